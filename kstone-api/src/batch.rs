@@ -1,8 +1,13 @@
 /// Batch operations for DynamoDB-style BatchGetItem and BatchWriteItem
 ///
 /// Provides APIs for getting or writing multiple items in a single operation.
+///
+/// A batch write is not atomic: each write is applied on its own, in order.
+/// Conditional puts are checked one by one as they are reached, and a put
+/// whose condition is false is skipped and reported in
+/// `BatchWriteResponse::rejected` without affecting the other writes.
 
-use kstone_core::{Item, Key};
+use kstone_core::{expression::ExpressionContext, Item, Key, Result};
 use bytes::Bytes;
 use std::collections::HashMap;
 
@@ -64,11 +69,29 @@ impl BatchGetResponse {
     }
 }
 
+/// Read each key on its own, in order, with `read`
+///
+/// A failed read is recorded in its key's entry and the other keys are
+/// still read.
+pub(crate) fn get_each(
+    keys: &[Key],
+    mut read: impl FnMut(&Key) -> Result<Option<Item>>,
+) -> Vec<Result<Option<Item>>> {
+    keys.iter().map(|key| read(key)).collect()
+}
+
 /// Batch write request item
 #[derive(Debug, Clone)]
 pub enum BatchWriteItem {
     /// Put an item
     Put { key: Key, item: Item },
+    /// Put an item only if `condition` holds for the stored one
+    PutConditional {
+        key: Key,
+        item: Item,
+        condition: String,
+        context: ExpressionContext,
+    },
     /// Delete an item
     Delete { key: Key },
 }
@@ -103,6 +126,49 @@ impl BatchWriteRequest {
         self
     }
 
+    /// Add a conditional put with partition key
+    ///
+    /// The put is skipped, and its index reported as rejected, if the
+    /// condition is false; the rest of the batch is still written.
+    pub fn put_conditional(
+        mut self,
+        pk: &[u8],
+        item: Item,
+        condition: &str,
+        context: ExpressionContext,
+    ) -> Self {
+        let key = Key::new(Bytes::copy_from_slice(pk));
+        self.items.push(BatchWriteItem::PutConditional {
+            key,
+            item,
+            condition: condition.to_string(),
+            context,
+        });
+        self
+    }
+
+    /// Add a conditional put with partition key and sort key
+    pub fn put_conditional_with_sk(
+        mut self,
+        pk: &[u8],
+        sk: &[u8],
+        item: Item,
+        condition: &str,
+        context: ExpressionContext,
+    ) -> Self {
+        let key = Key::with_sk(
+            Bytes::copy_from_slice(pk),
+            Bytes::copy_from_slice(sk),
+        );
+        self.items.push(BatchWriteItem::PutConditional {
+            key,
+            item,
+            condition: condition.to_string(),
+            context,
+        });
+        self
+    }
+
     /// Add a delete request with partition key
     pub fn delete(mut self, pk: &[u8]) -> Self {
         let key = Key::new(Bytes::copy_from_slice(pk));
@@ -139,13 +205,17 @@ pub struct BatchWriteResponse {
     pub processed_count: usize,
     /// Items that failed to write
     pub unprocessed_items: Vec<BatchWriteItem>,
+    /// Indices into the request of conditional puts whose condition was
+    /// false; those items were not written
+    pub rejected: Vec<usize>,
 }
 
 impl BatchWriteResponse {
-    pub(crate) fn new(processed_count: usize) -> Self {
+    pub(crate) fn new(processed_count: usize, rejected: Vec<usize>) -> Self {
         Self {
             processed_count,
             unprocessed_items: Vec::new(),
+            rejected,
         }
     }
 }
@@ -176,4 +246,27 @@ mod tests {
 
         assert_eq!(request.items().len(), 3);
     }
+
+    #[test]
+    fn test_get_each_keeps_going_past_a_failed_read() {
+        let request = BatchGetRequest::new()
+            .add_key(b"user#1")
+            .add_key(b"user#2")
+            .add_key(b"user#3");
+        let mut item = HashMap::new();
+        item.insert("name".to_string(), Value::string("Alice"));
+
+        let results = get_each(request.keys(), |key| {
+            if key.pk.as_ref() == b"user#2" {
+                Err(kstone_core::Error::ChecksumMismatch)
+            } else {
+                Ok(Some(item.clone()))
+            }
+        });
+
+        assert_eq!(results.len(), 3);
+        assert_eq!(results[0].as_ref().unwrap(), &Some(item.clone()));
+        assert!(matches!(results[1], Err(kstone_core::Error::ChecksumMismatch)));
+        assert_eq!(results[2].as_ref().unwrap(), &Some(item));
+    }
 }
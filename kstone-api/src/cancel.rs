@@ -0,0 +1,104 @@
+/// Cancellable reads and writes
+///
+/// A request handler that gives up on a request (the caller went away, its
+/// deadline passed) can hand a `CancelToken` to the database calls made on
+/// its behalf. A call made with a cancelled token fails with `Canceled`
+/// before touching the engine. Once started, a call runs to completion:
+/// the engine has no point at which it could stop halfway, and a write
+/// that has been applied is reported as applied.
+
+use crate::Database;
+use kstone_core::{Error, Item, Result};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+/// Cancels the calls it is passed to, by hand or at a deadline
+///
+/// Clones share their state, so cancelling one cancels all of them.
+#[derive(Debug, Clone, Default)]
+pub struct CancelToken {
+    cancelled: Arc<AtomicBool>,
+    deadline: Option<Instant>,
+}
+
+impl CancelToken {
+    /// A token that is cancelled only by `cancel`
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// A token that is also cancelled once `deadline` passes
+    pub fn with_deadline(deadline: Instant) -> Self {
+        Self {
+            cancelled: Arc::new(AtomicBool::new(false)),
+            deadline: Some(deadline),
+        }
+    }
+
+    /// A token that is also cancelled `timeout` from now
+    pub fn with_timeout(timeout: Duration) -> Self {
+        Self::with_deadline(Instant::now() + timeout)
+    }
+
+    /// Cancel this token and its clones
+    pub fn cancel(&self) {
+        self.cancelled.store(true, Ordering::SeqCst);
+    }
+
+    /// Whether the token was cancelled or its deadline has passed
+    pub fn is_cancelled(&self) -> bool {
+        self.check().is_err()
+    }
+
+    /// Fail with `Canceled` if the token was cancelled or its deadline has passed
+    pub fn check(&self) -> Result<()> {
+        if self.cancelled.load(Ordering::SeqCst) {
+            return Err(Error::Canceled("canceled by caller".to_string()));
+        }
+        if self.deadline.map_or(false, |deadline| Instant::now() >= deadline) {
+            return Err(Error::Canceled("deadline exceeded".to_string()));
+        }
+        Ok(())
+    }
+}
+
+impl Database {
+    /// Get an item unless `cancel` has been cancelled
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{CancelToken, Database};
+    /// # use std::time::Duration;
+    /// # fn example(db: &Database) -> Result<(), Box<dyn std::error::Error>> {
+    /// let cancel = CancelToken::with_timeout(Duration::from_millis(50));
+    /// let item = db.get_cancellable(&cancel, b"user#1", None)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn get_cancellable(&self, cancel: &CancelToken, pk: &[u8], sk: Option<&[u8]>) -> Result<Option<Item>> {
+        cancel.check()?;
+        match sk {
+            Some(sk) => self.get_with_sk(pk, sk),
+            None => self.get(pk),
+        }
+    }
+
+    /// Put an item unless `cancel` has been cancelled
+    pub fn put_cancellable(&self, cancel: &CancelToken, pk: &[u8], sk: Option<&[u8]>, item: Item) -> Result<()> {
+        cancel.check()?;
+        match sk {
+            Some(sk) => self.put_with_sk(pk, sk, item),
+            None => self.put(pk, item),
+        }
+    }
+
+    /// Delete an item unless `cancel` has been cancelled
+    pub fn delete_cancellable(&self, cancel: &CancelToken, pk: &[u8], sk: Option<&[u8]>) -> Result<()> {
+        cancel.check()?;
+        match sk {
+            Some(sk) => self.delete_with_sk(pk, sk),
+            None => self.delete(pk),
+        }
+    }
+}
@@ -0,0 +1,32 @@
+/// Dry-run results for write operations
+///
+/// A dry run validates a write (item size, condition) against the current
+/// state of the database and reports what would happen, without persisting
+/// anything.
+
+use kstone_core::Item;
+
+/// Outcome of a dry-run write
+#[derive(Debug, Clone, PartialEq)]
+pub struct DryRunResponse {
+    /// Whether the condition (if any) would pass
+    pub condition_passed: bool,
+    /// Item as it would exist after the write (None if it would be deleted
+    /// or does not exist). When the condition fails this is the current item.
+    pub item: Option<Item>,
+}
+
+impl DryRunResponse {
+    pub(crate) fn new(condition_passed: bool, item: Option<Item>) -> Self {
+        Self { condition_passed, item }
+    }
+}
+
+/// Outcome of a dry-run transaction
+#[derive(Debug, Clone, PartialEq)]
+pub struct TransactWriteDryRunResponse {
+    /// Whether the whole transaction would commit
+    pub would_commit: bool,
+    /// Per-operation results, in request order
+    pub results: Vec<DryRunResponse>,
+}
@@ -0,0 +1,136 @@
+/// Filter expressions and result selection for queries and scans
+///
+/// Filters are applied after items are read: `scanned_count` still counts
+/// every item examined, while `count` and `sum` reflect only matching items.
+
+use kstone_core::{
+    aggregate,
+    expression::{ExpressionContext, ExpressionEvaluator, ExpressionParser},
+    Item, Result, Value,
+};
+
+/// What a query or scan returns
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Select {
+    /// Return matching items (default)
+    #[default]
+    AllAttributes,
+    /// Return only the number of matching items
+    Count,
+}
+
+/// Filter expression, its bound names/values, the select mode and the
+/// attribute to sum
+#[derive(Debug, Clone, Default)]
+pub(crate) struct ReadFilter {
+    pub(crate) expression: Option<String>,
+    pub(crate) context: ExpressionContext,
+    pub(crate) select: Select,
+    pub(crate) sum: Option<String>,
+}
+
+/// Items left after filtering, with their count and requested sum
+pub(crate) struct Filtered {
+    pub(crate) items: Vec<Item>,
+    pub(crate) count: usize,
+    pub(crate) sum: Option<Value>,
+}
+
+impl ReadFilter {
+    pub(crate) fn set_expression(&mut self, expression: impl Into<String>) {
+        self.expression = Some(expression.into());
+    }
+
+    pub(crate) fn add_value(&mut self, placeholder: impl Into<String>, value: Value) {
+        self.context.values.insert(placeholder.into(), value);
+    }
+
+    pub(crate) fn add_name(&mut self, placeholder: impl Into<String>, name: impl Into<String>) {
+        self.context.names.insert(placeholder.into(), name.into());
+    }
+
+    /// Whether `item` passes the filter expression (true if there is none)
+    pub(crate) fn matches(&self, item: &Item) -> Result<bool> {
+        match &self.expression {
+            Some(expression) => {
+                let expr = ExpressionParser::parse(expression)?;
+                ExpressionEvaluator::new(item, &self.context).evaluate(&expr)
+            }
+            None => Ok(true),
+        }
+    }
+
+    /// Keep matching items; returns the items to send back, their count
+    /// and the sum of the requested attribute over them
+    ///
+    /// With `Select::Count` no items are returned, only the aggregates.
+    pub(crate) fn apply(&self, mut items: Vec<Item>) -> Result<Filtered> {
+        if let Some(expression) = &self.expression {
+            let expr = ExpressionParser::parse(expression)?;
+            let mut kept = Vec::with_capacity(items.len());
+            for item in items {
+                if ExpressionEvaluator::new(&item, &self.context).evaluate(&expr)? {
+                    kept.push(item);
+                }
+            }
+            items = kept;
+        }
+
+        let count = items.len();
+        let sum = self.sum.as_deref().map(|attribute| aggregate::sum(&items, attribute));
+        if self.select == Select::Count {
+            items = Vec::new();
+        }
+        Ok(Filtered { items, count, sum })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    fn item(status: &str) -> Item {
+        let mut item = HashMap::new();
+        item.insert("status".to_string(), Value::string(status));
+        item
+    }
+
+    #[test]
+    fn test_filter_and_count() {
+        let mut filter = ReadFilter::default();
+        filter.set_expression("#s = :active");
+        filter.add_name("#s", "status");
+        filter.add_value(":active", Value::string("active"));
+
+        let items = vec![item("active"), item("closed"), item("active")];
+        let filtered = filter.apply(items.clone()).unwrap();
+        assert_eq!(filtered.items.len(), 2);
+        assert_eq!(filtered.count, 2);
+        assert_eq!(filtered.sum, None);
+
+        filter.select = Select::Count;
+        let filtered = filter.apply(items).unwrap();
+        assert!(filtered.items.is_empty());
+        assert_eq!(filtered.count, 2);
+    }
+
+    #[test]
+    fn test_sum_covers_only_matching_items() {
+        let mut filter = ReadFilter::default();
+        filter.set_expression("#s = :active");
+        filter.add_name("#s", "status");
+        filter.add_value(":active", Value::string("active"));
+        filter.select = Select::Count;
+        filter.sum = Some("amount".to_string());
+
+        let mut items = vec![item("active"), item("closed"), item("active"), item("active")];
+        items[0].insert("amount".to_string(), Value::number(5));
+        items[1].insert("amount".to_string(), Value::number(100));
+        items[2].insert("amount".to_string(), Value::number(1.5));
+        let filtered = filter.apply(items).unwrap();
+        assert!(filtered.items.is_empty());
+        assert_eq!(filtered.count, 3);
+        assert_eq!(filtered.sum, Some(Value::number(6.5)));
+    }
+}
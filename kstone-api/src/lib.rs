@@ -8,15 +8,33 @@ pub use kstone_core::{
     Value as KeystoneValue,
     index::{LocalSecondaryIndex, GlobalSecondaryIndex, IndexProjection, TableSchema},
     stream::{StreamRecord, StreamEventType, StreamViewType, StreamConfig},
-    compaction::CompactionStats,
+    compaction::{CompactionConfig, CompactionStats, CompactionStyle},
     DatabaseConfig,
+    EngineEvent,
+    item_size,
+    value_equal,
+    item_diff,
+    DiffKind,
+    AttributeSchema, AttributeType, ValueConstraint,
+    TransactWriteOutcome, CancellationReason,
+    WalTail, WalTailEvent,
+    ChangeEvent, ChangeKind, Subscription,
+    ExportReader, ExportRecord, ExportManifest,
+    PartitionStat,
+    IntegrityReport, IntegrityProblem,
+    RepairOptions, RepairReport,
+    EvictionPolicy, MemoryLimit,
+    ConsumedCapacity,
 };
 
 pub mod query;
 pub use query::{Query, QueryResponse};
 
 pub mod scan;
-pub use scan::{Scan, ScanResponse};
+pub use scan::{KeyIterator, PartitionIterator, Scan, ScanResponse};
+
+pub mod filter;
+pub use filter::Select;
 
 pub mod update;
 pub use update::{Update, UpdateResponse};
@@ -28,7 +46,28 @@ pub mod transaction;
 pub use transaction::{TransactGetRequest, TransactGetResponse, TransactWriteRequest, TransactWriteResponse, TransactWriteOp};
 
 pub mod partiql;
-pub use partiql::{ExecuteStatementRequest, ExecuteStatementResponse};
+pub use partiql::{ExecuteStatementRequest, ExecuteStatementResponse, PlanOperation, PreparedStatement, QueryPlan};
+
+pub mod dry_run;
+pub use dry_run::{DryRunResponse, TransactWriteDryRunResponse};
+
+pub mod lock;
+pub use lock::Lock;
+
+pub mod snapshot;
+pub use snapshot::Snapshot;
+
+pub mod txn;
+pub use txn::Txn;
+
+pub mod live;
+pub use live::LiveQuery;
+
+pub mod session;
+pub use session::Session;
+
+pub mod cancel;
+pub use cancel::CancelToken;
 
 /// Storage engine type
 enum DatabaseEngine {
@@ -58,6 +97,17 @@ pub struct DatabaseStats {
     pub compaction: CompactionStats,
 }
 
+/// Options an open database is running with (see `Database::config`)
+#[derive(Debug, Clone)]
+pub struct EffectiveConfig {
+    /// Configuration in effect; `compaction` is always set, to the policy
+    /// stored with the database unless the opener replaced it
+    pub config: DatabaseConfig,
+
+    /// On-disk format version of the database
+    pub format_version: u32,
+}
+
 /// Database health status
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 pub enum HealthStatus {
@@ -69,6 +119,15 @@ pub enum HealthStatus {
     Unhealthy,
 }
 
+/// What `Database::partition_sizes` ranks partitions by
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PartitionRank {
+    /// Number of items
+    ItemCount,
+    /// Total item size in bytes
+    Bytes,
+}
+
 /// Database health information
 #[derive(Debug, Clone)]
 pub struct DatabaseHealth {
@@ -81,6 +140,10 @@ pub struct DatabaseHealth {
 }
 
 /// KeystoneDB Database handle
+///
+/// The engine logs WAL replay, memtable flushes and compactions through
+/// `tracing`; install a subscriber (e.g. `tracing-subscriber`) to route
+/// them into the application's logging.
 pub struct Database {
     engine: DatabaseEngine,
 }
@@ -102,6 +165,32 @@ impl Database {
         Ok(Self { engine: DatabaseEngine::Disk(engine) })
     }
 
+    /// Create a database at `dest` with the contents of `src` as of sequence number `seq`
+    ///
+    /// Replays the WAL of `src` up to and including `seq`, which undoes
+    /// everything written after it (e.g. a bad bulk write). Record the
+    /// point to return to with `last_seq`. `src` is left untouched. The
+    /// new database has fresh sequence numbers and no table schema. Fails
+    /// with `KeystoneError::SeqTruncated` if `seq` is older than the WAL,
+    /// or if `src` was bulk loaded (bulk loads bypass the WAL).
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::Database;
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let checkpoint = db.last_seq()?;
+    /// // ... a bad bulk write ...
+    /// drop(db);
+    /// let restored = Database::recover_to_seq("/tmp/mydb", "/tmp/mydb-restored", checkpoint)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn recover_to_seq(src: impl AsRef<Path>, dest: impl AsRef<Path>, seq: u64) -> Result<Self> {
+        let engine = LsmEngine::recover_to_seq(src, dest, seq)?;
+        Ok(Self { engine: DatabaseEngine::Disk(engine) })
+    }
+
     /// Create a new database with a table schema (Phase 3.1+)
     pub fn create_with_schema(path: impl AsRef<Path>, schema: TableSchema) -> Result<Self> {
         let engine = LsmEngine::create_with_schema(path, schema)?;
@@ -133,6 +222,15 @@ impl Database {
         Ok(Self { engine: DatabaseEngine::Disk(engine) })
     }
 
+    /// Open an existing database with custom configuration
+    ///
+    /// Only runtime settings such as `max_item_size_bytes` take effect on
+    /// an existing database.
+    pub fn open_with_config(path: impl AsRef<Path>, config: DatabaseConfig) -> Result<Self> {
+        let engine = LsmEngine::open_with_config(path, config)?;
+        Ok(Self { engine: DatabaseEngine::Disk(engine) })
+    }
+
     /// Create a new in-memory database (Phase 5+)
     ///
     /// All data is stored in memory and lost when the database is dropped.
@@ -143,11 +241,36 @@ impl Database {
     }
 
     /// Create a new in-memory database with a table schema (Phase 5+)
+    ///
+    /// In-memory databases don't maintain secondary indexes, so a schema
+    /// that declares any is rejected with `KeystoneError::InvalidArgument`.
     pub fn create_in_memory_with_schema(schema: TableSchema) -> Result<Self> {
         let engine = MemoryLsmEngine::create_with_schema(schema)?;
         Ok(Self { engine: DatabaseEngine::Memory(engine) })
     }
 
+    /// Create a new in-memory database whose items take at most `max_bytes`
+    ///
+    /// Items count their encoded key plus `item_size`. Once a write would
+    /// go over the limit, `EvictionPolicy::Reject` fails it with
+    /// `KeystoneError::MemoryLimit`, and `EvictionPolicy::EvictLru` evicts
+    /// the items least recently read or written until it fits, which suits
+    /// using the database as a cache. Only the newest version of each item
+    /// is kept, and deletes free their item's memory right away.
+    ///
+    /// # Example
+    /// ```
+    /// # use kstone_api::{Database, EvictionPolicy};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let cache = Database::create_in_memory_with_limit(64 * 1024 * 1024, EvictionPolicy::EvictLru)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn create_in_memory_with_limit(max_bytes: u64, policy: EvictionPolicy) -> Result<Self> {
+        let engine = MemoryLsmEngine::create_with_limit(MemoryLimit { max_bytes, policy })?;
+        Ok(Self { engine: DatabaseEngine::Memory(engine) })
+    }
+
     /// Put an item with a simple partition key
     pub fn put(&self, pk: &[u8], item: Item) -> Result<()> {
         let key = Key::new(Bytes::copy_from_slice(pk));
@@ -226,6 +349,293 @@ impl Database {
         }
     }
 
+    /// Insert items whose keys do not exist yet, skipping the rest
+    ///
+    /// For idempotent seeding ("import new records only"): all inserts are
+    /// applied in one atomic batch. Returns one flag per item, in order,
+    /// true if it was inserted and false if its key already existed. Not
+    /// supported for in-memory databases.
+    pub fn insert_many(&self, items: &[(Key, Item)]) -> Result<Vec<bool>> {
+        self.disk_engine()?.insert_many(items)
+    }
+
+    /// Begin an interactive transaction
+    ///
+    /// Reads go to the database, writes are buffered until `commit`, which
+    /// fails with `KeystoneError::TransactionConflict` if an item the
+    /// transaction read was written in the meantime. Not supported for
+    /// in-memory databases.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, KeystoneValue};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    ///
+    /// let mut txn = db.begin()?;
+    /// let mut account = txn.get(b"account#1")?.unwrap_or_default();
+    /// account.insert("status".to_string(), KeystoneValue::string("closed"));
+    /// txn.put(b"account#1", account);
+    /// txn.commit()?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn begin(&self) -> Result<Txn<'_>> {
+        Txn::begin(self)
+    }
+
+    /// Take a consistent read view of the database as it is now
+    ///
+    /// Reads through the snapshot ignore everything written afterwards.
+    /// Not supported for in-memory databases.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, Scan};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let snapshot = db.snapshot()?;
+    /// let export = snapshot.scan(Scan::new())?;
+    /// snapshot.release();
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn snapshot(&self) -> Result<Snapshot> {
+        Ok(Snapshot::new(self.disk_engine()?.snapshot()))
+    }
+
+    /// Open a session whose reads observe every write made through it
+    ///
+    /// See [`Session`] for the guarantee.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, ItemBuilder};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let session = db.session();
+    /// session.put(b"user#1", ItemBuilder::new().string("name", "Alice").build())?;
+    /// assert!(session.get(b"user#1")?.is_some());
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn session(&self) -> Session<'_> {
+        Session::new(self)
+    }
+
+    /// Get several items, returned in the order of `keys`
+    ///
+    /// Repeated keys are fetched once; each occurrence gets its own copy of
+    /// the item. Missing items are `None`.
+    pub fn get_many(&self, keys: &[Key]) -> Result<Vec<Option<Item>>> {
+        let mut seen = std::collections::HashSet::new();
+        let unique: Vec<Key> = keys.iter().filter(|k| seen.insert(*k)).cloned().collect();
+
+        let found = match &self.engine {
+            DatabaseEngine::Disk(e) => e.batch_get(&unique)?,
+            DatabaseEngine::Memory(e) => e.batch_get(&unique)?,
+        };
+
+        Ok(keys
+            .iter()
+            .map(|key| found.get(key).cloned().flatten())
+            .collect())
+    }
+
+    /// All items under a partition key, keyed by sort key
+    ///
+    /// Convenient when callers look items up by sort key anyway (e.g.
+    /// `org#acme` -> `user#alice`, `user#bob`). An item stored without a
+    /// sort key is keyed by an empty `Bytes`. An absent partition gives an
+    /// empty map.
+    pub fn get_partition_map(&self, pk: &[u8]) -> Result<std::collections::HashMap<Bytes, Item>> {
+        let pk = Bytes::copy_from_slice(pk);
+        let items = match &self.engine {
+            DatabaseEngine::Disk(e) => e.partition_items(&pk)?,
+            DatabaseEngine::Memory(e) => e.partition_items(&pk)?,
+        };
+
+        Ok(items
+            .into_iter()
+            .map(|(key, item)| (key.sk.unwrap_or_default(), item))
+            .collect())
+    }
+
+    /// Content digest of the items under a partition key
+    ///
+    /// A SHA-256 Merkle root over the partition's items in sort key order
+    /// (see `kstone_core::digest`). Partitions with the same items have the
+    /// same digest regardless of write order, and any changed attribute
+    /// changes it, so two instances can compare digests before transferring
+    /// a partition.
+    pub fn partition_digest(&self, pk: &[u8]) -> Result<Vec<u8>> {
+        let pk = Bytes::copy_from_slice(pk);
+        let items = match &self.engine {
+            DatabaseEngine::Disk(e) => e.partition_items(&pk)?,
+            DatabaseEngine::Memory(e) => e.partition_items(&pk)?,
+        };
+        Ok(kstone_core::digest::partition_digest(items.iter().map(|(key, item)| (key, item))))
+    }
+
+    /// Number of items under a partition key
+    ///
+    /// Counted in the engine without reading the items back, e.g. for
+    /// "showing 10 of 347" alongside a paginated query.
+    pub fn count_partition(&self, pk: &[u8]) -> Result<u64> {
+        let pk = Bytes::copy_from_slice(pk);
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.count_partition(&pk),
+            DatabaseEngine::Memory(e) => e.count_partition(&pk),
+        }
+    }
+
+    /// The `limit` largest partitions, biggest first
+    ///
+    /// Sizes are tallied from the keys held by the engine without copying
+    /// any item, to find hot or skewed partitions. Ties are broken by
+    /// partition key.
+    pub fn partition_sizes(&self, limit: usize, rank: PartitionRank) -> Result<Vec<PartitionStat>> {
+        let mut stats = match &self.engine {
+            DatabaseEngine::Disk(e) => e.partition_sizes()?,
+            DatabaseEngine::Memory(e) => e.partition_sizes()?,
+        };
+        // Stable sort keeps the engine's partition key order among ties
+        stats.sort_by(|a, b| match rank {
+            PartitionRank::ItemCount => b.items.cmp(&a.items),
+            PartitionRank::Bytes => b.bytes.cmp(&a.bytes),
+        });
+        stats.truncate(limit);
+        Ok(stats)
+    }
+
+    /// Delete the items in partition `pk` whose sort key starts with
+    /// `sk_prefix` and compact them away (disk databases only)
+    ///
+    /// Unlike deleting the items one by one, no tombstones are left behind
+    /// to slow down later scans of the remaining data. An empty prefix
+    /// purges the whole partition. Returns the number of items deleted.
+    pub fn purge_prefix(&self, pk: &[u8], sk_prefix: &[u8]) -> Result<u64> {
+        self.disk_engine()?.purge_prefix(&Bytes::copy_from_slice(pk), sk_prefix)
+    }
+
+    /// Delete every item a query matches, returning the number deleted
+    ///
+    /// The sort key condition and filter expression select the items; the
+    /// limit and start key are ignored, so all matches are deleted. Each
+    /// delete is conditional on the item still existing and still matching
+    /// the filter, so an item changed or deleted by someone else after it
+    /// was read is left alone and not counted. Deletes are applied in
+    /// batches, each atomically, so a failure part way leaves the earlier
+    /// batches deleted. Index queries are not supported.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, KeystoneValue, Query};
+    /// # fn example(db: &Database) -> Result<(), Box<dyn std::error::Error>> {
+    /// let query = Query::new(b"user#123")
+    ///     .sk_begins_with(b"session#")
+    ///     .filter("#s = :expired")
+    ///     .name("#s", "status")
+    ///     .value(":expired", KeystoneValue::string("expired"));
+    /// let deleted = db.delete_by_query(query)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn delete_by_query(&self, query: Query) -> Result<u64> {
+        /// Deletes applied per batch
+        const BATCH_SIZE: usize = 100;
+
+        if query.index_name().is_some() {
+            return Err(kstone_core::Error::InvalidArgument(
+                "Deleting by an index query is not supported".to_string(),
+            ));
+        }
+        let filter = query.read_filter();
+        let params = query.into_params();
+        let items = match &self.engine {
+            DatabaseEngine::Disk(e) => e.partition_items(&params.pk)?,
+            DatabaseEngine::Memory(e) => e.partition_items(&params.pk)?,
+        };
+
+        let mut matches = Vec::new();
+        for (key, item) in items {
+            if params.matches_sk(&key.sk) && filter.matches(&item)? {
+                matches.push((key, item));
+            }
+        }
+
+        let mut deleted = 0;
+        for batch in matches.chunks(BATCH_SIZE) {
+            deleted += self.delete_matching(batch, &filter)?;
+        }
+        Ok(deleted)
+    }
+
+    /// Delete items read by `delete_by_query` that still exist and still
+    /// match `filter`, returning the number deleted
+    ///
+    /// The batch is written as one transaction with a condition per item;
+    /// items whose condition fails are dropped and the rest retried.
+    fn delete_matching(&self, batch: &[(Key, Item)], filter: &filter::ReadFilter) -> Result<u64> {
+        use kstone_core::{TransactWriteOperation, expression::ExpressionParser};
+
+        // Existence is checked through an attribute the item had when read
+        let mut context = filter.context.clone();
+        let mut operations = Vec::with_capacity(batch.len());
+        for (i, (key, item)) in batch.iter().enumerate() {
+            let mut clauses = Vec::new();
+            if let Some(attribute) = item.keys().next() {
+                let placeholder = format!("#__delete_by_query_{}", i);
+                context.names.insert(placeholder.clone(), attribute.clone());
+                clauses.push(format!("attribute_exists({})", placeholder));
+            }
+            if let Some(expression) = &filter.expression {
+                clauses.push(format!("({})", expression));
+            }
+            let condition = if clauses.is_empty() {
+                None
+            } else {
+                Some(ExpressionParser::parse(&clauses.join(" AND "))?)
+            };
+            operations.push((key.clone(), TransactWriteOperation::Delete { condition }));
+        }
+
+        while !operations.is_empty() {
+            let outcome = match &self.engine {
+                DatabaseEngine::Disk(e) => e.try_transact_write(&operations, &context)?,
+                DatabaseEngine::Memory(e) => e.try_transact_write(&operations, &context)?,
+            };
+            match outcome {
+                TransactWriteOutcome::Committed(committed) => return Ok(committed as u64),
+                TransactWriteOutcome::Canceled(reasons) => {
+                    let mut reasons = reasons.into_iter();
+                    operations.retain(|_| reasons.next().map_or(true, |r| r.is_none()));
+                }
+            }
+        }
+        Ok(0)
+    }
+
+    /// Check whether an item exists, without reading it back
+    ///
+    /// Cheaper than `get` for large items since nothing is copied.
+    pub fn exists(&self, pk: &[u8]) -> Result<bool> {
+        let key = Key::new(Bytes::copy_from_slice(pk));
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.exists(&key),
+            DatabaseEngine::Memory(e) => e.exists(&key),
+        }
+    }
+
+    /// Check whether an item with partition key and sort key exists
+    pub fn exists_with_sk(&self, pk: &[u8], sk: &[u8]) -> Result<bool> {
+        let key = Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk));
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.exists(&key),
+            DatabaseEngine::Memory(e) => e.exists(&key),
+        }
+    }
+
     /// Delete an item by partition key
     pub fn delete(&self, pk: &[u8]) -> Result<()> {
         let key = Key::new(Bytes::copy_from_slice(pk));
@@ -287,15 +697,92 @@ impl Database {
         }
     }
 
+    /// Maximum item size in bytes accepted by this database
+    ///
+    /// Items are measured with `item_size`; larger writes are rejected.
+    pub fn item_size_limit(&self) -> usize {
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.max_item_size_bytes(),
+            DatabaseEngine::Memory(_) => kstone_core::config::DEFAULT_MAX_ITEM_SIZE_BYTES,
+        }
+    }
+
+    /// Set the attribute schema enforced on writes
+    ///
+    /// Puts and transactional puts whose items violate the schema (missing
+    /// required attribute, wrong type, failed constraint) are rejected with
+    /// `KeystoneError::SchemaValidation`. Replaces any previous schema; an
+    /// empty list disables validation.
+    pub fn set_schema(&self, attributes: Vec<AttributeSchema>) -> Result<()> {
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.set_attribute_schemas(attributes),
+            DatabaseEngine::Memory(e) => e.set_attribute_schemas(attributes),
+        }
+    }
+
     /// Scan with keys - returns (Key, Item) pairs for sync
     pub fn scan_with_keys(&self, limit: usize) -> Result<Vec<(Key, Item)>> {
         match &self.engine {
             DatabaseEngine::Disk(e) => e.scan_with_keys(limit),
-            DatabaseEngine::Memory(_) => {
-                // Memory engine doesn't support scan_with_keys yet
-                Ok(Vec::new())
+            DatabaseEngine::Memory(e) => e.scan_with_keys(limit),
+        }
+    }
+
+    /// Write every item to `writer` in the portable export format
+    ///
+    /// The export is newline-delimited JSON that keeps each value's type,
+    /// and can be loaded into a server with the client's `import_from`.
+    /// Returns the number of items written.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::Database;
+    /// let db = Database::open("local.keystone")?;
+    /// let file = std::fs::File::create("local.export")?;
+    /// let count = db.export_to(std::io::BufWriter::new(file))?;
+    /// println!("exported {} items", count);
+    /// # Ok::<(), Box<dyn std::error::Error>>(())
+    /// ```
+    pub fn export_to<W: std::io::Write>(&self, writer: W) -> Result<u64> {
+        let records = self.scan_with_keys(usize::MAX)?;
+        kstone_core::export::write_export(writer, records)
+    }
+
+    /// Up to `limit` items with their keys, in key order, for exports
+    ///
+    /// Covers keys from `start` (inclusive) to `end` (exclusive), where
+    /// given, and after `start_after` when resuming. Items are read one by
+    /// one, so a page is not a point-in-time view; an item deleted while
+    /// the page is read is left out.
+    pub fn export_page(
+        &self,
+        start: Option<Key>,
+        end: Option<Key>,
+        start_after: Option<Key>,
+        limit: usize,
+    ) -> Result<Vec<(Key, Item)>> {
+        let mut params = kstone_core::iterator::ScanParams::new()
+            .with_range(start, end)
+            .with_limit(limit);
+        if let Some(key) = start_after {
+            params = params.with_start_key(key);
+        }
+        let keys = match &self.engine {
+            DatabaseEngine::Disk(e) => e.scan_keys(&params)?,
+            DatabaseEngine::Memory(e) => e.scan_keys(&params)?,
+        };
+
+        let mut page = Vec::with_capacity(keys.len());
+        for key in keys {
+            let item = match &self.engine {
+                DatabaseEngine::Disk(e) => e.get(&key)?,
+                DatabaseEngine::Memory(e) => e.get(&key)?,
+            };
+            if let Some(item) = item {
+                page.push((key, item));
             }
         }
+        Ok(page)
     }
 
     /// Flush any pending writes
@@ -306,24 +793,442 @@ impl Database {
         }
     }
 
+    /// Seed a new database by writing items straight to SST files
+    ///
+    /// Much faster than puts for large initial loads, since nothing goes
+    /// through the WAL; all files are synced before it returns. There is
+    /// no crash safety while loading: if it is interrupted, delete the
+    /// database and start over. Fails with `InvalidArgument` once the
+    /// database has regular writes, or if it has indexes or a stream. Not
+    /// supported for in-memory databases. Returns the number of items
+    /// loaded. The load leaves no history in the WAL: `recover_to_seq`
+    /// refuses the database afterwards, and history reads reaching into
+    /// the load fail with `SeqTruncated`.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, ItemBuilder};
+    /// # use kstone_core::Key;
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::create("/tmp/seeded")?;
+    /// let items = (0..1_000_000).map(|i| {
+    ///     let key = Key::new(format!("user#{}", i).into_bytes().into());
+    ///     (key, ItemBuilder::new().number("id", i).build())
+    /// });
+    /// db.bulk_load(items)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn bulk_load(&self, items: impl IntoIterator<Item = (Key, Item)>) -> Result<u64> {
+        self.disk_engine()?.bulk_load(items)
+    }
+
     /// Query items within a partition (Phase 2.1+)
     pub fn query(&self, query: Query) -> Result<QueryResponse> {
+        let fetch_full_items = query.fetches_full_items();
+        let return_consumed_capacity = query.returns_consumed_capacity();
+        let filter = query.read_filter();
         let params = query.into_params();
-        let result = match &self.engine {
+        let mut result = match &self.engine {
             DatabaseEngine::Disk(e) => e.query(params)?,
             DatabaseEngine::Memory(e) => e.query(params)?,
         };
-        Ok(QueryResponse::from_result(result))
+
+        if fetch_full_items && !result.base_keys.is_empty() {
+            let index_items = std::mem::take(&mut result.items);
+            let base_keys = std::mem::take(&mut result.base_keys);
+            result.items = self.fetch_base_items(index_items, base_keys)?;
+        }
+
+        let consumed_capacity = return_consumed_capacity.then(|| ConsumedCapacity::read(&result.items));
+        let filtered = filter.apply(std::mem::take(&mut result.items))?;
+        let mut response = QueryResponse::from_result(result);
+        response.items = filtered.items;
+        response.count = filtered.count;
+        response.sum = filtered.sum;
+        response.consumed_capacity = consumed_capacity;
+        Ok(response)
+    }
+
+    /// Run a query and keep following the partition for new matching items
+    ///
+    /// The result holds the items the query matches now (subject to its
+    /// limit) and then yields each matching item as it is written; see
+    /// `LiveQuery`. Index queries are not supported. Disk databases only.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, Query};
+    /// # use std::time::Duration;
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let mut live = db.query_live(Query::new(b"log#app").sk_begins_with(b"event#"))?;
+    /// for item in live.initial_items() {
+    ///     println!("{:?}", item);
+    /// }
+    /// while let Some(item) = live.next_timeout(Duration::from_secs(30))? {
+    ///     println!("new: {:?}", item);
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn query_live(&self, query: Query) -> Result<LiveQuery> {
+        if query.index_name().is_some() {
+            return Err(kstone_core::Error::InvalidArgument(
+                "Live queries on an index are not supported".to_string(),
+            ));
+        }
+        // Follow from before the query runs, so no write is missed
+        let tail = self.tail_wal(self.last_seq()? + 1)?;
+        let initial = self.query(query.clone())?.items;
+        Ok(LiveQuery::new(query, initial, tail))
+    }
+
+    /// Replace index query results with their base-table items
+    ///
+    /// Looks up all distinct base keys in one batch and keeps index order.
+    fn fetch_base_items(&self, index_items: Vec<Item>, base_keys: Vec<Option<Key>>) -> Result<Vec<Item>> {
+        let mut unique: Vec<Key> = base_keys.iter().flatten().cloned().collect();
+        unique.sort();
+        unique.dedup();
+
+        let base_items = match &self.engine {
+            DatabaseEngine::Disk(e) => e.batch_get(&unique)?,
+            DatabaseEngine::Memory(e) => e.batch_get(&unique)?,
+        };
+
+        let mut items = Vec::with_capacity(index_items.len());
+        for (index_item, base_key) in index_items.into_iter().zip(base_keys) {
+            match base_key {
+                // Several index entries may point at the same base item
+                Some(key) => {
+                    if let Some(Some(item)) = base_items.get(&key) {
+                        items.push(item.clone());
+                    }
+                }
+                None => items.push(index_item),
+            }
+        }
+
+        Ok(items)
     }
 
     /// Scan all items in the table (Phase 2.2+)
+    ///
+    /// Without segments, items come back in key order: by partition key,
+    /// then sort key, comparing bytes (an item without a sort key sorts
+    /// before the same partition's items with one). A segment is in key
+    /// order within itself.
     pub fn scan(&self, scan: Scan) -> Result<ScanResponse> {
+        let return_consumed_capacity = scan.returns_consumed_capacity();
+        let filter = scan.read_filter();
         let params = scan.into_params();
-        let result = match &self.engine {
+        let mut result = match &self.engine {
             DatabaseEngine::Disk(e) => e.scan(params)?,
             DatabaseEngine::Memory(e) => e.scan(params)?,
         };
-        Ok(ScanResponse::from_result(result))
+
+        let consumed_capacity = return_consumed_capacity.then(|| ConsumedCapacity::read(&result.items));
+        let filtered = filter.apply(std::mem::take(&mut result.items))?;
+        let mut response = ScanResponse::from_result(result);
+        response.items = filtered.items;
+        response.count = filtered.count;
+        response.sum = filtered.sum;
+        response.consumed_capacity = consumed_capacity;
+        Ok(response)
+    }
+
+    /// Scan with a Rust predicate as the filter
+    ///
+    /// For filters that are awkward to write as an expression. Items are
+    /// read a page at a time and only those for which `pred` returns true
+    /// are kept, so unlike `Scan::filter`, the scan's `limit` bounds the
+    /// matches returned rather than the items examined. `last_key` is set
+    /// only when the limit stopped the scan early, as the key to resume
+    /// from. `scan` supplies the limit, pagination and segment; combine any
+    /// filter expression into `pred` instead, as `Scan::filter` is rejected.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, Scan};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let long_names = db.scan_filter(Scan::new().limit(10), |item| {
+    ///     item.get("name").and_then(|v| v.as_string()).map_or(false, |s| s.len() > 20)
+    /// })?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn scan_filter<F>(&self, scan: Scan, mut pred: F) -> Result<ScanResponse>
+    where
+        F: FnMut(&Item) -> bool,
+    {
+        /// Items read per page when the scan has no limit
+        const PAGE_SIZE: usize = 1000;
+
+        if scan.has_filter() {
+            return Err(kstone_core::Error::InvalidArgument(
+                "scan_filter takes its filter as a predicate, not an expression".to_string(),
+            ));
+        }
+        let return_consumed_capacity = scan.returns_consumed_capacity();
+        let mut params = scan.into_params();
+        let max_matches = params.limit;
+        let mut items = Vec::new();
+        let mut scanned_count = 0;
+        let mut read_units = 0;
+        let mut last_key = None;
+
+        loop {
+            // With a limit, never read more than the matches still wanted,
+            // so every page can be kept whole and its last key resumed from
+            let page_size = match max_matches {
+                Some(max) => max - items.len(),
+                None => PAGE_SIZE,
+            };
+            if page_size == 0 {
+                break;
+            }
+            params.limit = Some(page_size);
+            let page = match &self.engine {
+                DatabaseEngine::Disk(e) => e.scan(params.clone())?,
+                DatabaseEngine::Memory(e) => e.scan(params.clone())?,
+            };
+
+            let read = page.items.len();
+            scanned_count += page.scanned_count;
+            if return_consumed_capacity {
+                read_units += ConsumedCapacity::read(&page.items).read_units;
+            }
+            items.extend(page.items.into_iter().filter(|item| pred(item)));
+
+            let Some(page_last) = page.last_key else { break };
+            if read < page_size {
+                break;
+            }
+            if max_matches.map_or(false, |max| items.len() >= max) {
+                last_key = Some((page_last.pk.clone(), page_last.sk.clone()));
+                break;
+            }
+            params.start_key = Some(page_last);
+        }
+
+        Ok(ScanResponse {
+            count: items.len(),
+            items,
+            last_key,
+            scanned_count,
+            sum: None,
+            consumed_capacity: return_consumed_capacity.then(|| ConsumedCapacity {
+                read_units,
+                write_units: 0,
+            }),
+        })
+    }
+
+    /// Scan and hand each item to `f` on a pool of `workers` threads
+    ///
+    /// For CPU-heavy per-item work (parsing, transformation) over a whole
+    /// table: the calling thread reads the scan a page at a time while the
+    /// workers process the items already read. Items reach `f` in no
+    /// particular order, and concurrently on different threads.
+    ///
+    /// The first error returned by `f` stops the scan: items not yet handed
+    /// out are skipped, and that error is returned once the workers have
+    /// finished the items they were processing. `scan` supplies the filter,
+    /// limit, pagination and segment.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, Scan};
+    /// # use std::sync::atomic::{AtomicU64, Ordering};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let bytes = AtomicU64::new(0);
+    /// db.scan_workers(Scan::new(), 8, |item| {
+    ///     bytes.fetch_add(kstone_api::item_size(&item) as u64, Ordering::Relaxed);
+    ///     Ok(())
+    /// })?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn scan_workers<F>(&self, scan: Scan, workers: usize, f: F) -> Result<()>
+    where
+        F: Fn(Item) -> Result<()> + Sync,
+    {
+        use std::sync::atomic::{AtomicBool, Ordering};
+        use std::sync::{mpsc, Mutex};
+
+        /// Items read per page
+        const PAGE_SIZE: usize = 1000;
+
+        if workers == 0 {
+            return Err(kstone_core::Error::InvalidArgument(
+                "scan_workers needs at least one worker".to_string(),
+            ));
+        }
+
+        let filter = scan.read_filter();
+        let mut params = scan.into_params();
+        let stop = AtomicBool::new(false);
+        let failure = Mutex::new(None);
+        let (tx, rx) = mpsc::sync_channel::<Item>(workers * 2);
+        let rx = Mutex::new(rx);
+
+        let scanned = std::thread::scope(|s| {
+            for _ in 0..workers {
+                s.spawn(|| loop {
+                    let Ok(item) = rx.lock().unwrap().recv() else { return };
+                    // Once stopped, keep draining so the reader is never
+                    // left blocked on a full channel
+                    if stop.load(Ordering::Acquire) {
+                        continue;
+                    }
+                    if let Err(e) = f(item) {
+                        failure.lock().unwrap().get_or_insert(e);
+                        stop.store(true, Ordering::Release);
+                    }
+                });
+            }
+
+            let mut remaining = params.limit;
+            let result = 'pages: loop {
+                let page_size = remaining.map_or(PAGE_SIZE, |r| r.min(PAGE_SIZE));
+                if page_size == 0 || stop.load(Ordering::Acquire) {
+                    break Ok(());
+                }
+                params.limit = Some(page_size);
+                let page = match &self.engine {
+                    DatabaseEngine::Disk(e) => e.scan(params.clone()),
+                    DatabaseEngine::Memory(e) => e.scan(params.clone()),
+                };
+                let page = match page {
+                    Ok(page) => page,
+                    Err(e) => break Err(e),
+                };
+
+                let read = page.items.len();
+                if let Some(r) = remaining.as_mut() {
+                    *r -= read;
+                }
+                for item in page.items {
+                    match filter.matches(&item) {
+                        Ok(true) => {}
+                        Ok(false) => continue,
+                        Err(e) => break 'pages Err(e),
+                    }
+                    if stop.load(Ordering::Acquire) || tx.send(item).is_err() {
+                        break;
+                    }
+                }
+
+                let Some(page_last) = page.last_key else { break Ok(()) };
+                if read < page_size {
+                    break Ok(());
+                }
+                params.start_key = Some(page_last);
+            };
+            if result.is_err() {
+                stop.store(true, Ordering::Release);
+            }
+            // Closing the channel lets the workers finish
+            drop(tx);
+            result
+        });
+
+        match failure.into_inner().unwrap() {
+            Some(e) => Err(e),
+            None => scanned,
+        }
+    }
+
+    /// Iterate the keys a scan would return, without reading their values
+    ///
+    /// Much cheaper than `scan` for building key indexes or diffing two
+    /// databases, since no item is copied. `scan` supplies the limit,
+    /// pagination and segment; filters need the values and are rejected.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, Scan};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// for key in db.keys_only(Scan::new())? {
+    ///     println!("{:?} {:?}", key.pk, key.sk);
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn keys_only(&self, scan: Scan) -> Result<KeyIterator> {
+        if scan.has_filter() {
+            return Err(kstone_core::Error::InvalidArgument(
+                "Key-only scans cannot filter on values".to_string(),
+            ));
+        }
+        let params = scan.into_params();
+        let keys = match &self.engine {
+            DatabaseEngine::Disk(e) => e.scan_keys(&params)?,
+            DatabaseEngine::Memory(e) => e.scan_keys(&params)?,
+        };
+        Ok(KeyIterator::new(keys))
+    }
+
+    /// Iterate over the distinct partition keys, in order
+    ///
+    /// Each partition holding at least one live item is yielded once.
+    /// Only keys are compared, so this is much cheaper than a scan for
+    /// per-partition work such as per-tenant backups or digests.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::Database;
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// for pk in db.partitions()? {
+    ///     println!("{} items in {:?}", db.count_partition(&pk)?, pk);
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn partitions(&self) -> Result<PartitionIterator> {
+        let partitions = match &self.engine {
+            DatabaseEngine::Disk(e) => e.partition_keys()?,
+            DatabaseEngine::Memory(e) => e.partition_keys()?,
+        };
+        Ok(PartitionIterator::new(partitions))
+    }
+
+    /// Scan the keys from `start` (inclusive) to `end` (exclusive) in key order
+    ///
+    /// `scan` supplies the limit, filter and pagination; segments are not
+    /// allowed. Keys are ordered as in `scan`.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, Scan};
+    /// # use kstone_core::Key;
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let response = db.range_scan(
+    ///     Key::new(b"order#2024-01".to_vec().into()),
+    ///     Key::new(b"order#2024-02".to_vec().into()),
+    ///     Scan::new().limit(100),
+    /// )?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn range_scan(&self, start: Key, end: Key, scan: Scan) -> Result<ScanResponse> {
+        if scan.is_segmented() {
+            return Err(kstone_core::Error::InvalidArgument(
+                "Range scans cannot be segmented".to_string(),
+            ));
+        }
+        if end < start {
+            return Err(kstone_core::Error::InvalidArgument(
+                "Range end must not be before its start".to_string(),
+            ));
+        }
+        self.scan(scan.key_range(start, end))
     }
 
     /// Update an item using update expression (Phase 2.4+)
@@ -349,60 +1254,534 @@ impl Database {
         Ok(UpdateResponse::new(updated_item))
     }
 
-    /// Batch get multiple items (Phase 2.6+)
-    pub fn batch_get(&self, request: BatchGetRequest) -> Result<BatchGetResponse> {
-        let results = match &self.engine {
-            DatabaseEngine::Disk(e) => e.batch_get(request.keys())?,
-            DatabaseEngine::Memory(e) => e.batch_get(request.keys())?,
-        };
-
-        let mut items = std::collections::HashMap::new();
-        for (key, item_opt) in results {
-            if let Some(item) = item_opt {
-                items.insert(key, item);
-            }
+    /// Apply an update expression only if a condition holds
+    ///
+    /// Shorthand for [`Database::update`] with an `Update` carrying both
+    /// expressions. The condition is checked against the current item under
+    /// the engine's write lock, so several attributes can be moved through a
+    /// state transition atomically. Fails with `ConditionalCheckFailed` and
+    /// leaves the item unchanged if the condition is false.
+    pub fn update_conditional(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        update_expression: &str,
+        condition: &str,
+        values: HashMap<String, Value>,
+    ) -> Result<Item> {
+        let mut update = match sk {
+            Some(sk) => Update::with_sk(pk, sk),
+            None => Update::new(pk),
         }
-
-        Ok(BatchGetResponse::new(items))
+        .expression(update_expression)
+        .condition(condition);
+        for (placeholder, value) in values {
+            update = update.value(placeholder, value);
+        }
+        Ok(self.update(update)?.item)
     }
 
-    /// Batch write multiple items (Phase 2.6+)
-    pub fn batch_write(&self, request: BatchWriteRequest) -> Result<BatchWriteResponse> {
-        // Convert batch write request to operations
-        let mut operations = Vec::new();
-
-        for item in request.items() {
-            match item {
-                BatchWriteItem::Put { key, item } => {
-                    operations.push((key.clone(), Some(item.clone())));
-                }
-                BatchWriteItem::Delete { key } => {
-                    operations.push((key.clone(), None));
-                }
-            }
+    /// Merge a partial item into the stored one, creating it if needed
+    ///
+    /// Attributes in `patch` are set and all others are left as they are;
+    /// a `Null` value removes the attribute instead. The merge runs as one
+    /// update under the engine's write lock and returns the merged item.
+    pub fn merge(&self, pk: &[u8], sk: Option<&[u8]>, patch: HashMap<String, Value>) -> Result<Item> {
+        use kstone_core::expression::{ExpressionContext, UpdateAction, UpdateValue};
+
+        let key = match sk {
+            Some(sk) => Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            None => Key::new(Bytes::copy_from_slice(pk)),
+        };
+        let actions: Vec<UpdateAction> = patch
+            .into_iter()
+            .map(|(name, value)| match value {
+                Value::Null => UpdateAction::Remove(name),
+                value => UpdateAction::Set(name, UpdateValue::Value(value)),
+            })
+            .collect();
+
+        let context = ExpressionContext::new();
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.update(&key, &actions, &context),
+            DatabaseEngine::Memory(e) => e.update(&key, &actions, &context),
         }
+    }
 
-        let processed = match &self.engine {
-            DatabaseEngine::Disk(e) => e.batch_write(&operations)?,
-            DatabaseEngine::Memory(e) => e.batch_write(&operations)?,
+    /// Append `values` to a list attribute, creating the item and the list
+    /// if needed
+    ///
+    /// Runs as `SET attr = list_append(if_not_exists(attr, []), values)`
+    /// under the engine's write lock, so concurrent appends never lose each
+    /// other's values, e.g. for keeping an item's event history. Fails with
+    /// `InvalidExpression` if the attribute holds something other than a
+    /// list. Returns the updated item.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, KeystoneValue};
+    /// # fn example(db: &Database) -> Result<(), Box<dyn std::error::Error>> {
+    /// db.append_to_list(b"order#42", None, "history", vec![KeystoneValue::string("shipped")])?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn append_to_list(&self, pk: &[u8], sk: Option<&[u8]>, attribute: &str, values: Vec<Value>) -> Result<Item> {
+        use kstone_core::expression::{ExpressionContext, UpdateAction, UpdateValue};
+
+        let key = match sk {
+            Some(sk) => Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            None => Key::new(Bytes::copy_from_slice(pk)),
         };
-        Ok(BatchWriteResponse::new(processed))
-    }
+        let existing = UpdateValue::IfNotExists(
+            attribute.to_string(),
+            Box::new(UpdateValue::Value(Value::L(Vec::new()))),
+        );
+        let appended = UpdateValue::ListAppend(Box::new(existing), Box::new(UpdateValue::Value(Value::L(values))));
+        let actions = [UpdateAction::Set(attribute.to_string(), appended)];
 
-    /// Transactional get - read multiple items atomically (Phase 2.7+)
+        let context = ExpressionContext::new();
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.update(&key, &actions, &context),
+            DatabaseEngine::Memory(e) => e.update(&key, &actions, &context),
+        }
+    }
+
+    /// Set one attribute to a number, creating the item if needed
+    ///
+    /// The value is stored with the number type (`N`), so it compares
+    /// numerically in filters, conditions and PartiQL, unlike a string such
+    /// as `"30"`. Other attributes of the item are kept.
+    pub fn put_number(&self, pk: &[u8], sk: Option<&[u8]>, attribute: &str, n: f64) -> Result<()> {
+        if !n.is_finite() {
+            return Err(kstone_core::Error::InvalidArgument(format!(
+                "Cannot store {} in number attribute '{}'",
+                n, attribute
+            )));
+        }
+        self.set_attribute(pk, sk, attribute, Value::number(n))
+    }
+
+    /// Set one attribute to a boolean, creating the item if needed
+    ///
+    /// Other attributes of the item are kept.
+    pub fn put_bool(&self, pk: &[u8], sk: Option<&[u8]>, attribute: &str, b: bool) -> Result<()> {
+        self.set_attribute(pk, sk, attribute, Value::Bool(b))
+    }
+
+    /// Set one attribute that disappears at `expires_at`, creating the item
+    /// if needed
+    ///
+    /// Reads leave the attribute out from then on, while the item and its
+    /// other attributes stay, e.g. for a cached field that is recomputed
+    /// once it expires. Setting the attribute again replaces the expiry;
+    /// a value set without this method doesn't expire. Update expressions
+    /// can't do arithmetic on an expiring attribute (`SET n = n + 1` fails).
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, KeystoneValue};
+    /// # use std::time::{Duration, SystemTime};
+    /// # fn example(db: &Database) -> Result<(), Box<dyn std::error::Error>> {
+    /// let refresh_at = SystemTime::now() + Duration::from_secs(300);
+    /// db.put_attribute_with_ttl(b"user#1", None, "score", KeystoneValue::number(42), refresh_at)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn put_attribute_with_ttl(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        attribute: &str,
+        value: Value,
+        expires_at: std::time::SystemTime,
+    ) -> Result<()> {
+        self.set_attribute(pk, sk, attribute, kstone_core::attribute_ttl::expiring(value, expires_at))
+    }
+
+    /// Read a number attribute
+    ///
+    /// Returns None if the item or attribute does not exist, and fails with
+    /// `InvalidArgument` if the attribute holds another type.
+    pub fn get_number(&self, pk: &[u8], sk: Option<&[u8]>, attribute: &str) -> Result<Option<f64>> {
+        match self.get_attribute(pk, sk, attribute)? {
+            None => Ok(None),
+            Some(value) => value.as_number().map(Some).ok_or_else(|| {
+                kstone_core::Error::InvalidArgument(format!("Attribute '{}' is not a number: {:?}", attribute, value))
+            }),
+        }
+    }
+
+    /// Read a boolean attribute
+    ///
+    /// Returns None if the item or attribute does not exist, and fails with
+    /// `InvalidArgument` if the attribute holds another type.
+    pub fn get_bool(&self, pk: &[u8], sk: Option<&[u8]>, attribute: &str) -> Result<Option<bool>> {
+        match self.get_attribute(pk, sk, attribute)? {
+            None => Ok(None),
+            Some(value) => value.as_bool().map(Some).ok_or_else(|| {
+                kstone_core::Error::InvalidArgument(format!("Attribute '{}' is not a boolean: {:?}", attribute, value))
+            }),
+        }
+    }
+
+    /// Set one attribute to a string set, creating the item if needed
+    ///
+    /// Duplicate members are stored once. Sets cannot be empty, so an empty
+    /// `members` fails with `InvalidArgument`. Other attributes of the item
+    /// are kept; use an `ADD` or `DELETE` update to change membership.
+    pub fn put_string_set<S: Into<String>>(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        attribute: &str,
+        members: impl IntoIterator<Item = S>,
+    ) -> Result<()> {
+        let set = Value::string_set(members);
+        if set.as_string_set().map_or(true, |set| set.is_empty()) {
+            return Err(kstone_core::Error::InvalidArgument(format!(
+                "String set attribute '{}' cannot be empty",
+                attribute
+            )));
+        }
+        self.set_attribute(pk, sk, attribute, set)
+    }
+
+    /// Read a string set attribute
+    ///
+    /// Returns None if the item or attribute does not exist, and fails with
+    /// `InvalidArgument` if the attribute holds another type.
+    pub fn get_string_set(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        attribute: &str,
+    ) -> Result<Option<std::collections::BTreeSet<String>>> {
+        match self.get_attribute(pk, sk, attribute)? {
+            None => Ok(None),
+            Some(Value::SS(set)) => Ok(Some(set)),
+            Some(value) => Err(kstone_core::Error::InvalidArgument(format!(
+                "Attribute '{}' is not a string set: {:?}",
+                attribute, value
+            ))),
+        }
+    }
+
+    fn set_attribute(&self, pk: &[u8], sk: Option<&[u8]>, attribute: &str, value: Value) -> Result<()> {
+        let update = match sk {
+            Some(sk) => Update::with_sk(pk, sk),
+            None => Update::new(pk),
+        };
+        self.update(update.expression("SET #attr = :value").name("#attr", attribute).value(":value", value))?;
+        Ok(())
+    }
+
+    fn get_attribute(&self, pk: &[u8], sk: Option<&[u8]>, attribute: &str) -> Result<Option<Value>> {
+        let item = match sk {
+            Some(sk) => self.get_with_sk(pk, sk)?,
+            None => self.get(pk)?,
+        };
+        Ok(item.and_then(|mut item| item.remove(attribute)))
+    }
+
+    /// Atomically set one attribute if it still has the expected value
+    ///
+    /// `expected: None` means the attribute must not exist (the item is
+    /// created if missing). Returns whether the swap happened; the read,
+    /// comparison and write run under the engine's write lock, so concurrent
+    /// swaps from the same value have exactly one winner.
+    pub fn compare_and_swap(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        attribute: &str,
+        expected: Option<Value>,
+        new: Value,
+    ) -> Result<bool> {
+        use kstone_core::expression::{ExpressionContext, ExpressionParser, UpdateAction, UpdateValue};
+        use kstone_core::TransactWriteOperation;
+
+        let key = match sk {
+            Some(sk) => Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            None => Key::new(Bytes::copy_from_slice(pk)),
+        };
+
+        let mut context = ExpressionContext::new()
+            .with_name("#attr", attribute)
+            .with_value(":new", new);
+        let condition = match expected {
+            Some(value) => {
+                context = context.with_value(":expected", value);
+                ExpressionParser::parse("#attr = :expected")?
+            }
+            None => ExpressionParser::parse("attribute_not_exists(#attr)")?,
+        };
+
+        let operation = TransactWriteOperation::Update {
+            actions: vec![UpdateAction::Set(
+                "#attr".to_string(),
+                UpdateValue::Placeholder(":new".to_string()),
+            )],
+            condition: Some(condition),
+        };
+
+        self.write_if(key, operation, &context)
+    }
+
+    /// Put an item if its stored version attribute has the expected value
+    ///
+    /// For optimistic-retry loops: returns the stored item and whether this
+    /// put stored it. When the versions don't match, nothing is written and
+    /// the current item (None if there is none) is returned, so the caller
+    /// can retry against it without another read. `expected: None` means
+    /// the item must not have the attribute. The check and write are atomic;
+    /// the current item is read after a failed check, so it may be newer
+    /// than the one that failed it.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, KeystoneValue};
+    /// # use std::collections::HashMap;
+    /// # fn example(db: &Database) -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut item = HashMap::new();
+    /// item.insert("version".to_string(), KeystoneValue::number(1));
+    /// let (current, stored) = db.put_if_version(b"doc#1", None, item, "version", None)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn put_if_version(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        item: Item,
+        version_attribute: &str,
+        expected: Option<Value>,
+    ) -> Result<(Option<Item>, bool)> {
+        use kstone_core::expression::{ExpressionContext, ExpressionParser};
+        use kstone_core::TransactWriteOperation;
+
+        let key = match sk {
+            Some(sk) => Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            None => Key::new(Bytes::copy_from_slice(pk)),
+        };
+
+        let mut context = ExpressionContext::new().with_name("#version", version_attribute);
+        let condition = match expected {
+            Some(value) => {
+                context = context.with_value(":expected", value);
+                ExpressionParser::parse("#version = :expected")?
+            }
+            None => ExpressionParser::parse("attribute_not_exists(#version)")?,
+        };
+
+        let operation = TransactWriteOperation::Put {
+            item: item.clone(),
+            condition: Some(condition),
+        };
+        if self.write_if(key, operation, &context)? {
+            return Ok((Some(item), true));
+        }
+
+        let current = match sk {
+            Some(sk) => self.get_with_sk(pk, sk)?,
+            None => self.get(pk)?,
+        };
+        Ok((current, false))
+    }
+
+    /// Return the item at a key, creating it with `create` if absent
+    ///
+    /// Returns the item and whether this call created it. `create` runs only
+    /// when the item is missing, and the item is stored only if nothing
+    /// else created it first: of concurrent callers, exactly one creates
+    /// it and the rest return that item. A caller that loses the race may
+    /// still have run `create`, so it should not have side effects. Not
+    /// supported for in-memory databases.
+    pub fn get_or_create<F: FnOnce() -> Item>(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        create: F,
+    ) -> Result<(Item, bool)> {
+        let mut create = Some(create);
+        let mut new_item: Option<Item> = None;
+        loop {
+            let mut txn = self.begin()?;
+            let existing = match sk {
+                Some(sk) => txn.get_with_sk(pk, sk)?,
+                None => txn.get(pk)?,
+            };
+            if let Some(item) = existing {
+                return Ok((item, false));
+            }
+
+            // Made once, even if the item has to be created again after a
+            // conflicting create was deleted
+            let item = new_item
+                .get_or_insert_with(|| (create.take().expect("create runs once"))())
+                .clone();
+            match sk {
+                Some(sk) => txn.put_with_sk(pk, sk, item.clone()),
+                None => txn.put(pk, item.clone()),
+            }
+            match txn.commit() {
+                Ok(()) => return Ok((item, true)),
+                Err(kstone_core::Error::TransactionConflict(_)) => continue,
+                Err(e) => return Err(e),
+            }
+        }
+    }
+
+    /// Acquire a named lock for `ttl`
+    ///
+    /// The lock is stored as an item under `lock::LOCK_KEY_PREFIX` + name.
+    /// An expired lock is taken over with a higher fencing token; an
+    /// unexpired one fails with `ConditionalCheckFailed`.
+    pub fn acquire_lock(&self, name: &str, ttl: std::time::Duration) -> Result<Lock<'_>> {
+        Lock::acquire(self, name, ttl)
+    }
+
+    /// Apply one conditional write atomically, returning whether it applied
+    ///
+    /// A single-operation transaction gives read-compare-write atomicity.
+    pub(crate) fn write_if(
+        &self,
+        key: Key,
+        operation: kstone_core::TransactWriteOperation,
+        context: &kstone_core::expression::ExpressionContext,
+    ) -> Result<bool> {
+        let outcome = match &self.engine {
+            DatabaseEngine::Disk(e) => e.try_transact_write(&[(key, operation)], context)?,
+            DatabaseEngine::Memory(e) => e.try_transact_write(&[(key, operation)], context)?,
+        };
+
+        Ok(matches!(outcome, TransactWriteOutcome::Committed(_)))
+    }
+
+    /// Batch get multiple items (Phase 2.6+)
+    pub fn batch_get(&self, request: BatchGetRequest) -> Result<BatchGetResponse> {
+        let results = match &self.engine {
+            DatabaseEngine::Disk(e) => e.batch_get(request.keys())?,
+            DatabaseEngine::Memory(e) => e.batch_get(request.keys())?,
+        };
+
+        let mut items = std::collections::HashMap::new();
+        for (key, item_opt) in results {
+            if let Some(item) = item_opt {
+                items.insert(key, item);
+            }
+        }
+
+        Ok(BatchGetResponse::new(items))
+    }
+
+    /// Batch get that reports failures per key
+    ///
+    /// Returns one entry per requested key, in request order: the item,
+    /// None if there is none, or the error reading it. Unlike `batch_get`,
+    /// an unreadable item fails only its own entry, so one bad record
+    /// doesn't sink a large fan-out read.
+    pub fn batch_get_partial(&self, request: BatchGetRequest) -> Vec<Result<Option<Item>>> {
+        batch::get_each(request.keys(), |key| match &self.engine {
+            DatabaseEngine::Disk(e) => e.get(key),
+            DatabaseEngine::Memory(e) => e.get(key),
+        })
+    }
+
+    /// Batch write multiple items (Phase 2.6+)
+    ///
+    /// Not atomic: writes are applied in order, and a conditional put whose
+    /// condition is false is skipped and listed in `rejected` while the
+    /// rest of the batch is still written.
+    pub fn batch_write(&self, request: BatchWriteRequest) -> Result<BatchWriteResponse> {
+        // Unconditional writes are collected and applied together, up to
+        // each conditional put, so the order of the request is kept
+        let mut operations = Vec::new();
+        let mut processed = 0;
+        let mut rejected = Vec::new();
+
+        for (index, item) in request.items().iter().enumerate() {
+            match item {
+                BatchWriteItem::Put { key, item } => {
+                    operations.push((key.clone(), Some(item.clone())));
+                }
+                BatchWriteItem::Delete { key } => {
+                    operations.push((key.clone(), None));
+                }
+                BatchWriteItem::PutConditional { key, item, condition, context } => {
+                    processed += self.apply_batch(&std::mem::take(&mut operations))?;
+                    let expr = kstone_core::expression::ExpressionParser::parse(condition)?;
+                    let result = match &self.engine {
+                        DatabaseEngine::Disk(e) => e.put_conditional(key.clone(), item.clone(), &expr, context),
+                        DatabaseEngine::Memory(e) => e.put_conditional(key.clone(), item.clone(), &expr, context),
+                    };
+                    match result {
+                        Ok(()) => processed += 1,
+                        Err(KeystoneError::ConditionalCheckFailed(_)) => rejected.push(index),
+                        Err(e) => return Err(e),
+                    }
+                }
+            }
+        }
+
+        processed += self.apply_batch(&operations)?;
+        Ok(BatchWriteResponse::new(processed, rejected))
+    }
+
+    fn apply_batch(&self, operations: &[(Key, Option<Item>)]) -> Result<usize> {
+        if operations.is_empty() {
+            return Ok(0);
+        }
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.batch_write(operations),
+            DatabaseEngine::Memory(e) => e.batch_write(operations),
+        }
+    }
+
+    /// Transactional get - read multiple items atomically (Phase 2.7+)
     pub fn transact_get(&self, request: TransactGetRequest) -> Result<TransactGetResponse> {
+        let checks = request
+            .conditions
+            .iter()
+            .map(|(key, condition)| Ok((key.clone(), kstone_core::expression::ExpressionParser::parse(condition)?)))
+            .collect::<Result<Vec<_>>>()?;
         let items = match &self.engine {
-            DatabaseEngine::Disk(e) => e.transact_get(request.keys())?,
-            DatabaseEngine::Memory(e) => e.transact_get(request.keys())?,
+            DatabaseEngine::Disk(e) => e.transact_get_checked(request.keys(), &checks, &request.context)?,
+            DatabaseEngine::Memory(e) => e.transact_get_checked(request.keys(), &checks, &request.context)?,
         };
         Ok(TransactGetResponse::new(items))
     }
 
     /// Transactional write - write multiple items atomically with conditions (Phase 2.7+)
     pub fn transact_write(&self, request: TransactWriteRequest) -> Result<TransactWriteResponse> {
+        let operations = Self::transact_operations(&request)?;
+
+        let committed = match &self.engine {
+            DatabaseEngine::Disk(e) => e.transact_write(&operations, request.context())?,
+            DatabaseEngine::Memory(e) => e.transact_write(&operations, request.context())?,
+        };
+        Ok(TransactWriteResponse::new(committed))
+    }
+
+    /// Execute a transaction, reporting why it was canceled
+    ///
+    /// Unlike `transact_write`, a failed condition is not an error: the
+    /// outcome is `Canceled` with one `CancellationReason` per operation (in
+    /// request order), `"None"` for the operations that passed.
+    pub fn try_transact_write(&self, request: TransactWriteRequest) -> Result<TransactWriteOutcome> {
+        let operations = Self::transact_operations(&request)?;
+
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.try_transact_write(&operations, request.context()),
+            DatabaseEngine::Memory(e) => e.try_transact_write(&operations, request.context()),
+        }
+    }
+
+    /// Convert API transaction operations to core operations
+    fn transact_operations(
+        request: &TransactWriteRequest,
+    ) -> Result<Vec<(Key, kstone_core::TransactWriteOperation)>> {
         use kstone_core::{TransactWriteOperation, expression::ExpressionParser};
 
-        // Convert API operations to core operations
         let mut operations = Vec::new();
 
         for op in request.operations() {
@@ -461,11 +1840,137 @@ impl Database {
             }
         }
 
-        let committed = match &self.engine {
-            DatabaseEngine::Disk(e) => e.transact_write(&operations, request.context())?,
-            DatabaseEngine::Memory(e) => e.transact_write(&operations, request.context())?,
+        Ok(operations)
+    }
+
+    /// Get an item by key from whichever engine backs this database
+    pub(crate) fn get_key(&self, key: &Key) -> Result<Option<Item>> {
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.get(key),
+            DatabaseEngine::Memory(e) => e.get(key),
+        }
+    }
+
+    /// Validate a prospective put item the same way a real put would
+    fn check_item(&self, item: &Item) -> Result<()> {
+        self.check_item_size(item)?;
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.validate_item(item),
+            DatabaseEngine::Memory(e) => e.validate_item(item),
+        }
+    }
+
+    /// Reject an item over the size limit, as every write does
+    fn check_item_size(&self, item: &Item) -> Result<()> {
+        let size = item_size(item);
+        let limit = self.item_size_limit();
+        if size > limit {
+            return Err(KeystoneError::ItemTooLarge { size, limit });
+        }
+        Ok(())
+    }
+
+    /// Evaluate an optional condition against the current item
+    fn evaluate_condition(
+        current: &Option<Item>,
+        condition: Option<&kstone_core::expression::Expr>,
+        context: &kstone_core::expression::ExpressionContext,
+    ) -> Result<bool> {
+        match condition {
+            Some(expr) => {
+                let empty = Item::new();
+                let item = current.as_ref().unwrap_or(&empty);
+                kstone_core::expression::ExpressionEvaluator::new(item, context).evaluate(expr)
+            }
+            None => Ok(true),
+        }
+    }
+
+    /// Dry-run a put: validate and evaluate the condition without writing
+    ///
+    /// Returns whether the condition would pass and the resulting item.
+    pub fn put_dry_run(
+        &self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+        item: Item,
+        condition: Option<&str>,
+        context: kstone_core::expression::ExpressionContext,
+    ) -> Result<DryRunResponse> {
+        let key = match sk {
+            Some(sk) => Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            None => Key::new(Bytes::copy_from_slice(pk)),
         };
-        Ok(TransactWriteResponse::new(committed))
+        let condition = condition
+            .map(kstone_core::expression::ExpressionParser::parse)
+            .transpose()?;
+
+        self.check_item(&item)?;
+        let current = self.get_key(&key)?;
+        let passed = Self::evaluate_condition(&current, condition.as_ref(), &context)?;
+
+        Ok(DryRunResponse::new(passed, if passed { Some(item) } else { current }))
+    }
+
+    /// Dry-run an update: compute the post-image without writing
+    pub fn update_dry_run(&self, update: Update) -> Result<DryRunResponse> {
+        let key = update.key().clone();
+        let (actions, condition_expr, context) = update.into_actions()?;
+        let condition = condition_expr
+            .map(|c| kstone_core::expression::ExpressionParser::parse(&c))
+            .transpose()?;
+
+        let current = self.get_key(&key)?;
+        if !Self::evaluate_condition(&current, condition.as_ref(), &context)? {
+            return Ok(DryRunResponse::new(false, current));
+        }
+
+        let base = current.unwrap_or_default();
+        let updated = kstone_core::expression::UpdateExecutor::new(&context).execute(&base, &actions)?;
+        self.check_item_size(&updated)?;
+
+        Ok(DryRunResponse::new(true, Some(updated)))
+    }
+
+    /// Dry-run a transaction: evaluate every operation without writing
+    ///
+    /// Conditions are evaluated against the state before the transaction,
+    /// matching `transact_write`.
+    pub fn transact_write_dry_run(&self, request: TransactWriteRequest) -> Result<TransactWriteDryRunResponse> {
+        use kstone_core::TransactWriteOperation;
+
+        let operations = Self::transact_operations(&request)?;
+        let context = request.context();
+
+        let mut results = Vec::with_capacity(operations.len());
+        for (key, op) in &operations {
+            let current = self.get_key(key)?;
+            let passed = Self::evaluate_condition(&current, op.condition(), context)?;
+
+            let item = if !passed {
+                current
+            } else {
+                match op {
+                    TransactWriteOperation::Put { item, .. } => {
+                        self.check_item(item)?;
+                        Some(item.clone())
+                    }
+                    TransactWriteOperation::Update { actions, .. } => {
+                        let base = current.unwrap_or_default();
+                        let updated = kstone_core::expression::UpdateExecutor::new(context).execute(&base, actions)?;
+                        self.check_item_size(&updated)?;
+                        Some(updated)
+                    }
+                    TransactWriteOperation::Delete { .. } => None,
+                    TransactWriteOperation::ConditionCheck { .. } => current,
+                }
+            };
+
+            results.push(DryRunResponse::new(passed, item));
+        }
+
+        let would_commit = results.iter().all(|r| r.condition_passed);
+        Ok(TransactWriteDryRunResponse { would_commit, results })
     }
 
     /// Read stream records (Phase 3.4+)
@@ -476,6 +1981,221 @@ impl Database {
         self.disk_engine()?.read_stream(after_sequence_number)
     }
 
+    /// Follow committed writes from sequence number `from_seq`
+    ///
+    /// The returned tail yields each base-table put and delete, with its
+    /// sequence number, in commit order, and then waits for new writes. Use
+    /// it to drive replication, audit logs or derived stores. Writers never
+    /// wait for a slow tail; if the writes at `from_seq` have already been
+    /// dropped from the retained history, the tail first yields a `Gap`.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, WalTailEvent};
+    /// # use std::time::Duration;
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let mut tail = db.tail_wal(0)?;
+    /// while let Some(event) = tail.next_timeout(Duration::from_secs(1)) {
+    ///     if let WalTailEvent::Record(record) = event {
+    ///         println!("seq {} {:?}", record.seq, record.key);
+    ///     }
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn tail_wal(&self, from_seq: u64) -> Result<WalTail> {
+        Ok(self.disk_engine()?.tail_wal(from_seq))
+    }
+
+    /// Subscribe to changes to items whose partition key starts with
+    /// `prefix` (disk databases only)
+    ///
+    /// The subscription receives an insert, modify or remove event for
+    /// each matching write committed from now on. Up to `buffer` events
+    /// wait for the subscriber; writers never block on a slow one, and
+    /// events that don't fit are dropped and counted in
+    /// `Subscription::dropped`. Drop the subscription to close it.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{ChangeKind, Database};
+    /// # use std::time::Duration;
+    /// # fn example(db: &Database) -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut changes = db.subscribe(b"user#", 256)?;
+    /// while let Some(change) = changes.next_timeout(Duration::from_secs(1)) {
+    ///     if change.kind == ChangeKind::Remove {
+    ///         println!("removed {:?}", change.key);
+    ///     }
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn subscribe(&self, prefix: &[u8], buffer: usize) -> Result<Subscription> {
+        Ok(self.disk_engine()?.subscribe(prefix, buffer))
+    }
+
+    /// Read an item as it was at sequence number `seq` (disk databases only)
+    ///
+    /// Returns the item as last written at or before `seq`, or None if it
+    /// didn't exist then, e.g. to see what a record looked like before a bad
+    /// job ran. Record the point to read back to with `last_seq`. Old
+    /// versions come from the WAL, which this reads in full, so it suits
+    /// investigations rather than serving traffic. Fails with
+    /// `KeystoneError::SeqTruncated` if `seq` is older than the WAL.
+    pub fn get_as_of(&self, pk: &[u8], sk: Option<&[u8]>, seq: u64) -> Result<Option<Item>> {
+        let key = match sk {
+            Some(sk) => Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            None => Key::new(Bytes::copy_from_slice(pk)),
+        };
+        self.disk_engine()?.get_as_of(&key, seq)
+    }
+
+    /// Sequence number of the most recent write (0 if none)
+    ///
+    /// A recovery point for `recover_to_seq` and `get_as_of`.
+    pub fn last_seq(&self) -> Result<u64> {
+        Ok(self.disk_engine()?.last_seq())
+    }
+
+    /// Write the changes made after sequence number `since_seq` to `writer`
+    /// (disk databases only)
+    ///
+    /// Writes a diff holding the latest item of every key written after
+    /// `since_seq`, deletes included, and returns the sequence number it
+    /// covers; pass that as `since_seq` next time. With `since_seq` 0 the
+    /// diff holds every item and serves as a full backup. Load a backup by
+    /// applying the full diff and then each later one in order with
+    /// `apply_diff`. Changes are read back from the WAL; fails with
+    /// `KeystoneError::SeqTruncated` if it no longer reaches back to
+    /// `since_seq`, in which case take a new full backup.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::Database;
+    /// # fn example(db: &Database, last_backup_seq: u64) -> Result<(), Box<dyn std::error::Error>> {
+    /// let file = std::fs::File::create("backup.0001.diff")?;
+    /// let seq = db.snapshot_diff(last_backup_seq, std::io::BufWriter::new(file))?;
+    /// println!("backed up through seq {}", seq);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn snapshot_diff<W: std::io::Write>(&self, since_seq: u64, writer: W) -> Result<u64> {
+        let engine = self.disk_engine()?;
+        if since_seq == 0 {
+            // Items written while scanning are also in the next diff
+            let seq = engine.last_seq();
+            let items = engine.scan_with_keys(usize::MAX)?;
+            let changes = items.into_iter().map(|(key, item)| (key, Some(item)));
+            kstone_core::incremental::write_diff(writer, 0, seq, changes)?;
+            return Ok(seq);
+        }
+
+        let (seq, records) = engine.changes_since(since_seq)?;
+        let changes = records.into_iter().map(|record| (record.key, record.value));
+        kstone_core::incremental::write_diff(writer, since_seq, seq, changes)?;
+        Ok(seq)
+    }
+
+    /// Apply a diff written by `snapshot_diff`, returning the number of
+    /// changes applied
+    ///
+    /// Puts each item in the diff and deletes each key it marks deleted.
+    /// Diffs must be applied in the order they were taken, starting with a
+    /// full one.
+    pub fn apply_diff<R: std::io::Read>(&self, reader: R) -> Result<u64> {
+        let diff = kstone_core::DiffReader::new(std::io::BufReader::new(reader))?;
+        let mut count = 0;
+        for record in diff {
+            let record = record?;
+            let key = match record.sk {
+                Some(sk) => Key::with_sk(record.pk, sk),
+                None => Key::new(record.pk),
+            };
+            match (record.item, &self.engine) {
+                (Some(item), DatabaseEngine::Disk(e)) => e.put(key, item)?,
+                (Some(item), DatabaseEngine::Memory(e)) => e.put(key, item)?,
+                (None, DatabaseEngine::Disk(e)) => e.delete(key)?,
+                (None, DatabaseEngine::Memory(e)) => e.delete(key)?,
+            }
+            count += 1;
+        }
+        Ok(count)
+    }
+
+    /// Options the database is running with
+    ///
+    /// Settings stored with the database, such as the compaction policy,
+    /// may differ from what the opener passed; this reports what is
+    /// actually in effect.
+    pub fn config(&self) -> Result<EffectiveConfig> {
+        let engine = self.disk_engine()?;
+        Ok(EffectiveConfig {
+            config: engine.config(),
+            format_version: engine.format_version(),
+        })
+    }
+
+    /// Compaction policy in effect
+    ///
+    /// Set at create or open time with `DatabaseConfig::with_compaction`
+    /// and kept with the database across opens.
+    pub fn compaction_config(&self) -> Result<CompactionConfig> {
+        Ok(self.disk_engine()?.compaction_config())
+    }
+
+    /// Check the database's files for corruption
+    ///
+    /// Reads every SST file and the WAL back from disk and verifies their
+    /// checksums. Damaged files are listed in the report instead of failing
+    /// the call, so a periodic check can alert before data is lost. An
+    /// in-memory database has no files and always reports clean.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::Database;
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let db = Database::open("/tmp/mydb")?;
+    /// let report = db.verify()?;
+    /// for problem in &report.problems {
+    ///     eprintln!("{}: {}", problem.path.display(), problem.detail);
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn verify(&self) -> Result<IntegrityReport> {
+        match &self.engine {
+            DatabaseEngine::Disk(e) => e.verify(),
+            DatabaseEngine::Memory(_) => Ok(IntegrityReport::default()),
+        }
+    }
+
+    /// Copy what survives of a damaged database into a new one
+    ///
+    /// Reads the intact SST files and the readable part of the WAL at
+    /// `src`, keeps the newest version of each item, and writes them into a
+    /// new database at `dst`. The damaged database is left as it is. The
+    /// report counts the items recovered and lost and lists the damaged
+    /// files. Don't run it on a database that is open.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_api::{Database, RepairOptions};
+    /// # fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let report = Database::repair("/tmp/mydb", "/tmp/mydb-repaired", &RepairOptions::default())?;
+    /// println!("recovered {}, lost {}", report.items_recovered, report.items_lost);
+    /// let db = Database::open("/tmp/mydb-repaired")?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn repair(
+        src: impl AsRef<Path>,
+        dst: impl AsRef<Path>,
+        options: &RepairOptions,
+    ) -> Result<RepairReport> {
+        kstone_core::repair(src, dst, options)
+    }
+
     /// Get database statistics
     ///
     /// Returns comprehensive statistics about the database including
@@ -488,7 +2208,7 @@ impl Database {
                     total_sst_files: 0, // TODO: implement
                     wal_size_bytes: None,
                     memtable_size_bytes: None,
-                    total_disk_size_bytes: None,
+                    total_disk_size_bytes: Some(e.disk_size_bytes()?),
                     compaction: e.compaction_stats(),
                 })
             }
@@ -545,6 +2265,16 @@ impl ItemBuilder {
         self
     }
 
+    pub fn string_set<S: Into<String>>(mut self, key: impl Into<String>, members: impl IntoIterator<Item = S>) -> Self {
+        self.item.insert(key.into(), Value::string_set(members));
+        self
+    }
+
+    pub fn number_set<N: ToString>(mut self, key: impl Into<String>, members: impl IntoIterator<Item = N>) -> Self {
+        self.item.insert(key.into(), Value::number_set(members));
+        self
+    }
+
     pub fn build(self) -> Item {
         self.item
     }
@@ -1948,6 +3678,2026 @@ mod tests {
         assert_eq!(records[0].sequence_number, 6);
         assert_eq!(records[4].sequence_number, 10);
     }
+
+    #[test]
+    fn test_database_item_size_limit() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_max_item_size_bytes(64);
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+        assert_eq!(db.item_size_limit(), 64);
+
+        // "data" (4) + 60 bytes = exactly at the limit
+        let fits = ItemBuilder::new().string("data", "x".repeat(60)).build();
+        assert_eq!(item_size(&fits), 64);
+        db.put(b"small", fits).unwrap();
+
+        let too_big = ItemBuilder::new().string("data", "x".repeat(61)).build();
+        assert!(item_size(&too_big) > db.item_size_limit());
+        assert!(db.put(b"big", too_big).is_err());
+        assert!(db.get(b"big").unwrap().is_none());
+    }
+
+    #[test]
+    fn test_database_dry_run_does_not_persist() {
+        use kstone_core::expression::ExpressionContext;
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        db.put(b"doc#1", ItemBuilder::new().number("version", 1).build()).unwrap();
+
+        // Conditional put that would fail
+        let outcome = db.put_dry_run(
+            b"doc#1",
+            None,
+            ItemBuilder::new().number("version", 5).build(),
+            Some("version = :v"),
+            ExpressionContext::new().with_value(":v", Value::number(4)),
+        ).unwrap();
+        assert!(!outcome.condition_passed);
+        assert_eq!(outcome.item.unwrap().get("version").unwrap(), &Value::number(1));
+
+        // Update that would pass reports the post-image
+        let outcome = db.update_dry_run(
+            Update::new(b"doc#1")
+                .expression("SET version = version + :inc")
+                .value(":inc", Value::number(1)),
+        ).unwrap();
+        assert!(outcome.condition_passed);
+        assert_eq!(outcome.item.unwrap().get("version").unwrap(), &Value::number(2));
+
+        // Transaction with one failing check would not commit
+        let outcome = db.transact_write_dry_run(
+            TransactWriteRequest::new()
+                .put(b"doc#2", ItemBuilder::new().string("name", "new").build())
+                .delete_with_condition(b"doc#1", "attribute_not_exists(version)"),
+        ).unwrap();
+        assert!(!outcome.would_commit);
+        assert!(outcome.results[0].condition_passed);
+        assert!(!outcome.results[1].condition_passed);
+
+        // Nothing was written
+        let stored = db.get(b"doc#1").unwrap().unwrap();
+        assert_eq!(stored.get("version").unwrap(), &Value::number(1));
+        assert!(db.get(b"doc#2").unwrap().is_none());
+    }
+
+    #[test]
+    fn test_database_set_schema_rejects_missing_email() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        db.set_schema(vec![
+            AttributeSchema::new("email", AttributeType::String)
+                .required()
+                .with_constraint(ValueConstraint::MaxLength(64)),
+            AttributeSchema::new("age", AttributeType::Number)
+                .with_constraint(ValueConstraint::MinValue("0".to_string())),
+        ]).unwrap();
+
+        let missing = ItemBuilder::new().string("name", "Alice").build();
+        match db.put(b"user#1", missing) {
+            Err(KeystoneError::SchemaValidation { attribute, reason }) => {
+                assert_eq!(attribute, "email");
+                assert!(reason.contains("required"));
+            }
+            other => panic!("expected SchemaValidation error, got {:?}", other),
+        }
+        assert!(db.get(b"user#1").unwrap().is_none());
+
+        let negative_age = ItemBuilder::new()
+            .string("email", "alice@example.com")
+            .number("age", -1)
+            .build();
+        assert!(matches!(
+            db.put(b"user#1", negative_age),
+            Err(KeystoneError::SchemaValidation { ref attribute, .. }) if attribute == "age"
+        ));
+
+        let valid = ItemBuilder::new().string("email", "alice@example.com").build();
+        db.put(b"user#1", valid).unwrap();
+        assert!(db.get(b"user#1").unwrap().is_some());
+    }
+
+    #[test]
+    fn test_database_try_transact_write_reports_reasons() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        db.put(b"account#2", ItemBuilder::new().number("balance", 10).build()).unwrap();
+
+        let request = TransactWriteRequest::new()
+            .put(b"account#1", ItemBuilder::new().number("balance", 5).build())
+            .update_with_condition(
+                b"account#2",
+                "SET balance = balance - :amount",
+                "balance >= :amount"
+            )
+            .put(b"account#3", ItemBuilder::new().number("balance", 5).build())
+            .value(":amount", kstone_core::Value::number(100));
+
+        match db.try_transact_write(request).unwrap() {
+            TransactWriteOutcome::Canceled(reasons) => {
+                assert_eq!(reasons.len(), 3);
+                assert!(reasons[0].is_none());
+                assert_eq!(reasons[1].code, CancellationReason::CONDITIONAL_CHECK_FAILED);
+                assert!(reasons[2].is_none());
+            }
+            other => panic!("expected cancellation, got {:?}", other),
+        }
+
+        assert!(db.get(b"account#1").unwrap().is_none());
+        assert!(db.get(b"account#3").unwrap().is_none());
+    }
+
+    #[test]
+    fn test_database_query_index_fetch_full_items() {
+        let dir = TempDir::new().unwrap();
+
+        let schema = TableSchema::new().add_local_index(
+            LocalSecondaryIndex::new("email-index", "email").include(vec!["name".to_string()]),
+        );
+        let db = Database::create_with_schema(dir.path(), schema).unwrap();
+
+        for i in 0..3 {
+            let sk = format!("user#{}", i);
+            let item = ItemBuilder::new()
+                .string("email", format!("user{}@example.com", i))
+                .string("name", format!("User {}", i))
+                .string("bio", format!("Bio {}", i))
+                .build();
+            db.put_with_sk(b"org#acme", sk.as_bytes(), item).unwrap();
+        }
+
+        // Projected index results omit non-projected attributes
+        let projected = db
+            .query(Query::new(b"org#acme").index("email-index"))
+            .unwrap();
+        assert_eq!(projected.items.len(), 3);
+        assert!(projected.items.iter().all(|item| !item.contains_key("bio")));
+        assert!(projected.items.iter().all(|item| item.contains_key("name")));
+
+        // Full fetch reads through to the base table, in index order
+        let full = db
+            .query(Query::new(b"org#acme").index("email-index").fetch_full_items(true))
+            .unwrap();
+        assert_eq!(full.items.len(), 3);
+        for (i, item) in full.items.iter().enumerate() {
+            assert_eq!(item.get("email").unwrap().as_string().unwrap(), format!("user{}@example.com", i));
+            assert_eq!(item.get("bio").unwrap().as_string().unwrap(), format!("Bio {}", i));
+        }
+    }
+
+    #[test]
+    fn test_database_compare_and_swap_single_winner_per_generation() {
+        use std::sync::atomic::{AtomicUsize, Ordering};
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        // Absent attribute: first swap creates it, second sees it exists
+        assert!(db.compare_and_swap(b"leader", None, "term", None, Value::number(0)).unwrap());
+        assert!(!db.compare_and_swap(b"leader", None, "term", None, Value::number(0)).unwrap());
+
+        const GENERATIONS: i64 = 5;
+        const THREADS: usize = 8;
+
+        for generation in 0..GENERATIONS {
+            let winners = AtomicUsize::new(0);
+            std::thread::scope(|s| {
+                for _ in 0..THREADS {
+                    s.spawn(|| {
+                        let swapped = db
+                            .compare_and_swap(
+                                b"leader",
+                                None,
+                                "term",
+                                Some(Value::number(generation)),
+                                Value::number(generation + 1),
+                            )
+                            .unwrap();
+                        if swapped {
+                            winners.fetch_add(1, Ordering::SeqCst);
+                        }
+                    });
+                }
+            });
+            assert_eq!(winners.load(Ordering::SeqCst), 1, "generation {}", generation);
+        }
+
+        let item = db.get(b"leader").unwrap().unwrap();
+        assert_eq!(item.get("term").unwrap(), &Value::number(GENERATIONS));
+    }
+
+    #[test]
+    fn test_database_lock_acquire_contend_release() {
+        use std::time::Duration;
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let lock = db.acquire_lock("jobs", Duration::from_secs(60)).unwrap();
+        assert_eq!(lock.fencing_token(), 1);
+        assert!(!lock.is_expired());
+
+        // Held and unexpired: a second acquirer is refused
+        assert!(matches!(
+            db.acquire_lock("jobs", Duration::from_secs(60)),
+            Err(KeystoneError::ConditionalCheckFailed(_))
+        ));
+
+        // Other names are independent
+        let other = db.acquire_lock("reports", Duration::from_secs(60)).unwrap();
+        other.release().unwrap();
+
+        lock.release().unwrap();
+        // Release keeps the fence: the next holder gets a higher token
+        let again = db.acquire_lock("jobs", Duration::from_secs(60)).unwrap();
+        assert_eq!(again.fencing_token(), 2);
+        again.release().unwrap();
+        let third = db.acquire_lock("jobs", Duration::from_secs(60)).unwrap();
+        assert_eq!(third.fencing_token(), 3);
+        third.release().unwrap();
+    }
+
+    #[test]
+    fn test_database_lock_stale_takeover_after_ttl() {
+        use std::time::Duration;
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let mut stale = db.acquire_lock("jobs", Duration::from_millis(50)).unwrap();
+        stale.refresh().unwrap();
+        std::thread::sleep(Duration::from_millis(100));
+        assert!(stale.is_expired());
+
+        // Expired lock is taken over with a higher fencing token
+        let mut current = db.acquire_lock("jobs", Duration::from_secs(60)).unwrap();
+        assert_eq!(current.fencing_token(), 2);
+
+        // The stale holder can neither refresh nor release the new lock
+        assert!(matches!(stale.refresh(), Err(KeystoneError::ConditionalCheckFailed(_))));
+        assert!(matches!(stale.release(), Err(KeystoneError::ConditionalCheckFailed(_))));
+
+        current.refresh().unwrap();
+        current.release().unwrap();
+    }
+
+    #[test]
+    fn test_database_tail_wal_delivers_writes_in_order() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let mut tail = db.tail_wal(0).unwrap();
+        assert!(tail.try_next().is_none());
+
+        let mut item = HashMap::new();
+        item.insert("n".to_string(), Value::number(1));
+        db.put(b"a", item.clone()).unwrap();
+        db.put(b"b", item).unwrap();
+        db.delete(b"a").unwrap();
+
+        let mut events = Vec::new();
+        for _ in 0..3 {
+            match tail.next_timeout(std::time::Duration::from_secs(1)) {
+                Some(WalTailEvent::Record(record)) => events.push(record),
+                other => panic!("expected record, got {:?}", other),
+            }
+        }
+
+        assert_eq!(events[0].key.pk.as_ref(), b"a");
+        assert!(events[0].value.is_some());
+        assert_eq!(events[1].key.pk.as_ref(), b"b");
+        assert_eq!(events[2].key.pk.as_ref(), b"a");
+        assert!(events[2].value.is_none());
+        assert!(events[0].seq < events[1].seq && events[1].seq < events[2].seq);
+        assert!(tail.try_next().is_none());
+    }
+
+    #[test]
+    fn test_database_recover_to_seq_drops_later_writes() {
+        let dir = TempDir::new().unwrap();
+        let src = dir.path().join("src");
+        let dest = dir.path().join("dest");
+
+        let db = Database::create(&src).unwrap();
+        let mut item = HashMap::new();
+        item.insert("batch".to_string(), Value::number(1));
+        db.put(b"a", item.clone()).unwrap();
+        db.put(b"b", item).unwrap();
+
+        let checkpoint = db.last_seq().unwrap();
+
+        let mut item = HashMap::new();
+        item.insert("batch".to_string(), Value::number(2));
+        db.put(b"a", item.clone()).unwrap();
+        db.put(b"c", item).unwrap();
+        db.delete(b"b").unwrap();
+
+        let restored = Database::recover_to_seq(&src, &dest, checkpoint).unwrap();
+        assert_eq!(restored.get(b"a").unwrap().unwrap().get("batch"), Some(&Value::number(1)));
+        assert!(restored.get(b"b").unwrap().is_some());
+        assert!(restored.get(b"c").unwrap().is_none());
+
+        // The source still has the later writes
+        assert!(db.get(b"c").unwrap().is_some());
+        assert!(db.get(b"b").unwrap().is_none());
+    }
+
+    #[test]
+    fn test_database_range_scan_returns_sorted_keys_within_bounds() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        for pk in ["k07", "k02", "k10", "k05", "k01", "k09", "k03", "k08", "k04", "k06"] {
+            let mut item = HashMap::new();
+            item.insert("pk".to_string(), Value::string(pk));
+            db.put(pk.as_bytes(), item).unwrap();
+        }
+
+        let response = db
+            .range_scan(
+                Key::new(Bytes::from_static(b"k03")),
+                Key::new(Bytes::from_static(b"k08")),
+                Scan::new(),
+            )
+            .unwrap();
+        let pks: Vec<&Value> = response.items.iter().map(|item| &item["pk"]).collect();
+        let expected: Vec<Value> = ["k03", "k04", "k05", "k06", "k07"].iter().map(|s| Value::string(*s)).collect();
+        assert_eq!(pks, expected.iter().collect::<Vec<_>>());
+
+        // A full scan is in key order too
+        let all = db.scan(Scan::new()).unwrap();
+        let mut sorted = all.items.clone();
+        sorted.sort_by(|a, b| match (&a["pk"], &b["pk"]) {
+            (Value::S(a), Value::S(b)) => a.cmp(b),
+            _ => unreachable!(),
+        });
+        assert_eq!(all.items, sorted);
+        assert_eq!(all.count, 10);
+    }
+
+    #[test]
+    fn test_database_exists() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        assert!(!db.exists(b"user#1").unwrap());
+
+        let mut item = HashMap::new();
+        item.insert("name".to_string(), Value::string("Alice"));
+        db.put(b"user#1", item.clone()).unwrap();
+        db.put_with_sk(b"org#1", b"user#1", item).unwrap();
+
+        assert!(db.exists(b"user#1").unwrap());
+        assert!(db.exists_with_sk(b"org#1", b"user#1").unwrap());
+        assert!(!db.exists_with_sk(b"org#1", b"user#2").unwrap());
+
+        db.delete(b"user#1").unwrap();
+        assert!(!db.exists(b"user#1").unwrap());
+    }
+
+    #[test]
+    fn test_database_count_partition() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        for i in 0..25 {
+            let mut item = HashMap::new();
+            item.insert("n".to_string(), Value::number(i));
+            db.put_with_sk(b"org#1", format!("user#{:02}", i).as_bytes(), item).unwrap();
+        }
+        db.put(b"org#2", HashMap::new()).unwrap();
+        db.delete_with_sk(b"org#1", b"user#00").unwrap();
+
+        assert_eq!(db.count_partition(b"org#1").unwrap(), 24);
+        assert_eq!(db.count_partition(b"org#3").unwrap(), 0);
+    }
+
+    #[test]
+    fn test_database_get_many_preserves_order() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        for id in ["user#1", "user#2"] {
+            let mut item = HashMap::new();
+            item.insert("id".to_string(), Value::string(id));
+            db.put(id.as_bytes(), item).unwrap();
+        }
+
+        let keys = vec![
+            Key::new(b"user#2".to_vec()),
+            Key::new(b"user#1".to_vec()),
+            Key::new(b"user#9".to_vec()),
+            Key::new(b"user#2".to_vec()),
+        ];
+        let items = db.get_many(&keys).unwrap();
+
+        assert_eq!(items.len(), 4);
+        assert_eq!(items[0].as_ref().unwrap().get("id"), Some(&Value::string("user#2")));
+        assert_eq!(items[1].as_ref().unwrap().get("id"), Some(&Value::string("user#1")));
+        assert!(items[2].is_none());
+        assert_eq!(items[3], items[0]);
+    }
+
+    #[test]
+    fn test_database_get_partition_map() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        for name in ["alice", "bob", "carol"] {
+            let mut item = HashMap::new();
+            item.insert("name".to_string(), Value::string(name));
+            db.put_with_sk(b"org#acme", format!("user#{}", name).as_bytes(), item).unwrap();
+        }
+        db.put_with_sk(b"org#other", b"user#dave", HashMap::new()).unwrap();
+
+        let members = db.get_partition_map(b"org#acme").unwrap();
+        assert_eq!(members.len(), 3);
+        assert_eq!(
+            members.get(b"user#bob".as_slice()).unwrap().get("name"),
+            Some(&Value::string("bob"))
+        );
+
+        assert!(db.get_partition_map(b"org#none").unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_database_snapshot_isolated_from_later_writes() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let mut item = HashMap::new();
+        item.insert("balance".to_string(), Value::number(100));
+        db.put(b"account#1", item).unwrap();
+        db.put(b"account#2", HashMap::new()).unwrap();
+
+        let snapshot = db.snapshot().unwrap();
+
+        let mut item = HashMap::new();
+        item.insert("balance".to_string(), Value::number(50));
+        db.put(b"account#1", item).unwrap();
+        db.delete(b"account#2").unwrap();
+        db.put(b"account#3", HashMap::new()).unwrap();
+
+        assert_eq!(
+            snapshot.get(b"account#1").unwrap().unwrap().get("balance"),
+            Some(&Value::number(100))
+        );
+        assert_eq!(
+            db.get(b"account#1").unwrap().unwrap().get("balance"),
+            Some(&Value::number(50))
+        );
+        assert!(snapshot.get(b"account#2").unwrap().is_some());
+        assert!(snapshot.get(b"account#3").unwrap().is_none());
+        assert_eq!(snapshot.scan(Scan::new()).unwrap().items.len(), 2);
+        assert_eq!(db.scan(Scan::new()).unwrap().items.len(), 2);
+
+        snapshot.release();
+    }
+
+    #[test]
+    fn test_database_txn_conflict() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let mut item = HashMap::new();
+        item.insert("balance".to_string(), Value::number(100));
+        db.put(b"account#1", item).unwrap();
+
+        // Both transactions read the balance, then each withdraws from it
+        let mut first = db.begin().unwrap();
+        let mut second = db.begin().unwrap();
+        for (txn, amount) in [(&mut first, 30), (&mut second, 50)] {
+            let mut account = txn.get(b"account#1").unwrap().unwrap();
+            let balance = match account.get("balance") {
+                Some(Value::N(n)) => n.parse::<i64>().unwrap(),
+                other => panic!("unexpected balance {:?}", other),
+            };
+            account.insert("balance".to_string(), Value::number(balance - amount));
+            txn.put(b"account#1", account);
+        }
+
+        first.commit().unwrap();
+        assert!(matches!(
+            second.commit(),
+            Err(kstone_core::Error::TransactionConflict(_))
+        ));
+        assert_eq!(
+            db.get(b"account#1").unwrap().unwrap().get("balance"),
+            Some(&Value::number(70))
+        );
+
+        // Rolled back writes are never applied
+        let mut txn = db.begin().unwrap();
+        txn.delete(b"account#1");
+        txn.rollback();
+        assert!(db.get(b"account#1").unwrap().is_some());
+    }
+
+    #[test]
+    fn test_database_insert_many_skips_existing() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let mut original = HashMap::new();
+        original.insert("name".to_string(), Value::string("original"));
+        db.put(b"user#2", original).unwrap();
+
+        let items: Vec<(Key, Item)> = (1..=3)
+            .map(|i| {
+                let mut item = HashMap::new();
+                item.insert("name".to_string(), Value::string("seeded"));
+                (Key::new(format!("user#{}", i).into_bytes()), item)
+            })
+            .collect();
+
+        assert_eq!(db.insert_many(&items).unwrap(), vec![true, false, true]);
+        assert_eq!(
+            db.get(b"user#2").unwrap().unwrap().get("name"),
+            Some(&Value::string("original"))
+        );
+        assert!(db.get(b"user#3").unwrap().is_some());
+    }
+
+    #[test]
+    fn test_database_purge_prefix() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        for i in 0..200 {
+            let item = ItemBuilder::new().number("n", i).build();
+            db.put_with_sk(b"device#1", format!("log#{:03}", i).as_bytes(), item).unwrap();
+        }
+        for i in 0..5 {
+            let item = ItemBuilder::new().number("n", i).build();
+            db.put_with_sk(b"device#1", format!("keep#{}", i).as_bytes(), item).unwrap();
+        }
+        db.flush().unwrap();
+
+        assert_eq!(db.purge_prefix(b"device#1", b"log#").unwrap(), 200);
+        assert_eq!(db.count_partition(b"device#1").unwrap(), 5);
+        assert!(db.get_with_sk(b"device#1", b"log#000").unwrap().is_none());
+        assert!(db.get_with_sk(b"device#1", b"keep#0").unwrap().is_some());
+
+        // Compacted into a single SST holding only the remaining items
+        let ssts: Vec<_> = std::fs::read_dir(dir.path())
+            .unwrap()
+            .filter_map(|e| e.ok())
+            .filter(|e| e.path().extension().map_or(false, |ext| ext == "sst"))
+            .collect();
+        assert_eq!(ssts.len(), 1);
+        assert!(db.stats().unwrap().compaction.total_compactions >= 1);
+
+        assert_eq!(db.purge_prefix(b"device#1", b"log#").unwrap(), 0);
+    }
+
+    #[test]
+    fn test_database_item_too_large_reports_sizes() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_max_item_size_bytes(64);
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+
+        let too_big = ItemBuilder::new().string("data", "x".repeat(96)).build();
+        match db.put(b"big", too_big) {
+            Err(KeystoneError::ItemTooLarge { size, limit }) => {
+                assert_eq!(size, 100);
+                assert_eq!(limit, 64);
+            }
+            other => panic!("expected ItemTooLarge, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_database_export_to() {
+        let db = Database::create_in_memory().unwrap();
+        db.put(b"user#1", ItemBuilder::new().number("age", 30).build()).unwrap();
+        db.put_with_sk(b"user#1", b"order#1", ItemBuilder::new().string("sku", "a").build()).unwrap();
+        db.put(b"user#2", ItemBuilder::new().bool("active", true).build()).unwrap();
+        db.delete(b"user#2").unwrap();
+
+        let mut buf = Vec::new();
+        assert_eq!(db.export_to(&mut buf).unwrap(), 2);
+
+        let records: Vec<ExportRecord> = ExportReader::new(buf.as_slice())
+            .unwrap()
+            .collect::<Result<_>>()
+            .unwrap();
+        assert_eq!(records.len(), 2);
+        assert_eq!(records[0].item.get("age"), Some(&Value::N("30".to_string())));
+        assert_eq!(records[1].sk.as_deref(), Some(&b"order#1"[..]));
+    }
+
+    #[test]
+    fn test_database_query_live_follows_appends() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        let event = |n: i64| ItemBuilder::new().number("n", n).build();
+
+        db.put_with_sk(b"log", b"event#1", event(1)).unwrap();
+        let mut live = db.query_live(Query::new(b"log").sk_begins_with(b"event#").filter("n > :min").value(":min", Value::number(0))).unwrap();
+        assert_eq!(live.initial_items().len(), 1);
+
+        db.put_with_sk(b"log", b"event#2", event(2)).unwrap();
+        db.put_with_sk(b"other", b"event#1", event(3)).unwrap(); // other partition
+        db.put_with_sk(b"log", b"meta", event(4)).unwrap(); // sort key does not match
+        db.put_with_sk(b"log", b"event#3", event(0)).unwrap(); // filtered out
+        db.put_with_sk(b"log", b"event#4", event(5)).unwrap();
+
+        let timeout = std::time::Duration::from_secs(1);
+        assert_eq!(live.next_timeout(timeout).unwrap(), Some(event(2)));
+        assert_eq!(live.next_timeout(timeout).unwrap(), Some(event(5)));
+        assert_eq!(live.next_timeout(std::time::Duration::from_millis(50)).unwrap(), None);
+    }
+
+    #[test]
+    fn test_database_put_number_stores_number_type() {
+        let db = Database::create_in_memory().unwrap();
+        db.put(b"user#1", ItemBuilder::new().string("name", "Alice").build()).unwrap();
+
+        db.put_number(b"user#1", None, "age", 30.0).unwrap();
+        db.put_bool(b"user#1", None, "active", true).unwrap();
+        db.put_number(b"user#2", Some(b"profile"), "score", 2.5).unwrap();
+
+        let item = db.get(b"user#1").unwrap().unwrap();
+        assert_eq!(item.get("age"), Some(&Value::N("30".to_string())));
+        assert_eq!(item.get("name"), Some(&Value::S("Alice".to_string())));
+        assert_eq!(db.get_number(b"user#1", None, "age").unwrap(), Some(30.0));
+        assert_eq!(db.get_bool(b"user#1", None, "active").unwrap(), Some(true));
+        assert_eq!(db.get_number(b"user#2", Some(b"profile"), "score").unwrap(), Some(2.5));
+        assert_eq!(db.get_number(b"user#1", None, "missing").unwrap(), None);
+        assert!(db.get_number(b"user#1", None, "name").is_err());
+        assert!(db.put_number(b"user#1", None, "age", f64::NAN).is_err());
+
+        // Stored as a number, so it compares numerically
+        let response = db.query(Query::new(b"user#1").filter("age > :min").value(":min", Value::number(9))).unwrap();
+        assert_eq!(response.items.len(), 1);
+    }
+
+    #[test]
+    fn test_database_transact_get_condition_checks() {
+        let db = Database::create_in_memory().unwrap();
+        db.put(b"a", ItemBuilder::new().number("n", 1).build()).unwrap();
+        db.put(b"flag", ItemBuilder::new().bool("open", true).build()).unwrap();
+
+        let request = TransactGetRequest::new()
+            .get(b"a")
+            .condition_check(b"flag", "open = :yes")
+            .value(":yes", Value::Bool(true));
+        let response = db.transact_get(request.clone()).unwrap();
+        assert!(response.items[0].is_some());
+
+        db.put(b"flag", ItemBuilder::new().bool("open", false).build()).unwrap();
+        assert!(matches!(
+            db.transact_get(request),
+            Err(kstone_core::Error::ConditionalCheckFailed(_))
+        ));
+    }
+
+    #[test]
+    fn test_database_group_commit_writes_survive_reopen() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_group_commit_window(std::time::Duration::from_millis(2));
+        {
+            let db = std::sync::Arc::new(Database::create_with_config(dir.path(), config.clone()).unwrap());
+            let writers: Vec<_> = (0..8)
+                .map(|w| {
+                    let db = std::sync::Arc::clone(&db);
+                    std::thread::spawn(move || {
+                        for i in 0..25 {
+                            let key = format!("w{}#{}", w, i);
+                            db.put(key.as_bytes(), ItemBuilder::new().number("i", i).build()).unwrap();
+                        }
+                        db.delete(format!("w{}#0", w).as_bytes()).unwrap();
+                    })
+                })
+                .collect();
+            for writer in writers {
+                writer.join().unwrap();
+            }
+        }
+
+        let db = Database::open_with_config(dir.path(), config).unwrap();
+        for w in 0..8 {
+            assert!(db.get(format!("w{}#0", w).as_bytes()).unwrap().is_none());
+            for i in 1..25 {
+                let item = db.get(format!("w{}#{}", w, i).as_bytes()).unwrap().unwrap();
+                assert_eq!(item.get("i"), Some(&Value::number(i)));
+            }
+        }
+    }
+
+    #[test]
+    fn test_database_update_conditional_transition() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        db.put(b"order#1", ItemBuilder::new().string("status", "pending").number("attempts", 0).build())
+            .unwrap();
+
+        let values = || {
+            HashMap::from([
+                (":from".to_string(), Value::string("pending")),
+                (":to".to_string(), Value::string("done")),
+                (":one".to_string(), Value::number(1)),
+            ])
+        };
+        let expression = "SET status = :to, attempts = attempts + :one";
+
+        let item = db
+            .update_conditional(b"order#1", None, expression, "status = :from", values())
+            .unwrap();
+        assert_eq!(item.get("status"), Some(&Value::string("done")));
+        assert_eq!(item.get("attempts"), Some(&Value::number(1)));
+
+        // Repeating the same transition fails and leaves the item alone
+        let result = db.update_conditional(b"order#1", None, expression, "status = :from", values());
+        assert!(matches!(result, Err(kstone_core::Error::ConditionalCheckFailed(_))));
+
+        let stored = db.get(b"order#1").unwrap().unwrap();
+        assert_eq!(stored, item);
+    }
+
+    #[test]
+    fn test_database_value_compression_round_trips() {
+        let text = "highly compressible text ".repeat(42_000); // ~1 MB
+        let item = || ItemBuilder::new().string("body", text.clone()).number("version", 1).build();
+        let config = DatabaseConfig::new().with_max_item_size_bytes(2 * 1024 * 1024);
+        let compressed_config = config.clone().with_value_compression_threshold(4096);
+
+        let plain_dir = TempDir::new().unwrap();
+        let plain = Database::create_with_config(plain_dir.path(), config).unwrap();
+        plain.put(b"doc#1", item()).unwrap();
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create_with_config(dir.path(), compressed_config.clone()).unwrap();
+        db.put(b"doc#1", item()).unwrap();
+        assert_eq!(db.get(b"doc#1").unwrap(), Some(item()));
+
+        let plain_bytes = plain.stats().unwrap().total_disk_size_bytes.unwrap();
+        let compressed_bytes = db.stats().unwrap().total_disk_size_bytes.unwrap();
+        assert!(
+            compressed_bytes * 10 < plain_bytes,
+            "compressed {} bytes, plain {} bytes",
+            compressed_bytes,
+            plain_bytes
+        );
+
+        // Read back from an SST and from the WAL after reopening
+        db.flush().unwrap();
+        db.put(b"doc#2", item()).unwrap();
+        drop(db);
+        let db = Database::open_with_config(dir.path(), compressed_config).unwrap();
+        assert_eq!(db.get(b"doc#1").unwrap(), Some(item()));
+        assert_eq!(db.get(b"doc#2").unwrap(), Some(item()));
+    }
+
+    #[test]
+    fn test_database_keys_only() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let mut expected = Vec::new();
+        for user in 0..20 {
+            let pk = format!("user#{:02}", user);
+            for order in 0..10 {
+                let sk = format!("order#{:02}", order);
+                let item = ItemBuilder::new().string("data", "x".repeat(1024)).build();
+                db.put_with_sk(pk.as_bytes(), sk.as_bytes(), item).unwrap();
+                expected.push(Key::with_sk(Bytes::from(pk.clone()), Bytes::from(sk)));
+            }
+        }
+        db.delete_with_sk(b"user#03", b"order#04").unwrap();
+        expected.retain(|key| !(key.pk.as_ref() == b"user#03" && key.sk.as_deref() == Some(b"order#04".as_ref())));
+        expected.sort();
+
+        let keys: Vec<Key> = db.keys_only(Scan::new()).unwrap().collect();
+        assert_eq!(keys, expected);
+
+        // Limit and pagination work as for scans
+        let first: Vec<Key> = db.keys_only(Scan::new().limit(15)).unwrap().collect();
+        assert_eq!(first, expected[..15]);
+        let last = &first[14];
+        let rest: Vec<Key> = db
+            .keys_only(Scan::new().start_after(&last.pk, last.sk.as_deref()))
+            .unwrap()
+            .collect();
+        assert_eq!(rest, expected[15..]);
+
+        assert!(db.keys_only(Scan::new().filter("data = :x").value(":x", Value::string("x"))).is_err());
+    }
+
+    #[test]
+    fn test_database_partition_digest() {
+        let a = Database::create_in_memory().unwrap();
+        let b = Database::create_in_memory().unwrap();
+
+        let orders = [("order#1", 10), ("order#2", 20), ("order#3", 30)];
+        for (sk, total) in orders {
+            a.put_with_sk(b"user#1", sk.as_bytes(), ItemBuilder::new().number("total", total).build())
+                .unwrap();
+        }
+        // Same items written in the opposite order, plus another partition
+        for (sk, total) in orders.iter().rev() {
+            b.put_with_sk(b"user#1", sk.as_bytes(), ItemBuilder::new().number("total", total).build())
+                .unwrap();
+        }
+        b.put(b"user#2", ItemBuilder::new().string("name", "other").build()).unwrap();
+
+        let digest = a.partition_digest(b"user#1").unwrap();
+        assert_eq!(digest, b.partition_digest(b"user#1").unwrap());
+
+        // A single attribute change diverges the digest
+        b.put_with_sk(b"user#1", b"order#2", ItemBuilder::new().number("total", 21).build())
+            .unwrap();
+        assert_ne!(digest, b.partition_digest(b"user#1").unwrap());
+    }
+
+    #[test]
+    fn test_database_get_as_of() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let v1 = ItemBuilder::new().string("status", "active").number("version", 1).build();
+        let v2 = ItemBuilder::new().string("status", "corrupted").number("version", 2).build();
+
+        let before = db.last_seq().unwrap();
+        db.put_with_sk(b"user#1", b"profile", v1.clone()).unwrap();
+        let seq = db.last_seq().unwrap();
+        db.put_with_sk(b"user#1", b"profile", v2.clone()).unwrap();
+        db.flush().unwrap();
+
+        assert_eq!(db.get_with_sk(b"user#1", b"profile").unwrap(), Some(v2.clone()));
+        assert_eq!(db.get_as_of(b"user#1", Some(b"profile"), seq).unwrap(), Some(v1));
+        assert_eq!(db.get_as_of(b"user#1", Some(b"profile"), before).unwrap(), None);
+        assert_eq!(db.get_as_of(b"user#1", Some(b"profile"), db.last_seq().unwrap()).unwrap(), Some(v2));
+
+        // Deleted since: the old version is still readable
+        db.delete_with_sk(b"user#1", b"profile").unwrap();
+        assert_eq!(db.get_as_of(b"user#1", Some(b"profile"), seq).unwrap().unwrap()["version"], Value::number(1));
+    }
+
+    #[test]
+    fn test_database_auto_timestamp() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_auto_timestamp();
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+
+        let stamp = |db: &Database| match db.get(b"user#1").unwrap().unwrap().get("__updated_at") {
+            Some(Value::Ts(ts)) => *ts,
+            other => panic!("expected a timestamp, got {:?}", other),
+        };
+
+        db.put(b"user#1", ItemBuilder::new().string("name", "Alice").build()).unwrap();
+        let first = stamp(&db);
+
+        db.put(b"user#1", ItemBuilder::new().string("name", "Alicia").build()).unwrap();
+        let second = stamp(&db);
+        assert!(second > first, "{} should be after {}", second, first);
+
+        // Updates are stamped too, and return the stamped item
+        let response = db
+            .update(Update::new(b"user#1").expression("SET age = :age").value(":age", Value::number(30)))
+            .unwrap();
+        let third = stamp(&db);
+        assert!(third > second);
+        assert_eq!(response.item.get("__updated_at"), Some(&Value::Ts(third)));
+    }
+
+    #[test]
+    fn test_database_partition_sizes() {
+        let db = Database::create_in_memory().unwrap();
+
+        for i in 0..10 {
+            let sk = format!("sk{}", i);
+            db.put_with_sk(b"hot", sk.as_bytes(), ItemBuilder::new().number("n", i).build()).unwrap();
+        }
+        for i in 0..3 {
+            let sk = format!("sk{}", i);
+            db.put_with_sk(b"warm", sk.as_bytes(), ItemBuilder::new().number("n", i).build()).unwrap();
+        }
+        db.put(b"big", ItemBuilder::new().string("blob", "x".repeat(4096)).build()).unwrap();
+        db.put(b"gone", ItemBuilder::new().number("n", 1).build()).unwrap();
+        db.delete(b"gone").unwrap();
+
+        let by_count = db.partition_sizes(2, PartitionRank::ItemCount).unwrap();
+        assert_eq!(by_count.len(), 2);
+        assert_eq!(by_count[0].pk, Bytes::from_static(b"hot"));
+        assert_eq!(by_count[0].items, 10);
+        assert_eq!(by_count[1].pk, Bytes::from_static(b"warm"));
+
+        let by_bytes = db.partition_sizes(10, PartitionRank::Bytes).unwrap();
+        assert_eq!(by_bytes.len(), 3);
+        assert_eq!(by_bytes[0].pk, Bytes::from_static(b"big"));
+        assert_eq!(by_bytes[0].items, 1);
+        assert!(by_bytes[0].bytes > 4096);
+    }
+
+    #[test]
+    fn test_database_merge_patch() {
+        let db = Database::create_in_memory().unwrap();
+        db.put_with_sk(
+            b"user#1",
+            b"profile",
+            ItemBuilder::new()
+                .string("name", "Alice")
+                .number("age", 30)
+                .string("city", "Paris")
+                .build(),
+        )
+        .unwrap();
+
+        let mut patch = HashMap::new();
+        patch.insert("age".to_string(), Value::number(31));
+        patch.insert("city".to_string(), Value::Null);
+        let merged = db.merge(b"user#1", Some(b"profile"), patch).unwrap();
+
+        let stored = db.get_with_sk(b"user#1", b"profile").unwrap().unwrap();
+        assert_eq!(stored, merged);
+        assert_eq!(stored.get("age"), Some(&Value::number(31)));
+        assert!(!stored.contains_key("city"));
+        assert_eq!(stored.get("name"), Some(&Value::string("Alice")));
+        assert_eq!(stored.len(), 2);
+    }
+
+    #[test]
+    fn test_database_scan_filter_predicate() {
+        let db = Database::create_in_memory().unwrap();
+        for i in 0..100 {
+            let pk = format!("item#{:03}", i);
+            db.put(pk.as_bytes(), ItemBuilder::new().number("n", i).build()).unwrap();
+        }
+        let is_even = |item: &Item| item.get("n").and_then(|v| v.as_number()).map_or(false, |n| n % 2.0 == 0.0);
+
+        let all = db.scan_filter(Scan::new(), is_even).unwrap();
+        assert_eq!(all.count, 50);
+        assert_eq!(all.scanned_count, 100);
+        assert!(all.items.iter().all(is_even));
+        assert!(all.last_key.is_none());
+
+        // The limit counts matches, and paging resumes after the last one
+        let first = db.scan_filter(Scan::new().limit(10), is_even).unwrap();
+        assert_eq!(first.count, 10);
+        assert!(first.items.iter().all(is_even));
+        let (pk, sk) = first.last_key.unwrap();
+        let rest = db
+            .scan_filter(Scan::new().start_after(&pk, sk.as_deref()), is_even)
+            .unwrap();
+        assert_eq!(first.count + rest.count, 50);
+
+        assert!(db.scan_filter(Scan::new().filter("n > :z").value(":z", Value::number(0)), is_even).is_err());
+    }
+
+    #[test]
+    fn test_database_txn_overlapping_keys_never_deadlock() {
+        const ACCOUNTS: u64 = 10;
+        const THREADS: u64 = 8;
+        const TRANSFERS: usize = 100;
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        for i in 0..ACCOUNTS {
+            db.put(format!("account#{}", i).as_bytes(), ItemBuilder::new().number("balance", 100).build())
+                .unwrap();
+        }
+
+        let balance = |item: &Item| match item.get("balance") {
+            Some(Value::N(n)) => n.parse::<i64>().unwrap(),
+            other => panic!("unexpected balance {:?}", other),
+        };
+
+        std::thread::scope(|s| {
+            for thread in 0..THREADS {
+                let db = &db;
+                s.spawn(move || {
+                    // xorshift, seeded per thread
+                    let mut state = thread * 7919 + 1;
+                    let mut next = move || {
+                        state ^= state << 13;
+                        state ^= state >> 7;
+                        state ^= state << 17;
+                        state % ACCOUNTS
+                    };
+
+                    for _ in 0..TRANSFERS {
+                        // Three distinct accounts, touched in random order
+                        let mut keys = Vec::new();
+                        while keys.len() < 3 {
+                            let key = format!("account#{}", next());
+                            if !keys.contains(&key) {
+                                keys.push(key);
+                            }
+                        }
+
+                        loop {
+                            let mut txn = db.begin().unwrap();
+                            let mut items: Vec<Item> =
+                                keys.iter().map(|k| txn.get(k.as_bytes()).unwrap().unwrap()).collect();
+                            // Move 2 from the first account, 1 to each of the others
+                            let amounts = [-2, 1, 1];
+                            for (item, amount) in items.iter_mut().zip(amounts) {
+                                let updated = balance(item) + amount;
+                                item.insert("balance".to_string(), Value::number(updated));
+                            }
+                            for (key, item) in keys.iter().zip(items) {
+                                txn.put(key.as_bytes(), item);
+                            }
+                            match txn.commit() {
+                                Ok(()) => break,
+                                Err(kstone_core::Error::TransactionConflict(_)) => continue,
+                                Err(e) => panic!("commit failed: {}", e),
+                            }
+                        }
+                    }
+                });
+            }
+        });
+
+        let total: i64 = (0..ACCOUNTS)
+            .map(|i| balance(&db.get(format!("account#{}", i).as_bytes()).unwrap().unwrap()))
+            .sum();
+        assert_eq!(total, 100 * ACCOUNTS as i64);
+    }
+
+    #[test]
+    fn test_database_compaction_style_persists() {
+        let dir = TempDir::new().unwrap();
+        let tiered = CompactionConfig::new()
+            .with_style(CompactionStyle::Tiered)
+            .with_max_levels(5)
+            .with_level_size_multiplier(3);
+
+        {
+            let config = DatabaseConfig::new().with_compaction(tiered.clone());
+            let db = Database::create_with_config(dir.path(), config).unwrap();
+            assert_eq!(db.compaction_config().unwrap(), tiered);
+            for i in 0..50 {
+                db.put(format!("pk#{}", i).as_bytes(), ItemBuilder::new().number("n", i).build())
+                    .unwrap();
+                db.flush().unwrap();
+            }
+        }
+
+        // Reopening without a policy keeps the stored one
+        let db = Database::open(dir.path()).unwrap();
+        assert_eq!(db.compaction_config().unwrap(), tiered);
+        for i in 0..50 {
+            let item = db.get(format!("pk#{}", i).as_bytes()).unwrap().unwrap();
+            assert_eq!(item.get("n"), Some(&Value::number(i)));
+        }
+        drop(db);
+
+        // An explicit policy replaces it
+        let config = DatabaseConfig::new().with_compaction(CompactionConfig::new());
+        let db = Database::open_with_config(dir.path(), config).unwrap();
+        assert_eq!(db.compaction_config().unwrap().style, CompactionStyle::Leveled);
+    }
+
+    #[test]
+    fn test_database_config_reflects_stored_settings() {
+        let dir = TempDir::new().unwrap();
+        let compaction = CompactionConfig::new()
+            .with_style(CompactionStyle::Tiered)
+            .with_level_size_multiplier(6);
+        let config = DatabaseConfig::new()
+            .with_max_item_size_bytes(1024 * 1024)
+            .with_compaction(compaction.clone());
+        drop(Database::create_with_config(dir.path(), config).unwrap());
+
+        // Reopen without a compaction policy, but with a different item size
+        // limit and compression, which are per-open settings
+        let config = DatabaseConfig::new()
+            .with_max_item_size_bytes(2 * 1024 * 1024)
+            .with_compression()
+            .with_compression_level(9);
+        let db = Database::open_with_config(dir.path(), config).unwrap();
+        let effective = db.config().unwrap();
+        assert_eq!(effective.config.compaction, Some(compaction));
+        assert_eq!(effective.config.max_item_size_bytes, 2 * 1024 * 1024);
+        assert!(effective.config.compression_enabled);
+        assert_eq!(effective.config.compression_level, 9);
+        assert_eq!(effective.format_version, kstone_core::wal::WAL_FORMAT_VERSION);
+    }
+
+    #[test]
+    fn test_database_get_or_create_races_have_one_creator() {
+        use std::sync::atomic::{AtomicUsize, Ordering};
+        use std::sync::Barrier;
+
+        const THREADS: usize = 16;
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        let factory_runs = AtomicUsize::new(0);
+        let barrier = Barrier::new(THREADS);
+
+        let results: Vec<(Item, bool)> = std::thread::scope(|s| {
+            let handles: Vec<_> = (0..THREADS)
+                .map(|thread| {
+                    let (db, factory_runs, barrier) = (&db, &factory_runs, &barrier);
+                    s.spawn(move || {
+                        barrier.wait();
+                        db.get_or_create(b"config", Some(b"main"), || {
+                            factory_runs.fetch_add(1, Ordering::SeqCst);
+                            ItemBuilder::new().number("creator", thread as i64).build()
+                        })
+                        .unwrap()
+                    })
+                })
+                .collect();
+            handles.into_iter().map(|h| h.join().unwrap()).collect()
+        });
+
+        assert_eq!(results.iter().filter(|(_, created)| *created).count(), 1);
+        let stored = db.get_with_sk(b"config", b"main").unwrap().unwrap();
+        assert!(results.iter().all(|(item, _)| *item == stored));
+        assert!(factory_runs.load(Ordering::SeqCst) >= 1);
+
+        // Once it exists, the factory is not called
+        let (item, created) = db
+            .get_or_create(b"config", Some(b"main"), || panic!("item exists"))
+            .unwrap();
+        assert!(!created);
+        assert_eq!(item, stored);
+    }
+
+    #[test]
+    fn test_database_string_set_add_and_delete_members() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        db.put_string_set(b"post#1", None, "tags", ["rust", "db", "rust"]).unwrap();
+        assert_eq!(
+            db.get_string_set(b"post#1", None, "tags").unwrap().unwrap().into_iter().collect::<Vec<_>>(),
+            vec!["db", "rust"]
+        );
+        assert!(db.put_string_set(b"post#1", None, "tags", Vec::<String>::new()).is_err());
+
+        // ADD unions members in, DELETE takes them out; duplicates are ignored
+        let add = Update::new(b"post#1")
+            .expression("ADD tags :new")
+            .value(":new", Value::string_set(["db", "storage"]));
+        db.update(add).unwrap();
+        let delete = Update::new(b"post#1")
+            .expression("DELETE tags :old")
+            .value(":old", Value::string_set(["rust", "absent"]));
+        db.update(delete).unwrap();
+        assert_eq!(
+            db.get(b"post#1").unwrap().unwrap().get("tags"),
+            Some(&Value::string_set(["db", "storage"]))
+        );
+
+        // Concurrent ADDs each land exactly once
+        let db = std::sync::Arc::new(db);
+        let handles: Vec<_> = (0..8)
+            .map(|t| {
+                let db = db.clone();
+                std::thread::spawn(move || {
+                    for i in 0..25 {
+                        let add = Update::new(b"user#1")
+                            .expression("ADD followers :f")
+                            .value(":f", Value::string_set([format!("u{}", t * 25 + i), "shared".to_string()]));
+                        db.update(add).unwrap();
+                    }
+                })
+            })
+            .collect();
+        for handle in handles {
+            handle.join().unwrap();
+        }
+        let followers = db.get_string_set(b"user#1", None, "followers").unwrap().unwrap();
+        assert_eq!(followers.len(), 201);
+        assert!(followers.contains("shared"));
+
+        // Sets are stored as sets, and match set conditions
+        drop(db);
+        let db = Database::open(dir.path()).unwrap();
+        let response = db
+            .query(Query::new(b"post#1").filter("contains(tags, :t)").value(":t", Value::string("storage")))
+            .unwrap();
+        assert_eq!(response.items.len(), 1);
+        db.put_number(b"post#1", None, "views", 1.0).unwrap();
+        assert!(db.get_string_set(b"post#1", None, "views").is_err());
+    }
+
+    #[test]
+    fn test_database_session_reads_its_own_writes() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_group_commit_window(std::time::Duration::from_millis(50));
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+
+        let session = db.session();
+        assert_eq!(session.last_write_seq(), None);
+
+        session.put(b"user#1", ItemBuilder::new().string("name", "Alice").build()).unwrap();
+        assert_eq!(session.get(b"user#1").unwrap().unwrap().get("name"), Some(&Value::string("Alice")));
+        let first = session.last_write_seq().unwrap();
+
+        session.put_with_sk(b"user#1", b"profile", ItemBuilder::new().number("age", 30).build()).unwrap();
+        session.delete(b"user#1").unwrap();
+        assert!(session.last_write_seq().unwrap() > first);
+        assert_eq!(session.get(b"user#1").unwrap(), None);
+        assert_eq!(session.query(Query::new(b"user#1")).unwrap().items.len(), 1);
+
+        // In-memory databases give the same guarantee without sequence numbers
+        let db = Database::create_in_memory().unwrap();
+        let session = db.session();
+        session.put(b"k", ItemBuilder::new().number("n", 1).build()).unwrap();
+        assert!(session.get(b"k").unwrap().is_some());
+        assert_eq!(session.last_write_seq(), None);
+    }
+
+    #[test]
+    fn test_database_bulk_load_items_are_queryable() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_max_memtable_records(50);
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+
+        let items = (0..2_000).map(|i| {
+            let key = Key::with_sk(
+                Bytes::from(format!("user#{}", i % 20)),
+                Bytes::from(format!("event#{:04}", i)),
+            );
+            (key, ItemBuilder::new().number("n", i).build())
+        });
+        assert_eq!(db.bulk_load(items).unwrap(), 2_000);
+
+        let response = db.query(Query::new(b"user#7")).unwrap();
+        assert_eq!(response.items.len(), 100);
+        assert_eq!(
+            db.get_with_sk(b"user#3", b"event#1003").unwrap().unwrap().get("n"),
+            Some(&Value::number(1003))
+        );
+
+        // Regular writes afterwards get later sequence numbers, across reopens
+        drop(db);
+        let db = Database::open(dir.path()).unwrap();
+        assert_eq!(db.scan(Scan::new()).unwrap().items.len(), 2_000);
+        assert!(db.last_seq().unwrap() >= 2_000);
+        db.put_with_sk(b"user#3", b"event#1003", ItemBuilder::new().number("n", -1).build()).unwrap();
+        assert_eq!(
+            db.get_with_sk(b"user#3", b"event#1003").unwrap().unwrap().get("n"),
+            Some(&Value::number(-1))
+        );
+
+        // Once the WAL holds writes, a bulk load could be shadowed by them
+        let more = vec![(Key::new(Bytes::from_static(b"late")), Item::new())];
+        assert!(matches!(db.bulk_load(more), Err(kstone_core::Error::InvalidArgument(_))));
+        assert!(Database::create_in_memory().unwrap().bulk_load(Vec::new()).is_err());
+    }
+
+    #[test]
+    fn test_database_bulk_load_is_not_silently_missing_from_history() {
+        use std::time::Duration;
+
+        let dir = TempDir::new().unwrap();
+        let src = dir.path().join("src");
+        let db = Database::create(&src).unwrap();
+
+        let items = (0..10).map(|i| {
+            (Key::new(Bytes::from(format!("user#{}", i))), ItemBuilder::new().number("n", i).build())
+        });
+        assert_eq!(db.bulk_load(items).unwrap(), 10);
+        let loaded = db.last_seq().unwrap();
+        db.put(b"user#0", ItemBuilder::new().number("n", -1).build()).unwrap();
+
+        // Recovery cannot replay the bulk-loaded items, so it refuses
+        for seq in [loaded, db.last_seq().unwrap()] {
+            let dest = dir.path().join(format!("dest-{}", seq));
+            assert!(matches!(
+                Database::recover_to_seq(&src, &dest, seq),
+                Err(KeystoneError::SeqTruncated { oldest, .. }) if oldest == loaded + 1
+            ));
+        }
+
+        // Reads of history within the load fail; later history still works
+        assert!(matches!(db.get_as_of(b"user#0", None, loaded), Err(KeystoneError::SeqTruncated { .. })));
+        assert!(matches!(db.snapshot_diff(1, Vec::new()), Err(KeystoneError::SeqTruncated { .. })));
+        let mut diff = Vec::new();
+        db.snapshot_diff(loaded, &mut diff).unwrap();
+        assert_eq!(String::from_utf8(diff).unwrap().lines().count(), 2);
+
+        // Tails starting inside the load are told they missed it, across reopens
+        drop(db);
+        let db = Database::open(&src).unwrap();
+        let mut tail = db.tail_wal(1).unwrap();
+        match tail.next_timeout(Duration::from_millis(10)) {
+            Some(WalTailEvent::Gap { from_seq, resume_seq }) => {
+                assert_eq!(from_seq, 1);
+                assert_eq!(resume_seq, loaded + 1);
+            }
+            other => panic!("expected gap, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_database_scan_workers_sum_matches_serial() {
+        use std::sync::atomic::{AtomicU64, AtomicUsize, Ordering};
+
+        const ITEMS: u64 = 100_000;
+        let db = Database::create_in_memory().unwrap();
+        for i in 0..ITEMS {
+            let pk = format!("item#{:06}", i);
+            db.put(pk.as_bytes(), ItemBuilder::new().number("n", i % 1000).build()).unwrap();
+        }
+
+        let serial: u64 = db
+            .scan(Scan::new())
+            .unwrap()
+            .items
+            .iter()
+            .map(|item| item.get("n").unwrap().as_number().unwrap() as u64)
+            .sum();
+
+        let total = AtomicU64::new(0);
+        let seen = AtomicUsize::new(0);
+        db.scan_workers(Scan::new(), 8, |item| {
+            let n = item.get("n").and_then(|v| v.as_number()).unwrap() as u64;
+            total.fetch_add(n, Ordering::Relaxed);
+            seen.fetch_add(1, Ordering::Relaxed);
+            Ok(())
+        })
+        .unwrap();
+        assert_eq!(seen.load(Ordering::Relaxed), ITEMS as usize);
+        assert_eq!(total.load(Ordering::Relaxed), serial);
+
+        // Filters and limits apply as for a plain scan
+        let seen = AtomicUsize::new(0);
+        db.scan_workers(Scan::new().filter("n < :max").value(":max", Value::number(10)), 4, |_| {
+            seen.fetch_add(1, Ordering::Relaxed);
+            Ok(())
+        })
+        .unwrap();
+        assert_eq!(seen.load(Ordering::Relaxed), 1000);
+
+        let seen = AtomicUsize::new(0);
+        db.scan_workers(Scan::new().limit(2500), 4, |_| {
+            seen.fetch_add(1, Ordering::Relaxed);
+            Ok(())
+        })
+        .unwrap();
+        assert_eq!(seen.load(Ordering::Relaxed), 2500);
+
+        assert!(db.scan_workers(Scan::new(), 0, |_| Ok(())).is_err());
+    }
+
+    #[test]
+    fn test_database_scan_workers_stops_on_first_error() {
+        use std::sync::atomic::{AtomicUsize, Ordering};
+
+        let db = Database::create_in_memory().unwrap();
+        for i in 0..10_000 {
+            let pk = format!("item#{:05}", i);
+            db.put(pk.as_bytes(), ItemBuilder::new().number("n", i).build()).unwrap();
+        }
+
+        let processed = AtomicUsize::new(0);
+        let err = db
+            .scan_workers(Scan::new(), 4, |item| {
+                processed.fetch_add(1, Ordering::Relaxed);
+                if item.get("n").and_then(|v| v.as_number()) == Some(100.0) {
+                    return Err(kstone_core::Error::InvalidArgument("bad item".to_string()));
+                }
+                Ok(())
+            })
+            .unwrap_err();
+        assert!(matches!(err, kstone_core::Error::InvalidArgument(ref msg) if msg == "bad item"));
+        assert!(processed.load(Ordering::Relaxed) < 10_000);
+    }
+
+    #[test]
+    fn test_database_verify_reports_clean_and_corrupt_files() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        for i in 0..50 {
+            let pk = format!("user#{:03}", i);
+            db.put(pk.as_bytes(), ItemBuilder::new().string("name", format!("User {}", i)).build()).unwrap();
+        }
+        db.flush().unwrap();
+
+        let report = db.verify().unwrap();
+        assert!(report.is_clean(), "{:?}", report.problems);
+        assert!(report.sst_files_checked > 0);
+        assert_eq!(report.wal_records_checked, 50);
+
+        // Flip a byte in the middle of one SST file
+        let sst_path = std::fs::read_dir(dir.path())
+            .unwrap()
+            .map(|entry| entry.unwrap().path())
+            .find(|path| path.extension().map_or(false, |ext| ext == "sst"))
+            .unwrap();
+        let mut bytes = std::fs::read(&sst_path).unwrap();
+        let middle = bytes.len() / 2;
+        bytes[middle] ^= 0xff;
+        std::fs::write(&sst_path, &bytes).unwrap();
+
+        let report = db.verify().unwrap();
+        assert!(!report.is_clean());
+        assert_eq!(report.problems.len(), 1);
+        assert_eq!(report.problems[0].path, sst_path);
+
+        // Reads keep being served from what was loaded at open
+        assert!(db.get(b"user#001").unwrap().is_some());
+
+        // Damage in the WAL is reported too
+        let wal_path = dir.path().join("wal.log");
+        let mut bytes = std::fs::read(&wal_path).unwrap();
+        bytes[16 + 12 + 4] ^= 0xff;
+        std::fs::write(&wal_path, &bytes).unwrap();
+
+        let report = db.verify().unwrap();
+        assert_eq!(report.problems.len(), 2);
+        assert!(report.problems.iter().any(|problem| problem.path == wal_path));
+
+        assert!(Database::create_in_memory().unwrap().verify().unwrap().is_clean());
+    }
+
+    #[test]
+    fn test_database_repair_recovers_undamaged_items() {
+        let dir = TempDir::new().unwrap();
+        let src = dir.path().join("damaged");
+        {
+            let db = Database::create(&src).unwrap();
+            for i in 0..50 {
+                let pk = format!("user#{:03}", i);
+                db.put(pk.as_bytes(), ItemBuilder::new().string("name", format!("User {}", i)).build()).unwrap();
+            }
+            db.flush().unwrap();
+        }
+
+        // Damage one SST file and the WAL's first record, so the items of
+        // that file have no other copy
+        let sst_path = std::fs::read_dir(&src)
+            .unwrap()
+            .map(|entry| entry.unwrap().path())
+            .find(|path| path.extension().map_or(false, |ext| ext == "sst"))
+            .unwrap();
+        let mut bytes = std::fs::read(&sst_path).unwrap();
+        let middle = bytes.len() / 2;
+        bytes[middle] ^= 0xff;
+        std::fs::write(&sst_path, &bytes).unwrap();
+
+        let wal_path = src.join("wal.log");
+        let mut bytes = std::fs::read(&wal_path).unwrap();
+        bytes[16 + 12 + 4] ^= 0xff;
+        std::fs::write(&wal_path, &bytes).unwrap();
+
+        let dst = dir.path().join("repaired");
+        let report = Database::repair(&src, &dst, &RepairOptions::default()).unwrap();
+        assert!(report.items_recovered > 0);
+        assert!(report.items_lost > 0);
+        assert_eq!(report.items_recovered + report.items_lost, 50);
+        assert_eq!(report.damaged_files.len(), 2);
+        assert!(report.damaged_files.iter().any(|problem| problem.path == sst_path));
+        assert!(report.damaged_files.iter().any(|problem| problem.path == wal_path));
+
+        let repaired = Database::open(&dst).unwrap();
+        assert!(repaired.verify().unwrap().is_clean());
+        let mut found = 0;
+        for i in 0..50 {
+            let pk = format!("user#{:03}", i);
+            if let Some(item) = repaired.get(pk.as_bytes()).unwrap() {
+                assert_eq!(item.get("name"), Some(&Value::string(format!("User {}", i))));
+                found += 1;
+            }
+        }
+        assert_eq!(found, report.items_recovered);
+    }
+
+    #[test]
+    fn test_database_memory_limit_rejects_or_evicts() {
+        let item = |i: usize| ItemBuilder::new().string("payload", format!("{:0100}", i)).build();
+
+        // Each item takes a bit over 100 bytes, so 20 of them overflow 1 KiB
+        let db = Database::create_in_memory_with_limit(1024, EvictionPolicy::Reject).unwrap();
+        let mut stored = 0;
+        let err = loop {
+            match db.put(format!("key#{:02}", stored).as_bytes(), item(stored)) {
+                Ok(()) => stored += 1,
+                Err(e) => break e,
+            }
+            assert!(stored < 20, "limit was never reached");
+        };
+        assert!(matches!(err, KeystoneError::MemoryLimit { limit: 1024, .. }), "{:?}", err);
+        assert!(stored > 0);
+        for i in 0..stored {
+            assert!(db.get(format!("key#{:02}", i).as_bytes()).unwrap().is_some());
+        }
+
+        // Deleting makes room again
+        db.delete(b"key#00").unwrap();
+        db.put(format!("key#{:02}", stored).as_bytes(), item(stored)).unwrap();
+
+        let db = Database::create_in_memory_with_limit(1024, EvictionPolicy::EvictLru).unwrap();
+        for i in 0..20 {
+            db.put(format!("key#{:02}", i).as_bytes(), item(i)).unwrap();
+        }
+        assert!(db.get(b"key#00").unwrap().is_none());
+        assert!(db.get(b"key#17").unwrap().is_some());
+        assert!(db.get(b"key#18").unwrap().is_some());
+        assert!(db.get(b"key#19").unwrap().is_some());
+
+        // A read counts as use, so the item read survives the next evictions
+        let kept = (0..20)
+            .find(|i| db.get(format!("key#{:02}", i).as_bytes()).unwrap().is_some())
+            .unwrap();
+        for i in 20..23 {
+            db.put(format!("key#{:02}", i).as_bytes(), item(i)).unwrap();
+        }
+        assert!(db.get(format!("key#{:02}", kept).as_bytes()).unwrap().is_some());
+
+        // An item larger than the whole limit can't be stored either way
+        let huge = ItemBuilder::new().string("payload", "x".repeat(2048)).build();
+        assert!(matches!(db.put(b"huge", huge), Err(KeystoneError::MemoryLimit { .. })));
+    }
+
+    #[test]
+    fn test_database_attribute_ttl_expires_only_the_attribute() {
+        use std::time::{Duration, SystemTime};
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        db.put(b"user#1", ItemBuilder::new().string("name", "Alice").build()).unwrap();
+
+        let expires_at = SystemTime::now() + Duration::from_secs(1);
+        db.put_attribute_with_ttl(b"user#1", None, "score", Value::number(42), expires_at).unwrap();
+        db.put_attribute_with_ttl(b"user#1", None, "rank", Value::number(1), expires_at).unwrap();
+        // Setting it again without a TTL makes it permanent
+        db.put_number(b"user#1", None, "rank", 2.0).unwrap();
+
+        let item = db.get(b"user#1").unwrap().unwrap();
+        assert_eq!(item.get("score"), Some(&Value::number(42)));
+        assert_eq!(item.get("rank"), Some(&Value::number(2)));
+
+        std::thread::sleep(Duration::from_millis(1100));
+
+        let item = db.get(b"user#1").unwrap().unwrap();
+        assert!(item.get("score").is_none());
+        assert_eq!(item.get("name"), Some(&Value::string("Alice")));
+        assert_eq!(item.get("rank"), Some(&Value::number(2)));
+
+        // Queries and scans leave it out too
+        let response = db.query(Query::new(b"user#1")).unwrap();
+        assert!(response.items[0].get("score").is_none());
+        let response = db.scan(Scan::new()).unwrap();
+        assert!(response.items[0].get("score").is_none());
+
+        // The next update drops the expired value for good
+        db.put_bool(b"user#1", None, "active", true).unwrap();
+        db.flush().unwrap();
+        assert!(db.get(b"user#1").unwrap().unwrap().get("score").is_none());
+    }
+
+    #[test]
+    fn test_database_partitions_yields_each_partition_once() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        for tenant in (0..10).rev() {
+            for n in 0..5 {
+                let pk = format!("tenant#{}", tenant);
+                let sk = format!("item#{}", n);
+                db.put_with_sk(pk.as_bytes(), sk.as_bytes(), ItemBuilder::new().number("n", n).build()).unwrap();
+            }
+            if tenant == 4 {
+                db.flush().unwrap();
+            }
+        }
+        // A partition whose items are all deleted is gone
+        db.put(b"removed", ItemBuilder::new().number("n", 0).build()).unwrap();
+        db.delete(b"removed").unwrap();
+
+        let expected: Vec<Bytes> = (0..10).map(|tenant| Bytes::from(format!("tenant#{}", tenant))).collect();
+        let partitions = db.partitions().unwrap();
+        assert_eq!(partitions.len(), 10);
+        assert_eq!(partitions.collect::<Vec<_>>(), expected);
+
+        let db = Database::create_in_memory().unwrap();
+        db.put_with_sk(b"b", b"1", ItemBuilder::new().number("n", 1).build()).unwrap();
+        db.put_with_sk(b"a", b"1", ItemBuilder::new().number("n", 1).build()).unwrap();
+        db.put_with_sk(b"a", b"2", ItemBuilder::new().number("n", 2).build()).unwrap();
+        assert_eq!(db.partitions().unwrap().collect::<Vec<_>>(), vec![Bytes::from("a"), Bytes::from("b")]);
+    }
+
+    #[test]
+    fn test_database_cancelled_token_fails_before_doing_work() {
+        use std::time::Duration;
+
+        let db = Database::create_in_memory().unwrap();
+        let mut item = HashMap::new();
+        item.insert("name".to_string(), Value::string("Alice"));
+        db.put(b"user#1", item.clone()).unwrap();
+
+        let cancel = CancelToken::new();
+        assert!(db.get_cancellable(&cancel, b"user#1", None).unwrap().is_some());
+
+        cancel.clone().cancel();
+        assert!(cancel.is_cancelled());
+        let err = db.get_cancellable(&cancel, b"user#1", None).unwrap_err();
+        assert!(matches!(err, KeystoneError::Canceled(_)), "{:?}", err);
+        assert!(matches!(db.put_cancellable(&cancel, b"user#2", None, item), Err(KeystoneError::Canceled(_))));
+        assert!(matches!(db.delete_cancellable(&cancel, b"user#1", None), Err(KeystoneError::Canceled(_))));
+        assert!(db.get(b"user#1").unwrap().is_some());
+        assert!(db.get(b"user#2").unwrap().is_none());
+
+        let expired = CancelToken::with_timeout(Duration::ZERO);
+        assert!(matches!(db.get_cancellable(&expired, b"user#1", None), Err(KeystoneError::Canceled(_))));
+    }
+
+    #[test]
+    fn test_database_put_if_version_returns_current_item_to_stale_writer() {
+        let db = Database::create_in_memory().unwrap();
+        let doc = |version: i64, body: &str| {
+            let mut item = HashMap::new();
+            item.insert("version".to_string(), Value::number(version));
+            item.insert("body".to_string(), Value::string(body));
+            item
+        };
+
+        let (_, stored) = db.put_if_version(b"doc#1", None, doc(1, "draft"), "version", None).unwrap();
+        assert!(stored);
+
+        // Two writers read version 1; the first one wins
+        let (_, stored) = db
+            .put_if_version(b"doc#1", None, doc(2, "winner"), "version", Some(Value::number(1)))
+            .unwrap();
+        assert!(stored);
+        let (current, stored) = db
+            .put_if_version(b"doc#1", None, doc(2, "stale"), "version", Some(Value::number(1)))
+            .unwrap();
+        assert!(!stored);
+        let current = current.unwrap();
+        assert_eq!(current.get("body"), Some(&Value::string("winner")));
+
+        // The stale writer retries against the item it got back
+        let version = current.get("version").cloned();
+        let (stored_item, stored) = db
+            .put_if_version(b"doc#1", None, doc(3, "merged"), "version", version)
+            .unwrap();
+        assert!(stored);
+        assert_eq!(stored_item, Some(doc(3, "merged")));
+        assert_eq!(db.get(b"doc#1").unwrap(), Some(doc(3, "merged")));
+    }
+
+    #[test]
+    fn test_database_batch_get_partial_returns_an_entry_per_key() {
+        let db = Database::create_in_memory().unwrap();
+        let mut item = HashMap::new();
+        item.insert("name".to_string(), Value::string("Alice"));
+        db.put(b"user#1", item.clone()).unwrap();
+        db.put_with_sk(b"user#3", b"profile", item.clone()).unwrap();
+
+        let request = BatchGetRequest::new()
+            .add_key(b"user#1")
+            .add_key(b"user#2")
+            .add_key_with_sk(b"user#3", b"profile");
+        let results = db.batch_get_partial(request);
+
+        assert_eq!(results.len(), 3);
+        assert_eq!(results[0].as_ref().unwrap(), &Some(item.clone()));
+        assert_eq!(results[1].as_ref().unwrap(), &None);
+        assert_eq!(results[2].as_ref().unwrap(), &Some(item));
+    }
+
+    #[test]
+    fn test_database_query_reports_consumed_capacity() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        for i in 0..10 {
+            let sk = format!("item#{:03}", i);
+            let item = ItemBuilder::new().string("body", "x".repeat(2000)).build();
+            db.put_with_sk(b"user#123", sk.as_bytes(), item).unwrap();
+        }
+
+        let response = db.query(Query::new(b"user#123")).unwrap();
+        assert!(response.consumed_capacity.is_none());
+
+        let response = db
+            .query(Query::new(b"user#123").return_consumed_capacity(true))
+            .unwrap();
+        let capacity = response.consumed_capacity.unwrap();
+        assert!(capacity.read_units >= 5, "{:?}", capacity);
+        assert_eq!(capacity.write_units, 0);
+    }
+
+    #[test]
+    fn test_database_snapshot_diff_restores_base_plus_changes() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        db.put(b"user#1", ItemBuilder::new().number("age", 30).build()).unwrap();
+        db.put(b"user#2", ItemBuilder::new().number("age", 40).build()).unwrap();
+        db.put_with_sk(b"user#1", b"order#1", ItemBuilder::new().string("sku", "a").build()).unwrap();
+
+        let mut base = Vec::new();
+        let base_seq = db.snapshot_diff(0, &mut base).unwrap();
+        assert_eq!(base_seq, db.last_seq().unwrap());
+
+        db.put(b"user#1", ItemBuilder::new().number("age", 31).build()).unwrap();
+        db.delete(b"user#2").unwrap();
+        db.put(b"user#3", ItemBuilder::new().number("age", 20).build()).unwrap();
+        db.put(b"user#3", ItemBuilder::new().number("age", 21).build()).unwrap();
+
+        let mut diff = Vec::new();
+        let diff_seq = db.snapshot_diff(base_seq, &mut diff).unwrap();
+        assert_eq!(diff_seq, db.last_seq().unwrap());
+
+        let restore_dir = TempDir::new().unwrap();
+        let restored = Database::create(restore_dir.path()).unwrap();
+        assert_eq!(restored.apply_diff(base.as_slice()).unwrap(), 3);
+        // Only the latest write to each changed key is carried
+        assert_eq!(restored.apply_diff(diff.as_slice()).unwrap(), 3);
+
+        assert_eq!(restored.scan_with_keys(usize::MAX).unwrap(), db.scan_with_keys(usize::MAX).unwrap());
+        assert!(restored.get(b"user#2").unwrap().is_none());
+        assert_eq!(restored.get(b"user#3").unwrap().unwrap().get("age"), Some(&Value::number(21)));
+
+        // Nothing changed since the last diff
+        let mut empty = Vec::new();
+        assert_eq!(db.snapshot_diff(diff_seq, &mut empty).unwrap(), diff_seq);
+        assert_eq!(restored.apply_diff(empty.as_slice()).unwrap(), 0);
+    }
+
+    #[test]
+    fn test_database_delete_by_query() {
+        let db = Database::create_in_memory().unwrap();
+        for i in 0..250 {
+            let status = if i % 5 == 0 { "active" } else { "expired" };
+            let sk = format!("session#{:03}", i);
+            db.put_with_sk(b"user#1", sk.as_bytes(), ItemBuilder::new().string("status", status).build())
+                .unwrap();
+        }
+        db.put_with_sk(b"user#1", b"profile", ItemBuilder::new().string("status", "expired").build())
+            .unwrap();
+
+        let query = Query::new(b"user#1")
+            .sk_begins_with(b"session#")
+            .filter("#s = :expired")
+            .name("#s", "status")
+            .value(":expired", Value::string("expired"));
+        assert_eq!(db.delete_by_query(query).unwrap(), 200);
+
+        assert_eq!(db.count_partition(b"user#1").unwrap(), 51);
+        assert!(db.get_with_sk(b"user#1", b"profile").unwrap().is_some());
+
+        let index_query = Query::new(b"user#1").index("by-status");
+        assert!(matches!(db.delete_by_query(index_query), Err(KeystoneError::InvalidArgument(_))));
+    }
+
+    #[test]
+    fn test_database_delete_by_query_counts_only_applied_deletes() {
+        let dir = TempDir::new().unwrap();
+        let db = std::sync::Arc::new(Database::create(dir.path()).unwrap());
+        for i in 0..300 {
+            let sk = format!("session#{:03}", i);
+            db.put_with_sk(b"user#1", sk.as_bytes(), ItemBuilder::new().string("status", "expired").build())
+                .unwrap();
+        }
+
+        // Overlapping deletes race for the same items; each is counted once
+        let handles: Vec<_> = (0..4)
+            .map(|_| {
+                let db = db.clone();
+                std::thread::spawn(move || {
+                    let query = Query::new(b"user#1")
+                        .filter("#s = :expired")
+                        .name("#s", "status")
+                        .value(":expired", Value::string("expired"));
+                    db.delete_by_query(query).unwrap()
+                })
+            })
+            .collect();
+        let deleted: u64 = handles.into_iter().map(|h| h.join().unwrap()).sum();
+        assert_eq!(deleted, 300);
+        assert_eq!(db.count_partition(b"user#1").unwrap(), 0);
+
+        // An item that stops matching after it was read is left alone
+        db.put_with_sk(b"user#2", b"a", ItemBuilder::new().string("status", "expired").build()).unwrap();
+        let query = Query::new(b"user#2")
+            .filter("#s = :expired")
+            .name("#s", "status")
+            .value(":expired", Value::string("expired"));
+        let read = vec![(
+            Key::with_sk(Bytes::from_static(b"user#2"), Bytes::from_static(b"a")),
+            ItemBuilder::new().string("status", "expired").build(),
+        )];
+        db.put_with_sk(b"user#2", b"a", ItemBuilder::new().string("status", "active").build()).unwrap();
+        assert_eq!(db.delete_matching(&read, &query.read_filter()).unwrap(), 0);
+        assert!(db.get_with_sk(b"user#2", b"a").unwrap().is_some());
+    }
+
+    #[test]
+    fn test_database_append_to_list_loses_no_concurrent_appends() {
+        let dir = TempDir::new().unwrap();
+        let db = std::sync::Arc::new(Database::create(dir.path()).unwrap());
+
+        let handles: Vec<_> = (0..8)
+            .map(|t| {
+                let db = db.clone();
+                std::thread::spawn(move || {
+                    for i in 0..25 {
+                        let event = Value::string(format!("t{}-{}", t, i));
+                        db.append_to_list(b"order#1", None, "history", vec![event]).unwrap();
+                    }
+                })
+            })
+            .collect();
+        for handle in handles {
+            handle.join().unwrap();
+        }
+
+        let item = db.get(b"order#1").unwrap().unwrap();
+        let history = match item.get("history") {
+            Some(Value::L(history)) => history.clone(),
+            other => panic!("expected a list, got {:?}", other),
+        };
+        assert_eq!(history.len(), 200);
+        let distinct: std::collections::HashSet<_> = history.iter().map(|v| format!("{:?}", v)).collect();
+        assert_eq!(distinct.len(), 200);
+
+        // Appending to an attribute that isn't a list fails
+        db.put_number(b"order#1", None, "total", 5.0).unwrap();
+        assert!(db.append_to_list(b"order#1", None, "total", vec![Value::number(1)]).is_err());
+    }
+
+    #[test]
+    fn test_database_event_handler_sees_flushes_and_compactions() {
+        let dir = TempDir::new().unwrap();
+        let events = std::sync::Arc::new(std::sync::Mutex::new(Vec::new()));
+        let seen = events.clone();
+        let config = DatabaseConfig::new().with_event_handler(move |event| seen.lock().unwrap().push(event.clone()));
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+
+        for i in 0..10 {
+            let sk = format!("item#{}", i);
+            db.put_with_sk(b"user#1", sk.as_bytes(), ItemBuilder::new().string("name", "x".repeat(100)).build())
+                .unwrap();
+        }
+        db.flush().unwrap();
+
+        let flushed = events.lock().unwrap().clone();
+        assert!(matches!(flushed[0], EngineEvent::FlushStarted { records: 10, .. }), "{:?}", flushed);
+        match &flushed[1] {
+            EngineEvent::FlushCompleted { records, bytes, .. } => {
+                assert_eq!(*records, 10);
+                assert!(*bytes > 1000, "{} bytes", bytes);
+            }
+            other => panic!("expected flush completed, got {:?}", other),
+        }
+
+        // Purging flushes the tombstones and compacts them away
+        db.purge_prefix(b"user#1", b"").unwrap();
+        let compacted = events.lock().unwrap().iter().any(|event| {
+            matches!(event, EngineEvent::CompactionCompleted { ssts: 2, bytes_read, .. } if *bytes_read > 0)
+        });
+        assert!(compacted, "{:?}", events.lock().unwrap());
+    }
+
+    #[test]
+    fn test_database_subscribe_delivers_matching_changes() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        let mut changes = db.subscribe(b"user#", 16).unwrap();
+
+        let item = |n: i64| ItemBuilder::new().number("n", n).build();
+        db.put(b"user#1", item(1)).unwrap();
+        db.put(b"order#1", item(2)).unwrap();
+        db.put(b"user#1", item(3)).unwrap();
+        db.delete(b"order#1").unwrap();
+        db.delete(b"user#1").unwrap();
+        // Deleting a missing item is not a change
+        db.delete(b"user#2").unwrap();
+
+        let mut events = Vec::new();
+        while let Some(event) = changes.try_next() {
+            events.push(event);
+        }
+        let kinds: Vec<_> = events.iter().map(|e| (e.kind, e.key.pk.clone())).collect();
+        assert_eq!(
+            kinds,
+            vec![
+                (ChangeKind::Insert, Bytes::from("user#1")),
+                (ChangeKind::Modify, Bytes::from("user#1")),
+                (ChangeKind::Remove, Bytes::from("user#1")),
+            ]
+        );
+        assert_eq!(events[1].item.as_ref().and_then(|item| item.get("n")), Some(&Value::number(3)));
+        assert_eq!(changes.dropped(), 0);
+    }
+
+    #[test]
+    fn test_database_dry_run_rejects_schema_violations() {
+        use kstone_core::expression::ExpressionContext;
+
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+        db.set_schema(vec![AttributeSchema::new("email", AttributeType::String).required()]).unwrap();
+
+        let missing = ItemBuilder::new().string("name", "Alice").build();
+        assert!(matches!(
+            db.put_dry_run(b"user#1", None, missing.clone(), None, ExpressionContext::new()),
+            Err(KeystoneError::SchemaValidation { ref attribute, .. }) if attribute == "email"
+        ));
+        assert!(matches!(
+            db.transact_write_dry_run(TransactWriteRequest::new().put(b"user#1", missing)),
+            Err(KeystoneError::SchemaValidation { ref attribute, .. }) if attribute == "email"
+        ));
+
+        let valid = ItemBuilder::new().string("email", "alice@example.com").build();
+        let outcome = db.put_dry_run(b"user#1", None, valid, None, ExpressionContext::new()).unwrap();
+        assert!(outcome.condition_passed);
+        assert!(db.get(b"user#1").unwrap().is_none());
+    }
+
+    #[test]
+    fn test_database_in_memory_rejects_projected_indexes() {
+        let schema = || {
+            TableSchema::new().add_local_index(
+                LocalSecondaryIndex::new("email-index", "email").include(vec!["name".to_string()]),
+            )
+        };
+        let item = ItemBuilder::new()
+            .string("email", "ada@example.com")
+            .string("name", "Ada")
+            .string("bio", "Engineer")
+            .build();
+
+        // The disk engine serves the projection
+        let dir = TempDir::new().unwrap();
+        let disk = Database::create_with_schema(dir.path(), schema()).unwrap();
+        disk.put_with_sk(b"org#acme", b"user#1", item.clone()).unwrap();
+        let projected = disk.query(Query::new(b"org#acme").index("email-index")).unwrap();
+        assert_eq!(projected.items.len(), 1);
+        assert!(!projected.items[0].contains_key("bio"));
+
+        // The memory engine refuses instead of returning unprojected items
+        assert!(matches!(
+            Database::create_in_memory_with_schema(schema()),
+            Err(KeystoneError::InvalidArgument(_))
+        ));
+        let memory = Database::create_in_memory().unwrap();
+        memory.put_with_sk(b"org#acme", b"user#1", item).unwrap();
+        assert!(matches!(
+            memory.query(Query::new(b"org#acme").index("email-index")),
+            Err(KeystoneError::InvalidArgument(_))
+        ));
+    }
+
+    #[test]
+    fn test_database_query_and_scan_sum() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        db.put_with_sk(b"order#1", b"line#1", ItemBuilder::new().number("amount", 10).string("status", "paid").build()).unwrap();
+        db.put_with_sk(b"order#1", b"line#2", ItemBuilder::new().number("amount", 2.5).string("status", "paid").build()).unwrap();
+        db.put_with_sk(b"order#1", b"line#3", ItemBuilder::new().string("amount", "lots").string("status", "paid").build()).unwrap();
+        db.put_with_sk(b"order#1", b"line#4", ItemBuilder::new().string("status", "paid").build()).unwrap();
+        db.put_with_sk(b"order#1", b"line#5", ItemBuilder::new().number("amount", 100).string("status", "void").build()).unwrap();
+
+        // Non-numeric and missing amounts are skipped; the filter applies first
+        let response = db
+            .query(
+                Query::new(b"order#1")
+                    .filter("#s = :paid")
+                    .name("#s", "status")
+                    .value(":paid", Value::string("paid"))
+                    .select(Select::Count)
+                    .sum("amount"),
+            )
+            .unwrap();
+        assert!(response.items.is_empty());
+        assert_eq!(response.count, 4);
+        assert_eq!(response.sum, Some(Value::number(12.5)));
+
+        let response = db.scan(Scan::new().sum("amount")).unwrap();
+        assert_eq!(response.items.len(), 5);
+        assert_eq!(response.sum, Some(Value::number(112.5)));
+
+        assert_eq!(db.query(Query::new(b"order#1")).unwrap().sum, None);
+        assert_eq!(db.query(Query::new(b"order#2").sum("amount")).unwrap().sum, Some(Value::number(0)));
+    }
+
+    #[test]
+    fn test_database_session_ignores_others_staged_writes() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_group_commit_window(std::time::Duration::from_millis(300));
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+        let session = db.session();
+        session.put(b"user#1", ItemBuilder::new().number("n", 1).build()).unwrap();
+        assert_eq!(session.last_write_seq(), Some(1));
+
+        // Another writer's write waits for its sync; the session's reads
+        // neither fail on it nor see it
+        std::thread::scope(|s| {
+            let writer = s.spawn(|| db.put(b"user#2", ItemBuilder::new().number("n", 2).build()));
+            std::thread::sleep(std::time::Duration::from_millis(50));
+            assert_eq!(db.last_seq().unwrap(), 2);
+            assert!(session.get(b"user#1").unwrap().is_some());
+            assert!(session.get(b"user#2").unwrap().is_none());
+            writer.join().unwrap().unwrap();
+        });
+        assert!(session.get(b"user#2").unwrap().is_some());
+        assert_eq!(session.last_write_seq(), Some(1));
+    }
 }
 
 
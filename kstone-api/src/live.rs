@@ -0,0 +1,61 @@
+/// Live queries: a query that keeps delivering new matching items
+///
+/// Opening a live query returns the items the query matches now and then
+/// follows committed writes, yielding each new or updated item in the
+/// partition that matches the query's sort key condition and filter. This
+/// suits append-only partitions such as event logs, where it tails the
+/// log. Deletes are not reported. Following writes uses the WAL tail, so
+/// live queries need a disk database.
+
+use crate::query::Query;
+use kstone_core::{Error, Item, Result, WalTail, WalTailEvent};
+use std::time::{Duration, Instant};
+
+/// A query that follows new writes
+pub struct LiveQuery {
+    query: Query,
+    initial: Vec<Item>,
+    tail: WalTail,
+}
+
+impl LiveQuery {
+    pub(crate) fn new(query: Query, initial: Vec<Item>, tail: WalTail) -> Self {
+        Self { query, initial, tail }
+    }
+
+    /// Take the items the query matched when it was opened
+    ///
+    /// Returns them once; later calls return an empty list.
+    pub fn initial_items(&mut self) -> Vec<Item> {
+        std::mem::take(&mut self.initial)
+    }
+
+    /// Next matching item written since the query was opened, waiting up
+    /// to `timeout` for one
+    ///
+    /// An item written while the query was being opened can appear both in
+    /// the initial items and here. Fails if the query fell so far behind
+    /// that writes were dropped from the retained history.
+    pub fn next_timeout(&mut self, timeout: Duration) -> Result<Option<Item>> {
+        let deadline = Instant::now() + timeout;
+        loop {
+            let remaining = deadline.saturating_duration_since(Instant::now());
+            match self.tail.next_timeout(remaining) {
+                None => return Ok(None),
+                Some(WalTailEvent::Record(record)) => {
+                    if let Some(item) = record.value {
+                        if self.query.matches(&record.key, &item)? {
+                            return Ok(Some(item));
+                        }
+                    }
+                }
+                Some(WalTailEvent::Gap { from_seq, resume_seq }) => {
+                    return Err(Error::ResourceExhausted(format!(
+                        "Live query fell behind: writes {}..{} were dropped",
+                        from_seq, resume_seq
+                    )));
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,227 @@
+/// Distributed locks built on conditional writes
+///
+/// A lock is a single item holding its owner, a fencing token and an expiry
+/// time. Acquiring, refreshing and releasing are conditional writes, so two
+/// holders can never both succeed. A lock whose expiry has passed may be
+/// taken over; the fencing token increases with every acquisition so that
+/// work done under a stale lock can be rejected downstream. Releasing clears
+/// the owner and expiry but keeps the item and its fencing token, so the
+/// token keeps increasing across release and re-acquire.
+
+use crate::Database;
+use bytes::Bytes;
+use kstone_core::expression::{ExpressionContext, ExpressionParser, UpdateAction, UpdateValue};
+use kstone_core::{Error, Item, Key, Result, TransactWriteOperation, Value};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+/// Partition key prefix for lock items
+pub const LOCK_KEY_PREFIX: &str = "__lock#";
+
+const OWNER_ATTR: &str = "owner";
+const FENCING_ATTR: &str = "fencing_token";
+const EXPIRES_ATTR: &str = "expires_at";
+
+/// A held lock
+///
+/// Dropping a `Lock` does not release it; call `release` (or let it expire).
+pub struct Lock<'a> {
+    db: &'a Database,
+    name: String,
+    key: Key,
+    owner: String,
+    fencing_token: u64,
+    ttl: Duration,
+    expires_at_ms: u64,
+}
+
+impl<'a> Lock<'a> {
+    /// Acquire `name` for `ttl`, taking over an expired lock if necessary
+    ///
+    /// Fails with `ConditionalCheckFailed` if another owner holds an
+    /// unexpired lock or wins a concurrent acquisition.
+    pub(crate) fn acquire(db: &'a Database, name: &str, ttl: Duration) -> Result<Self> {
+        if name.is_empty() {
+            return Err(Error::InvalidArgument("Lock name cannot be empty".into()));
+        }
+
+        let key = Key::new(Bytes::from(format!("{}{}", LOCK_KEY_PREFIX, name)));
+        let owner = new_owner_id();
+        let now = now_ms();
+
+        let mut context = ExpressionContext::new()
+            .with_name("#owner", OWNER_ATTR)
+            .with_name("#fence", FENCING_ATTR);
+
+        let (condition, fencing_token) = match db.get_key(&key)? {
+            None => (ExpressionParser::parse("attribute_not_exists(#owner)")?, 1),
+            // Released: the fence survives, so keep counting from it
+            Some(current) if !current.contains_key(OWNER_ATTR) => {
+                let previous_fence = number_attr(&current, FENCING_ATTR)?;
+                context = context.with_value(":prev_fence", Value::number(previous_fence));
+                (
+                    ExpressionParser::parse("attribute_not_exists(#owner) AND #fence = :prev_fence")?,
+                    previous_fence + 1,
+                )
+            }
+            Some(current) => {
+                let expires_at = number_attr(&current, EXPIRES_ATTR)?;
+                if expires_at > now {
+                    return Err(Error::ConditionalCheckFailed(format!(
+                        "Lock '{}' is held by another owner",
+                        name
+                    )));
+                }
+
+                // Expired: take over only if nobody else did in the meantime
+                let previous_fence = number_attr(&current, FENCING_ATTR)?;
+                let previous_owner = current
+                    .get(OWNER_ATTR)
+                    .cloned()
+                    .ok_or_else(|| Error::Corruption(format!("Lock '{}' has no owner", name)))?;
+                context = context
+                    .with_value(":prev_owner", previous_owner)
+                    .with_value(":prev_fence", Value::number(previous_fence));
+                (
+                    ExpressionParser::parse("#owner = :prev_owner AND #fence = :prev_fence")?,
+                    previous_fence + 1,
+                )
+            }
+        };
+
+        let expires_at_ms = now + ttl.as_millis() as u64;
+        let mut item = Item::new();
+        item.insert(OWNER_ATTR.to_string(), Value::string(owner.clone()));
+        item.insert(FENCING_ATTR.to_string(), Value::number(fencing_token));
+        item.insert(EXPIRES_ATTR.to_string(), Value::number(expires_at_ms));
+
+        let operation = TransactWriteOperation::Put {
+            item,
+            condition: Some(condition),
+        };
+        if !db.write_if(key.clone(), operation, &context)? {
+            return Err(Error::ConditionalCheckFailed(format!(
+                "Lock '{}' was acquired by another owner",
+                name
+            )));
+        }
+
+        Ok(Self {
+            db,
+            name: name.to_string(),
+            key,
+            owner,
+            fencing_token,
+            ttl,
+            expires_at_ms,
+        })
+    }
+
+    /// Lock name
+    pub fn name(&self) -> &str {
+        &self.name
+    }
+
+    /// Fencing token, strictly increasing across acquisitions of this lock
+    pub fn fencing_token(&self) -> u64 {
+        self.fencing_token
+    }
+
+    /// Expiry time in milliseconds since the Unix epoch
+    pub fn expires_at_ms(&self) -> u64 {
+        self.expires_at_ms
+    }
+
+    /// Whether the lock's TTL has passed (another owner may take it over)
+    pub fn is_expired(&self) -> bool {
+        now_ms() >= self.expires_at_ms
+    }
+
+    /// Extend the lock by its TTL from now
+    ///
+    /// Fails with `ConditionalCheckFailed` if the lock was taken over.
+    pub fn refresh(&mut self) -> Result<()> {
+        let expires_at_ms = now_ms() + self.ttl.as_millis() as u64;
+        let context = self
+            .ownership_context()
+            .with_name("#expires", EXPIRES_ATTR)
+            .with_value(":expires", Value::number(expires_at_ms));
+
+        let operation = TransactWriteOperation::Update {
+            actions: vec![UpdateAction::Set(
+                "#expires".to_string(),
+                UpdateValue::Placeholder(":expires".to_string()),
+            )],
+            condition: Some(ExpressionParser::parse("#owner = :owner AND #fence = :fence")?),
+        };
+        if !self.db.write_if(self.key.clone(), operation, &context)? {
+            return Err(self.lost());
+        }
+
+        self.expires_at_ms = expires_at_ms;
+        Ok(())
+    }
+
+    /// Release the lock
+    ///
+    /// Clears the owner and expiry; the fencing token is kept so the next
+    /// acquisition gets a higher one. Fails with `ConditionalCheckFailed` if
+    /// the lock was taken over, in which case the new owner's lock is left
+    /// untouched.
+    pub fn release(self) -> Result<()> {
+        let context = self.ownership_context().with_name("#expires", EXPIRES_ATTR);
+        let operation = TransactWriteOperation::Update {
+            actions: vec![
+                UpdateAction::Remove("#owner".to_string()),
+                UpdateAction::Remove("#expires".to_string()),
+            ],
+            condition: Some(ExpressionParser::parse("#owner = :owner AND #fence = :fence")?),
+        };
+        if !self.db.write_if(self.key.clone(), operation, &context)? {
+            return Err(self.lost());
+        }
+        Ok(())
+    }
+
+    fn ownership_context(&self) -> ExpressionContext {
+        ExpressionContext::new()
+            .with_name("#owner", OWNER_ATTR)
+            .with_name("#fence", FENCING_ATTR)
+            .with_value(":owner", Value::string(self.owner.clone()))
+            .with_value(":fence", Value::number(self.fencing_token))
+    }
+
+    fn lost(&self) -> Error {
+        Error::ConditionalCheckFailed(format!(
+            "Lock '{}' is no longer held (fencing token {})",
+            self.name, self.fencing_token
+        ))
+    }
+}
+
+fn number_attr(item: &Item, name: &str) -> Result<u64> {
+    match item.get(name) {
+        Some(Value::N(n)) => n
+            .parse()
+            .map_err(|_| Error::Corruption(format!("Lock attribute '{}' is not an integer", name))),
+        _ => Err(Error::Corruption(format!("Lock attribute '{}' is missing", name))),
+    }
+}
+
+fn now_ms() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_millis() as u64)
+        .unwrap_or(0)
+}
+
+/// Unique owner id for one acquisition
+fn new_owner_id() -> String {
+    static COUNTER: AtomicU64 = AtomicU64::new(0);
+    format!(
+        "{}-{}-{}",
+        std::process::id(),
+        now_ms(),
+        COUNTER.fetch_add(1, Ordering::Relaxed)
+    )
+}
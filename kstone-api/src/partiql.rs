@@ -3,12 +3,12 @@
 /// Provides a high-level API for executing PartiQL (SQL-compatible) queries against KeystoneDB.
 /// Supports SELECT, INSERT, UPDATE, and DELETE operations.
 
-use crate::{Database, Item, Query, Scan, Update};
+use crate::{Database, Item, PartitionRank, Query, Scan, Select, Update, Value};
 use bytes::Bytes;
 use kstone_core::{
     partiql::{
         PartiQLParser, PartiQLStatement, PartiQLTranslator, SelectTranslation,
-        SortKeyConditionType,
+        SortKeyConditionType, SqlValue,
     },
     Result,
 };
@@ -43,6 +43,64 @@ pub enum ExecuteStatementResponse {
     Delete { success: bool },
 }
 
+/// A parsed PartiQL statement, from `Database::prepare`
+///
+/// Executing a prepared statement skips parsing, so a statement run many
+/// times with different `?` parameters is parsed only once.
+#[derive(Debug, Clone)]
+pub struct PreparedStatement {
+    sql: String,
+    statement: PartiQLStatement,
+}
+
+impl PreparedStatement {
+    /// The statement's SQL text
+    pub fn sql(&self) -> &str {
+        &self.sql
+    }
+
+    /// Number of `?` parameters the statement takes
+    pub fn parameter_count(&self) -> usize {
+        self.statement.parameter_count()
+    }
+}
+
+/// How a PartiQL statement would be executed, from `Database::explain_statement`
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct QueryPlan {
+    /// Access path the statement takes
+    pub operation: PlanOperation,
+    /// Index read instead of the base table, if any
+    pub index_name: Option<String>,
+    /// Items the statement would examine, before filters and LIMIT
+    pub estimated_items: u64,
+}
+
+/// Access path of a query plan
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PlanOperation {
+    /// Query of one partition
+    Query,
+    /// One query per partition key in an `IN` list
+    MultiQuery,
+    /// Scan of the whole table
+    Scan,
+    /// Single-item INSERT, UPDATE or DELETE
+    Write,
+}
+
+impl QueryPlan {
+    /// Whether the statement reads through a secondary index
+    pub fn uses_index(&self) -> bool {
+        self.index_name.is_some()
+    }
+
+    /// Whether the statement scans the whole table
+    pub fn is_full_scan(&self) -> bool {
+        self.operation == PlanOperation::Scan
+    }
+}
+
 impl Database {
     /// Execute a PartiQL statement
     ///
@@ -74,9 +132,53 @@ impl Database {
     /// db.execute_statement(sql).unwrap();
     /// ```
     pub fn execute_statement(&self, sql: &str) -> Result<ExecuteStatementResponse> {
-        // Parse the SQL statement
-        let statement = PartiQLParser::parse(sql)?;
+        // Parse the SQL statement; parameters need a prepared statement
+        let statement = PartiQLParser::parse(sql)?.bind(&[])?;
+        self.execute_parsed(statement)
+    }
+
+    /// Parse a PartiQL statement for repeated execution
+    ///
+    /// Values in WHERE conditions, SET assignments and INSERT maps may be
+    /// written `?` and supplied on each `execute_prepared`. Key and type
+    /// checks that depend on those values happen when it is executed.
+    ///
+    /// # Examples
+    ///
+    /// ```no_run
+    /// use kstone_api::{Database, KeystoneValue};
+    /// use tempfile::TempDir;
+    ///
+    /// let dir = TempDir::new().unwrap();
+    /// let db = Database::create(dir.path()).unwrap();
+    ///
+    /// let lookup = db.prepare("SELECT * FROM users WHERE pk = ?").unwrap();
+    /// for user in ["user#1", "user#2"] {
+    ///     db.execute_prepared(&lookup, &[KeystoneValue::string(user)]).unwrap();
+    /// }
+    /// ```
+    pub fn prepare(&self, sql: &str) -> Result<PreparedStatement> {
+        Ok(PreparedStatement {
+            sql: sql.to_string(),
+            statement: PartiQLParser::parse(sql)?,
+        })
+    }
+
+    /// Execute a prepared statement with values for its parameters, in order
+    ///
+    /// Fails with `InvalidQuery` unless exactly `parameter_count()` values
+    /// are given.
+    pub fn execute_prepared(
+        &self,
+        statement: &PreparedStatement,
+        params: &[Value],
+    ) -> Result<ExecuteStatementResponse> {
+        let params: Vec<SqlValue> = params.iter().map(SqlValue::from_kstone_value).collect();
+        let statement = statement.statement.clone().bind(&params)?;
+        self.execute_parsed(statement)
+    }
 
+    fn execute_parsed(&self, statement: PartiQLStatement) -> Result<ExecuteStatementResponse> {
         // Execute based on statement type
         match statement {
             PartiQLStatement::Select(select_stmt) => {
@@ -311,6 +413,100 @@ impl Database {
             }
         }
     }
+
+    /// Explain how a PartiQL statement would be executed, without running it
+    ///
+    /// Reports whether a SELECT queries partitions (through an index or the
+    /// base table) or scans the whole table, and estimates how many items it
+    /// would examine. Estimates count whole partitions, ignoring sort key
+    /// conditions, filters and LIMIT, so they are an upper bound. Writes
+    /// always touch exactly one item.
+    ///
+    /// # Examples
+    ///
+    /// ```no_run
+    /// use kstone_api::Database;
+    /// use tempfile::TempDir;
+    ///
+    /// let dir = TempDir::new().unwrap();
+    /// let db = Database::create(dir.path()).unwrap();
+    ///
+    /// let plan = db.explain_statement("SELECT * FROM users WHERE age > 30").unwrap();
+    /// assert!(plan.is_full_scan());
+    /// ```
+    pub fn explain_statement(&self, sql: &str) -> Result<QueryPlan> {
+        let statement = PartiQLParser::parse(sql)?;
+
+        let select_stmt = match statement {
+            PartiQLStatement::Select(select_stmt) => select_stmt,
+            PartiQLStatement::Insert(insert_stmt) => {
+                PartiQLTranslator::translate_insert(&insert_stmt)?;
+                return Ok(QueryPlan::write());
+            }
+            PartiQLStatement::Update(update_stmt) => {
+                PartiQLTranslator::translate_update(&update_stmt)?;
+                return Ok(QueryPlan::write());
+            }
+            PartiQLStatement::Delete(delete_stmt) => {
+                PartiQLTranslator::translate_delete(&delete_stmt)?;
+                return Ok(QueryPlan::write());
+            }
+        };
+
+        match PartiQLTranslator::translate_select(&select_stmt)? {
+            SelectTranslation::Query { pk, index_name, .. } => Ok(QueryPlan {
+                operation: PlanOperation::Query,
+                estimated_items: self.estimate_partition(&pk, index_name.as_deref())?,
+                index_name,
+            }),
+            SelectTranslation::MultiGet { keys, index_name } => {
+                let mut estimated_items = 0;
+                for pk in &keys {
+                    estimated_items += self.estimate_partition(pk, index_name.as_deref())?;
+                }
+                Ok(QueryPlan {
+                    operation: PlanOperation::MultiQuery,
+                    index_name,
+                    estimated_items,
+                })
+            }
+            SelectTranslation::Scan { .. } => {
+                let estimated_items = self
+                    .partition_sizes(usize::MAX, PartitionRank::ItemCount)?
+                    .iter()
+                    .map(|stat| stat.items)
+                    .sum();
+                Ok(QueryPlan {
+                    operation: PlanOperation::Scan,
+                    index_name: None,
+                    estimated_items,
+                })
+            }
+        }
+    }
+
+    /// Items in a partition of the base table or of an index
+    fn estimate_partition(&self, pk: &[u8], index_name: Option<&str>) -> Result<u64> {
+        match index_name {
+            // Index entries are stored under encoded keys, so count them
+            // with a query that returns nothing but the count
+            Some(index) => {
+                let query = Query::new(pk).index(index).select(Select::Count);
+                Ok(self.query(query)?.count as u64)
+            }
+            None => self.count_partition(pk),
+        }
+    }
+}
+
+impl QueryPlan {
+    fn write() -> Self {
+        Self {
+            operation: PlanOperation::Write,
+            index_name: None,
+            estimated_items: 1,
+        }
+    }
 }
 
 /// Apply projection to filter items to only include selected attributes
@@ -827,4 +1023,106 @@ mod tests {
             _ => panic!("Expected Select response"),
         }
     }
+
+    #[test]
+    fn test_explain_statement_reports_access_path() {
+        use kstone_core::index::{LocalSecondaryIndex, TableSchema};
+
+        let dir = TempDir::new().unwrap();
+        let schema = TableSchema::new().add_local_index(LocalSecondaryIndex::new("email_index", "email"));
+        let db = Database::create_with_schema(dir.path(), schema).unwrap();
+
+        for i in 0..3 {
+            let sk = format!("user#{}", i);
+            let item = ItemBuilder::new()
+                .string("email", format!("user{}@example.com", i))
+                .number("age", 20 + i)
+                .build();
+            db.put_with_sk(b"org#acme", sk.as_bytes(), item).unwrap();
+        }
+        db.put_with_sk(b"org#other", b"user#0", ItemBuilder::new().number("age", 40).build())
+            .unwrap();
+
+        // Key condition on an index: an index query, nothing executed
+        let plan = db
+            .explain_statement("SELECT * FROM users.email_index WHERE pk = 'org#acme'")
+            .unwrap();
+        assert_eq!(plan.operation, PlanOperation::Query);
+        assert!(plan.uses_index());
+        assert!(!plan.is_full_scan());
+        assert_eq!(plan.index_name.as_deref(), Some("email_index"));
+        assert_eq!(plan.estimated_items, 3);
+
+        // Key condition on the base table
+        let plan = db.explain_statement("SELECT * FROM users WHERE pk = 'org#acme'").unwrap();
+        assert_eq!(plan.operation, PlanOperation::Query);
+        assert!(!plan.uses_index());
+        assert_eq!(plan.estimated_items, 3);
+
+        let plan = db
+            .explain_statement("SELECT * FROM users WHERE pk IN ('org#acme', 'org#other')")
+            .unwrap();
+        assert_eq!(plan.operation, PlanOperation::MultiQuery);
+        assert_eq!(plan.estimated_items, 4);
+
+        // A filter on a non-key attribute scans the whole table
+        let plan = db.explain_statement("SELECT * FROM users WHERE age > 30").unwrap();
+        assert!(plan.is_full_scan());
+        assert!(!plan.uses_index());
+        assert_eq!(plan.estimated_items, 4);
+
+        let plan = db
+            .explain_statement("DELETE FROM users WHERE pk = 'org#acme' AND sk = 'user#0'")
+            .unwrap();
+        assert_eq!(plan.operation, PlanOperation::Write);
+        assert_eq!(plan.estimated_items, 1);
+
+        // Explaining executes nothing
+        assert!(db.get_with_sk(b"org#acme", b"user#0").unwrap().is_some());
+        assert!(db.explain_statement("SELECT FROM").is_err());
+    }
+
+    #[test]
+    fn test_execute_prepared_with_parameters() {
+        let dir = TempDir::new().unwrap();
+        let db = Database::create(dir.path()).unwrap();
+
+        let insert = db
+            .prepare("INSERT INTO users VALUE {'pk': ?, 'name': ?, 'age': ?}")
+            .unwrap();
+        assert_eq!(insert.parameter_count(), 3);
+        for i in 0..3 {
+            db.execute_prepared(
+                &insert,
+                &[
+                    crate::Value::string(format!("user#{}", i)),
+                    crate::Value::string(format!("User{}", i)),
+                    crate::Value::number(20 + i),
+                ],
+            )
+            .unwrap();
+        }
+
+        let lookup = db.prepare("SELECT name FROM users WHERE pk = ?").unwrap();
+        for i in 0..3 {
+            let pk = crate::Value::string(format!("user#{}", i));
+            match db.execute_prepared(&lookup, &[pk]).unwrap() {
+                ExecuteStatementResponse::Select { items, .. } => {
+                    assert_eq!(items.len(), 1);
+                    assert_eq!(items[0].get("name").unwrap().as_string().unwrap(), format!("User{}", i));
+                }
+                _ => panic!("Expected Select response"),
+            }
+        }
+
+        let bump = db.prepare("UPDATE users SET age = age + ? WHERE pk = ?").unwrap();
+        db.execute_prepared(&bump, &[crate::Value::number(10), crate::Value::string("user#1")])
+            .unwrap();
+        let item = db.get(b"user#1").unwrap().unwrap();
+        assert!(matches!(item.get("age"), Some(crate::Value::N(n)) if n == "31"));
+
+        // Wrong parameter counts are rejected, and plain execution takes none
+        assert!(db.execute_prepared(&lookup, &[]).is_err());
+        assert!(db.execute_statement("SELECT * FROM users WHERE pk = ?").is_err());
+    }
 }
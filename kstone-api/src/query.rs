@@ -2,12 +2,17 @@
 ///
 /// Provides a high-level API for querying items within a partition.
 
-use kstone_core::{Item, Key, iterator::{QueryParams, QueryResult, SortKeyCondition}};
+use crate::filter::{ReadFilter, Select};
+use kstone_core::{ConsumedCapacity, Item, Key, Value, iterator::{QueryParams, QueryResult, SortKeyCondition}};
 use bytes::Bytes;
 
 /// Query builder
+#[derive(Clone)]
 pub struct Query {
     params: QueryParams,
+    fetch_full_items: bool,
+    filter: ReadFilter,
+    return_consumed_capacity: bool,
 }
 
 impl Query {
@@ -15,6 +20,9 @@ impl Query {
     pub fn new(pk: &[u8]) -> Self {
         Self {
             params: QueryParams::new(Bytes::copy_from_slice(pk)),
+            fetch_full_items: false,
+            filter: ReadFilter::default(),
+            return_consumed_capacity: false,
         }
     }
 
@@ -117,6 +125,84 @@ impl Query {
         self
     }
 
+    /// Return complete base-table items for an index query
+    ///
+    /// Index matches are read through to the base table in one batched
+    /// lookup, so attributes outside the index projection are included.
+    /// Index order is preserved; matches whose base item no longer exists
+    /// are dropped. Has no effect on base-table queries.
+    pub fn fetch_full_items(mut self, fetch: bool) -> Self {
+        self.fetch_full_items = fetch;
+        self
+    }
+
+    pub(crate) fn fetches_full_items(&self) -> bool {
+        self.fetch_full_items
+    }
+
+    /// Report the capacity the query consumed in the response
+    pub fn return_consumed_capacity(mut self, enabled: bool) -> Self {
+        self.return_consumed_capacity = enabled;
+        self
+    }
+
+    pub(crate) fn returns_consumed_capacity(&self) -> bool {
+        self.return_consumed_capacity
+    }
+
+    /// Only return items matching a filter expression
+    ///
+    /// The filter is applied after reading, so `limit` bounds the items
+    /// examined rather than the items returned.
+    pub fn filter(mut self, expression: impl Into<String>) -> Self {
+        self.filter.set_expression(expression);
+        self
+    }
+
+    /// Bind an expression attribute value used by the filter
+    pub fn value(mut self, placeholder: impl Into<String>, value: Value) -> Self {
+        self.filter.add_value(placeholder, value);
+        self
+    }
+
+    /// Bind an expression attribute name used by the filter
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.filter.add_name(placeholder, name);
+        self
+    }
+
+    /// Choose what to return (`Select::Count` returns only the count)
+    pub fn select(mut self, select: Select) -> Self {
+        self.filter.select = select;
+        self
+    }
+
+    /// Also return the sum of a numeric attribute over the matching items
+    ///
+    /// Items without the attribute or with a non-numeric value there are
+    /// skipped. Combine with `Select::Count` to get only the aggregates.
+    pub fn sum(mut self, attribute: impl Into<String>) -> Self {
+        self.filter.sum = Some(attribute.into());
+        self
+    }
+
+    pub(crate) fn index_name(&self) -> Option<&str> {
+        self.params.index_name.as_deref()
+    }
+
+    /// Whether the base-table item at `key` is one this query selects,
+    /// ignoring limit and pagination
+    pub(crate) fn matches(&self, key: &Key, item: &Item) -> kstone_core::Result<bool> {
+        if key.pk != self.params.pk || !self.params.matches_sk(&key.sk) {
+            return Ok(false);
+        }
+        self.filter.matches(item)
+    }
+
+    pub(crate) fn read_filter(&self) -> ReadFilter {
+        self.filter.clone()
+    }
+
     /// Get the underlying QueryParams
     pub(crate) fn into_params(self) -> QueryParams {
         self.params
@@ -133,6 +219,10 @@ pub struct QueryResponse {
     pub last_key: Option<(Bytes, Option<Bytes>)>,
     /// Number of items examined
     pub scanned_count: usize,
+    /// Sum of the attribute requested with `sum` over the matching items
+    pub sum: Option<Value>,
+    /// Capacity consumed, if requested with `return_consumed_capacity`
+    pub consumed_capacity: Option<ConsumedCapacity>,
 }
 
 impl QueryResponse {
@@ -144,6 +234,8 @@ impl QueryResponse {
             count,
             last_key,
             scanned_count: result.scanned_count,
+            sum: None,
+            consumed_capacity: None,
         }
     }
 }
@@ -2,12 +2,15 @@
 ///
 /// Provides a high-level API for scanning all items in a table.
 
-use kstone_core::{Item, Key, iterator::{ScanParams, ScanResult}};
+use crate::filter::{ReadFilter, Select};
+use kstone_core::{ConsumedCapacity, Item, Key, Value, iterator::{ScanParams, ScanResult}};
 use bytes::Bytes;
 
 /// Scan builder
 pub struct Scan {
     params: ScanParams,
+    filter: ReadFilter,
+    return_consumed_capacity: bool,
 }
 
 impl Scan {
@@ -15,6 +18,8 @@ impl Scan {
     pub fn new() -> Self {
         Self {
             params: ScanParams::new(),
+            filter: ReadFilter::default(),
+            return_consumed_capacity: false,
         }
     }
 
@@ -41,6 +46,70 @@ impl Scan {
         self
     }
 
+    /// Restrict the scan to keys from `start` (inclusive) to `end` (exclusive)
+    pub(crate) fn key_range(mut self, start: Key, end: Key) -> Self {
+        self.params = self.params.with_range(Some(start), Some(end));
+        self
+    }
+
+    pub(crate) fn is_segmented(&self) -> bool {
+        self.params.segment.is_some()
+    }
+
+    pub(crate) fn has_filter(&self) -> bool {
+        self.filter.expression.is_some()
+    }
+
+    /// Only return items matching a filter expression
+    ///
+    /// The filter is applied after reading, so `limit` bounds the items
+    /// examined rather than the items returned.
+    pub fn filter(mut self, expression: impl Into<String>) -> Self {
+        self.filter.set_expression(expression);
+        self
+    }
+
+    /// Bind an expression attribute value used by the filter
+    pub fn value(mut self, placeholder: impl Into<String>, value: Value) -> Self {
+        self.filter.add_value(placeholder, value);
+        self
+    }
+
+    /// Bind an expression attribute name used by the filter
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.filter.add_name(placeholder, name);
+        self
+    }
+
+    /// Choose what to return (`Select::Count` returns only the count)
+    pub fn select(mut self, select: Select) -> Self {
+        self.filter.select = select;
+        self
+    }
+
+    /// Also return the sum of a numeric attribute over the matching items
+    ///
+    /// Items without the attribute or with a non-numeric value there are
+    /// skipped. Combine with `Select::Count` to get only the aggregates.
+    pub fn sum(mut self, attribute: impl Into<String>) -> Self {
+        self.filter.sum = Some(attribute.into());
+        self
+    }
+
+    /// Report the capacity the scan consumed in the response
+    pub fn return_consumed_capacity(mut self, enabled: bool) -> Self {
+        self.return_consumed_capacity = enabled;
+        self
+    }
+
+    pub(crate) fn returns_consumed_capacity(&self) -> bool {
+        self.return_consumed_capacity
+    }
+
+    pub(crate) fn read_filter(&self) -> ReadFilter {
+        self.filter.clone()
+    }
+
     /// Get the underlying ScanParams
     pub(crate) fn into_params(self) -> ScanParams {
         self.params
@@ -63,6 +132,10 @@ pub struct ScanResponse {
     pub last_key: Option<(Bytes, Option<Bytes>)>,
     /// Number of items examined
     pub scanned_count: usize,
+    /// Sum of the attribute requested with `sum` over the matching items
+    pub sum: Option<Value>,
+    /// Capacity consumed, if requested with `return_consumed_capacity`
+    pub consumed_capacity: Option<ConsumedCapacity>,
 }
 
 impl ScanResponse {
@@ -74,10 +147,66 @@ impl ScanResponse {
             count,
             last_key,
             scanned_count: result.scanned_count,
+            sum: None,
+            consumed_capacity: None,
         }
     }
 }
 
+/// Keys yielded by `Database::keys_only`, in scan order
+pub struct KeyIterator {
+    keys: std::vec::IntoIter<Key>,
+}
+
+impl KeyIterator {
+    pub(crate) fn new(keys: Vec<Key>) -> Self {
+        Self {
+            keys: keys.into_iter(),
+        }
+    }
+}
+
+impl Iterator for KeyIterator {
+    type Item = Key;
+
+    fn next(&mut self) -> Option<Key> {
+        self.keys.next()
+    }
+
+    fn size_hint(&self) -> (usize, Option<usize>) {
+        self.keys.size_hint()
+    }
+}
+
+impl ExactSizeIterator for KeyIterator {}
+
+/// Partition keys yielded by `Database::partitions`, in order
+pub struct PartitionIterator {
+    partitions: std::vec::IntoIter<Bytes>,
+}
+
+impl PartitionIterator {
+    pub(crate) fn new(partitions: Vec<Bytes>) -> Self {
+        Self {
+            partitions: partitions.into_iter(),
+        }
+    }
+}
+
+impl Iterator for PartitionIterator {
+    type Item = Bytes;
+
+    fn next(&mut self) -> Option<Bytes> {
+        self.partitions.next()
+    }
+
+    fn size_hint(&self) -> (usize, Option<usize>) {
+        self.partitions.size_hint()
+    }
+}
+
+impl ExactSizeIterator for PartitionIterator {}
+
 #[cfg(test)]
 mod tests {
     use super::*;
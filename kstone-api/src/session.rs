@@ -0,0 +1,128 @@
+/// Read-your-writes sessions
+///
+/// A session remembers the engine's applied watermark (the newest sequence
+/// number visible to readers, see `LsmEngine::applied_seq`) as of its
+/// newest write, and every read through the session first checks that the
+/// engine it reads from has applied at least that much. A read never
+/// returns data that is missing one of the session's own writes: it fails
+/// instead.
+///
+/// With a group commit window a write is staged, unseen by readers, until
+/// its sync, but the call only returns once it has been applied. Writes by
+/// others that are still staged are not part of the watermark, so they
+/// never fail a session read. An embedded database therefore always passes
+/// the check; keep session reads going through `Session` anyway, as it is
+/// the contract that holds once reads can be served from elsewhere.
+
+use crate::query::{Query, QueryResponse};
+use crate::update::{Update, UpdateResponse};
+use crate::Database;
+use kstone_core::{Error, Item, Result};
+use std::sync::atomic::{AtomicU64, Ordering};
+
+/// A view of a database whose reads observe all of its own writes
+///
+/// Sessions are cheap; open one per logical client or request flow.
+pub struct Session<'a> {
+    db: &'a Database,
+    /// Applied watermark as of the newest write made through the session, 0 if none
+    written: AtomicU64,
+}
+
+impl<'a> Session<'a> {
+    pub(crate) fn new(db: &'a Database) -> Self {
+        Self {
+            db,
+            written: AtomicU64::new(0),
+        }
+    }
+
+    /// Applied watermark as of the newest write made through the session,
+    /// which is at least that write's sequence number
+    ///
+    /// None before the first write, and always for in-memory databases,
+    /// which do not number their writes.
+    pub fn last_write_seq(&self) -> Option<u64> {
+        match self.written.load(Ordering::Acquire) {
+            0 => None,
+            seq => Some(seq),
+        }
+    }
+
+    /// Put an item by partition key
+    pub fn put(&self, pk: &[u8], item: Item) -> Result<()> {
+        self.db.put(pk, item)?;
+        self.record_write();
+        Ok(())
+    }
+
+    /// Put an item by partition key and sort key
+    pub fn put_with_sk(&self, pk: &[u8], sk: &[u8], item: Item) -> Result<()> {
+        self.db.put_with_sk(pk, sk, item)?;
+        self.record_write();
+        Ok(())
+    }
+
+    /// Delete an item by partition key
+    pub fn delete(&self, pk: &[u8]) -> Result<()> {
+        self.db.delete(pk)?;
+        self.record_write();
+        Ok(())
+    }
+
+    /// Delete an item by partition key and sort key
+    pub fn delete_with_sk(&self, pk: &[u8], sk: &[u8]) -> Result<()> {
+        self.db.delete_with_sk(pk, sk)?;
+        self.record_write();
+        Ok(())
+    }
+
+    /// Update an item using an update expression
+    pub fn update(&self, update: Update) -> Result<UpdateResponse> {
+        let response = self.db.update(update)?;
+        self.record_write();
+        Ok(response)
+    }
+
+    /// Get an item by partition key
+    pub fn get(&self, pk: &[u8]) -> Result<Option<Item>> {
+        self.check_visible()?;
+        self.db.get(pk)
+    }
+
+    /// Get an item by partition key and sort key
+    pub fn get_with_sk(&self, pk: &[u8], sk: &[u8]) -> Result<Option<Item>> {
+        self.check_visible()?;
+        self.db.get_with_sk(pk, sk)
+    }
+
+    /// Query a partition
+    pub fn query(&self, query: Query) -> Result<QueryResponse> {
+        self.check_visible()?;
+        self.db.query(query)
+    }
+
+    /// Newest sequence number the engine has applied, with every earlier one
+    fn applied_seq(&self) -> Option<u64> {
+        self.db.disk_engine().ok().map(|engine| engine.applied_seq())
+    }
+
+    /// Remember the newest write so far; it covers the session's own write
+    /// and possibly later writes by others, which only makes reads stricter
+    fn record_write(&self) {
+        if let Some(seq) = self.applied_seq() {
+            self.written.fetch_max(seq, Ordering::AcqRel);
+        }
+    }
+
+    fn check_visible(&self) -> Result<()> {
+        let written = self.written.load(Ordering::Acquire);
+        match self.applied_seq() {
+            Some(applied) if applied < written => Err(Error::Internal(format!(
+                "Session write {} is not visible yet (applied up to {})",
+                written, applied
+            ))),
+            _ => Ok(()),
+        }
+    }
+}
@@ -0,0 +1,71 @@
+/// Point-in-time read views
+///
+/// A snapshot sees the database exactly as it was when it was taken, no
+/// matter what is written afterwards, so a long export or report reads one
+/// consistent state instead of a mix of old and new items.
+
+use crate::query::{Query, QueryResponse};
+use crate::scan::{Scan, ScanResponse};
+use bytes::Bytes;
+use kstone_core::{Item, Key, Result};
+
+/// A consistent read view of a database
+///
+/// Release it (or drop it) when done: while it is open, every write keeps
+/// a copy of the version it replaces. Index queries are not supported.
+pub struct Snapshot {
+    inner: kstone_core::Snapshot,
+}
+
+impl Snapshot {
+    pub(crate) fn new(inner: kstone_core::Snapshot) -> Self {
+        Self { inner }
+    }
+
+    /// Sequence number of the last write visible in the snapshot
+    pub fn seq(&self) -> u64 {
+        self.inner.seq()
+    }
+
+    /// Get an item by partition key
+    pub fn get(&self, pk: &[u8]) -> Result<Option<Item>> {
+        self.inner.get(&Key::new(Bytes::copy_from_slice(pk)))
+    }
+
+    /// Get an item by partition key and sort key
+    pub fn get_with_sk(&self, pk: &[u8], sk: &[u8]) -> Result<Option<Item>> {
+        self.inner
+            .get(&Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)))
+    }
+
+    /// Query a partition
+    pub fn query(&self, query: Query) -> Result<QueryResponse> {
+        let filter = query.read_filter();
+        let mut result = self.inner.query(query.into_params())?;
+
+        let filtered = filter.apply(std::mem::take(&mut result.items))?;
+        let mut response = QueryResponse::from_result(result);
+        response.items = filtered.items;
+        response.count = filtered.count;
+        response.sum = filtered.sum;
+        Ok(response)
+    }
+
+    /// Scan the table
+    pub fn scan(&self, scan: Scan) -> Result<ScanResponse> {
+        let filter = scan.read_filter();
+        let mut result = self.inner.scan(scan.into_params())?;
+
+        let filtered = filter.apply(std::mem::take(&mut result.items))?;
+        let mut response = ScanResponse::from_result(result);
+        response.items = filtered.items;
+        response.count = filtered.count;
+        response.sum = filtered.sum;
+        Ok(response)
+    }
+
+    /// Release the snapshot
+    pub fn release(self) {
+        self.inner.release();
+    }
+}
@@ -9,16 +9,28 @@ use bytes::Bytes;
 use std::collections::HashMap;
 
 /// Transaction get request - read multiple items atomically
+///
+/// Condition checks assert invariants on other items in the same atomic
+/// read: if any is false, nothing is returned and the request fails with
+/// `ConditionalCheckFailed`.
 #[derive(Debug, Clone)]
 pub struct TransactGetRequest {
     /// Keys to retrieve
     pub keys: Vec<Key>,
+    /// Conditions that must hold, by key
+    pub conditions: Vec<(Key, String)>,
+    /// Shared expression context for the conditions
+    pub context: kstone_core::expression::ExpressionContext,
 }
 
 impl TransactGetRequest {
     /// Create a new transaction get request
     pub fn new() -> Self {
-        Self { keys: Vec::new() }
+        Self {
+            keys: Vec::new(),
+            conditions: Vec::new(),
+            context: kstone_core::expression::ExpressionContext::new(),
+        }
     }
 
     /// Add a key with partition key only
@@ -36,6 +48,33 @@ impl TransactGetRequest {
         self
     }
 
+    /// Require `condition` to hold for the item at `pk`
+    pub fn condition_check(mut self, pk: &[u8], condition: impl Into<String>) -> Self {
+        self.conditions.push((Key::new(Bytes::copy_from_slice(pk)), condition.into()));
+        self
+    }
+
+    /// Require `condition` to hold for the item at `pk`/`sk`
+    pub fn condition_check_with_sk(mut self, pk: &[u8], sk: &[u8], condition: impl Into<String>) -> Self {
+        self.conditions.push((
+            Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            condition.into(),
+        ));
+        self
+    }
+
+    /// Add expression attribute value
+    pub fn value(mut self, placeholder: impl Into<String>, value: kstone_core::Value) -> Self {
+        self.context = self.context.with_value(placeholder, value);
+        self
+    }
+
+    /// Add expression attribute name
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.context = self.context.with_name(placeholder, name);
+        self
+    }
+
     /// Get the keys
     pub(crate) fn keys(&self) -> &[Key] {
         &self.keys
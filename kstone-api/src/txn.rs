@@ -0,0 +1,121 @@
+/// Interactive transactions
+///
+/// A `Txn` reads items as it goes and buffers its writes. Commit applies
+/// the writes atomically, but only if none of the items the transaction
+/// read has been written since it read them (optimistic concurrency);
+/// otherwise it fails with `TransactionConflict` and writes nothing. Reads
+/// see the transaction's own buffered writes.
+///
+/// A running transaction holds no locks. Commit checks its reads and
+/// applies its writes under the engine's single write lock, so there is no
+/// order in which transactions over overlapping keys could deadlock: when
+/// they collide, the later commit fails with `TransactionConflict`.
+
+use crate::Database;
+use bytes::Bytes;
+use kstone_core::lsm::LsmEngine;
+use kstone_core::{Item, Key, Result, SeqNo, TransactWriteOperation};
+use std::collections::{BTreeMap, HashMap};
+
+/// An open interactive transaction
+///
+/// Dropping a transaction without committing discards its writes.
+pub struct Txn<'a> {
+    engine: &'a LsmEngine,
+    /// Version of each item when the transaction first read it
+    reads: HashMap<Key, SeqNo>,
+    /// Buffered writes, None for a delete
+    writes: BTreeMap<Key, Option<Item>>,
+}
+
+impl<'a> Txn<'a> {
+    pub(crate) fn begin(db: &'a Database) -> Result<Self> {
+        Ok(Self {
+            engine: db.disk_engine()?,
+            reads: HashMap::new(),
+            writes: BTreeMap::new(),
+        })
+    }
+
+    /// Get an item by partition key
+    pub fn get(&mut self, pk: &[u8]) -> Result<Option<Item>> {
+        self.read(Key::new(Bytes::copy_from_slice(pk)))
+    }
+
+    /// Get an item by partition key and sort key
+    pub fn get_with_sk(&mut self, pk: &[u8], sk: &[u8]) -> Result<Option<Item>> {
+        self.read(Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)))
+    }
+
+    /// Put an item by partition key
+    pub fn put(&mut self, pk: &[u8], item: Item) {
+        self.writes.insert(Key::new(Bytes::copy_from_slice(pk)), Some(item));
+    }
+
+    /// Put an item by partition key and sort key
+    pub fn put_with_sk(&mut self, pk: &[u8], sk: &[u8], item: Item) {
+        self.writes.insert(
+            Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            Some(item),
+        );
+    }
+
+    /// Delete an item by partition key
+    pub fn delete(&mut self, pk: &[u8]) {
+        self.writes.insert(Key::new(Bytes::copy_from_slice(pk)), None);
+    }
+
+    /// Delete an item by partition key and sort key
+    pub fn delete_with_sk(&mut self, pk: &[u8], sk: &[u8]) {
+        self.writes.insert(
+            Key::with_sk(Bytes::copy_from_slice(pk), Bytes::copy_from_slice(sk)),
+            None,
+        );
+    }
+
+    /// Apply the buffered writes
+    ///
+    /// Fails with `TransactionConflict` if an item read by the transaction
+    /// has changed since; the transaction can then be retried from the start.
+    pub fn commit(self) -> Result<()> {
+        if self.writes.is_empty() {
+            return Ok(());
+        }
+
+        let reads: Vec<(Key, SeqNo)> = self.reads.into_iter().collect();
+        let operations: Vec<(Key, TransactWriteOperation)> = self
+            .writes
+            .into_iter()
+            .map(|(key, write)| {
+                let op = match write {
+                    Some(item) => TransactWriteOperation::Put { item, condition: None },
+                    None => TransactWriteOperation::Delete { condition: None },
+                };
+                (key, op)
+            })
+            .collect();
+
+        self.engine.commit_if_unchanged(&reads, &operations)?;
+        Ok(())
+    }
+
+    /// Discard the buffered writes
+    pub fn rollback(self) {}
+
+    fn read(&mut self, key: Key) -> Result<Option<Item>> {
+        if let Some(write) = self.writes.get(&key) {
+            return Ok(write.clone());
+        }
+
+        let (item, version) = self.engine.get_versioned(&key)?;
+        // Validate against the first read; a later read seeing a newer
+        // version would already be a conflict
+        let first = *self.reads.entry(key).or_insert(version);
+        if first != version {
+            return Err(kstone_core::Error::TransactionConflict(
+                "Item changed while the transaction was running".to_string(),
+            ));
+        }
+        Ok(item)
+    }
+}
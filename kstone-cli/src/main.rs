@@ -632,6 +632,16 @@ fn keystone_value_to_json(value: &KeystoneValue) -> serde_json::Value {
             // Encode timestamp as number
             serde_json::Value::Number((*ts).into())
         }
+        // Encode sets as arrays of their members
+        KeystoneValue::SS(set) => {
+            serde_json::Value::Array(set.iter().cloned().map(serde_json::Value::String).collect())
+        }
+        KeystoneValue::NS(set) => serde_json::Value::Array(
+            set.iter().map(|n| keystone_value_to_json(&KeystoneValue::N(n.clone()))).collect(),
+        ),
+        KeystoneValue::BS(set) => serde_json::Value::Array(
+            set.iter().map(|b| serde_json::Value::String(base64_encode(b))).collect(),
+        ),
     }
 }
 
@@ -695,6 +705,11 @@ fn format_csv_value(value: &KeystoneValue) -> String {
         KeystoneValue::B(_) => escape_csv("[binary]"),
         KeystoneValue::VecF32(_) => escape_csv("[vector]"),
         KeystoneValue::Ts(ts) => ts.to_string(),
+        KeystoneValue::SS(set) | KeystoneValue::NS(set) => {
+            let members: Vec<&str> = set.iter().map(String::as_str).collect();
+            escape_csv(&format!("{{{}}}", members.join(",")))
+        }
+        KeystoneValue::BS(_) => escape_csv("[binary set]"),
     }
 }
 
@@ -889,5 +904,7 @@ fn value_to_compact_string(value: &KeystoneValue) -> String {
         L(list) => format!("[{} items]", list.len()),
         M(map) => format!("{{{} fields}}", map.len()),
         VecF32(vec) => format!("<vector[{}]>", vec.len()),
+        SS(set) | NS(set) => format!("{{{} members}}", set.len()),
+        BS(set) => format!("{{{} members}}", set.len()),
     }
 }
@@ -89,6 +89,16 @@ fn format_value(value: &KeystoneValue) -> String {
             // Display timestamp
             ts.to_string()
         }
+        KeystoneValue::SS(set) => {
+            // Display sets in braces
+            let members: Vec<String> = set.iter().map(|s| format!("\"{}\"", s)).collect();
+            format!("{{{}}}", members.join(", "))
+        }
+        KeystoneValue::NS(set) => {
+            let members: Vec<&str> = set.iter().map(String::as_str).collect();
+            format!("{{{}}}", members.join(", "))
+        }
+        KeystoneValue::BS(set) => format!("<Binary set {} members>", set.len()),
     }
 }
 
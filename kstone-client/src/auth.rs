@@ -0,0 +1,251 @@
+/// Client authentication
+///
+/// Credentials are sent as a request header on every call: bearer tokens as
+/// `authorization: Bearer <token>`, API keys as `x-api-key: <key>`. Tokens
+/// come from a `TokenSource`. The current token is cached and the source is
+/// asked for a new one before a call once the token is within
+/// `TOKEN_REFRESH_SKEW` of expiring.
+///
+/// Credentials are only sent over TLS (`https://`) unless the client
+/// explicitly allows insecure transport.
+
+use crate::error::{ClientError, Result};
+use std::future::Future;
+use std::pin::Pin;
+use std::sync::RwLock;
+use std::time::{Duration, SystemTime};
+use tokio::sync::Mutex;
+use tonic::metadata::{Ascii, MetadataKey, MetadataValue};
+
+/// Header carrying bearer tokens
+pub const AUTHORIZATION_HEADER: &str = "authorization";
+
+/// Header carrying API keys
+pub const API_KEY_HEADER: &str = "x-api-key";
+
+/// How long before expiry a cached token is refreshed
+pub const TOKEN_REFRESH_SKEW: Duration = Duration::from_secs(10);
+
+/// A credential and when it stops being valid
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Token {
+    /// Token value, without any scheme prefix
+    pub value: String,
+    /// Expiry time, or None if the token does not expire
+    pub expires_at: Option<SystemTime>,
+}
+
+impl Token {
+    /// A token that never expires
+    pub fn new(value: impl Into<String>) -> Self {
+        Self {
+            value: value.into(),
+            expires_at: None,
+        }
+    }
+
+    /// A token valid until `expires_at`
+    pub fn expiring(value: impl Into<String>, expires_at: SystemTime) -> Self {
+        Self {
+            value: value.into(),
+            expires_at: Some(expires_at),
+        }
+    }
+}
+
+/// Future returned by `TokenSource::token`
+pub type TokenFuture<'a> = Pin<Box<dyn Future<Output = Result<Token>> + Send + 'a>>;
+
+/// Supplies the token to send with a call
+///
+/// Implement this to plug in OIDC, STS or any other short-lived credential
+/// provider. The client caches the returned token and only asks again when
+/// it is about to expire.
+pub trait TokenSource: Send + Sync {
+    /// Return a valid token
+    fn token(&self) -> TokenFuture<'_>;
+}
+
+impl<T: TokenSource + ?Sized> TokenSource for std::sync::Arc<T> {
+    fn token(&self) -> TokenFuture<'_> {
+        (**self).token()
+    }
+}
+
+/// A fixed token
+#[derive(Debug, Clone)]
+pub struct StaticToken(String);
+
+impl StaticToken {
+    pub fn new(token: impl Into<String>) -> Self {
+        Self(token.into())
+    }
+}
+
+impl TokenSource for StaticToken {
+    fn token(&self) -> TokenFuture<'_> {
+        Box::pin(async move { Ok(Token::new(self.0.clone())) })
+    }
+}
+
+/// A fetched token, ready to send
+struct CachedToken {
+    value: MetadataValue<Ascii>,
+    expires_at: Option<SystemTime>,
+}
+
+/// Credential header attached to every call
+pub(crate) struct Credentials {
+    header: MetadataKey<Ascii>,
+    scheme: &'static str,
+    source: Box<dyn TokenSource>,
+    skew: Duration,
+    current: RwLock<Option<CachedToken>>,
+    /// Serializes fetches so concurrent calls refresh only once
+    refreshing: Mutex<()>,
+}
+
+impl Credentials {
+    /// Bearer tokens in the `authorization` header
+    pub(crate) fn bearer(source: Box<dyn TokenSource>) -> Self {
+        Self::new(AUTHORIZATION_HEADER, "Bearer ", source)
+    }
+
+    /// API key in the `x-api-key` header
+    pub(crate) fn api_key(key: impl Into<String>) -> Self {
+        Self::new(API_KEY_HEADER, "", Box::new(StaticToken::new(key)))
+    }
+
+    fn new(header: &'static str, scheme: &'static str, source: Box<dyn TokenSource>) -> Self {
+        Self {
+            header: MetadataKey::from_static(header),
+            scheme,
+            source,
+            skew: TOKEN_REFRESH_SKEW,
+            current: RwLock::new(None),
+            refreshing: Mutex::new(()),
+        }
+    }
+
+    #[cfg(test)]
+    fn with_skew(mut self, skew: Duration) -> Self {
+        self.skew = skew;
+        self
+    }
+
+    /// Make sure a token is cached that is not about to expire
+    pub(crate) async fn ensure_current(&self) -> Result<()> {
+        if self.is_current() {
+            return Ok(());
+        }
+
+        let _refreshing = self.refreshing.lock().await;
+        // Another call may have refreshed while we waited
+        if self.is_current() {
+            return Ok(());
+        }
+
+        let token = self.source.token().await?;
+        let value = MetadataValue::try_from(format!("{}{}", self.scheme, token.value))
+            .map_err(|_| ClientError::InvalidArgument("Token contains invalid header characters".to_string()))?;
+        *self.current.write().unwrap() = Some(CachedToken {
+            value,
+            expires_at: token.expires_at,
+        });
+        Ok(())
+    }
+
+    fn is_current(&self) -> bool {
+        match &*self.current.read().unwrap() {
+            None => false,
+            Some(CachedToken { expires_at: None, .. }) => true,
+            Some(CachedToken { expires_at: Some(expires_at), .. }) => {
+                SystemTime::now() + self.skew < *expires_at
+            }
+        }
+    }
+
+    /// Header to send, once a token has been fetched
+    pub(crate) fn header(&self) -> Option<(MetadataKey<Ascii>, MetadataValue<Ascii>)> {
+        self.current
+            .read()
+            .unwrap()
+            .as_ref()
+            .map(|token| (self.header.clone(), token.value.clone()))
+    }
+}
+
+impl std::fmt::Debug for Credentials {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        // Never print the token itself
+        f.debug_struct("Credentials").field("header", &self.header).finish()
+    }
+}
+
+/// Refuse to send credentials over plaintext unless explicitly allowed
+pub(crate) fn check_transport(secure: bool, allow_insecure: bool) -> Result<()> {
+    if !secure && !allow_insecure {
+        return Err(ClientError::InvalidArgument(
+            "Refusing to send credentials over a plaintext connection; \
+             use https:// or call allow_insecure_credentials()"
+                .to_string(),
+        ));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::sync::Arc;
+
+    #[tokio::test]
+    async fn test_bearer_header() {
+        let credentials = Credentials::bearer(Box::new(StaticToken::new("secret")));
+        assert!(credentials.header().is_none());
+
+        credentials.ensure_current().await.unwrap();
+        let (key, value) = credentials.header().unwrap();
+        assert_eq!(key.as_str(), "authorization");
+        assert_eq!(value, "Bearer secret");
+        assert!(!format!("{:?}", credentials).contains("secret"));
+    }
+
+    /// Hands out numbered tokens that expire after one second
+    struct ShortLived {
+        fetches: AtomicUsize,
+    }
+
+    impl TokenSource for ShortLived {
+        fn token(&self) -> TokenFuture<'_> {
+            Box::pin(async move {
+                let n = self.fetches.fetch_add(1, Ordering::SeqCst) + 1;
+                Ok(Token::expiring(format!("t{}", n), SystemTime::now() + Duration::from_secs(1)))
+            })
+        }
+    }
+
+    #[tokio::test]
+    async fn test_token_refreshed_after_expiry() {
+        let source = Arc::new(ShortLived { fetches: AtomicUsize::new(0) });
+        let credentials = Credentials::bearer(Box::new(Arc::clone(&source))).with_skew(Duration::ZERO);
+
+        credentials.ensure_current().await.unwrap();
+        credentials.ensure_current().await.unwrap();
+        assert_eq!(source.fetches.load(Ordering::SeqCst), 1);
+        assert_eq!(credentials.header().unwrap().1, "Bearer t1");
+
+        tokio::time::sleep(Duration::from_millis(1100)).await;
+        credentials.ensure_current().await.unwrap();
+        assert_eq!(source.fetches.load(Ordering::SeqCst), 2);
+        assert_eq!(credentials.header().unwrap().1, "Bearer t2");
+    }
+
+    #[test]
+    fn test_check_transport() {
+        assert!(check_transport(true, false).is_ok());
+        assert!(check_transport(false, true).is_ok());
+        assert!(matches!(check_transport(false, false), Err(ClientError::InvalidArgument(_))));
+    }
+}
@@ -1,9 +1,12 @@
 /// Remote batch operations
+use crate::cond::Cond;
 use crate::convert::*;
 use crate::error::Result;
 use kstone_core::Item;
 use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
-use tonic::transport::Channel;
+use crate::metadata::Transport;
+use crate::validate;
+use std::collections::HashMap;
 
 /// Remote batch get request builder
 pub struct RemoteBatchGetRequest {
@@ -34,8 +37,36 @@ impl RemoteBatchGetRequest {
         self
     }
 
+    /// Partition keys this request reads
+    pub(crate) fn partition_keys(&self) -> Vec<&[u8]> {
+        self.keys.iter().map(|k| k.partition_key.as_slice()).collect()
+    }
+
+    /// Requested keys as (partition key, sort key)
+    pub(crate) fn keys(&self) -> impl Iterator<Item = (&[u8], Option<&[u8]>)> {
+        self.keys
+            .iter()
+            .map(|k| (k.partition_key.as_slice(), k.sort_key.as_deref()))
+    }
+
+    /// Check the request locally before it is sent
+    ///
+    /// Rejects empty partition keys and requests too large to send.
+    pub fn validate(&self) -> Result<()> {
+        for (pk, _) in self.keys() {
+            validate::partition_key(pk, "batch get partition key")?;
+        }
+        let size: usize = self
+            .keys
+            .iter()
+            .map(|key| prost::encoding::message::encoded_len(1, key))
+            .sum();
+        validate::request_size(size, "batch get")
+    }
+
     /// Execute the batch get operation
-    pub async fn execute(self, client: &mut KeystoneDbClient<Channel>) -> Result<RemoteBatchGetResponse> {
+    pub async fn execute(self, client: &mut KeystoneDbClient<Transport>) -> Result<RemoteBatchGetResponse> {
+        self.validate()?;
         let request = proto::BatchGetRequest {
             keys: self.keys,
         };
@@ -91,6 +122,9 @@ impl RemoteBatchWriteRequest {
                 partition_key: pk.to_vec(),
                 sort_key: None,
                 item: Some(ks_item_to_proto(&item)),
+                condition_expression: None,
+                expression_values: HashMap::new(),
+                expression_names: HashMap::new(),
             })),
         });
         self
@@ -103,6 +137,43 @@ impl RemoteBatchWriteRequest {
                 partition_key: pk.to_vec(),
                 sort_key: Some(sk.to_vec()),
                 item: Some(ks_item_to_proto(&item)),
+                condition_expression: None,
+                expression_values: HashMap::new(),
+                expression_names: HashMap::new(),
+            })),
+        });
+        self
+    }
+
+    /// Add a put that is only applied if `condition` holds for the stored
+    /// item
+    ///
+    /// The batch is not atomic: a false condition rejects this put alone,
+    /// reported in `RemoteBatchWriteResponse::rejected`, and the other
+    /// writes still go through.
+    pub fn put_conditional(self, pk: &[u8], item: Item, condition: Cond) -> Self {
+        self.push_conditional(pk.to_vec(), None, item, condition)
+    }
+
+    /// Add a conditional put with partition key and sort key
+    pub fn put_conditional_with_sk(self, pk: &[u8], sk: &[u8], item: Item, condition: Cond) -> Self {
+        self.push_conditional(pk.to_vec(), Some(sk.to_vec()), item, condition)
+    }
+
+    fn push_conditional(mut self, pk: Vec<u8>, sk: Option<Vec<u8>>, item: Item, condition: Cond) -> Self {
+        let compiled = condition.compile();
+        self.writes.push(proto::WriteRequest {
+            request: Some(proto::write_request::Request::Put(proto::PutItem {
+                partition_key: pk,
+                sort_key: sk,
+                item: Some(ks_item_to_proto(&item)),
+                condition_expression: Some(compiled.expression),
+                expression_values: compiled
+                    .values
+                    .iter()
+                    .map(|(k, v)| (k.clone(), ks_value_to_proto(v)))
+                    .collect(),
+                expression_names: compiled.names,
             })),
         });
         self
@@ -130,8 +201,45 @@ impl RemoteBatchWriteRequest {
         self
     }
 
+    /// Partition keys this request writes
+    pub(crate) fn partition_keys(&self) -> Vec<&[u8]> {
+        self.writes
+            .iter()
+            .filter_map(|w| match &w.request {
+                Some(proto::write_request::Request::Put(put)) => Some(put.partition_key.as_slice()),
+                Some(proto::write_request::Request::Delete(delete)) => Some(delete.partition_key.as_slice()),
+                None => None,
+            })
+            .collect()
+    }
+
+    /// Check the request locally before it is sent
+    ///
+    /// Rejects writes with an empty partition key and batches too large
+    /// to send.
+    pub fn validate(&self) -> Result<()> {
+        for write in &self.writes {
+            match &write.request {
+                Some(proto::write_request::Request::Put(put)) => {
+                    validate::partition_key(&put.partition_key, "batch put partition key")?;
+                }
+                Some(proto::write_request::Request::Delete(delete)) => {
+                    validate::partition_key(&delete.partition_key, "batch delete partition key")?;
+                }
+                None => {}
+            }
+        }
+        let size: usize = self
+            .writes
+            .iter()
+            .map(|write| prost::encoding::message::encoded_len(1, write))
+            .sum();
+        validate::request_size(size, "batch write")
+    }
+
     /// Execute the batch write operation
-    pub async fn execute(self, client: &mut KeystoneDbClient<Channel>) -> Result<RemoteBatchWriteResponse> {
+    pub async fn execute(self, client: &mut KeystoneDbClient<Transport>) -> Result<RemoteBatchWriteResponse> {
+        self.validate()?;
         let request = proto::BatchWriteRequest {
             writes: self.writes,
         };
@@ -143,6 +251,7 @@ impl RemoteBatchWriteRequest {
 
         Ok(RemoteBatchWriteResponse {
             success: response.success,
+            rejected: response.rejected.into_iter().map(|index| index as usize).collect(),
         })
     }
 }
@@ -157,4 +266,7 @@ impl Default for RemoteBatchWriteRequest {
 pub struct RemoteBatchWriteResponse {
     /// Whether the batch write succeeded
     pub success: bool,
+    /// Indices, in the order writes were added, of conditional puts whose
+    /// condition was false; those items were not written
+    pub rejected: Vec<usize>,
 }
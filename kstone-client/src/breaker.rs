@@ -0,0 +1,270 @@
+/// Circuit breaker for client calls
+///
+/// The breaker watches the outcome of recent calls. When the failure rate
+/// over the window crosses the configured threshold it opens, and calls fail
+/// immediately with `ClientError::CircuitOpen` instead of waiting on a dead
+/// server. After `open_duration` a single probe call is let through: if it
+/// succeeds the circuit closes again, otherwise it stays open for another
+/// `open_duration`.
+///
+/// Only errors that indicate an unhealthy server or connection count as
+/// failures; application errors such as a failed condition or a missing
+/// item do not.
+
+use crate::error::{ClientError, Result};
+use std::collections::VecDeque;
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+
+/// Circuit breaker settings
+#[derive(Debug, Clone)]
+pub struct BreakerConfig {
+    /// Number of most recent calls the failure rate is computed over
+    pub window_size: usize,
+    /// Minimum calls in the window before the breaker may open
+    pub minimum_calls: usize,
+    /// Failure rate (0.0 to 1.0) at or above which the breaker opens
+    pub failure_rate: f64,
+    /// How long the breaker stays open before probing
+    pub open_duration: Duration,
+}
+
+impl Default for BreakerConfig {
+    fn default() -> Self {
+        Self {
+            window_size: 20,
+            minimum_calls: 5,
+            failure_rate: 0.5,
+            open_duration: Duration::from_secs(30),
+        }
+    }
+}
+
+/// Current state of a circuit breaker
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum CircuitState {
+    /// Calls flow normally
+    Closed,
+    /// Calls fail fast until the open duration has passed
+    Open,
+    /// A probe call is allowed through to test recovery
+    HalfOpen,
+}
+
+#[derive(Debug)]
+enum Mode {
+    Closed,
+    Open { since: Instant },
+    HalfOpen { probing: bool },
+}
+
+#[derive(Debug)]
+struct State {
+    mode: Mode,
+    /// Recent outcomes, `true` for a failure
+    outcomes: VecDeque<bool>,
+}
+
+/// Shared breaker state across client clones
+#[derive(Debug)]
+pub(crate) struct CircuitBreaker {
+    config: BreakerConfig,
+    state: Mutex<State>,
+}
+
+impl CircuitBreaker {
+    pub(crate) fn new(config: BreakerConfig) -> Arc<Self> {
+        Arc::new(Self {
+            config,
+            state: Mutex::new(State {
+                mode: Mode::Closed,
+                outcomes: VecDeque::new(),
+            }),
+        })
+    }
+
+    /// Current state, moving from open to half-open once the open duration has passed
+    pub(crate) fn state(&self) -> CircuitState {
+        let mut state = self.state.lock().unwrap();
+        self.advance(&mut state);
+        match state.mode {
+            Mode::Closed => CircuitState::Closed,
+            Mode::Open { .. } => CircuitState::Open,
+            Mode::HalfOpen { .. } => CircuitState::HalfOpen,
+        }
+    }
+
+    /// Ask to make a call, failing fast if the circuit is open
+    pub(crate) fn acquire(self: &Arc<Self>) -> Result<BreakerPermit> {
+        let mut state = self.state.lock().unwrap();
+        self.advance(&mut state);
+
+        let probe = match &mut state.mode {
+            Mode::Closed => false,
+            Mode::HalfOpen { probing } if !*probing => {
+                *probing = true;
+                true
+            }
+            Mode::HalfOpen { .. } => {
+                return Err(ClientError::CircuitOpen("Recovery probe in progress".to_string()))
+            }
+            Mode::Open { .. } => {
+                return Err(ClientError::CircuitOpen("Too many recent failures".to_string()))
+            }
+        };
+
+        Ok(BreakerPermit {
+            breaker: Arc::clone(self),
+            probe,
+            recorded: false,
+        })
+    }
+
+    fn advance(&self, state: &mut State) {
+        if let Mode::Open { since } = state.mode {
+            if since.elapsed() >= self.config.open_duration {
+                state.mode = Mode::HalfOpen { probing: false };
+            }
+        }
+    }
+
+    fn record(&self, probe: bool, failed: bool) {
+        let mut state = self.state.lock().unwrap();
+
+        if probe {
+            state.outcomes.clear();
+            state.mode = if failed {
+                Mode::Open { since: Instant::now() }
+            } else {
+                Mode::Closed
+            };
+            return;
+        }
+
+        // Late results from calls started before the circuit opened
+        if !matches!(state.mode, Mode::Closed) {
+            return;
+        }
+
+        state.outcomes.push_back(failed);
+        while state.outcomes.len() > self.config.window_size.max(1) {
+            state.outcomes.pop_front();
+        }
+
+        let calls = state.outcomes.len();
+        let failures = state.outcomes.iter().filter(|f| **f).count();
+        if calls >= self.config.minimum_calls.max(1)
+            && failures as f64 / calls as f64 >= self.config.failure_rate
+        {
+            state.outcomes.clear();
+            state.mode = Mode::Open { since: Instant::now() };
+        }
+    }
+
+    fn release_probe(&self) {
+        let mut state = self.state.lock().unwrap();
+        if let Mode::HalfOpen { probing } = &mut state.mode {
+            *probing = false;
+        }
+    }
+}
+
+/// Permission to make one call; report its outcome with `record`
+///
+/// A permit dropped without a result (e.g. a cancelled call) is not counted,
+/// and frees the probe slot if it held it.
+pub(crate) struct BreakerPermit {
+    breaker: Arc<CircuitBreaker>,
+    probe: bool,
+    recorded: bool,
+}
+
+impl BreakerPermit {
+    pub(crate) fn record<T>(mut self, result: &Result<T>) {
+        let failed = matches!(result, Err(e) if is_failure(e));
+        self.breaker.record(self.probe, failed);
+        self.recorded = true;
+    }
+}
+
+impl Drop for BreakerPermit {
+    fn drop(&mut self) {
+        if self.probe && !self.recorded {
+            self.breaker.release_probe();
+        }
+    }
+}
+
+/// Whether an error indicates an unhealthy server or connection
+fn is_failure(error: &ClientError) -> bool {
+    matches!(
+        error,
+        ClientError::ConnectionError(_)
+            | ClientError::Unavailable(_)
+            | ClientError::Timeout(_)
+            | ClientError::InternalError(_)
+            | ClientError::Unknown(_)
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn config() -> BreakerConfig {
+        BreakerConfig {
+            window_size: 10,
+            minimum_calls: 3,
+            failure_rate: 0.5,
+            open_duration: Duration::from_millis(20),
+        }
+    }
+
+    fn unavailable() -> Result<()> {
+        Err(ClientError::Unavailable("down".to_string()))
+    }
+
+    #[test]
+    fn test_opens_fails_fast_and_recovers_after_probe() {
+        let breaker = CircuitBreaker::new(config());
+
+        for _ in 0..3 {
+            breaker.acquire().unwrap().record(&unavailable());
+        }
+        assert_eq!(breaker.state(), CircuitState::Open);
+        assert!(matches!(breaker.acquire(), Err(ClientError::CircuitOpen(_))));
+
+        std::thread::sleep(Duration::from_millis(30));
+        assert_eq!(breaker.state(), CircuitState::HalfOpen);
+
+        // Only one probe at a time
+        let probe = breaker.acquire().unwrap();
+        assert!(matches!(breaker.acquire(), Err(ClientError::CircuitOpen(_))));
+
+        probe.record(&Ok(()));
+        assert_eq!(breaker.state(), CircuitState::Closed);
+        breaker.acquire().unwrap().record(&Ok(()));
+    }
+
+    #[test]
+    fn test_failed_probe_reopens() {
+        let breaker = CircuitBreaker::new(config());
+        for _ in 0..3 {
+            breaker.acquire().unwrap().record(&unavailable());
+        }
+
+        std::thread::sleep(Duration::from_millis(30));
+        breaker.acquire().unwrap().record(&unavailable());
+        assert_eq!(breaker.state(), CircuitState::Open);
+    }
+
+    #[test]
+    fn test_application_errors_do_not_open() {
+        let breaker = CircuitBreaker::new(config());
+        for _ in 0..5 {
+            let result: Result<()> = Err(ClientError::ConditionCheckFailed("no".to_string()));
+            breaker.acquire().unwrap().record(&result);
+        }
+        assert_eq!(breaker.state(), CircuitState::Closed);
+    }
+}
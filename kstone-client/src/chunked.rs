@@ -0,0 +1,72 @@
+/// Transactional writes larger than one transaction
+///
+/// `Client::transact_write_chunked` splits a large write into consecutive
+/// transactions of at most `chunk_size` operations. This is NOT atomic:
+/// each chunk commits on its own, and other clients can observe the write
+/// half done. What it bounds is the blast radius of a failure. Before each
+/// chunk commits, the items it writes are read; if a later chunk fails,
+/// the chunks that already committed are rolled back (newest first) by
+/// writing those items back, or deleting the ones that did not exist.
+/// Rollback is best effort: it overwrites any change another client made
+/// to the same items in the meantime, and if it fails the call returns
+/// `ClientError::CompensationFailed` naming the chunks left in place.
+
+use crate::transaction::RemoteTransactWriteRequest;
+use kstone_core::Item;
+
+/// Operations per chunk by default
+pub const DEFAULT_CHUNK_SIZE: usize = 25;
+
+/// Options for a chunked transactional write
+#[derive(Debug, Clone, Copy)]
+pub struct ChunkOptions {
+    chunk_size: usize,
+}
+
+impl ChunkOptions {
+    pub fn new() -> Self {
+        Self {
+            chunk_size: DEFAULT_CHUNK_SIZE,
+        }
+    }
+
+    /// Operations per transaction (at least 1)
+    pub fn chunk_size(mut self, chunk_size: usize) -> Self {
+        self.chunk_size = chunk_size.max(1);
+        self
+    }
+
+    pub(crate) fn size(&self) -> usize {
+        self.chunk_size
+    }
+}
+
+impl Default for ChunkOptions {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Result of a chunked transactional write
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct ChunkedWriteResponse {
+    /// Transactions committed
+    pub chunks: usize,
+}
+
+/// A committed chunk: the items it wrote, as they were before
+pub(crate) type PriorState = Vec<((Vec<u8>, Option<Vec<u8>>), Option<Item>)>;
+
+/// Transaction that puts back `prior`
+pub(crate) fn compensation(prior: &PriorState) -> RemoteTransactWriteRequest {
+    prior
+        .iter()
+        .fold(RemoteTransactWriteRequest::new(), |request, ((pk, sk), item)| {
+            match (sk, item) {
+                (None, Some(item)) => request.put(pk, item.clone()),
+                (Some(sk), Some(item)) => request.put_with_sk(pk, sk, item.clone()),
+                (None, None) => request.delete(pk),
+                (Some(sk), None) => request.delete_with_sk(pk, sk),
+            }
+        })
+}
@@ -1,12 +1,174 @@
 /// KeystoneDB gRPC client implementation
+use crate::auth::{check_transport, Credentials, TokenSource};
+use crate::breaker::{BreakerConfig, BreakerPermit, CircuitBreaker, CircuitState};
+use crate::discovery::{self, SrvResolver, SrvTarget, DEFAULT_RESOLVE_INTERVAL};
 use crate::error::{ClientError, Result};
-use kstone_core::Item;
+use crate::idle::{IdleConnection, IDLE_POOL_CAPACITY};
+use crate::inflight::{CallGuard, CallTracker, ConcurrencyLimit, WhenSaturated};
+use crate::metadata::{MetadataInterceptor, Transport};
+use crate::rate_limit::RateLimiter;
+use crate::read_cache::{Invalidation, ReadCache, ReadCacheConfig, ReadCacheStats};
+use crate::request_id::RequestIdSource;
+use crate::tenant::TenantGuard;
+use kstone_core::{ConsumedCapacity, Item};
 use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
-use tonic::transport::Channel;
+use serde::de::DeserializeOwned;
+use std::sync::Arc;
+use std::time::Duration;
+use tokio::sync::{mpsc, oneshot};
+use tonic::codec::ProstCodec;
+use tonic::codegen::http::uri::PathAndQuery;
+use tonic::service::interceptor::InterceptedService;
+use tonic::transport::{Channel, ClientTlsConfig, Endpoint};
+
+/// User agent sent when none is configured
+pub const DEFAULT_USER_AGENT: &str = concat!("kstone-client/", env!("CARGO_PKG_VERSION"));
+
+/// Options applied when connecting
+#[derive(Debug, Clone)]
+pub struct ConnectOptions {
+    user_agent: String,
+    resolve_interval: Duration,
+    keepalive_time: Option<Duration>,
+    keepalive_timeout: Option<Duration>,
+    idle_timeout: Option<Duration>,
+    tls: Option<ClientTlsConfig>,
+}
+
+impl ConnectOptions {
+    /// Default options
+    pub fn new() -> Self {
+        Self {
+            user_agent: DEFAULT_USER_AGENT.to_string(),
+            resolve_interval: DEFAULT_RESOLVE_INTERVAL,
+            keepalive_time: None,
+            keepalive_timeout: None,
+            idle_timeout: None,
+            tls: None,
+        }
+    }
+
+    /// Identify the application in the `user-agent` header of every call
+    ///
+    /// Lets server logs and metrics attribute traffic to a client, e.g.
+    /// `"billing-service/2.3.1"`. gRPC appends its own version to it.
+    pub fn user_agent(mut self, user_agent: impl Into<String>) -> Self {
+        self.user_agent = user_agent.into();
+        self
+    }
+
+    /// How often `Client::connect_srv` re-resolves its service name
+    pub fn resolve_interval(mut self, interval: Duration) -> Self {
+        self.resolve_interval = interval;
+        self
+    }
+
+    /// Send an HTTP/2 keepalive ping after `interval` without traffic
+    ///
+    /// Pings go out on idle connections too, so NATs and load balancers
+    /// that drop idle connections see them in use. Off by default. Servers
+    /// may close connections that ping too often; stay above their limit
+    /// (tonic servers accept pings at any rate).
+    pub fn keepalive_time(mut self, interval: Duration) -> Self {
+        self.keepalive_time = Some(interval);
+        self
+    }
+
+    /// Close the connection if a keepalive ping isn't answered within
+    /// `timeout` (20s by default)
+    ///
+    /// Only takes effect with `keepalive_time`. The next call opens a new
+    /// connection.
+    pub fn keepalive_timeout(mut self, timeout: Duration) -> Self {
+        self.keepalive_timeout = Some(timeout);
+        self
+    }
+
+    /// Retire the connection once no call has run for `timeout`
+    ///
+    /// The next call opens a new connection instead of trying one an
+    /// intermediary may have dropped in the meantime. Not used by
+    /// `connect_srv`.
+    pub fn idle_timeout(mut self, timeout: Duration) -> Self {
+        self.idle_timeout = Some(timeout);
+        self
+    }
+
+    /// Connect over TLS with `config`
+    ///
+    /// Applies to `https://` addresses, and makes `Client::connect_srv`
+    /// connect to its servers over `https`. Server certificates are checked
+    /// against the system's root certificates unless `config` names a CA.
+    pub fn tls(mut self, config: ClientTlsConfig) -> Self {
+        self.tls = Some(config);
+        self
+    }
+
+    /// Scheme of the addresses built for `connect_srv`
+    fn scheme(&self) -> &'static str {
+        if self.tls.is_some() {
+            "https"
+        } else {
+            "http"
+        }
+    }
+
+    /// Endpoint for `addr` with these options applied
+    fn endpoint(&self, addr: String) -> Result<Endpoint> {
+        let mut endpoint = Endpoint::from_shared(addr)
+            .map_err(|e| ClientError::ConnectionError(format!("Invalid address: {}", e)))?
+            .user_agent(self.user_agent.clone())
+            .map_err(|e| ClientError::InvalidArgument(format!("Invalid user agent: {}", e)))?;
+        if let Some(interval) = self.keepalive_time {
+            endpoint = endpoint.http2_keep_alive_interval(interval).keep_alive_while_idle(true);
+        }
+        if let Some(timeout) = self.keepalive_timeout {
+            endpoint = endpoint.keep_alive_timeout(timeout);
+        }
+        if let Some(config) = &self.tls {
+            endpoint = endpoint
+                .tls_config(config.clone())
+                .map_err(|e| ClientError::ConnectionError(format!("Invalid TLS configuration: {}", e)))?;
+        }
+        Ok(endpoint)
+    }
+}
+
+impl Default for ConnectOptions {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Fully qualified gRPC service name, used to build `raw_call` paths
+const SERVICE_NAME: &str = "keystone.KeystoneDB";
+
+/// Items buffered by `scan_channel` ahead of the receiver
+const SCAN_CHANNEL_CAPACITY: usize = 256;
 
 /// KeystoneDB remote client
+///
+/// Cloning a client is cheap: clones share the underlying connection,
+/// in-flight call tracking and circuit breaker, so `drain` on any clone
+/// affects all of them. Metadata and a tenant guard are copied into clones
+/// made after they were set.
+#[derive(Clone)]
 pub struct Client {
-    inner: KeystoneDbClient<Channel>,
+    inner: KeystoneDbClient<Transport>,
+    channel: Channel,
+    metadata: MetadataInterceptor,
+    credentials: Option<Arc<Credentials>>,
+    /// Whether the connection uses TLS
+    secure: bool,
+    allow_insecure_credentials: bool,
+    calls: Arc<CallTracker>,
+    concurrency: Option<Arc<ConcurrencyLimit>>,
+    breaker: Option<Arc<CircuitBreaker>>,
+    tenant: Option<TenantGuard>,
+    read_cache: Option<Arc<ReadCache>>,
+    bulk_rate: Option<Arc<RateLimiter>>,
+    /// Connection to a single address (None for `connect_srv`)
+    connection: Option<Arc<IdleConnection>>,
 }
 
 impl Client {
@@ -24,15 +186,408 @@ impl Client {
     /// # }
     /// ```
     pub async fn connect(addr: impl Into<String>) -> Result<Self> {
+        Self::connect_with_options(addr, ConnectOptions::default()).await
+    }
+
+    /// Connect to a KeystoneDB server with custom options
+    ///
+    /// The connection is opened lazily, so an unreachable server is
+    /// reported by the first call.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, ConnectOptions};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let options = ConnectOptions::new().user_agent("billing-service/2.3.1");
+    /// let client = Client::connect_with_options("http://localhost:50051", options).await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn connect_with_options(addr: impl Into<String>, options: ConnectOptions) -> Result<Self> {
         let addr = addr.into();
-        let channel = Channel::from_shared(addr)
-            .map_err(|e| ClientError::ConnectionError(format!("Invalid address: {}", e)))?
-            .connect()
-            .await
-            .map_err(|e| ClientError::ConnectionError(format!("Failed to connect: {}", e)))?;
+        let secure = addr.starts_with("https://");
+        let endpoint = options.endpoint(addr)?;
+
+        // A pooled connection can be closed while clones of the client
+        // still hold the channel
+        let (channel, pool) = Channel::balance_channel(IDLE_POOL_CAPACITY);
+        let mut client = Self::from_channel(channel, secure);
+        client.connection = Some(IdleConnection::start(
+            endpoint,
+            options.idle_timeout,
+            pool,
+            Arc::clone(&client.calls),
+        )?);
+        Ok(client)
+    }
+
+    /// Connect to every server named by a DNS SRV record
+    ///
+    /// Calls are balanced across the resolved servers. The record is looked
+    /// up again every `options.resolve_interval` (30s by default) and the
+    /// pool follows it; a failed or empty re-resolution keeps the current
+    /// servers. Only the initial lookup must succeed. Connections use TLS
+    /// if `options.tls` is set, and are plaintext otherwise.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, ConnectOptions, DnsSrvResolver};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let resolver = DnsSrvResolver::from_system_conf()?;
+    /// let client = Client::connect_srv("_kstone._tcp.example.com", resolver, ConnectOptions::new()).await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn connect_srv(
+        service: impl Into<String>,
+        resolver: impl SrvResolver + 'static,
+        options: ConnectOptions,
+    ) -> Result<Self> {
+        let service = service.into();
+        let targets = discovery::resolve_targets(&resolver, &service).await?;
+
+        let interval = options.resolve_interval;
+        let secure = options.tls.is_some();
+        let endpoint = move |target: &SrvTarget| options.endpoint(target.uri(options.scheme()));
+        let (channel, pool) = Channel::balance_channel(targets.len().max(16));
+        for change in discovery::diff(&Default::default(), &targets, &endpoint)? {
+            pool.send(change)
+                .await
+                .map_err(|_| ClientError::ConnectionError("Connection pool closed".to_string()))?;
+        }
+
+        let client = Self::from_channel(channel, secure);
+        tokio::spawn(discovery::refresh(
+            Box::new(resolver),
+            service,
+            interval,
+            targets,
+            pool,
+            endpoint,
+            Arc::clone(&client.calls),
+        ));
+        Ok(client)
+    }
+
+    fn from_channel(channel: Channel, secure: bool) -> Self {
+        let metadata = MetadataInterceptor::default();
+        let inner = KeystoneDbClient::with_interceptor(channel.clone(), metadata.clone());
+        Self {
+            inner,
+            channel,
+            metadata,
+            credentials: None,
+            secure,
+            allow_insecure_credentials: false,
+            calls: CallTracker::new(),
+            concurrency: None,
+            breaker: None,
+            tenant: None,
+            read_cache: None,
+            bulk_rate: None,
+            connection: None,
+        }
+    }
+
+    /// Guard calls with a circuit breaker
+    ///
+    /// Once the failure rate of recent calls reaches `config.failure_rate`,
+    /// calls fail immediately with `ClientError::CircuitOpen` until a probe
+    /// call succeeds. Only connection and server errors count as failures.
+    /// A retry loop around the client should not retry `CircuitOpen`
+    /// before `config.open_duration` has passed.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{BreakerConfig, Client};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051")
+    ///     .await?
+    ///     .with_circuit_breaker(BreakerConfig::default());
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_circuit_breaker(mut self, config: BreakerConfig) -> Self {
+        self.breaker = Some(CircuitBreaker::new(config));
+        self
+    }
+
+    /// Cap the number of calls running at once
+    ///
+    /// The cap is shared with clones made afterwards. Calls beyond it wait
+    /// for a running call to finish, or fail with
+    /// `ClientError::ResourceExhausted` when `when_saturated` is `Fail`.
+    /// Streams hold their slot only while they are being opened.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, WhenSaturated};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051")
+    ///     .await?
+    ///     .with_max_concurrent_requests(64, WhenSaturated::Wait);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_max_concurrent_requests(mut self, max: usize, when_saturated: WhenSaturated) -> Self {
+        self.concurrency = Some(ConcurrencyLimit::new(max, when_saturated));
+        self
+    }
+
+    /// Send a header with every call made through this client
+    ///
+    /// Applies to this client and clones made from it afterwards. For
+    /// metadata on a single call, add it to a clone:
+    /// `client.clone().with_metadata(...)?`. Returns
+    /// `ClientError::InvalidArgument` for an invalid header name or value.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051")
+    ///     .await?
+    ///     .with_metadata("authorization", "Bearer secret")?;
+    ///
+    /// // Only this call carries the trace header
+    /// client.clone().with_metadata("x-trace-id", "abc123")?.get(b"user#1").await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_metadata(mut self, key: &str, value: &str) -> Result<Self> {
+        self.metadata.add(key, value)?;
+        self.inner = KeystoneDbClient::with_interceptor(self.channel.clone(), self.metadata.clone());
+        Ok(self)
+    }
+
+    /// Look up the request ID of each call with `source`
+    ///
+    /// By default calls carry the ID set by `request_id::with_request_id`,
+    /// if any. Use this when services keep the ID elsewhere, e.g. in their
+    /// own task-local or tracing span. The ID goes out in the
+    /// `x-request-id` header; a call whose ID isn't a valid header value
+    /// fails with `ClientError::InvalidArgument`.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # fn current_trace_id() -> Option<String> { None }
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051")
+    ///     .await?
+    ///     .with_request_id_from(current_trace_id);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_request_id_from(mut self, source: impl Fn() -> Option<String> + Send + Sync + 'static) -> Self {
+        self.metadata.set_request_id_source(RequestIdSource::new(source));
+        self.inner = KeystoneDbClient::with_interceptor(self.channel.clone(), self.metadata.clone());
+        self
+    }
+
+    /// Authenticate every call with a bearer token
+    ///
+    /// Sent as `authorization: Bearer <token>`. Fails with
+    /// `ClientError::InvalidArgument` on a plaintext (`http://`) connection
+    /// unless `allow_insecure_credentials` was called first.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("https://db.example.com:50051")
+    ///     .await?
+    ///     .with_bearer_token("secret")?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_bearer_token(self, token: impl Into<String>) -> Result<Self> {
+        self.with_token_source(crate::auth::StaticToken::new(token))
+    }
+
+    /// Authenticate every call with a bearer token from `source`
+    ///
+    /// The token is cached and refreshed transparently before a call once
+    /// it is within `auth::TOKEN_REFRESH_SKEW` of its expiry; concurrent
+    /// calls share a single refresh. Same transport rules as
+    /// `with_bearer_token`.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, Token, TokenSource};
+    /// # use kstone_client::auth::TokenFuture;
+    /// # use std::time::{Duration, SystemTime};
+    /// struct Sts;
+    ///
+    /// impl TokenSource for Sts {
+    ///     fn token(&self) -> TokenFuture<'_> {
+    ///         Box::pin(async move {
+    ///             // Fetch a short-lived credential here
+    ///             Ok(Token::expiring("token", SystemTime::now() + Duration::from_secs(900)))
+    ///         })
+    ///     }
+    /// }
+    ///
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("https://db.example.com:50051")
+    ///     .await?
+    ///     .with_token_source(Sts)?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_token_source(self, source: impl TokenSource + 'static) -> Result<Self> {
+        self.with_credentials(Credentials::bearer(Box::new(source)))
+    }
+
+    /// Authenticate every call with an API key, sent as `x-api-key`
+    ///
+    /// Same transport rules as `with_bearer_token`.
+    pub fn with_api_key(self, key: impl Into<String>) -> Result<Self> {
+        self.with_credentials(Credentials::api_key(key))
+    }
+
+    /// Allow credentials to be sent over a plaintext connection
+    ///
+    /// Only for local development and tests: anyone on the network path
+    /// can read the credentials.
+    pub fn allow_insecure_credentials(mut self) -> Self {
+        self.allow_insecure_credentials = true;
+        self
+    }
+
+    fn with_credentials(mut self, credentials: Credentials) -> Result<Self> {
+        check_transport(self.secure, self.allow_insecure_credentials)?;
+        let credentials = Arc::new(credentials);
+        self.metadata.set_credentials(Arc::clone(&credentials));
+        self.credentials = Some(credentials);
+        self.inner = KeystoneDbClient::with_interceptor(self.channel.clone(), self.metadata.clone());
+        Ok(self)
+    }
+
+    /// Confine the client to partition keys beginning with `prefix`
+    ///
+    /// Requests touching any other partition fail locally with
+    /// `ClientError::PermissionDenied` without reaching the server. Scans
+    /// and PartiQL statements are rejected, since they are not scoped to a
+    /// partition. Index queries are checked against the index partition key.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051")
+    ///     .await?
+    ///     .with_tenant_guard("acme#");
+    ///
+    /// client.get(b"acme#user1").await?;          // allowed
+    /// assert!(client.get(b"globex#user1").await.is_err()); // rejected locally
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_tenant_guard(mut self, prefix: impl Into<Vec<u8>>) -> Self {
+        self.tenant = Some(TenantGuard::new(prefix));
+        self
+    }
+
+    /// Serve repeated reads of the same keys from a local cache
+    ///
+    /// See the `read_cache` module for what is cached and when entries are
+    /// dropped. Clones made afterwards share the cache.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, ReadCacheConfig};
+    /// # use std::time::Duration;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let config = ReadCacheConfig { ttl: Duration::from_millis(500), ..Default::default() };
+    /// let mut client = Client::connect("http://localhost:50051")
+    ///     .await?
+    ///     .with_read_cache(config);
+    ///
+    /// client.get(b"config#flags").await?; // from the server
+    /// client.get(b"config#flags").await?; // from the cache
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_read_cache(mut self, config: ReadCacheConfig) -> Self {
+        self.read_cache = Some(ReadCache::new(config));
+        self
+    }
+
+    /// Pace the bulk helpers to at most `ops_per_second` items per second
+    ///
+    /// Applies to `import_from` (items written) and `parallel_scan` (items
+    /// scanned, across all segments), and is shared with clones made
+    /// afterwards; see the `rate_limit` module. Returns
+    /// `ClientError::InvalidArgument` unless the rate is positive.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051")
+    ///     .await?
+    ///     .with_bulk_rate_limit(500.0)?;
+    ///
+    /// let file = std::io::BufReader::new(std::fs::File::open("local.export")?);
+    /// client.import_from(file).await?; // at most 500 items per second
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn with_bulk_rate_limit(mut self, ops_per_second: f64) -> Result<Self> {
+        self.bulk_rate = Some(RateLimiter::new(ops_per_second)?);
+        Ok(self)
+    }
+
+    /// Read cache counters, if a cache is configured
+    pub fn read_cache_stats(&self) -> Option<ReadCacheStats> {
+        self.read_cache.as_ref().map(|cache| cache.stats())
+    }
+
+    /// State of the circuit breaker, if one is configured
+    pub fn circuit_state(&self) -> Option<CircuitState> {
+        self.breaker.as_ref().map(|b| b.state())
+    }
+
+    /// Gracefully shut down the client
+    ///
+    /// Stops accepting new calls on this client and all of its clones, waits
+    /// for in-flight RPCs (including active scans) to finish, then closes
+    /// the connection, even while clones of the client are still around.
+    /// Returns `ClientError::Timeout` if calls are still running when
+    /// `timeout` expires; the connection is closed either way.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # use std::time::Duration;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051").await?;
+    /// client.drain(Duration::from_secs(5)).await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn drain(self, timeout: Duration) -> Result<()> {
+        self.calls.start_draining();
+
+        let result = tokio::time::timeout(timeout, self.calls.wait_idle()).await;
+        if let Some(connection) = &self.connection {
+            connection.close();
+        }
+        // Servers found through SRV are removed by the refresh task
+        self.calls.close();
+
+        result.map_err(|_| ClientError::Timeout("Drain timed out with calls still in flight".to_string()))
+    }
 
-        let inner = KeystoneDbClient::new(channel);
-        Ok(Self { inner })
+    /// Number of RPCs currently in flight across this client and its clones
+    pub fn in_flight(&self) -> usize {
+        self.calls.in_flight()
+    }
+
+    /// Whether `drain` has been called on this client or one of its clones
+    pub fn is_draining(&self) -> bool {
+        self.calls.is_draining()
     }
 
     /// Put an item with a simple partition key
@@ -58,19 +613,26 @@ impl Client {
     /// # }
     /// ```
     pub async fn put(&mut self, pk: &[u8], item: Item) -> Result<()> {
+        self.authorize([pk])?;
+        let _written = self.writing([pk]);
+        let call = self.begin().await?;
         let request = proto::PutRequest {
             partition_key: pk.to_vec(),
             sort_key: None,
             item: Some(crate::convert::ks_item_to_proto(&item)),
             condition_expression: None,
             expression_values: std::collections::HashMap::new(),
+            expression_names: std::collections::HashMap::new(),
+            dry_run: false,
+            return_consumed_capacity: false,
         };
 
-        self.inner
+        let result = self.inner
             .put(request)
             .await
             .map_err(|e| e.into())
-            .map(|_| ())
+            .map(|_| ());
+        call.finish(result)
     }
 
     /// Put an item with partition key and sort key
@@ -80,19 +642,26 @@ impl Client {
     /// * `sk` - Sort key
     /// * `item` - Item to store
     pub async fn put_with_sk(&mut self, pk: &[u8], sk: &[u8], item: Item) -> Result<()> {
+        self.authorize([pk])?;
+        let _written = self.writing([pk]);
+        let call = self.begin().await?;
         let request = proto::PutRequest {
             partition_key: pk.to_vec(),
             sort_key: Some(sk.to_vec()),
             item: Some(crate::convert::ks_item_to_proto(&item)),
             condition_expression: None,
             expression_values: std::collections::HashMap::new(),
+            expression_names: std::collections::HashMap::new(),
+            dry_run: false,
+            return_consumed_capacity: false,
         };
 
-        self.inner
+        let result = self.inner
             .put(request)
             .await
             .map_err(|e| e.into())
-            .map(|_| ())
+            .map(|_| ());
+        call.finish(result)
     }
 
     /// Put an item with a condition expression
@@ -109,6 +678,9 @@ impl Client {
         condition: impl Into<String>,
         values: std::collections::HashMap<String, kstone_core::Value>,
     ) -> Result<()> {
+        self.authorize([pk])?;
+        let _written = self.writing([pk]);
+        let call = self.begin().await?;
         let proto_values: std::collections::HashMap<String, proto::Value> = values
             .iter()
             .map(|(k, v)| (k.clone(), crate::convert::ks_value_to_proto(v)))
@@ -120,13 +692,47 @@ impl Client {
             item: Some(crate::convert::ks_item_to_proto(&item)),
             condition_expression: Some(condition.into()),
             expression_values: proto_values,
+            expression_names: std::collections::HashMap::new(),
+            dry_run: false,
+            return_consumed_capacity: false,
         };
 
-        self.inner
+        let result = self.inner
             .put(request)
             .await
             .map_err(|e| e.into())
-            .map(|_| ())
+            .map(|_| ());
+        call.finish(result)
+    }
+
+    /// Execute a put built with `RemotePut`
+    ///
+    /// # Arguments
+    /// * `put` - Put builder with optional condition
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemotePut, cond};
+    /// # use std::collections::HashMap;
+    /// # use kstone_core::Value;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let mut item = HashMap::new();
+    /// item.insert("version".to_string(), Value::number(1));
+    ///
+    /// let put = RemotePut::new(b"user#123", item)
+    ///     .condition_expr(cond::attr_not_exists("version"));
+    ///
+    /// client.put_item(put).await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn put_item(&mut self, put: crate::put::RemotePut) -> Result<crate::put::RemotePutResponse> {
+        self.authorize([put.partition_key()])?;
+        let _written = self.writing([put.partition_key()]);
+        let call = self.begin().await?;
+        call.finish(put.execute(&mut self.inner).await)
     }
 
     /// Get an item with a simple partition key
@@ -137,22 +743,7 @@ impl Client {
     /// # Returns
     /// The item if found, None otherwise
     pub async fn get(&mut self, pk: &[u8]) -> Result<Option<Item>> {
-        let request = proto::GetRequest {
-            partition_key: pk.to_vec(),
-            sort_key: None,
-        };
-
-        let response = self
-            .inner
-            .get(request)
-            .await
-            .map_err(|e| ClientError::from(e))?
-            .into_inner();
-
-        Ok(response.item.map(|proto_item| {
-            crate::convert::proto_item_to_ks(proto_item)
-                .expect("Server returned invalid item")
-        }))
+        self.get_item(pk, None).await
     }
 
     /// Get an item with partition key and sort key
@@ -164,22 +755,86 @@ impl Client {
     /// # Returns
     /// The item if found, None otherwise
     pub async fn get_with_sk(&mut self, pk: &[u8], sk: &[u8]) -> Result<Option<Item>> {
+        self.get_item(pk, Some(sk)).await
+    }
+
+    /// Get an item, through the read cache if one is configured
+    async fn get_item(&mut self, pk: &[u8], sk: Option<&[u8]>) -> Result<Option<Item>> {
+        self.authorize([pk])?;
+        let cache = self.read_cache.clone();
+        let generation = match &cache {
+            Some(cache) => match cache.lookup(pk, sk) {
+                Some(item) => return Ok(item),
+                None => cache.generation(),
+            },
+            None => 0,
+        };
+
+        let call = self.begin().await?;
         let request = proto::GetRequest {
             partition_key: pk.to_vec(),
-            sort_key: Some(sk.to_vec()),
+            sort_key: sk.map(<[u8]>::to_vec),
+            return_consumed_capacity: false,
         };
 
-        let response = self
+        let result = self
             .inner
             .get(request)
             .await
-            .map_err(|e| ClientError::from(e))?
-            .into_inner();
+            .map_err(|e| ClientError::from(e));
+        let response = call.finish(result)?.into_inner();
 
-        Ok(response.item.map(|proto_item| {
+        let item = response.item.map(|proto_item| {
             crate::convert::proto_item_to_ks(proto_item)
                 .expect("Server returned invalid item")
-        }))
+        });
+        if let Some(cache) = &cache {
+            cache.store(pk, sk, &item, generation);
+        }
+        Ok(item)
+    }
+
+    /// Get an item along with the capacity the read consumed
+    ///
+    /// Always reads from the server, bypassing the read cache. A missing
+    /// item still consumes one read unit.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let (item, capacity) = client.get_with_consumed_capacity(b"user#123", None).await?;
+    /// println!("{} read units", capacity.read_units);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn get_with_consumed_capacity(
+        &mut self,
+        pk: &[u8],
+        sk: Option<&[u8]>,
+    ) -> Result<(Option<Item>, ConsumedCapacity)> {
+        self.authorize([pk])?;
+        let call = self.begin().await?;
+        let request = proto::GetRequest {
+            partition_key: pk.to_vec(),
+            sort_key: sk.map(<[u8]>::to_vec),
+            return_consumed_capacity: true,
+        };
+
+        let result = self.inner.get(request).await.map_err(ClientError::from);
+        let response = call.finish(result)?.into_inner();
+
+        let item = response.item.map(|proto_item| {
+            crate::convert::proto_item_to_ks(proto_item)
+                .expect("Server returned invalid item")
+        });
+        let capacity = response
+            .consumed_capacity
+            .map(crate::convert::proto_consumed_capacity_to_ks)
+            .unwrap_or_default();
+        Ok((item, capacity))
     }
 
     /// Delete an item with a simple partition key
@@ -187,18 +842,23 @@ impl Client {
     /// # Arguments
     /// * `pk` - Partition key
     pub async fn delete(&mut self, pk: &[u8]) -> Result<()> {
+        self.authorize([pk])?;
+        let _written = self.writing([pk]);
+        let call = self.begin().await?;
         let request = proto::DeleteRequest {
             partition_key: pk.to_vec(),
             sort_key: None,
             condition_expression: None,
             expression_values: std::collections::HashMap::new(),
+            expression_names: std::collections::HashMap::new(),
         };
 
-        self.inner
+        let result = self.inner
             .delete(request)
             .await
             .map_err(|e| e.into())
-            .map(|_| ())
+            .map(|_| ());
+        call.finish(result)
     }
 
     /// Delete an item with partition key and sort key
@@ -207,18 +867,23 @@ impl Client {
     /// * `pk` - Partition key
     /// * `sk` - Sort key
     pub async fn delete_with_sk(&mut self, pk: &[u8], sk: &[u8]) -> Result<()> {
+        self.authorize([pk])?;
+        let _written = self.writing([pk]);
+        let call = self.begin().await?;
         let request = proto::DeleteRequest {
             partition_key: pk.to_vec(),
             sort_key: Some(sk.to_vec()),
             condition_expression: None,
             expression_values: std::collections::HashMap::new(),
+            expression_names: std::collections::HashMap::new(),
         };
 
-        self.inner
+        let result = self.inner
             .delete(request)
             .await
             .map_err(|e| e.into())
-            .map(|_| ())
+            .map(|_| ());
+        call.finish(result)
     }
 
     /// Delete an item with a condition expression
@@ -233,6 +898,9 @@ impl Client {
         condition: impl Into<String>,
         values: std::collections::HashMap<String, kstone_core::Value>,
     ) -> Result<()> {
+        self.authorize([pk])?;
+        let _written = self.writing([pk]);
+        let call = self.begin().await?;
         let proto_values: std::collections::HashMap<String, proto::Value> = values
             .iter()
             .map(|(k, v)| (k.clone(), crate::convert::ks_value_to_proto(v)))
@@ -243,37 +911,167 @@ impl Client {
             sort_key: None,
             condition_expression: Some(condition.into()),
             expression_values: proto_values,
+            expression_names: std::collections::HashMap::new(),
         };
 
-        self.inner
+        let result = self.inner
             .delete(request)
             .await
             .map_err(|e| e.into())
-            .map(|_| ())
+            .map(|_| ());
+        call.finish(result)
     }
 
-    /// Execute a query operation
+    /// Execute a delete built with `RemoteDelete`
     ///
     /// # Arguments
-    /// * `query` - Query builder with conditions
+    /// * `delete` - Delete builder with optional condition
     ///
     /// # Example
     /// ```no_run
-    /// # use kstone_client::{Client, RemoteQuery};
+    /// # use kstone_client::{Client, RemoteDelete};
+    /// # use kstone_core::Value;
     /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
     /// let mut client = Client::connect("http://localhost:50051").await?;
     ///
-    /// let query = RemoteQuery::new(b"user#org1")
-    ///     .sk_begins_with(b"USER#")
-    ///     .limit(10);
+    /// let delete = RemoteDelete::new(b"order#42")
+    ///     .condition("#s = :cancelled")
+    ///     .name("#s", "status")
+    ///     .value(":cancelled", Value::string("cancelled"));
     ///
-    /// let response = client.query(query).await?;
-    /// println!("Found {} items", response.count);
+    /// client.delete_item(delete).await?;
     /// # Ok(())
     /// # }
     /// ```
-    pub async fn query(&mut self, query: crate::query::RemoteQuery) -> Result<crate::query::RemoteQueryResponse> {
-        query.execute(&mut self.inner).await
+    pub async fn delete_item(&mut self, delete: crate::delete::RemoteDelete) -> Result<()> {
+        self.authorize([delete.partition_key()])?;
+        let _written = self.writing([delete.partition_key()]);
+        let call = self.begin().await?;
+        call.finish(delete.execute(&mut self.inner).await)
+    }
+
+    /// Execute a query operation
+    ///
+    /// # Arguments
+    /// * `query` - Query builder with conditions
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteQuery};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let query = RemoteQuery::new(b"user#org1")
+    ///     .sk_begins_with(b"USER#")
+    ///     .limit(10);
+    ///
+    /// let response = client.query(query).await?;
+    /// println!("Found {} items", response.count);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn query(&mut self, query: crate::query::RemoteQuery) -> Result<crate::query::RemoteQueryResponse> {
+        self.authorize([query.partition_key()])?;
+        let call = self.begin().await?;
+        call.finish(query.execute(&mut self.inner).await)
+    }
+
+    /// Execute a query and decode the items it returns into `T`
+    ///
+    /// Shorthand for `query` followed by `RemoteQueryResponse::decode`;
+    /// only the first page is returned, so set a `limit` or page with
+    /// `query` when the partition is large.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteQuery};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// #[derive(serde::Deserialize)]
+    /// struct Order {
+    ///     total: f64,
+    ///     status: String,
+    /// }
+    ///
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    /// let orders: Vec<Order> = client
+    ///     .query_as(RemoteQuery::new(b"customer#42").sk_begins_with(b"order#"))
+    ///     .await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn query_as<T: DeserializeOwned>(&mut self, query: crate::query::RemoteQuery) -> Result<Vec<T>> {
+        self.query(query).await?.decode()
+    }
+
+    /// Delete every item a query matches, returning the number deleted
+    ///
+    /// The server finds and deletes the items in batches, so nothing but
+    /// the count crosses the wire. The sort key condition and filter select
+    /// the items; the limit and start key are ignored. Index queries are
+    /// not supported.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteQuery};
+    /// # use kstone_core::Value;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let query = RemoteQuery::new(b"user#123")
+    ///     .sk_begins_with(b"session#")
+    ///     .filter("#s = :expired")
+    ///     .name("#s", "status")
+    ///     .value(":expired", Value::string("expired"));
+    /// let deleted = client.delete_by_query(query).await?;
+    /// println!("deleted {} sessions", deleted);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn delete_by_query(&mut self, query: crate::query::RemoteQuery) -> Result<u64> {
+        self.authorize([query.partition_key()])?;
+        query.validate()?;
+        let _written = self.writing([query.partition_key()]);
+        let call = self.begin().await?;
+        let result = self
+            .inner
+            .delete_by_query(query.into_proto())
+            .await
+            .map_err(ClientError::from)
+            .map(|response| response.into_inner().deleted_count);
+        call.finish(result)
+    }
+
+    /// Run a query and keep receiving new matching items as they are written
+    ///
+    /// The stream first yields the items the query matches now, then each
+    /// matching item written to the partition afterwards; see
+    /// `LiveQueryStream`. The server must use a disk database, and index
+    /// queries are not supported. Drop the stream to cancel.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteQuery};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let mut live = client.query_live(RemoteQuery::new(b"log#app").sk_begins_with(b"event#")).await?;
+    /// while let Some(item) = live.next().await {
+    ///     println!("{:?}", item?);
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn query_live(&mut self, query: crate::query::RemoteQuery) -> Result<crate::live::LiveQueryStream> {
+        self.authorize([query.partition_key()])?;
+        query.validate()?;
+        let call = self.begin().await?;
+        let opened = self
+            .inner
+            .query_live(query.into_proto())
+            .await
+            .map(|response| crate::live::LiveQueryStream::new(response.into_inner()))
+            .map_err(ClientError::from);
+        call.finish(opened)
     }
 
     /// Execute a scan operation
@@ -296,7 +1094,543 @@ impl Client {
     /// # }
     /// ```
     pub async fn scan(&mut self, scan: crate::scan::RemoteScan) -> Result<crate::scan::RemoteScanResponse> {
-        scan.execute(&mut self.inner).await
+        self.deny_unscoped("Scan")?;
+        let call = self.begin().await?;
+        call.finish(scan.execute(&mut self.inner).await)
+    }
+
+    /// Scan with a deadline, keeping what arrived before it
+    ///
+    /// `timeout` is sent to the server as the call's gRPC deadline and also
+    /// enforced locally. If it expires before the scan completes, the error
+    /// is `ClientError::PartialResult`, holding the items received so far
+    /// and wrapping the `Timeout` error; its `last_key`, when present, can
+    /// be passed to `start_after` to resume. Other errors are returned as is.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, ClientError, RemoteScan};
+    /// # use std::time::Duration;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let items = match client.scan_partial(RemoteScan::new(), Duration::from_millis(200)).await {
+    ///     Ok(response) => response.items,
+    ///     Err(ClientError::PartialResult { response, .. }) => response.items,
+    ///     Err(e) => return Err(e.into()),
+    /// };
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn scan_partial(
+        &mut self,
+        scan: crate::scan::RemoteScan,
+        timeout: Duration,
+    ) -> Result<crate::scan::RemoteScanResponse> {
+        self.deny_unscoped("Scan")?;
+        let deadline = tokio::time::Instant::now() + timeout;
+        let call = self.begin().await?;
+        let result = match tokio::time::timeout_at(deadline, scan.open(&mut self.inner, Some(timeout))).await {
+            Ok(Ok(stream)) => crate::scan::collect_until(stream, deadline).await,
+            Ok(Err(ClientError::Timeout(msg))) => {
+                Err(crate::scan::ScanCollector::default().partial(ClientError::Timeout(msg)))
+            }
+            Ok(Err(e)) => Err(e),
+            Err(_) => Err(crate::scan::ScanCollector::default()
+                .partial(ClientError::Timeout("Scan deadline exceeded".to_string()))),
+        };
+        call.finish(result)
+    }
+
+    /// Stream scan results through a channel as they arrive
+    ///
+    /// Unlike `scan`, which returns once the whole response has been
+    /// received, items are sent on the first channel as soon as their chunk
+    /// of the response stream arrives, so callers can start rendering
+    /// before the scan completes. The second channel then receives exactly
+    /// one result: `Ok(())` once every item has been sent, or the error that
+    /// ended the scan. The item channel is closed before the result is sent.
+    /// Dropping the item receiver stops the scan.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteScan};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let (mut items, done) = client.scan_channel(RemoteScan::new());
+    /// while let Some(item) = items.recv().await {
+    ///     println!("{:?}", item);
+    /// }
+    /// done.await??;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn scan_channel(
+        &self,
+        scan: crate::scan::RemoteScan,
+    ) -> (mpsc::Receiver<Item>, oneshot::Receiver<Result<()>>) {
+        let (item_tx, item_rx) = mpsc::channel(SCAN_CHANNEL_CAPACITY);
+        let (done_tx, done_rx) = oneshot::channel();
+        let mut client = self.clone();
+
+        tokio::spawn(async move {
+            let result = client.forward_scan(scan, &item_tx).await;
+            drop(item_tx);
+            let _ = done_tx.send(result);
+        });
+
+        (item_rx, done_rx)
+    }
+
+    async fn forward_scan(&mut self, scan: crate::scan::RemoteScan, items: &mpsc::Sender<Item>) -> Result<()> {
+        self.deny_unscoped("Scan")?;
+        let call = self.begin().await?;
+        let result = match scan.open(&mut self.inner, None).await {
+            Ok(stream) => crate::scan::forward_items(stream, items).await,
+            Err(e) => Err(e),
+        };
+        call.finish(result)
+    }
+
+    /// Read a scan page by page
+    ///
+    /// The returned `ScanPages` can produce a cursor after any page; see
+    /// `scan_from_cursor`.
+    pub fn scan_pages(&self, scan: crate::scan::RemoteScan) -> crate::cursor::ScanPages {
+        crate::cursor::ScanPages::new(self.clone(), scan, Default::default())
+    }
+
+    /// Resume a scan from a cursor saved with `ScanPages::cursor`
+    ///
+    /// `scan` should be built the same way as the original (filter, index,
+    /// page size); the position and segment are taken from the cursor.
+    /// Fails with `InvalidArgument` if the cursor is malformed or was
+    /// written by an incompatible version.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteScan};
+    /// # async fn example(saved: Vec<u8>) -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let mut pages = client.scan_from_cursor(RemoteScan::new().limit(100), &saved)?;
+    /// while let Some(page) = pages.next_page().await? {
+    ///     println!("{} items", page.items.len());
+    ///     std::fs::write("scan.cursor", pages.cursor())?;
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub fn scan_from_cursor(&self, scan: crate::scan::RemoteScan, cursor: &[u8]) -> Result<crate::cursor::ScanPages> {
+        let position = crate::cursor::ScanPosition::decode(cursor)?;
+        Ok(crate::cursor::ScanPages::new(self.clone(), scan, position))
+    }
+
+    /// Count the items a query matches (after its filter)
+    ///
+    /// The server returns only the count; no items are transferred.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteQuery, Value};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let active = client
+    ///     .count(RemoteQuery::new(b"org#acme").filter("status = :s").value(":s", Value::string("active")))
+    ///     .await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn count(&mut self, query: crate::query::RemoteQuery) -> Result<u64> {
+        self.authorize([query.partition_key()])?;
+        let call = self.begin().await?;
+        let response = call.finish(query.select_count().execute(&mut self.inner).await)?;
+        Ok(response.count as u64)
+    }
+
+    /// Count the items a scan matches (after its filter)
+    pub async fn count_scan(&mut self, scan: crate::scan::RemoteScan) -> Result<u64> {
+        self.deny_unscoped("Scan")?;
+        let call = self.begin().await?;
+        let response = call.finish(scan.select_count().execute(&mut self.inner).await)?;
+        Ok(response.count as u64)
+    }
+
+    /// Sum a numeric attribute over the items a query matches (after its
+    /// filter)
+    ///
+    /// The server adds up the values and returns no items. Items without
+    /// the attribute or with a non-numeric value there are skipped, so a
+    /// query matching none of them sums to 0. Fails with `Unimplemented`
+    /// if the server predates sums.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteQuery};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let total = client.sum(RemoteQuery::new(b"order#42"), "amount").await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn sum(&mut self, query: crate::query::RemoteQuery, attribute: impl Into<String>) -> Result<kstone_core::Value> {
+        self.authorize([query.partition_key()])?;
+        let call = self.begin().await?;
+        let response = call.finish(query.select_count().sum(attribute).execute(&mut self.inner).await)?;
+        response
+            .sum
+            .ok_or_else(|| ClientError::Unimplemented("Server does not support sums".to_string()))
+    }
+
+    /// Sum a numeric attribute over the items a scan matches (after its
+    /// filter)
+    pub async fn sum_scan(&mut self, scan: crate::scan::RemoteScan, attribute: impl Into<String>) -> Result<kstone_core::Value> {
+        self.deny_unscoped("Scan")?;
+        let call = self.begin().await?;
+        let response = call.finish(scan.select_count().sum(attribute).execute(&mut self.inner).await)?;
+        response
+            .sum
+            .ok_or_else(|| ClientError::Unimplemented("Server does not support sums".to_string()))
+    }
+
+    /// Run a query to completion, following `last_key` across pages
+    ///
+    /// Stops with `ClientError::LimitExceeded` once a budget in `limits` is
+    /// reached and more pages remain; the error carries the items so far
+    /// and the key to resume from with `start_after`.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, ClientError, RemoteQuery, ResultLimits};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let limits = ResultLimits::new().max_items(1000).max_bytes(16 << 20);
+    /// match client.query_all(RemoteQuery::new(b"org#acme"), limits).await {
+    ///     Ok(items) => println!("{} items", items.len()),
+    ///     Err(ClientError::LimitExceeded { items, last_key, .. }) => {
+    ///         println!("stopped after {} items, resume after {:?}", items.len(), last_key);
+    ///     }
+    ///     Err(e) => return Err(e.into()),
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn query_all(
+        &mut self,
+        mut query: crate::query::RemoteQuery,
+        limits: crate::limits::ResultLimits,
+    ) -> Result<Vec<Item>> {
+        self.authorize([query.partition_key()])?;
+        let mut aggregate = crate::limits::Aggregate::new(limits);
+        loop {
+            let mut page = query.clone();
+            if let Some(limit) = aggregate.page_limit(query.page_limit()) {
+                page = page.limit(limit);
+            }
+            let call = self.begin().await?;
+            let response = call.finish(page.execute(&mut self.inner).await)?;
+            match aggregate.add(response.items, response.last_key)? {
+                Some((pk, sk)) => query = query.start_after(&pk, sk.as_deref()),
+                None => return Ok(aggregate.into_items()),
+            }
+        }
+    }
+
+    /// Run a scan to completion, following `last_key` across pages
+    ///
+    /// Budgets in `limits` behave as in `query_all`.
+    pub async fn scan_all(
+        &mut self,
+        mut scan: crate::scan::RemoteScan,
+        limits: crate::limits::ResultLimits,
+    ) -> Result<Vec<Item>> {
+        self.deny_unscoped("Scan")?;
+        let mut aggregate = crate::limits::Aggregate::new(limits);
+        loop {
+            let mut page = scan.clone();
+            if let Some(limit) = aggregate.page_limit(scan.page_limit()) {
+                page = page.limit(limit);
+            }
+            let call = self.begin().await?;
+            let response = call.finish(page.execute(&mut self.inner).await)?;
+            match aggregate.add(response.items, response.last_key)? {
+                Some((pk, sk)) => scan = scan.start_after(&pk, sk.as_deref()),
+                None => return Ok(aggregate.into_items()),
+            }
+        }
+    }
+
+    /// Load an export written by `Database::export_to`
+    ///
+    /// Items are written with batched puts of `IMPORT_BATCH_SIZE`, keeping
+    /// their keys and value types. Existing items with the same keys are
+    /// overwritten. The reader is read synchronously, a line at a time.
+    /// On error, batches already sent stay written.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let file = std::io::BufReader::new(std::fs::File::open("local.export")?);
+    /// let stats = client.import_from(file).await?;
+    /// println!("imported {} items in {} batches", stats.items, stats.batches);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn import_from<R: std::io::BufRead>(&mut self, reader: R) -> Result<crate::import::ImportStats> {
+        let records = kstone_core::ExportReader::new(reader)
+            .map_err(|e| ClientError::InvalidArgument(e.to_string()))?;
+
+        let mut stats = crate::import::ImportStats::default();
+        let mut batch = crate::batch::RemoteBatchWriteRequest::new();
+        let mut pending = 0;
+        for record in records {
+            let record = record.map_err(|e| ClientError::InvalidArgument(e.to_string()))?;
+            batch = match &record.sk {
+                Some(sk) => batch.put_with_sk(&record.pk, sk, record.item),
+                None => batch.put(&record.pk, record.item),
+            };
+            pending += 1;
+
+            if pending == crate::import::IMPORT_BATCH_SIZE {
+                self.import_batch(std::mem::take(&mut batch), &mut stats, pending).await?;
+                pending = 0;
+            }
+        }
+        if pending > 0 {
+            self.import_batch(batch, &mut stats, pending).await?;
+        }
+        Ok(stats)
+    }
+
+    /// Export the table, or a key range of it, to `writer`
+    ///
+    /// Writes the portable export format, which `import_from` loads back.
+    /// The stream is resumed after the last item written if it breaks with
+    /// a transient error (see the `export` module). Keep the returned
+    /// manifest with the backup to check it later.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteExport};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    /// let file = std::io::BufWriter::new(std::fs::File::create("server.export")?);
+    /// let stats = client.export_to(RemoteExport::new(), file).await?;
+    /// println!("exported {} items, crc32c {:08x}", stats.manifest.items, stats.manifest.checksum);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn export_to<W: std::io::Write>(
+        &mut self,
+        export: crate::export::RemoteExport,
+        writer: W,
+    ) -> Result<crate::export::ExportStats> {
+        self.deny_unscoped("exports")?;
+        export.validate()?;
+        let max_resumes = export.resumes();
+        crate::export::run_export(writer, max_resumes, |after| {
+            let request = export.to_proto(after.as_ref());
+            let mut client = self.clone();
+            async move {
+                let call = client.begin().await?;
+                let opened = client
+                    .inner
+                    .export(request)
+                    .await
+                    .map(|response| response.into_inner())
+                    .map_err(ClientError::from);
+                call.finish(opened)
+            }
+        })
+        .await
+    }
+
+    /// Rename attribute `from` to `to` on every item in `scope`
+    ///
+    /// Each item holding `from` is rewritten with an update expression
+    /// that copies it to `to` and removes it; items without it are left
+    /// alone. Safe to run again after an interruption (see the `migrate`
+    /// module).
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteExport};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    /// let stats = client.migrate_attribute(RemoteExport::new(), "status", "state").await?;
+    /// println!("migrated {} of {} items", stats.migrated, stats.scanned);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn migrate_attribute(
+        &mut self,
+        scope: crate::export::RemoteExport,
+        from: &str,
+        to: &str,
+    ) -> Result<crate::migrate::MigrateStats> {
+        use crate::migrate::{MIGRATE_CONDITION, MIGRATE_EXPRESSION};
+
+        self.deny_unscoped("attribute migrations")?;
+        scope.validate()?;
+        if from.is_empty() || to.is_empty() || from == to {
+            return Err(ClientError::InvalidArgument(
+                "Attribute names must be non-empty and different".to_string(),
+            ));
+        }
+
+        let call = self.begin().await?;
+        let opened = self
+            .inner
+            .export(scope.to_proto(None))
+            .await
+            .map(|response| response.into_inner())
+            .map_err(ClientError::from);
+        let mut stream = call.finish(opened)?;
+
+        let mut stats = crate::migrate::MigrateStats::default();
+        while let Some(chunk) = stream.message().await? {
+            if crate::convert::export_checksum(&chunk.entries) != chunk.checksum {
+                return Err(ClientError::DataCorruption(
+                    "Export chunk failed its checksum".to_string(),
+                ));
+            }
+            for entry in chunk.entries {
+                let (key, item) = crate::export::entry_to_ks(entry)?;
+                stats.scanned += 1;
+                if !item.contains_key(from) {
+                    stats.skipped += 1;
+                    continue;
+                }
+
+                let update = match &key.sk {
+                    Some(sk) => crate::update::RemoteUpdate::with_sk(&key.pk, sk),
+                    None => crate::update::RemoteUpdate::new(&key.pk),
+                }
+                .expression(MIGRATE_EXPRESSION)
+                .condition(MIGRATE_CONDITION)
+                .name("#from", from)
+                .name("#to", to);
+                match self.update(update).await {
+                    Ok(_) => stats.migrated += 1,
+                    // Migrated since the item was read
+                    Err(ClientError::ConditionCheckFailed(_)) => stats.skipped += 1,
+                    Err(e) => return Err(e),
+                }
+            }
+        }
+        Ok(stats)
+    }
+
+    async fn import_batch(
+        &mut self,
+        batch: crate::batch::RemoteBatchWriteRequest,
+        stats: &mut crate::import::ImportStats,
+        items: usize,
+    ) -> Result<()> {
+        if let Some(rate) = &self.bulk_rate {
+            rate.acquire(items).await;
+        }
+        let response = self.batch_write(batch).await?;
+        if !response.success {
+            return Err(ClientError::InternalError("Import batch write was not applied".to_string()));
+        }
+        stats.items += items as u64;
+        stats.batches += 1;
+        Ok(())
+    }
+
+    /// Scan the whole table with `parallelism` concurrent segments
+    ///
+    /// Each segment runs in its own task on a clone of this client and
+    /// passes every item to `f`. `f` is called concurrently from several
+    /// tasks, so it must do its own synchronization. If the scan has a
+    /// limit, each segment pages through its share using that page size.
+    /// The first error (from the server or from `f`) cancels all segments
+    /// and is returned.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, RemoteScan};
+    /// # use std::sync::atomic::{AtomicUsize, Ordering};
+    /// # use std::sync::Arc;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let seen = Arc::new(AtomicUsize::new(0));
+    /// let counter = Arc::clone(&seen);
+    /// client
+    ///     .parallel_scan(RemoteScan::new(), 8, move |_item| {
+    ///         counter.fetch_add(1, Ordering::Relaxed);
+    ///         Ok(())
+    ///     })
+    ///     .await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn parallel_scan<F>(&self, scan: crate::scan::RemoteScan, parallelism: usize, f: F) -> Result<()>
+    where
+        F: Fn(Item) -> Result<()> + Send + Sync + 'static,
+    {
+        if parallelism == 0 {
+            return Err(ClientError::InvalidArgument("Parallelism must be at least 1".to_string()));
+        }
+
+        self.deny_unscoped("Scan")?;
+
+        // Segments go through `scan`, which reports to the circuit breaker
+        let _call = self.calls.begin()?;
+        let f = Arc::new(f);
+        let mut segments = tokio::task::JoinSet::new();
+
+        for segment in 0..parallelism {
+            let mut client = self.clone();
+            let scan = scan.clone().segment(segment, parallelism);
+            let f = Arc::clone(&f);
+
+            segments.spawn(async move {
+                let page_limit = scan.page_limit();
+                let mut page = scan.clone();
+                loop {
+                    let response = client.scan(page).await?;
+                    let scanned = response.scanned_count;
+                    for item in response.items {
+                        f(item)?;
+                    }
+                    // Scanned items are only known afterwards, so the next
+                    // page waits off this one's cost
+                    if let Some(rate) = &client.bulk_rate {
+                        rate.acquire(scanned).await;
+                    }
+
+                    // Continue only if a page limit was hit and there is more
+                    match (page_limit, response.last_key) {
+                        (Some(limit), Some((pk, sk))) if scanned >= limit as usize => {
+                            page = scan.clone().start_after(&pk, sk.as_deref());
+                        }
+                        _ => return Ok::<(), ClientError>(()),
+                    }
+                }
+            });
+        }
+
+        while let Some(joined) = segments.join_next().await {
+            let result = joined
+                .map_err(|e| ClientError::InternalError(format!("Scan segment task failed: {}", e)))
+                .and_then(|r| r);
+            if let Err(e) = result {
+                segments.abort_all();
+                return Err(e);
+            }
+        }
+
+        Ok(())
     }
 
     /// Execute a batch get operation
@@ -320,7 +1654,16 @@ impl Client {
     /// # }
     /// ```
     pub async fn batch_get(&mut self, request: crate::batch::RemoteBatchGetRequest) -> Result<crate::batch::RemoteBatchGetResponse> {
-        request.execute(&mut self.inner).await
+        self.authorize(request.partition_keys())?;
+        if let Some(cache) = &self.read_cache {
+            if let Some(items) = cache.lookup_all(request.keys()) {
+                let items: Vec<Item> = items.into_iter().flatten().collect();
+                let count = items.len();
+                return Ok(crate::batch::RemoteBatchGetResponse { items, count });
+            }
+        }
+        let call = self.begin().await?;
+        call.finish(request.execute(&mut self.inner).await)
     }
 
     /// Execute a batch write operation
@@ -349,7 +1692,53 @@ impl Client {
     /// # }
     /// ```
     pub async fn batch_write(&mut self, request: crate::batch::RemoteBatchWriteRequest) -> Result<crate::batch::RemoteBatchWriteResponse> {
-        request.execute(&mut self.inner).await
+        self.authorize(request.partition_keys())?;
+        let _written = self.writing(request.partition_keys());
+        let call = self.begin().await?;
+        call.finish(request.execute(&mut self.inner).await)
+    }
+
+    /// Open a stream for pushing many writes without a round trip each
+    ///
+    /// Writes sent on the stream are applied in order and acked
+    /// asynchronously; see `write_stream` for details. Not available to
+    /// tenant-scoped clients, since the stream's writes are not checked
+    /// one by one.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, WriteOp};
+    /// # use kstone_core::Value;
+    /// # use std::collections::HashMap;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let mut stream = client.write_stream().await?;
+    /// for i in 0..1000 {
+    ///     let mut item = HashMap::new();
+    ///     item.insert("n".to_string(), Value::number(i));
+    ///     stream.send(WriteOp::put(format!("event#{}", i).as_bytes(), item)).await?;
+    /// }
+    /// stream.close();
+    ///
+    /// while let Some(ack) = stream.next_ack().await {
+    ///     ack?.result?;
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn write_stream(&mut self) -> Result<crate::write_stream::WriteStream> {
+        self.deny_unscoped("write streams")?;
+        let call = self.begin().await?;
+        let (tx, rx) = futures::channel::mpsc::channel(crate::write_stream::WRITE_STREAM_BUFFER);
+        let read_cache = self.read_cache.clone();
+        let opened = self
+            .inner
+            .write_stream(rx)
+            .await
+            .map(|response| crate::write_stream::WriteStream::new(tx, response.into_inner(), read_cache))
+            .map_err(ClientError::from);
+        call.finish(opened)
     }
 
     /// Execute a transactional get operation
@@ -373,7 +1762,9 @@ impl Client {
     /// # }
     /// ```
     pub async fn transact_get(&mut self, request: crate::transaction::RemoteTransactGetRequest) -> Result<crate::transaction::RemoteTransactGetResponse> {
-        request.execute(&mut self.inner).await
+        self.authorize(request.partition_keys())?;
+        let call = self.begin().await?;
+        call.finish(request.execute(&mut self.inner).await)
     }
 
     /// Execute a transactional write operation
@@ -399,8 +1790,106 @@ impl Client {
     /// # Ok(())
     /// # }
     /// ```
-    pub async fn transact_write(&mut self, request: crate::transaction::RemoteTransactWriteRequest) -> Result<()> {
-        request.execute(&mut self.inner).await
+    pub async fn transact_write(&mut self, request: crate::transaction::RemoteTransactWriteRequest) -> Result<crate::transaction::RemoteTransactWriteResponse> {
+        self.authorize(request.partition_keys())?;
+        let _written = self.writing(request.partition_keys());
+        let call = self.begin().await?;
+        call.finish(request.execute(&mut self.inner).await)
+    }
+
+    /// Apply a transactional write in chunks of `options.chunk_size`
+    /// operations, rolling back committed chunks if a later one fails
+    ///
+    /// This is not atomic; see the `chunked` module for exactly what is
+    /// guaranteed. If a chunk fails and every earlier chunk is rolled back,
+    /// the chunk's error is returned; if rollback fails too, the result is
+    /// `ClientError::CompensationFailed`. The request's client request
+    /// token and dry-run flag are ignored.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{ChunkOptions, Client, RemoteTransactWriteRequest};
+    /// # use std::collections::HashMap;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let mut request = RemoteTransactWriteRequest::new();
+    /// for i in 0..100 {
+    ///     request = request.put(format!("user#{}", i).as_bytes(), HashMap::new());
+    /// }
+    /// let response = client.transact_write_chunked(request, ChunkOptions::new()).await?;
+    /// println!("committed {} chunks", response.chunks);
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn transact_write_chunked(
+        &mut self,
+        request: crate::transaction::RemoteTransactWriteRequest,
+        options: crate::chunked::ChunkOptions,
+    ) -> Result<crate::chunked::ChunkedWriteResponse> {
+        self.authorize(request.partition_keys())?;
+        let _written = self.writing(request.partition_keys());
+
+        let mut committed: Vec<crate::chunked::PriorState> = Vec::new();
+        for (index, chunk) in request.into_chunks(options.size()).into_iter().enumerate() {
+            match self.commit_chunk(chunk).await {
+                Ok(prior) => committed.push(prior),
+                Err(source) => return Err(self.roll_back_chunks(index, source, committed).await),
+            }
+        }
+        Ok(crate::chunked::ChunkedWriteResponse { chunks: committed.len() })
+    }
+
+    /// Read what `chunk` will overwrite, then commit it
+    async fn commit_chunk(
+        &mut self,
+        chunk: crate::transaction::RemoteTransactWriteRequest,
+    ) -> Result<crate::chunked::PriorState> {
+        let keys = chunk.written_keys();
+        let read = keys.iter().fold(crate::transaction::RemoteTransactGetRequest::new(), |read, (pk, sk)| match sk {
+            Some(sk) => read.get_with_sk(pk, sk),
+            None => read.get(pk),
+        });
+        let prior = self.transact_get(read).await?.items;
+
+        let response = self.transact_write(chunk).await?;
+        if !response.success {
+            return Err(ClientError::TransactionAborted("Chunk was not committed".to_string()));
+        }
+        Ok(keys.into_iter().zip(prior).collect())
+    }
+
+    /// Undo `committed` chunks, newest first, after chunk `failed_chunk` failed
+    async fn roll_back_chunks(
+        &mut self,
+        failed_chunk: usize,
+        source: ClientError,
+        committed: Vec<crate::chunked::PriorState>,
+    ) -> ClientError {
+        let mut unrestored = Vec::new();
+        let mut compensation = None;
+        for (index, prior) in committed.iter().enumerate().rev() {
+            let restored = match self.transact_write(crate::chunked::compensation(prior)).await {
+                Ok(response) if response.success => continue,
+                Ok(_) => ClientError::TransactionAborted("Rollback was not committed".to_string()),
+                Err(e) => e,
+            };
+            unrestored.push(index);
+            compensation.get_or_insert(restored);
+        }
+
+        match compensation {
+            None => source,
+            Some(compensation) => {
+                unrestored.reverse();
+                ClientError::CompensationFailed {
+                    failed_chunk,
+                    source: Box::new(source),
+                    unrestored,
+                    compensation: Box::new(compensation),
+                }
+            }
+        }
     }
 
     /// Update an item using update expression
@@ -425,7 +1914,10 @@ impl Client {
     /// # }
     /// ```
     pub async fn update(&mut self, request: crate::update::RemoteUpdate) -> Result<crate::update::RemoteUpdateResponse> {
-        request.execute(&mut self.inner).await
+        self.authorize([request.partition_key()])?;
+        let _written = self.writing([request.partition_key()]);
+        let call = self.begin().await?;
+        call.finish(request.execute(&mut self.inner).await)
     }
 
     /// Execute a PartiQL statement
@@ -448,19 +1940,221 @@ impl Client {
     /// # }
     /// ```
     pub async fn execute_statement(&mut self, statement: impl Into<String>) -> Result<crate::partiql::RemoteExecuteStatementResponse> {
-        let statement = statement.into();
-        let request = kstone_proto::ExecuteStatementRequest { statement };
+        self.execute_with_parameters(statement.into(), Vec::new()).await
+    }
+
+    /// Prepare a PartiQL statement for repeated execution
+    ///
+    /// Values in WHERE conditions, SET assignments and INSERT maps may be
+    /// written `?` and supplied on each `execute`. The server parses the
+    /// statement once and keeps it, so hot statements skip parsing; one it
+    /// has dropped since is parsed again transparently.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{Client, Value};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let mut lookup = client.prepare("SELECT * FROM users WHERE pk = ?").await?;
+    /// for user in ["user#1", "user#2"] {
+    ///     let response = lookup.execute(&[Value::string(user)]).await?;
+    ///     println!("{:?}", response);
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn prepare(&self, statement: impl Into<String>) -> Result<crate::partiql::RemotePreparedStatement> {
+        let mut client = self.clone();
+        client.deny_unscoped("PartiQL")?;
+        let call = client.begin().await?;
+        let sql = statement.into();
+        let request = kstone_proto::PrepareRequest { statement: sql.clone() };
+
+        let result = client.inner
+            .prepare(request)
+            .await
+            .map_err(ClientError::from);
+        let response = call.finish(result)?.into_inner();
+
+        Ok(crate::partiql::RemotePreparedStatement::new(
+            client,
+            sql,
+            response.parameter_count as usize,
+        ))
+    }
 
-        let response = self.inner
+    pub(crate) async fn execute_with_parameters(
+        &mut self,
+        statement: String,
+        parameters: Vec<kstone_proto::Value>,
+    ) -> Result<crate::partiql::RemoteExecuteStatementResponse> {
+        self.deny_unscoped("PartiQL")?;
+        let _written = self.writing_anywhere();
+        let call = self.begin().await?;
+        let request = kstone_proto::ExecuteStatementRequest { statement, parameters };
+
+        let result = self.inner
             .execute_statement(request)
-            .await?
-            .into_inner();
+            .await
+            .map_err(ClientError::from);
+        let response = call.finish(result)?.into_inner();
 
         crate::partiql::parse_execute_statement_response(response)
     }
 
+    /// Explain how a PartiQL statement would be executed, without running it
+    ///
+    /// The plan says whether the statement queries partitions, through an
+    /// index or the base table, or scans the whole table, and estimates the
+    /// items it would examine (an upper bound: sort key conditions, filters
+    /// and LIMIT are not counted).
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let plan = client.explain("SELECT * FROM users WHERE age > 30").await?;
+    /// if plan.is_full_scan() {
+    ///     println!("full table scan over ~{} items", plan.estimated_items);
+    /// }
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn explain(&mut self, statement: impl Into<String>) -> Result<crate::partiql::RemoteQueryPlan> {
+        self.deny_unscoped("PartiQL")?;
+        let call = self.begin().await?;
+        let request = kstone_proto::ExplainRequest {
+            statement: statement.into(),
+        };
+
+        let result = self.inner
+            .explain(request)
+            .await
+            .map_err(ClientError::from);
+        let response = call.finish(result)?.into_inner();
+
+        crate::partiql::parse_explain_response(response)
+    }
+
+    /// Call any RPC by its method name, bypassing the typed wrappers
+    ///
+    /// `method` is the RPC name as declared in the service (e.g. `"Get"`),
+    /// and the request and response are the generated `kstone_proto`
+    /// messages. Meant for tooling and for methods the server has added that
+    /// this client doesn't wrap yet. Metadata, credentials, draining and the
+    /// circuit breaker apply as for typed calls; with a tenant guard set raw
+    /// calls are rejected, since their partition keys can't be checked.
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::Client;
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let mut client = Client::connect("http://localhost:50051").await?;
+    ///
+    /// let request = kstone_proto::GetRequest {
+    ///     partition_key: b"user#123".to_vec(),
+    ///     sort_key: None,
+    ///     return_consumed_capacity: false,
+    /// };
+    /// let response: kstone_proto::GetResponse = client.raw_call("Get", request).await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn raw_call<Req, Resp>(&mut self, method: &str, request: Req) -> Result<Resp>
+    where
+        Req: prost::Message + Send + Sync + 'static,
+        Resp: prost::Message + Default + Send + Sync + 'static,
+    {
+        self.deny_unscoped(method)?;
+        let _written = self.writing_anywhere();
+        let path = PathAndQuery::try_from(format!("/{}/{}", SERVICE_NAME, method))
+            .map_err(|e| ClientError::InvalidArgument(format!("Invalid method name '{}': {}", method, e)))?;
+        let call = self.begin().await?;
+
+        let mut grpc = tonic::client::Grpc::new(InterceptedService::new(self.channel.clone(), self.metadata.clone()));
+        let result = match grpc.ready().await {
+            Ok(()) => grpc
+                .unary(tonic::Request::new(request), path, ProstCodec::<Req, Resp>::default())
+                .await
+                .map_err(ClientError::from),
+            Err(e) => Err(ClientError::ConnectionError(format!("Service was not ready: {}", e))),
+        };
+        Ok(call.finish(result)?.into_inner())
+    }
+
     /// Get a reference to the underlying gRPC client
-    pub(crate) fn inner_mut(&mut self) -> &mut KeystoneDbClient<Channel> {
+    pub(crate) fn inner_mut(&mut self) -> &mut KeystoneDbClient<Transport> {
         &mut self.inner
     }
+
+    /// Drop the partitions a write touches from the read cache once the
+    /// returned guard goes out of scope
+    fn writing<'a>(&self, partition_keys: impl IntoIterator<Item = &'a [u8]>) -> Option<Invalidation> {
+        self.read_cache
+            .as_ref()
+            .map(|cache| Invalidation::partitions(cache, partition_keys))
+    }
+
+    /// Clear the read cache once a call that may write any key ends
+    fn writing_anywhere(&self) -> Option<Invalidation> {
+        self.read_cache.as_ref().map(Invalidation::all)
+    }
+
+    /// Check partition keys against the tenant guard, if any
+    fn authorize<'a>(&self, partition_keys: impl IntoIterator<Item = &'a [u8]>) -> Result<()> {
+        match &self.tenant {
+            Some(tenant) => tenant.check(partition_keys),
+            None => Ok(()),
+        }
+    }
+
+    /// Reject an operation not scoped to a partition when a tenant guard is set
+    fn deny_unscoped(&self, operation: &str) -> Result<()> {
+        match &self.tenant {
+            Some(tenant) => Err(tenant.deny(operation)),
+            None => Ok(()),
+        }
+    }
+
+    /// Start a call: wait for a concurrency slot, reopen an idle-retired
+    /// connection, register the call as in flight, fetch the current
+    /// credential and check the circuit breaker
+    async fn begin(&self) -> Result<Call> {
+        let slot = match &self.concurrency {
+            Some(limit) => Some(limit.acquire().await?),
+            None => None,
+        };
+        if let Some(connection) = &self.connection {
+            connection.touch()?;
+        }
+        let guard = self.calls.begin()?;
+        if let Some(credentials) = &self.credentials {
+            credentials.ensure_current().await?;
+        }
+        let permit = match &self.breaker {
+            Some(breaker) => Some(breaker.acquire()?),
+            None => None,
+        };
+        Ok(Call { _guard: guard, _slot: slot, permit })
+    }
+}
+
+/// A call in progress
+struct Call {
+    _guard: CallGuard,
+    _slot: Option<tokio::sync::OwnedSemaphorePermit>,
+    permit: Option<BreakerPermit>,
+}
+
+impl Call {
+    /// Report the call's outcome to the circuit breaker and pass it through
+    fn finish<T>(self, result: Result<T>) -> Result<T> {
+        if let Some(permit) = self.permit {
+            permit.record(&result);
+        }
+        result
+    }
 }
@@ -0,0 +1,386 @@
+/// Fluent builder for condition expressions
+///
+/// Builds condition expressions without hand-written placeholders. Every
+/// attribute name is aliased (`#n0`, `#n1`, ...) and every value is bound
+/// (`:c0`, `:c1`, ...), so the expression string and its names/values maps
+/// always agree.
+///
+/// # Example
+///
+/// ```
+/// use kstone_client::cond;
+/// use kstone_core::Value;
+///
+/// let condition = cond::and(vec![
+///     cond::attr_not_exists("pk"),
+///     cond::eq("version", Value::number(1)),
+/// ]);
+///
+/// let compiled = condition.compile();
+/// assert_eq!(compiled.expression, "(attribute_not_exists(#n0) AND #n1 = :c0)");
+/// ```
+
+use kstone_core::Value;
+use std::collections::HashMap;
+
+/// Condition expression tree
+#[derive(Debug, Clone, PartialEq)]
+pub enum Cond {
+    AttrExists(String),
+    AttrNotExists(String),
+    Eq(String, Value),
+    Ne(String, Value),
+    Lt(String, Value),
+    Le(String, Value),
+    Gt(String, Value),
+    Ge(String, Value),
+    Between(String, Value, Value),
+    BeginsWith(String, Value),
+    Contains(String, Value),
+    AttributeType(String, String),
+    And(Vec<Cond>),
+    Or(Vec<Cond>),
+    Not(Box<Cond>),
+}
+
+/// A compiled condition: expression string plus bound names and values
+#[derive(Debug, Clone, PartialEq, Default)]
+pub struct CompiledCondition {
+    /// Expression string using only `#name` and `:value` placeholders
+    pub expression: String,
+    /// Expression attribute names (#n0 -> actual name)
+    pub names: HashMap<String, String>,
+    /// Expression attribute values (:c0 -> value)
+    pub values: HashMap<String, Value>,
+}
+
+/// attribute_exists(name)
+pub fn attr_exists(name: impl Into<String>) -> Cond {
+    Cond::AttrExists(name.into())
+}
+
+/// attribute_not_exists(name)
+pub fn attr_not_exists(name: impl Into<String>) -> Cond {
+    Cond::AttrNotExists(name.into())
+}
+
+/// name = value
+pub fn eq(name: impl Into<String>, value: Value) -> Cond {
+    Cond::Eq(name.into(), value)
+}
+
+/// name <> value
+pub fn ne(name: impl Into<String>, value: Value) -> Cond {
+    Cond::Ne(name.into(), value)
+}
+
+/// name < value
+pub fn lt(name: impl Into<String>, value: Value) -> Cond {
+    Cond::Lt(name.into(), value)
+}
+
+/// name <= value
+pub fn le(name: impl Into<String>, value: Value) -> Cond {
+    Cond::Le(name.into(), value)
+}
+
+/// name > value
+pub fn gt(name: impl Into<String>, value: Value) -> Cond {
+    Cond::Gt(name.into(), value)
+}
+
+/// name >= value
+pub fn ge(name: impl Into<String>, value: Value) -> Cond {
+    Cond::Ge(name.into(), value)
+}
+
+/// name BETWEEN lower AND upper (inclusive)
+pub fn between(name: impl Into<String>, lower: Value, upper: Value) -> Cond {
+    Cond::Between(name.into(), lower, upper)
+}
+
+/// begins_with(name, prefix)
+pub fn begins_with(name: impl Into<String>, prefix: Value) -> Cond {
+    Cond::BeginsWith(name.into(), prefix)
+}
+
+/// contains(name, operand): substring of a string or element of a list
+pub fn contains(name: impl Into<String>, operand: Value) -> Cond {
+    Cond::Contains(name.into(), operand)
+}
+
+/// attribute_type(name, type_code)
+///
+/// Type codes: "S", "N", "B", "BOOL", "NULL", "L", "M", "VEC", "TS".
+pub fn attribute_type(name: impl Into<String>, type_code: impl Into<String>) -> Cond {
+    Cond::AttributeType(name.into(), type_code.into())
+}
+
+/// All conditions must hold (always true when there are none)
+pub fn and(conditions: Vec<Cond>) -> Cond {
+    Cond::And(conditions)
+}
+
+/// At least one condition must hold (always false when there are none)
+pub fn or(conditions: Vec<Cond>) -> Cond {
+    Cond::Or(conditions)
+}
+
+/// Negate a condition
+pub fn not(condition: Cond) -> Cond {
+    Cond::Not(Box::new(condition))
+}
+
+impl Cond {
+    /// Combine with another condition using AND
+    pub fn and(self, other: Cond) -> Cond {
+        match self {
+            Cond::And(mut conditions) => {
+                conditions.push(other);
+                Cond::And(conditions)
+            }
+            cond => Cond::And(vec![cond, other]),
+        }
+    }
+
+    /// Combine with another condition using OR
+    pub fn or(self, other: Cond) -> Cond {
+        match self {
+            Cond::Or(mut conditions) => {
+                conditions.push(other);
+                Cond::Or(conditions)
+            }
+            cond => Cond::Or(vec![cond, other]),
+        }
+    }
+
+    /// Compile into an expression string with names/values maps
+    pub fn compile(&self) -> CompiledCondition {
+        let mut compiler = Compiler::default();
+        let expression = compiler.compile(self);
+        CompiledCondition {
+            expression,
+            names: compiler.names,
+            values: compiler.values,
+        }
+    }
+}
+
+/// Assigns placeholders while walking the condition tree
+#[derive(Default)]
+struct Compiler {
+    names: HashMap<String, String>,
+    name_aliases: HashMap<String, String>,
+    values: HashMap<String, Value>,
+}
+
+impl Compiler {
+    fn name(&mut self, name: &str) -> String {
+        if let Some(alias) = self.name_aliases.get(name) {
+            return alias.clone();
+        }
+        let alias = format!("#n{}", self.name_aliases.len());
+        self.name_aliases.insert(name.to_string(), alias.clone());
+        self.names.insert(alias.clone(), name.to_string());
+        alias
+    }
+
+    fn value(&mut self, value: &Value) -> String {
+        let placeholder = format!(":c{}", self.values.len());
+        self.values.insert(placeholder.clone(), value.clone());
+        placeholder
+    }
+
+    fn comparison(&mut self, name: &str, op: &str, value: &Value) -> String {
+        let n = self.name(name);
+        let v = self.value(value);
+        format!("{} {} {}", n, op, v)
+    }
+
+    fn join(&mut self, conditions: &[Cond], op: &str) -> String {
+        let parts: Vec<String> = conditions.iter().map(|c| self.compile(c)).collect();
+        match parts.len() {
+            // The identity of the operator: an empty AND holds, an empty OR
+            // doesn't
+            0 => {
+                let v = self.value(&Value::number(0));
+                let op = if op == "AND" { "=" } else { "<>" };
+                format!("{} {} {}", v, op, v)
+            }
+            1 => parts.into_iter().next().unwrap(),
+            _ => format!("({})", parts.join(&format!(" {} ", op))),
+        }
+    }
+
+    fn compile(&mut self, cond: &Cond) -> String {
+        match cond {
+            Cond::AttrExists(name) => format!("attribute_exists({})", self.name(name)),
+            Cond::AttrNotExists(name) => format!("attribute_not_exists({})", self.name(name)),
+            Cond::Eq(name, value) => self.comparison(name, "=", value),
+            Cond::Ne(name, value) => self.comparison(name, "<>", value),
+            Cond::Lt(name, value) => self.comparison(name, "<", value),
+            Cond::Le(name, value) => self.comparison(name, "<=", value),
+            Cond::Gt(name, value) => self.comparison(name, ">", value),
+            Cond::Ge(name, value) => self.comparison(name, ">=", value),
+            Cond::Between(name, lower, upper) => {
+                let n = self.name(name);
+                let lo = self.value(lower);
+                let hi = self.value(upper);
+                format!("{} BETWEEN {} AND {}", n, lo, hi)
+            }
+            Cond::BeginsWith(name, prefix) => {
+                let n = self.name(name);
+                let v = self.value(prefix);
+                format!("begins_with({}, {})", n, v)
+            }
+            Cond::Contains(name, operand) => {
+                let n = self.name(name);
+                let v = self.value(operand);
+                format!("contains({}, {})", n, v)
+            }
+            Cond::AttributeType(name, type_code) => {
+                let n = self.name(name);
+                let v = self.value(&Value::string(type_code.clone()));
+                format!("attribute_type({}, {})", n, v)
+            }
+            Cond::And(conditions) => self.join(conditions, "AND"),
+            Cond::Or(conditions) => self.join(conditions, "OR"),
+            Cond::Not(inner) => format!("NOT ({})", self.compile(inner)),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_compile_compound_and() {
+        let condition = and(vec![
+            attr_not_exists("pk"),
+            eq("version", Value::number(1)),
+            between("age", Value::number(18), Value::number(65)),
+        ]);
+
+        let compiled = condition.compile();
+        assert_eq!(
+            compiled.expression,
+            "(attribute_not_exists(#n0) AND #n1 = :c0 AND #n2 BETWEEN :c1 AND :c2)"
+        );
+
+        assert_eq!(compiled.names.len(), 3);
+        assert_eq!(compiled.names["#n0"], "pk");
+        assert_eq!(compiled.names["#n1"], "version");
+        assert_eq!(compiled.names["#n2"], "age");
+
+        assert_eq!(compiled.values.len(), 3);
+        assert_eq!(compiled.values[":c0"], Value::number(1));
+        assert_eq!(compiled.values[":c1"], Value::number(18));
+        assert_eq!(compiled.values[":c2"], Value::number(65));
+    }
+
+    #[test]
+    fn test_compile_reuses_name_aliases() {
+        let condition = or(vec![
+            eq("status", Value::string("pending")),
+            eq("status", Value::string("new")),
+        ]);
+
+        let compiled = condition.compile();
+        assert_eq!(compiled.expression, "(#n0 = :c0 OR #n0 = :c1)");
+        assert_eq!(compiled.names.len(), 1);
+        assert_eq!(compiled.values.len(), 2);
+    }
+
+    #[test]
+    fn test_compile_nested_and_not() {
+        let condition = attr_exists("pk")
+            .and(not(eq("locked", Value::Bool(true))))
+            .and(or(vec![
+                lt("count", Value::number(10)),
+                begins_with("name", Value::string("a")),
+            ]));
+
+        let compiled = condition.compile();
+        assert_eq!(
+            compiled.expression,
+            "(attribute_exists(#n0) AND NOT (#n1 = :c0) AND (#n2 < :c1 OR begins_with(#n3, :c2)))"
+        );
+    }
+
+    #[test]
+    fn test_compile_attribute_not_exists_filter() {
+        let compiled = attr_not_exists("email").compile();
+        assert_eq!(compiled.expression, "attribute_not_exists(#n0)");
+        assert_eq!(compiled.names["#n0"], "email");
+        assert!(compiled.values.is_empty());
+    }
+
+    #[test]
+    fn test_compile_contains_and_attribute_type() {
+        let compiled = contains("tags", Value::string("urgent"))
+            .or(not(attribute_type("tags", "L")))
+            .compile();
+        assert_eq!(
+            compiled.expression,
+            "(contains(#n0, :c0) OR NOT (attribute_type(#n0, :c1)))"
+        );
+        assert_eq!(compiled.names.len(), 1);
+        assert_eq!(compiled.names["#n0"], "tags");
+        assert_eq!(compiled.values[":c0"], Value::string("urgent"));
+        assert_eq!(compiled.values[":c1"], Value::string("L"));
+    }
+
+    #[test]
+    fn test_filter_expr_binds_into_scan() {
+        use crate::scan::RemoteScan;
+
+        let scan = RemoteScan::new().filter_expr(and(vec![
+            attr_not_exists("email"),
+            contains("tags", Value::string("t")),
+        ]));
+        assert_eq!(
+            scan.filter_expression(),
+            Some("(attribute_not_exists(#n0) AND contains(#n1, :c0))")
+        );
+        assert_eq!(scan.expression_names()["#n0"], "email");
+        assert_eq!(scan.expression_names()["#n1"], "tags");
+        assert_eq!(scan.expression_values()[":c0"], Value::string("t"));
+    }
+
+    #[test]
+    fn test_compiled_expression_parses() {
+        use kstone_core::expression::ExpressionParser;
+
+        let compiled = and(vec![
+            attr_not_exists("pk"),
+            between("age", Value::number(1), Value::number(2)),
+            contains("tags", Value::string("a")),
+            attribute_type("age", "N"),
+        ])
+        .compile();
+
+        assert!(ExpressionParser::parse(&compiled.expression).is_ok());
+    }
+
+    #[test]
+    fn test_compile_empty_and_or_are_identities() {
+        use kstone_core::expression::{ExpressionContext, ExpressionEvaluator, ExpressionParser};
+
+        let evaluate = |condition: Cond| {
+            let compiled = condition.compile();
+            let mut context = ExpressionContext::new();
+            for (placeholder, value) in compiled.values {
+                context = context.with_value(placeholder, value);
+            }
+            let expr = ExpressionParser::parse(&compiled.expression).unwrap();
+            ExpressionEvaluator::new(&kstone_core::Item::new(), &context).evaluate(&expr).unwrap()
+        };
+
+        assert!(evaluate(and(vec![])));
+        assert!(!evaluate(or(vec![])));
+        assert!(evaluate(and(vec![]).and(attr_not_exists("pk"))));
+        assert!(!evaluate(not(and(vec![]))));
+        assert_eq!(and(vec![]).compile().expression, ":c0 = :c0");
+    }
+}
@@ -44,6 +44,11 @@ pub fn proto_value_to_ks(value: proto::Value) -> Result<KsValue, Status> {
         }
         ProtoValueEnum::VectorValue(vec) => Ok(KsValue::VecF32(vec.values)),
         ProtoValueEnum::TimestampValue(ts) => Ok(KsValue::Ts(ts as i64)),
+        ProtoValueEnum::StringSetValue(set) => Ok(KsValue::SS(set.values.into_iter().collect())),
+        ProtoValueEnum::NumberSetValue(set) => Ok(KsValue::NS(set.values.into_iter().collect())),
+        ProtoValueEnum::BinarySetValue(set) => {
+            Ok(KsValue::BS(set.values.into_iter().map(Bytes::from).collect()))
+        }
     }
 }
 
@@ -70,6 +75,15 @@ pub fn ks_value_to_proto(value: &KsValue) -> proto::Value {
             values: vec.clone(),
         }),
         KsValue::Ts(ts) => ProtoValueEnum::TimestampValue(*ts as u64),
+        KsValue::SS(set) => ProtoValueEnum::StringSetValue(proto::StringSetValue {
+            values: set.iter().cloned().collect(),
+        }),
+        KsValue::NS(set) => ProtoValueEnum::NumberSetValue(proto::StringSetValue {
+            values: set.iter().cloned().collect(),
+        }),
+        KsValue::BS(set) => ProtoValueEnum::BinarySetValue(proto::BinarySetValue {
+            values: set.iter().map(|b| b.to_vec()).collect(),
+        }),
     };
 
     proto::Value {
@@ -157,6 +171,31 @@ pub fn ks_last_key_to_proto(
     }
 }
 
+// ============================================================================
+// Export Conversions
+// ============================================================================
+
+/// Checksum of an export chunk's entries (see `ExportChunk.checksum`)
+pub fn export_checksum(entries: &[proto::ExportEntry]) -> u32 {
+    let mut encoded = Vec::new();
+    for entry in entries {
+        prost::Message::encode(entry, &mut encoded).expect("Vec has unbounded capacity");
+    }
+    kstone_core::types::checksum::compute(&encoded)
+}
+
+// ============================================================================
+// Consumed Capacity Conversions
+// ============================================================================
+
+/// Convert protobuf consumed capacity to the core type
+pub fn proto_consumed_capacity_to_ks(capacity: proto::ConsumedCapacity) -> kstone_core::ConsumedCapacity {
+    kstone_core::ConsumedCapacity {
+        read_units: capacity.read_units,
+        write_units: capacity.write_units,
+    }
+}
+
 // ============================================================================
 // Helper Functions for Option<LastKey>
 // ============================================================================
@@ -254,6 +293,19 @@ mod tests {
         assert_eq!(ks_value, converted);
     }
 
+    #[test]
+    fn test_value_set_roundtrip() {
+        for ks_value in [
+            KsValue::string_set(["a", "b"]),
+            KsValue::number_set([1, 2]),
+            KsValue::binary_set([b"x".to_vec(), b"y".to_vec()]),
+        ] {
+            let proto_value = ks_value_to_proto(&ks_value);
+            let converted = proto_value_to_ks(proto_value).unwrap();
+            assert_eq!(ks_value, converted);
+        }
+    }
+
     #[test]
     fn test_item_roundtrip() {
         let mut item = HashMap::new();
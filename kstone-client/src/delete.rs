@@ -0,0 +1,115 @@
+/// Remote delete operations
+use crate::cond::Cond;
+use crate::convert::*;
+use crate::error::Result;
+use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
+use crate::metadata::Transport;
+use crate::validate;
+use std::collections::HashMap;
+
+/// Remote delete request builder
+pub struct RemoteDelete {
+    partition_key: Vec<u8>,
+    sort_key: Option<Vec<u8>>,
+    condition_expression: Option<String>,
+    expression_values: HashMap<String, kstone_core::Value>,
+    expression_names: HashMap<String, String>,
+}
+
+impl RemoteDelete {
+    /// Create a new delete operation
+    pub fn new(pk: &[u8]) -> Self {
+        Self {
+            partition_key: pk.to_vec(),
+            sort_key: None,
+            condition_expression: None,
+            expression_values: HashMap::new(),
+            expression_names: HashMap::new(),
+        }
+    }
+
+    /// Create delete with sort key
+    pub fn with_sk(pk: &[u8], sk: &[u8]) -> Self {
+        let mut delete = Self::new(pk);
+        delete.sort_key = Some(sk.to_vec());
+        delete
+    }
+
+    /// Set a raw condition expression
+    pub fn condition(mut self, condition: impl Into<String>) -> Self {
+        self.condition_expression = Some(condition.into());
+        self
+    }
+
+    /// Set the condition from a `cond` builder
+    ///
+    /// Replaces any previous condition; the compiled names and values are
+    /// merged into the request's maps.
+    pub fn condition_expr(mut self, condition: Cond) -> Self {
+        let compiled = condition.compile();
+        self.condition_expression = Some(compiled.expression);
+        self.expression_names.extend(compiled.names);
+        self.expression_values.extend(compiled.values);
+        self
+    }
+
+    /// Add an expression attribute value
+    pub fn value(mut self, placeholder: impl Into<String>, value: kstone_core::Value) -> Self {
+        self.expression_values.insert(placeholder.into(), value);
+        self
+    }
+
+    /// Add an expression attribute name
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.expression_names.insert(placeholder.into(), name.into());
+        self
+    }
+
+    /// Condition expression, if set
+    pub fn condition_expression(&self) -> Option<&str> {
+        self.condition_expression.as_deref()
+    }
+
+    /// Expression attribute names bound to this request
+    pub fn expression_names(&self) -> &HashMap<String, String> {
+        &self.expression_names
+    }
+
+    /// Expression attribute values bound to this request
+    pub fn expression_values(&self) -> &HashMap<String, kstone_core::Value> {
+        &self.expression_values
+    }
+
+    /// Partition key this request targets
+    pub(crate) fn partition_key(&self) -> &[u8] {
+        &self.partition_key
+    }
+
+    /// Check the delete locally before it is sent
+    ///
+    /// Rejects an empty partition key.
+    pub fn validate(&self) -> Result<()> {
+        validate::partition_key(&self.partition_key, "partition key")
+    }
+
+    /// Execute the delete operation
+    pub async fn execute(self, client: &mut KeystoneDbClient<Transport>) -> Result<()> {
+        self.validate()?;
+        let proto_values: HashMap<String, proto::Value> = self
+            .expression_values
+            .iter()
+            .map(|(k, v)| (k.clone(), ks_value_to_proto(v)))
+            .collect();
+
+        let request = proto::DeleteRequest {
+            partition_key: self.partition_key,
+            sort_key: self.sort_key,
+            condition_expression: self.condition_expression,
+            expression_values: proto_values,
+            expression_names: self.expression_names,
+        };
+
+        client.delete(request).await?;
+        Ok(())
+    }
+}
@@ -0,0 +1,250 @@
+/// Server discovery via DNS SRV records
+///
+/// `Client::connect_srv` resolves a service name (e.g.
+/// `"_kstone._tcp.example.com"`) to a set of servers and balances calls
+/// across all of them. The name is re-resolved periodically: servers that
+/// appear are added to the pool and servers that disappear are removed.
+/// Lookups go through a `SrvResolver`: `DnsSrvResolver` queries the
+/// system's DNS servers, and any other DNS library (or a fixed list, in
+/// tests) can be plugged in.
+
+use crate::error::{ClientError, Result};
+use crate::inflight::CallTracker;
+use hickory_resolver::TokioAsyncResolver;
+use std::collections::HashSet;
+use std::future::Future;
+use std::pin::Pin;
+use std::sync::Arc;
+use std::time::Duration;
+use tokio::sync::mpsc;
+use tonic::transport::Endpoint;
+use tower::discover::Change;
+
+/// How often `connect_srv` re-resolves the service name by default
+pub const DEFAULT_RESOLVE_INTERVAL: Duration = Duration::from_secs(30);
+
+/// One server named by an SRV record
+#[derive(Debug, Clone, PartialEq, Eq, Hash)]
+pub struct SrvTarget {
+    pub host: String,
+    pub port: u16,
+}
+
+impl SrvTarget {
+    pub fn new(host: impl Into<String>, port: u16) -> Self {
+        Self {
+            host: host.into(),
+            port,
+        }
+    }
+
+    /// Address the client connects to, with `scheme` `"http"` or `"https"`
+    pub fn uri(&self, scheme: &str) -> String {
+        format!("{}://{}:{}", scheme, self.host, self.port)
+    }
+}
+
+/// Future returned by `SrvResolver::resolve`
+pub type ResolveFuture<'a> = Pin<Box<dyn Future<Output = Result<Vec<SrvTarget>>> + Send + 'a>>;
+
+/// Resolves an SRV service name to its targets
+pub trait SrvResolver: Send + Sync {
+    /// Look up the current targets of `service`
+    fn resolve<'a>(&'a self, service: &'a str) -> ResolveFuture<'a>;
+}
+
+impl<T: SrvResolver + ?Sized> SrvResolver for Arc<T> {
+    fn resolve<'a>(&'a self, service: &'a str) -> ResolveFuture<'a> {
+        (**self).resolve(service)
+    }
+}
+
+/// Resolves SRV records through the system's DNS servers
+///
+/// Only the targets with the lowest priority are returned, as SRV
+/// requires; weights are ignored since the client balances calls itself.
+pub struct DnsSrvResolver {
+    resolver: TokioAsyncResolver,
+}
+
+impl DnsSrvResolver {
+    /// Use the DNS servers from the system configuration
+    /// (`/etc/resolv.conf` on Unix)
+    pub fn from_system_conf() -> Result<Self> {
+        let resolver = TokioAsyncResolver::tokio_from_system_conf()
+            .map_err(|e| ClientError::ConnectionError(format!("Failed to read DNS configuration: {}", e)))?;
+        Ok(Self { resolver })
+    }
+}
+
+impl SrvResolver for DnsSrvResolver {
+    fn resolve<'a>(&'a self, service: &'a str) -> ResolveFuture<'a> {
+        Box::pin(async move {
+            let lookup = self
+                .resolver
+                .srv_lookup(service)
+                .await
+                .map_err(|e| ClientError::ConnectionError(format!("SRV lookup for {} failed: {}", service, e)))?;
+            Ok(lowest_priority(lookup.iter().map(|srv| {
+                let host = srv.target().to_utf8();
+                (srv.priority(), SrvTarget::new(host.trim_end_matches('.'), srv.port()))
+            })))
+        })
+    }
+}
+
+/// The targets sharing the lowest priority
+fn lowest_priority(records: impl Iterator<Item = (u16, SrvTarget)>) -> Vec<SrvTarget> {
+    let records: Vec<(u16, SrvTarget)> = records.collect();
+    let lowest = records.iter().map(|(priority, _)| *priority).min();
+    records
+        .into_iter()
+        .filter(|(priority, _)| Some(*priority) == lowest)
+        .map(|(_, target)| target)
+        .collect()
+}
+
+/// Resolve `service`, failing if it has no targets
+pub(crate) async fn resolve_targets(resolver: &dyn SrvResolver, service: &str) -> Result<HashSet<SrvTarget>> {
+    let targets: HashSet<SrvTarget> = resolver.resolve(service).await?.into_iter().collect();
+    if targets.is_empty() {
+        return Err(ClientError::ConnectionError(format!(
+            "SRV lookup for {} returned no targets",
+            service
+        )));
+    }
+    Ok(targets)
+}
+
+/// Endpoint changes that turn the `current` targets into `next`
+pub(crate) fn diff(
+    current: &HashSet<SrvTarget>,
+    next: &HashSet<SrvTarget>,
+    endpoint: impl Fn(&SrvTarget) -> Result<Endpoint>,
+) -> Result<Vec<Change<SrvTarget, Endpoint>>> {
+    let mut changes = Vec::new();
+    for target in current.difference(next) {
+        changes.push(Change::Remove(target.clone()));
+    }
+    for target in next.difference(current) {
+        changes.push(Change::Insert(target.clone(), endpoint(target)?));
+    }
+    Ok(changes)
+}
+
+/// Re-resolve `service` every `interval` and apply the changes to the pool
+///
+/// Stops once the pool is gone, i.e. every clone of the client was dropped,
+/// or removes every target and stops once `calls` is closed by a drain.
+/// Failed or empty lookups keep the current targets.
+pub(crate) async fn refresh(
+    resolver: Box<dyn SrvResolver>,
+    service: String,
+    interval: Duration,
+    mut current: HashSet<SrvTarget>,
+    pool: mpsc::Sender<Change<SrvTarget, Endpoint>>,
+    endpoint: impl Fn(&SrvTarget) -> Result<Endpoint>,
+    calls: Arc<CallTracker>,
+) {
+    loop {
+        tokio::select! {
+            _ = tokio::time::sleep(interval) => {}
+            _ = calls.closed() => {
+                for target in current {
+                    if pool.send(Change::Remove(target)).await.is_err() {
+                        return;
+                    }
+                }
+                return;
+            }
+        }
+        if pool.is_closed() {
+            return;
+        }
+
+        let next = match resolve_targets(resolver.as_ref(), &service).await {
+            Ok(next) => next,
+            Err(_) => continue,
+        };
+        let changes = match diff(&current, &next, &endpoint) {
+            Ok(changes) => changes,
+            Err(_) => continue,
+        };
+        for change in changes {
+            if pool.send(change).await.is_err() {
+                return;
+            }
+        }
+        current = next;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn keys(changes: &[Change<SrvTarget, Endpoint>]) -> (Vec<u16>, Vec<u16>) {
+        let mut inserted = Vec::new();
+        let mut removed = Vec::new();
+        for change in changes {
+            match change {
+                Change::Insert(target, _) => inserted.push(target.port),
+                Change::Remove(target) => removed.push(target.port),
+            }
+        }
+        (inserted, removed)
+    }
+
+    #[test]
+    fn test_diff_adds_and_removes_targets() {
+        let current: HashSet<_> = [SrvTarget::new("a", 1), SrvTarget::new("b", 2)].into();
+        let next: HashSet<_> = [SrvTarget::new("b", 2), SrvTarget::new("c", 3)].into();
+
+        let changes = diff(&current, &next, |t| Ok(Endpoint::from_shared(t.uri("http")).unwrap())).unwrap();
+        assert_eq!(keys(&changes), (vec![3], vec![1]));
+    }
+
+    #[test]
+    fn test_only_lowest_priority_targets_are_used() {
+        let records = vec![
+            (20, SrvTarget::new("backup", 1)),
+            (10, SrvTarget::new("a", 2)),
+            (10, SrvTarget::new("b", 3)),
+        ];
+        let targets = lowest_priority(records.into_iter());
+        assert_eq!(targets, vec![SrvTarget::new("a", 2), SrvTarget::new("b", 3)]);
+        assert_eq!(SrvTarget::new("a", 2).uri("https"), "https://a:2");
+    }
+
+    #[tokio::test]
+    async fn test_refresh_removes_every_target_once_closed() {
+        struct Fixed;
+        impl SrvResolver for Fixed {
+            fn resolve<'a>(&'a self, _service: &'a str) -> ResolveFuture<'a> {
+                Box::pin(async { Ok(vec![SrvTarget::new("a", 1)]) })
+            }
+        }
+
+        let (pool, mut changes) = mpsc::channel(16);
+        let current: HashSet<_> = [SrvTarget::new("a", 1), SrvTarget::new("b", 2)].into();
+        let calls = CallTracker::new();
+        let task = tokio::spawn(refresh(
+            Box::new(Fixed),
+            "_kstone._tcp.test".to_string(),
+            Duration::from_secs(3600),
+            current,
+            pool,
+            |t| Ok(Endpoint::from_shared(t.uri("http")).unwrap()),
+            Arc::clone(&calls),
+        ));
+
+        calls.close();
+        task.await.unwrap();
+        let mut removed = Vec::new();
+        while let Ok(change) = changes.try_recv() {
+            removed.extend(keys(&[change]).1);
+        }
+        removed.sort();
+        assert_eq!(removed, vec![1, 2]);
+    }
+}
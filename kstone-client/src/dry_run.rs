@@ -0,0 +1,24 @@
+/// Remote dry-run results
+use crate::convert::proto_item_to_ks;
+use crate::error::Result;
+use kstone_core::Item;
+use kstone_proto as proto;
+
+/// Outcome of a dry-run write; nothing was persisted
+#[derive(Debug, Clone, PartialEq)]
+pub struct RemoteDryRunResult {
+    /// Whether the condition (if any) would pass
+    pub condition_passed: bool,
+    /// Item as it would exist after the write (the current item if the
+    /// condition would fail, None if absent or deleted)
+    pub item: Option<Item>,
+}
+
+impl RemoteDryRunResult {
+    pub(crate) fn from_proto(result: proto::DryRunResult) -> Result<Self> {
+        Ok(Self {
+            condition_passed: result.condition_passed,
+            item: result.item.map(proto_item_to_ks).transpose()?,
+        })
+    }
+}
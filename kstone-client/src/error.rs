@@ -1,4 +1,5 @@
 /// Error types for the KeystoneDB client
+use kstone_core::CancellationReason;
 use thiserror::Error;
 use tonic::Status;
 
@@ -10,6 +11,10 @@ pub enum ClientError {
     #[error("Invalid argument: {0}")]
     InvalidArgument(String),
 
+    /// An item exceeded the server's maximum item size
+    #[error("Item size {size} bytes exceeds maximum of {limit} bytes")]
+    ItemTooLarge { size: usize, limit: usize },
+
     #[error("Condition check failed: {0}")]
     ConditionCheckFailed(String),
 
@@ -28,9 +33,33 @@ pub enum ClientError {
     #[error("Data corruption: {0}")]
     DataCorruption(String),
 
+    /// The server's response was malformed or missing a required part,
+    /// e.g. from a server of a different version
+    #[error("Invalid response from server: {0}")]
+    InvalidResponse(String),
+
     #[error("Transaction aborted: {0}")]
     TransactionAborted(String),
 
+    /// A transaction was canceled; `reasons` has one entry per operation,
+    /// in request order, with code "None" for operations that did not fail
+    #[error("Transaction canceled: {message}")]
+    TransactionCanceled {
+        message: String,
+        reasons: Vec<CancellationReason>,
+    },
+
+    /// A chunked transactional write failed, and rolling back the chunks
+    /// committed before it failed too; `unrestored` lists the chunks (by
+    /// index) whose writes were left in place
+    #[error("Chunk {failed_chunk} failed ({source}) and rolling back chunks {unrestored:?} failed: {compensation}")]
+    CompensationFailed {
+        failed_chunk: usize,
+        source: Box<ClientError>,
+        unrestored: Vec<usize>,
+        compensation: Box<ClientError>,
+    },
+
     #[error("Already exists: {0}")]
     AlreadyExists(String),
 
@@ -43,6 +72,28 @@ pub enum ClientError {
     #[error("Permission denied: {0}")]
     PermissionDenied(String),
 
+    /// The client's circuit breaker is open; the call was not sent
+    #[error("Circuit open: {0}")]
+    CircuitOpen(String),
+
+    /// A scan hit its deadline; `response` holds the items received before
+    /// it, and its `last_key` (if any) can be used to resume
+    #[error("Partial result ({} items): {source}", .response.items.len())]
+    PartialResult {
+        response: Box<crate::scan::RemoteScanResponse>,
+        source: Box<ClientError>,
+    },
+
+    /// An aggregating read reached a `ResultLimits` budget; `items` holds
+    /// what was collected and `last_key` can be passed to `start_after`
+    /// to continue
+    #[error("Limit exceeded: {limit}")]
+    LimitExceeded {
+        limit: String,
+        items: Vec<kstone_core::Item>,
+        last_key: (bytes::Bytes, Option<bytes::Bytes>),
+    },
+
     #[error("Unknown error: {0}")]
     Unknown(String),
 }
@@ -54,6 +105,13 @@ impl From<Status> for ClientError {
     fn from(status: Status) -> Self {
         let msg = status.message().to_string();
 
+        if let (Some(size), Some(limit)) = (
+            metadata_usize(&status, kstone_proto::ITEM_SIZE_METADATA),
+            metadata_usize(&status, kstone_proto::ITEM_SIZE_LIMIT_METADATA),
+        ) {
+            return ClientError::ItemTooLarge { size, limit };
+        }
+
         match status.code() {
             tonic::Code::NotFound => ClientError::NotFound(msg),
             tonic::Code::InvalidArgument => ClientError::InvalidArgument(msg),
@@ -71,3 +129,8 @@ impl From<Status> for ClientError {
         }
     }
 }
+
+/// Numeric value of a status metadata entry, if present and well-formed
+fn metadata_usize(status: &Status, key: &str) -> Option<usize> {
+    status.metadata().get(key)?.to_str().ok()?.parse().ok()
+}
@@ -0,0 +1,311 @@
+/// Backing up a server deployment
+///
+/// `Client::export_to` streams the table, or a key range of it, from the
+/// server and writes it in the portable export format, the one
+/// `import_from` and `ExportReader` read. Every chunk carries a checksum of
+/// its entries. A chunk that fails it, or a stream broken by a transient
+/// error, is resumed after the last key written, so each item is written
+/// once. The returned `ExportStats` holds the `ExportManifest` (items,
+/// bytes and CRC32C of the output) to keep alongside the backup.
+///
+/// The server reads the table a chunk at a time while streaming, so an
+/// export of a table taking writes is not a point-in-time copy.
+
+use crate::convert::{export_checksum, ks_last_key_to_proto, proto_item_to_ks};
+use crate::error::{ClientError, Result};
+use crate::validate;
+use bytes::Bytes;
+use futures::{Stream, StreamExt};
+use kstone_core::{ExportManifest, ExportWriter, Item, Key};
+use kstone_proto as proto;
+use std::future::Future;
+use std::io::Write;
+use tonic::Status;
+
+/// Times an export resumes after transient errors before giving up
+pub const DEFAULT_EXPORT_RESUMES: u32 = 3;
+
+/// Export request builder
+#[derive(Debug, Clone)]
+pub struct RemoteExport {
+    range_start: Option<(Vec<u8>, Option<Vec<u8>>)>,
+    range_end: Option<(Vec<u8>, Option<Vec<u8>>)>,
+    chunk_size: Option<u32>,
+    max_resumes: u32,
+}
+
+impl RemoteExport {
+    /// Export the whole table
+    pub fn new() -> Self {
+        Self {
+            range_start: None,
+            range_end: None,
+            chunk_size: None,
+            max_resumes: DEFAULT_EXPORT_RESUMES,
+        }
+    }
+
+    /// Start at this key (inclusive)
+    pub fn from_key(mut self, pk: &[u8], sk: Option<&[u8]>) -> Self {
+        self.range_start = Some((pk.to_vec(), sk.map(<[u8]>::to_vec)));
+        self
+    }
+
+    /// Stop before this key (exclusive)
+    pub fn until_key(mut self, pk: &[u8], sk: Option<&[u8]>) -> Self {
+        self.range_end = Some((pk.to_vec(), sk.map(<[u8]>::to_vec)));
+        self
+    }
+
+    /// Items per chunk (server default otherwise)
+    pub fn chunk_size(mut self, size: u32) -> Self {
+        self.chunk_size = Some(size);
+        self
+    }
+
+    /// Times to resume after transient errors (default 3)
+    pub fn max_resumes(mut self, resumes: u32) -> Self {
+        self.max_resumes = resumes;
+        self
+    }
+
+    /// Check the request before sending it
+    pub fn validate(&self) -> Result<()> {
+        if let Some((pk, _)) = &self.range_start {
+            validate::partition_key(pk, "range start partition key")?;
+        }
+        if let Some((pk, _)) = &self.range_end {
+            validate::partition_key(pk, "range end partition key")?;
+        }
+        if self.chunk_size == Some(0) {
+            return Err(ClientError::InvalidArgument(
+                "chunk_size must be greater than zero".to_string(),
+            ));
+        }
+        Ok(())
+    }
+
+    pub(crate) fn resumes(&self) -> u32 {
+        self.max_resumes
+    }
+
+    /// The request resuming after `after`, or starting afresh
+    pub(crate) fn to_proto(&self, after: Option<&Key>) -> proto::ExportRequest {
+        proto::ExportRequest {
+            range_start: self.range_start.clone().map(|(pk, sk)| ks_last_key_to_proto(pk, sk)),
+            range_end: self.range_end.clone().map(|(pk, sk)| ks_last_key_to_proto(pk, sk)),
+            exclusive_start_key: after.map(|key| ks_last_key_to_proto(key.pk.to_vec(), key.sk.as_ref().map(|sk| sk.to_vec()))),
+            chunk_size: self.chunk_size,
+        }
+    }
+}
+
+impl Default for RemoteExport {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+/// Outcome of an export
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct ExportStats {
+    /// Totals of the written export, to keep alongside it
+    pub manifest: ExportManifest,
+    /// Chunks received
+    pub chunks: u64,
+    /// Times the stream was reopened after a transient error
+    pub resumes: u32,
+}
+
+/// Write the chunks of export streams to `writer`
+///
+/// `open` starts a stream after the given key, or from the start. A stream
+/// ending early with a transient error, or sending a chunk that fails its
+/// checksum, is reopened after the last key written, up to `max_resumes`
+/// times.
+pub(crate) async fn run_export<W, F, Fut, S>(writer: W, max_resumes: u32, mut open: F) -> Result<ExportStats>
+where
+    W: Write,
+    F: FnMut(Option<Key>) -> Fut,
+    Fut: Future<Output = Result<S>>,
+    S: Stream<Item = std::result::Result<proto::ExportChunk, Status>> + Unpin,
+{
+    let mut export = ExportWriter::new(writer).map_err(write_error)?;
+    let mut last: Option<Key> = None;
+    let mut chunks = 0;
+    let mut resumes = 0;
+
+    loop {
+        let streamed = async {
+            let mut stream = open(last.clone()).await?;
+            while let Some(chunk) = stream.next().await {
+                let chunk = chunk?;
+                if export_checksum(&chunk.entries) != chunk.checksum {
+                    return Err(ClientError::DataCorruption(
+                        "Export chunk failed its checksum".to_string(),
+                    ));
+                }
+                // Decode the whole chunk before writing any of it
+                let entries = chunk
+                    .entries
+                    .into_iter()
+                    .map(entry_to_ks)
+                    .collect::<Result<Vec<_>>>()?;
+                for (key, item) in entries {
+                    export.write(key.clone(), item).map_err(write_error)?;
+                    last = Some(key);
+                }
+                chunks += 1;
+            }
+            Ok(())
+        }
+        .await;
+
+        match streamed {
+            Ok(()) => break,
+            Err(e) if is_transient(&e) && resumes < max_resumes => resumes += 1,
+            Err(e) => return Err(e),
+        }
+    }
+
+    Ok(ExportStats {
+        manifest: export.finish().map_err(write_error)?,
+        chunks,
+        resumes,
+    })
+}
+
+pub(crate) fn entry_to_ks(entry: proto::ExportEntry) -> Result<(Key, Item)> {
+    let item = entry
+        .item
+        .ok_or_else(|| ClientError::InternalError("Export entry has no item".to_string()))?;
+    let key = match entry.sort_key {
+        Some(sk) => Key::with_sk(Bytes::from(entry.partition_key), Bytes::from(sk)),
+        None => Key::new(Bytes::from(entry.partition_key)),
+    };
+    Ok((key, proto_item_to_ks(item)?))
+}
+
+/// Errors worth reopening the stream for
+fn is_transient(error: &ClientError) -> bool {
+    matches!(
+        error,
+        ClientError::Unavailable(_)
+            | ClientError::Timeout(_)
+            | ClientError::ConnectionError(_)
+            | ClientError::DataCorruption(_)
+    )
+}
+
+/// Failures writing the output are not retried
+fn write_error(error: kstone_core::Error) -> ClientError {
+    ClientError::InternalError(format!("Failed to write export: {}", error))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::convert::ks_item_to_proto;
+    use kstone_core::{ExportReader, Value};
+
+    fn items() -> Vec<(Key, Item)> {
+        (0..10)
+            .map(|i| {
+                let mut item = Item::new();
+                item.insert("n".to_string(), Value::number(i));
+                item.insert("name".to_string(), Value::string(format!("item {}", i)));
+                let key = Key::with_sk(Bytes::from_static(b"pk"), Bytes::from(format!("sk#{:02}", i)));
+                (key, item)
+            })
+            .collect()
+    }
+
+    fn chunk(entries: &[(Key, Item)]) -> proto::ExportChunk {
+        let entries: Vec<proto::ExportEntry> = entries
+            .iter()
+            .map(|(key, item)| proto::ExportEntry {
+                partition_key: key.pk.to_vec(),
+                sort_key: key.sk.as_ref().map(|sk| sk.to_vec()),
+                item: Some(ks_item_to_proto(item)),
+            })
+            .collect();
+        proto::ExportChunk {
+            checksum: export_checksum(&entries),
+            entries,
+        }
+    }
+
+    /// Chunks of three items after `after`, as a server would send them
+    fn chunks_after(all: &[(Key, Item)], after: Option<&Key>) -> Vec<proto::ExportChunk> {
+        let rest: Vec<(Key, Item)> = all
+            .iter()
+            .filter(|(key, _)| after.map_or(true, |after| key > after))
+            .cloned()
+            .collect();
+        rest.chunks(3).map(chunk).collect()
+    }
+
+    fn read_back(buf: &[u8]) -> Vec<(Key, Item)> {
+        ExportReader::new(buf)
+            .unwrap()
+            .map(|record| {
+                let record = record.unwrap();
+                let key = match record.sk {
+                    Some(sk) => Key::with_sk(record.pk, sk),
+                    None => Key::new(record.pk),
+                };
+                (key, record.item)
+            })
+            .collect()
+    }
+
+    #[tokio::test]
+    async fn test_export_resumes_after_broken_stream_and_bad_chunk() {
+        let all = items();
+        let mut opened = Vec::new();
+
+        let mut buf = Vec::new();
+        let stats = run_export(&mut buf, 3, |after: Option<Key>| {
+            opened.push(after.clone());
+            let mut chunks: Vec<std::result::Result<proto::ExportChunk, Status>> =
+                chunks_after(&all, after.as_ref()).into_iter().map(Ok).collect();
+            match opened.len() {
+                // First stream breaks after one chunk
+                1 => {
+                    chunks.truncate(1);
+                    chunks.push(Err(Status::unavailable("connection reset")));
+                }
+                // Second stream corrupts its second chunk
+                2 => chunks[1].as_mut().unwrap().checksum ^= 1,
+                _ => {}
+            }
+            async move { Ok(futures::stream::iter(chunks)) }
+        })
+        .await
+        .unwrap();
+
+        assert_eq!(read_back(&buf), all);
+        assert_eq!(stats.resumes, 2);
+        assert_eq!(stats.manifest.items, 10);
+        assert_eq!(stats.manifest.bytes, buf.len() as u64);
+        assert_eq!(stats.manifest.checksum, kstone_core::types::checksum::compute(&buf));
+
+        // Each reopen resumed after the last key written
+        assert_eq!(opened.len(), 3);
+        assert_eq!(opened[0], None);
+        assert_eq!(opened[1].as_ref(), Some(&all[2].0));
+        assert_eq!(opened[2].as_ref(), Some(&all[5].0));
+    }
+
+    #[tokio::test]
+    async fn test_export_gives_up_after_max_resumes() {
+        let mut buf = Vec::new();
+        let result = run_export(&mut buf, 1, |_after: Option<Key>| async {
+            let chunks: Vec<std::result::Result<proto::ExportChunk, Status>> =
+                vec![Err(Status::unavailable("down"))];
+            Ok(futures::stream::iter(chunks))
+        })
+        .await;
+        assert!(matches!(result, Err(ClientError::Unavailable(_))));
+    }
+}
@@ -0,0 +1,101 @@
+/// Client-side filtering for filters the server rejects
+///
+/// A server older than the client may not support every filter expression
+/// the client can build, and rejects such a query or scan with
+/// `Unimplemented` or `InvalidArgument`. When the client can parse the
+/// filter itself, it runs the request again without it and applies the
+/// filter to the returned items, logging a warning. `scanned_count` and
+/// `last_key` are those of the unfiltered request, which are also what the
+/// server would have reported, so paging works unchanged; the fallback
+/// only costs transferring the items the filter drops.
+
+use crate::error::{ClientError, Result};
+use kstone_core::expression::{Expr, ExpressionContext, ExpressionEvaluator, ExpressionParser};
+use kstone_core::{Item, Value};
+use std::collections::HashMap;
+
+/// A filter to apply to items the server returned unfiltered
+pub(crate) struct LocalFilter {
+    expr: Expr,
+    context: ExpressionContext,
+}
+
+impl LocalFilter {
+    /// The filter to apply locally after the server rejected `filter`
+    /// with `error`, or None if the error isn't such a rejection or the
+    /// client can't parse the filter either
+    pub(crate) fn for_rejected(
+        error: &ClientError,
+        filter: Option<&str>,
+        names: &HashMap<String, String>,
+        values: &HashMap<String, Value>,
+    ) -> Option<Self> {
+        if !matches!(error, ClientError::Unimplemented(_) | ClientError::InvalidArgument(_)) {
+            return None;
+        }
+        let filter = filter?;
+        let expr = ExpressionParser::parse(filter).ok()?;
+        let mut context = ExpressionContext::new();
+        for (placeholder, name) in names {
+            context = context.with_name(placeholder.clone(), name.clone());
+        }
+        for (placeholder, value) in values {
+            context = context.with_value(placeholder.clone(), value.clone());
+        }
+        tracing::warn!(filter, error = %error, "server rejected filter; filtering on the client");
+        Some(Self { expr, context })
+    }
+
+    /// Keep the items matching the filter
+    pub(crate) fn retain(&self, items: Vec<Item>) -> Result<Vec<Item>> {
+        let mut kept = Vec::with_capacity(items.len());
+        for item in items {
+            let matches = ExpressionEvaluator::new(&item, &self.context)
+                .evaluate(&self.expr)
+                .map_err(|e| ClientError::InvalidArgument(format!("Filter failed on the client: {}", e)))?;
+            if matches {
+                kept.push(item);
+            }
+        }
+        Ok(kept)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_only_rejections_of_parseable_filters_fall_back() {
+        let names = HashMap::new();
+        let mut values = HashMap::new();
+        values.insert(":min".to_string(), Value::number(18));
+        let rejected = ClientError::Unimplemented("filter".to_string());
+
+        assert!(LocalFilter::for_rejected(&rejected, Some("age >= :min"), &names, &values).is_some());
+        assert!(LocalFilter::for_rejected(&rejected, None, &names, &values).is_none());
+        assert!(LocalFilter::for_rejected(&rejected, Some("(age >= :min"), &names, &values).is_none());
+        let unavailable = ClientError::Unavailable("down".to_string());
+        assert!(LocalFilter::for_rejected(&unavailable, Some("age >= :min"), &names, &values).is_none());
+    }
+
+    #[test]
+    fn test_retain_keeps_matching_items() {
+        let mut values = HashMap::new();
+        values.insert(":min".to_string(), Value::number(18));
+        let filter = LocalFilter::for_rejected(
+            &ClientError::InvalidArgument("unsupported".to_string()),
+            Some("age >= :min"),
+            &HashMap::new(),
+            &values,
+        )
+        .unwrap();
+
+        let item = |age: i64| {
+            let mut item = HashMap::new();
+            item.insert("age".to_string(), Value::number(age));
+            item
+        };
+        assert_eq!(filter.retain(vec![item(12), item(30), item(18)]).unwrap(), vec![item(30), item(18)]);
+    }
+}
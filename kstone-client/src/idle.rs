@@ -0,0 +1,213 @@
+/// Replacing idle connections
+///
+/// NATs and load balancers drop connections that stay idle for a while,
+/// often without telling either end, and the first call over such a
+/// connection fails with `Unavailable`. With `ConnectOptions::idle_timeout`
+/// the client retires its connection once no call has run for that long,
+/// and the next call opens a new one instead of trying the stale one.
+/// Keepalive pings (`ConnectOptions::keepalive_time`) are the other remedy:
+/// they keep the connection in use so intermediaries don't drop it.
+///
+/// The connection lives in a balanced channel holding at most one endpoint.
+/// Retiring removes it; the next call inserts the endpoint again under a
+/// new key, and the channel applies both changes before routing that call.
+/// Every client connected to a single address keeps its connection this
+/// way, idle timeout or not, so `Client::drain` can close it for good while
+/// clones of the client still hold the channel.
+
+use crate::error::{ClientError, Result};
+use crate::inflight::CallTracker;
+use std::sync::{Arc, Mutex, Weak};
+use std::time::{Duration, Instant};
+use tokio::sync::mpsc;
+use tonic::transport::Endpoint;
+use tower::discover::Change;
+
+/// Room for pending changes; an idle period queues at most two
+pub(crate) const IDLE_POOL_CAPACITY: usize = 16;
+
+/// A connection that is retired after a timeout without calls, and can be
+/// closed
+pub(crate) struct IdleConnection {
+    endpoint: Endpoint,
+    pool: mpsc::Sender<Change<u64, Endpoint>>,
+    calls: Arc<CallTracker>,
+    state: Mutex<State>,
+}
+
+struct State {
+    /// When a call last started or was seen in flight
+    last_used: Instant,
+    /// Key of the current connection in the pool, if one is open
+    open: Option<u64>,
+    next_key: u64,
+    /// Closed for good by `close`
+    closed: bool,
+}
+
+impl IdleConnection {
+    /// Open `endpoint` in `pool` and retire it whenever it idles for
+    /// `timeout`, if given
+    pub(crate) fn start(
+        endpoint: Endpoint,
+        timeout: Option<Duration>,
+        pool: mpsc::Sender<Change<u64, Endpoint>>,
+        calls: Arc<CallTracker>,
+    ) -> Result<Arc<Self>> {
+        let connection = Self::new(endpoint, pool, calls)?;
+        if let Some(timeout) = timeout {
+            tokio::spawn(retire_when_idle(Arc::downgrade(&connection), timeout));
+        }
+        Ok(connection)
+    }
+
+    fn new(
+        endpoint: Endpoint,
+        pool: mpsc::Sender<Change<u64, Endpoint>>,
+        calls: Arc<CallTracker>,
+    ) -> Result<Arc<Self>> {
+        let connection = Arc::new(Self {
+            endpoint,
+            pool,
+            calls,
+            state: Mutex::new(State {
+                last_used: Instant::now(),
+                open: None,
+                next_key: 0,
+                closed: false,
+            }),
+        });
+        connection.touch()?;
+        Ok(connection)
+    }
+
+    /// Note that a call is starting, opening a new connection if the last
+    /// one was retired
+    pub(crate) fn touch(&self) -> Result<()> {
+        let mut state = self.state.lock().unwrap();
+        if state.closed {
+            return Err(ClientError::Unavailable("Client is draining".to_string()));
+        }
+        state.last_used = Instant::now();
+        if state.open.is_none() {
+            let key = state.next_key;
+            state.next_key += 1;
+            self.send(Change::Insert(key, self.endpoint.clone()))?;
+            state.open = Some(key);
+        }
+        Ok(())
+    }
+
+    /// Close the connection for good; later calls don't reopen it
+    pub(crate) fn close(&self) {
+        let mut state = self.state.lock().unwrap();
+        state.closed = true;
+        if let Some(key) = state.open.take() {
+            // A closed pool has no connection left to close
+            let _ = self.send(Change::Remove(key));
+        }
+    }
+
+    /// Retire the connection if it has been idle for `timeout`, and return
+    /// how long to wait before checking again; fails once it is closed
+    fn retire_if_idle(&self, timeout: Duration) -> Result<Duration> {
+        let mut state = self.state.lock().unwrap();
+        if state.closed {
+            return Err(ClientError::Unavailable("Client is draining".to_string()));
+        }
+        // A long call (e.g. a stream) keeps the connection in use
+        if self.calls.in_flight() > 0 {
+            state.last_used = Instant::now();
+        }
+        let idle = state.last_used.elapsed();
+        if idle < timeout {
+            return Ok(timeout - idle);
+        }
+        if let Some(key) = state.open.take() {
+            self.send(Change::Remove(key))?;
+        }
+        Ok(timeout)
+    }
+
+    fn send(&self, change: Change<u64, Endpoint>) -> Result<()> {
+        self.pool
+            .try_send(change)
+            .map_err(|_| ClientError::ConnectionError("Connection pool closed".to_string()))
+    }
+}
+
+/// Check the connection until every client using it is dropped, or it is
+/// closed
+async fn retire_when_idle(connection: Weak<IdleConnection>, timeout: Duration) {
+    loop {
+        let Some(connection) = connection.upgrade() else {
+            return;
+        };
+        let Ok(wait) = connection.retire_if_idle(timeout) else {
+            return;
+        };
+        drop(connection);
+        tokio::time::sleep(wait).await;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn describe(change: Change<u64, Endpoint>) -> String {
+        match change {
+            Change::Insert(key, _) => format!("insert {}", key),
+            Change::Remove(key) => format!("remove {}", key),
+        }
+    }
+
+    #[test]
+    fn test_idle_connection_is_replaced_on_next_call() {
+        let (pool, mut changes) = mpsc::channel(IDLE_POOL_CAPACITY);
+        let endpoint = Endpoint::from_static("http://127.0.0.1:50051");
+        let calls = CallTracker::new();
+        let timeout = Duration::from_millis(50);
+        let connection = IdleConnection::new(endpoint, pool, Arc::clone(&calls)).unwrap();
+        assert_eq!(describe(changes.try_recv().unwrap()), "insert 0");
+
+        // Busy: nothing changes
+        assert!(connection.retire_if_idle(timeout).unwrap() > Duration::ZERO);
+        connection.touch().unwrap();
+        assert!(changes.try_recv().is_err());
+
+        // A call in flight keeps the connection even past the timeout
+        let guard = calls.begin().unwrap();
+        std::thread::sleep(Duration::from_millis(60));
+        connection.retire_if_idle(timeout).unwrap();
+        assert!(changes.try_recv().is_err());
+        drop(guard);
+
+        std::thread::sleep(Duration::from_millis(60));
+        connection.retire_if_idle(timeout).unwrap();
+        assert_eq!(describe(changes.try_recv().unwrap()), "remove 0");
+
+        // Retired only once however long it idles
+        std::thread::sleep(Duration::from_millis(60));
+        connection.retire_if_idle(timeout).unwrap();
+        assert!(changes.try_recv().is_err());
+
+        connection.touch().unwrap();
+        assert_eq!(describe(changes.try_recv().unwrap()), "insert 1");
+    }
+
+    #[test]
+    fn test_closed_connection_is_removed_and_not_reopened() {
+        let (pool, mut changes) = mpsc::channel(IDLE_POOL_CAPACITY);
+        let endpoint = Endpoint::from_static("http://127.0.0.1:50051");
+        let connection = IdleConnection::new(endpoint, pool, CallTracker::new()).unwrap();
+        assert_eq!(describe(changes.try_recv().unwrap()), "insert 0");
+
+        connection.close();
+        assert_eq!(describe(changes.try_recv().unwrap()), "remove 0");
+
+        assert!(matches!(connection.touch(), Err(ClientError::Unavailable(_))));
+        assert!(connection.retire_if_idle(Duration::from_millis(50)).is_err());
+        assert!(changes.try_recv().is_err());
+    }
+}
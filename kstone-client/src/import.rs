@@ -0,0 +1,17 @@
+/// Loading exports produced by an embedded database
+///
+/// `Client::import_from` reads the portable export format written by
+/// `Database::export_to` and replays it against the server as batched
+/// writes.
+
+/// Items sent per batch write during an import
+pub const IMPORT_BATCH_SIZE: usize = 25;
+
+/// Outcome of an import
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct ImportStats {
+    /// Items written
+    pub items: u64,
+    /// Batch writes sent
+    pub batches: u64,
+}
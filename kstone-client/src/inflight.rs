@@ -0,0 +1,191 @@
+/// In-flight call tracking for graceful client shutdown
+///
+/// Every RPC issued through a `Client` holds a `CallGuard` for its duration.
+/// Once draining starts, new calls are rejected while existing guards are
+/// allowed to finish; then the tracker is closed, telling whatever manages
+/// the connection to close it.
+///
+/// A `ConcurrencyLimit` optionally caps how many calls run at once; calls
+/// beyond the cap wait for a slot or fail, per `WhenSaturated`.
+
+use crate::error::{ClientError, Result};
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::sync::Arc;
+use tokio::sync::{Notify, OwnedSemaphorePermit, Semaphore};
+
+/// Shared state for counting in-flight calls across client clones
+#[derive(Debug, Default)]
+pub(crate) struct CallTracker {
+    in_flight: AtomicUsize,
+    draining: AtomicBool,
+    idle: Notify,
+    closed: AtomicBool,
+    on_close: Notify,
+}
+
+impl CallTracker {
+    pub(crate) fn new() -> Arc<Self> {
+        Arc::new(Self::default())
+    }
+
+    /// Register a new call, failing if the client is draining
+    pub(crate) fn begin(self: &Arc<Self>) -> Result<CallGuard> {
+        self.in_flight.fetch_add(1, Ordering::SeqCst);
+        let guard = CallGuard { tracker: Arc::clone(self) };
+
+        if self.draining.load(Ordering::SeqCst) {
+            return Err(ClientError::Unavailable("Client is draining".to_string()));
+        }
+
+        Ok(guard)
+    }
+
+    /// Number of calls currently in flight
+    pub(crate) fn in_flight(&self) -> usize {
+        self.in_flight.load(Ordering::SeqCst)
+    }
+
+    /// Stop accepting new calls
+    pub(crate) fn start_draining(&self) {
+        self.draining.store(true, Ordering::SeqCst);
+    }
+
+    pub(crate) fn is_draining(&self) -> bool {
+        self.draining.load(Ordering::SeqCst)
+    }
+
+    /// Wait until no calls are in flight
+    pub(crate) async fn wait_idle(&self) {
+        loop {
+            // Register interest before checking the counter so a guard
+            // dropped in between still wakes us.
+            let notified = self.idle.notified();
+            if self.in_flight() == 0 {
+                return;
+            }
+            notified.await;
+        }
+    }
+
+    /// Signal that the connection should be closed
+    pub(crate) fn close(&self) {
+        self.closed.store(true, Ordering::SeqCst);
+        self.on_close.notify_waiters();
+    }
+
+    /// Wait until `close` is called
+    pub(crate) async fn closed(&self) {
+        loop {
+            let notified = self.on_close.notified();
+            if self.closed.load(Ordering::SeqCst) {
+                return;
+            }
+            notified.await;
+        }
+    }
+}
+
+/// What a call does when the client's concurrency limit is reached
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum WhenSaturated {
+    /// Wait for a running call to finish
+    #[default]
+    Wait,
+    /// Fail at once with `ClientError::ResourceExhausted`
+    Fail,
+}
+
+/// Cap on concurrent calls, shared across client clones
+#[derive(Debug)]
+pub(crate) struct ConcurrencyLimit {
+    slots: Arc<Semaphore>,
+    max: usize,
+    when_saturated: WhenSaturated,
+}
+
+impl ConcurrencyLimit {
+    pub(crate) fn new(max: usize, when_saturated: WhenSaturated) -> Arc<Self> {
+        Arc::new(Self {
+            slots: Arc::new(Semaphore::new(max)),
+            max,
+            when_saturated,
+        })
+    }
+
+    /// Take a slot for one call, held until the permit is dropped
+    pub(crate) async fn acquire(&self) -> Result<OwnedSemaphorePermit> {
+        let saturated = || {
+            ClientError::ResourceExhausted(format!(
+                "Client concurrency limit of {} requests reached",
+                self.max
+            ))
+        };
+        match self.when_saturated {
+            WhenSaturated::Wait => Arc::clone(&self.slots)
+                .acquire_owned()
+                .await
+                .map_err(|_| saturated()),
+            WhenSaturated::Fail => Arc::clone(&self.slots)
+                .try_acquire_owned()
+                .map_err(|_| saturated()),
+        }
+    }
+}
+
+/// RAII guard for a single in-flight call
+pub(crate) struct CallGuard {
+    tracker: Arc<CallTracker>,
+}
+
+impl Drop for CallGuard {
+    fn drop(&mut self) {
+        if self.tracker.in_flight.fetch_sub(1, Ordering::SeqCst) == 1 {
+            self.tracker.idle.notify_waiters();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_begin_rejected_while_draining() {
+        let tracker = CallTracker::new();
+        let guard = tracker.begin().unwrap();
+        assert_eq!(tracker.in_flight(), 1);
+
+        tracker.start_draining();
+        assert!(matches!(tracker.begin(), Err(ClientError::Unavailable(_))));
+        assert_eq!(tracker.in_flight(), 1);
+
+        drop(guard);
+        assert_eq!(tracker.in_flight(), 0);
+        tracker.wait_idle().await;
+    }
+
+    #[tokio::test]
+    async fn test_concurrency_limit_fail_fast() {
+        let limit = ConcurrencyLimit::new(1, WhenSaturated::Fail);
+        let slot = limit.acquire().await.unwrap();
+        assert!(matches!(limit.acquire().await, Err(ClientError::ResourceExhausted(_))));
+
+        drop(slot);
+        assert!(limit.acquire().await.is_ok());
+    }
+
+    #[tokio::test]
+    async fn test_wait_idle_wakes_on_last_guard() {
+        let tracker = CallTracker::new();
+        let guard = tracker.begin().unwrap();
+
+        let waiter = {
+            let tracker = Arc::clone(&tracker);
+            tokio::spawn(async move { tracker.wait_idle().await })
+        };
+
+        tokio::task::yield_now().await;
+        drop(guard);
+        waiter.await.unwrap();
+    }
+}
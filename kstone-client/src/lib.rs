@@ -11,14 +11,57 @@ pub mod batch;
 pub mod transaction;
 pub mod update;
 pub mod partiql;
+pub mod cond;
+pub mod put;
+pub mod delete;
+pub mod dry_run;
+pub mod auth;
+pub mod breaker;
+pub mod metadata;
+pub mod discovery;
+pub mod limits;
+pub mod cursor;
+pub mod import;
+pub mod export;
+pub mod migrate;
+pub mod pool;
+pub mod rate_limit;
+pub mod chunked;
+pub mod live;
+pub mod read_cache;
+pub mod validate;
+pub mod write_stream;
+pub mod request_id;
+mod fallback;
+mod idle;
+mod inflight;
+mod tenant;
 
 // Re-export key types
-pub use client::Client;
+pub use client::{Client, ConnectOptions, DEFAULT_USER_AGENT};
+pub use auth::{StaticToken, Token, TokenSource};
+pub use breaker::{BreakerConfig, CircuitState};
+pub use inflight::WhenSaturated;
+pub use discovery::{DnsSrvResolver, ResolveFuture, SrvResolver, SrvTarget};
+pub use limits::ResultLimits;
+pub use cursor::ScanPages;
+pub use import::ImportStats;
+pub use export::{ExportStats, RemoteExport};
+pub use migrate::MigrateStats;
+pub use chunked::{ChunkOptions, ChunkedWriteResponse};
+pub use live::LiveQueryStream;
+pub use write_stream::{WriteAck, WriteAcks, WriteOp, WriteSender, WriteStream};
+pub use read_cache::{ReadCacheConfig, ReadCacheStats};
+pub use pool::{ConnectPool, ConsistentHashRouter, RoundRobinRouter, Router};
 pub use error::{ClientError, Result};
-pub use kstone_core::{Item, Value};
+pub use kstone_core::{Item, Value, item_size, value_equal, item_diff, DiffKind, CancellationReason, ExportManifest};
 pub use query::{RemoteQuery, RemoteQueryResponse};
 pub use scan::{RemoteScan, RemoteScanResponse};
 pub use batch::{RemoteBatchGetRequest, RemoteBatchGetResponse, RemoteBatchWriteRequest, RemoteBatchWriteResponse};
-pub use transaction::{RemoteTransactGetRequest, RemoteTransactGetResponse, RemoteTransactWriteRequest};
-pub use update::{RemoteUpdate, RemoteUpdateResponse};
-pub use partiql::RemoteExecuteStatementResponse;
+pub use transaction::{RemoteTransactGetRequest, RemoteTransactGetResponse, RemoteTransactWriteRequest, RemoteTransactWriteResponse};
+pub use update::{RemoteUpdate, RemoteUpdateResponse, UpdateExpr, CompiledUpdate};
+pub use put::{RemotePut, RemotePutResponse};
+pub use delete::RemoteDelete;
+pub use dry_run::RemoteDryRunResult;
+pub use cond::{Cond, CompiledCondition};
+pub use partiql::{PlanOperation, RemoteExecuteStatementResponse, RemotePreparedStatement, RemoteQueryPlan};
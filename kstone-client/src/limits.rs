@@ -0,0 +1,140 @@
+/// Result budgets for the aggregating `scan_all` and `query_all` helpers
+///
+/// The helpers follow `last_key` from page to page and collect every item.
+/// Budgets stop an accidentally unbounded read before it exhausts memory:
+/// once one is reached, the helper stops with `ClientError::LimitExceeded`,
+/// which carries the items collected so far and the key to resume from.
+
+use crate::error::{ClientError, Result};
+use bytes::Bytes;
+use kstone_core::{item_size, Item};
+
+/// Budgets for an aggregating read
+#[derive(Debug, Clone, Copy, Default)]
+pub struct ResultLimits {
+    max_items: Option<usize>,
+    max_bytes: Option<usize>,
+}
+
+impl ResultLimits {
+    /// No limits
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Stop after collecting `max_items` items
+    ///
+    /// Pages are sized so that exactly `max_items` items are returned.
+    pub fn max_items(mut self, max_items: usize) -> Self {
+        self.max_items = Some(max_items);
+        self
+    }
+
+    /// Stop once the collected items total `max_bytes` or more
+    ///
+    /// Checked after each page, so the result can exceed the budget by up
+    /// to one page; set a page size with `limit` to bound the overshoot.
+    pub fn max_bytes(mut self, max_bytes: usize) -> Self {
+        self.max_bytes = Some(max_bytes);
+        self
+    }
+}
+
+/// Items collected across pages, checked against the limits
+pub(crate) struct Aggregate {
+    limits: ResultLimits,
+    items: Vec<Item>,
+    bytes: usize,
+}
+
+impl Aggregate {
+    pub(crate) fn new(limits: ResultLimits) -> Self {
+        Self {
+            limits,
+            items: Vec::new(),
+            bytes: 0,
+        }
+    }
+
+    /// Size of the next page, given the request's own page size
+    pub(crate) fn page_limit(&self, requested: Option<u32>) -> Option<usize> {
+        let remaining = self
+            .limits
+            .max_items
+            .map(|max| max.saturating_sub(self.items.len()));
+        match (requested.map(|n| n as usize), remaining) {
+            (Some(requested), Some(remaining)) => Some(requested.min(remaining)),
+            (requested, remaining) => requested.or(remaining),
+        }
+    }
+
+    /// Add a page; returns the key to continue from, or None once done
+    ///
+    /// Fails with `LimitExceeded` if a budget is reached while more pages remain.
+    pub(crate) fn add(
+        &mut self,
+        items: Vec<Item>,
+        last_key: Option<(Bytes, Option<Bytes>)>,
+    ) -> Result<Option<(Bytes, Option<Bytes>)>> {
+        self.bytes += items.iter().map(item_size).sum::<usize>();
+        self.items.extend(items);
+
+        let last_key = match last_key {
+            Some(key) => key,
+            None => return Ok(None),
+        };
+
+        let limit = match self.limits {
+            ResultLimits { max_items: Some(max), .. } if self.items.len() >= max => {
+                format!("{} items", max)
+            }
+            ResultLimits { max_bytes: Some(max), .. } if self.bytes >= max => {
+                format!("{} bytes", max)
+            }
+            _ => return Ok(Some(last_key)),
+        };
+        Err(ClientError::LimitExceeded {
+            limit,
+            items: std::mem::take(&mut self.items),
+            last_key,
+        })
+    }
+
+    pub(crate) fn into_items(self) -> Vec<Item> {
+        self.items
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn key(pk: &str) -> Option<(Bytes, Option<Bytes>)> {
+        Some((Bytes::from(pk.to_string()), None))
+    }
+
+    #[test]
+    fn test_page_limit_respects_remaining_items() {
+        let mut aggregate = Aggregate::new(ResultLimits::new().max_items(5));
+        assert_eq!(aggregate.page_limit(Some(3)), Some(3));
+        aggregate.add(vec![Item::new(); 3], key("c")).unwrap();
+        assert_eq!(aggregate.page_limit(Some(3)), Some(2));
+        assert_eq!(aggregate.page_limit(None), Some(2));
+    }
+
+    #[test]
+    fn test_limit_only_exceeded_when_more_pages_remain() {
+        let mut aggregate = Aggregate::new(ResultLimits::new().max_items(2));
+        aggregate.add(vec![Item::new(); 2], None).unwrap();
+        assert_eq!(aggregate.into_items().len(), 2);
+
+        let mut aggregate = Aggregate::new(ResultLimits::new().max_items(2));
+        match aggregate.add(vec![Item::new(); 2], key("b")) {
+            Err(ClientError::LimitExceeded { items, last_key, .. }) => {
+                assert_eq!(items.len(), 2);
+                assert_eq!(last_key.0, Bytes::from("b"));
+            }
+            other => panic!("expected LimitExceeded, got {:?}", other),
+        }
+    }
+}
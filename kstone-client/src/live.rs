@@ -0,0 +1,62 @@
+/// Live queries over the network
+///
+/// `Client::query_live` runs a query and keeps the stream open: after the
+/// items the query matches now, the server pushes each matching item
+/// written to the partition from then on. Made for tailing append-only
+/// partitions such as event logs. Dropping the stream cancels it on the
+/// server.
+
+use crate::convert::proto_item_to_ks;
+use crate::error::Result;
+use kstone_core::Item;
+use kstone_proto as proto;
+use std::collections::VecDeque;
+use tonic::Streaming;
+
+/// Items of a live query: the current results, then new items as written
+pub struct LiveQueryStream {
+    inner: Streaming<proto::QueryLiveResponse>,
+    buffered: VecDeque<Item>,
+    caught_up: bool,
+}
+
+impl LiveQueryStream {
+    pub(crate) fn new(inner: Streaming<proto::QueryLiveResponse>) -> Self {
+        Self {
+            inner,
+            buffered: VecDeque::new(),
+            caught_up: false,
+        }
+    }
+
+    /// Next item, waiting for one to be written if the current results
+    /// have all been returned
+    ///
+    /// Returns None once the server closes the stream. Wrap the call in
+    /// `tokio::time::timeout` or `select!` to stop waiting.
+    pub async fn next(&mut self) -> Option<Result<Item>> {
+        loop {
+            if let Some(item) = self.buffered.pop_front() {
+                return Some(Ok(item));
+            }
+            let message = match self.inner.message().await {
+                Ok(Some(message)) => message,
+                Ok(None) => return None,
+                Err(status) => return Some(Err(status.into())),
+            };
+            self.caught_up = true;
+            for proto_item in message.items {
+                match proto_item_to_ks(proto_item) {
+                    Ok(item) => self.buffered.push_back(item),
+                    Err(status) => return Some(Err(status.into())),
+                }
+            }
+        }
+    }
+
+    /// Whether the query's initial results have been received, so further
+    /// items are ones written after it was opened
+    pub fn is_caught_up(&self) -> bool {
+        self.caught_up && self.buffered.is_empty()
+    }
+}
@@ -0,0 +1,111 @@
+/// Request metadata (gRPC headers) attached to client calls
+///
+/// Headers set on a `Client` are sent with every call made through it and
+/// through clones made afterwards. Use them for auth tokens, tenant ids or
+/// trace headers. Credentials (see `auth`) and the caller's request ID (see
+/// `request_id`) are added the same way.
+
+use crate::auth::Credentials;
+use crate::error::{ClientError, Result};
+use crate::request_id::{RequestIdSource, REQUEST_ID_HEADER};
+use std::sync::Arc;
+use tonic::metadata::{Ascii, MetadataKey, MetadataValue};
+use tonic::service::interceptor::InterceptedService;
+use tonic::service::Interceptor;
+use tonic::transport::Channel;
+use tonic::{Request, Status};
+
+/// gRPC transport used by the client: a channel that adds request metadata
+pub type Transport = InterceptedService<Channel, MetadataInterceptor>;
+
+/// Interceptor that appends a fixed set of headers, the current credential
+/// and the current request ID, if any, to each request
+#[derive(Debug, Clone, Default)]
+pub struct MetadataInterceptor {
+    headers: Vec<(MetadataKey<Ascii>, MetadataValue<Ascii>)>,
+    credentials: Option<Arc<Credentials>>,
+    request_id: RequestIdSource,
+}
+
+impl MetadataInterceptor {
+    /// Add a header, validating its name and value
+    pub(crate) fn add(&mut self, key: &str, value: &str) -> Result<()> {
+        let key = MetadataKey::from_bytes(key.as_bytes())
+            .map_err(|e| ClientError::InvalidArgument(format!("Invalid metadata key '{}': {}", key, e)))?;
+        let value = MetadataValue::try_from(value)
+            .map_err(|e| ClientError::InvalidArgument(format!("Invalid metadata value for '{}': {}", key, e)))?;
+        self.headers.push((key, value));
+        Ok(())
+    }
+
+    /// Send `credentials` with every request, replacing any previous ones
+    pub(crate) fn set_credentials(&mut self, credentials: Arc<Credentials>) {
+        self.credentials = Some(credentials);
+    }
+
+    /// Look up each request's ID with `source` instead of `current_request_id`
+    pub(crate) fn set_request_id_source(&mut self, source: RequestIdSource) {
+        self.request_id = source;
+    }
+}
+
+impl Interceptor for MetadataInterceptor {
+    fn call(&mut self, mut request: Request<()>) -> std::result::Result<Request<()>, Status> {
+        for (key, value) in &self.headers {
+            request.metadata_mut().append(key.clone(), value.clone());
+        }
+        if let Some((key, value)) = self.credentials.as_ref().and_then(|c| c.header()) {
+            request.metadata_mut().insert(key, value);
+        }
+        if let Some(id) = self.request_id.current() {
+            let value = MetadataValue::try_from(id.as_str())
+                .map_err(|_| Status::invalid_argument(format!("Invalid request ID '{}'", id.escape_debug())))?;
+            request.metadata_mut().insert(REQUEST_ID_HEADER, value);
+        }
+        Ok(request)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_headers_are_appended() {
+        let mut interceptor = MetadataInterceptor::default();
+        interceptor.add("x-tenant-id", "acme").unwrap();
+        interceptor.add("x-trace-id", "t1").unwrap();
+        interceptor.add("x-trace-id", "t2").unwrap();
+
+        let request = interceptor.call(Request::new(())).unwrap();
+        assert_eq!(request.metadata().get("x-tenant-id").unwrap(), "acme");
+        assert_eq!(request.metadata().get_all("x-trace-id").iter().count(), 2);
+    }
+
+    #[tokio::test]
+    async fn test_request_id_is_sent_from_scope_or_source() {
+        let mut interceptor = MetadataInterceptor::default();
+        let request = interceptor.call(Request::new(())).unwrap();
+        assert!(request.metadata().get(REQUEST_ID_HEADER).is_none());
+
+        let request = crate::request_id::with_request_id("req-1", async {
+            interceptor.call(Request::new(())).unwrap()
+        })
+        .await;
+        assert_eq!(request.metadata().get(REQUEST_ID_HEADER).unwrap(), "req-1");
+
+        interceptor.set_request_id_source(RequestIdSource::new(|| Some("from-source".to_string())));
+        let request = interceptor.call(Request::new(())).unwrap();
+        assert_eq!(request.metadata().get(REQUEST_ID_HEADER).unwrap(), "from-source");
+
+        interceptor.set_request_id_source(RequestIdSource::new(|| Some("bad\nid".to_string())));
+        assert!(interceptor.call(Request::new(())).is_err());
+    }
+
+    #[test]
+    fn test_invalid_headers_rejected() {
+        let mut interceptor = MetadataInterceptor::default();
+        assert!(interceptor.add("bad key", "v").is_err());
+        assert!(interceptor.add("x-ok", "bad\nvalue").is_err());
+    }
+}
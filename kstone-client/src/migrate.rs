@@ -0,0 +1,25 @@
+/// Renaming an attribute across existing items
+///
+/// `Client::migrate_attribute` streams the items in scope (see
+/// `RemoteExport`) and, for each one holding the old attribute, applies
+/// `SET #to = #from REMOVE #from` conditioned on the old attribute still
+/// existing. Items already migrated, by an earlier run or concurrently,
+/// fail the condition and are skipped, so an interrupted migration is
+/// finished by simply running it again.
+
+/// Outcome of an attribute migration
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct MigrateStats {
+    /// Items examined
+    pub scanned: u64,
+    /// Items rewritten
+    pub migrated: u64,
+    /// Items without the old attribute, including ones already migrated
+    pub skipped: u64,
+}
+
+/// Update expression moving `#from` to `#to`
+pub(crate) const MIGRATE_EXPRESSION: &str = "SET #to = #from REMOVE #from";
+
+/// Condition keeping reruns from touching migrated items
+pub(crate) const MIGRATE_CONDITION: &str = "attribute_exists(#from)";
@@ -1,9 +1,11 @@
 /// Remote PartiQL operations
 use crate::convert::*;
 use crate::error::{ClientError, Result};
+use crate::Client;
 use bytes::Bytes;
-use kstone_core::Item;
+use kstone_core::{Item, Value};
 use kstone_proto as proto;
+use serde::de::DeserializeOwned;
 
 /// Execute statement response (mirrors kstone-api ExecuteStatementResponse)
 #[derive(Debug)]
@@ -23,6 +25,148 @@ pub enum RemoteExecuteStatementResponse {
     Delete { success: bool },
 }
 
+impl RemoteExecuteStatementResponse {
+    /// Decode the rows of a SELECT into `T`, in order
+    ///
+    /// Attributes map to fields by name, with numbers converted to the
+    /// field's numeric type (see `kstone_core::decode`). Fails with
+    /// `InvalidArgument` for other statements or a row that doesn't fit.
+    pub fn decode<T: DeserializeOwned>(&self) -> Result<Vec<T>> {
+        self.rows()?.iter().map(decode_row).collect()
+    }
+
+    /// Decode the single row of a SELECT into `T`
+    ///
+    /// Returns None if there are no rows, and fails with `InvalidArgument`
+    /// if there is more than one.
+    pub fn decode_one<T: DeserializeOwned>(&self) -> Result<Option<T>> {
+        match self.rows()? {
+            [] => Ok(None),
+            [row] => decode_row(row).map(Some),
+            rows => Err(ClientError::InvalidArgument(format!(
+                "expected at most one row, got {}",
+                rows.len()
+            ))),
+        }
+    }
+
+    fn rows(&self) -> Result<&[Item]> {
+        match self {
+            RemoteExecuteStatementResponse::Select { items, .. } => Ok(items),
+            _ => Err(ClientError::InvalidArgument(
+                "only SELECT results have rows to decode".to_string(),
+            )),
+        }
+    }
+}
+
+/// A statement prepared on the server, from `Client::prepare`
+///
+/// Holds a clone of the client it was prepared with.
+#[derive(Clone)]
+pub struct RemotePreparedStatement {
+    client: Client,
+    sql: String,
+    parameter_count: usize,
+}
+
+impl RemotePreparedStatement {
+    pub(crate) fn new(client: Client, sql: String, parameter_count: usize) -> Self {
+        Self {
+            client,
+            sql,
+            parameter_count,
+        }
+    }
+
+    /// The statement's SQL text
+    pub fn sql(&self) -> &str {
+        &self.sql
+    }
+
+    /// Number of `?` parameters the statement takes
+    pub fn parameter_count(&self) -> usize {
+        self.parameter_count
+    }
+
+    /// Execute the statement with values for its parameters, in order
+    ///
+    /// Fails with `InvalidArgument`, without calling the server, unless
+    /// exactly `parameter_count()` values are given.
+    pub async fn execute(&mut self, params: &[Value]) -> Result<RemoteExecuteStatementResponse> {
+        if params.len() != self.parameter_count {
+            return Err(ClientError::InvalidArgument(format!(
+                "statement takes {} parameters, got {}",
+                self.parameter_count,
+                params.len()
+            )));
+        }
+        let parameters = params.iter().map(ks_value_to_proto).collect();
+        self.client.execute_with_parameters(self.sql.clone(), parameters).await
+    }
+}
+
+/// How a statement would be executed, from `Client::explain`
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RemoteQueryPlan {
+    /// Access path the statement takes
+    pub operation: PlanOperation,
+    /// Index read instead of the base table, if any
+    pub index_name: Option<String>,
+    /// Items the statement would examine, before filters and LIMIT
+    pub estimated_items: u64,
+}
+
+/// Access path of a query plan (mirrors kstone-api PlanOperation)
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum PlanOperation {
+    /// Query of one partition
+    Query,
+    /// One query per partition key in an `IN` list
+    MultiQuery,
+    /// Scan of the whole table
+    Scan,
+    /// Single-item INSERT, UPDATE or DELETE
+    Write,
+}
+
+impl RemoteQueryPlan {
+    /// Whether the statement reads through a secondary index
+    pub fn uses_index(&self) -> bool {
+        self.index_name.is_some()
+    }
+
+    /// Whether the statement scans the whole table
+    pub fn is_full_scan(&self) -> bool {
+        self.operation == PlanOperation::Scan
+    }
+}
+
+/// Parse ExplainResponse from protobuf
+pub(crate) fn parse_explain_response(response: proto::ExplainResponse) -> Result<RemoteQueryPlan> {
+    let operation = match proto::PlanOperation::try_from(response.operation) {
+        Ok(proto::PlanOperation::Query) => PlanOperation::Query,
+        Ok(proto::PlanOperation::MultiQuery) => PlanOperation::MultiQuery,
+        Ok(proto::PlanOperation::Scan) => PlanOperation::Scan,
+        Ok(proto::PlanOperation::Write) => PlanOperation::Write,
+        Err(_) => {
+            return Err(ClientError::InvalidArgument(format!(
+                "unknown plan operation {}",
+                response.operation
+            )))
+        }
+    };
+    Ok(RemoteQueryPlan {
+        operation,
+        index_name: response.index_name,
+        estimated_items: response.estimated_items,
+    })
+}
+
+fn decode_row<T: DeserializeOwned>(row: &Item) -> Result<T> {
+    kstone_core::from_item(row).map_err(|e| ClientError::InvalidArgument(e.to_string()))
+}
+
 /// Parse ExecuteStatementResponse from protobuf
 pub(crate) fn parse_execute_statement_response(
     response: proto::ExecuteStatementResponse,
@@ -0,0 +1,223 @@
+/// Client pools over several servers
+///
+/// A `ConnectPool` holds one connection per server of a sharded cluster
+/// and picks one per request through a `Router`. `ConsistentHashRouter`
+/// sends every request for a partition key to the same server, so each
+/// server's caches only hold its share of the keys; `RoundRobinRouter`
+/// (the default) spreads requests evenly. Requests without a partition
+/// key, such as scans, are always spread round-robin.
+
+use crate::client::{Client, ConnectOptions};
+use crate::error::{ClientError, Result};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Arc;
+
+/// Picks the server a request goes to
+pub trait Router: Send + Sync {
+    /// Called once with the pool's endpoints, in order, before any routing
+    fn bind(&mut self, endpoints: &[String]);
+
+    /// Index of the endpoint for a request on `partition_key`, or for a
+    /// request that spans partitions if None
+    fn route(&self, partition_key: Option<&[u8]>) -> usize;
+}
+
+/// Spreads requests evenly, ignoring the partition key
+#[derive(Debug, Default)]
+pub struct RoundRobinRouter {
+    endpoints: usize,
+    next: AtomicUsize,
+}
+
+impl RoundRobinRouter {
+    pub fn new() -> Self {
+        Self::default()
+    }
+}
+
+impl Router for RoundRobinRouter {
+    fn bind(&mut self, endpoints: &[String]) {
+        self.endpoints = endpoints.len();
+    }
+
+    fn route(&self, _partition_key: Option<&[u8]>) -> usize {
+        self.next.fetch_add(1, Ordering::Relaxed) % self.endpoints.max(1)
+    }
+}
+
+/// Virtual nodes per endpoint on the hash ring
+const VIRTUAL_NODES: usize = 64;
+
+/// Routes each partition key to the same endpoint
+///
+/// Endpoints are placed on a hash ring by address (with several virtual
+/// nodes each), so the mapping is the same in every process using the
+/// same endpoints, and adding or removing an endpoint only moves the keys
+/// next to it on the ring.
+#[derive(Debug, Default)]
+pub struct ConsistentHashRouter {
+    ring: Vec<(u64, usize)>,
+    fallback: RoundRobinRouter,
+}
+
+impl ConsistentHashRouter {
+    pub fn new() -> Self {
+        Self::default()
+    }
+}
+
+impl Router for ConsistentHashRouter {
+    fn bind(&mut self, endpoints: &[String]) {
+        self.ring = endpoints
+            .iter()
+            .enumerate()
+            .flat_map(|(index, endpoint)| {
+                (0..VIRTUAL_NODES).map(move |vnode| (hash(format!("{}#{}", endpoint, vnode).as_bytes()), index))
+            })
+            .collect();
+        self.ring.sort_unstable();
+        self.fallback.bind(endpoints);
+    }
+
+    fn route(&self, partition_key: Option<&[u8]>) -> usize {
+        let Some(pk) = partition_key else {
+            return self.fallback.route(None);
+        };
+        if self.ring.is_empty() {
+            return 0;
+        }
+        let h = hash(pk);
+        let at = self.ring.partition_point(|&(point, _)| point < h);
+        self.ring[at % self.ring.len()].1
+    }
+}
+
+/// 64-bit FNV-1a; stable across processes and releases, unlike `DefaultHasher`
+fn hash(data: &[u8]) -> u64 {
+    let mut h: u64 = 0xcbf2_9ce4_8422_2325;
+    for &byte in data {
+        h ^= byte as u64;
+        h = h.wrapping_mul(0x0000_0100_0000_01b3);
+    }
+    h
+}
+
+/// Connections to several servers with per-request routing
+#[derive(Clone)]
+pub struct ConnectPool {
+    endpoints: Vec<String>,
+    clients: Vec<Client>,
+    router: Arc<dyn Router>,
+}
+
+impl ConnectPool {
+    /// Connect to every address, routing round-robin
+    ///
+    /// # Example
+    /// ```no_run
+    /// # use kstone_client::{ConnectOptions, ConnectPool, ConsistentHashRouter};
+    /// # async fn example() -> Result<(), Box<dyn std::error::Error>> {
+    /// let pool = ConnectPool::connect(
+    ///     ["http://db-0:50051", "http://db-1:50051", "http://db-2:50051"],
+    ///     ConnectOptions::new(),
+    /// )
+    /// .await?
+    /// .with_router(ConsistentHashRouter::new());
+    ///
+    /// let item = pool.for_key(b"user#123").get(b"user#123").await?;
+    /// # Ok(())
+    /// # }
+    /// ```
+    pub async fn connect<I, S>(addrs: I, options: ConnectOptions) -> Result<Self>
+    where
+        I: IntoIterator<Item = S>,
+        S: Into<String>,
+    {
+        let endpoints: Vec<String> = addrs.into_iter().map(Into::into).collect();
+        if endpoints.is_empty() {
+            return Err(ClientError::InvalidArgument("Connection pool needs at least one address".to_string()));
+        }
+
+        let mut clients = Vec::with_capacity(endpoints.len());
+        for addr in &endpoints {
+            clients.push(Client::connect_with_options(addr.clone(), options.clone()).await?);
+        }
+
+        let mut router = RoundRobinRouter::new();
+        router.bind(&endpoints);
+        Ok(Self {
+            endpoints,
+            clients,
+            router: Arc::new(router),
+        })
+    }
+
+    /// Route requests with `router`
+    pub fn with_router(mut self, mut router: impl Router + 'static) -> Self {
+        router.bind(&self.endpoints);
+        self.router = Arc::new(router);
+        self
+    }
+
+    /// Client for a request on partition `pk`
+    pub fn for_key(&self, pk: &[u8]) -> Client {
+        self.clients[self.index(Some(pk))].clone()
+    }
+
+    /// Client for a request without a single partition key (e.g. a scan)
+    pub fn for_scan(&self) -> Client {
+        self.clients[self.index(None)].clone()
+    }
+
+    /// Address a request on partition `pk` is routed to
+    pub fn endpoint_for(&self, pk: &[u8]) -> &str {
+        &self.endpoints[self.index(Some(pk))]
+    }
+
+    /// Addresses in the pool, in connection order
+    pub fn endpoints(&self) -> &[String] {
+        &self.endpoints
+    }
+
+    fn index(&self, pk: Option<&[u8]>) -> usize {
+        self.router.route(pk) % self.clients.len()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn endpoints(n: usize) -> Vec<String> {
+        (0..n).map(|i| format!("http://db-{}:50051", i)).collect()
+    }
+
+    #[test]
+    fn test_consistent_hash_moves_few_keys() {
+        let mut three = ConsistentHashRouter::new();
+        three.bind(&endpoints(3));
+        let mut four = ConsistentHashRouter::new();
+        four.bind(&endpoints(4));
+
+        let keys: Vec<String> = (0..1000).map(|i| format!("user#{}", i)).collect();
+        let moved = keys
+            .iter()
+            .filter(|k| three.route(Some(k.as_bytes())) != four.route(Some(k.as_bytes())))
+            .count();
+        // Roughly a quarter of the keys move to the new endpoint; none move between old ones
+        assert!(moved > 100 && moved < 400, "moved {}", moved);
+        for k in &keys {
+            let before = three.route(Some(k.as_bytes()));
+            let after = four.route(Some(k.as_bytes()));
+            assert!(after == before || after == 3);
+        }
+    }
+
+    #[test]
+    fn test_unkeyed_requests_round_robin() {
+        let mut router = ConsistentHashRouter::new();
+        router.bind(&endpoints(3));
+        let picks: Vec<usize> = (0..6).map(|_| router.route(None)).collect();
+        assert_eq!(picks, vec![0, 1, 2, 0, 1, 2]);
+    }
+}
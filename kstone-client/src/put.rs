@@ -0,0 +1,158 @@
+/// Remote put operations
+use crate::cond::Cond;
+use crate::convert::*;
+use crate::dry_run::RemoteDryRunResult;
+use crate::error::Result;
+use kstone_core::Item;
+use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
+use crate::metadata::Transport;
+use crate::validate;
+use std::collections::HashMap;
+
+/// Remote put request builder
+pub struct RemotePut {
+    partition_key: Vec<u8>,
+    sort_key: Option<Vec<u8>>,
+    item: Item,
+    condition_expression: Option<String>,
+    expression_values: HashMap<String, kstone_core::Value>,
+    expression_names: HashMap<String, String>,
+    dry_run: bool,
+    return_consumed_capacity: bool,
+}
+
+impl RemotePut {
+    /// Create a new put operation
+    pub fn new(pk: &[u8], item: Item) -> Self {
+        Self {
+            partition_key: pk.to_vec(),
+            sort_key: None,
+            item,
+            condition_expression: None,
+            expression_values: HashMap::new(),
+            expression_names: HashMap::new(),
+            dry_run: false,
+            return_consumed_capacity: false,
+        }
+    }
+
+    /// Create put with sort key
+    pub fn with_sk(pk: &[u8], sk: &[u8], item: Item) -> Self {
+        let mut put = Self::new(pk, item);
+        put.sort_key = Some(sk.to_vec());
+        put
+    }
+
+    /// Set a raw condition expression
+    pub fn condition(mut self, condition: impl Into<String>) -> Self {
+        self.condition_expression = Some(condition.into());
+        self
+    }
+
+    /// Set the condition from a `cond` builder
+    ///
+    /// Replaces any previous condition; the compiled names and values are
+    /// merged into the request's maps.
+    pub fn condition_expr(mut self, condition: Cond) -> Self {
+        let compiled = condition.compile();
+        self.condition_expression = Some(compiled.expression);
+        self.expression_names.extend(compiled.names);
+        self.expression_values.extend(compiled.values);
+        self
+    }
+
+    /// Add an expression attribute value
+    pub fn value(mut self, placeholder: impl Into<String>, value: kstone_core::Value) -> Self {
+        self.expression_values.insert(placeholder.into(), value);
+        self
+    }
+
+    /// Add an expression attribute name
+    ///
+    /// Lets a condition refer to an attribute through a `#` placeholder,
+    /// e.g. one named like a reserved word such as `status` or `size`.
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.expression_names.insert(placeholder.into(), name.into());
+        self
+    }
+
+    /// Validate and evaluate the put on the server without persisting it
+    pub fn dry_run(mut self, dry_run: bool) -> Self {
+        self.dry_run = dry_run;
+        self
+    }
+
+    /// Report the capacity the put consumed in the response
+    ///
+    /// A dry run writes nothing and reports no capacity.
+    pub fn return_consumed_capacity(mut self, enabled: bool) -> Self {
+        self.return_consumed_capacity = enabled;
+        self
+    }
+
+    /// Condition expression, if set
+    pub fn condition_expression(&self) -> Option<&str> {
+        self.condition_expression.as_deref()
+    }
+
+    /// Expression attribute names bound to this request
+    pub fn expression_names(&self) -> &HashMap<String, String> {
+        &self.expression_names
+    }
+
+    /// Expression attribute values bound to this request
+    pub fn expression_values(&self) -> &HashMap<String, kstone_core::Value> {
+        &self.expression_values
+    }
+
+    /// Partition key this request targets
+    pub(crate) fn partition_key(&self) -> &[u8] {
+        &self.partition_key
+    }
+
+    /// Check the put locally before it is sent
+    ///
+    /// Rejects an empty partition key.
+    pub fn validate(&self) -> Result<()> {
+        validate::partition_key(&self.partition_key, "partition key")
+    }
+
+    /// Execute the put operation
+    pub async fn execute(self, client: &mut KeystoneDbClient<Transport>) -> Result<RemotePutResponse> {
+        self.validate()?;
+        let proto_values: HashMap<String, proto::Value> = self
+            .expression_values
+            .iter()
+            .map(|(k, v)| (k.clone(), ks_value_to_proto(v)))
+            .collect();
+
+        let request = proto::PutRequest {
+            partition_key: self.partition_key,
+            sort_key: self.sort_key,
+            item: Some(ks_item_to_proto(&self.item)),
+            condition_expression: self.condition_expression,
+            expression_values: proto_values,
+            expression_names: self.expression_names,
+            dry_run: self.dry_run,
+            return_consumed_capacity: self.return_consumed_capacity,
+        };
+
+        let response = client.put(request).await?.into_inner();
+
+        Ok(RemotePutResponse {
+            dry_run: response
+                .dry_run_result
+                .map(RemoteDryRunResult::from_proto)
+                .transpose()?,
+            consumed_capacity: response.consumed_capacity.map(proto_consumed_capacity_to_ks),
+        })
+    }
+}
+
+/// Put response
+pub struct RemotePutResponse {
+    /// Dry-run outcome (only set when the put was a dry run)
+    pub dry_run: Option<RemoteDryRunResult>,
+    /// Capacity consumed, if requested with `return_consumed_capacity`
+    pub consumed_capacity: Option<kstone_core::ConsumedCapacity>,
+}
@@ -1,19 +1,33 @@
 /// Remote query builder and response types
 use crate::convert::*;
-use crate::error::Result;
+use crate::error::{ClientError, Result};
+use crate::fallback::LocalFilter;
+use crate::validate;
 use bytes::Bytes;
-use kstone_core::Item;
+use kstone_core::{aggregate, ConsumedCapacity, Item, Value};
 use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
-use tonic::transport::Channel;
+use serde::de::DeserializeOwned;
+use std::collections::HashMap;
+use crate::metadata::Transport;
 
 /// Remote query builder
+#[derive(Clone)]
 pub struct RemoteQuery {
     partition_key: Vec<u8>,
     sort_key_condition: Option<proto::SortKeyCondition>,
+    /// Set when a second sort key condition replaced the first
+    sk_condition_replaced: bool,
     limit: Option<u32>,
     exclusive_start_key: Option<proto::LastKey>,
     scan_forward: Option<bool>,
     index_name: Option<String>,
+    fetch_full_items: bool,
+    filter_expression: Option<String>,
+    expression_values: HashMap<String, Value>,
+    expression_names: HashMap<String, String>,
+    select: proto::Select,
+    sum_attribute: Option<String>,
+    return_consumed_capacity: bool,
 }
 
 impl RemoteQuery {
@@ -22,82 +36,77 @@ impl RemoteQuery {
         Self {
             partition_key: pk.to_vec(),
             sort_key_condition: None,
+            sk_condition_replaced: false,
             limit: None,
             exclusive_start_key: None,
             scan_forward: None,
             index_name: None,
+            fetch_full_items: false,
+            filter_expression: None,
+            expression_values: HashMap::new(),
+            expression_names: HashMap::new(),
+            select: proto::Select::AllAttributes,
+            sum_attribute: None,
+            return_consumed_capacity: false,
         }
     }
 
     /// Add a sort key equals condition
-    pub fn sk_eq(mut self, sk: &[u8]) -> Self {
-        self.sort_key_condition = Some(proto::SortKeyCondition {
-            condition: Some(proto::sort_key_condition::Condition::EqualTo(
-                value_to_proto_bytes(sk),
-            )),
-        });
-        self
+    pub fn sk_eq(self, sk: &[u8]) -> Self {
+        self.set_sk_condition(proto::sort_key_condition::Condition::EqualTo(
+            value_to_proto_bytes(sk),
+        ))
     }
 
     /// Add a sort key less than condition
-    pub fn sk_lt(mut self, sk: &[u8]) -> Self {
-        self.sort_key_condition = Some(proto::SortKeyCondition {
-            condition: Some(proto::sort_key_condition::Condition::LessThan(
-                value_to_proto_bytes(sk),
-            )),
-        });
-        self
+    pub fn sk_lt(self, sk: &[u8]) -> Self {
+        self.set_sk_condition(proto::sort_key_condition::Condition::LessThan(
+            value_to_proto_bytes(sk),
+        ))
     }
 
     /// Add a sort key less than or equal condition
-    pub fn sk_lte(mut self, sk: &[u8]) -> Self {
-        self.sort_key_condition = Some(proto::SortKeyCondition {
-            condition: Some(proto::sort_key_condition::Condition::LessThanOrEqual(
-                value_to_proto_bytes(sk),
-            )),
-        });
-        self
+    pub fn sk_lte(self, sk: &[u8]) -> Self {
+        self.set_sk_condition(proto::sort_key_condition::Condition::LessThanOrEqual(
+            value_to_proto_bytes(sk),
+        ))
     }
 
     /// Add a sort key greater than condition
-    pub fn sk_gt(mut self, sk: &[u8]) -> Self {
-        self.sort_key_condition = Some(proto::SortKeyCondition {
-            condition: Some(proto::sort_key_condition::Condition::GreaterThan(
-                value_to_proto_bytes(sk),
-            )),
-        });
-        self
+    pub fn sk_gt(self, sk: &[u8]) -> Self {
+        self.set_sk_condition(proto::sort_key_condition::Condition::GreaterThan(
+            value_to_proto_bytes(sk),
+        ))
     }
 
     /// Add a sort key greater than or equal condition
-    pub fn sk_gte(mut self, sk: &[u8]) -> Self {
-        self.sort_key_condition = Some(proto::SortKeyCondition {
-            condition: Some(proto::sort_key_condition::Condition::GreaterThanOrEqual(
-                value_to_proto_bytes(sk),
-            )),
-        });
-        self
+    pub fn sk_gte(self, sk: &[u8]) -> Self {
+        self.set_sk_condition(proto::sort_key_condition::Condition::GreaterThanOrEqual(
+            value_to_proto_bytes(sk),
+        ))
     }
 
     /// Add a sort key between condition
-    pub fn sk_between(mut self, sk1: &[u8], sk2: &[u8]) -> Self {
-        self.sort_key_condition = Some(proto::SortKeyCondition {
-            condition: Some(proto::sort_key_condition::Condition::Between(
-                proto::BetweenCondition {
-                    lower: Some(value_to_proto_bytes(sk1)),
-                    upper: Some(value_to_proto_bytes(sk2)),
-                },
-            )),
-        });
-        self
+    pub fn sk_between(self, sk1: &[u8], sk2: &[u8]) -> Self {
+        self.set_sk_condition(proto::sort_key_condition::Condition::Between(
+            proto::BetweenCondition {
+                lower: Some(value_to_proto_bytes(sk1)),
+                upper: Some(value_to_proto_bytes(sk2)),
+            },
+        ))
     }
 
     /// Add a sort key begins_with condition
-    pub fn sk_begins_with(mut self, prefix: &[u8]) -> Self {
+    pub fn sk_begins_with(self, prefix: &[u8]) -> Self {
+        self.set_sk_condition(proto::sort_key_condition::Condition::BeginsWith(
+            value_to_proto_bytes(prefix),
+        ))
+    }
+
+    fn set_sk_condition(mut self, condition: proto::sort_key_condition::Condition) -> Self {
+        self.sk_condition_replaced |= self.sort_key_condition.is_some();
         self.sort_key_condition = Some(proto::SortKeyCondition {
-            condition: Some(proto::sort_key_condition::Condition::BeginsWith(
-                value_to_proto_bytes(prefix),
-            )),
+            condition: Some(condition),
         });
         self
     }
@@ -129,46 +138,207 @@ impl RemoteQuery {
         self
     }
 
+    /// Return complete base-table items instead of projected index items
+    pub fn fetch_full_items(mut self, fetch: bool) -> Self {
+        self.fetch_full_items = fetch;
+        self
+    }
+
+    /// Only return items matching a filter expression
+    pub fn filter(mut self, expression: impl Into<String>) -> Self {
+        self.filter_expression = Some(expression.into());
+        self
+    }
+
+    /// Set the filter from a `cond` builder
+    ///
+    /// Replaces any previous filter; the compiled names and values are
+    /// merged into the request's maps.
+    pub fn filter_expr(mut self, filter: crate::cond::Cond) -> Self {
+        let compiled = filter.compile();
+        self.filter_expression = Some(compiled.expression);
+        self.expression_names.extend(compiled.names);
+        self.expression_values.extend(compiled.values);
+        self
+    }
+
+    /// Filter expression, if set
+    pub fn filter_expression(&self) -> Option<&str> {
+        self.filter_expression.as_deref()
+    }
+
+    /// Expression attribute names bound to this request
+    pub fn expression_names(&self) -> &HashMap<String, String> {
+        &self.expression_names
+    }
+
+    /// Expression attribute values bound to this request
+    pub fn expression_values(&self) -> &HashMap<String, Value> {
+        &self.expression_values
+    }
+
+    /// Bind an expression attribute value used by the filter
+    pub fn value(mut self, placeholder: impl Into<String>, value: Value) -> Self {
+        self.expression_values.insert(placeholder.into(), value);
+        self
+    }
+
+    /// Bind an expression attribute name used by the filter
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.expression_names.insert(placeholder.into(), name.into());
+        self
+    }
+
+    /// Return only the number of matching items; no items are transferred
+    ///
+    /// Unless the server rejects the filter: the client then runs the
+    /// query unfiltered and counts on its side (see `execute`), which
+    /// transfers every item examined.
+    pub fn select_count(mut self) -> Self {
+        self.select = proto::Select::Count;
+        self
+    }
+
+    /// Also return the sum of a numeric attribute over the matching items
+    ///
+    /// The server adds up the values, so with `select_count` no items are
+    /// transferred, except when the filter falls back to the client as for
+    /// `select_count`. Items without the attribute or with a non-numeric
+    /// value there are skipped.
+    pub fn sum(mut self, attribute: impl Into<String>) -> Self {
+        self.sum_attribute = Some(attribute.into());
+        self
+    }
+
+    /// Report the capacity the query consumed in the response
+    pub fn return_consumed_capacity(mut self, enabled: bool) -> Self {
+        self.return_consumed_capacity = enabled;
+        self
+    }
+
+    /// Page size, if set
+    pub(crate) fn page_limit(&self) -> Option<u32> {
+        self.limit
+    }
+
+    /// Partition key this request targets
+    pub(crate) fn partition_key(&self) -> &[u8] {
+        &self.partition_key
+    }
+
+    /// Check the query locally before it is sent
+    ///
+    /// Rejects an empty partition key, a limit of zero, and more than one
+    /// sort key condition (each `sk_*` call would replace the last).
+    pub fn validate(&self) -> Result<()> {
+        validate::partition_key(&self.partition_key, "partition key")?;
+        validate::limit(self.limit)?;
+        if self.sk_condition_replaced {
+            return Err(ClientError::InvalidArgument(
+                "only one sort key condition may be set; use sk_between for a range".to_string(),
+            ));
+        }
+        Ok(())
+    }
+
     /// Execute the query
+    ///
+    /// If the server rejects the filter and the client can apply it, the
+    /// query runs unfiltered and is filtered here (see `fallback`).
     pub async fn execute(
         self,
-        client: &mut KeystoneDbClient<Channel>,
+        client: &mut KeystoneDbClient<Transport>,
     ) -> Result<RemoteQueryResponse> {
-        let request = proto::QueryRequest {
+        self.validate()?;
+        let request = self.clone().into_proto();
+        let error = match send(client, request).await {
+            Ok(response) => return Ok(response),
+            Err(e) => e,
+        };
+        let Some(filter) = LocalFilter::for_rejected(
+            &error,
+            self.filter_expression(),
+            self.expression_names(),
+            self.expression_values(),
+        ) else {
+            return Err(error);
+        };
+
+        let count_only = self.select == proto::Select::Count;
+        let sum_attribute = self.sum_attribute.clone();
+        let unfiltered = Self {
+            filter_expression: None,
+            expression_names: HashMap::new(),
+            expression_values: HashMap::new(),
+            select: proto::Select::AllAttributes,
+            sum_attribute: None,
+            ..self
+        };
+        let mut response = send(client, unfiltered.into_proto()).await?;
+        response.items = filter.retain(response.items)?;
+        response.count = response.items.len();
+        response.sum = sum_attribute.map(|attribute| aggregate::sum(&response.items, &attribute));
+        if count_only {
+            response.items.clear();
+        }
+        Ok(response)
+    }
+
+    pub(crate) fn into_proto(self) -> proto::QueryRequest {
+        proto::QueryRequest {
             partition_key: self.partition_key,
             sort_key_condition: self.sort_key_condition,
-            filter_expression: None,
-            expression_values: std::collections::HashMap::new(),
+            filter_expression: self.filter_expression,
+            expression_values: self
+                .expression_values
+                .iter()
+                .map(|(k, v)| (k.clone(), ks_value_to_proto(v)))
+                .collect(),
             index_name: self.index_name,
             limit: self.limit,
             exclusive_start_key: self.exclusive_start_key,
             scan_forward: self.scan_forward,
-        };
+            fetch_full_items: self.fetch_full_items,
+            expression_names: self.expression_names,
+            select: self.select as i32,
+            return_consumed_capacity: self.return_consumed_capacity,
+            sum_attribute: self.sum_attribute,
+        }
+    }
+}
 
-        let response = client
-            .query(request)
-            .await?
-            .into_inner();
-
-        // Convert protobuf response to Rust types
-        let items: Vec<Item> = response
-            .items
-            .into_iter()
-            .map(|proto_item| proto_item_to_ks(proto_item).expect("Invalid item from server"))
-            .collect();
-
-        let last_key = response.last_evaluated_key.map(|key| {
-            let (pk, sk) = proto_last_key_to_ks(key);
-            (pk, sk)
-        });
+/// Send a query request and convert the response
+async fn send(
+    client: &mut KeystoneDbClient<Transport>,
+    request: proto::QueryRequest,
+) -> Result<RemoteQueryResponse> {
+    let response = client
+        .query(request)
+        .await?
+        .into_inner();
 
-        Ok(RemoteQueryResponse {
-            items,
-            count: response.count as usize,
-            scanned_count: response.scanned_count as usize,
-            last_key,
+    // Convert protobuf response to Rust types
+    let items: Vec<Item> = response
+        .items
+        .into_iter()
+        .map(|proto_item| {
+            proto_item_to_ks(proto_item).map_err(|e| ClientError::InvalidResponse(e.message().to_string()))
         })
-    }
+        .collect::<Result<_>>()?;
+
+    let last_key = response.last_evaluated_key.map(|key| {
+        let (pk, sk) = proto_last_key_to_ks(key);
+        (pk, sk)
+    });
+
+    Ok(RemoteQueryResponse {
+        items,
+        count: response.count as usize,
+        scanned_count: response.scanned_count as usize,
+        last_key,
+        sum: response.sum.map(proto_value_to_ks).transpose()?,
+        consumed_capacity: response.consumed_capacity.map(proto_consumed_capacity_to_ks),
+    })
 }
 
 /// Query response
@@ -181,6 +351,32 @@ pub struct RemoteQueryResponse {
     pub last_key: Option<(Bytes, Option<Bytes>)>,
     /// Number of items examined
     pub scanned_count: usize,
+    /// Sum of the attribute requested with `sum` over the matching items
+    pub sum: Option<Value>,
+    /// Capacity consumed, if requested with `return_consumed_capacity`
+    pub consumed_capacity: Option<ConsumedCapacity>,
+}
+
+impl RemoteQueryResponse {
+    /// Whether the query stopped early and more items may follow
+    ///
+    /// Pass `last_key` to `RemoteQuery::start_after` to fetch the next page.
+    /// `count` below `scanned_count` only means the filter dropped items.
+    pub fn has_more(&self) -> bool {
+        self.last_key.is_some()
+    }
+
+    /// Decode the items into `T`, in order
+    ///
+    /// Attributes map to fields by name, with numbers converted to the
+    /// field's numeric type (see `kstone_core::decode`). Fails with
+    /// `InvalidArgument` if an item doesn't fit.
+    pub fn decode<T: DeserializeOwned>(&self) -> Result<Vec<T>> {
+        self.items
+            .iter()
+            .map(|item| kstone_core::from_item(item).map_err(|e| ClientError::InvalidArgument(e.to_string())))
+            .collect()
+    }
 }
 
 /// Helper function to convert bytes to protobuf Value (for sort key conditions)
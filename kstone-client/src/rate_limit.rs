@@ -0,0 +1,113 @@
+/// Pacing for bulk helpers
+///
+/// `Client::with_bulk_rate_limit` caps how many items per second the bulk
+/// helpers (`import_from` and `parallel_scan`) push through the server, so
+/// an ingestion or backfill job does not crowd out other users of a shared
+/// server. Single calls such as `put` or `scan` are never paced.
+///
+/// The limit is a token bucket holding at most one item's worth of burst.
+/// Each request takes tokens for the items it carries, running the bucket
+/// into debt if needed, and then waits until the debt is paid off at the
+/// configured rate. Clones of a client share its bucket.
+
+use crate::error::{ClientError, Result};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+
+/// Token bucket shared by a client and its clones
+pub(crate) struct RateLimiter {
+    /// Items per second
+    rate: f64,
+    bucket: Mutex<Bucket>,
+}
+
+struct Bucket {
+    /// Available items; negative while requests are waiting off a debt
+    tokens: f64,
+    refilled: Instant,
+}
+
+/// Items that may be sent at once after the limiter has been idle
+const BURST: f64 = 1.0;
+
+impl RateLimiter {
+    pub(crate) fn new(ops_per_second: f64) -> Result<Arc<Self>> {
+        if !ops_per_second.is_finite() || ops_per_second <= 0.0 {
+            return Err(ClientError::InvalidArgument(format!(
+                "rate limit must be a positive number of operations per second, got {}",
+                ops_per_second
+            )));
+        }
+        Ok(Arc::new(Self {
+            rate: ops_per_second,
+            bucket: Mutex::new(Bucket {
+                tokens: BURST,
+                refilled: Instant::now(),
+            }),
+        }))
+    }
+
+    /// Wait until `items` more items may be sent
+    pub(crate) async fn acquire(&self, items: usize) {
+        let wait = self.reserve(items, Instant::now());
+        if !wait.is_zero() {
+            tokio::time::sleep(wait).await;
+        }
+    }
+
+    /// Take tokens for `items` and return how long the caller must wait
+    fn reserve(&self, items: usize, now: Instant) -> Duration {
+        let mut bucket = self.bucket.lock().unwrap();
+        let elapsed = now.saturating_duration_since(bucket.refilled).as_secs_f64();
+        bucket.tokens = (bucket.tokens + elapsed * self.rate).min(BURST);
+        bucket.refilled = now;
+
+        bucket.tokens -= items as f64;
+        if bucket.tokens >= 0.0 {
+            Duration::ZERO
+        } else {
+            Duration::from_secs_f64(-bucket.tokens / self.rate)
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn millis(wait: Duration) -> u64 {
+        (wait.as_secs_f64() * 1000.0).round() as u64
+    }
+
+    #[test]
+    fn test_rejects_invalid_rate() {
+        assert!(RateLimiter::new(0.0).is_err());
+        assert!(RateLimiter::new(-5.0).is_err());
+        assert!(RateLimiter::new(f64::NAN).is_err());
+        assert!(RateLimiter::new(0.5).is_ok());
+    }
+
+    #[test]
+    fn test_reservations_queue_up() {
+        let limiter = RateLimiter::new(10.0).unwrap();
+        let start = Instant::now();
+
+        // The burst covers one item; the next waits a tenth of a second
+        assert_eq!(millis(limiter.reserve(1, start)), 0);
+        assert_eq!(millis(limiter.reserve(1, start)), 100);
+
+        // A batch waits for all of its items, behind earlier reservations
+        assert_eq!(millis(limiter.reserve(5, start)), 600);
+
+        // Time passing pays the debt off
+        assert_eq!(millis(limiter.reserve(1, start + Duration::from_millis(600))), 100);
+    }
+
+    #[test]
+    fn test_idle_time_does_not_build_a_burst() {
+        let limiter = RateLimiter::new(10.0).unwrap();
+        let later = Instant::now() + Duration::from_secs(60);
+        assert_eq!(millis(limiter.reserve(1, later)), 0);
+        assert_eq!(millis(limiter.reserve(2, later)), 200);
+    }
+}
@@ -0,0 +1,318 @@
+/// Client-side read cache
+///
+/// With `Client::with_read_cache`, `get` and `get_with_sk` answer from a
+/// local cache for up to `ReadCacheConfig::ttl` after an item was read from
+/// the server, and `batch_get` does when every key it asks for is cached.
+/// Writes through the same client, or its clones, drop the partitions they
+/// touch once they finish; writes made by anyone else are only seen after
+/// the entries expire, so keep the TTL short.
+///
+/// Missing items are not cached unless `cache_not_found` is set, since a
+/// cached miss would hide an item created elsewhere until it expired.
+
+use kstone_core::Item;
+use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+
+/// How long an entry is served by default
+pub const DEFAULT_READ_CACHE_TTL: Duration = Duration::from_secs(1);
+
+/// Entries kept by default
+pub const DEFAULT_READ_CACHE_CAPACITY: usize = 10_000;
+
+/// Read cache settings
+#[derive(Debug, Clone)]
+pub struct ReadCacheConfig {
+    /// How long an entry is served after it was read
+    pub ttl: Duration,
+    /// Most entries kept; expired entries, then arbitrary ones, are evicted
+    pub max_entries: usize,
+    /// Also cache that an item does not exist
+    pub cache_not_found: bool,
+}
+
+impl Default for ReadCacheConfig {
+    fn default() -> Self {
+        Self {
+            ttl: DEFAULT_READ_CACHE_TTL,
+            max_entries: DEFAULT_READ_CACHE_CAPACITY,
+            cache_not_found: false,
+        }
+    }
+}
+
+/// Read cache counters
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct ReadCacheStats {
+    /// Reads answered from the cache
+    pub hits: u64,
+    /// Reads that went to the server
+    pub misses: u64,
+    /// Entries currently held, including expired ones not yet evicted
+    pub entries: usize,
+}
+
+struct Entry {
+    item: Option<Item>,
+    expires: Instant,
+}
+
+/// Cached items by partition key, then sort key
+#[derive(Default)]
+struct Entries {
+    partitions: HashMap<Vec<u8>, HashMap<Option<Vec<u8>>, Entry>>,
+    len: usize,
+}
+
+impl Entries {
+    fn evict_expired(&mut self, now: Instant) {
+        let mut removed = 0;
+        self.partitions.retain(|_, items| {
+            let before = items.len();
+            items.retain(|_, entry| entry.expires > now);
+            removed += before - items.len();
+            !items.is_empty()
+        });
+        self.len -= removed;
+    }
+
+    fn evict_any(&mut self) {
+        let Some(pk) = self.partitions.keys().next().cloned() else {
+            return;
+        };
+        if let Some(items) = self.partitions.remove(&pk) {
+            self.len -= items.len();
+        }
+    }
+}
+
+/// Cache shared by a client and its clones
+pub(crate) struct ReadCache {
+    config: ReadCacheConfig,
+    entries: Mutex<Entries>,
+    /// Bumped by every invalidation, so a read that overlapped a write
+    /// doesn't store what it read
+    generation: AtomicU64,
+    hits: AtomicU64,
+    misses: AtomicU64,
+}
+
+impl ReadCache {
+    pub(crate) fn new(config: ReadCacheConfig) -> Arc<Self> {
+        Arc::new(Self {
+            config,
+            entries: Mutex::new(Entries::default()),
+            generation: AtomicU64::new(0),
+            hits: AtomicU64::new(0),
+            misses: AtomicU64::new(0),
+        })
+    }
+
+    /// The cached item for a key, if fresh: `Some(None)` is a cached miss
+    pub(crate) fn lookup(&self, pk: &[u8], sk: Option<&[u8]>) -> Option<Option<Item>> {
+        let hit = self.peek(pk, sk);
+        let counter = if hit.is_some() { &self.hits } else { &self.misses };
+        counter.fetch_add(1, Ordering::Relaxed);
+        hit
+    }
+
+    /// Cached items for every key, or None if any is missing or stale
+    pub(crate) fn lookup_all<'a>(
+        &self,
+        keys: impl IntoIterator<Item = (&'a [u8], Option<&'a [u8]>)>,
+    ) -> Option<Vec<Option<Item>>> {
+        let hits: Option<Vec<_>> = keys.into_iter().map(|(pk, sk)| self.peek(pk, sk)).collect();
+        let counter = if hits.is_some() { &self.hits } else { &self.misses };
+        counter.fetch_add(1, Ordering::Relaxed);
+        hits
+    }
+
+    fn peek(&self, pk: &[u8], sk: Option<&[u8]>) -> Option<Option<Item>> {
+        let entries = self.entries.lock().unwrap();
+        let entry = entries.partitions.get(pk)?.get(&sk.map(<[u8]>::to_vec))?;
+        (entry.expires > Instant::now()).then(|| entry.item.clone())
+    }
+
+    /// Current generation, taken before reading from the server
+    pub(crate) fn generation(&self) -> u64 {
+        self.generation.load(Ordering::Acquire)
+    }
+
+    /// Cache an item read from the server, unless a write through this
+    /// client finished since `generation` was taken
+    pub(crate) fn store(&self, pk: &[u8], sk: Option<&[u8]>, item: &Option<Item>, generation: u64) {
+        if self.config.max_entries == 0 || (item.is_none() && !self.config.cache_not_found) {
+            return;
+        }
+
+        let mut entries = self.entries.lock().unwrap();
+        if self.generation() != generation {
+            return;
+        }
+        let now = Instant::now();
+        if entries.len >= self.config.max_entries {
+            entries.evict_expired(now);
+        }
+        while entries.len >= self.config.max_entries {
+            entries.evict_any();
+        }
+
+        let entry = Entry {
+            item: item.clone(),
+            expires: now + self.config.ttl,
+        };
+        let previous = entries
+            .partitions
+            .entry(pk.to_vec())
+            .or_default()
+            .insert(sk.map(<[u8]>::to_vec), entry);
+        if previous.is_none() {
+            entries.len += 1;
+        }
+    }
+
+    /// Drop every cached item in these partitions
+    pub(crate) fn invalidate<'a>(&self, partition_keys: impl IntoIterator<Item = &'a [u8]>) {
+        let mut entries = self.entries.lock().unwrap();
+        self.generation.fetch_add(1, Ordering::AcqRel);
+        for pk in partition_keys {
+            if let Some(items) = entries.partitions.remove(pk) {
+                entries.len -= items.len();
+            }
+        }
+    }
+
+    /// Drop everything
+    pub(crate) fn clear(&self) {
+        let mut entries = self.entries.lock().unwrap();
+        self.generation.fetch_add(1, Ordering::AcqRel);
+        *entries = Entries::default();
+    }
+
+    pub(crate) fn stats(&self) -> ReadCacheStats {
+        ReadCacheStats {
+            hits: self.hits.load(Ordering::Relaxed),
+            misses: self.misses.load(Ordering::Relaxed),
+            entries: self.entries.lock().unwrap().len,
+        }
+    }
+}
+
+/// Invalidates partitions, or the whole cache, when dropped at the end of a
+/// write, whether it succeeded, failed or was cancelled
+pub(crate) struct Invalidation {
+    cache: Arc<ReadCache>,
+    partitions: Option<Vec<Vec<u8>>>,
+}
+
+impl Invalidation {
+    /// Invalidate `partition_keys` when dropped
+    pub(crate) fn partitions<'a>(
+        cache: &Arc<ReadCache>,
+        partition_keys: impl IntoIterator<Item = &'a [u8]>,
+    ) -> Self {
+        Self {
+            cache: Arc::clone(cache),
+            partitions: Some(partition_keys.into_iter().map(<[u8]>::to_vec).collect()),
+        }
+    }
+
+    /// Clear the cache when dropped, for writes that may touch any key
+    pub(crate) fn all(cache: &Arc<ReadCache>) -> Self {
+        Self {
+            cache: Arc::clone(cache),
+            partitions: None,
+        }
+    }
+}
+
+impl Drop for Invalidation {
+    fn drop(&mut self) {
+        match &self.partitions {
+            Some(partitions) => self.cache.invalidate(partitions.iter().map(Vec::as_slice)),
+            None => self.cache.clear(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use kstone_core::Value;
+
+    fn item(name: &str) -> Option<Item> {
+        let mut item = Item::new();
+        item.insert("name".to_string(), Value::S(name.to_string()));
+        Some(item)
+    }
+
+    fn config(ttl: Duration) -> ReadCacheConfig {
+        ReadCacheConfig {
+            ttl,
+            ..ReadCacheConfig::default()
+        }
+    }
+
+    #[test]
+    fn test_hit_until_expiry() {
+        let cache = ReadCache::new(config(Duration::from_millis(50)));
+        assert_eq!(cache.lookup(b"pk", None), None);
+
+        cache.store(b"pk", None, &item("a"), cache.generation());
+        assert_eq!(cache.lookup(b"pk", None), Some(item("a")));
+        assert_eq!(cache.lookup(b"pk", Some(b"sk")), None);
+
+        std::thread::sleep(Duration::from_millis(60));
+        assert_eq!(cache.lookup(b"pk", None), None);
+        assert_eq!(cache.stats().hits, 1);
+        assert_eq!(cache.stats().misses, 3);
+    }
+
+    #[test]
+    fn test_misses_cached_only_when_enabled() {
+        let cache = ReadCache::new(ReadCacheConfig::default());
+        cache.store(b"pk", None, &None, cache.generation());
+        assert_eq!(cache.lookup(b"pk", None), None);
+
+        let cache = ReadCache::new(ReadCacheConfig {
+            cache_not_found: true,
+            ..ReadCacheConfig::default()
+        });
+        cache.store(b"pk", None, &None, cache.generation());
+        assert_eq!(cache.lookup(b"pk", None), Some(None));
+    }
+
+    #[test]
+    fn test_invalidation_drops_partition_and_stale_reads() {
+        let cache = ReadCache::new(ReadCacheConfig::default());
+        cache.store(b"a", Some(b"1"), &item("a1"), cache.generation());
+        cache.store(b"b", None, &item("b"), cache.generation());
+
+        // A read that started before the write must not be stored after it
+        let generation = cache.generation();
+        drop(Invalidation::partitions(&cache, [b"a".as_slice()]));
+        cache.store(b"a", Some(b"2"), &item("a2"), generation);
+
+        assert_eq!(cache.lookup(b"a", Some(b"1")), None);
+        assert_eq!(cache.lookup(b"a", Some(b"2")), None);
+        assert_eq!(cache.lookup(b"b", None), Some(item("b")));
+
+        drop(Invalidation::all(&cache));
+        assert_eq!(cache.stats().entries, 0);
+    }
+
+    #[test]
+    fn test_capacity_bounded() {
+        let cache = ReadCache::new(ReadCacheConfig {
+            max_entries: 2,
+            ..ReadCacheConfig::default()
+        });
+        for pk in [b"a", b"b", b"c"] {
+            cache.store(pk, None, &item("x"), cache.generation());
+        }
+        assert_eq!(cache.stats().entries, 2);
+        assert_eq!(cache.lookup(b"c", None), Some(item("x")));
+    }
+}
@@ -0,0 +1,65 @@
+/// Request IDs carried from the caller's context to the server
+///
+/// Services that tag each incoming request with an ID can have it sent
+/// along with every KeystoneDB call made on the request's behalf, so server
+/// logs line up with the service's traces. Run the work inside
+/// `with_request_id` and every call made in it, by any client, carries the
+/// ID in the `x-request-id` header. Where the ID lives elsewhere (a tracing
+/// span, another task-local), `Client::with_request_id_from` reads it from
+/// there instead.
+
+use std::fmt;
+use std::future::Future;
+use std::sync::Arc;
+
+/// Header the request ID is sent in
+pub const REQUEST_ID_HEADER: &str = "x-request-id";
+
+tokio::task_local! {
+    static REQUEST_ID: String;
+}
+
+/// Run `future` with `id` as the current request ID
+///
+/// # Example
+/// ```no_run
+/// # use kstone_client::{request_id, Client};
+/// # async fn example(mut client: Client) -> Result<(), Box<dyn std::error::Error>> {
+/// let item = request_id::with_request_id("req-8f3a", async move { client.get(b"user#1").await }).await?;
+/// # Ok(())
+/// # }
+/// ```
+pub async fn with_request_id<F: Future>(id: impl Into<String>, future: F) -> F::Output {
+    REQUEST_ID.scope(id.into(), future).await
+}
+
+/// The request ID set by the enclosing `with_request_id`, if any
+pub fn current_request_id() -> Option<String> {
+    REQUEST_ID.try_with(|id| id.clone()).ok()
+}
+
+/// Where a client looks up the request ID of each call
+#[derive(Clone)]
+pub(crate) struct RequestIdSource(Arc<dyn Fn() -> Option<String> + Send + Sync>);
+
+impl RequestIdSource {
+    pub(crate) fn new(source: impl Fn() -> Option<String> + Send + Sync + 'static) -> Self {
+        Self(Arc::new(source))
+    }
+
+    pub(crate) fn current(&self) -> Option<String> {
+        (self.0)()
+    }
+}
+
+impl Default for RequestIdSource {
+    fn default() -> Self {
+        Self::new(current_request_id)
+    }
+}
+
+impl fmt::Debug for RequestIdSource {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("RequestIdSource")
+    }
+}
@@ -1,19 +1,32 @@
 /// Remote scan builder and response types
 use crate::convert::*;
-use crate::error::Result;
+use crate::error::{ClientError, Result};
+use crate::fallback::LocalFilter;
 use bytes::Bytes;
-use kstone_core::Item;
+use kstone_core::{aggregate, ConsumedCapacity, Item, Value};
 use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
-use tonic::transport::Channel;
-use tonic::Streaming;
+use std::collections::HashMap;
+use crate::metadata::Transport;
+use crate::validate;
+use std::time::Duration;
+use tokio::sync::mpsc;
+use tokio::time::Instant;
+use tonic::{Request, Streaming};
 
 /// Remote scan builder
+#[derive(Clone)]
 pub struct RemoteScan {
     limit: Option<u32>,
     exclusive_start_key: Option<proto::LastKey>,
     index_name: Option<String>,
     segment: Option<u32>,
     total_segments: Option<u32>,
+    filter_expression: Option<String>,
+    expression_values: HashMap<String, Value>,
+    expression_names: HashMap<String, String>,
+    select: proto::Select,
+    sum_attribute: Option<String>,
+    return_consumed_capacity: bool,
 }
 
 impl RemoteScan {
@@ -25,6 +38,12 @@ impl RemoteScan {
             index_name: None,
             segment: None,
             total_segments: None,
+            filter_expression: None,
+            expression_values: HashMap::new(),
+            expression_names: HashMap::new(),
+            select: proto::Select::AllAttributes,
+            sum_attribute: None,
+            return_consumed_capacity: false,
         }
     }
 
@@ -56,60 +75,193 @@ impl RemoteScan {
         self
     }
 
+    /// Only return items matching a filter expression
+    pub fn filter(mut self, expression: impl Into<String>) -> Self {
+        self.filter_expression = Some(expression.into());
+        self
+    }
+
+    /// Set the filter from a `cond` builder
+    ///
+    /// Replaces any previous filter; the compiled names and values are
+    /// merged into the request's maps.
+    pub fn filter_expr(mut self, filter: crate::cond::Cond) -> Self {
+        let compiled = filter.compile();
+        self.filter_expression = Some(compiled.expression);
+        self.expression_names.extend(compiled.names);
+        self.expression_values.extend(compiled.values);
+        self
+    }
+
+    /// Filter expression, if set
+    pub fn filter_expression(&self) -> Option<&str> {
+        self.filter_expression.as_deref()
+    }
+
+    /// Expression attribute names bound to this request
+    pub fn expression_names(&self) -> &HashMap<String, String> {
+        &self.expression_names
+    }
+
+    /// Expression attribute values bound to this request
+    pub fn expression_values(&self) -> &HashMap<String, Value> {
+        &self.expression_values
+    }
+
+    /// Bind an expression attribute value used by the filter
+    pub fn value(mut self, placeholder: impl Into<String>, value: Value) -> Self {
+        self.expression_values.insert(placeholder.into(), value);
+        self
+    }
+
+    /// Bind an expression attribute name used by the filter
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.expression_names.insert(placeholder.into(), name.into());
+        self
+    }
+
+    /// Page size, if set
+    pub(crate) fn page_limit(&self) -> Option<u32> {
+        self.limit
+    }
+
+    /// (segment, total_segments), if this is one segment of a parallel scan
+    pub(crate) fn segment_info(&self) -> Option<(u32, u32)> {
+        self.segment.zip(self.total_segments)
+    }
+
+    /// Return only the number of matching items; no items are transferred
+    ///
+    /// Unless the server rejects the filter: the client then runs the
+    /// scan unfiltered and counts on its side (see `execute`), which
+    /// transfers every item examined.
+    pub fn select_count(mut self) -> Self {
+        self.select = proto::Select::Count;
+        self
+    }
+
+    /// Also return the sum of a numeric attribute over the matching items
+    ///
+    /// The server adds up the values, so with `select_count` no items are
+    /// transferred, except when the filter falls back to the client as for
+    /// `select_count`. Items without the attribute or with a non-numeric
+    /// value there are skipped.
+    pub fn sum(mut self, attribute: impl Into<String>) -> Self {
+        self.sum_attribute = Some(attribute.into());
+        self
+    }
+
+    /// Report the capacity the scan consumed in the response
+    pub fn return_consumed_capacity(mut self, enabled: bool) -> Self {
+        self.return_consumed_capacity = enabled;
+        self
+    }
+
+    /// Check the scan locally before it is sent
+    ///
+    /// Rejects a limit of zero and a segment outside `0..total_segments`.
+    pub fn validate(&self) -> Result<()> {
+        validate::limit(self.limit)?;
+        if let Some((segment, total_segments)) = self.segment_info() {
+            if segment >= total_segments {
+                return Err(ClientError::InvalidArgument(format!(
+                    "segment {} must be less than total_segments {}",
+                    segment, total_segments
+                )));
+            }
+        }
+        Ok(())
+    }
+
+    fn into_request(self) -> proto::ScanRequest {
+        proto::ScanRequest {
+            filter_expression: self.filter_expression,
+            expression_values: self
+                .expression_values
+                .iter()
+                .map(|(k, v)| (k.clone(), ks_value_to_proto(v)))
+                .collect(),
+            limit: self.limit,
+            exclusive_start_key: self.exclusive_start_key,
+            index_name: self.index_name,
+            segment: self.segment,
+            total_segments: self.total_segments,
+            expression_names: self.expression_names,
+            select: self.select as i32,
+            return_consumed_capacity: self.return_consumed_capacity,
+            sum_attribute: self.sum_attribute,
+        }
+    }
+
+    /// Start the scan and return the response stream
+    ///
+    /// A `timeout` is sent to the server as the call's gRPC deadline.
+    pub(crate) async fn open(
+        self,
+        client: &mut KeystoneDbClient<Transport>,
+        timeout: Option<Duration>,
+    ) -> Result<Streaming<proto::ScanResponse>> {
+        self.validate()?;
+        let mut request = Request::new(self.into_request());
+        if let Some(timeout) = timeout {
+            request.set_timeout(timeout);
+        }
+        Ok(client.scan(request).await?.into_inner())
+    }
+
     /// Execute the scan and get a stream of responses
     ///
     /// Note: The server currently returns a single response, but this
     /// interface is prepared for future streaming support.
+    ///
+    /// If the server rejects the filter and the client can apply it, the
+    /// scan runs unfiltered and is filtered here (see `fallback`).
     pub async fn execute(
         self,
-        client: &mut KeystoneDbClient<Channel>,
+        client: &mut KeystoneDbClient<Transport>,
     ) -> Result<RemoteScanResponse> {
-        let request = proto::ScanRequest {
-            filter_expression: None,
-            expression_values: std::collections::HashMap::new(),
-            limit: self.limit,
-            exclusive_start_key: self.exclusive_start_key,
-            index_name: self.index_name,
-            segment: self.segment,
-            total_segments: self.total_segments,
+        let error = match collect(self.clone().open(client, None).await).await {
+            Ok(response) => return Ok(response),
+            Err(e) => e,
+        };
+        let Some(filter) = LocalFilter::for_rejected(
+            &error,
+            self.filter_expression(),
+            self.expression_names(),
+            self.expression_values(),
+        ) else {
+            return Err(error);
         };
 
-        let mut stream: Streaming<proto::ScanResponse> = client
-            .scan(request)
-            .await?
-            .into_inner();
-
-        // Collect all items from the stream
-        let mut all_items = Vec::new();
-        let mut total_count = 0;
-        let mut total_scanned_count = 0;
-        let mut last_key = None;
-
-        while let Some(response) = stream.message().await? {
-            let items: Vec<Item> = response
-                .items
-                .into_iter()
-                .map(|proto_item| proto_item_to_ks(proto_item).expect("Invalid item from server"))
-                .collect();
-
-            total_count += response.count as usize;
-            total_scanned_count += response.scanned_count as usize;
-
-            if let Some(key) = response.last_evaluated_key {
-                let (pk, sk) = proto_last_key_to_ks(key);
-                last_key = Some((pk, sk));
-            }
-
-            all_items.extend(items);
+        let count_only = self.select == proto::Select::Count;
+        let sum_attribute = self.sum_attribute.clone();
+        let unfiltered = Self {
+            filter_expression: None,
+            expression_names: HashMap::new(),
+            expression_values: HashMap::new(),
+            select: proto::Select::AllAttributes,
+            sum_attribute: None,
+            ..self
+        };
+        let mut response = collect(unfiltered.open(client, None).await).await?;
+        response.items = filter.retain(response.items)?;
+        response.count = response.items.len();
+        response.sum = sum_attribute.map(|attribute| aggregate::sum(&response.items, &attribute));
+        if count_only {
+            response.items.clear();
         }
+        Ok(response)
+    }
+}
 
-        Ok(RemoteScanResponse {
-            items: all_items,
-            count: total_count,
-            scanned_count: total_scanned_count,
-            last_key,
-        })
+/// Collect all items from an opened scan stream
+async fn collect(stream: Result<Streaming<proto::ScanResponse>>) -> Result<RemoteScanResponse> {
+    let mut stream = stream?;
+    let mut collected = ScanCollector::default();
+    while let Some(response) = stream.message().await? {
+        collected.add(response)?;
     }
+    Ok(collected.finish())
 }
 
 impl Default for RemoteScan {
@@ -119,6 +271,7 @@ impl Default for RemoteScan {
 }
 
 /// Scan response
+#[derive(Debug)]
 pub struct RemoteScanResponse {
     /// Items found
     pub items: Vec<Item>,
@@ -128,4 +281,206 @@ pub struct RemoteScanResponse {
     pub last_key: Option<(Bytes, Option<Bytes>)>,
     /// Number of items examined
     pub scanned_count: usize,
+    /// Sum of the attribute requested with `sum` over the matching items
+    pub sum: Option<Value>,
+    /// Capacity consumed, if requested with `return_consumed_capacity`
+    pub consumed_capacity: Option<ConsumedCapacity>,
+}
+
+impl RemoteScanResponse {
+    /// Whether the scan stopped early and more items may follow
+    pub fn has_more(&self) -> bool {
+        self.last_key.is_some()
+    }
+}
+
+/// Totals of the scan chunks received so far
+#[derive(Default)]
+pub(crate) struct ScanCollector {
+    items: Vec<Item>,
+    count: usize,
+    scanned_count: usize,
+    last_key: Option<(Bytes, Option<Bytes>)>,
+    sum: Option<f64>,
+    consumed_capacity: Option<ConsumedCapacity>,
+}
+
+impl ScanCollector {
+    pub(crate) fn add(&mut self, response: proto::ScanResponse) -> Result<()> {
+        for proto_item in response.items {
+            self.items.push(proto_item_to_ks(proto_item)?);
+        }
+        self.count += response.count as usize;
+        self.scanned_count += response.scanned_count as usize;
+        if let Some(key) = response.last_evaluated_key {
+            self.last_key = Some(proto_last_key_to_ks(key));
+        }
+        if let Some(sum) = response.sum {
+            let sum = proto_value_to_ks(sum)?.as_number().unwrap_or(0.0);
+            *self.sum.get_or_insert(0.0) += sum;
+        }
+        if let Some(capacity) = response.consumed_capacity {
+            let total = self.consumed_capacity.get_or_insert_with(ConsumedCapacity::default);
+            total.read_units += capacity.read_units;
+            total.write_units += capacity.write_units;
+        }
+        Ok(())
+    }
+
+    pub(crate) fn finish(self) -> RemoteScanResponse {
+        RemoteScanResponse {
+            items: self.items,
+            count: self.count,
+            scanned_count: self.scanned_count,
+            last_key: self.last_key,
+            sum: self.sum.map(Value::number),
+            consumed_capacity: self.consumed_capacity,
+        }
+    }
+
+    /// The items so far, returned with the deadline error that cut the scan short
+    pub(crate) fn partial(self, source: ClientError) -> ClientError {
+        ClientError::PartialResult {
+            response: Box::new(self.finish()),
+            source: Box::new(source),
+        }
+    }
+}
+
+/// Collect chunks until the stream ends or `deadline` passes
+///
+/// If the deadline passes first, locally or on the server, the items
+/// received so far are returned in `ClientError::PartialResult`.
+pub(crate) async fn collect_until(mut chunks: impl ScanChunks, deadline: Instant) -> Result<RemoteScanResponse> {
+    let mut collected = ScanCollector::default();
+    loop {
+        match tokio::time::timeout_at(deadline, chunks.next_chunk()).await {
+            Ok(Ok(Some(response))) => collected.add(response)?,
+            Ok(Ok(None)) => return Ok(collected.finish()),
+            Ok(Err(ClientError::Timeout(msg))) => return Err(collected.partial(ClientError::Timeout(msg))),
+            Ok(Err(e)) => return Err(e),
+            Err(_) => {
+                return Err(collected.partial(ClientError::Timeout("Scan deadline exceeded".to_string())))
+            }
+        }
+    }
+}
+
+/// Source of scan response chunks
+pub(crate) trait ScanChunks {
+    /// Next chunk, or None once the scan is complete
+    async fn next_chunk(&mut self) -> Result<Option<proto::ScanResponse>>;
+}
+
+impl ScanChunks for Streaming<proto::ScanResponse> {
+    async fn next_chunk(&mut self) -> Result<Option<proto::ScanResponse>> {
+        Ok(self.message().await?)
+    }
+}
+
+/// Send the items of each chunk to `items` as soon as the chunk arrives
+///
+/// Stops early, without error, if the receiver has been dropped.
+pub(crate) async fn forward_items(mut chunks: impl ScanChunks, items: &mpsc::Sender<Item>) -> Result<()> {
+    while let Some(response) = chunks.next_chunk().await? {
+        for proto_item in response.items {
+            if items.send(proto_item_to_ks(proto_item)?).await.is_err() {
+                return Ok(());
+            }
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Chunks handed over one at a time by the test
+    struct MockChunks(mpsc::Receiver<proto::ScanResponse>);
+
+    impl ScanChunks for MockChunks {
+        async fn next_chunk(&mut self) -> Result<Option<proto::ScanResponse>> {
+            Ok(self.0.recv().await)
+        }
+    }
+
+    fn chunk(names: &[&str]) -> proto::ScanResponse {
+        let mut item = HashMap::new();
+        proto::ScanResponse {
+            items: names
+                .iter()
+                .map(|name| {
+                    item.insert("name".to_string(), Value::string(*name));
+                    ks_item_to_proto(&item)
+                })
+                .collect(),
+            count: names.len() as u32,
+            scanned_count: names.len() as u32,
+            last_evaluated_key: None,
+            error: None,
+            consumed_capacity: None,
+            sum: None,
+        }
+    }
+
+    #[tokio::test]
+    async fn test_items_forwarded_per_chunk() {
+        let (chunk_tx, chunk_rx) = mpsc::channel(1);
+        let (item_tx, mut item_rx) = mpsc::channel(16);
+        let forward = tokio::spawn(async move { forward_items(MockChunks(chunk_rx), &item_tx).await });
+
+        // First chunk's items arrive while the scan is still open
+        chunk_tx.send(chunk(&["a", "b"])).await.unwrap();
+        assert_eq!(item_rx.recv().await.unwrap().get("name"), Some(&Value::string("a")));
+        assert_eq!(item_rx.recv().await.unwrap().get("name"), Some(&Value::string("b")));
+        assert!(item_rx.try_recv().is_err());
+
+        chunk_tx.send(chunk(&["c"])).await.unwrap();
+        assert_eq!(item_rx.recv().await.unwrap().get("name"), Some(&Value::string("c")));
+
+        drop(chunk_tx);
+        forward.await.unwrap().unwrap();
+        assert!(item_rx.recv().await.is_none());
+    }
+
+    #[tokio::test]
+    async fn test_deadline_returns_partial_result() {
+        let (chunk_tx, chunk_rx) = mpsc::channel(4);
+        chunk_tx.send(chunk(&["a", "b"])).await.unwrap();
+
+        // The second chunk never arrives before the deadline
+        let deadline = Instant::now() + Duration::from_millis(50);
+        match collect_until(MockChunks(chunk_rx), deadline).await {
+            Err(ClientError::PartialResult { response, source }) => {
+                assert_eq!(response.items.len(), 2);
+                assert_eq!(response.count, 2);
+                assert!(matches!(*source, ClientError::Timeout(_)));
+            }
+            other => panic!("expected partial result, got {:?}", other),
+        }
+        drop(chunk_tx);
+    }
+
+    #[tokio::test]
+    async fn test_chunk_sums_are_added() {
+        let (chunk_tx, chunk_rx) = mpsc::channel(4);
+        let mut first = chunk(&["a", "b"]);
+        first.sum = Some(ks_value_to_proto(&Value::number(7)));
+        let mut second = chunk(&["c"]);
+        second.sum = Some(ks_value_to_proto(&Value::number(0.5)));
+        chunk_tx.send(first).await.unwrap();
+        chunk_tx.send(second).await.unwrap();
+        drop(chunk_tx);
+
+        let deadline = Instant::now() + Duration::from_secs(5);
+        let response = collect_until(MockChunks(chunk_rx), deadline).await.unwrap();
+        assert_eq!(response.count, 3);
+        assert_eq!(response.sum, Some(Value::number(7.5)));
+
+        let (chunk_tx, chunk_rx) = mpsc::channel(1);
+        chunk_tx.send(chunk(&["a"])).await.unwrap();
+        drop(chunk_tx);
+        assert_eq!(collect_until(MockChunks(chunk_rx), deadline).await.unwrap().sum, None);
+    }
 }
@@ -0,0 +1,59 @@
+/// Tenant isolation for multi-tenant services
+///
+/// A client with a tenant guard only sends requests whose partition keys
+/// begin with the tenant's prefix. Anything else fails locally with
+/// `ClientError::PermissionDenied` and never reaches the server. Scans and
+/// PartiQL statements are not restricted to known partitions, so they are
+/// rejected outright.
+
+use crate::error::{ClientError, Result};
+
+/// Partition key prefix a client is confined to
+#[derive(Debug, Clone)]
+pub(crate) struct TenantGuard {
+    prefix: Vec<u8>,
+}
+
+impl TenantGuard {
+    pub(crate) fn new(prefix: impl Into<Vec<u8>>) -> Self {
+        Self { prefix: prefix.into() }
+    }
+
+    /// Check that every partition key belongs to the tenant
+    pub(crate) fn check<'a>(&self, partition_keys: impl IntoIterator<Item = &'a [u8]>) -> Result<()> {
+        for pk in partition_keys {
+            if !pk.starts_with(&self.prefix) {
+                return Err(ClientError::PermissionDenied(format!(
+                    "Partition key '{}' is outside tenant prefix '{}'",
+                    String::from_utf8_lossy(pk),
+                    String::from_utf8_lossy(&self.prefix)
+                )));
+            }
+        }
+        Ok(())
+    }
+
+    /// Reject an operation that cannot be confined to the tenant
+    pub(crate) fn deny(&self, operation: &str) -> ClientError {
+        ClientError::PermissionDenied(format!(
+            "{} is not allowed for tenant prefix '{}'",
+            operation,
+            String::from_utf8_lossy(&self.prefix)
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check_prefix() {
+        let guard = TenantGuard::new(b"acme#".to_vec());
+        assert!(guard.check([b"acme#user1".as_slice()]).is_ok());
+        assert!(matches!(
+            guard.check([b"acme#user1".as_slice(), b"globex#user1".as_slice()]),
+            Err(ClientError::PermissionDenied(_))
+        ));
+    }
+}
@@ -1,19 +1,32 @@
 /// Remote transaction operations
 use crate::convert::*;
-use crate::error::Result;
-use kstone_core::Item;
+use crate::dry_run::RemoteDryRunResult;
+use crate::error::{ClientError, Result};
+use kstone_core::{CancellationReason, Item, Value};
 use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
-use tonic::transport::Channel;
+use crate::metadata::Transport;
 
 /// Remote transact get request builder
+///
+/// Condition checks assert invariants on other items in the same atomic
+/// read; if any is false the request fails with
+/// `ClientError::ConditionCheckFailed` and no items are returned.
 pub struct RemoteTransactGetRequest {
     keys: Vec<proto::Key>,
+    condition_checks: Vec<proto::ConditionCheck>,
+    expression_values: std::collections::HashMap<String, proto::Value>,
+    expression_names: std::collections::HashMap<String, String>,
 }
 
 impl RemoteTransactGetRequest {
     /// Create a new transact get request
     pub fn new() -> Self {
-        Self { keys: Vec::new() }
+        Self {
+            keys: Vec::new(),
+            condition_checks: Vec::new(),
+            expression_values: Default::default(),
+            expression_names: Default::default(),
+        }
     }
 
     /// Add a key with partition key only
@@ -34,10 +47,54 @@ impl RemoteTransactGetRequest {
         self
     }
 
+    /// Require `condition` to hold for the item at `pk`
+    pub fn condition_check(mut self, pk: &[u8], condition: impl Into<String>) -> Self {
+        self.condition_checks.push(proto::ConditionCheck {
+            partition_key: pk.to_vec(),
+            sort_key: None,
+            condition_expression: condition.into(),
+        });
+        self
+    }
+
+    /// Require `condition` to hold for the item at `pk`/`sk`
+    pub fn condition_check_with_sk(mut self, pk: &[u8], sk: &[u8], condition: impl Into<String>) -> Self {
+        self.condition_checks.push(proto::ConditionCheck {
+            partition_key: pk.to_vec(),
+            sort_key: Some(sk.to_vec()),
+            condition_expression: condition.into(),
+        });
+        self
+    }
+
+    /// Bind a value placeholder (e.g. `:flag`) used in the conditions
+    pub fn value(mut self, placeholder: impl Into<String>, value: Value) -> Self {
+        self.expression_values.insert(placeholder.into(), ks_value_to_proto(&value));
+        self
+    }
+
+    /// Bind a name placeholder (e.g. `#status`) used in the conditions
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.expression_names.insert(placeholder.into(), name.into());
+        self
+    }
+
+    /// Partition keys this request reads or checks
+    pub(crate) fn partition_keys(&self) -> Vec<&[u8]> {
+        self.keys
+            .iter()
+            .map(|k| k.partition_key.as_slice())
+            .chain(self.condition_checks.iter().map(|c| c.partition_key.as_slice()))
+            .collect()
+    }
+
     /// Execute the transact get operation
-    pub async fn execute(self, client: &mut KeystoneDbClient<Channel>) -> Result<RemoteTransactGetResponse> {
+    pub async fn execute(self, client: &mut KeystoneDbClient<Transport>) -> Result<RemoteTransactGetResponse> {
         let request = proto::TransactGetRequest {
             keys: self.keys,
+            condition_checks: self.condition_checks,
+            expression_values: self.expression_values,
+            expression_names: self.expression_names,
         };
 
         let response = client
@@ -75,12 +132,50 @@ pub struct RemoteTransactGetResponse {
 /// Remote transact write request builder
 pub struct RemoteTransactWriteRequest {
     writes: Vec<proto::TransactWriteItem>,
+    dry_run: bool,
+    client_request_token: Option<String>,
 }
 
 impl RemoteTransactWriteRequest {
     /// Create a new transact write request
     pub fn new() -> Self {
-        Self { writes: Vec::new() }
+        Self {
+            writes: Vec::new(),
+            dry_run: false,
+            client_request_token: None,
+        }
+    }
+
+    /// Set an idempotency token (1-36 characters)
+    ///
+    /// The server applies a transaction at most once per token within its
+    /// idempotency window; resubmitting the same request with the same token
+    /// returns the original result without writing again.
+    pub fn client_request_token(mut self, token: impl Into<String>) -> Self {
+        self.client_request_token = Some(token.into());
+        self
+    }
+
+    /// Make the request safe to retry
+    ///
+    /// Generates a client request token unless one was already set. Callers
+    /// that resubmit after a timeout should reuse the same request value.
+    pub fn idempotent(mut self) -> Self {
+        if self.client_request_token.is_none() {
+            self.client_request_token = Some(generate_token());
+        }
+        self
+    }
+
+    /// Client request token, if set
+    pub fn token(&self) -> Option<&str> {
+        self.client_request_token.as_deref()
+    }
+
+    /// Evaluate the transaction on the server without persisting it
+    pub fn dry_run(mut self, dry_run: bool) -> Self {
+        self.dry_run = dry_run;
+        self
     }
 
     /// Add a put request
@@ -134,6 +229,18 @@ impl RemoteTransactWriteRequest {
         self
     }
 
+    /// Add a delete request with sort key
+    pub fn delete_with_sk(mut self, pk: &[u8], sk: &[u8]) -> Self {
+        self.writes.push(proto::TransactWriteItem {
+            item: Some(proto::transact_write_item::Item::Delete(proto::TransactDelete {
+                partition_key: pk.to_vec(),
+                sort_key: Some(sk.to_vec()),
+                condition_expression: None,
+            })),
+        });
+        self
+    }
+
     /// Add a condition check
     pub fn condition_check(mut self, pk: &[u8], condition: impl Into<String>) -> Self {
         self.writes.push(proto::TransactWriteItem {
@@ -146,22 +253,144 @@ impl RemoteTransactWriteRequest {
         self
     }
 
+    /// Partition keys this request touches
+    pub(crate) fn partition_keys(&self) -> Vec<&[u8]> {
+        use proto::transact_write_item::Item as Op;
+        self.writes
+            .iter()
+            .filter_map(|w| match &w.item {
+                Some(Op::Put(op)) => Some(op.partition_key.as_slice()),
+                Some(Op::Update(op)) => Some(op.partition_key.as_slice()),
+                Some(Op::Delete(op)) => Some(op.partition_key.as_slice()),
+                Some(Op::ConditionCheck(op)) => Some(op.partition_key.as_slice()),
+                None => None,
+            })
+            .collect()
+    }
+
+    /// Split into requests of at most `size` operations, in order
+    ///
+    /// The token and dry-run flag are not carried over.
+    pub(crate) fn into_chunks(self, size: usize) -> Vec<RemoteTransactWriteRequest> {
+        self.writes
+            .chunks(size.max(1))
+            .map(|writes| RemoteTransactWriteRequest {
+                writes: writes.to_vec(),
+                ..Self::new()
+            })
+            .collect()
+    }
+
+    /// Keys this request writes (condition checks excluded), without duplicates
+    pub(crate) fn written_keys(&self) -> Vec<(Vec<u8>, Option<Vec<u8>>)> {
+        use proto::transact_write_item::Item as Op;
+        let mut keys = Vec::new();
+        for write in &self.writes {
+            let key = match &write.item {
+                Some(Op::Put(op)) => (op.partition_key.clone(), op.sort_key.clone()),
+                Some(Op::Update(op)) => (op.partition_key.clone(), op.sort_key.clone()),
+                Some(Op::Delete(op)) => (op.partition_key.clone(), op.sort_key.clone()),
+                Some(Op::ConditionCheck(_)) | None => continue,
+            };
+            if !keys.contains(&key) {
+                keys.push(key);
+            }
+        }
+        keys
+    }
+
     /// Execute the transact write operation
-    pub async fn execute(self, client: &mut KeystoneDbClient<Channel>) -> Result<()> {
+    pub async fn execute(self, client: &mut KeystoneDbClient<Transport>) -> Result<RemoteTransactWriteResponse> {
         let request = proto::TransactWriteRequest {
             items: self.writes,
+            dry_run: self.dry_run,
+            client_request_token: self.client_request_token,
         };
 
-        client
+        let response = client
             .transact_write(request)
-            .await?;
+            .await?
+            .into_inner();
+
+        if !response.success && !response.cancellation_reasons.is_empty() {
+            return Err(ClientError::TransactionCanceled {
+                message: response
+                    .error
+                    .unwrap_or_else(|| "Transaction canceled".to_string()),
+                reasons: response
+                    .cancellation_reasons
+                    .into_iter()
+                    .map(|r| CancellationReason {
+                        code: r.code,
+                        message: r.message,
+                    })
+                    .collect(),
+            });
+        }
+
+        let dry_run_results = response
+            .dry_run_results
+            .into_iter()
+            .map(RemoteDryRunResult::from_proto)
+            .collect::<Result<Vec<_>>>()?;
 
-        Ok(())
+        Ok(RemoteTransactWriteResponse {
+            success: response.success,
+            dry_run_results,
+        })
     }
 }
 
+/// Transact write response
+pub struct RemoteTransactWriteResponse {
+    /// Whether the transaction committed (or would commit, for a dry run)
+    pub success: bool,
+    /// Per-operation dry-run outcomes, in request order (empty unless dry run)
+    pub dry_run_results: Vec<RemoteDryRunResult>,
+}
+
 impl Default for RemoteTransactWriteRequest {
     fn default() -> Self {
         Self::new()
     }
 }
+
+/// Generate a unique client request token (34 characters)
+fn generate_token() -> String {
+    use std::sync::atomic::{AtomicU32, Ordering};
+    use std::time::{SystemTime, UNIX_EPOCH};
+
+    static COUNTER: AtomicU32 = AtomicU32::new(0);
+
+    let nanos = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|d| d.as_nanos() as u64)
+        .unwrap_or(0);
+    format!(
+        "{:016x}-{:08x}-{:08x}",
+        nanos,
+        std::process::id(),
+        COUNTER.fetch_add(1, Ordering::Relaxed)
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_idempotent_keeps_explicit_token() {
+        let request = RemoteTransactWriteRequest::new()
+            .client_request_token("my-token")
+            .idempotent();
+        assert_eq!(request.token(), Some("my-token"));
+    }
+
+    #[test]
+    fn test_generated_tokens_are_unique() {
+        let a = RemoteTransactWriteRequest::new().idempotent();
+        let b = RemoteTransactWriteRequest::new().idempotent();
+        assert_ne!(a.token(), b.token());
+        assert!(a.token().unwrap().len() <= 36);
+    }
+}
@@ -1,9 +1,10 @@
 /// Remote update operations
 use crate::convert::*;
-use crate::error::Result;
+use crate::dry_run::RemoteDryRunResult;
+use crate::error::{ClientError, Result};
 use kstone_core::Item;
 use kstone_proto::{self as proto, keystone_db_client::KeystoneDbClient};
-use tonic::transport::Channel;
+use crate::metadata::Transport;
 use std::collections::HashMap;
 
 /// Remote update request builder
@@ -13,6 +14,8 @@ pub struct RemoteUpdate {
     update_expression: String,
     condition_expression: Option<String>,
     expression_values: HashMap<String, kstone_core::Value>,
+    expression_names: HashMap<String, String>,
+    dry_run: bool,
 }
 
 impl RemoteUpdate {
@@ -24,6 +27,8 @@ impl RemoteUpdate {
             update_expression: String::new(),
             condition_expression: None,
             expression_values: HashMap::new(),
+            expression_names: HashMap::new(),
+            dry_run: false,
         }
     }
 
@@ -35,6 +40,8 @@ impl RemoteUpdate {
             update_expression: String::new(),
             condition_expression: None,
             expression_values: HashMap::new(),
+            expression_names: HashMap::new(),
+            dry_run: false,
         }
     }
 
@@ -56,8 +63,37 @@ impl RemoteUpdate {
         self
     }
 
+    /// Add an expression attribute name
+    pub fn name(mut self, placeholder: impl Into<String>, name: impl Into<String>) -> Self {
+        self.expression_names.insert(placeholder.into(), name.into());
+        self
+    }
+
+    /// Compute the post-image on the server without persisting it
+    pub fn dry_run(mut self, dry_run: bool) -> Self {
+        self.dry_run = dry_run;
+        self
+    }
+
+    /// Set the update expression from an `UpdateExpr` builder
+    ///
+    /// Replaces any previous update expression; the compiled names and
+    /// values are merged into the request's maps.
+    pub fn update_expr(mut self, expr: UpdateExpr) -> Self {
+        let compiled = expr.compile();
+        self.update_expression = compiled.expression;
+        self.expression_names.extend(compiled.names);
+        self.expression_values.extend(compiled.values);
+        self
+    }
+
+    /// Partition key this request targets
+    pub(crate) fn partition_key(&self) -> &[u8] {
+        &self.partition_key
+    }
+
     /// Execute the update operation
-    pub async fn execute(self, client: &mut KeystoneDbClient<Channel>) -> Result<RemoteUpdateResponse> {
+    pub async fn execute(self, client: &mut KeystoneDbClient<Transport>) -> Result<RemoteUpdateResponse> {
         // Convert expression values to protobuf
         let proto_values: HashMap<String, proto::Value> = self
             .expression_values
@@ -71,6 +107,8 @@ impl RemoteUpdate {
             update_expression: self.update_expression,
             condition_expression: self.condition_expression,
             expression_values: proto_values,
+            expression_names: self.expression_names,
+            dry_run: self.dry_run,
         };
 
         let response = client
@@ -78,16 +116,280 @@ impl RemoteUpdate {
             .await?
             .into_inner();
 
-        let item = proto_item_to_ks(
-            response.item.expect("Server should return updated item")
-        )?;
+        let dry_run = response
+            .dry_run_result
+            .map(RemoteDryRunResult::from_proto)
+            .transpose()?;
+
+        let item = match (response.item, &dry_run) {
+            (Some(item), _) => proto_item_to_ks(item)?,
+            // A dry run against a missing item whose condition fails has no image
+            (None, Some(_)) => Item::new(),
+            (None, None) => {
+                return Err(ClientError::InvalidResponse("update response has no item".to_string()))
+            }
+        };
 
-        Ok(RemoteUpdateResponse { item })
+        Ok(RemoteUpdateResponse { item, dry_run })
     }
 }
 
 /// Update response
 pub struct RemoteUpdateResponse {
-    /// The updated item
+    /// The updated item (the would-be item for a dry run)
     pub item: Item,
+    /// Dry-run outcome (only set when the update was a dry run)
+    pub dry_run: Option<RemoteDryRunResult>,
+}
+
+/// Fluent builder for update expressions
+///
+/// Every attribute name is aliased (`#u0`, `#u1`, ...) and every value is
+/// bound (`:u0`, `:u1`, ...), so the expression and its maps always agree.
+///
+/// # Example
+///
+/// ```
+/// use kstone_client::UpdateExpr;
+/// use kstone_core::Value;
+///
+/// let compiled = UpdateExpr::new()
+///     .set("status", Value::string("done"))
+///     .add("count", Value::number(1))
+///     .remove("temp")
+///     .compile();
+///
+/// assert_eq!(compiled.expression, "SET #u0 = :u0 REMOVE #u2 ADD #u1 :u1");
+/// ```
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct UpdateExpr {
+    clauses: Vec<UpdateClause>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum UpdateClause {
+    Set(String, kstone_core::Value),
+    SetIfNotExists(String, kstone_core::Value),
+    ListAppend(String, kstone_core::Value),
+    Add(String, kstone_core::Value),
+    Delete(String, kstone_core::Value),
+    Remove(String),
+}
+
+/// A compiled update: expression string plus bound names and values
+#[derive(Debug, Clone, PartialEq, Default)]
+pub struct CompiledUpdate {
+    /// Update expression using only `#name` and `:value` placeholders
+    pub expression: String,
+    /// Expression attribute names (#u0 -> actual name)
+    pub names: HashMap<String, String>,
+    /// Expression attribute values (:u0 -> value)
+    pub values: HashMap<String, kstone_core::Value>,
+}
+
+impl UpdateExpr {
+    /// Create an empty update expression
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// SET name = value
+    pub fn set(mut self, name: impl Into<String>, value: kstone_core::Value) -> Self {
+        self.clauses.push(UpdateClause::Set(name.into(), value));
+        self
+    }
+
+    /// SET name = if_not_exists(name, value)
+    pub fn set_if_not_exists(mut self, name: impl Into<String>, value: kstone_core::Value) -> Self {
+        self.clauses.push(UpdateClause::SetIfNotExists(name.into(), value));
+        self
+    }
+
+    /// SET name = list_append(name, values)
+    pub fn list_append(mut self, name: impl Into<String>, values: Vec<kstone_core::Value>) -> Self {
+        self.clauses.push(UpdateClause::ListAppend(name.into(), kstone_core::Value::L(values)));
+        self
+    }
+
+    /// ADD name value (numeric increment, or add set members)
+    pub fn add(mut self, name: impl Into<String>, value: kstone_core::Value) -> Self {
+        self.clauses.push(UpdateClause::Add(name.into(), value));
+        self
+    }
+
+    /// DELETE name members (remove set members)
+    pub fn delete(mut self, name: impl Into<String>, members: kstone_core::Value) -> Self {
+        self.clauses.push(UpdateClause::Delete(name.into(), members));
+        self
+    }
+
+    /// REMOVE name
+    pub fn remove(mut self, name: impl Into<String>) -> Self {
+        self.clauses.push(UpdateClause::Remove(name.into()));
+        self
+    }
+
+    /// Compile into an expression string with names/values maps
+    pub fn compile(&self) -> CompiledUpdate {
+        let mut names = HashMap::new();
+        let mut aliases: HashMap<String, String> = HashMap::new();
+        let mut values = HashMap::new();
+
+        let mut alias = |name: &str, names: &mut HashMap<String, String>| -> String {
+            if let Some(a) = aliases.get(name) {
+                return a.clone();
+            }
+            let a = format!("#u{}", aliases.len());
+            aliases.insert(name.to_string(), a.clone());
+            names.insert(a.clone(), name.to_string());
+            a
+        };
+        let bind = |value: &kstone_core::Value, values: &mut HashMap<String, kstone_core::Value>| -> String {
+            let placeholder = format!(":u{}", values.len());
+            values.insert(placeholder.clone(), value.clone());
+            placeholder
+        };
+
+        let mut set = Vec::new();
+        let mut remove = Vec::new();
+        let mut add = Vec::new();
+        let mut delete = Vec::new();
+
+        for clause in &self.clauses {
+            match clause {
+                UpdateClause::Set(name, value) => {
+                    let n = alias(name, &mut names);
+                    let v = bind(value, &mut values);
+                    set.push(format!("{} = {}", n, v));
+                }
+                UpdateClause::SetIfNotExists(name, value) => {
+                    let n = alias(name, &mut names);
+                    let v = bind(value, &mut values);
+                    set.push(format!("{} = if_not_exists({}, {})", n, n, v));
+                }
+                UpdateClause::ListAppend(name, value) => {
+                    let n = alias(name, &mut names);
+                    let v = bind(value, &mut values);
+                    set.push(format!("{} = list_append({}, {})", n, n, v));
+                }
+                UpdateClause::Add(name, value) => {
+                    let n = alias(name, &mut names);
+                    let v = bind(value, &mut values);
+                    add.push(format!("{} {}", n, v));
+                }
+                UpdateClause::Delete(name, value) => {
+                    let n = alias(name, &mut names);
+                    let v = bind(value, &mut values);
+                    delete.push(format!("{} {}", n, v));
+                }
+                UpdateClause::Remove(name) => {
+                    remove.push(alias(name, &mut names));
+                }
+            }
+        }
+
+        let mut sections = Vec::new();
+        if !set.is_empty() {
+            sections.push(format!("SET {}", set.join(", ")));
+        }
+        if !remove.is_empty() {
+            sections.push(format!("REMOVE {}", remove.join(", ")));
+        }
+        if !add.is_empty() {
+            sections.push(format!("ADD {}", add.join(", ")));
+        }
+        if !delete.is_empty() {
+            sections.push(format!("DELETE {}", delete.join(", ")));
+        }
+
+        CompiledUpdate {
+            expression: sections.join(" "),
+            names,
+            values,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use kstone_core::expression::UpdateExpressionParser;
+    use kstone_core::Value;
+
+    #[test]
+    fn test_compile_set() {
+        let compiled = UpdateExpr::new().set("status", Value::string("done")).compile();
+        assert_eq!(compiled.expression, "SET #u0 = :u0");
+        assert_eq!(compiled.names["#u0"], "status");
+        assert_eq!(compiled.values[":u0"], Value::string("done"));
+    }
+
+    #[test]
+    fn test_compile_add() {
+        let compiled = UpdateExpr::new().add("count", Value::number(1)).compile();
+        assert_eq!(compiled.expression, "ADD #u0 :u0");
+        assert_eq!(compiled.names["#u0"], "count");
+        assert_eq!(compiled.values[":u0"], Value::number(1));
+    }
+
+    #[test]
+    fn test_compile_set_membership() {
+        let compiled = UpdateExpr::new()
+            .delete("tags", Value::string_set(["old"]))
+            .add("tags", Value::string_set(["new"]))
+            .compile();
+        assert_eq!(compiled.expression, "ADD #u0 :u1 DELETE #u0 :u0");
+        assert_eq!(compiled.values[":u0"], Value::string_set(["old"]));
+
+        let actions = UpdateExpressionParser::parse(&compiled.expression).unwrap();
+        assert_eq!(actions.len(), 2);
+    }
+
+    #[test]
+    fn test_compile_remove() {
+        let compiled = UpdateExpr::new().remove("temp").compile();
+        assert_eq!(compiled.expression, "REMOVE #u0");
+        assert_eq!(compiled.names["#u0"], "temp");
+        assert!(compiled.values.is_empty());
+    }
+
+    #[test]
+    fn test_compile_list_append() {
+        let compiled = UpdateExpr::new()
+            .list_append("tags", vec![Value::string("new")])
+            .compile();
+        assert_eq!(compiled.expression, "SET #u0 = list_append(#u0, :u0)");
+        assert_eq!(compiled.values[":u0"], Value::L(vec![Value::string("new")]));
+    }
+
+    #[test]
+    fn test_compile_set_if_not_exists() {
+        let compiled = UpdateExpr::new()
+            .set_if_not_exists("created", Value::number(100))
+            .compile();
+        assert_eq!(compiled.expression, "SET #u0 = if_not_exists(#u0, :u0)");
+        assert_eq!(compiled.names["#u0"], "created");
+    }
+
+    #[test]
+    fn test_compile_multi_clause() {
+        let compiled = UpdateExpr::new()
+            .set("status", Value::string("done"))
+            .add("count", Value::number(1))
+            .remove("temp")
+            .list_append("history", vec![Value::string("done")])
+            .set_if_not_exists("created", Value::number(1))
+            .compile();
+
+        assert_eq!(
+            compiled.expression,
+            "SET #u0 = :u0, #u3 = list_append(#u3, :u2), #u4 = if_not_exists(#u4, :u3) REMOVE #u2 ADD #u1 :u1"
+        );
+        assert_eq!(compiled.names.len(), 5);
+        assert_eq!(compiled.values.len(), 4);
+
+        // The server-side parser accepts what we generate
+        let actions = UpdateExpressionParser::parse(&compiled.expression).unwrap();
+        assert_eq!(actions.len(), 5);
+    }
 }
@@ -0,0 +1,100 @@
+/// Local request validation
+///
+/// Request builders check for mistakes the server would only report after
+/// a round trip, or that would silently do nothing: an empty partition key,
+/// a page limit of zero, two sort key conditions on one query, or a batch
+/// too large to send. Each builder's `validate` runs these checks, and
+/// `execute` calls it before sending, so the mistake surfaces as
+/// `ClientError::InvalidArgument` naming the offending field.
+
+use crate::error::{ClientError, Result};
+
+/// Largest encoded request the server accepts (tonic's default limit)
+pub const MAX_REQUEST_BYTES: usize = 4 * 1024 * 1024;
+
+pub(crate) fn partition_key(pk: &[u8], field: &str) -> Result<()> {
+    if pk.is_empty() {
+        return Err(ClientError::InvalidArgument(format!("{} must not be empty", field)));
+    }
+    Ok(())
+}
+
+pub(crate) fn limit(limit: Option<u32>) -> Result<()> {
+    if limit == Some(0) {
+        return Err(ClientError::InvalidArgument(
+            "limit must be greater than zero".to_string(),
+        ));
+    }
+    Ok(())
+}
+
+pub(crate) fn request_size(encoded_len: usize, what: &str) -> Result<()> {
+    if encoded_len > MAX_REQUEST_BYTES {
+        return Err(ClientError::InvalidArgument(format!(
+            "{} is {} bytes encoded, over the {} byte request limit; split it up",
+            what, encoded_len, MAX_REQUEST_BYTES
+        )));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::batch::{RemoteBatchGetRequest, RemoteBatchWriteRequest};
+    use crate::put::RemotePut;
+    use crate::query::RemoteQuery;
+    use crate::scan::RemoteScan;
+    use kstone_core::{Item, Value};
+
+    fn message(result: Result<()>) -> String {
+        match result {
+            Err(ClientError::InvalidArgument(msg)) => msg,
+            other => panic!("expected InvalidArgument, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_query_rules() {
+        assert!(RemoteQuery::new(b"pk").sk_between(b"a", b"z").limit(10).validate().is_ok());
+
+        assert!(message(RemoteQuery::new(b"").validate()).contains("partition key must not be empty"));
+        assert!(message(RemoteQuery::new(b"pk").limit(0).validate()).contains("limit must be greater than zero"));
+        assert!(message(RemoteQuery::new(b"pk").sk_eq(b"a").sk_between(b"a", b"z").validate())
+            .contains("only one sort key condition"));
+    }
+
+    #[test]
+    fn test_scan_rules() {
+        assert!(RemoteScan::new().segment(1, 2).validate().is_ok());
+
+        assert!(message(RemoteScan::new().limit(0).validate()).contains("limit must be greater than zero"));
+        assert!(message(RemoteScan::new().segment(2, 2).validate()).contains("segment 2 must be less than total_segments 2"));
+    }
+
+    #[test]
+    fn test_put_and_batch_rules() {
+        let mut item = Item::new();
+        item.insert("name".to_string(), Value::string("x"));
+
+        assert!(message(RemotePut::new(b"", item.clone()).validate()).contains("partition key must not be empty"));
+        assert!(message(RemoteBatchGetRequest::new().add_key(b"a").add_key(b"").validate())
+            .contains("batch get partition key must not be empty"));
+        assert!(message(RemoteBatchWriteRequest::new().put(b"a", item.clone()).delete(b"").validate())
+            .contains("batch delete partition key must not be empty"));
+    }
+
+    #[test]
+    fn test_oversized_batch_rejected() {
+        let mut item = Item::new();
+        item.insert("payload".to_string(), Value::S("x".repeat(64 * 1024)));
+
+        let mut batch = RemoteBatchWriteRequest::new();
+        for i in 0..100 {
+            batch = batch.put(format!("pk#{}", i).as_bytes(), item.clone());
+        }
+        let msg = message(batch.validate());
+        assert!(msg.contains("batch write is"), "{}", msg);
+        assert!(msg.contains("over the 4194304 byte request limit"), "{}", msg);
+    }
+}
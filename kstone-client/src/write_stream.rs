@@ -0,0 +1,199 @@
+/// Streaming writes for bulk ingestion
+///
+/// `Client::write_stream` opens one bidirectional stream: puts and deletes
+/// are pushed with `send` without waiting for each to be applied, and the
+/// server acks every write, in send order, once it has been applied. This
+/// avoids a round trip per write. Read acks with `next_ack`, or split the
+/// stream with `into_split` to send and read acks from different tasks.
+///
+/// A failed write is reported in its ack and the stream carries on.
+
+use crate::convert::ks_item_to_proto;
+use crate::error::{ClientError, Result};
+use crate::read_cache::ReadCache;
+use futures::SinkExt;
+use kstone_core::Item;
+use kstone_proto as proto;
+use std::collections::HashMap;
+use std::sync::Arc;
+use tokio::sync::mpsc;
+use tonic::{Status, Streaming};
+
+/// Writes buffered on the client before `send` waits for the server
+pub(crate) const WRITE_STREAM_BUFFER: usize = 256;
+
+/// A write pushed over a write stream
+#[derive(Debug, Clone)]
+pub enum WriteOp {
+    /// Put an item
+    Put { pk: Vec<u8>, sk: Option<Vec<u8>>, item: Item },
+    /// Delete an item
+    Delete { pk: Vec<u8>, sk: Option<Vec<u8>> },
+}
+
+impl WriteOp {
+    /// Put with partition key
+    pub fn put(pk: &[u8], item: Item) -> Self {
+        WriteOp::Put { pk: pk.to_vec(), sk: None, item }
+    }
+
+    /// Put with partition key and sort key
+    pub fn put_with_sk(pk: &[u8], sk: &[u8], item: Item) -> Self {
+        WriteOp::Put { pk: pk.to_vec(), sk: Some(sk.to_vec()), item }
+    }
+
+    /// Delete with partition key
+    pub fn delete(pk: &[u8]) -> Self {
+        WriteOp::Delete { pk: pk.to_vec(), sk: None }
+    }
+
+    /// Delete with partition key and sort key
+    pub fn delete_with_sk(pk: &[u8], sk: &[u8]) -> Self {
+        WriteOp::Delete { pk: pk.to_vec(), sk: Some(sk.to_vec()) }
+    }
+
+    fn into_proto(self) -> proto::WriteRequest {
+        let request = match self {
+            WriteOp::Put { pk, sk, item } => proto::write_request::Request::Put(proto::PutItem {
+                partition_key: pk,
+                sort_key: sk,
+                item: Some(ks_item_to_proto(&item)),
+                condition_expression: None,
+                expression_values: HashMap::new(),
+                expression_names: HashMap::new(),
+            }),
+            WriteOp::Delete { pk, sk } => proto::write_request::Request::Delete(proto::DeleteKey {
+                partition_key: pk,
+                sort_key: sk,
+            }),
+        };
+        proto::WriteRequest { request: Some(request) }
+    }
+}
+
+/// Outcome of one streamed write
+#[derive(Debug)]
+pub struct WriteAck {
+    /// Id `send` returned for the write
+    pub id: u64,
+    /// Whether the write was applied, or why not
+    pub result: Result<()>,
+}
+
+/// Acks of a write stream
+///
+/// Yields `Err` once, and then ends, if the stream itself fails.
+pub type WriteAcks = mpsc::UnboundedReceiver<Result<WriteAck>>;
+
+/// Sending half of a write stream
+pub struct WriteSender {
+    tx: futures::channel::mpsc::Sender<proto::WriteStreamRequest>,
+    next_id: u64,
+}
+
+impl WriteSender {
+    /// Push a write and return the id its ack will carry
+    ///
+    /// Waits only while the stream's buffer is full. Fails with
+    /// `ConnectionError` once the stream has been closed.
+    pub async fn send(&mut self, op: WriteOp) -> Result<u64> {
+        let id = self.next_id;
+        let request = proto::WriteStreamRequest {
+            id,
+            write: Some(op.into_proto()),
+        };
+        self.tx
+            .send(request)
+            .await
+            .map_err(|_| ClientError::ConnectionError("Write stream is closed".to_string()))?;
+        self.next_id += 1;
+        Ok(id)
+    }
+
+    /// Stop sending; the acks end once every write sent has been acked
+    ///
+    /// Dropping the sender does the same.
+    pub fn close(&mut self) {
+        self.tx.close_channel();
+    }
+}
+
+/// An open write stream
+pub struct WriteStream {
+    sender: WriteSender,
+    acks: WriteAcks,
+}
+
+impl WriteStream {
+    pub(crate) fn new(
+        tx: futures::channel::mpsc::Sender<proto::WriteStreamRequest>,
+        inbound: Streaming<proto::WriteStreamAck>,
+        read_cache: Option<Arc<ReadCache>>,
+    ) -> Self {
+        // Unbounded, so unread acks never stall the server while the
+        // caller is still sending
+        let (ack_tx, acks) = mpsc::unbounded_channel();
+        tokio::spawn(forward_acks(inbound, ack_tx, read_cache));
+        Self {
+            sender: WriteSender { tx, next_id: 0 },
+            acks,
+        }
+    }
+
+    /// Push a write and return the id its ack will carry
+    ///
+    /// See `WriteSender::send`.
+    pub async fn send(&mut self, op: WriteOp) -> Result<u64> {
+        self.sender.send(op).await
+    }
+
+    /// Stop sending; the acks end once every write sent has been acked
+    pub fn close(&mut self) {
+        self.sender.close();
+    }
+
+    /// Next ack, waiting for one if none has arrived yet
+    ///
+    /// Returns None once the stream is closed and fully acked.
+    pub async fn next_ack(&mut self) -> Option<Result<WriteAck>> {
+        self.acks.recv().await
+    }
+
+    /// Separate the sending half from the acks
+    pub fn into_split(self) -> (WriteSender, WriteAcks) {
+        (self.sender, self.acks)
+    }
+}
+
+/// Pass acks from the server on to the caller
+async fn forward_acks(
+    mut inbound: Streaming<proto::WriteStreamAck>,
+    acks: mpsc::UnboundedSender<Result<WriteAck>>,
+    read_cache: Option<Arc<ReadCache>>,
+) {
+    loop {
+        let ack = match inbound.message().await {
+            Ok(Some(ack)) => ack,
+            Ok(None) => return,
+            Err(status) => {
+                let _ = acks.send(Err(status.into()));
+                return;
+            }
+        };
+
+        // The write has been applied, so nothing read before it may be served
+        if let Some(cache) = &read_cache {
+            cache.clear();
+        }
+
+        let result = if ack.success {
+            Ok(())
+        } else {
+            let status = Status::new(tonic::Code::from(ack.code), ack.error.unwrap_or_default());
+            Err(status.into())
+        };
+        if acks.send(Ok(WriteAck { id: ack.id, result })).is_err() {
+            return;
+        }
+    }
+}
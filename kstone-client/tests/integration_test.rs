@@ -7,7 +7,7 @@ use kstone_api::Database;
 use kstone_client::{
     Client, RemoteQuery, RemoteScan, RemoteBatchGetRequest, RemoteBatchWriteRequest,
     RemoteTransactGetRequest, RemoteTransactWriteRequest, RemoteUpdate,
-    RemoteExecuteStatementResponse
+    RemoteExecuteStatementResponse, RemotePut, RemoteDelete, UpdateExpr, ClientError, WriteOp, cond
 };
 use kstone_core::Value;
 use kstone_server::{KeystoneDbServer, KeystoneService};
@@ -633,3 +633,1828 @@ async fn test_transact_get_with_missing_items() {
     assert!(response.items[1].is_none());
     assert!(response.items[2].is_none());
 }
+
+#[tokio::test]
+async fn test_drain_waits_for_in_flight_scan() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    // Populate enough data that the scan takes a moment
+    let mut batch = RemoteBatchWriteRequest::new();
+    for i in 0..500 {
+        let mut item = HashMap::new();
+        item.insert("payload".to_string(), Value::S("x".repeat(256)));
+        batch = batch.put(format!("item#{:04}", i).as_bytes(), item);
+    }
+    client.batch_write(batch).await.unwrap();
+
+    // Start the scan on a clone so it shares in-flight tracking
+    let mut scanner = client.clone();
+    let scan_task = tokio::spawn(async move { scanner.scan(RemoteScan::new()).await });
+
+    while client.in_flight() == 0 {
+        tokio::task::yield_now().await;
+    }
+
+    let mut late = client.clone();
+    client.drain(Duration::from_secs(5)).await.unwrap();
+
+    // The scan finished before drain returned
+    let response = scan_task.await.unwrap().unwrap();
+    assert_eq!(response.count, 500);
+
+    // New calls are rejected once draining
+    assert!(late.is_draining());
+    let result = late.get(b"item#0000").await;
+    assert!(matches!(result, Err(kstone_client::ClientError::Unavailable(_))));
+}
+
+#[tokio::test]
+async fn test_put_with_condition_builder() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("version".to_string(), Value::number(1));
+    item.insert("status".to_string(), Value::string("new"));
+
+    // Create only if absent
+    let put = RemotePut::new(b"doc#1", item.clone())
+        .condition_expr(cond::attr_not_exists("version"));
+    client.put_item(put).await.unwrap();
+
+    // Compound condition that holds
+    let mut next = item.clone();
+    next.insert("version".to_string(), Value::number(2));
+    let put = RemotePut::new(b"doc#1", next)
+        .condition_expr(cond::and(vec![
+            cond::attr_exists("version"),
+            cond::eq("version", Value::number(1)),
+            cond::between("version", Value::number(0), Value::number(5)),
+        ]));
+    client.put_item(put).await.unwrap();
+
+    // Stale version check fails
+    let put = RemotePut::new(b"doc#1", item)
+        .condition_expr(cond::eq("version", Value::number(1)));
+    let result = client.put_item(put).await;
+    assert!(matches!(result, Err(kstone_client::ClientError::ConditionCheckFailed(_))));
+
+    let stored = client.get(b"doc#1").await.unwrap().unwrap();
+    assert_eq!(stored.get("version").unwrap(), &Value::number(2));
+}
+
+#[tokio::test]
+async fn test_update_with_expression_builder() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("status".to_string(), Value::string("pending"));
+    item.insert("count".to_string(), Value::number(1));
+    item.insert("temp".to_string(), Value::Bool(true));
+    item.insert("history".to_string(), Value::L(vec![Value::string("pending")]));
+    client.put(b"task#1", item).await.unwrap();
+
+    let update = RemoteUpdate::new(b"task#1").update_expr(
+        UpdateExpr::new()
+            .set("status", Value::string("done"))
+            .add("count", Value::number(1))
+            .remove("temp")
+            .list_append("history", vec![Value::string("done")])
+            .set_if_not_exists("created", Value::number(42)),
+    );
+
+    let response = client.update(update).await.unwrap();
+    let updated = response.item;
+    assert_eq!(updated.get("status").unwrap(), &Value::string("done"));
+    assert_eq!(updated.get("count").unwrap(), &Value::number(2));
+    assert!(!updated.contains_key("temp"));
+    assert_eq!(
+        updated.get("history").unwrap(),
+        &Value::L(vec![Value::string("pending"), Value::string("done")])
+    );
+    assert_eq!(updated.get("created").unwrap(), &Value::number(42));
+}
+
+#[tokio::test]
+async fn test_dry_run_conditional_put_does_not_mutate() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("version".to_string(), Value::number(1));
+    client.put(b"doc#1", item).await.unwrap();
+
+    let mut replacement = HashMap::new();
+    replacement.insert("version".to_string(), Value::number(7));
+
+    // Condition would fail: reported, not raised
+    let put = RemotePut::new(b"doc#1", replacement.clone())
+        .condition_expr(cond::eq("version", Value::number(6)))
+        .dry_run(true);
+    let response = client.put_item(put).await.unwrap();
+    let outcome = response.dry_run.expect("dry run result");
+    assert!(!outcome.condition_passed);
+    assert_eq!(outcome.item.unwrap().get("version").unwrap(), &Value::number(1));
+
+    // Condition would pass: post-image reported, still not persisted
+    let put = RemotePut::new(b"doc#1", replacement)
+        .condition_expr(cond::eq("version", Value::number(1)))
+        .dry_run(true);
+    let outcome = client.put_item(put).await.unwrap().dry_run.unwrap();
+    assert!(outcome.condition_passed);
+    assert_eq!(outcome.item.unwrap().get("version").unwrap(), &Value::number(7));
+
+    let stored = client.get(b"doc#1").await.unwrap().unwrap();
+    assert_eq!(stored.get("version").unwrap(), &Value::number(1));
+}
+
+#[tokio::test]
+async fn test_transact_write_cancellation_reasons() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("balance".to_string(), Value::number(10));
+
+    // Middle operation requires an item that does not exist
+    let request = RemoteTransactWriteRequest::new()
+        .put(b"account#1", item.clone())
+        .condition_check(b"account#missing", "attribute_exists(balance)")
+        .put(b"account#2", item);
+
+    match client.transact_write(request).await {
+        Err(ClientError::TransactionCanceled { reasons, .. }) => {
+            assert_eq!(reasons.len(), 3);
+            assert_eq!(reasons[0].code, "None");
+            assert_eq!(reasons[1].code, "ConditionalCheckFailed");
+            assert!(reasons[1].message.is_some());
+            assert_eq!(reasons[2].code, "None");
+        }
+        Err(e) => panic!("expected TransactionCanceled, got {:?}", e),
+        Ok(_) => panic!("expected TransactionCanceled, got success"),
+    }
+
+    // Nothing was written
+    assert!(client.get(b"account#1").await.unwrap().is_none());
+    assert!(client.get(b"account#2").await.unwrap().is_none());
+}
+
+#[tokio::test]
+async fn test_transact_write_client_request_token_is_idempotent() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("version".to_string(), Value::number(1));
+
+    let request = || {
+        RemoteTransactWriteRequest::new()
+            .put(b"doc#1", item.clone())
+            .client_request_token("retry-token-1")
+    };
+
+    assert!(client.transact_write(request()).await.unwrap().success);
+
+    // Change the item outside the transaction
+    let mut newer = HashMap::new();
+    newer.insert("version".to_string(), Value::number(2));
+    client.put(b"doc#1", newer).await.unwrap();
+
+    // Retrying with the same token is a no-op that reports the original result
+    assert!(client.transact_write(request()).await.unwrap().success);
+    let stored = client.get(b"doc#1").await.unwrap().unwrap();
+    assert_eq!(stored.get("version").unwrap(), &Value::number(2));
+
+    // Reusing the token for different operations is rejected
+    let result = client
+        .transact_write(
+            RemoteTransactWriteRequest::new()
+                .delete(b"doc#1")
+                .client_request_token("retry-token-1"),
+        )
+        .await;
+    assert!(matches!(result, Err(ClientError::InvalidArgument(_))));
+}
+
+#[tokio::test]
+async fn test_count_filtered_query_transfers_no_items() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for i in 0..6 {
+        let mut item = HashMap::new();
+        let status = if i % 3 == 0 { "closed" } else { "open" };
+        item.insert("status".to_string(), Value::string(status));
+        client
+            .put_with_sk(b"org#acme", format!("ticket#{}", i).as_bytes(), item)
+            .await
+            .unwrap();
+    }
+
+    let open = || {
+        RemoteQuery::new(b"org#acme")
+            .filter("#s = :open")
+            .name("#s", "status")
+            .value(":open", Value::string("open"))
+    };
+
+    // The count-only response carries no item bodies
+    let response = client.query(open().select_count()).await.unwrap();
+    assert_eq!(response.count, 4);
+    assert!(response.items.is_empty());
+    assert_eq!(response.scanned_count, 6);
+
+    assert_eq!(client.count(open()).await.unwrap(), 4);
+
+    // Without select_count the filtered items are returned
+    let response = client.query(open()).await.unwrap();
+    assert_eq!(response.items.len(), 4);
+}
+
+#[tokio::test]
+async fn test_parallel_scan_delivers_each_item_once() {
+    use std::sync::{Arc, Mutex};
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for i in 0..200 {
+        let mut item = HashMap::new();
+        item.insert("n".to_string(), Value::number(i));
+        client.put(format!("item#{}", i).as_bytes(), item).await.unwrap();
+    }
+
+    let seen = Arc::new(Mutex::new(Vec::new()));
+    let sink = Arc::clone(&seen);
+    client
+        .parallel_scan(RemoteScan::new(), 8, move |item| {
+            if let Some(Value::N(n)) = item.get("n") {
+                sink.lock().unwrap().push(n.parse::<i64>().unwrap());
+            }
+            Ok(())
+        })
+        .await
+        .unwrap();
+
+    let mut seen = seen.lock().unwrap().clone();
+    assert_eq!(seen.len(), 200);
+    seen.sort();
+    seen.dedup();
+    assert_eq!(seen.len(), 200);
+
+    // An error from the callback cancels the scan and is returned
+    let result = client
+        .parallel_scan(RemoteScan::new(), 8, |_item| {
+            Err(kstone_client::ClientError::InvalidArgument("stop".to_string()))
+        })
+        .await;
+    assert!(matches!(result, Err(ClientError::InvalidArgument(_))));
+}
+
+#[tokio::test]
+async fn test_tenant_guard_rejects_cross_tenant_get() {
+    let (_dir, addr, _handle) = start_test_server().await;
+
+    let mut admin = Client::connect(addr.clone()).await.unwrap();
+    let mut item = HashMap::new();
+    item.insert("name".to_string(), Value::string("Alice"));
+    admin.put(b"acme#user1", item.clone()).await.unwrap();
+    admin.put(b"globex#user1", item).await.unwrap();
+
+    let mut client = Client::connect(addr).await.unwrap().with_tenant_guard("acme#");
+
+    let own = client.get(b"acme#user1").await.unwrap();
+    assert!(own.is_some());
+
+    let other = client.get(b"globex#user1").await;
+    assert!(matches!(other, Err(ClientError::PermissionDenied(_))));
+
+    let scan = client.scan(RemoteScan::new()).await;
+    assert!(matches!(scan, Err(ClientError::PermissionDenied(_))));
+}
+
+#[tokio::test]
+async fn test_metadata_reaches_server() {
+    use std::net::TcpListener;
+    use std::sync::{Arc, Mutex};
+
+    // Server that records the x-tenant-id and x-trace-id headers of each request
+    let dir = TempDir::new().unwrap();
+    let service = KeystoneService::new(Database::create(dir.path()).unwrap());
+    let seen: Arc<Mutex<Vec<(Option<String>, Option<String>)>>> = Arc::new(Mutex::new(Vec::new()));
+    let recorder = Arc::clone(&seen);
+    let echo = move |request: tonic::Request<()>| {
+        let header = |name: &str| {
+            request
+                .metadata()
+                .get(name)
+                .map(|v| v.to_str().unwrap().to_string())
+        };
+        recorder
+            .lock()
+            .unwrap()
+            .push((header("x-tenant-id"), header("x-trace-id")));
+        Ok::<_, tonic::Status>(request)
+    };
+
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let port = listener.local_addr().unwrap().port();
+    drop(listener);
+    let addr_str = format!("127.0.0.1:{}", port);
+    let addr = format!("http://{}", addr_str);
+    tokio::spawn(async move {
+        Server::builder()
+            .add_service(KeystoneDbServer::with_interceptor(service, echo))
+            .serve(addr_str.parse().unwrap())
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(200)).await;
+
+    let mut client = Client::connect(addr)
+        .await
+        .unwrap()
+        .with_metadata("x-tenant-id", "acme")
+        .unwrap();
+
+    client.get(b"user#1").await.unwrap();
+    client
+        .clone()
+        .with_metadata("x-trace-id", "trace-42")
+        .unwrap()
+        .get(b"user#1")
+        .await
+        .unwrap();
+    client.get(b"user#1").await.unwrap();
+
+    let seen = seen.lock().unwrap().clone();
+    assert_eq!(
+        seen,
+        vec![
+            (Some("acme".to_string()), None),
+            (Some("acme".to_string()), Some("trace-42".to_string())),
+            (Some("acme".to_string()), None),
+        ]
+    );
+
+    assert!(matches!(
+        client.clone().with_metadata("bad key", "v"),
+        Err(ClientError::InvalidArgument(_))
+    ));
+}
+
+#[tokio::test]
+async fn test_bearer_token_sent_and_plaintext_refused() {
+    use std::net::TcpListener;
+    use std::sync::{Arc, Mutex};
+
+    let dir = TempDir::new().unwrap();
+    let service = KeystoneService::new(Database::create(dir.path()).unwrap());
+    let seen: Arc<Mutex<Vec<Option<String>>>> = Arc::new(Mutex::new(Vec::new()));
+    let recorder = Arc::clone(&seen);
+    let echo = move |request: tonic::Request<()>| {
+        let authorization = request
+            .metadata()
+            .get("authorization")
+            .map(|v| v.to_str().unwrap().to_string());
+        recorder.lock().unwrap().push(authorization);
+        Ok::<_, tonic::Status>(request)
+    };
+
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let port = listener.local_addr().unwrap().port();
+    drop(listener);
+    let addr_str = format!("127.0.0.1:{}", port);
+    let addr = format!("http://{}", addr_str);
+    tokio::spawn(async move {
+        Server::builder()
+            .add_service(KeystoneDbServer::with_interceptor(service, echo))
+            .serve(addr_str.parse().unwrap())
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(200)).await;
+
+    // Plaintext connection: credentials refused unless explicitly allowed
+    let refused = Client::connect(addr.clone()).await.unwrap().with_bearer_token("secret");
+    assert!(matches!(refused, Err(ClientError::InvalidArgument(_))));
+
+    let mut client = Client::connect(addr)
+        .await
+        .unwrap()
+        .allow_insecure_credentials()
+        .with_bearer_token("secret")
+        .unwrap();
+    client.get(b"user#1").await.unwrap();
+
+    assert_eq!(*seen.lock().unwrap(), vec![Some("Bearer secret".to_string())]);
+}
+
+#[tokio::test]
+async fn test_scan_channel() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for i in 1..=10 {
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        client.put(format!("item#{}", i).as_bytes(), item).await.unwrap();
+    }
+
+    let (mut items, done) = client.scan_channel(RemoteScan::new());
+    let mut received = 0;
+    while items.recv().await.is_some() {
+        received += 1;
+    }
+    assert_eq!(received, 10);
+    done.await.unwrap().unwrap();
+}
+
+#[tokio::test]
+async fn test_user_agent_sent() {
+    use kstone_client::{ConnectOptions, DEFAULT_USER_AGENT};
+    use std::net::TcpListener;
+    use std::sync::{Arc, Mutex};
+
+    // Server that records the user-agent header of each request
+    let dir = TempDir::new().unwrap();
+    let service = KeystoneService::new(Database::create(dir.path()).unwrap());
+    let seen: Arc<Mutex<Vec<String>>> = Arc::new(Mutex::new(Vec::new()));
+    let recorder = Arc::clone(&seen);
+    let capture = move |request: tonic::Request<()>| {
+        if let Some(agent) = request.metadata().get("user-agent") {
+            recorder.lock().unwrap().push(agent.to_str().unwrap().to_string());
+        }
+        Ok::<_, tonic::Status>(request)
+    };
+
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let port = listener.local_addr().unwrap().port();
+    drop(listener);
+    let addr_str = format!("127.0.0.1:{}", port);
+    let addr = format!("http://{}", addr_str);
+    tokio::spawn(async move {
+        Server::builder()
+            .add_service(KeystoneDbServer::with_interceptor(service, capture))
+            .serve(addr_str.parse().unwrap())
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(200)).await;
+
+    let mut client = Client::connect(addr.clone()).await.unwrap();
+    client.get(b"user#1").await.unwrap();
+
+    let options = ConnectOptions::new().user_agent("billing-service/2.3.1");
+    let mut client = Client::connect_with_options(addr, options).await.unwrap();
+    client.get(b"user#1").await.unwrap();
+
+    let seen = seen.lock().unwrap();
+    assert_eq!(seen.len(), 2);
+    assert!(seen[0].starts_with(DEFAULT_USER_AGENT));
+    assert!(seen[1].starts_with("billing-service/2.3.1"));
+}
+
+#[tokio::test]
+async fn test_connect_srv_balances_across_targets() {
+    use kstone_client::{ConnectOptions, ResolveFuture, SrvResolver, SrvTarget};
+    use std::net::TcpListener;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::sync::Arc;
+
+    struct FakeResolver(Vec<SrvTarget>);
+
+    impl SrvResolver for FakeResolver {
+        fn resolve<'a>(&'a self, service: &'a str) -> ResolveFuture<'a> {
+            assert_eq!(service, "_kstone._tcp.test");
+            Box::pin(async move { Ok(self.0.clone()) })
+        }
+    }
+
+    let mut targets = Vec::new();
+    let mut counters = Vec::new();
+    let mut dirs = Vec::new();
+    for _ in 0..2 {
+        let dir = TempDir::new().unwrap();
+        let service = KeystoneService::new(Database::create(dir.path()).unwrap());
+        dirs.push(dir);
+
+        let calls = Arc::new(AtomicUsize::new(0));
+        let counter = Arc::clone(&calls);
+        let count = move |request: tonic::Request<()>| {
+            counter.fetch_add(1, Ordering::SeqCst);
+            Ok::<_, tonic::Status>(request)
+        };
+        counters.push(calls);
+
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let port = listener.local_addr().unwrap().port();
+        drop(listener);
+        targets.push(SrvTarget::new("127.0.0.1", port));
+        tokio::spawn(async move {
+            Server::builder()
+                .add_service(KeystoneDbServer::with_interceptor(service, count))
+                .serve(format!("127.0.0.1:{}", port).parse().unwrap())
+                .await
+                .unwrap();
+        });
+    }
+    sleep(Duration::from_millis(200)).await;
+
+    let mut client = Client::connect_srv("_kstone._tcp.test", FakeResolver(targets), ConnectOptions::new())
+        .await
+        .unwrap();
+    for _ in 0..40 {
+        client.get(b"user#1").await.unwrap();
+    }
+
+    for calls in &counters {
+        assert!(calls.load(Ordering::SeqCst) > 0);
+    }
+}
+
+#[tokio::test]
+async fn test_scan_all_stops_at_max_items() {
+    use kstone_client::ResultLimits;
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for i in 1..=25 {
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        client.put(format!("item#{:02}", i).as_bytes(), item).await.unwrap();
+    }
+
+    let scan = RemoteScan::new().limit(4);
+    let (first, last_key) = match client.scan_all(scan.clone(), ResultLimits::new().max_items(10)).await {
+        Err(ClientError::LimitExceeded { items, last_key, .. }) => (items, last_key),
+        other => panic!("expected LimitExceeded, got {:?}", other),
+    };
+    assert_eq!(first.len(), 10);
+
+    let (pk, sk) = last_key;
+    let rest = client
+        .scan_all(scan.start_after(&pk, sk.as_deref()), ResultLimits::new())
+        .await
+        .unwrap();
+    assert_eq!(rest.len(), 15);
+
+    let mut ids: Vec<_> = first.iter().chain(&rest).map(|item| item["id"].clone()).collect();
+    ids.sort_by_key(|id| match id {
+        Value::N(n) => n.parse::<u32>().unwrap(),
+        _ => unreachable!(),
+    });
+    ids.dedup();
+    assert_eq!(ids.len(), 25);
+}
+
+#[tokio::test]
+async fn test_item_too_large_error() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("data".to_string(), Value::S("x".repeat(500 * 1024)));
+    match client.put(b"big", item).await {
+        Err(ClientError::ItemTooLarge { size, limit }) => {
+            assert_eq!(size, 4 + 500 * 1024);
+            assert_eq!(limit, 400 * 1024);
+        }
+        other => panic!("expected ItemTooLarge, got {:?}", other),
+    }
+}
+
+#[tokio::test]
+async fn test_scan_from_cursor_resumes_without_gaps() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for i in 1..=20 {
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        client.put(format!("item#{:02}", i).as_bytes(), item).await.unwrap();
+    }
+
+    let mut first = Vec::new();
+    let mut pages = client.scan_pages(RemoteScan::new().limit(5));
+    for _ in 0..2 {
+        first.extend(pages.next_page().await.unwrap().unwrap().items);
+    }
+    let cursor = pages.cursor();
+    drop(pages);
+    assert_eq!(first.len(), 10);
+
+    // A fresh iterator picks up after the last page returned
+    let mut rest = Vec::new();
+    let mut resumed = client.scan_from_cursor(RemoteScan::new().limit(5), &cursor).unwrap();
+    while let Some(page) = resumed.next_page().await.unwrap() {
+        rest.extend(page.items);
+    }
+    assert_eq!(rest.len(), 10);
+    assert!(resumed.next_page().await.unwrap().is_none());
+
+    let mut ids: Vec<u32> = first
+        .iter()
+        .chain(&rest)
+        .map(|item| match &item["id"] {
+            Value::N(n) => n.parse().unwrap(),
+            _ => unreachable!(),
+        })
+        .collect();
+    ids.sort();
+    assert_eq!(ids, (1..=20).collect::<Vec<u32>>());
+
+    assert!(matches!(
+        client.scan_from_cursor(RemoteScan::new(), &[0xff, 0]),
+        Err(ClientError::InvalidArgument(_))
+    ));
+}
+
+#[tokio::test]
+async fn test_import_from_embedded_export() {
+    let embedded = Database::create_in_memory().unwrap();
+    for i in 0..60 {
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        item.insert("tag".to_string(), Value::B(bytes::Bytes::from(vec![i as u8])));
+        embedded.put_with_sk(format!("user#{}", i % 7).as_bytes(), format!("event#{:02}", i).as_bytes(), item).unwrap();
+    }
+    let mut export = Vec::new();
+    assert_eq!(embedded.export_to(&mut export).unwrap(), 60);
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let stats = client.import_from(export.as_slice()).await.unwrap();
+    assert_eq!(stats.items, 60);
+    assert_eq!(stats.batches, 3);
+
+    let imported = client.scan_all(RemoteScan::new(), Default::default()).await.unwrap();
+    assert_eq!(imported.len(), 60);
+
+    let item = client.get_with_sk(b"user#3", b"event#10").await.unwrap().unwrap();
+    assert_eq!(item.get("id"), Some(&Value::N("10".to_string())));
+    assert_eq!(item.get("tag"), Some(&Value::B(bytes::Bytes::from(vec![10u8]))));
+}
+
+#[tokio::test]
+async fn test_consistent_hash_pool_routes_by_key() {
+    use kstone_client::{ConnectOptions, ConnectPool, ConsistentHashRouter};
+
+    let mut servers = Vec::new();
+    for _ in 0..3 {
+        servers.push(start_test_server().await);
+    }
+    let addrs: Vec<String> = servers.iter().map(|(_, addr, _)| addr.clone()).collect();
+    let pool = ConnectPool::connect(addrs.clone(), ConnectOptions::new())
+        .await
+        .unwrap()
+        .with_router(ConsistentHashRouter::new());
+
+    // The same key always goes to the same endpoint
+    assert_eq!(pool.endpoint_for(b"user#1"), pool.endpoint_for(b"user#1"));
+    let mut item = HashMap::new();
+    item.insert("name".to_string(), Value::S("Alice".to_string()));
+    pool.for_key(b"user#1").put(b"user#1", item).await.unwrap();
+    assert!(pool.for_key(b"user#1").get(b"user#1").await.unwrap().is_some());
+    assert!(pool.for_key(b"user#1").get(b"user#1").await.unwrap().is_some());
+
+    // Different keys spread across endpoints, each stored only where it was routed
+    let mut used = std::collections::HashSet::new();
+    for i in 0..30 {
+        let pk = format!("user#{}", i);
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        pool.for_key(pk.as_bytes()).put(pk.as_bytes(), item).await.unwrap();
+        used.insert(pool.endpoint_for(pk.as_bytes()).to_string());
+    }
+    assert!(used.len() >= 2);
+
+    for addr in &addrs {
+        let mut direct = Client::connect(addr.clone()).await.unwrap();
+        for i in 0..30 {
+            let pk = format!("user#{}", i);
+            let found = direct.get(pk.as_bytes()).await.unwrap().is_some();
+            assert_eq!(found, pool.endpoint_for(pk.as_bytes()) == addr);
+        }
+    }
+}
+
+#[tokio::test]
+async fn test_transact_write_chunked_compensates_on_failure() {
+    use kstone_client::ChunkOptions;
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut original = HashMap::new();
+    original.insert("v".to_string(), Value::S("original".to_string()));
+    client.put(b"key#1", original.clone()).await.unwrap();
+
+    let write = |v: &str| {
+        let mut item = HashMap::new();
+        item.insert("v".to_string(), Value::S(v.to_string()));
+        item
+    };
+    // Chunks of two: [0, 1], [2, 3], [4, missing-check]
+    let request = RemoteTransactWriteRequest::new()
+        .put(b"key#0", write("new"))
+        .put(b"key#1", write("new"))
+        .put(b"key#2", write("new"))
+        .delete(b"key#1")
+        .put(b"key#4", write("new"))
+        .condition_check(b"missing", "attribute_exists(v)");
+
+    let result = client
+        .transact_write_chunked(request, ChunkOptions::new().chunk_size(2))
+        .await;
+    assert!(matches!(result, Err(ClientError::TransactionCanceled { .. })), "{:?}", result.err());
+
+    // The first two chunks were rolled back; the third never committed
+    assert!(client.get(b"key#0").await.unwrap().is_none());
+    assert_eq!(client.get(b"key#1").await.unwrap(), Some(original));
+    assert!(client.get(b"key#2").await.unwrap().is_none());
+    assert!(client.get(b"key#4").await.unwrap().is_none());
+}
+
+#[tokio::test]
+async fn test_query_live_pushes_appended_items() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr.clone()).await.unwrap();
+
+    let event = |n: u32| {
+        let mut item = HashMap::new();
+        item.insert("n".to_string(), Value::N(n.to_string()));
+        item
+    };
+    client.put_with_sk(b"log", b"event#1", event(1)).await.unwrap();
+
+    let mut live = client
+        .query_live(RemoteQuery::new(b"log").sk_begins_with(b"event#"))
+        .await
+        .unwrap();
+    let timeout = Duration::from_secs(5);
+    let first = tokio::time::timeout(timeout, live.next()).await.unwrap().unwrap().unwrap();
+    assert_eq!(first, event(1));
+    assert!(live.is_caught_up());
+
+    // Append on another connection
+    let mut writer = Client::connect(addr).await.unwrap();
+    writer.put_with_sk(b"log", b"event#2", event(2)).await.unwrap();
+    writer.put_with_sk(b"other", b"event#1", event(9)).await.unwrap();
+    writer.put_with_sk(b"log", b"event#3", event(3)).await.unwrap();
+
+    let second = tokio::time::timeout(timeout, live.next()).await.unwrap().unwrap().unwrap();
+    let third = tokio::time::timeout(timeout, live.next()).await.unwrap().unwrap().unwrap();
+    assert_eq!(second, event(2));
+    assert_eq!(third, event(3));
+}
+
+#[tokio::test]
+async fn test_transact_get_with_condition_check() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for pk in [b"item#1", b"item#2"] {
+        let mut item = HashMap::new();
+        item.insert("name".to_string(), Value::S("x".to_string()));
+        client.put(pk, item).await.unwrap();
+    }
+    let mut flag = HashMap::new();
+    flag.insert("enabled".to_string(), Value::Bool(true));
+    client.put(b"config", flag).await.unwrap();
+
+    let request = || {
+        RemoteTransactGetRequest::new()
+            .get(b"item#1")
+            .get(b"item#2")
+            .condition_check(b"config", "enabled = :yes")
+            .value(":yes", Value::Bool(true))
+    };
+    let response = client.transact_get(request()).await.unwrap();
+    assert_eq!(response.items.len(), 2);
+    assert!(response.items.iter().all(Option::is_some));
+
+    let mut flag = HashMap::new();
+    flag.insert("enabled".to_string(), Value::Bool(false));
+    client.put(b"config", flag).await.unwrap();
+
+    match client.transact_get(request()).await {
+        Err(ClientError::ConditionCheckFailed(_)) => {}
+        other => panic!("expected ConditionCheckFailed, got {:?}", other.map(|r| r.items)),
+    }
+}
+
+#[tokio::test]
+async fn test_raw_call_matches_typed_get() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("name".to_string(), Value::S("Alice".to_string()));
+    item.insert("age".to_string(), Value::N("30".to_string()));
+    client.put(b"user#raw", item).await.unwrap();
+
+    let typed = client.get(b"user#raw").await.unwrap();
+
+    let request = kstone_proto::GetRequest {
+        partition_key: b"user#raw".to_vec(),
+        sort_key: None,
+        return_consumed_capacity: false,
+    };
+    let response: kstone_proto::GetResponse = client.raw_call("Get", request).await.unwrap();
+    let raw = response
+        .item
+        .map(|item| kstone_client::convert::proto_item_to_ks(item).unwrap());
+
+    assert!(typed.is_some());
+    assert_eq!(raw, typed);
+}
+
+#[tokio::test]
+async fn test_read_cache_hits_and_invalidates_on_put() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let config = kstone_client::ReadCacheConfig {
+        ttl: Duration::from_secs(60),
+        ..Default::default()
+    };
+    let mut client = Client::connect(addr.clone()).await.unwrap().with_read_cache(config);
+    let mut other = Client::connect(addr).await.unwrap();
+
+    let named = |name: &str| {
+        let mut item = HashMap::new();
+        item.insert("name".to_string(), Value::S(name.to_string()));
+        item
+    };
+
+    client.put(b"user#1", named("Alice")).await.unwrap();
+    assert_eq!(client.get(b"user#1").await.unwrap(), Some(named("Alice")));
+
+    // Changed behind the cache's back: the second read never reaches the server
+    other.put(b"user#1", named("Mallory")).await.unwrap();
+    assert_eq!(client.get(b"user#1").await.unwrap(), Some(named("Alice")));
+    let stats = client.read_cache_stats().unwrap();
+    assert_eq!((stats.hits, stats.misses), (1, 1));
+
+    // A write through the caching client invalidates the partition
+    client.put(b"user#1", named("Bob")).await.unwrap();
+    assert_eq!(client.get(b"user#1").await.unwrap(), Some(named("Bob")));
+    assert_eq!(client.read_cache_stats().unwrap().misses, 2);
+
+    // Missing items are not cached by default
+    assert_eq!(client.get(b"user#2").await.unwrap(), None);
+    other.put(b"user#2", named("Carol")).await.unwrap();
+    assert_eq!(client.get(b"user#2").await.unwrap(), Some(named("Carol")));
+}
+
+#[tokio::test]
+async fn test_batch_write_conditional_put_rejected_alone() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let versioned = |version: i64| {
+        let mut item = HashMap::new();
+        item.insert("version".to_string(), Value::number(version));
+        item
+    };
+    client.put(b"doc#1", versioned(1)).await.unwrap();
+    client.put(b"doc#2", versioned(5)).await.unwrap();
+    client.put(b"doc#3", versioned(1)).await.unwrap();
+
+    // Upsert-if-newer: doc#2 already holds a later version
+    let batch = RemoteBatchWriteRequest::new()
+        .put_conditional(b"doc#1", versioned(2), cond::lt("version", Value::number(2)))
+        .put_conditional(b"doc#2", versioned(2), cond::lt("version", Value::number(2)))
+        .put_conditional(b"doc#3", versioned(2), cond::lt("version", Value::number(2)));
+
+    let response = client.batch_write(batch).await.unwrap();
+    assert!(response.success);
+    assert_eq!(response.rejected, vec![1]);
+
+    assert_eq!(client.get(b"doc#1").await.unwrap().unwrap()["version"], Value::number(2));
+    assert_eq!(client.get(b"doc#2").await.unwrap().unwrap()["version"], Value::number(5));
+    assert_eq!(client.get(b"doc#3").await.unwrap().unwrap()["version"], Value::number(2));
+}
+
+#[tokio::test]
+async fn test_write_stream_acks_every_write() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let (mut sender, mut acks) = client.write_stream().await.unwrap().into_split();
+    let producer = tokio::spawn(async move {
+        for i in 0..10_000 {
+            let mut item = HashMap::new();
+            item.insert("n".to_string(), Value::number(i));
+            sender.send(WriteOp::put(format!("ws#{:05}", i).as_bytes(), item)).await.unwrap();
+        }
+        sender.close();
+    });
+
+    let mut expected_id = 0;
+    while let Some(ack) = acks.recv().await {
+        let ack = ack.unwrap();
+        assert_eq!(ack.id, expected_id);
+        assert!(ack.result.is_ok(), "write {} failed: {:?}", ack.id, ack.result);
+        expected_id += 1;
+    }
+    producer.await.unwrap();
+    assert_eq!(expected_id, 10_000);
+
+    let item = client.get(b"ws#09999").await.unwrap().unwrap();
+    assert_eq!(item["n"], Value::number(9999));
+}
+
+/// Server middleware that slows every call down and records the most
+/// calls seen running at once
+#[derive(Clone)]
+struct SlowCounting<S> {
+    inner: S,
+    current: std::sync::Arc<std::sync::atomic::AtomicUsize>,
+    peak: std::sync::Arc<std::sync::atomic::AtomicUsize>,
+}
+
+impl<S, R> tower::Service<R> for SlowCounting<S>
+where
+    S: tower::Service<R> + Clone + Send + 'static,
+    S::Response: Send,
+    S::Error: Send,
+    S::Future: Send,
+    R: Send + 'static,
+{
+    type Response = S::Response;
+    type Error = S::Error;
+    type Future = std::pin::Pin<Box<dyn std::future::Future<Output = Result<S::Response, S::Error>> + Send>>;
+
+    fn poll_ready(&mut self, cx: &mut std::task::Context<'_>) -> std::task::Poll<Result<(), S::Error>> {
+        self.inner.poll_ready(cx)
+    }
+
+    fn call(&mut self, request: R) -> Self::Future {
+        use std::sync::atomic::Ordering;
+
+        // Use the service that was polled ready, leaving a clone behind
+        let clone = self.inner.clone();
+        let mut inner = std::mem::replace(&mut self.inner, clone);
+        let (current, peak) = (self.current.clone(), self.peak.clone());
+        Box::pin(async move {
+            let running = current.fetch_add(1, Ordering::SeqCst) + 1;
+            peak.fetch_max(running, Ordering::SeqCst);
+            sleep(Duration::from_millis(50)).await;
+            let response = inner.call(request).await;
+            current.fetch_sub(1, Ordering::SeqCst);
+            response
+        })
+    }
+}
+
+#[tokio::test]
+async fn test_max_concurrent_requests_caps_calls_in_flight() {
+    use kstone_client::WhenSaturated;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::sync::Arc;
+
+    let dir = TempDir::new().unwrap();
+    let service = KeystoneService::new(Database::create(dir.path()).unwrap());
+    let peak = Arc::new(AtomicUsize::new(0));
+    let layer = {
+        let peak = Arc::clone(&peak);
+        let current = Arc::new(AtomicUsize::new(0));
+        tower::layer::layer_fn(move |inner| SlowCounting {
+            inner,
+            current: Arc::clone(&current),
+            peak: Arc::clone(&peak),
+        })
+    };
+
+    let listener = std::net::TcpListener::bind("127.0.0.1:0").unwrap();
+    let addr = listener.local_addr().unwrap();
+    drop(listener);
+    tokio::spawn(async move {
+        Server::builder()
+            .layer(layer)
+            .add_service(KeystoneDbServer::new(service))
+            .serve(addr)
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(200)).await;
+
+    let client = Client::connect(format!("http://{}", addr))
+        .await
+        .unwrap()
+        .with_max_concurrent_requests(3, WhenSaturated::Wait);
+
+    let tasks: Vec<_> = (0..12)
+        .map(|i| {
+            let mut client = client.clone();
+            tokio::spawn(async move { client.get(format!("slow#{}", i).as_bytes()).await })
+        })
+        .collect();
+    for task in tasks {
+        assert!(task.await.unwrap().unwrap().is_none());
+    }
+
+    let peak = peak.load(Ordering::SeqCst);
+    assert!(peak <= 3, "{} calls ran at once", peak);
+    assert!(peak >= 2, "calls did not overlap at all");
+
+    // Failing fast instead of waiting
+    let mut strict = Client::connect(format!("http://{}", addr))
+        .await
+        .unwrap()
+        .with_max_concurrent_requests(1, WhenSaturated::Fail);
+    let mut holder = strict.clone();
+    let running = tokio::spawn(async move { holder.get(b"slow#held").await });
+    sleep(Duration::from_millis(10)).await;
+    assert!(matches!(strict.get(b"slow#other").await, Err(ClientError::ResourceExhausted(_))));
+    running.await.unwrap().unwrap();
+    assert!(strict.get(b"slow#after").await.is_ok());
+}
+
+#[tokio::test]
+async fn test_export_to_round_trips_through_import() {
+    use kstone_client::RemoteExport;
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+    for i in 0..25 {
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        client.put_with_sk(format!("user#{}", i % 3).as_bytes(), format!("event#{:02}", i).as_bytes(), item).await.unwrap();
+    }
+
+    let mut export = Vec::new();
+    let stats = client.export_to(RemoteExport::new().chunk_size(4), &mut export).await.unwrap();
+    assert_eq!(stats.manifest.items, 25);
+    assert_eq!(stats.chunks, 7);
+    assert_eq!(stats.manifest.bytes, export.len() as u64);
+
+    // A key range covers one partition
+    let mut partition = Vec::new();
+    let export_range = RemoteExport::new().from_key(b"user#1", None).until_key(b"user#2", None);
+    let stats = client.export_to(export_range, &mut partition).await.unwrap();
+    assert_eq!(stats.manifest.items, 8);
+
+    let (_dir2, addr2, _handle2) = start_test_server().await;
+    let mut restored = Client::connect(addr2).await.unwrap();
+    assert_eq!(restored.import_from(export.as_slice()).await.unwrap().items, 25);
+    let item = restored.get_with_sk(b"user#2", b"event#17").await.unwrap().unwrap();
+    assert_eq!(item.get("id"), Some(&Value::N("17".to_string())));
+}
+
+#[tokio::test]
+async fn test_migrate_attribute_renames_and_reruns_safely() {
+    use kstone_client::RemoteExport;
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+    for i in 0..30 {
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        // Every third item never had the attribute
+        if i % 3 != 0 {
+            item.insert("status".to_string(), Value::S(format!("s{}", i)));
+        }
+        client.put_with_sk(b"orders", format!("order#{:02}", i).as_bytes(), item).await.unwrap();
+    }
+
+    let stats = client
+        .migrate_attribute(RemoteExport::new().chunk_size(7), "status", "state")
+        .await
+        .unwrap();
+    assert_eq!(stats.scanned, 30);
+    assert_eq!(stats.migrated, 20);
+    assert_eq!(stats.skipped, 10);
+
+    for i in 0..30 {
+        let item = client.get_with_sk(b"orders", format!("order#{:02}", i).as_bytes()).await.unwrap().unwrap();
+        assert!(!item.contains_key("status"));
+        if i % 3 != 0 {
+            assert_eq!(item.get("state"), Some(&Value::S(format!("s{}", i))));
+        } else {
+            assert!(!item.contains_key("state"));
+        }
+    }
+
+    // A rerun finds nothing left to do
+    let stats = client.migrate_attribute(RemoteExport::new(), "status", "state").await.unwrap();
+    assert_eq!(stats.migrated, 0);
+    assert_eq!(stats.skipped, 30);
+}
+
+#[tokio::test]
+async fn test_string_set_membership_updates() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("tags".to_string(), Value::string_set(["a", "b"]));
+    client.put(b"post#1", item).await.unwrap();
+
+    let update = UpdateExpr::new()
+        .add("tags", Value::string_set(["b", "c"]))
+        .delete("tags", Value::string_set(["a"]));
+    client.update(RemoteUpdate::new(b"post#1").update_expr(update)).await.unwrap();
+
+    let item = client.get(b"post#1").await.unwrap().unwrap();
+    assert_eq!(item.get("tags"), Some(&Value::string_set(["b", "c"])));
+}
+
+#[tokio::test]
+async fn test_query_pagination_metadata() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for i in 0..6 {
+        let mut item = HashMap::new();
+        let status = if i % 2 == 0 { "open" } else { "closed" };
+        item.insert("status".to_string(), Value::string(status));
+        client
+            .put_with_sk(b"org#acme", format!("ticket#{}", i).as_bytes(), item)
+            .await
+            .unwrap();
+    }
+
+    let open = || {
+        RemoteQuery::new(b"org#acme")
+            .filter("#s = :open")
+            .name("#s", "status")
+            .value(":open", Value::string("open"))
+            .limit(4)
+    };
+
+    // The limit caps items examined; the filter then drops some of them
+    let first = client.query(open()).await.unwrap();
+    assert_eq!(first.scanned_count, 4);
+    assert_eq!(first.count, 2);
+    assert!(first.scanned_count > first.count);
+    assert!(first.has_more());
+
+    let (pk, sk) = first.last_key.clone().unwrap();
+    let second = client.query(open().start_after(&pk, sk.as_deref())).await.unwrap();
+    assert_eq!(second.scanned_count, 2);
+    assert_eq!(second.count, 1);
+    assert!(!second.has_more());
+}
+
+#[tokio::test]
+async fn test_bulk_rate_limit_paces_import_and_parallel_scan() {
+    let embedded = Database::create_in_memory().unwrap();
+    for i in 0..60 {
+        let mut item = HashMap::new();
+        item.insert("id".to_string(), Value::N(i.to_string()));
+        embedded.put(format!("item#{}", i).as_bytes(), item).unwrap();
+    }
+    let mut export = Vec::new();
+    embedded.export_to(&mut export).unwrap();
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap().with_bulk_rate_limit(100.0).unwrap();
+    assert!(client.clone().with_bulk_rate_limit(0.0).is_err());
+
+    // 60 items at 100 per second, less the one-item burst
+    let started = std::time::Instant::now();
+    let stats = client.import_from(export.as_slice()).await.unwrap();
+    assert_eq!(stats.items, 60);
+    assert!(started.elapsed() >= Duration::from_millis(550), "{:?}", started.elapsed());
+
+    // The scan pays for its pages as it goes, across all segments
+    let seen = std::sync::Arc::new(std::sync::atomic::AtomicUsize::new(0));
+    let counter = seen.clone();
+    let started = std::time::Instant::now();
+    client
+        .parallel_scan(RemoteScan::new().limit(10), 3, move |_item| {
+            counter.fetch_add(1, std::sync::atomic::Ordering::Relaxed);
+            Ok(())
+        })
+        .await
+        .unwrap();
+    assert_eq!(seen.load(std::sync::atomic::Ordering::Relaxed), 60);
+    assert!(started.elapsed() >= Duration::from_millis(400), "{:?}", started.elapsed());
+}
+
+#[tokio::test]
+async fn test_execute_statement_decodes_rows() {
+    #[derive(Debug, PartialEq, serde::Deserialize)]
+    struct Player {
+        name: String,
+        score: i64,
+        #[serde(rename = "winRate")]
+        win_rate: f64,
+        active: bool,
+        badges: Vec<String>,
+        team: Option<String>,
+    }
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for (sk, name, score) in [("p1", "Alice", 120), ("p2", "Bob", 95)] {
+        let mut item = HashMap::new();
+        item.insert("name".to_string(), Value::string(name));
+        item.insert("score".to_string(), Value::number(score));
+        item.insert("winRate".to_string(), Value::number(0.5));
+        item.insert("active".to_string(), Value::Bool(true));
+        item.insert("badges".to_string(), Value::string_set(["gold"]));
+        client.put_with_sk(b"league#1", sk.as_bytes(), item).await.unwrap();
+    }
+
+    let response = client
+        .execute_statement("SELECT * FROM players WHERE pk = 'league#1'")
+        .await
+        .unwrap();
+    let players: Vec<Player> = response.decode().unwrap();
+    assert_eq!(players.len(), 2);
+    assert_eq!(
+        players[0],
+        Player {
+            name: "Alice".to_string(),
+            score: 120,
+            win_rate: 0.5,
+            active: true,
+            badges: vec!["gold".to_string()],
+            team: None,
+        }
+    );
+    assert_eq!(players[1].score, 95);
+
+    // Several rows are an error for decode_one; none is not
+    assert!(matches!(response.decode_one::<Player>(), Err(ClientError::InvalidArgument(_))));
+    let response = client
+        .execute_statement("SELECT * FROM players WHERE pk = 'league#2'")
+        .await
+        .unwrap();
+    assert_eq!(response.decode_one::<Player>().unwrap(), None);
+}
+
+#[tokio::test]
+async fn test_explain_reports_index_use_and_scans() {
+    use kstone_client::PlanOperation;
+    use kstone_core::index::{LocalSecondaryIndex, TableSchema};
+
+    let dir = TempDir::new().unwrap();
+    let schema = TableSchema::new().add_local_index(LocalSecondaryIndex::new("email_index", "email"));
+    let service = KeystoneService::new(Database::create_with_schema(dir.path(), schema).unwrap());
+
+    let listener = std::net::TcpListener::bind("127.0.0.1:0").unwrap();
+    let addr = listener.local_addr().unwrap();
+    drop(listener);
+    tokio::spawn(async move {
+        Server::builder()
+            .add_service(KeystoneDbServer::new(service))
+            .serve(addr)
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(100)).await;
+
+    let mut client = Client::connect(format!("http://{}", addr)).await.unwrap();
+    for i in 0..3 {
+        let mut item = HashMap::new();
+        item.insert("email".to_string(), Value::S(format!("user{}@example.com", i)));
+        item.insert("age".to_string(), Value::N((20 + i).to_string()));
+        client
+            .put_with_sk(b"org#acme", format!("user#{}", i).as_bytes(), item)
+            .await
+            .unwrap();
+    }
+
+    // A key condition on an index reads through the index
+    let plan = client
+        .explain("SELECT * FROM users.email_index WHERE pk = 'org#acme'")
+        .await
+        .unwrap();
+    assert_eq!(plan.operation, PlanOperation::Query);
+    assert!(plan.uses_index());
+    assert_eq!(plan.index_name.as_deref(), Some("email_index"));
+    assert_eq!(plan.estimated_items, 3);
+
+    // A filter on a non-key attribute scans the table
+    let plan = client.explain("SELECT * FROM users WHERE age > 21").await.unwrap();
+    assert!(plan.is_full_scan());
+    assert!(!plan.uses_index());
+    assert_eq!(plan.estimated_items, 3);
+
+    // Explaining a write does not run it
+    let plan = client
+        .explain("DELETE FROM users WHERE pk = 'org#acme' AND sk = 'user#0'")
+        .await
+        .unwrap();
+    assert_eq!(plan.operation, PlanOperation::Write);
+    assert!(client.get_with_sk(b"org#acme", b"user#0").await.unwrap().is_some());
+}
+
+#[tokio::test]
+async fn test_prepared_statement_runs_with_different_parameters() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let client = Client::connect(addr).await.unwrap();
+
+    let mut insert = client
+        .prepare("INSERT INTO players VALUE {'pk': ?, 'name': ?, 'score': ?}")
+        .await
+        .unwrap();
+    assert_eq!(insert.parameter_count(), 3);
+    for i in 0..5 {
+        insert
+            .execute(&[
+                Value::string(format!("player#{}", i)),
+                Value::string(format!("Player {}", i)),
+                Value::number(i * 10),
+            ])
+            .await
+            .unwrap();
+    }
+
+    let mut lookup = client.prepare("SELECT * FROM players WHERE pk = ?").await.unwrap();
+    assert_eq!(lookup.sql(), "SELECT * FROM players WHERE pk = ?");
+    for i in 0..5 {
+        let response = lookup
+            .execute(&[Value::string(format!("player#{}", i))])
+            .await
+            .unwrap();
+        match response {
+            RemoteExecuteStatementResponse::Select { items, .. } => {
+                assert_eq!(items.len(), 1);
+                assert_eq!(items[0].get("name").unwrap().as_string().unwrap(), format!("Player {}", i));
+                assert_eq!(items[0].get("score"), Some(&Value::N((i * 10).to_string())));
+            }
+            other => panic!("Expected Select response, got {:?}", other),
+        }
+    }
+
+    // The wrong number of parameters fails without reaching the server
+    let err = lookup.execute(&[]).await.unwrap_err();
+    assert!(matches!(err, ClientError::InvalidArgument(_)), "{:?}", err);
+
+    // Unparsable statements fail at prepare time
+    assert!(client.prepare("SELECT FROM WHERE").await.is_err());
+}
+
+#[tokio::test]
+async fn test_idle_connection_is_replaced_before_next_call() {
+    use kstone_client::ConnectOptions;
+    use std::net::TcpListener;
+    use std::sync::{Arc, Mutex};
+
+    let dir = TempDir::new().unwrap();
+    let db = Database::create(dir.path()).unwrap();
+    let service = KeystoneService::new(db);
+
+    // Record the client address of every call to tell connections apart
+    let peers = Arc::new(Mutex::new(Vec::new()));
+    let recorder = Arc::clone(&peers);
+    let capture = move |request: tonic::Request<()>| {
+        recorder.lock().unwrap().push(request.remote_addr().unwrap());
+        Ok::<_, tonic::Status>(request)
+    };
+
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let port = listener.local_addr().unwrap().port();
+    drop(listener);
+    let addr_str = format!("127.0.0.1:{}", port);
+    let addr = format!("http://{}", addr_str);
+    tokio::spawn(async move {
+        Server::builder()
+            .add_service(KeystoneDbServer::with_interceptor(service, capture))
+            .serve(addr_str.parse().unwrap())
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(200)).await;
+
+    let options = ConnectOptions::new()
+        .keepalive_time(Duration::from_millis(50))
+        .keepalive_timeout(Duration::from_secs(1))
+        .idle_timeout(Duration::from_millis(300));
+    let mut client = Client::connect_with_options(addr, options).await.unwrap();
+
+    let mut item = HashMap::new();
+    item.insert("name".to_string(), Value::string("Alice"));
+    client.put(b"user#1", item).await.unwrap();
+    client.get(b"user#1").await.unwrap();
+
+    // Keepalive pings don't count as use; the idle timeout still applies
+    sleep(Duration::from_millis(800)).await;
+    let item = client.get(b"user#1").await.unwrap().unwrap();
+    assert_eq!(item.get("name").unwrap().as_string(), Some("Alice"));
+
+    let peers = peers.lock().unwrap();
+    assert_eq!(peers.len(), 3);
+    assert_eq!(peers[0], peers[1]);
+    assert_ne!(peers[1], peers[2]);
+}
+
+#[tokio::test]
+async fn test_request_id_reaches_server() {
+    use kstone_client::request_id::{with_request_id, REQUEST_ID_HEADER};
+    use std::net::TcpListener;
+    use std::sync::{Arc, Mutex};
+
+    let dir = TempDir::new().unwrap();
+    let db = Database::create(dir.path()).unwrap();
+    let service = KeystoneService::new(db);
+
+    let seen = Arc::new(Mutex::new(Vec::new()));
+    let recorder = Arc::clone(&seen);
+    let capture = move |request: tonic::Request<()>| {
+        let id = request
+            .metadata()
+            .get(REQUEST_ID_HEADER)
+            .map(|id| id.to_str().unwrap().to_string());
+        recorder.lock().unwrap().push(id);
+        Ok::<_, tonic::Status>(request)
+    };
+
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let port = listener.local_addr().unwrap().port();
+    drop(listener);
+    let addr_str = format!("127.0.0.1:{}", port);
+    let addr = format!("http://{}", addr_str);
+    tokio::spawn(async move {
+        Server::builder()
+            .add_service(KeystoneDbServer::with_interceptor(service, capture))
+            .serve(addr_str.parse().unwrap())
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(200)).await;
+
+    let mut client = Client::connect(addr.clone()).await.unwrap();
+    client.get(b"user#1").await.unwrap();
+    with_request_id("req-42", client.get(b"user#1")).await.unwrap();
+
+    let mut client = Client::connect(addr).await.unwrap().with_request_id_from(|| Some("req-fixed".to_string()));
+    client.get(b"user#1").await.unwrap();
+
+    let seen = seen.lock().unwrap();
+    assert_eq!(
+        *seen,
+        vec![None, Some("req-42".to_string()), Some("req-fixed".to_string())]
+    );
+}
+
+/// A server that rejects filters using `contains`, like one predating it
+struct NoContainsServer(KeystoneService);
+
+impl NoContainsServer {
+    fn check(filter: &Option<String>) -> Result<(), tonic::Status> {
+        match filter {
+            Some(filter) if filter.contains("contains(") => {
+                Err(tonic::Status::unimplemented("contains() is not supported in filters"))
+            }
+            _ => Ok(()),
+        }
+    }
+}
+
+#[tonic::async_trait]
+impl kstone_proto::keystone_db_server::KeystoneDb for NoContainsServer {
+    type ScanStream = <KeystoneService as kstone_proto::keystone_db_server::KeystoneDb>::ScanStream;
+    type QueryLiveStream = <KeystoneService as kstone_proto::keystone_db_server::KeystoneDb>::QueryLiveStream;
+    type ExportStream = <KeystoneService as kstone_proto::keystone_db_server::KeystoneDb>::ExportStream;
+    type WriteStreamStream = <KeystoneService as kstone_proto::keystone_db_server::KeystoneDb>::WriteStreamStream;
+
+    async fn put(&self, request: tonic::Request<kstone_proto::PutRequest>) -> Result<tonic::Response<kstone_proto::PutResponse>, tonic::Status> {
+        self.0.put(request).await
+    }
+
+    async fn get(&self, request: tonic::Request<kstone_proto::GetRequest>) -> Result<tonic::Response<kstone_proto::GetResponse>, tonic::Status> {
+        self.0.get(request).await
+    }
+
+    async fn delete(&self, request: tonic::Request<kstone_proto::DeleteRequest>) -> Result<tonic::Response<kstone_proto::DeleteResponse>, tonic::Status> {
+        self.0.delete(request).await
+    }
+
+    async fn query(&self, request: tonic::Request<kstone_proto::QueryRequest>) -> Result<tonic::Response<kstone_proto::QueryResponse>, tonic::Status> {
+        Self::check(&request.get_ref().filter_expression)?;
+        self.0.query(request).await
+    }
+
+    async fn scan(&self, request: tonic::Request<kstone_proto::ScanRequest>) -> Result<tonic::Response<Self::ScanStream>, tonic::Status> {
+        Self::check(&request.get_ref().filter_expression)?;
+        self.0.scan(request).await
+    }
+
+    async fn query_live(&self, request: tonic::Request<kstone_proto::QueryRequest>) -> Result<tonic::Response<Self::QueryLiveStream>, tonic::Status> {
+        self.0.query_live(request).await
+    }
+
+    async fn export(&self, request: tonic::Request<kstone_proto::ExportRequest>) -> Result<tonic::Response<Self::ExportStream>, tonic::Status> {
+        self.0.export(request).await
+    }
+
+    async fn batch_get(&self, request: tonic::Request<kstone_proto::BatchGetRequest>) -> Result<tonic::Response<kstone_proto::BatchGetResponse>, tonic::Status> {
+        self.0.batch_get(request).await
+    }
+
+    async fn batch_write(&self, request: tonic::Request<kstone_proto::BatchWriteRequest>) -> Result<tonic::Response<kstone_proto::BatchWriteResponse>, tonic::Status> {
+        self.0.batch_write(request).await
+    }
+
+    async fn write_stream(
+        &self,
+        request: tonic::Request<tonic::Streaming<kstone_proto::WriteStreamRequest>>,
+    ) -> Result<tonic::Response<Self::WriteStreamStream>, tonic::Status> {
+        self.0.write_stream(request).await
+    }
+
+    async fn delete_by_query(&self, request: tonic::Request<kstone_proto::QueryRequest>) -> Result<tonic::Response<kstone_proto::DeleteByQueryResponse>, tonic::Status> {
+        self.0.delete_by_query(request).await
+    }
+
+    async fn transact_get(&self, request: tonic::Request<kstone_proto::TransactGetRequest>) -> Result<tonic::Response<kstone_proto::TransactGetResponse>, tonic::Status> {
+        self.0.transact_get(request).await
+    }
+
+    async fn transact_write(&self, request: tonic::Request<kstone_proto::TransactWriteRequest>) -> Result<tonic::Response<kstone_proto::TransactWriteResponse>, tonic::Status> {
+        self.0.transact_write(request).await
+    }
+
+    async fn update(&self, request: tonic::Request<kstone_proto::UpdateRequest>) -> Result<tonic::Response<kstone_proto::UpdateResponse>, tonic::Status> {
+        self.0.update(request).await
+    }
+
+    async fn execute_statement(
+        &self,
+        request: tonic::Request<kstone_proto::ExecuteStatementRequest>,
+    ) -> Result<tonic::Response<kstone_proto::ExecuteStatementResponse>, tonic::Status> {
+        self.0.execute_statement(request).await
+    }
+
+    async fn explain(&self, request: tonic::Request<kstone_proto::ExplainRequest>) -> Result<tonic::Response<kstone_proto::ExplainResponse>, tonic::Status> {
+        self.0.explain(request).await
+    }
+
+    async fn prepare(&self, request: tonic::Request<kstone_proto::PrepareRequest>) -> Result<tonic::Response<kstone_proto::PrepareResponse>, tonic::Status> {
+        self.0.prepare(request).await
+    }
+}
+
+#[tokio::test]
+async fn test_rejected_filter_is_applied_on_the_client() {
+    use kstone_proto::keystone_db_server::KeystoneDbServer as MockServer;
+    use std::net::TcpListener;
+
+    let dir = TempDir::new().unwrap();
+    let service = NoContainsServer(KeystoneService::new(Database::create(dir.path()).unwrap()));
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let port = listener.local_addr().unwrap().port();
+    drop(listener);
+    let addr_str = format!("127.0.0.1:{}", port);
+    let client_addr = format!("http://{}", addr_str);
+    let server = tokio::spawn(async move {
+        Server::builder()
+            .add_service(MockServer::new(service))
+            .serve(addr_str.parse().unwrap())
+            .await
+            .unwrap();
+    });
+    sleep(Duration::from_millis(200)).await;
+
+    let mut client = Client::connect(client_addr).await.unwrap();
+    for (sk, tags) in [("a", "red,blue"), ("b", "green"), ("c", "blue")] {
+        let mut item = HashMap::new();
+        item.insert("tags".to_string(), Value::string(tags));
+        client.put_with_sk(b"org#1", sk.as_bytes(), item).await.unwrap();
+    }
+
+    let query = RemoteQuery::new(b"org#1")
+        .filter("contains(tags, :t)")
+        .value(":t", Value::string("blue"));
+    let response = client.query(query.clone()).await.unwrap();
+    assert_eq!(response.count, 2);
+    assert_eq!(response.scanned_count, 3);
+    assert!(response.items.iter().all(|item| match item.get("tags") {
+        Some(Value::S(tags)) => tags.contains("blue"),
+        _ => false,
+    }));
+
+    let counted = client.query(query.select_count()).await.unwrap();
+    assert_eq!(counted.count, 2);
+    assert!(counted.items.is_empty());
+
+    let scanned = client
+        .scan(RemoteScan::new().filter("contains(tags, :t)").value(":t", Value::string("green")))
+        .await
+        .unwrap();
+    assert_eq!(scanned.count, 1);
+
+    // A filter the client can't parse either still fails
+    let err = client.query(RemoteQuery::new(b"org#1").filter("(contains(tags, :t)")).await.unwrap_err();
+    assert!(matches!(err, ClientError::Unimplemented(_)), "{:?}", err);
+
+    server.abort();
+}
+
+#[tokio::test]
+async fn test_query_as_decodes_into_structs() {
+    #[derive(Debug, PartialEq, serde::Deserialize)]
+    struct Order {
+        total: f64,
+        status: String,
+        note: Option<String>,
+    }
+
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for (sk, total, status) in [("order#1", 25.5, "shipped"), ("order#2", 10.0, "open"), ("profile", 0.0, "-")] {
+        let mut item = HashMap::new();
+        item.insert("total".to_string(), Value::number(total));
+        item.insert("status".to_string(), Value::string(status));
+        client.put_with_sk(b"customer#42", sk.as_bytes(), item).await.unwrap();
+    }
+
+    let pk = String::from("customer#42");
+    let orders: Vec<Order> = client
+        .query_as(RemoteQuery::new(pk.as_bytes()).sk_begins_with("order#".as_bytes()))
+        .await
+        .unwrap();
+    assert_eq!(
+        orders,
+        vec![
+            Order { total: 25.5, status: "shipped".to_string(), note: None },
+            Order { total: 10.0, status: "open".to_string(), note: None },
+        ]
+    );
+
+    // An item that doesn't fit the struct fails the decode
+    let err = client
+        .query_as::<Vec<u8>>(RemoteQuery::new(b"customer#42"))
+        .await
+        .unwrap_err();
+    assert!(matches!(err, ClientError::InvalidArgument(_)), "{:?}", err);
+}
+
+#[tokio::test]
+async fn test_large_query_consumes_more_capacity_than_point_get() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for i in 0..20 {
+        let mut item = HashMap::new();
+        item.insert("body".to_string(), Value::string("x".repeat(2000)));
+        client
+            .put_with_sk(b"feed#1", format!("post#{:02}", i).as_bytes(), item)
+            .await
+            .unwrap();
+    }
+
+    let (item, get_capacity) = client
+        .get_with_consumed_capacity(b"feed#1", Some(b"post#00"))
+        .await
+        .unwrap();
+    assert!(item.is_some());
+    assert_eq!(get_capacity.read_units, 1);
+
+    let response = client
+        .query(RemoteQuery::new(b"feed#1").return_consumed_capacity(true))
+        .await
+        .unwrap();
+    assert_eq!(response.items.len(), 20);
+    let query_capacity = response.consumed_capacity.unwrap();
+    assert!(query_capacity.read_units > get_capacity.read_units, "{:?}", query_capacity);
+
+    // Capacity is only reported when asked for
+    let response = client.query(RemoteQuery::new(b"feed#1")).await.unwrap();
+    assert!(response.consumed_capacity.is_none());
+}
+
+#[tokio::test]
+async fn test_delete_by_query_removes_only_matching_items() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    for (sk, status) in [("job#1", "done"), ("job#2", "running"), ("job#3", "done"), ("job#4", "failed")] {
+        let mut item = HashMap::new();
+        item.insert("status".to_string(), Value::string(status));
+        client.put_with_sk(b"queue#1", sk.as_bytes(), item).await.unwrap();
+    }
+    let mut other = HashMap::new();
+    other.insert("status".to_string(), Value::string("done"));
+    client.put_with_sk(b"queue#2", b"job#1", other).await.unwrap();
+
+    let deleted = client
+        .delete_by_query(
+            RemoteQuery::new(b"queue#1")
+                .filter("#s = :done")
+                .name("#s", "status")
+                .value(":done", Value::string("done")),
+        )
+        .await
+        .unwrap();
+    assert_eq!(deleted, 2);
+
+    let remaining = client.query(RemoteQuery::new(b"queue#1")).await.unwrap();
+    let statuses: Vec<_> = remaining.items.iter().map(|item| item.get("status").cloned()).collect();
+    assert_eq!(statuses, vec![Some(Value::string("running")), Some(Value::string("failed"))]);
+    assert!(client.get_with_sk(b"queue#2", b"job#1").await.unwrap().is_some());
+}
+
+#[tokio::test]
+async fn test_conditions_on_reserved_word_attributes_use_names() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let item = |status: &str, size: i64| {
+        let mut item = HashMap::new();
+        item.insert("status".to_string(), Value::string(status));
+        item.insert("size".to_string(), Value::number(size));
+        item
+    };
+    client.put(b"order#1", item("draft", 3)).await.unwrap();
+
+    let put = RemotePut::new(b"order#1", item("placed", 3))
+        .condition("#status = :draft AND #size < :max")
+        .name("#status", "status")
+        .name("#size", "size")
+        .value(":draft", Value::string("draft"))
+        .value(":max", Value::number(10));
+    assert_eq!(put.expression_names().get("#status").map(String::as_str), Some("status"));
+    assert_eq!(put.expression_names().get("#size").map(String::as_str), Some("size"));
+    client.put_item(put).await.unwrap();
+
+    let update = RemoteUpdate::new(b"order#1")
+        .expression("SET #size = :size")
+        .condition("#status = :draft")
+        .name("#status", "status")
+        .name("#size", "size")
+        .value(":size", Value::number(4))
+        .value(":draft", Value::string("draft"));
+    let result = client.update(update).await;
+    assert!(matches!(result, Err(ClientError::ConditionCheckFailed(_))), "{:?}", result.err());
+
+    let delete = RemoteDelete::new(b"order#1")
+        .condition("#status = :placed")
+        .name("#status", "status")
+        .value(":placed", Value::string("placed"));
+    assert_eq!(delete.expression_names().len(), 1);
+    client.delete_item(delete).await.unwrap();
+    assert!(client.get(b"order#1").await.unwrap().is_none());
+}
+
+#[tokio::test]
+async fn test_transact_write_retry_with_many_attributes_matches_original() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    // Each request re-encodes the attributes from a fresh HashMap, so they
+    // go over the wire in a different order each time
+    let request = || {
+        let mut item = HashMap::new();
+        for i in 0..12 {
+            item.insert(format!("attr{}", i), Value::number(i));
+        }
+        let mut nested = HashMap::new();
+        nested.insert("a".to_string(), Value::string("x"));
+        nested.insert("b".to_string(), Value::string("y"));
+        nested.insert("c".to_string(), Value::string("z"));
+        item.insert("nested".to_string(), Value::M(nested));
+        RemoteTransactWriteRequest::new()
+            .put(b"doc#2", item)
+            .client_request_token("retry-token-2")
+    };
+
+    assert!(client.transact_write(request()).await.unwrap().success);
+    for _ in 0..10 {
+        assert!(client.transact_write(request()).await.unwrap().success);
+    }
+}
+
+#[tokio::test]
+async fn test_sum_over_filtered_query_and_scan() {
+    let (_dir, addr, _handle) = start_test_server().await;
+    let mut client = Client::connect(addr).await.unwrap();
+
+    let lines = [
+        ("line#1", Some(Value::number(10)), "paid"),
+        ("line#2", Some(Value::number(2.5)), "paid"),
+        ("line#3", Some(Value::string("lots")), "paid"),
+        ("line#4", None, "paid"),
+        ("line#5", Some(Value::number(100)), "void"),
+    ];
+    for (sk, amount, status) in lines {
+        let mut item = HashMap::new();
+        item.insert("status".to_string(), Value::string(status));
+        if let Some(amount) = amount {
+            item.insert("amount".to_string(), amount);
+        }
+        client.put_with_sk(b"order#1", sk.as_bytes(), item).await.unwrap();
+    }
+
+    // Non-numeric and missing amounts are skipped; no items come back
+    let paid = RemoteQuery::new(b"order#1").filter("#s = :paid").name("#s", "status").value(":paid", Value::string("paid"));
+    let response = client.query(paid.clone().select_count().sum("amount")).await.unwrap();
+    assert!(response.items.is_empty());
+    assert_eq!(response.count, 4);
+    assert_eq!(response.sum, Some(Value::number(12.5)));
+
+    assert_eq!(client.sum(paid, "amount").await.unwrap(), Value::number(12.5));
+    assert_eq!(client.sum_scan(RemoteScan::new(), "amount").await.unwrap(), Value::number(112.5));
+    assert_eq!(client.sum(RemoteQuery::new(b"order#2"), "amount").await.unwrap(), Value::number(0));
+}
@@ -0,0 +1,48 @@
+/// Aggregates over the items a query or scan returns
+///
+/// Aggregates are computed where the items are, so only the result crosses
+/// the wire. Like SQL aggregates skipping NULLs, items that lack the
+/// attribute or hold a non-numeric value there are left out rather than
+/// failing the request.
+
+use crate::{Item, Value};
+
+/// Sum of the numeric values of the top-level attribute `attribute`
+///
+/// Items without the attribute, or whose value there is not a number, are
+/// skipped; the sum of no numbers is 0.
+pub fn sum<'a>(items: impl IntoIterator<Item = &'a Item>, attribute: &str) -> Value {
+    let total: f64 = items
+        .into_iter()
+        .filter_map(|item| item.get(attribute).and_then(Value::as_number))
+        .sum();
+    Value::number(total)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    fn item(value: Option<Value>) -> Item {
+        let mut item = HashMap::new();
+        if let Some(value) = value {
+            item.insert("total".to_string(), value);
+        }
+        item
+    }
+
+    #[test]
+    fn test_sum_skips_missing_and_non_numeric_values() {
+        let items = vec![
+            item(Some(Value::number(10))),
+            item(Some(Value::number(2.5))),
+            item(Some(Value::string("12"))),
+            item(Some(Value::Bool(true))),
+            item(None),
+        ];
+        assert_eq!(sum(&items, "total"), Value::number(12.5));
+        assert_eq!(sum(&items[2..], "total"), Value::number(0));
+        assert_eq!(sum(&Vec::new(), "total"), Value::number(0));
+    }
+}
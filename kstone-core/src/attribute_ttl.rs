@@ -0,0 +1,110 @@
+/// Attribute-level expiry
+///
+/// Besides item TTL (`TableSchema::with_ttl`), a single attribute can be
+/// given an expiry time while the rest of the item stays, e.g. a cached,
+/// computed field that should be refreshed now and then. The stored value
+/// wraps the real one together with its expiry (see `expiring`); reads
+/// unwrap live values and leave expired ones out. Writing the attribute
+/// again by any other means replaces the wrapper, so the new value doesn't
+/// expire.
+///
+/// Expired values stay stored until the item is next updated. Conditions of
+/// an update see live values unwrapped, but the update expression itself
+/// sees the wrapper, so `SET n = n + 1` on an expiring `n` fails; set the
+/// attribute to a new value instead.
+
+use crate::{Item, Value};
+use std::collections::HashMap;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+/// Key of the wrapped value in an expiring attribute
+pub const EXPIRING_VALUE_KEY: &str = "__kstone_value";
+
+/// Key of the expiry time (`Ts`, milliseconds) in an expiring attribute
+pub const EXPIRING_AT_KEY: &str = "__kstone_expires_at";
+
+/// Wrap `value` so it is left out of reads from `expires_at` on
+pub fn expiring(value: Value, expires_at: SystemTime) -> Value {
+    let mut wrapper = HashMap::new();
+    wrapper.insert(EXPIRING_VALUE_KEY.to_string(), value);
+    wrapper.insert(EXPIRING_AT_KEY.to_string(), Value::Ts(millis_since_epoch(expires_at)));
+    Value::M(wrapper)
+}
+
+/// Unwrap the live expiring attributes of `item` and remove expired ones
+pub fn resolve_expiring(item: &mut Item) {
+    remove_expired(item);
+    for value in item.values_mut() {
+        if expires_at(value).is_some() {
+            if let Value::M(wrapper) = value {
+                *value = wrapper.remove(EXPIRING_VALUE_KEY).unwrap_or(Value::Null);
+            }
+        }
+    }
+}
+
+/// `item` as reads return it (see `resolve_expiring`)
+pub(crate) fn resolved(mut item: Item) -> Item {
+    resolve_expiring(&mut item);
+    item
+}
+
+/// Remove the expired attributes of `item`, keeping live ones wrapped
+pub(crate) fn remove_expired(item: &mut Item) {
+    let now = millis_since_epoch(SystemTime::now());
+    item.retain(|_, value| expires_at(value).map_or(true, |at| at > now));
+}
+
+/// Expiry time of an expiring attribute's value, None for other values
+fn expires_at(value: &Value) -> Option<i64> {
+    match value {
+        Value::M(wrapper) if wrapper.len() == 2 && wrapper.contains_key(EXPIRING_VALUE_KEY) => {
+            match wrapper.get(EXPIRING_AT_KEY) {
+                Some(Value::Ts(at)) => Some(*at),
+                _ => None,
+            }
+        }
+        _ => None,
+    }
+}
+
+fn millis_since_epoch(time: SystemTime) -> i64 {
+    time.duration_since(UNIX_EPOCH).map_or(0, |d| d.as_millis() as i64)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    #[test]
+    fn test_resolve_expiring_unwraps_live_and_removes_expired() {
+        let now = SystemTime::now();
+        let mut item = Item::new();
+        item.insert("name".to_string(), Value::string("Alice"));
+        item.insert("score".to_string(), expiring(Value::number(42), now + Duration::from_secs(60)));
+        item.insert("rank".to_string(), expiring(Value::number(7), now - Duration::from_secs(1)));
+
+        let mut stored = item.clone();
+        remove_expired(&mut stored);
+        assert_eq!(stored.len(), 2);
+        assert!(matches!(stored.get("score"), Some(Value::M(_))));
+
+        resolve_expiring(&mut item);
+        assert_eq!(item.len(), 2);
+        assert_eq!(item.get("name"), Some(&Value::string("Alice")));
+        assert_eq!(item.get("score"), Some(&Value::number(42)));
+    }
+
+    #[test]
+    fn test_resolve_expiring_leaves_ordinary_maps_alone() {
+        let mut map = HashMap::new();
+        map.insert(EXPIRING_VALUE_KEY.to_string(), Value::number(1));
+        map.insert("other".to_string(), Value::Ts(0));
+        let mut item = Item::new();
+        item.insert("data".to_string(), Value::M(map.clone()));
+
+        resolve_expiring(&mut item);
+        assert_eq!(item.get("data"), Some(&Value::M(map)));
+    }
+}
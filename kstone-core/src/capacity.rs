@@ -0,0 +1,83 @@
+/// Consumed capacity
+///
+/// Reads and writes can report the capacity units they consumed, in the
+/// style of DynamoDB, for cost modeling and throttling decisions. A read
+/// unit covers up to 4 KB read and a write unit up to 1 KB written, with
+/// items measured by `item_size`. Reads are strongly consistent, so reading
+/// nothing (a missing item, an empty page) still costs one read unit. A
+/// query or scan adds up the items it examined, including those its filter
+/// drops, and rounds up once.
+
+use crate::{item_size, Item};
+
+/// Bytes covered by one read unit
+pub const READ_UNIT_BYTES: u64 = 4096;
+
+/// Bytes covered by one write unit
+pub const WRITE_UNIT_BYTES: u64 = 1024;
+
+/// Capacity units consumed by an operation
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct ConsumedCapacity {
+    /// Read units
+    pub read_units: u64,
+    /// Write units
+    pub write_units: u64,
+}
+
+impl ConsumedCapacity {
+    /// Capacity of reading `items` in one operation
+    pub fn read<'a>(items: impl IntoIterator<Item = &'a Item>) -> Self {
+        let bytes: u64 = items.into_iter().map(|item| item_size(item) as u64).sum();
+        Self {
+            read_units: units(bytes, READ_UNIT_BYTES),
+            write_units: 0,
+        }
+    }
+
+    /// Capacity of writing `item`
+    pub fn write(item: &Item) -> Self {
+        Self {
+            read_units: 0,
+            write_units: units(item_size(item) as u64, WRITE_UNIT_BYTES),
+        }
+    }
+
+    /// Read and write units together
+    pub fn total_units(&self) -> u64 {
+        self.read_units + self.write_units
+    }
+}
+
+/// `bytes` in units of `unit_bytes`, rounded up, and at least one
+fn units(bytes: u64, unit_bytes: u64) -> u64 {
+    ((bytes + unit_bytes - 1) / unit_bytes).max(1)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::Value;
+
+    fn item(bytes: usize) -> Item {
+        let mut item = Item::new();
+        item.insert("data".to_string(), Value::B(vec![0; bytes].into()));
+        item
+    }
+
+    #[test]
+    fn test_units_round_up_per_operation() {
+        let nothing: [&Item; 0] = [];
+        assert_eq!(ConsumedCapacity::read(nothing).read_units, 1);
+        assert_eq!(ConsumedCapacity::read([&item(10)]).read_units, 1);
+        assert_eq!(ConsumedCapacity::read([&item(5000)]).read_units, 2);
+
+        // A page is rounded up once, not per item
+        let page: Vec<Item> = (0..8).map(|_| item(1000)).collect();
+        assert_eq!(ConsumedCapacity::read(&page).read_units, 2);
+
+        let write = ConsumedCapacity::write(&item(2000));
+        assert_eq!(write, ConsumedCapacity { read_units: 0, write_units: 2 });
+        assert_eq!(write.total_units(), 2);
+    }
+}
@@ -5,15 +5,23 @@
 ///
 /// # Compaction Strategy
 ///
-/// Uses a simple level-based compaction approach:
-/// - Triggers when a stripe has too many SST files (default: ≥10 SSTs)
-/// - Merges all SSTs in a stripe into a single new SST
-/// - Removes tombstones (deleted records) during merge
-/// - Keeps newest version of each key (highest SeqNo)
+/// `CompactionConfig::style` picks how a stripe's SSTs are merged:
+/// - `Leveled` (default): once a stripe has `sst_threshold` SSTs, merge
+///   them all into one. Reads check few SSTs, but every merge rewrites the
+///   whole stripe.
+/// - `Tiered`: once the stripe's `level_size_multiplier` newest SSTs are of
+///   similar size, merge just those into one SST of the next tier. Each
+///   record is rewritten about once per tier, at the cost of reads checking
+///   more SSTs; a stripe reaching `max_levels * level_size_multiplier` SSTs
+///   is merged whole.
+///
+/// Either way a merge keeps the newest version of each key (highest SeqNo),
+/// and drops tombstones when it covers all of the stripe's SSTs.
 
 use crate::{Error, Result, Record, sst::{SstWriter, SstReader}};
+use serde::{Deserialize, Serialize};
 use std::collections::BTreeMap;
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
 use std::fs;
 use std::sync::atomic::{AtomicU64, Ordering};
 use std::sync::Arc;
@@ -27,8 +35,27 @@ pub const MIN_SSTS_TO_COMPACT: usize = 2;
 /// Legacy constant for backward compatibility
 pub const COMPACTION_THRESHOLD: usize = DEFAULT_SST_THRESHOLD;
 
+/// Default number of tiers before a tiered stripe is merged whole
+pub const DEFAULT_MAX_LEVELS: usize = 4;
+
+/// Default number of similar-sized SSTs merged into the next tier
+pub const DEFAULT_LEVEL_SIZE_MULTIPLIER: usize = 4;
+
+/// File in the database directory recording its compaction config
+pub const COMPACTION_CONFIG_FILE: &str = "compaction.json";
+
+/// How a stripe's SSTs are merged (see the module docs)
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub enum CompactionStyle {
+    /// Merge the whole stripe once it has `sst_threshold` SSTs; favours reads
+    #[default]
+    Leveled,
+    /// Merge similar-sized SSTs a tier at a time; favours writes
+    Tiered,
+}
+
 /// Compaction configuration
-#[derive(Clone, Debug)]
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize)]
 pub struct CompactionConfig {
     /// Enable/disable automatic background compaction
     pub enabled: bool,
@@ -41,6 +68,26 @@ pub struct CompactionConfig {
 
     /// Maximum number of stripes to compact concurrently
     pub max_concurrent_compactions: usize,
+
+    /// How SSTs are merged
+    #[serde(default)]
+    pub style: CompactionStyle,
+
+    /// Tiers a stripe may hold before it is merged whole (`Tiered` only)
+    #[serde(default = "default_max_levels")]
+    pub max_levels: usize,
+
+    /// SSTs merged into one of the next tier (`Tiered` only)
+    #[serde(default = "default_level_size_multiplier")]
+    pub level_size_multiplier: usize,
+}
+
+fn default_max_levels() -> usize {
+    DEFAULT_MAX_LEVELS
+}
+
+fn default_level_size_multiplier() -> usize {
+    DEFAULT_LEVEL_SIZE_MULTIPLIER
 }
 
 impl Default for CompactionConfig {
@@ -50,6 +97,9 @@ impl Default for CompactionConfig {
             sst_threshold: DEFAULT_SST_THRESHOLD,
             check_interval_secs: 60, // Check every minute
             max_concurrent_compactions: 4, // Compact up to 4 stripes at once
+            style: CompactionStyle::Leveled,
+            max_levels: DEFAULT_MAX_LEVELS,
+            level_size_multiplier: DEFAULT_LEVEL_SIZE_MULTIPLIER,
         }
     }
 }
@@ -85,6 +135,98 @@ impl CompactionConfig {
         self.max_concurrent_compactions = max.max(1);
         self
     }
+
+    /// Set the compaction style
+    pub fn with_style(mut self, style: CompactionStyle) -> Self {
+        self.style = style;
+        self
+    }
+
+    /// Set the number of tiers before a tiered stripe is merged whole
+    pub fn with_max_levels(mut self, levels: usize) -> Self {
+        self.max_levels = levels.max(1);
+        self
+    }
+
+    /// Set how many similar-sized SSTs are merged into the next tier
+    pub fn with_level_size_multiplier(mut self, multiplier: usize) -> Self {
+        self.level_size_multiplier = multiplier.max(MIN_SSTS_TO_COMPACT);
+        self
+    }
+
+    /// Check values set directly on the fields
+    pub fn validate(&self) -> std::result::Result<(), String> {
+        if self.sst_threshold < MIN_SSTS_TO_COMPACT {
+            return Err(format!("compaction sst_threshold must be at least {}", MIN_SSTS_TO_COMPACT));
+        }
+        if self.max_levels == 0 {
+            return Err("compaction max_levels must be greater than 0".to_string());
+        }
+        if self.level_size_multiplier < MIN_SSTS_TO_COMPACT {
+            return Err(format!(
+                "compaction level_size_multiplier must be at least {}",
+                MIN_SSTS_TO_COMPACT
+            ));
+        }
+        Ok(())
+    }
+
+    /// How many of a stripe's newest SSTs to merge now, or 0 if none
+    ///
+    /// `sizes` are the stripe's SST file sizes, newest first.
+    pub fn ssts_to_compact(&self, sizes: &[u64]) -> usize {
+        if !self.enabled {
+            return 0;
+        }
+        match self.style {
+            CompactionStyle::Leveled => {
+                if sizes.len() >= self.sst_threshold {
+                    sizes.len()
+                } else {
+                    0
+                }
+            }
+            CompactionStyle::Tiered => {
+                let fan_in = self.level_size_multiplier;
+                if sizes.len() >= self.max_levels.saturating_mul(fan_in) {
+                    return sizes.len();
+                }
+                // The newest SST's tier: those within a factor of two of it
+                let Some(&newest) = sizes.first() else {
+                    return 0;
+                };
+                let tier = sizes
+                    .iter()
+                    .take_while(|&&size| size / 2 <= newest && newest / 2 <= size)
+                    .count();
+                if tier >= fan_in {
+                    tier
+                } else {
+                    0
+                }
+            }
+        }
+    }
+
+    /// Read the config stored in a database directory, if any
+    pub fn load(dir: &Path) -> Result<Option<Self>> {
+        let path = dir.join(COMPACTION_CONFIG_FILE);
+        if !path.exists() {
+            return Ok(None);
+        }
+        let json = fs::read(&path)?;
+        let config = serde_json::from_slice(&json)
+            .map_err(|e| Error::Corruption(format!("Invalid {}: {}", COMPACTION_CONFIG_FILE, e)))?;
+        Ok(Some(config))
+    }
+
+    /// Record this config in a database directory
+    pub fn store(&self, dir: &Path) -> Result<()> {
+        let json = serde_json::to_vec_pretty(self)
+            .map_err(|e| Error::Internal(format!("Failed to encode compaction config: {}", e)))?;
+        fs::write(dir.join(COMPACTION_CONFIG_FILE), json)?;
+        Ok(())
+    }
 }
 
 /// Statistics about compaction operations
@@ -233,12 +375,32 @@ impl Drop for CompactionGuard {
 pub struct CompactionManager {
     stripe_id: usize,
     dir: PathBuf,
+    value_compression_threshold: Option<usize>,
+    keep_tombstones: bool,
 }
 
 impl CompactionManager {
     /// Create a new compaction manager
     pub fn new(stripe_id: usize, dir: PathBuf) -> Self {
-        Self { stripe_id, dir }
+        Self {
+            stripe_id,
+            dir,
+            value_compression_threshold: None,
+            keep_tombstones: false,
+        }
+    }
+
+    /// Keep tombstones in the output, for merges that leave older SSTs
+    /// whose versions the tombstones must go on shadowing
+    pub fn with_tombstones_kept(mut self, keep: bool) -> Self {
+        self.keep_tombstones = keep;
+        self
+    }
+
+    /// Compress large attribute values in compacted SSTs
+    pub fn with_value_compression(mut self, threshold: Option<usize>) -> Self {
+        self.value_compression_threshold = threshold;
+        self
     }
 
     /// Check if compaction is needed for this stripe
@@ -251,7 +413,7 @@ impl CompactionManager {
     /// Algorithm:
     /// 1. Read all records from all input SSTs
     /// 2. Merge by key, keeping only the latest version (highest SeqNo)
-    /// 3. Filter out tombstones (deleted records)
+    /// 3. Filter out tombstones (deleted records), unless they are kept
     /// 4. Write merged records to new SST
     /// 5. Return new SST reader and paths of old SSTs to delete
     pub fn compact(
@@ -288,7 +450,7 @@ impl CompactionManager {
         // Step 2: Filter out tombstones and collect records to write
         let mut records_to_write: Vec<Record> = records_by_key
             .into_values()
-            .filter(|record| !record.is_tombstone())
+            .filter(|record| self.keep_tombstones || !record.is_tombstone())
             .collect();
 
         // Sort by encoded key (already sorted from BTreeMap, but ensure consistency)
@@ -296,7 +458,8 @@ impl CompactionManager {
 
         // Step 3: Write new SST with compression settings
         let new_sst_path = self.dir.join(format!("{:03}-{}.sst", self.stripe_id, next_sst_id));
-        let mut writer = SstWriter::with_compression(compress, compression_level);
+        let mut writer = SstWriter::with_compression(compress, compression_level)
+            .with_value_compression(self.value_compression_threshold);
 
         for record in records_to_write {
             writer.add(record);
@@ -572,4 +735,36 @@ mod tests {
         drop(_guard2);
         assert_eq!(stats.active_compactions.load(Ordering::Relaxed), 2);
     }
+
+    #[test]
+    fn test_ssts_to_compact_by_style() {
+        let leveled = CompactionConfig::new().with_sst_threshold(3);
+        assert_eq!(leveled.ssts_to_compact(&[10, 10]), 0);
+        assert_eq!(leveled.ssts_to_compact(&[10, 10, 500]), 3);
+
+        let tiered = CompactionConfig::new()
+            .with_style(CompactionStyle::Tiered)
+            .with_level_size_multiplier(3)
+            .with_max_levels(3);
+        // Only the newest run of similar-sized SSTs is merged
+        assert_eq!(tiered.ssts_to_compact(&[10, 12, 400, 380]), 0);
+        assert_eq!(tiered.ssts_to_compact(&[10, 12, 9, 400, 380]), 3);
+        // Too many tiers: merge the whole stripe
+        assert_eq!(tiered.ssts_to_compact(&[1, 10, 100, 1000, 1, 10, 100, 1000, 1]), 9);
+
+        assert_eq!(CompactionConfig::disabled().ssts_to_compact(&[1; 20]), 0);
+    }
+
+    #[test]
+    fn test_compaction_config_store_and_load() {
+        let dir = TempDir::new().unwrap();
+        assert_eq!(CompactionConfig::load(dir.path()).unwrap(), None);
+
+        let config = CompactionConfig::new()
+            .with_style(CompactionStyle::Tiered)
+            .with_max_levels(6)
+            .with_level_size_multiplier(8);
+        config.store(dir.path()).unwrap();
+        assert_eq!(CompactionConfig::load(dir.path()).unwrap(), Some(config));
+    }
 }
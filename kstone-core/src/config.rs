@@ -1,4 +1,17 @@
+use crate::compaction::CompactionConfig;
+use crate::events::{EngineEvent, EventHandler};
+
+/// Default maximum item size (400 KB, matching DynamoDB)
+pub const DEFAULT_MAX_ITEM_SIZE_BYTES: usize = 400 * 1024;
+
+/// Attribute stamped by `with_auto_timestamp`
+pub const DEFAULT_TIMESTAMP_ATTRIBUTE: &str = "__updated_at";
+
 /// Database configuration for resource limits and operational parameters
+///
+/// There is no block cache to size: an SST's records are read into memory
+/// when it is opened, so reads never go back to disk for a cached block to
+/// save. Read memory grows with the data on disk, not with a cache setting.
 #[derive(Debug, Clone)]
 pub struct DatabaseConfig {
     /// Maximum memtable size in bytes (None = unlimited)
@@ -22,6 +35,36 @@ pub struct DatabaseConfig {
     /// Compression level (1-22, where 1 is fastest, 22 is best compression)
     /// Default: 3 (balanced speed/ratio)
     pub compression_level: i32,
+
+    /// Maximum accounted item size in bytes (see `item_size`)
+    pub max_item_size_bytes: usize,
+
+    /// How long a put or delete waits for concurrent writes to share its
+    /// WAL sync (zero = sync every write on its own)
+    ///
+    /// A window trades up to that much latency per write for far fewer
+    /// fsyncs under concurrent writers. Writes become visible to readers
+    /// only once durable, and return after that. If a sync fails, its
+    /// writes are never applied and the database refuses further writes.
+    pub group_commit_window: std::time::Duration,
+
+    /// Compress string and binary attributes at least this many bytes long
+    /// when writing them to disk (None = disabled)
+    pub value_compression_threshold: Option<usize>,
+
+    /// Attribute the engine stamps with the write time (`Value::Ts`,
+    /// milliseconds since the epoch) on every put and update (None = off)
+    pub auto_timestamp_attribute: Option<String>,
+
+    /// Compaction policy (None = the one stored with the database, or the
+    /// default for a new one)
+    ///
+    /// The policy in effect is stored in the database directory, so a later
+    /// open without one keeps it.
+    pub compaction: Option<CompactionConfig>,
+
+    /// Called on each flush and compaction (see `events`)
+    pub event_handler: Option<EventHandler>,
 }
 
 impl Default for DatabaseConfig {
@@ -36,6 +79,12 @@ impl Default for DatabaseConfig {
             write_buffer_size: 1024,
             compression_enabled: false,
             compression_level: 3,
+            max_item_size_bytes: DEFAULT_MAX_ITEM_SIZE_BYTES,
+            group_commit_window: std::time::Duration::ZERO,
+            value_compression_threshold: None,
+            auto_timestamp_attribute: None,
+            compaction: None,
+            event_handler: None,
         }
     }
 }
@@ -91,6 +140,55 @@ impl DatabaseConfig {
         self
     }
 
+    /// Set maximum item size in bytes
+    pub fn with_max_item_size_bytes(mut self, size: usize) -> Self {
+        self.max_item_size_bytes = size;
+        self
+    }
+
+    /// Set the group commit window (zero disables group commit)
+    pub fn with_group_commit_window(mut self, window: std::time::Duration) -> Self {
+        self.group_commit_window = window;
+        self
+    }
+
+    /// Compress individual string and binary attributes of at least `bytes`
+    ///
+    /// Reads decompress transparently; small scalars are never compressed.
+    pub fn with_value_compression_threshold(mut self, bytes: usize) -> Self {
+        self.value_compression_threshold = Some(bytes);
+        self
+    }
+
+    /// Stamp every written item with its write time in `__updated_at`
+    pub fn with_auto_timestamp(self) -> Self {
+        self.with_auto_timestamp_attribute(DEFAULT_TIMESTAMP_ATTRIBUTE)
+    }
+
+    /// Stamp every written item with its write time in `attribute`
+    ///
+    /// The engine sets the stamp under its write lock, so stamps on
+    /// successive writes strictly increase and any value the caller put in
+    /// the attribute is replaced.
+    pub fn with_auto_timestamp_attribute(mut self, attribute: impl Into<String>) -> Self {
+        self.auto_timestamp_attribute = Some(attribute.into());
+        self
+    }
+
+    /// Set the compaction policy, stored with the database
+    pub fn with_compaction(mut self, compaction: CompactionConfig) -> Self {
+        self.compaction = Some(compaction);
+        self
+    }
+
+    /// Call `handler` on each flush and compaction
+    ///
+    /// The handler runs under the engine's write lock; see `events`.
+    pub fn with_event_handler(mut self, handler: impl Fn(&EngineEvent) + Send + Sync + 'static) -> Self {
+        self.event_handler = Some(EventHandler::new(handler));
+        self
+    }
+
     /// Validate configuration values
     pub fn validate(&self) -> Result<(), String> {
         if self.max_memtable_records == 0 {
@@ -107,10 +205,26 @@ impl DatabaseConfig {
             }
         }
 
+        if self.max_item_size_bytes == 0 {
+            return Err("max_item_size_bytes must be greater than 0".to_string());
+        }
+
+        if self.auto_timestamp_attribute.as_deref() == Some("") {
+            return Err("auto_timestamp_attribute must not be empty when set".to_string());
+        }
+
+        if self.value_compression_threshold == Some(0) {
+            return Err("value_compression_threshold must be greater than 0 when set".to_string());
+        }
+
         if self.compression_level < 1 || self.compression_level > 22 {
             return Err("compression_level must be between 1 and 22".to_string());
         }
 
+        if let Some(compaction) = &self.compaction {
+            compaction.validate()?;
+        }
+
         Ok(())
     }
 }
@@ -127,6 +241,7 @@ mod tests {
         assert_eq!(config.max_memtable_size_bytes, Some(4 * 1024 * 1024));
         assert!(config.max_wal_size_bytes.is_none());
         assert!(config.max_total_disk_bytes.is_none());
+        assert_eq!(config.max_item_size_bytes, 400 * 1024);
     }
 
     #[test]
@@ -0,0 +1,155 @@
+/// Decoding items into Rust types
+///
+/// `from_item` turns an item into any type implementing serde's
+/// `Deserialize`, matching attributes to fields by name (use
+/// `#[serde(rename = "...")]` for attributes named differently). Values
+/// convert the natural way:
+///
+/// - N: to any integer or float field that can hold it
+/// - S: to `String`
+/// - B: to `Vec<u8>` or `Bytes`
+/// - L, SS, NS, BS, VecF32: to `Vec`s (or other sequences)
+/// - M: to nested structs or maps
+/// - Ts: to an integer (milliseconds since the epoch)
+/// - Null: to `None`
+///
+/// A missing attribute decodes as `None` into an `Option` field and fails
+/// otherwise, unless the field has `#[serde(default)]`.
+
+use crate::{Error, Item, Result, Value};
+use serde::de::DeserializeOwned;
+
+/// Decode an item into `T`
+///
+/// Fails with `InvalidArgument` naming the field that did not fit.
+pub fn from_item<T: DeserializeOwned>(item: &Item) -> Result<T> {
+    let object = item
+        .iter()
+        .map(|(name, value)| (name.clone(), to_json(value)))
+        .collect();
+    serde_json::from_value(serde_json::Value::Object(object))
+        .map_err(|e| Error::InvalidArgument(format!("Cannot decode item: {}", e)))
+}
+
+fn to_json(value: &Value) -> serde_json::Value {
+    use serde_json::Value as Json;
+
+    match value {
+        Value::N(n) => number_to_json(n),
+        Value::S(s) => Json::String(s.clone()),
+        Value::B(b) => bytes_to_json(b),
+        Value::Bool(b) => Json::Bool(*b),
+        Value::Null => Json::Null,
+        Value::L(list) => Json::Array(list.iter().map(to_json).collect()),
+        Value::M(map) => Json::Object(map.iter().map(|(k, v)| (k.clone(), to_json(v))).collect()),
+        Value::VecF32(vector) => Json::Array(
+            vector
+                .iter()
+                .map(|&x| serde_json::Number::from_f64(x as f64).map_or(Json::Null, Json::Number))
+                .collect(),
+        ),
+        Value::Ts(ts) => Json::Number((*ts).into()),
+        Value::SS(set) => Json::Array(set.iter().cloned().map(Json::String).collect()),
+        Value::NS(set) => Json::Array(set.iter().map(|n| number_to_json(n)).collect()),
+        Value::BS(set) => Json::Array(set.iter().map(|b| bytes_to_json(b)).collect()),
+    }
+}
+
+/// Integers stay exact; anything else goes through f64. A number that
+/// doesn't parse stays a string, so decoding it into a number field fails
+fn number_to_json(n: &str) -> serde_json::Value {
+    if let Ok(i) = n.parse::<i64>() {
+        return serde_json::Value::Number(i.into());
+    }
+    if let Ok(u) = n.parse::<u64>() {
+        return serde_json::Value::Number(u.into());
+    }
+    n.parse::<f64>()
+        .ok()
+        .and_then(serde_json::Number::from_f64)
+        .map_or_else(|| serde_json::Value::String(n.to_string()), serde_json::Value::Number)
+}
+
+fn bytes_to_json(bytes: &[u8]) -> serde_json::Value {
+    serde_json::Value::Array(bytes.iter().map(|&b| serde_json::Value::Number(b.into())).collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde::Deserialize;
+    use std::collections::HashMap;
+
+    #[derive(Debug, Deserialize, PartialEq)]
+    struct Address {
+        city: String,
+    }
+
+    #[derive(Debug, Deserialize, PartialEq)]
+    struct User {
+        name: String,
+        age: u32,
+        score: f64,
+        #[serde(rename = "isActive")]
+        active: bool,
+        tags: Vec<String>,
+        avatar: Vec<u8>,
+        address: Address,
+        nickname: Option<String>,
+    }
+
+    #[test]
+    fn test_from_item_maps_fields_and_types() {
+        let mut address = HashMap::new();
+        address.insert("city".to_string(), Value::string("Oslo"));
+
+        let mut item = Item::new();
+        item.insert("name".to_string(), Value::string("Alice"));
+        item.insert("age".to_string(), Value::number(30));
+        item.insert("score".to_string(), Value::number(9));
+        item.insert("isActive".to_string(), Value::Bool(true));
+        item.insert("tags".to_string(), Value::string_set(["b", "a"]));
+        item.insert("avatar".to_string(), Value::binary(vec![1u8, 2]));
+        item.insert("address".to_string(), Value::M(address));
+        item.insert("ignored".to_string(), Value::Null);
+
+        let user: User = from_item(&item).unwrap();
+        assert_eq!(
+            user,
+            User {
+                name: "Alice".to_string(),
+                age: 30,
+                score: 9.0,
+                active: true,
+                tags: vec!["a".to_string(), "b".to_string()],
+                avatar: vec![1, 2],
+                address: Address { city: "Oslo".to_string() },
+                nickname: None,
+            }
+        );
+    }
+
+    #[test]
+    fn test_from_item_reports_mismatches() {
+        let mut item = Item::new();
+        item.insert("name".to_string(), Value::number(1));
+
+        #[derive(Debug, Deserialize)]
+        #[allow(dead_code)]
+        struct Named {
+            name: String,
+        }
+        let err = from_item::<Named>(&item).unwrap_err();
+        assert!(matches!(err, Error::InvalidArgument(ref msg) if msg.contains("Cannot decode item")), "{:?}", err);
+
+        // Negative numbers don't fit unsigned fields
+        #[derive(Debug, Deserialize)]
+        #[allow(dead_code)]
+        struct Counted {
+            count: u8,
+        }
+        let mut item = Item::new();
+        item.insert("count".to_string(), Value::number(-1));
+        assert!(from_item::<Counted>(&item).is_err());
+    }
+}
@@ -0,0 +1,169 @@
+/// Value equality and item diffs
+///
+/// `Value` derives `PartialEq`, but that compares numbers as the strings
+/// they are stored as, so `1.0` and `1` differ. `value_equal` compares
+/// numbers by value and recurses into lists and maps; `item_diff` builds on
+/// it to report which attributes differ between two versions of an item,
+/// as needed by sync and merge logic.
+
+use crate::{Item, Value};
+use std::collections::HashMap;
+
+/// How an attribute differs between two items
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum DiffKind {
+    /// Only in the second item
+    Added,
+    /// Only in the first item
+    Removed,
+    /// In both, with unequal values
+    Changed,
+}
+
+/// Deep, type-aware equality
+///
+/// Numbers are equal if they denote the same value (`1`, `1.0` and `1e0`);
+/// binary values compare by bytes, lists element by element and maps key by
+/// key. Values of different types are never equal.
+pub fn value_equal(a: &Value, b: &Value) -> bool {
+    match (a, b) {
+        (Value::N(a), Value::N(b)) => numbers_equal(a, b),
+        (Value::L(a), Value::L(b)) => {
+            a.len() == b.len() && a.iter().zip(b).all(|(a, b)| value_equal(a, b))
+        }
+        (Value::M(a), Value::M(b)) => maps_equal(a, b),
+        _ => a == b,
+    }
+}
+
+/// Attributes that differ between `a` and `b`, by name
+///
+/// Attributes equal under `value_equal` are omitted, so an empty map means
+/// the items are equal.
+pub fn item_diff(a: &Item, b: &Item) -> HashMap<String, DiffKind> {
+    let mut diff = HashMap::new();
+    for (name, value) in a {
+        match b.get(name) {
+            None => {
+                diff.insert(name.clone(), DiffKind::Removed);
+            }
+            Some(other) if !value_equal(value, other) => {
+                diff.insert(name.clone(), DiffKind::Changed);
+            }
+            Some(_) => {}
+        }
+    }
+    for name in b.keys().filter(|name| !a.contains_key(*name)) {
+        diff.insert(name.clone(), DiffKind::Added);
+    }
+    diff
+}
+
+fn maps_equal(a: &HashMap<String, Value>, b: &HashMap<String, Value>) -> bool {
+    a.len() == b.len()
+        && a
+            .iter()
+            .all(|(name, value)| b.get(name).map_or(false, |other| value_equal(value, other)))
+}
+
+fn numbers_equal(a: &str, b: &str) -> bool {
+    if a == b {
+        return true;
+    }
+    match (canonical_decimal(a), canonical_decimal(b)) {
+        (Some(a), Some(b)) => a == b,
+        // Exponent notation: compare as floating point
+        _ => match (a.trim().parse::<f64>(), b.trim().parse::<f64>()) {
+            (Ok(a), Ok(b)) => a == b,
+            _ => false,
+        },
+    }
+}
+
+/// Canonical form of a plain decimal ("-012.50" -> "-12.5"), exact for any
+/// number of digits; None for exponent notation or malformed input
+fn canonical_decimal(n: &str) -> Option<String> {
+    let n = n.trim();
+    let (negative, digits) = match n.strip_prefix('-') {
+        Some(rest) => (true, rest),
+        None => (false, n.strip_prefix('+').unwrap_or(n)),
+    };
+    let (int, frac) = digits.split_once('.').unwrap_or((digits, ""));
+    if int.is_empty() && frac.is_empty() {
+        return None;
+    }
+    if !int.bytes().chain(frac.bytes()).all(|c| c.is_ascii_digit()) {
+        return None;
+    }
+
+    let int = int.trim_start_matches('0');
+    let frac = frac.trim_end_matches('0');
+    let mut canonical = String::new();
+    if negative && !(int.is_empty() && frac.is_empty()) {
+        canonical.push('-');
+    }
+    canonical.push_str(if int.is_empty() { "0" } else { int });
+    if !frac.is_empty() {
+        canonical.push('.');
+        canonical.push_str(frac);
+    }
+    Some(canonical)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_numbers_compare_by_value() {
+        assert!(value_equal(&Value::number("1.0"), &Value::number(1)));
+        assert!(value_equal(&Value::number("-0"), &Value::number("0.00")));
+        assert!(value_equal(&Value::number("1e3"), &Value::number(1000)));
+        assert!(value_equal(
+            &Value::number("12345678901234567890"),
+            &Value::number("12345678901234567890.0")
+        ));
+        assert!(!value_equal(
+            &Value::number("12345678901234567890"),
+            &Value::number("12345678901234567891")
+        ));
+        assert!(!value_equal(&Value::number(1), &Value::string("1")));
+    }
+
+    #[test]
+    fn test_nested_maps_and_binary() {
+        let mut inner_a = HashMap::new();
+        inner_a.insert("n".to_string(), Value::number("2.50"));
+        let mut inner_b = HashMap::new();
+        inner_b.insert("n".to_string(), Value::number("2.5"));
+        assert!(value_equal(
+            &Value::L(vec![Value::M(inner_a.clone())]),
+            &Value::L(vec![Value::M(inner_b)])
+        ));
+
+        inner_a.insert("extra".to_string(), Value::Null);
+        assert!(!value_equal(&Value::M(inner_a), &Value::M(HashMap::new())));
+
+        assert!(value_equal(&Value::binary(vec![1, 2]), &Value::binary(vec![1, 2])));
+        assert!(!value_equal(&Value::binary(vec![1, 2]), &Value::binary(vec![2, 1])));
+    }
+
+    #[test]
+    fn test_item_diff() {
+        let mut a = Item::new();
+        a.insert("same".to_string(), Value::number(1));
+        a.insert("changed".to_string(), Value::string("x"));
+        a.insert("removed".to_string(), Value::Bool(true));
+        let mut b = Item::new();
+        b.insert("same".to_string(), Value::number("1.0"));
+        b.insert("changed".to_string(), Value::string("y"));
+        b.insert("added".to_string(), Value::Null);
+
+        let diff = item_diff(&a, &b);
+        assert_eq!(diff.len(), 3);
+        assert_eq!(diff["changed"], DiffKind::Changed);
+        assert_eq!(diff["removed"], DiffKind::Removed);
+        assert_eq!(diff["added"], DiffKind::Added);
+        assert!(item_diff(&a, &a).is_empty());
+    }
+}
@@ -0,0 +1,189 @@
+/// Partition digests for reconciliation
+///
+/// `partition_digest` hashes each item of a partition into a leaf (its sort
+/// key and its attributes in name order) and combines the leaves, in sort
+/// key order, into a SHA-256 Merkle root. Two partitions holding the same
+/// items get the same digest however they were written, and changing,
+/// adding or removing any attribute or item changes it, so replicas can
+/// compare digests before transferring data.
+///
+/// Values are hashed as stored: numbers `1` and `1.0` differ here even
+/// though `value_equal` treats them as equal.
+
+use crate::{Item, Key, Value};
+use sha2::{Digest, Sha256};
+
+/// Length in bytes of a partition digest
+pub const DIGEST_LEN: usize = 32;
+
+/// Prefixes keeping leaf and internal hashes apart
+const LEAF: u8 = 0;
+const NODE: u8 = 1;
+
+/// Merkle root over the items of one partition
+///
+/// The items may come in any order. An empty partition has the digest of
+/// an empty input.
+pub fn partition_digest<'a>(items: impl IntoIterator<Item = (&'a Key, &'a Item)>) -> Vec<u8> {
+    let mut leaves: Vec<(&[u8], [u8; DIGEST_LEN])> = items
+        .into_iter()
+        .map(|(key, item)| {
+            let sk = key.sk.as_deref().unwrap_or_default();
+            (sk, leaf_hash(sk, item))
+        })
+        .collect();
+    leaves.sort_by(|a, b| a.0.cmp(b.0));
+
+    let mut level: Vec<[u8; DIGEST_LEN]> = leaves.into_iter().map(|(_, hash)| hash).collect();
+    if level.is_empty() {
+        return Sha256::digest(b"").to_vec();
+    }
+    while level.len() > 1 {
+        level = level
+            .chunks(2)
+            .map(|pair| match pair {
+                [left, right] => {
+                    let mut hasher = Sha256::new();
+                    hasher.update([NODE]);
+                    hasher.update(left);
+                    hasher.update(right);
+                    finish(hasher)
+                }
+                // An odd node out moves up unchanged
+                [single] => *single,
+                _ => unreachable!(),
+            })
+            .collect();
+    }
+    level[0].to_vec()
+}
+
+fn leaf_hash(sk: &[u8], item: &Item) -> [u8; DIGEST_LEN] {
+    let mut hasher = Sha256::new();
+    hasher.update([LEAF]);
+    update_bytes(&mut hasher, sk);
+    update_map(&mut hasher, item);
+    finish(hasher)
+}
+
+fn finish(hasher: Sha256) -> [u8; DIGEST_LEN] {
+    let mut hash = [0u8; DIGEST_LEN];
+    hash.copy_from_slice(&hasher.finalize());
+    hash
+}
+
+/// Hash a map with its entries in name order
+fn update_map(hasher: &mut Sha256, map: &std::collections::HashMap<String, Value>) {
+    let mut entries: Vec<_> = map.iter().collect();
+    entries.sort_by(|a, b| a.0.cmp(b.0));
+    hasher.update((entries.len() as u64).to_le_bytes());
+    for (name, value) in entries {
+        update_bytes(hasher, name.as_bytes());
+        update_value(hasher, value);
+    }
+}
+
+/// Hash a value with a type tag, so e.g. `S("1")` and `N("1")` differ
+fn update_value(hasher: &mut Sha256, value: &Value) {
+    match value {
+        Value::N(n) => {
+            hasher.update(b"N");
+            update_bytes(hasher, n.as_bytes());
+        }
+        Value::S(s) => {
+            hasher.update(b"S");
+            update_bytes(hasher, s.as_bytes());
+        }
+        Value::B(b) => {
+            hasher.update(b"B");
+            update_bytes(hasher, b);
+        }
+        Value::Bool(b) => hasher.update([b'T', *b as u8]),
+        Value::Null => hasher.update(b"0"),
+        Value::L(list) => {
+            hasher.update(b"L");
+            hasher.update((list.len() as u64).to_le_bytes());
+            for element in list {
+                update_value(hasher, element);
+            }
+        }
+        Value::M(map) => {
+            hasher.update(b"M");
+            update_map(hasher, map);
+        }
+        Value::VecF32(vector) => {
+            hasher.update(b"V");
+            hasher.update((vector.len() as u64).to_le_bytes());
+            for x in vector {
+                hasher.update(x.to_le_bytes());
+            }
+        }
+        Value::Ts(ts) => {
+            hasher.update(b"D");
+            hasher.update(ts.to_le_bytes());
+        }
+        // Sets iterate in order, so equal sets hash the same
+        Value::SS(set) | Value::NS(set) => {
+            hasher.update(if matches!(value, Value::SS(_)) { b"s" } else { b"n" });
+            hasher.update((set.len() as u64).to_le_bytes());
+            for member in set {
+                update_bytes(hasher, member.as_bytes());
+            }
+        }
+        Value::BS(set) => {
+            hasher.update(b"b");
+            hasher.update((set.len() as u64).to_le_bytes());
+            for member in set {
+                update_bytes(hasher, member);
+            }
+        }
+    }
+}
+
+/// Hash length-prefixed bytes, so adjacent fields can't run together
+fn update_bytes(hasher: &mut Sha256, bytes: &[u8]) {
+    hasher.update((bytes.len() as u64).to_le_bytes());
+    hasher.update(bytes);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use bytes::Bytes;
+
+    fn entry(sk: &str, attr: &str, value: Value) -> (Key, Item) {
+        let mut item = Item::new();
+        item.insert(attr.to_string(), value);
+        (Key::with_sk(Bytes::from_static(b"pk"), Bytes::from(sk.to_string())), item)
+    }
+
+    fn digest(items: &[(Key, Item)]) -> Vec<u8> {
+        partition_digest(items.iter().map(|(key, item)| (key, item)))
+    }
+
+    #[test]
+    fn test_digest_ignores_item_order() {
+        let a = entry("a", "x", Value::number(1));
+        let b = entry("b", "x", Value::string("two"));
+        let c = entry("c", "y", Value::Bool(true));
+
+        let forward = digest(&[a.clone(), b.clone(), c.clone()]);
+        let backward = digest(&[c, b, a]);
+        assert_eq!(forward, backward);
+        assert_eq!(forward.len(), DIGEST_LEN);
+    }
+
+    #[test]
+    fn test_digest_detects_changes() {
+        let base = [entry("a", "x", Value::number(1)), entry("b", "x", Value::number(2))];
+        let changed = [entry("a", "x", Value::number(1)), entry("b", "x", Value::number(3))];
+        let retyped = [entry("a", "x", Value::number(1)), entry("b", "x", Value::string("2"))];
+        let fewer = [entry("a", "x", Value::number(1))];
+
+        let original = digest(&base);
+        assert_ne!(original, digest(&changed));
+        assert_ne!(original, digest(&retyped));
+        assert_ne!(original, digest(&fewer));
+        assert_ne!(digest(&fewer), digest(&[]));
+    }
+}
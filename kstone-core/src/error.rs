@@ -62,6 +62,27 @@ pub enum Error {
     // Phase 8 additions
     #[error("Resource exhausted: {0}")]
     ResourceExhausted(String),
+
+    // Schema validation
+    #[error("Schema validation failed for attribute '{attribute}': {reason}")]
+    SchemaValidation { attribute: String, reason: String },
+
+    // Point-in-time recovery
+    #[error("Sequence number {requested} is no longer in the WAL (oldest retained: {oldest})")]
+    SeqTruncated { requested: u64, oldest: u64 },
+
+    // Interactive transactions
+    #[error("Transaction conflict: {0}")]
+    TransactionConflict(String),
+
+    #[error("Item size {size} bytes exceeds maximum of {limit} bytes")]
+    ItemTooLarge { size: usize, limit: usize },
+
+    #[error("Memory limit of {limit} bytes reached ({needed} bytes needed)")]
+    MemoryLimit { needed: u64, limit: u64 },
+
+    #[error("Operation canceled: {0}")]
+    Canceled(String),
 }
 
 impl Error {
@@ -87,6 +108,12 @@ impl Error {
             Error::TransactionCanceled(_) => "TRANSACTION_CANCELED",
             Error::InvalidQuery(_) => "INVALID_QUERY",
             Error::ResourceExhausted(_) => "RESOURCE_EXHAUSTED",
+            Error::SchemaValidation { .. } => "SCHEMA_VALIDATION",
+            Error::SeqTruncated { .. } => "SEQ_TRUNCATED",
+            Error::TransactionConflict(_) => "TRANSACTION_CONFLICT",
+            Error::ItemTooLarge { .. } => "ITEM_TOO_LARGE",
+            Error::MemoryLimit { .. } => "MEMORY_LIMIT",
+            Error::Canceled(_) => "CANCELED",
         }
     }
 
@@ -102,6 +129,7 @@ impl Error {
             Error::ResourceExhausted(_) => true,
             Error::CompactionError(_) => true,
             Error::StripeError(_) => true,
+            Error::TransactionConflict(_) => true,
 
             // Non-retryable errors (logical/permanent)
             Error::Corruption(_) => false,
@@ -117,6 +145,11 @@ impl Error {
             Error::ConditionalCheckFailed(_) => false,
             Error::TransactionCanceled(_) => false,
             Error::InvalidQuery(_) => false,
+            Error::SchemaValidation { .. } => false,
+            Error::SeqTruncated { .. } => false,
+            Error::ItemTooLarge { .. } => false,
+            Error::MemoryLimit { .. } => false,
+            Error::Canceled(_) => false,
         }
     }
 
@@ -0,0 +1,72 @@
+/// Engine lifecycle events
+///
+/// An embedded application can watch the engine's flushes and compactions,
+/// e.g. to chart write amplification or alert on slow compactions, by
+/// setting `DatabaseConfig::with_event_handler`. Events are delivered on
+/// the thread doing the work, while the engine holds its write lock: the
+/// handler must return quickly and must not call back into the database.
+/// Send events to a channel for anything slower. Disk databases only.
+///
+/// There is no WAL rotation event: the engine keeps appending to the one
+/// WAL it creates with the database and never rotates or resets it (old
+/// versions are read back from it), so there is nothing to report.
+
+use std::fmt;
+use std::sync::Arc;
+
+/// Something the engine did
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum EngineEvent {
+    /// A stripe's memtable is about to be written to an SST
+    FlushStarted {
+        stripe: usize,
+        /// Records in the memtable
+        records: usize,
+        /// Accounted size of the memtable
+        bytes: u64,
+    },
+    /// A stripe's memtable was written to an SST
+    FlushCompleted {
+        stripe: usize,
+        sst_id: u64,
+        records: usize,
+        /// Size of the new SST file
+        bytes: u64,
+    },
+    /// Some of a stripe's SSTs are about to be merged into one
+    CompactionStarted {
+        stripe: usize,
+        /// SSTs being merged
+        ssts: usize,
+        /// Total size of the SSTs being merged
+        bytes: u64,
+    },
+    /// Some of a stripe's SSTs were merged into one
+    CompactionCompleted {
+        stripe: usize,
+        sst_id: u64,
+        ssts: usize,
+        bytes_read: u64,
+        bytes_written: u64,
+    },
+}
+
+/// Callback receiving engine events
+#[derive(Clone)]
+pub struct EventHandler(Arc<dyn Fn(&EngineEvent) + Send + Sync>);
+
+impl EventHandler {
+    pub fn new(handler: impl Fn(&EngineEvent) + Send + Sync + 'static) -> Self {
+        Self(Arc::new(handler))
+    }
+
+    pub(crate) fn emit(&self, event: &EngineEvent) {
+        (self.0)(event)
+    }
+}
+
+impl fmt::Debug for EventHandler {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("EventHandler")
+    }
+}
@@ -46,6 +46,8 @@ pub enum Expr {
     LessThanOrEqual(Box<Expr>, Box<Expr>),
     GreaterThan(Box<Expr>, Box<Expr>),
     GreaterThanOrEqual(Box<Expr>, Box<Expr>),
+    /// operand BETWEEN lower AND upper (inclusive)
+    Between(Box<Expr>, Box<Expr>, Box<Expr>),
 
     // Logical operators
     And(Box<Expr>, Box<Expr>),
@@ -56,6 +58,10 @@ pub enum Expr {
     AttributeExists(String),
     AttributeNotExists(String),
     BeginsWith(Box<Expr>, Box<Expr>),
+    /// contains(path, operand): substring of a string, element of a list or member of a set
+    Contains(Box<Expr>, Box<Expr>),
+    /// attribute_type(path, type): type code such as "S", "N" or "L"
+    AttributeType(String, Box<Expr>),
 
     // Operands
     AttributePath(String),
@@ -132,6 +138,12 @@ impl<'a> ExpressionEvaluator<'a> {
                 let r = self.resolve_value(right)?;
                 Ok(self.compare_values(&l, &r)? >= 0)
             }
+            Expr::Between(operand, lower, upper) => {
+                let v = self.resolve_value(operand)?;
+                let lo = self.resolve_value(lower)?;
+                let hi = self.resolve_value(upper)?;
+                Ok(self.compare_values(&v, &lo)? >= 0 && self.compare_values(&v, &hi)? <= 0)
+            }
             Expr::And(left, right) => {
                 Ok(self.evaluate(left)? && self.evaluate(right)?)
             }
@@ -159,6 +171,32 @@ impl<'a> ExpressionEvaluator<'a> {
                     _ => Err(Error::InvalidExpression("begins_with requires string or binary operands".into()))
                 }
             }
+            Expr::Contains(path_expr, value_expr) => {
+                // A missing attribute contains nothing
+                let path_value = match self.resolve_optional(path_expr)? {
+                    Some(v) => v,
+                    None => return Ok(false),
+                };
+                let operand = self.resolve_value(value_expr)?;
+
+                match (&path_value, &operand) {
+                    (Value::S(s), Value::S(sub)) => Ok(s.contains(sub.as_str())),
+                    (Value::B(b), Value::B(sub)) => Ok(sub.is_empty() || b.windows(sub.len()).any(|w| w == sub.as_ref())),
+                    (Value::L(list), element) => Ok(list.contains(element)),
+                    (Value::SS(set), Value::S(member)) => Ok(set.contains(member)),
+                    (Value::NS(set), Value::N(member)) => Ok(set.contains(member)),
+                    (Value::BS(set), Value::B(member)) => Ok(set.contains(member)),
+                    _ => Ok(false),
+                }
+            }
+            Expr::AttributeType(path, type_expr) => {
+                let attr_name = self.resolve_attribute_name(path);
+                let expected = match self.resolve_value(type_expr)? {
+                    Value::S(code) => code,
+                    _ => return Err(Error::InvalidExpression("attribute_type requires a string type code".into())),
+                };
+                Ok(self.item.get(&attr_name).map_or(false, |v| value_type_code(v) == expected))
+            }
             Expr::AttributePath(_) | Expr::ValuePlaceholder(_) | Expr::Literal(_) => {
                 Err(Error::InvalidExpression("Cannot evaluate operand as boolean expression".into()))
             }
@@ -184,6 +222,17 @@ impl<'a> ExpressionEvaluator<'a> {
         }
     }
 
+    /// Resolve an operand, treating a missing attribute as None
+    fn resolve_optional(&self, expr: &Expr) -> Result<Option<Value>> {
+        match expr {
+            Expr::AttributePath(path) => {
+                let attr_name = self.resolve_attribute_name(path);
+                Ok(self.item.get(&attr_name).cloned())
+            }
+            _ => self.resolve_value(expr).map(Some),
+        }
+    }
+
     /// Resolve attribute name (handle #placeholder)
     fn resolve_attribute_name(&self, path: &str) -> String {
         if path.starts_with('#') {
@@ -214,6 +263,27 @@ impl<'a> ExpressionEvaluator<'a> {
     }
 }
 
+/// Type code used by `attribute_type`
+///
+/// Follows DynamoDB's codes ("S", "N", "B", "BOOL", "NULL", "L", "M",
+/// "SS", "NS", "BS"), plus "VEC" for f32 vectors and "TS" for timestamps.
+pub fn value_type_code(value: &Value) -> &'static str {
+    match value {
+        Value::S(_) => "S",
+        Value::N(_) => "N",
+        Value::B(_) => "B",
+        Value::Bool(_) => "BOOL",
+        Value::Null => "NULL",
+        Value::L(_) => "L",
+        Value::M(_) => "M",
+        Value::VecF32(_) => "VEC",
+        Value::Ts(_) => "TS",
+        Value::SS(_) => "SS",
+        Value::NS(_) => "NS",
+        Value::BS(_) => "BS",
+    }
+}
+
 /// Update expression actions
 #[derive(Debug, Clone, PartialEq)]
 pub enum UpdateAction {
@@ -239,6 +309,10 @@ pub enum UpdateValue {
     /// Arithmetic: path + value or path - value
     Add(String, Box<UpdateValue>),
     Sub(String, Box<UpdateValue>),
+    /// list_append(list1, list2)
+    ListAppend(Box<UpdateValue>, Box<UpdateValue>),
+    /// if_not_exists(path, value)
+    IfNotExists(String, Box<UpdateValue>),
 }
 
 /// Update executor
@@ -270,24 +344,46 @@ impl<'a> UpdateExecutor<'a> {
                     let attr_name = self.resolve_attribute_name(path);
                     let add_value = self.resolve_update_value(value, &result)?;
 
-                    if let Some(existing) = result.get(&attr_name) {
-                        // Add to existing number
-                        match (existing, &add_value) {
+                    if let Some(existing) = result.get_mut(&attr_name) {
+                        // Add to existing number, or union into existing set
+                        match (existing, add_value) {
                             (Value::N(n1), Value::N(n2)) => {
                                 let num1: f64 = n1.parse().map_err(|_| Error::InvalidExpression("Invalid number".into()))?;
                                 let num2: f64 = n2.parse().map_err(|_| Error::InvalidExpression("Invalid number".into()))?;
-                                result.insert(attr_name, Value::number(num1 + num2));
+                                *n1 = (num1 + num2).to_string();
                             }
-                            _ => return Err(Error::InvalidExpression("ADD requires numbers".into()))
+                            (Value::SS(set), Value::SS(members)) | (Value::NS(set), Value::NS(members)) => {
+                                set.extend(members);
+                            }
+                            (Value::BS(set), Value::BS(members)) => set.extend(members),
+                            _ => return Err(Error::InvalidExpression("ADD requires a number or a set of the attribute's type".into()))
                         }
                     } else {
                         // Initialize with value
                         result.insert(attr_name, add_value);
                     }
                 }
-                UpdateAction::Delete(_path, _value) => {
-                    // DELETE is for sets - not implementing full set support in this phase
-                    return Err(Error::InvalidExpression("DELETE action not yet supported".into()));
+                UpdateAction::Delete(path, value) => {
+                    let attr_name = self.resolve_attribute_name(path);
+                    let delete_value = self.resolve_update_value(value, &result)?;
+
+                    // Deleting from a missing attribute is a no-op
+                    let now_empty = match (result.get_mut(&attr_name), delete_value) {
+                        (None, Value::SS(_) | Value::NS(_) | Value::BS(_)) => continue,
+                        (Some(Value::SS(set)), Value::SS(members)) | (Some(Value::NS(set)), Value::NS(members)) => {
+                            set.retain(|m| !members.contains(m));
+                            set.is_empty()
+                        }
+                        (Some(Value::BS(set)), Value::BS(members)) => {
+                            set.retain(|m| !members.contains(m));
+                            set.is_empty()
+                        }
+                        _ => return Err(Error::InvalidExpression("DELETE requires a set of the attribute's type".into()))
+                    };
+                    // Sets are never empty; removing the last member removes the attribute
+                    if now_empty {
+                        result.remove(&attr_name);
+                    }
                 }
             }
         }
@@ -341,6 +437,25 @@ impl<'a> UpdateExecutor<'a> {
                     _ => Err(Error::InvalidExpression("Subtraction requires numbers".into()))
                 }
             }
+            UpdateValue::ListAppend(first, second) => {
+                let first = self.resolve_update_value(first, item)?;
+                let second = self.resolve_update_value(second, item)?;
+
+                match (first, second) {
+                    (Value::L(mut l1), Value::L(l2)) => {
+                        l1.extend(l2);
+                        Ok(Value::L(l1))
+                    }
+                    _ => Err(Error::InvalidExpression("list_append requires list operands".into()))
+                }
+            }
+            UpdateValue::IfNotExists(path, default) => {
+                let attr_name = self.resolve_attribute_name(path);
+                match item.get(&attr_name) {
+                    Some(existing) => Ok(existing.clone()),
+                    None => self.resolve_update_value(default, item),
+                }
+            }
         }
     }
 
@@ -370,6 +485,7 @@ enum Token {
     And,
     Or,
     Not,
+    Between,
 
     // Update keywords
     Set,
@@ -385,6 +501,8 @@ enum Token {
     AttributeExists,
     AttributeNotExists,
     BeginsWith,
+    Contains,
+    AttributeType,
 
     // Identifiers and literals
     Identifier(String),
@@ -443,6 +561,12 @@ impl Lexer {
         self.input[start..self.pos].iter().collect()
     }
 
+    /// Whether the next non-whitespace character is `(`, i.e. the word just
+    /// read is a function name rather than an attribute
+    fn followed_by_paren(&self) -> bool {
+        self.input[self.pos..].iter().find(|ch| !ch.is_whitespace()) == Some(&'(')
+    }
+
     fn next_token(&mut self) -> Result<Token> {
         self.skip_whitespace();
 
@@ -509,6 +633,7 @@ impl Lexer {
                     "AND" => Ok(Token::And),
                     "OR" => Ok(Token::Or),
                     "NOT" => Ok(Token::Not),
+                    "BETWEEN" => Ok(Token::Between),
                     "SET" => Ok(Token::Set),
                     "REMOVE" => Ok(Token::Remove),
                     "ADD" => Ok(Token::Add),
@@ -516,6 +641,8 @@ impl Lexer {
                     "ATTRIBUTE_EXISTS" => Ok(Token::AttributeExists),
                     "ATTRIBUTE_NOT_EXISTS" => Ok(Token::AttributeNotExists),
                     "BEGINS_WITH" => Ok(Token::BeginsWith),
+                    "CONTAINS" if self.followed_by_paren() => Ok(Token::Contains),
+                    "ATTRIBUTE_TYPE" if self.followed_by_paren() => Ok(Token::AttributeType),
                     _ => Ok(Token::Identifier(ident)),
                 }
             }
@@ -639,6 +766,13 @@ impl ExpressionParser {
                 let right = self.parse_operand()?;
                 Ok(Expr::GreaterThanOrEqual(Box::new(left), Box::new(right)))
             }
+            Token::Between => {
+                self.advance();
+                let lower = self.parse_operand()?;
+                self.expect(Token::And)?;
+                let upper = self.parse_operand()?;
+                Ok(Expr::Between(Box::new(left), Box::new(lower), Box::new(upper)))
+            }
             _ => Ok(left) // Could be a function call that returns bool
         }
     }
@@ -696,6 +830,29 @@ impl ExpressionParser {
                 self.expect(Token::RightParen)?;
                 Ok(Expr::BeginsWith(Box::new(path), Box::new(prefix)))
             }
+            Token::Contains => {
+                self.advance();
+                self.expect(Token::LeftParen)?;
+                let path = self.parse_operand()?;
+                self.expect(Token::Comma)?;
+                let operand = self.parse_operand()?;
+                self.expect(Token::RightParen)?;
+                Ok(Expr::Contains(Box::new(path), Box::new(operand)))
+            }
+            Token::AttributeType => {
+                self.advance();
+                self.expect(Token::LeftParen)?;
+                let path = match self.current().clone() {
+                    Token::Identifier(p) => p,
+                    Token::NamePlaceholder(p) => p,
+                    _ => return Err(Error::InvalidExpression("Expected attribute path".into()))
+                };
+                self.advance();
+                self.expect(Token::Comma)?;
+                let type_code = self.parse_operand()?;
+                self.expect(Token::RightParen)?;
+                Ok(Expr::AttributeType(path, Box::new(type_code)))
+            }
             _ => Err(Error::InvalidExpression(format!("Unexpected token: {:?}", self.current())))
         }
     }
@@ -735,6 +892,19 @@ impl UpdateExpressionParser {
         self.pos += 1;
     }
 
+    fn peek(&self) -> &Token {
+        self.tokens.get(self.pos + 1).unwrap_or(&Token::Eof)
+    }
+
+    fn expect(&mut self, expected: Token) -> Result<()> {
+        if self.current() == &expected {
+            self.advance();
+            Ok(())
+        } else {
+            Err(Error::InvalidExpression(format!("Expected {:?}, got {:?}", expected, self.current())))
+        }
+    }
+
     fn parse_update_expr(&mut self) -> Result<Vec<UpdateAction>> {
         let mut actions = Vec::new();
 
@@ -873,6 +1043,33 @@ impl UpdateExpressionParser {
     fn parse_update_value(&mut self) -> Result<UpdateValue> {
         // First, get the base value (path or placeholder)
         let base = match self.current() {
+            Token::Identifier(p) if self.peek() == &Token::LeftParen => {
+                let function = p.to_lowercase();
+                self.advance();
+                self.advance();
+                let value = match function.as_str() {
+                    "list_append" => {
+                        let first = self.parse_update_value()?;
+                        self.expect(Token::Comma)?;
+                        let second = self.parse_update_value()?;
+                        UpdateValue::ListAppend(Box::new(first), Box::new(second))
+                    }
+                    "if_not_exists" => {
+                        let path = match self.current() {
+                            Token::Identifier(p) => p.clone(),
+                            Token::NamePlaceholder(p) => p.clone(),
+                            _ => return Err(Error::InvalidExpression("Expected attribute path in if_not_exists".into()))
+                        };
+                        self.advance();
+                        self.expect(Token::Comma)?;
+                        let default = self.parse_update_value()?;
+                        UpdateValue::IfNotExists(path, Box::new(default))
+                    }
+                    _ => return Err(Error::InvalidExpression(format!("Unknown update function: {}", function)))
+                };
+                self.expect(Token::RightParen)?;
+                value
+            }
             Token::Identifier(p) => {
                 let path = p.clone();
                 self.advance();
@@ -1059,6 +1256,46 @@ mod tests {
         assert!(matches!(expr, Expr::BeginsWith(_, _)));
     }
 
+    #[test]
+    fn test_contains_and_attribute_type() {
+        let mut item = HashMap::new();
+        item.insert("tags".to_string(), Value::L(vec![Value::string("red"), Value::string("blue")]));
+        item.insert("title".to_string(), Value::string("hello world"));
+
+        let context = ExpressionContext::new()
+            .with_value(":t", Value::string("blue"))
+            .with_value(":w", Value::string("world"))
+            .with_value(":list", Value::string("L"))
+            .with_value(":num", Value::string("N"));
+        let evaluator = ExpressionEvaluator::new(&item, &context);
+
+        let eval = |s: &str| evaluator.evaluate(&ExpressionParser::parse(s).unwrap()).unwrap();
+        assert!(eval("contains(tags, :t)"));
+        assert!(eval("contains(title, :w)"));
+        assert!(!eval("contains(missing, :t)"));
+        assert!(eval("attribute_type(tags, :list)"));
+        assert!(!eval("attribute_type(title, :num)"));
+        assert!(!eval("attribute_type(missing, :list)"));
+    }
+
+    #[test]
+    fn test_function_names_are_attributes_without_parens() {
+        let mut item = HashMap::new();
+        item.insert("contains".to_string(), Value::string("glass"));
+        item.insert("attribute_type".to_string(), Value::number(2));
+
+        let context = ExpressionContext::new()
+            .with_value(":c", Value::string("glass"))
+            .with_value(":n", Value::number(2))
+            .with_value(":s", Value::string("S"));
+        let evaluator = ExpressionEvaluator::new(&item, &context);
+
+        let eval = |s: &str| evaluator.evaluate(&ExpressionParser::parse(s).unwrap()).unwrap();
+        assert!(eval("contains = :c"));
+        assert!(eval("attribute_type = :n AND contains (contains, :c)"));
+        assert!(eval("attribute_type(contains, :s)"));
+    }
+
     #[test]
     fn test_parse_complex_expression() {
         let expr = ExpressionParser::parse(
@@ -1092,6 +1329,30 @@ mod tests {
         assert!(evaluator.evaluate(&expr).unwrap());
     }
 
+    #[test]
+    fn test_parse_between_and_evaluate() {
+        let mut item = HashMap::new();
+        item.insert("age".to_string(), Value::number(25));
+        item.insert("active".to_string(), Value::Bool(true));
+
+        let expr = ExpressionParser::parse("age BETWEEN :lo AND :hi AND active = :t").unwrap();
+        assert!(matches!(expr, Expr::And(_, _)));
+
+        let context = ExpressionContext::new()
+            .with_value(":lo", Value::number(18))
+            .with_value(":hi", Value::number(25))
+            .with_value(":t", Value::Bool(true));
+        let evaluator = ExpressionEvaluator::new(&item, &context);
+        assert!(evaluator.evaluate(&expr).unwrap());
+
+        let context = ExpressionContext::new()
+            .with_value(":lo", Value::number(30))
+            .with_value(":hi", Value::number(40))
+            .with_value(":t", Value::Bool(true));
+        let evaluator = ExpressionEvaluator::new(&item, &context);
+        assert!(!evaluator.evaluate(&expr).unwrap());
+    }
+
     // Update expression tests
     #[test]
     fn test_update_set_simple() {
@@ -1197,4 +1458,84 @@ mod tests {
             _ => panic!("Expected number"),
         }
     }
+
+    #[test]
+    fn test_update_list_append() {
+        let mut item = HashMap::new();
+        item.insert("tags".to_string(), Value::L(vec![Value::string("a")]));
+
+        let actions = UpdateExpressionParser::parse("SET tags = list_append(tags, :more)").unwrap();
+        let context = ExpressionContext::new()
+            .with_value(":more", Value::L(vec![Value::string("b"), Value::string("c")]));
+
+        let executor = UpdateExecutor::new(&context);
+        let result = executor.execute(&item, &actions).unwrap();
+        assert_eq!(
+            result.get("tags").unwrap(),
+            &Value::L(vec![Value::string("a"), Value::string("b"), Value::string("c")])
+        );
+    }
+
+    #[test]
+    fn test_update_if_not_exists() {
+        let mut item = HashMap::new();
+        item.insert("created".to_string(), Value::number(1));
+
+        let actions = UpdateExpressionParser::parse(
+            "SET created = if_not_exists(created, :now), views = if_not_exists(#v, :zero)"
+        ).unwrap();
+        let context = ExpressionContext::new()
+            .with_value(":now", Value::number(99))
+            .with_value(":zero", Value::number(0))
+            .with_name("#v", "views");
+
+        let executor = UpdateExecutor::new(&context);
+        let result = executor.execute(&item, &actions).unwrap();
+        assert_eq!(result.get("created").unwrap(), &Value::number(1));
+        assert_eq!(result.get("views").unwrap(), &Value::number(0));
+    }
+
+    #[test]
+    fn test_update_set_add_and_delete() {
+        let mut item = HashMap::new();
+        item.insert("tags".to_string(), Value::string_set(["a", "b"]));
+
+        let actions = UpdateExpressionParser::parse("ADD tags :more, ids :ids DELETE tags :gone").unwrap();
+        let context = ExpressionContext::new()
+            .with_value(":more", Value::string_set(["b", "c"]))
+            .with_value(":ids", Value::number_set([1, 2]))
+            .with_value(":gone", Value::string_set(["a", "missing"]));
+
+        let executor = UpdateExecutor::new(&context);
+        let result = executor.execute(&item, &actions).unwrap();
+        assert_eq!(result.get("tags").unwrap(), &Value::string_set(["b", "c"]));
+        assert_eq!(result.get("ids").unwrap(), &Value::number_set([1, 2]));
+
+        // Removing the last members removes the attribute
+        let actions = UpdateExpressionParser::parse("DELETE tags :all").unwrap();
+        let context = ExpressionContext::new().with_value(":all", Value::string_set(["b", "c"]));
+        let result = UpdateExecutor::new(&context).execute(&result, &actions).unwrap();
+        assert!(!result.contains_key("tags"));
+
+        // Set types must match
+        let actions = UpdateExpressionParser::parse("ADD ids :names").unwrap();
+        let context = ExpressionContext::new().with_value(":names", Value::string_set(["x"]));
+        assert!(UpdateExecutor::new(&context).execute(&result, &actions).is_err());
+    }
+
+    #[test]
+    fn test_contains_set_member() {
+        let mut item = HashMap::new();
+        item.insert("tags".to_string(), Value::string_set(["red", "blue"]));
+
+        let context = ExpressionContext::new()
+            .with_value(":red", Value::string("red"))
+            .with_value(":green", Value::string("green"));
+        let evaluator = ExpressionEvaluator::new(&item, &context);
+
+        let expr = ExpressionParser::parse("contains(tags, :red)").unwrap();
+        assert!(evaluator.evaluate(&expr).unwrap());
+        let expr = ExpressionParser::parse("contains(tags, :green)").unwrap();
+        assert!(!evaluator.evaluate(&expr).unwrap());
+    }
 }
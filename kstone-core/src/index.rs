@@ -307,10 +307,92 @@ pub fn is_index_key(encoded: &[u8]) -> bool {
     !encoded.is_empty() && encoded[0] == INDEX_MARKER
 }
 
+/// Reserved attribute in index records holding the base-table key
+///
+/// Stripped from query results; used to read through to the base item.
+pub const BASE_KEY_ATTRIBUTE: &str = "__kstone_base_key";
+
+/// Build the item stored in an index record
+///
+/// Keeps the attributes selected by `projection` (always including the
+/// index key attributes) and records the base-table key.
+pub fn project_index_item(
+    item: &crate::Item,
+    projection: &IndexProjection,
+    key_attributes: &[&str],
+    base_key: &crate::Key,
+) -> crate::Item {
+    use crate::Value;
+
+    let mut projected: crate::Item = match projection {
+        IndexProjection::All => item.clone(),
+        IndexProjection::KeysOnly => item
+            .iter()
+            .filter(|(name, _)| key_attributes.contains(&name.as_str()))
+            .map(|(name, value)| (name.clone(), value.clone()))
+            .collect(),
+        IndexProjection::Include(attributes) => item
+            .iter()
+            .filter(|(name, _)| {
+                key_attributes.contains(&name.as_str()) || attributes.contains(name)
+            })
+            .map(|(name, value)| (name.clone(), value.clone()))
+            .collect(),
+    };
+
+    let mut key = std::collections::HashMap::new();
+    key.insert("pk".to_string(), Value::B(base_key.pk.clone()));
+    if let Some(sk) = &base_key.sk {
+        key.insert("sk".to_string(), Value::B(sk.clone()));
+    }
+    projected.insert(BASE_KEY_ATTRIBUTE.to_string(), Value::M(key));
+
+    projected
+}
+
+/// Remove the base-table key from an index record's item
+pub fn take_base_key(item: &mut crate::Item) -> Option<crate::Key> {
+    use crate::Value;
+
+    match item.remove(BASE_KEY_ATTRIBUTE)? {
+        Value::M(key) => {
+            let pk = match key.get("pk")? {
+                Value::B(pk) => pk.clone(),
+                _ => return None,
+            };
+            match key.get("sk") {
+                Some(Value::B(sk)) => Some(crate::Key::with_sk(pk, sk.clone())),
+                _ => Some(crate::Key::new(pk)),
+            }
+        }
+        _ => None,
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_project_index_item() {
+        use crate::{Key, Value};
+
+        let mut item = std::collections::HashMap::new();
+        item.insert("email".to_string(), Value::string("a@example.com"));
+        item.insert("name".to_string(), Value::string("Alice"));
+        item.insert("bio".to_string(), Value::string("long text"));
+
+        let base_key = Key::with_sk(Bytes::from("user#1"), Bytes::from("profile"));
+        let projection = IndexProjection::Include(vec!["name".to_string()]);
+        let mut projected = project_index_item(&item, &projection, &["email"], &base_key);
+
+        assert_eq!(take_base_key(&mut projected), Some(base_key));
+        assert_eq!(projected.len(), 2);
+        assert!(projected.contains_key("email"));
+        assert!(projected.contains_key("name"));
+        assert!(!projected.contains_key("bio"));
+    }
+
     #[test]
     fn test_lsi_creation() {
         let lsi = LocalSecondaryIndex::new("email-index", "email");
@@ -144,6 +144,9 @@ pub struct QueryResult {
     pub last_key: Option<Key>,
     /// Count of items examined (before filter)
     pub scanned_count: usize,
+    /// Base-table keys of the returned items, parallel to `items`
+    /// (index queries only; empty for base-table queries)
+    pub base_keys: Vec<Option<Key>>,
 }
 
 impl QueryResult {
@@ -152,8 +155,15 @@ impl QueryResult {
             items,
             last_key,
             scanned_count,
+            base_keys: Vec::new(),
         }
     }
+
+    /// Attach the base-table keys of index query results
+    pub fn with_base_keys(mut self, base_keys: Vec<Option<Key>>) -> Self {
+        self.base_keys = base_keys;
+        self
+    }
 }
 
 /// Scan parameters for table/stripe scanning
@@ -167,6 +177,10 @@ pub struct ScanParams {
     pub segment: Option<usize>,
     /// Total number of segments (for parallel scans)
     pub total_segments: Option<usize>,
+    /// First key of the range to scan (inclusive)
+    pub range_start: Option<Key>,
+    /// End of the range to scan (exclusive)
+    pub range_end: Option<Key>,
 }
 
 impl ScanParams {
@@ -177,6 +191,8 @@ impl ScanParams {
             start_key: None,
             segment: None,
             total_segments: None,
+            range_start: None,
+            range_end: None,
         }
     }
 
@@ -199,6 +215,19 @@ impl ScanParams {
         self
     }
 
+    /// Restrict the scan to keys in `start..end` (in key order)
+    pub fn with_range(mut self, start: Option<Key>, end: Option<Key>) -> Self {
+        self.range_start = start;
+        self.range_end = end;
+        self
+    }
+
+    /// Check if a key falls within the scan's key range
+    pub fn in_range(&self, key: &Key) -> bool {
+        self.range_start.as_ref().map_or(true, |start| key >= start)
+            && self.range_end.as_ref().map_or(true, |end| key < end)
+    }
+
     /// Check if a stripe should be scanned by this segment
     pub fn should_scan_stripe(&self, stripe_id: usize) -> bool {
         match (self.segment, self.total_segments) {
@@ -7,6 +7,8 @@ pub mod mmap;
 pub mod bloom; // Phase 1.4+ bloom filters
 pub mod wal;
 pub mod wal_ring; // Phase 1.3+ ring buffer WAL
+pub mod wal_tail; // Tailing committed writes
+pub mod snapshot; // Point-in-time read views
 pub mod memory_wal; // Phase 5+ in-memory WAL
 pub mod memory_sst; // Phase 5+ in-memory SST
 pub mod memory_lsm; // Phase 5+ in-memory LSM engine
@@ -24,12 +26,34 @@ pub mod partiql; // Phase 4+ PartiQL (SQL-compatible query language)
 pub mod config; // Phase 8+ database configuration
 pub mod retry; // Phase 8+ retry logic with exponential backoff
 pub mod validation; // Schema validation and constraints
+pub mod diff; // Value equality and item diffs
+pub mod export; // Portable export format
+pub mod value_compression; // Per-attribute compression of large values
+pub mod digest; // Partition digests for reconciliation
+pub mod decode; // Decoding items into Rust types
+pub mod repair; // Salvaging damaged databases
+pub mod attribute_ttl; // Per-attribute expiry
+pub mod capacity; // Consumed capacity units
+pub mod incremental; // Incremental backup diffs
+pub mod events; // Flush and compaction events
+pub mod subscribe; // In-process change subscriptions
+pub mod aggregate; // Aggregates over query and scan results
 
 pub use error::{Error, Result};
 pub use types::*;
-pub use lsm::{LsmEngine, TransactWriteOperation};
-pub use memory_lsm::MemoryLsmEngine;
-pub use compaction::{CompactionConfig, CompactionStats};
-pub use config::DatabaseConfig;
+pub use lsm::{LsmEngine, TransactWriteOperation, TransactWriteOutcome, CancellationReason};
+pub use memory_lsm::{EvictionPolicy, MemoryLimit, MemoryLsmEngine};
+pub use wal_tail::{WalTail, WalTailEvent};
+pub use snapshot::Snapshot;
+pub use compaction::{CompactionConfig, CompactionStats, CompactionStyle};
+pub use config::{DatabaseConfig, DEFAULT_TIMESTAMP_ATTRIBUTE};
+pub use diff::{value_equal, item_diff, DiffKind};
+pub use export::{ExportManifest, ExportReader, ExportRecord, ExportWriter};
+pub use incremental::{DiffReader, DiffRecord};
+pub use events::{EngineEvent, EventHandler};
+pub use subscribe::{ChangeEvent, ChangeKind, Subscription};
+pub use decode::from_item;
+pub use capacity::ConsumedCapacity;
+pub use repair::{repair, RepairOptions, RepairReport};
 pub use retry::{RetryPolicy, retry_with_policy, retry};
 pub use validation::{AttributeSchema, AttributeType, ValueConstraint, Validator};
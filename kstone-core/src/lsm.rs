@@ -1,21 +1,31 @@
-use crate::{Error, Result, Record, Key, Item, SeqNo, Value, wal::Wal, sst::{SstWriter, SstReader}};
+use crate::{Error, Result, Record, Key, Item, SeqNo, Lsn, Value, wal::Wal, sst::{SstWriter, SstReader}, IntegrityProblem, IntegrityReport};
 use crate::iterator::{QueryParams, QueryResult, ScanParams, ScanResult};
 use crate::expression::{UpdateAction, UpdateExecutor, ExpressionContext, Expr, ExpressionEvaluator};
-use crate::index::{TableSchema, encode_index_key, decode_index_key};
-use crate::compaction::{CompactionManager, CompactionConfig, CompactionStatsAtomic};
+use crate::index::{TableSchema, encode_index_key, decode_index_key, project_index_item, take_base_key};
+use crate::compaction::{CompactionManager, CompactionConfig, CompactionStatsAtomic, MIN_SSTS_TO_COMPACT};
 use crate::config::DatabaseConfig;
+use crate::wal_tail::{WalTail, WalTailHub, DEFAULT_WAL_TAIL_CAPACITY};
+use crate::snapshot::{Snapshot, SnapshotState};
+use crate::attribute_ttl::{remove_expired, resolve_expiring, resolved};
+use crate::events::EngineEvent;
+use crate::subscribe::{Subscribers, Subscription};
 use bytes::Bytes;
 use parking_lot::RwLock;
 use std::collections::BTreeMap;
 use std::path::{Path, PathBuf};
-use std::sync::Arc;
+use std::sync::{Arc, Weak};
 use std::fs;
+use tracing::{debug, info};
 
 /// Legacy constant - now configured via DatabaseConfig::max_memtable_records
 /// Default is now 10,000 (acts as safety ceiling)
 const MEMTABLE_THRESHOLD: usize = 10_000;
 const NUM_STRIPES: usize = 256;
 
+/// File holding the highest sequence number written by a bulk load, whose
+/// writes never went through the WAL
+const BULK_LOAD_FILE: &str = "bulk_load";
+
 /// LSM engine with 256-way striping (Phase 1.6+)
 ///
 /// Flushing behavior:
@@ -71,6 +81,8 @@ impl Stripe {
                         // f32 vectors: 4 bytes per element
                         vec.len() * 4
                     }
+                    Value::SS(set) | Value::NS(set) => set.iter().map(String::len).sum(),
+                    Value::BS(set) => set.iter().map(|b| b.len()).sum(),
                 };
             }
         }
@@ -79,6 +91,45 @@ impl Stripe {
     }
 }
 
+/// A WAL write that may still need syncing (see `DatabaseConfig::group_commit_window`)
+enum PendingCommit {
+    Durable,
+    Wait { wal: Wal, lsn: Lsn, window: std::time::Duration },
+}
+
+/// A base-table write waiting in the WAL for a group commit
+///
+/// It is applied to the memtable and published only once it is durable,
+/// so readers never see a write that could still be lost.
+struct StagedWrite {
+    lsn: Lsn,
+    record: Record,
+}
+
+/// Size of an SST's file, for compaction planning and statistics
+fn sst_file_size(sst: &SstReader) -> u64 {
+    fs::metadata(sst.path()).map(|m| m.len()).unwrap_or(0)
+}
+
+/// Highest sequence number a bulk load wrote into `dir` (0 if none)
+fn bulk_loaded_through(dir: &Path) -> Result<SeqNo> {
+    let path = dir.join(BULK_LOAD_FILE);
+    if !path.exists() {
+        return Ok(0);
+    }
+    let text = fs::read_to_string(&path)?;
+    text.trim()
+        .parse()
+        .map_err(|_| Error::Corruption(format!("Invalid {}: {:?}", BULK_LOAD_FILE, text)))
+}
+
+/// Oldest sequence number whose history the WAL still holds; bulk-loaded
+/// writes never reached it
+fn oldest_retained(records: &[(Lsn, Record)], bulk_loaded_through: SeqNo) -> SeqNo {
+    let oldest = records.iter().map(|(_, r)| r.seq).min().unwrap_or(1);
+    oldest.max(bulk_loaded_through + 1)
+}
+
 struct LsmInner {
     dir: PathBuf,
     wal: Wal,
@@ -90,6 +141,12 @@ struct LsmInner {
     compaction_config: CompactionConfig,  // Compaction configuration (Phase 1.7+)
     compaction_stats: CompactionStatsAtomic,  // Compaction statistics (Phase 1.7+)
     config: DatabaseConfig,  // Database configuration (Phase 8+)
+    wal_tail: Arc<WalTailHub>,  // Recent committed writes for tails
+    subscribers: Subscribers,  // In-process change subscriptions
+    snapshots: Vec<Weak<SnapshotState>>,  // Open snapshots
+    last_timestamp: i64,  // Last auto-timestamp stamped on a write
+    bulk_loaded_through: SeqNo,  // Highest bulk-loaded sequence number (0 if none)
+    staged: std::collections::VecDeque<StagedWrite>,  // Writes waiting for a group commit, in LSN order
 }
 
 /// Transaction write operation (Phase 2.7+)
@@ -127,7 +184,77 @@ impl TransactWriteOperation {
     }
 }
 
+/// Why a single transaction operation caused a cancellation
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct CancellationReason {
+    /// Reason code ("None" for operations that did not fail)
+    pub code: String,
+    /// Human-readable detail (None for operations that did not fail)
+    pub message: Option<String>,
+}
+
+impl CancellationReason {
+    /// Code for operations that did not cause the cancellation
+    pub const NONE: &'static str = "None";
+    /// Code for operations whose condition evaluated to false
+    pub const CONDITIONAL_CHECK_FAILED: &'static str = "ConditionalCheckFailed";
+
+    /// Reason for an operation that did not fail
+    pub fn none() -> Self {
+        Self {
+            code: Self::NONE.to_string(),
+            message: None,
+        }
+    }
+
+    /// Reason for an operation whose condition failed
+    pub fn condition_failed(key: &Key) -> Self {
+        Self {
+            code: Self::CONDITIONAL_CHECK_FAILED.to_string(),
+            message: Some(format!("Condition failed for key {:?}", key)),
+        }
+    }
+
+    /// Whether this operation did not cause the cancellation
+    pub fn is_none(&self) -> bool {
+        self.code == Self::NONE
+    }
+}
+
+/// Result of a transaction write that ran to a decision
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum TransactWriteOutcome {
+    /// All operations were applied (count of operations)
+    Committed(usize),
+    /// Nothing was applied; one reason per operation, in request order
+    Canceled(Vec<CancellationReason>),
+}
+
+impl TransactWriteOutcome {
+    /// Convert to the committed count, or `TransactionCanceled` naming the
+    /// first failed operation
+    pub fn into_result(self) -> Result<usize> {
+        match self {
+            Self::Committed(committed) => Ok(committed),
+            Self::Canceled(reasons) => {
+                let message = reasons
+                    .iter()
+                    .find_map(|r| r.message.clone())
+                    .unwrap_or_else(|| "Transaction canceled".to_string());
+                Err(Error::TransactionCanceled(message))
+            }
+        }
+    }
+}
+
 impl LsmInner {
+    /// Pass `event` to the configured event handler, if any
+    fn emit(&self, event: EngineEvent) {
+        if let Some(handler) = &self.config.event_handler {
+            handler.emit(&event);
+        }
+    }
+
     /// Check if a stripe needs to flush based on configured limits
     fn should_flush_stripe(&self, stripe_id: usize) -> bool {
         let stripe = &self.stripes[stripe_id];
@@ -147,6 +274,77 @@ impl LsmInner {
         false
     }
 
+    /// Make the WAL record at `lsn` durable, now or (with a group commit
+    /// window) once the engine lock is released
+    fn commit_wal(&self, lsn: Lsn) -> Result<PendingCommit> {
+        let window = self.config.group_commit_window;
+        if window.is_zero() {
+            self.wal.flush()?;
+            return Ok(PendingCommit::Durable);
+        }
+        Ok(PendingCommit::Wait { wal: self.wal.clone(), lsn, window })
+    }
+
+    /// Newest sequence number whose write readers can see: every earlier
+    /// one is applied too, while staged writes are not yet
+    fn applied_seq(&self) -> SeqNo {
+        self.staged.front().map_or(self.next_seq, |staged| staged.record.seq) - 1
+    }
+
+    /// Reject items larger than the configured maximum item size
+    fn check_item_size(&self, item: &Item) -> Result<()> {
+        let size = crate::types::item_size(item);
+        if size > self.config.max_item_size_bytes {
+            return Err(Error::ItemTooLarge {
+                size,
+                limit: self.config.max_item_size_bytes,
+            });
+        }
+        Ok(())
+    }
+
+    /// Announce a committed base-table write before it is applied
+    ///
+    /// Open snapshots keep the version the write replaces, then tails and
+    /// subscribers are given the write.
+    fn publish(&mut self, record: &Record) {
+        self.snapshots.retain(|s| s.strong_count() > 0);
+        if !self.snapshots.is_empty() {
+            let previous = self.newest_record(&record.key);
+            for snapshot in self.snapshots.iter().filter_map(Weak::upgrade) {
+                snapshot.preserve(&record.key, previous.clone());
+            }
+        }
+        self.wal_tail.publish(record);
+        if !self.subscribers.is_empty() {
+            let existed = self.newest_record(&record.key).map_or(false, |r| r.value.is_some());
+            self.subscribers.publish(record, existed);
+        }
+    }
+
+    /// Stamp the configured auto-timestamp attribute on an item being written
+    ///
+    /// Stamps strictly increase, even for writes in the same millisecond.
+    fn stamp(&mut self, item: &mut Item) {
+        if let Some(attribute) = &self.config.auto_timestamp_attribute {
+            let now = std::time::SystemTime::now()
+                .duration_since(std::time::UNIX_EPOCH)
+                .map(|d| d.as_millis() as i64)
+                .unwrap_or(0);
+            self.last_timestamp = now.max(self.last_timestamp + 1);
+            item.insert(attribute.clone(), Value::Ts(self.last_timestamp));
+        }
+    }
+
+    /// Newest version of a key (possibly a tombstone) from the memtable or SSTs
+    fn newest_record(&self, key: &Key) -> Option<Record> {
+        let stripe = &self.stripes[key.stripe() as usize];
+        match stripe.memtable.get(key.encode().as_ref()) {
+            Some(record) => Some(record.clone()),
+            None => stripe.ssts.iter().find_map(|sst| sst.get(key)).cloned(),
+        }
+    }
+
     /// Insert a record into a stripe's memtable, tracking size
     fn insert_into_memtable(&mut self, stripe_id: usize, key_enc: Vec<u8>, record: Record) {
         let record_size = Stripe::estimate_record_size(&key_enc, &record);
@@ -192,6 +390,10 @@ impl LsmEngine {
         }
 
         let wal = Wal::create(&wal_path)?;
+        wal.set_value_compression_threshold(config.value_compression_threshold);
+
+        let compaction_config = config.compaction.clone().unwrap_or_default();
+        compaction_config.store(dir)?;
 
         // Initialize 256 stripes
         let stripes = (0..NUM_STRIPES).map(|_| Stripe::new()).collect();
@@ -205,9 +407,15 @@ impl LsmEngine {
                 next_sst_id: 1,
                 schema,
                 stream_buffer: std::collections::VecDeque::new(),
-                compaction_config: CompactionConfig::default(),
+                compaction_config,
                 compaction_stats: CompactionStatsAtomic::new(),
                 config,
+                wal_tail: WalTailHub::new(DEFAULT_WAL_TAIL_CAPACITY),
+                subscribers: Subscribers::default(),
+                snapshots: Vec::new(),
+                last_timestamp: 0,
+                bulk_loaded_through: 0,
+                staged: std::collections::VecDeque::new(),
             })),
             path: dir.to_path_buf(),
         })
@@ -215,10 +423,27 @@ impl LsmEngine {
 
     /// Open existing database
     pub fn open(dir: impl AsRef<Path>) -> Result<Self> {
+        Self::open_with_config(dir, DatabaseConfig::default())
+    }
+
+    /// Open existing database with custom configuration
+    pub fn open_with_config(dir: impl AsRef<Path>, config: DatabaseConfig) -> Result<Self> {
+        config.validate().map_err(|e| Error::InvalidArgument(e))?;
+
         let dir = dir.as_ref();
         let wal_path = dir.join("wal.log");
 
         let wal = Wal::open(&wal_path)?;
+        wal.set_value_compression_threshold(config.value_compression_threshold);
+
+        // An explicit policy replaces the stored one
+        let compaction_config = match &config.compaction {
+            Some(compaction) => {
+                compaction.store(dir)?;
+                compaction.clone()
+            }
+            None => CompactionConfig::load(dir)?.unwrap_or_default(),
+        };
 
         // Initialize 256 stripes
         let mut stripes: Vec<Stripe> = (0..NUM_STRIPES).map(|_| Stripe::new()).collect();
@@ -261,16 +486,34 @@ impl LsmEngine {
             stripe.ssts.reverse();
         }
 
-        // Recover from WAL
+        // Bulk loads write SSTs without logging them, so their sequence
+        // numbers count as well as the WAL's
+        let mut max_seq = stripes
+            .iter()
+            .flat_map(|stripe| stripe.ssts.iter())
+            .flat_map(|sst| sst.iter())
+            .map(|record| record.seq)
+            .max()
+            .unwrap_or(0);
+
+        // Recover from WAL, seeding the tail history with base-table writes
         let records = wal.read_all()?;
-        let mut max_seq = 0;
+        let wal_tail = WalTailHub::new(DEFAULT_WAL_TAIL_CAPACITY);
+        let bulk_loaded_through = bulk_loaded_through(dir)?;
+        wal_tail.skip_through(bulk_loaded_through);
 
+        let replayed = records.len();
         for (_lsn, record) in records {
             max_seq = max_seq.max(record.seq);
             let key_enc = record.key.encode().to_vec();
+            // Index records use the encoded index key as their partition key
+            if !crate::index::is_index_key(&record.key.pk) {
+                wal_tail.publish(&record);
+            }
             let stripe_id = record.key.stripe() as usize;
             stripes[stripe_id].memtable.insert(key_enc, record);
         }
+        info!(records = replayed, last_seq = max_seq, "Replayed WAL");
 
         Ok(Self {
             inner: Arc::new(RwLock::new(LsmInner {
@@ -281,75 +524,176 @@ impl LsmEngine {
                 next_sst_id: max_sst_id + 1,
                 schema: TableSchema::new(), // TODO: Load from manifest in future
                 stream_buffer: std::collections::VecDeque::new(),
-                compaction_config: CompactionConfig::default(),
+                compaction_config,
                 compaction_stats: CompactionStatsAtomic::new(),
-                config: DatabaseConfig::default(), // TODO: Load from manifest in future
+                config,
+                wal_tail,
+                subscribers: Subscribers::default(),
+                snapshots: Vec::new(),
+                last_timestamp: 0,
+                bulk_loaded_through,
+                staged: std::collections::VecDeque::new(),
             })),
             path: dir.to_path_buf(),
         })
     }
 
     /// Put an item
-    pub fn put(&self, key: Key, item: Item) -> Result<()> {
+    pub fn put(&self, key: Key, mut item: Item) -> Result<()> {
         let mut inner = self.inner.write();
+        inner.stamp(&mut item);
+        let commit = self.put_locked(&mut inner, key, item)?;
 
-        // Check if item exists (for stream record) (Phase 3.4+)
-        let old_image = if inner.schema.stream_config.enabled {
-            let stripe_id = key.stripe() as usize;
-            let key_enc = key.encode().to_vec();
-            inner.stripes[stripe_id].memtable.get(&key_enc).and_then(|r| r.value.clone())
-        } else {
-            None
-        };
+        // Wait for the WAL sync without blocking other writers
+        drop(inner);
+        self.finish_commit(commit)
+    }
+
+    /// Put an item while already holding the write lock
+    ///
+    /// The returned commit must be finished after the lock is released.
+    fn put_locked(&self, inner: &mut LsmInner, key: Key, item: Item) -> Result<PendingCommit> {
+        inner.check_item_size(&item)?;
+        inner.schema.validate_item(&item)?;
 
         let seq = inner.next_seq;
         inner.next_seq += 1;
 
-        let record = Record::put(key.clone(), item.clone(), seq);
+        self.log_write(inner, Record::put(key, item, seq))
+    }
 
-        // Write to WAL
-        inner.wal.append(record.clone())?;
-        inner.wal.flush()?;
+    /// Write a base-table record to the WAL and apply it once durable
+    ///
+    /// Without a group commit window the record is synced and applied now.
+    /// Otherwise it is staged, unseen by readers, and the returned commit
+    /// must be passed to `finish_commit` after the lock is released.
+    fn log_write(&self, inner: &mut LsmInner, record: Record) -> Result<PendingCommit> {
+        let lsn = inner.wal.append(record.clone())?;
+        let commit = inner.commit_wal(lsn)?;
+        match commit {
+            PendingCommit::Durable => {
+                // The sync also covered any staged writes, which come first
+                self.apply_staged(inner)?;
+                self.apply_write(inner, record)?;
+            }
+            PendingCommit::Wait { .. } => inner.staged.push_back(StagedWrite { lsn, record }),
+        }
+        Ok(commit)
+    }
 
-        // Route to correct stripe
-        let stripe_id = record.key.stripe() as usize;
-        let key_enc = record.key.encode().to_vec();
-        inner.insert_into_memtable(stripe_id, key_enc, record);
+    /// Wait for a write's group commit, then apply it along with the other
+    /// writes the same sync made durable; call without holding the lock
+    ///
+    /// A write whose sync failed is dropped without ever being applied.
+    fn finish_commit(&self, commit: PendingCommit) -> Result<()> {
+        let (wal, lsn, window) = match commit {
+            PendingCommit::Durable => return Ok(()),
+            PendingCommit::Wait { wal, lsn, window } => (wal, lsn, window),
+        };
+        let synced = wal.commit(lsn, window);
 
-        // Materialize LSI entries (Phase 3.1+)
-        if !inner.schema.local_indexes.is_empty() {
-            self.materialize_lsi_entries(&mut inner, &key, &item)?;
+        let mut inner = self.inner.write();
+        if synced.is_err() {
+            inner.staged.retain(|staged| staged.lsn != lsn);
         }
+        self.apply_staged(&mut inner)?;
+        synced
+    }
 
-        // Materialize GSI entries (Phase 3.2+)
-        if !inner.schema.global_indexes.is_empty() {
-            self.materialize_gsi_entries(&mut inner, &key, &item)?;
+    /// Apply the staged writes that are now durable, in WAL order
+    fn apply_staged(&self, inner: &mut LsmInner) -> Result<()> {
+        let durable_lsn = inner.wal.durable_lsn();
+        while inner.staged.front().map_or(false, |staged| staged.lsn <= durable_lsn) {
+            if let Some(staged) = inner.staged.pop_front() {
+                self.apply_write(inner, staged.record)?;
+            }
         }
+        Ok(())
+    }
 
-        // Emit stream record (Phase 3.4+)
-        if inner.schema.stream_config.enabled {
-            let stream_record = if let Some(old) = old_image {
-                crate::stream::StreamRecord::modify(
-                    seq,
-                    key.clone(),
-                    old,
-                    item.clone(),
-                    inner.schema.stream_config.view_type,
-                )
-            } else {
-                crate::stream::StreamRecord::insert(
-                    seq,
-                    key.clone(),
-                    item.clone(),
-                    inner.schema.stream_config.view_type,
-                )
-            };
-            self.emit_stream_record(&mut inner, stream_record);
+    /// Sync and apply every staged write, so that reads under the write
+    /// lock see all accepted writes before checking conditions on them
+    fn settle(&self, inner: &mut LsmInner) -> Result<()> {
+        if inner.staged.is_empty() {
+            return Ok(());
+        }
+        inner.wal.flush()?;
+        self.apply_staged(inner)
+    }
+
+    /// Apply a durable base-table write: publish it, add it to the
+    /// memtable, and maintain indexes and the stream
+    fn apply_write(&self, inner: &mut LsmInner, record: Record) -> Result<()> {
+        let key = record.key.clone();
+        let seq = record.seq;
+        let stripe_id = key.stripe() as usize;
+        let key_enc = key.encode().to_vec();
+
+        // Check if item exists (for stream record) (Phase 3.4+)
+        let old_image = if inner.schema.stream_config.enabled {
+            inner.stripes[stripe_id].memtable.get(&key_enc).and_then(|r| r.value.clone())
+        } else {
+            None
+        };
+
+        inner.publish(&record);
+
+        match record.value.clone() {
+            Some(item) => {
+                inner.insert_into_memtable(stripe_id, key_enc, record);
+
+                // Materialize LSI entries (Phase 3.1+)
+                if !inner.schema.local_indexes.is_empty() {
+                    self.materialize_lsi_entries(inner, &key, &item)?;
+                }
+
+                // Materialize GSI entries (Phase 3.2+)
+                if !inner.schema.global_indexes.is_empty() {
+                    self.materialize_gsi_entries(inner, &key, &item)?;
+                }
+
+                // Emit stream record (Phase 3.4+)
+                if inner.schema.stream_config.enabled {
+                    let stream_record = if let Some(old) = old_image {
+                        crate::stream::StreamRecord::modify(
+                            seq,
+                            key,
+                            old,
+                            item,
+                            inner.schema.stream_config.view_type,
+                        )
+                    } else {
+                        crate::stream::StreamRecord::insert(
+                            seq,
+                            key,
+                            item,
+                            inner.schema.stream_config.view_type,
+                        )
+                    };
+                    self.emit_stream_record(inner, stream_record);
+                }
+            }
+            None => {
+                inner.stripes[stripe_id].memtable.insert(key_enc, record);
+
+                // Emit stream record (Phase 3.4+)
+                if inner.schema.stream_config.enabled {
+                    if let Some(old) = old_image {
+                        let stream_record = crate::stream::StreamRecord::remove(
+                            seq,
+                            key,
+                            old,
+                            inner.schema.stream_config.view_type,
+                        );
+                        self.emit_stream_record(inner, stream_record);
+                    }
+                }
+            }
         }
 
         // Check if this stripe needs to flush
         if inner.should_flush_stripe(stripe_id) {
-            self.flush_stripe(&mut inner, stripe_id)?;
+            self.flush_stripe(inner, stripe_id)?;
         }
 
         Ok(())
@@ -392,7 +736,7 @@ impl LsmEngine {
                     return Ok(None);
                 }
             }
-            return Ok(record.value.clone());
+            return Ok(record.value.clone().map(resolved));
         }
 
         // Check stripe's SSTs (newest to oldest)
@@ -407,59 +751,132 @@ impl LsmEngine {
                         return Ok(None);
                     }
                 }
-                return Ok(record.value.clone());
+                return Ok(record.value.clone().map(resolved));
             }
         }
 
         Ok(None)
     }
 
-    /// Delete an item
-    pub fn delete(&self, key: Key) -> Result<()> {
-        let mut inner = self.inner.write();
+    /// All live items under partition key `pk`, in sort key order
+    pub fn partition_items(&self, pk: &Bytes) -> Result<Vec<(Key, Item)>> {
+        let inner = self.inner.read();
+        let stripe = &inner.stripes[Key::new(pk.clone()).stripe() as usize];
 
-        // Check if item exists (for stream record) (Phase 3.4+)
-        let old_image = if inner.schema.stream_config.enabled {
-            let stripe_id = key.stripe() as usize;
-            let key_enc = key.encode().to_vec();
-            inner.stripes[stripe_id].memtable.get(&key_enc).and_then(|r| r.value.clone())
-        } else {
-            None
-        };
+        // The newest version of each key wins; tombstones shadow older ones
+        let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+        for record in stripe.memtable.values().filter(|r| r.key.pk == *pk) {
+            newest.insert(&record.key, record);
+        }
+        for sst in &stripe.ssts {
+            for record in sst.scan_prefix(pk) {
+                newest.entry(&record.key).or_insert(record);
+            }
+        }
 
-        let seq = inner.next_seq;
-        inner.next_seq += 1;
+        Ok(newest
+            .into_iter()
+            .filter_map(|(key, record)| match &record.value {
+                Some(item) if !inner.schema.is_expired(item) => Some((key.clone(), resolved(item.clone()))),
+                _ => None,
+            })
+            .collect())
+    }
 
-        let record = Record::delete(key.clone(), seq);
+    /// Item count and size of every partition, in partition key order
+    ///
+    /// Walks the keys in the memtables and SSTs without copying any item.
+    pub fn partition_sizes(&self) -> Result<Vec<crate::PartitionStat>> {
+        let inner = self.inner.read();
+        let mut stats: BTreeMap<Bytes, crate::PartitionStat> = BTreeMap::new();
 
-        // Write to WAL
-        inner.wal.append(record.clone())?;
-        inner.wal.flush()?;
+        for stripe in &inner.stripes {
+            // The newest version of each key wins; tombstones shadow older ones
+            let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+            for record in stripe.memtable.values() {
+                newest.insert(&record.key, record);
+            }
+            for sst in &stripe.ssts {
+                for record in sst.iter() {
+                    newest.entry(&record.key).or_insert(record);
+                }
+            }
 
-        // Route to correct stripe
-        let stripe_id = record.key.stripe() as usize;
-        let key_enc = record.key.encode().to_vec();
-        inner.stripes[stripe_id].memtable.insert(key_enc, record);
-
-        // Emit stream record (Phase 3.4+)
-        if inner.schema.stream_config.enabled {
-            if let Some(old) = old_image {
-                let stream_record = crate::stream::StreamRecord::remove(
-                    seq,
-                    key.clone(),
-                    old,
-                    inner.schema.stream_config.view_type,
-                );
-                self.emit_stream_record(&mut inner, stream_record);
+            for (key, record) in newest {
+                let Some(item) = &record.value else { continue };
+                if crate::index::is_index_key(&key.pk) || inner.schema.is_expired(item) {
+                    continue;
+                }
+                let stat = stats.entry(key.pk.clone()).or_insert_with(|| crate::PartitionStat {
+                    pk: key.pk.clone(),
+                    items: 0,
+                    bytes: 0,
+                });
+                stat.items += 1;
+                stat.bytes += crate::item_size(item) as u64;
             }
         }
 
-        // Check if this stripe needs to flush
-        if inner.should_flush_stripe(stripe_id) {
-            self.flush_stripe(&mut inner, stripe_id)?;
+        Ok(stats.into_values().collect())
+    }
+
+    /// Count the items under partition key `pk` without copying them
+    pub fn count_partition(&self, pk: &Bytes) -> Result<u64> {
+        let inner = self.inner.read();
+        let stripe = &inner.stripes[Key::new(pk.clone()).stripe() as usize];
+        let is_live = |record: &Record| {
+            record
+                .value
+                .as_ref()
+                .map_or(false, |item| !inner.schema.is_expired(item))
+        };
+
+        // The newest version of each key decides whether it counts
+        let mut live: BTreeMap<&Key, bool> = BTreeMap::new();
+        for record in stripe.memtable.values().filter(|r| r.key.pk == *pk) {
+            live.insert(&record.key, is_live(record));
+        }
+        for sst in &stripe.ssts {
+            for record in sst.scan_prefix(pk) {
+                live.entry(&record.key).or_insert_with(|| is_live(record));
+            }
         }
 
-        Ok(())
+        Ok(live.values().filter(|l| **l).count() as u64)
+    }
+
+    /// Check whether an item exists without copying it
+    ///
+    /// Expired items (TTL) count as absent; unlike `get`, they are not
+    /// deleted here.
+    pub fn exists(&self, key: &Key) -> Result<bool> {
+        let inner = self.inner.read();
+        let stripe = &inner.stripes[key.stripe() as usize];
+        let key_enc = key.encode().to_vec();
+
+        let record = match stripe.memtable.get(&key_enc) {
+            Some(record) => Some(record),
+            None => stripe.ssts.iter().find_map(|sst| sst.get(key)),
+        };
+
+        Ok(match record.and_then(|r| r.value.as_ref()) {
+            Some(item) => !inner.schema.is_expired(item),
+            None => false,
+        })
+    }
+
+    /// Delete an item
+    pub fn delete(&self, key: Key) -> Result<()> {
+        let mut inner = self.inner.write();
+
+        let seq = inner.next_seq;
+        inner.next_seq += 1;
+
+        let commit = self.log_write(&mut inner, Record::delete(key, seq))?;
+
+        // Wait for the WAL sync without blocking other writers
+        drop(inner);
+        self.finish_commit(commit)
     }
 
     /// Delete an item with a condition expression (Phase 2.5+)
@@ -481,17 +898,7 @@ impl LsmEngine {
 
     /// Update an item using update expression (Phase 2.4+)
     pub fn update(&self, key: &Key, actions: &[UpdateAction], context: &ExpressionContext) -> Result<Item> {
-        // First, get the current item (or create empty if doesn't exist)
-        let current_item = self.get(key)?.unwrap_or_else(|| std::collections::HashMap::new());
-
-        // Execute update actions
-        let executor = UpdateExecutor::new(context);
-        let updated_item = executor.execute(&current_item, actions)?;
-
-        // Put the updated item
-        self.put(key.clone(), updated_item.clone())?;
-
-        Ok(updated_item)
+        self.update_locked(key, actions, None, context)
     }
 
     /// Update an item with a condition expression (Phase 2.5+)
@@ -502,29 +909,91 @@ impl LsmEngine {
         condition: &Expr,
         context: &ExpressionContext,
     ) -> Result<Item> {
-        // Get current item (or create empty if doesn't exist)
-        let current_item = self.get(key)?.unwrap_or_else(|| std::collections::HashMap::new());
-
-        // Evaluate condition
-        let evaluator = ExpressionEvaluator::new(&current_item, context);
-        let condition_passed = evaluator.evaluate(condition)?;
+        self.update_locked(key, actions, Some(condition), context)
+    }
 
-        if !condition_passed {
-            return Err(Error::ConditionalCheckFailed("Update condition failed".into()));
+    /// Read, check, and rewrite an item under a single write lock so no
+    /// concurrent writer can slip in between the condition and the update
+    fn update_locked(
+        &self,
+        key: &Key,
+        actions: &[UpdateAction],
+        condition: Option<&Expr>,
+        context: &ExpressionContext,
+    ) -> Result<Item> {
+        let mut inner = self.inner.write();
+        self.settle(&mut inner)?;
+
+        // Current item (or empty if it doesn't exist or has expired), without
+        // expired attributes so the rewrite drops them
+        let mut current_item = inner
+            .newest_record(key)
+            .and_then(|record| record.value)
+            .filter(|item| !inner.schema.is_expired(item))
+            .unwrap_or_default();
+        remove_expired(&mut current_item);
+
+        if let Some(condition) = condition {
+            let visible = resolved(current_item.clone());
+            let evaluator = ExpressionEvaluator::new(&visible, context);
+            if !evaluator.evaluate(condition)? {
+                return Err(Error::ConditionalCheckFailed("Update condition failed".into()));
+            }
         }
 
-        // Condition passed, execute update
         let executor = UpdateExecutor::new(context);
-        let updated_item = executor.execute(&current_item, actions)?;
+        let mut updated_item = executor.execute(&current_item, actions)?;
+        inner.stamp(&mut updated_item);
+        let commit = self.put_locked(&mut inner, key.clone(), updated_item.clone())?;
+
+        drop(inner);
+        self.finish_commit(commit)?;
+        Ok(resolved(updated_item))
+    }
 
-        // Put the updated item
-        self.put(key.clone(), updated_item.clone())?;
+    /// Take a consistent read view of the database as it is now
+    ///
+    /// Writes made afterwards are not visible through the snapshot.
+    pub fn snapshot(&self) -> Snapshot {
+        let mut inner = self.inner.write();
+        // Staged writes are not visible yet and stay hidden from the snapshot
+        let state = SnapshotState::new(inner.applied_seq());
+        inner.snapshots.push(Arc::downgrade(&state));
+        Snapshot::new(
+            LsmEngine {
+                inner: Arc::clone(&self.inner),
+                path: self.path.clone(),
+            },
+            state,
+        )
+    }
 
-        Ok(updated_item)
+    /// Get an item as seen by `snapshot`, or live if None (no lazy TTL deletion)
+    pub(crate) fn get_at(&self, key: &Key, snapshot: Option<&SnapshotState>) -> Result<Option<Item>> {
+        let inner = self.inner.read();
+        let record = match snapshot.and_then(|s| s.lookup(key)) {
+            Some(preserved) => preserved,
+            None => inner.newest_record(key),
+        };
+        Ok(record
+            .and_then(|r| r.value)
+            .filter(|item| !inner.schema.is_expired(item))
+            .map(resolved))
     }
 
     /// Query items within a partition (Phase 2.1+)
     pub fn query(&self, params: QueryParams) -> Result<QueryResult> {
+        self.query_at(params, None)
+    }
+
+    /// Query as seen by `snapshot`, or live if None
+    pub(crate) fn query_at(&self, params: QueryParams, snapshot: Option<&SnapshotState>) -> Result<QueryResult> {
+        if snapshot.is_some() && params.index_name.is_some() {
+            return Err(Error::InvalidArgument(
+                "Index queries are not supported on snapshots".to_string(),
+            ));
+        }
+
         let inner = self.inner.read();
 
         // Route to correct stripe
@@ -535,6 +1004,7 @@ impl LsmEngine {
         let stripe = &inner.stripes[stripe_id];
 
         let mut items = Vec::new();
+        let mut base_keys = Vec::new();
         let mut seen_keys: std::collections::HashSet<Vec<u8>> = std::collections::HashSet::new();
         let mut scanned_count = 0;
         let mut last_key = None;
@@ -593,6 +1063,23 @@ impl LsmEngine {
             // SST scanning will be added when we implement SST iterators
         }
 
+        // Keys written since the snapshot revert to their snapshot versions
+        if let Some(snapshot) = snapshot {
+            snapshot.for_each(|key, record| {
+                if key.pk != params.pk || !params.matches_sk(&key.sk) {
+                    return;
+                }
+                match record {
+                    Some(record) => {
+                        all_records.insert(key.encode().to_vec(), record.clone());
+                    }
+                    None => {
+                        all_records.remove(key.encode().as_ref());
+                    }
+                }
+            });
+        }
+
         // Convert to sorted vec based on direction
         let mut sorted_records: Vec<(Vec<u8>, Record)> = all_records.into_iter().collect();
 
@@ -629,7 +1116,12 @@ impl LsmEngine {
 
             last_key = Some(record.key.clone());
 
-            if let Some(item) = record.value {
+            if let Some(mut item) = record.value {
+                // Index records carry the base key; keep it out of the item
+                if is_index_query {
+                    base_keys.push(take_base_key(&mut item));
+                }
+                resolve_expiring(&mut item);
                 items.push(item);
 
                 // Check limit
@@ -641,7 +1133,7 @@ impl LsmEngine {
             }
         }
 
-        Ok(QueryResult::new(items, last_key, scanned_count))
+        Ok(QueryResult::new(items, last_key, scanned_count).with_base_keys(base_keys))
     }
 
     /// Batch get multiple items (Phase 2.6+)
@@ -678,66 +1170,198 @@ impl LsmEngine {
 
     /// Transaction get - read multiple items atomically (Phase 2.7+)
     pub fn transact_get(&self, keys: &[Key]) -> Result<Vec<Option<Item>>> {
-        // Hold read lock for consistent snapshot
-        let _inner = self.inner.read();
+        self.transact_get_checked(keys, &[], &ExpressionContext::new())
+    }
 
-        let mut items = Vec::new();
-        for key in keys {
-            let item = self.get(key)?;
-            items.push(item);
+    /// Transaction get that also asserts conditions on other items
+    ///
+    /// Reads and checks happen under one read lock, so the items returned
+    /// are from the same state in which every condition held. Fails with
+    /// `ConditionalCheckFailed` if any condition is false.
+    pub fn transact_get_checked(
+        &self,
+        keys: &[Key],
+        checks: &[(Key, Expr)],
+        context: &ExpressionContext,
+    ) -> Result<Vec<Option<Item>>> {
+        // Every read goes through this one guard: calling `get` here would
+        // take the lock again, and its lazy TTL delete needs the write lock
+        let inner = self.inner.read();
+        let live = |key: &Key| {
+            inner
+                .newest_record(key)
+                .and_then(|record| record.value)
+                .filter(|item| !inner.schema.is_expired(item))
+                .map(resolved)
+        };
+
+        for (index, (key, condition)) in checks.iter().enumerate() {
+            let current_item = live(key).unwrap_or_default();
+            if !ExpressionEvaluator::new(&current_item, context).evaluate(condition)? {
+                return Err(Error::ConditionalCheckFailed(format!(
+                    "Condition check {} failed for key {:?}",
+                    index, key
+                )));
+            }
         }
 
-        Ok(items)
+        Ok(keys.iter().map(live).collect())
+    }
+
+    /// Get an item with its version
+    ///
+    /// The version is the sequence number of the key's newest record, or 0
+    /// if the key has never been written. Any write to the key changes it.
+    pub fn get_versioned(&self, key: &Key) -> Result<(Option<Item>, SeqNo)> {
+        let inner = self.inner.read();
+        Ok(match inner.newest_record(key) {
+            Some(record) => {
+                let seq = record.seq;
+                (record.value.filter(|item| !inner.schema.is_expired(item)).map(resolved), seq)
+            }
+            None => (None, 0),
+        })
+    }
+
+    /// Write `operations` atomically if every key in `reads` is still at the given version
+    ///
+    /// Optimistic commit for interactive transactions: versions come from
+    /// `get_versioned`. Fails with `Error::TransactionConflict`, writing
+    /// nothing, if any of them changed.
+    pub fn commit_if_unchanged(
+        &self,
+        reads: &[(Key, SeqNo)],
+        operations: &[(Key, TransactWriteOperation)],
+    ) -> Result<usize> {
+        let mut inner = self.inner.write();
+        self.settle(&mut inner)?;
+        for (key, version) in reads {
+            if inner.newest_record(key).map_or(0, |r| r.seq) != *version {
+                return Err(Error::TransactionConflict(format!(
+                    "Item '{}' changed since it was read",
+                    String::from_utf8_lossy(&key.pk)
+                )));
+            }
+        }
+        self.transact_write_locked(&mut inner, operations, &ExpressionContext::new())?
+            .into_result()
+    }
+
+    /// Put each item whose key does not already hold a live item
+    ///
+    /// All inserts happen in one atomic batch under a single lock. Returns
+    /// one flag per item, in order: true if it was inserted, false if its
+    /// key already existed (including an earlier item in the same call).
+    pub fn insert_many(&self, items: &[(Key, Item)]) -> Result<Vec<bool>> {
+        let mut inner = self.inner.write();
+        self.settle(&mut inner)?;
+
+        let mut inserted = Vec::with_capacity(items.len());
+        let mut taken = std::collections::HashSet::new();
+        let mut operations = Vec::new();
+        for (key, item) in items {
+            let exists = taken.contains(key)
+                || inner
+                    .newest_record(key)
+                    .and_then(|r| r.value)
+                    .map_or(false, |existing| !inner.schema.is_expired(&existing));
+            if !exists {
+                taken.insert(key.clone());
+                operations.push((
+                    key.clone(),
+                    TransactWriteOperation::Put { item: item.clone(), condition: None },
+                ));
+            }
+            inserted.push(!exists);
+        }
+
+        if !operations.is_empty() {
+            self.transact_write_locked(&mut inner, &operations, &ExpressionContext::new())?
+                .into_result()?;
+        }
+        Ok(inserted)
     }
 
     /// Transaction write - write multiple items atomically with conditions (Phase 2.7+)
+    ///
+    /// Fails with `TransactionCanceled` if any condition fails; use
+    /// `try_transact_write` to get per-operation cancellation reasons.
     pub fn transact_write(
         &self,
         operations: &[(Key, TransactWriteOperation)],
         context: &ExpressionContext,
     ) -> Result<usize> {
+        self.try_transact_write(operations, context)?.into_result()
+    }
+
+    /// Transaction write reporting per-operation cancellation reasons
+    ///
+    /// Every condition is evaluated before deciding; if any fails, nothing is
+    /// written and the outcome carries one reason per operation, in order.
+    pub fn try_transact_write(
+        &self,
+        operations: &[(Key, TransactWriteOperation)],
+        context: &ExpressionContext,
+    ) -> Result<TransactWriteOutcome> {
         // Acquire write lock for atomicity
         let mut inner = self.inner.write();
+        self.transact_write_locked(&mut inner, operations, context)
+    }
 
-        // Phase 1: Read all items and check all conditions
-        let mut current_items: Vec<Option<Item>> = Vec::new();
+    /// `try_transact_write` with the write lock already held
+    fn transact_write_locked(
+        &self,
+        inner: &mut LsmInner,
+        operations: &[(Key, TransactWriteOperation)],
+        context: &ExpressionContext,
+    ) -> Result<TransactWriteOutcome> {
+        // Staged writes must be visible to the conditions, and precede ours
+        self.settle(inner)?;
+
+        // Phase 1: Read all items, check all conditions, and build the items
+        // updates will write
+        let mut updated_items: Vec<Option<Item>> = Vec::new();
+        let mut reasons: Vec<CancellationReason> = Vec::new();
         for (key, op) in operations {
-            let item = {
-                let stripe_id = key.stripe() as usize;
-                let stripe = &inner.stripes[stripe_id];
-                let key_enc = key.encode().to_vec();
-
-                // Check memtable
-                if let Some(record) = stripe.memtable.get(&key_enc) {
-                    record.value.clone()
-                } else {
-                    // Check SSTs
-                    let mut found = None;
-                    for sst in &stripe.ssts {
-                        if let Some(record) = sst.get(key) {
-                            found = record.value.clone();
-                            break;
-                        }
-                    }
-                    found
-                }
-            };
-
-            current_items.push(item.clone());
+            // As in `update_locked`: an expired item is absent, expired
+            // attributes are dropped, and conditions see live values unwrapped
+            let mut current_item = inner
+                .newest_record(key)
+                .and_then(|record| record.value)
+                .filter(|item| !inner.schema.is_expired(item))
+                .unwrap_or_default();
+            remove_expired(&mut current_item);
+
+            if let TransactWriteOperation::Put { item: new_item, .. } = op {
+                inner.check_item_size(new_item)?;
+                inner.schema.validate_item(new_item)?;
+            }
 
             // Check condition if present
+            let mut reason = CancellationReason::none();
             if let Some(condition_expr) = op.condition() {
-                let current_item = item.unwrap_or_else(|| std::collections::HashMap::new());
-                let evaluator = ExpressionEvaluator::new(&current_item, context);
+                let visible = resolved(current_item.clone());
+                let evaluator = ExpressionEvaluator::new(&visible, context);
                 let condition_passed = evaluator.evaluate(condition_expr)?;
 
                 if !condition_passed {
-                    return Err(Error::TransactionCanceled(format!(
-                        "Condition failed for key {:?}",
-                        key
-                    )));
+                    reason = CancellationReason::condition_failed(key);
                 }
             }
+
+            let mut updated_item = None;
+            if let (TransactWriteOperation::Update { actions, .. }, true) = (op, reason.is_none()) {
+                let item = UpdateExecutor::new(context).execute(&current_item, actions)?;
+                inner.check_item_size(&item)?;
+                updated_item = Some(item);
+            }
+            updated_items.push(updated_item);
+            reasons.push(reason);
+        }
+
+        // Cancel without writing anything if any condition failed
+        if reasons.iter().any(|r| !r.is_none()) {
+            return Ok(TransactWriteOutcome::Canceled(reasons));
         }
 
         // Phase 2: All conditions passed, perform all writes
@@ -746,18 +1370,21 @@ impl LsmEngine {
             match op {
                 TransactWriteOperation::Put { item, .. } => {
                     // Perform put (without going through public API to avoid nested locks)
+                    let mut item = item.clone();
+                    inner.stamp(&mut item);
                     let seq = inner.next_seq;
                     inner.next_seq += 1;
-                    let record = Record::put(key.clone(), item.clone(), seq);
+                    let record = Record::put(key.clone(), item, seq);
                     inner.wal.append(record.clone())?;
                     inner.wal.flush()?;
+                    inner.publish(&record);
 
                     let stripe_id = record.key.stripe() as usize;
                     let key_enc = record.key.encode().to_vec();
                     inner.stripes[stripe_id].memtable.insert(key_enc, record);
 
                     if inner.stripes[stripe_id].memtable.len() >= MEMTABLE_THRESHOLD {
-                        self.flush_stripe(&mut inner, stripe_id)?;
+                        self.flush_stripe(inner, stripe_id)?;
                     }
 
                     committed += 1;
@@ -769,101 +1396,170 @@ impl LsmEngine {
                     let record = Record::delete(key.clone(), seq);
                     inner.wal.append(record.clone())?;
                     inner.wal.flush()?;
+                    inner.publish(&record);
 
                     let stripe_id = record.key.stripe() as usize;
                     let key_enc = record.key.encode().to_vec();
                     inner.stripes[stripe_id].memtable.insert(key_enc, record);
 
                     if inner.stripes[stripe_id].memtable.len() >= MEMTABLE_THRESHOLD {
-                        self.flush_stripe(&mut inner, stripe_id)?;
+                        self.flush_stripe(inner, stripe_id)?;
                     }
 
                     committed += 1;
                 }
-                TransactWriteOperation::Update { actions, .. } => {
-                    // Perform update
-                    let current_item = current_items[i].clone().unwrap_or_else(|| std::collections::HashMap::new());
-                    let executor = UpdateExecutor::new(context);
-                    let updated_item = executor.execute(&current_item, actions)?;
+                TransactWriteOperation::Update { .. } => {
+                    // Built and size-checked in phase 1
+                    let mut updated_item = updated_items[i].take().unwrap_or_default();
+                    inner.stamp(&mut updated_item);
 
                     let seq = inner.next_seq;
                     inner.next_seq += 1;
                     let record = Record::put(key.clone(), updated_item, seq);
                     inner.wal.append(record.clone())?;
                     inner.wal.flush()?;
+                    inner.publish(&record);
 
                     let stripe_id = record.key.stripe() as usize;
                     let key_enc = record.key.encode().to_vec();
                     inner.stripes[stripe_id].memtable.insert(key_enc, record);
 
                     if inner.stripes[stripe_id].memtable.len() >= MEMTABLE_THRESHOLD {
-                        self.flush_stripe(&mut inner, stripe_id)?;
+                        self.flush_stripe(inner, stripe_id)?;
                     }
 
-                    committed += 1;
-                }
-                TransactWriteOperation::ConditionCheck { .. } => {
-                    // Condition already checked in phase 1, no write needed
-                    committed += 1;
+                    committed += 1;
+                }
+                TransactWriteOperation::ConditionCheck { .. } => {
+                    // Condition already checked in phase 1, no write needed
+                    committed += 1;
+                }
+            }
+        }
+
+        Ok(TransactWriteOutcome::Committed(committed))
+    }
+
+    /// Scan with keys - returns (Key, Item) pairs for sync
+    ///
+    /// Returns the newest live version of each item in key order, skipping
+    /// index entries and sync metadata.
+    pub fn scan_with_keys(&self, limit: usize) -> Result<Vec<(Key, Item)>> {
+        let inner = self.inner.read();
+        let mut all_records: BTreeMap<Key, Record> = BTreeMap::new();
+        let wanted = |record: &Record| {
+            // Skip index records (start with 0xFF) and sync metadata
+            !record.key.pk.starts_with(&[0xFF]) && !record.key.pk.starts_with(b"_sync#")
+        };
+
+        for stripe in &inner.stripes {
+            // Memtable first: it holds the newest versions
+            for record in stripe.memtable.values() {
+                if wanted(record) {
+                    all_records.insert(record.key.clone(), record.clone());
+                }
+            }
+
+            // Then SSTs, newest first; tombstones shadow older versions
+            for sst in &stripe.ssts {
+                for record in sst.iter() {
+                    if wanted(record) {
+                        all_records.entry(record.key.clone()).or_insert_with(|| record.clone());
+                    }
+                }
+            }
+        }
+
+        Ok(all_records
+            .into_values()
+            .filter_map(|record| record.value.map(|item| (record.key, item)))
+            .take(limit)
+            .collect())
+    }
+
+    /// Keys of the items `scan` would return, without copying their values
+    ///
+    /// Honors the params' segment, key range, start key and limit; keys come
+    /// back in the same order as a scan's items.
+    pub fn scan_keys(&self, params: &ScanParams) -> Result<Vec<Key>> {
+        let inner = self.inner.read();
+        let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+
+        for stripe_id in (0..NUM_STRIPES).filter(|&id| params.should_scan_stripe(id)) {
+            let stripe = &inner.stripes[stripe_id];
+            let wanted = |record: &Record| {
+                !crate::index::is_index_key(&record.key.pk) && params.in_range(&record.key)
+            };
+
+            // Memtable first, then SSTs newest first, as in `scan`
+            for record in stripe.memtable.values().filter(|r| wanted(r)) {
+                newest.insert(&record.key, record);
+            }
+            for sst in &stripe.ssts {
+                for record in sst.iter().filter(|r| wanted(r)) {
+                    newest.entry(&record.key).or_insert(record);
                 }
             }
         }
 
-        Ok(committed)
+        let live = newest
+            .into_iter()
+            .filter(|(key, record)| match &record.value {
+                Some(item) => !params.should_skip(key) && !inner.schema.is_expired(item),
+                None => false,
+            })
+            .map(|(key, _)| key.clone());
+        Ok(live.take(params.limit.unwrap_or(usize::MAX)).collect())
     }
 
-    /// Scan with keys - returns (Key, Item) pairs for sync
-    pub fn scan_with_keys(&self, limit: usize) -> Result<Vec<(Key, Item)>> {
+    /// Distinct partition keys of all live items, in order
+    ///
+    /// Only keys are compared, so no item is copied. Partitions whose items
+    /// are all deleted or expired are left out; index partitions too.
+    pub fn partition_keys(&self) -> Result<Vec<Bytes>> {
         let inner = self.inner.read();
-        let mut results = Vec::new();
-        let mut count = 0;
+        let mut partitions = std::collections::BTreeSet::new();
 
-        // Scan all stripes
         for stripe in &inner.stripes {
-            // From memtable
-            for (_key_bytes, record) in &stripe.memtable {
-                if let Some(ref item) = record.value {
-                    // Skip index records (start with 0xFF) and sync metadata
-                    if !record.key.pk.starts_with(&[0xFF]) &&
-                       !record.key.pk.starts_with(b"_sync#") {
-                        results.push((record.key.clone(), item.clone()));
-                        count += 1;
-                        if count >= limit {
-                            return Ok(results);
-                        }
-                    }
+            // Newest version of each key in this stripe
+            let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+            for record in stripe.memtable.values() {
+                newest.insert(&record.key, record);
+            }
+            for sst in &stripe.ssts {
+                for record in sst.iter() {
+                    newest.entry(&record.key).or_insert(record);
                 }
             }
 
-            // From SSTs
-            for sst in &stripe.ssts {
-                if let Ok(records) = sst.scan() {
-                    for record in records {
-                        if let Some(ref item) = record.value {
-                            // Skip index records (start with 0xFF) and sync metadata
-                            if !record.key.pk.starts_with(&[0xFF]) &&
-                               !record.key.pk.starts_with(b"_sync#") {
-                                results.push((record.key.clone(), item.clone()));
-                                count += 1;
-                                if count >= limit {
-                                    return Ok(results);
-                                }
-                            }
-                        }
-                    }
+            for (key, record) in newest {
+                if partitions.contains(&key.pk) || crate::index::is_index_key(&key.pk) {
+                    continue;
+                }
+                if record.value.as_ref().map_or(false, |item| !inner.schema.is_expired(item)) {
+                    partitions.insert(key.pk.clone());
                 }
             }
         }
 
-        Ok(results)
+        Ok(partitions.into_iter().collect())
     }
 
     /// Scan all items across all stripes (Phase 2.2+)
+    ///
+    /// Items are returned in key order (partition key, then sort key, as
+    /// bytes), restricted to the params' key range if one is set. A
+    /// segmented scan is in key order within its segment.
     pub fn scan(&self, params: ScanParams) -> Result<ScanResult> {
+        self.scan_at(params, None)
+    }
+
+    /// Scan as seen by `snapshot`, or live if None
+    pub(crate) fn scan_at(&self, params: ScanParams, snapshot: Option<&SnapshotState>) -> Result<ScanResult> {
         let inner = self.inner.read();
 
-        // Collect all records from all stripes first, then sort globally
-        let mut all_records: BTreeMap<Vec<u8>, Record> = BTreeMap::new();
+        // Collect the newest version of each key from all stripes, sorted by key
+        let mut all_records: BTreeMap<Key, Record> = BTreeMap::new();
 
         // Scan all stripes (or subset for parallel scans)
         for stripe_id in 0..NUM_STRIPES {
@@ -873,19 +1569,45 @@ impl LsmEngine {
             }
 
             let stripe = &inner.stripes[stripe_id];
+            let wanted = |record: &Record| {
+                !crate::index::is_index_key(&record.key.pk) && params.in_range(&record.key)
+            };
 
-            // Collect from stripe's memtable
-            for (key_enc, record) in &stripe.memtable {
-                // Skip tombstones
-                if record.value.is_none() {
-                    continue;
+            // Memtable first: it holds the newest versions
+            for record in stripe.memtable.values() {
+                if wanted(record) {
+                    all_records.insert(record.key.clone(), record.clone());
                 }
+            }
 
-                all_records.insert(key_enc.clone(), record.clone());
+            // Then SSTs, newest first; tombstones are kept so they shadow older versions
+            for sst in &stripe.ssts {
+                for record in sst.scan()? {
+                    if wanted(&record) {
+                        all_records.entry(record.key.clone()).or_insert(record);
+                    }
+                }
             }
+        }
 
-            // TODO: Scan stripe's SSTs
-            // Will be added when we implement SST iterators
+        // Keys written since the snapshot revert to their snapshot versions
+        if let Some(snapshot) = snapshot {
+            snapshot.for_each(|key, record| {
+                if !params.should_scan_stripe(key.stripe() as usize)
+                    || crate::index::is_index_key(&key.pk)
+                    || !params.in_range(key)
+                {
+                    return;
+                }
+                match record {
+                    Some(record) => {
+                        all_records.insert(key.clone(), record.clone());
+                    }
+                    None => {
+                        all_records.remove(key);
+                    }
+                }
+            });
         }
 
         // Now apply pagination and limit on sorted records
@@ -894,8 +1616,8 @@ impl LsmEngine {
         let mut last_key = None;
 
         for (_, record) in all_records {
-            // Skip based on pagination
-            if params.should_skip(&record.key) {
+            // Skip deleted items and pagination
+            if record.value.is_none() || params.should_skip(&record.key) {
                 continue;
             }
 
@@ -911,7 +1633,7 @@ impl LsmEngine {
             last_key = Some(record.key.clone());
 
             if let Some(item) = record.value {
-                items.push(item);
+                items.push(resolved(item));
 
                 // Check limit
                 if let Some(limit) = params.limit {
@@ -944,8 +1666,13 @@ impl LsmEngine {
                 // Create index key
                 let index_key_encoded = encode_index_key(&lsi.name, &key.pk, &index_sk_bytes);
 
-                // Create index record with the full item (or projected attributes based on projection type)
-                let index_item = item.clone(); // For now, always store full item
+                // Create index record with the projected attributes and base key
+                let index_item = project_index_item(
+                    item,
+                    &lsi.projection,
+                    &[lsi.sort_key_attribute.as_str()],
+                    key,
+                );
 
                 // Create a synthetic Key from the encoded bytes
                 // Index records use the base table's PK + encoded index info
@@ -1013,8 +1740,12 @@ impl LsmEngine {
                 // Create GSI index key
                 let index_key_encoded = encode_index_key(&gsi.name, &gsi_pk_bytes, &gsi_sk_bytes);
 
-                // Create index record with the full item
-                let index_item = item.clone(); // For now, always store full item
+                // Create index record with the projected attributes and base key
+                let mut key_attributes = vec![gsi.partition_key_attribute.as_str()];
+                if let Some(sk_attr) = &gsi.sort_key_attribute {
+                    key_attributes.push(sk_attr.as_str());
+                }
+                let index_item = project_index_item(item, &gsi.projection, &key_attributes, base_key);
 
                 // Create a synthetic Key from the encoded bytes
                 let index_key = Key::new(Bytes::copy_from_slice(&index_key_encoded));
@@ -1064,6 +1795,141 @@ impl LsmEngine {
         Ok(records)
     }
 
+    /// Sequence number of the most recent write (0 if none)
+    ///
+    /// With a group commit window this includes writes still waiting for
+    /// their sync, which readers can't see yet; see `applied_seq`.
+    pub fn last_seq(&self) -> SeqNo {
+        self.inner.read().next_seq - 1
+    }
+
+    /// Newest sequence number whose write is visible to readers, along
+    /// with every earlier one (0 if none)
+    pub fn applied_seq(&self) -> SeqNo {
+        self.inner.read().applied_seq()
+    }
+
+    /// The version of `key` current as of sequence number `seq`
+    ///
+    /// Finds the newest write to `key` with a sequence number up to and
+    /// including `seq` and returns its item, or None if that write was a
+    /// delete or there was none. Old versions are read back from the WAL, so
+    /// this reads the whole log. Fails with `Error::SeqTruncated` if the WAL
+    /// no longer reaches back to `seq`, or `seq` is within a bulk load.
+    pub fn get_as_of(&self, key: &Key, seq: SeqNo) -> Result<Option<Item>> {
+        let (wal, current, bulk_loaded_through) = {
+            let inner = self.inner.read();
+            (inner.wal.clone(), inner.newest_record(key), inner.bulk_loaded_through)
+        };
+        let records = wal.read_all()?;
+
+        // Sequence numbers start at 1; a WAL starting later has lost history
+        let oldest = oldest_retained(&records, bulk_loaded_through);
+        if oldest > 1 && seq < oldest {
+            return Err(Error::SeqTruncated { requested: seq, oldest });
+        }
+
+        // A write still waiting for a group commit is not in the log yet
+        let version = records
+            .into_iter()
+            .map(|(_, record)| record)
+            .chain(current)
+            .filter(|record| record.key == *key && record.seq <= seq)
+            .max_by_key(|record| record.seq);
+        Ok(version.and_then(|record| record.value))
+    }
+
+    /// The latest write to each base-table key after sequence number `since`
+    ///
+    /// Returns the writes in key order, deletes included, with the highest
+    /// sequence number they cover (`since` if there were none). Writes are
+    /// read back from the WAL, so a write still waiting for a group commit
+    /// is left for the next call. Fails with `Error::SeqTruncated` if the
+    /// WAL no longer reaches back to the first write after `since`, which
+    /// includes any write made by a bulk load.
+    pub fn changes_since(&self, since: SeqNo) -> Result<(SeqNo, Vec<Record>)> {
+        let (wal, bulk_loaded_through) = {
+            let inner = self.inner.read();
+            (inner.wal.clone(), inner.bulk_loaded_through)
+        };
+        let records = wal.read_all()?;
+
+        let oldest = oldest_retained(&records, bulk_loaded_through);
+        if oldest > since + 1 {
+            return Err(Error::SeqTruncated { requested: since, oldest });
+        }
+
+        let mut through = since;
+        let mut latest: BTreeMap<Key, Record> = BTreeMap::new();
+        for (_lsn, record) in records {
+            if record.seq <= since {
+                continue;
+            }
+            through = through.max(record.seq);
+            // Skip index records and sync metadata, as scan_with_keys does
+            if crate::index::is_index_key(&record.key.pk) || record.key.pk.starts_with(b"_sync#") {
+                continue;
+            }
+            if latest.get(&record.key).map_or(true, |newest| newest.seq < record.seq) {
+                latest.insert(record.key.clone(), record);
+            }
+        }
+
+        Ok((through, latest.into_values().collect()))
+    }
+
+    /// Build a new database at `dest` with the state of `src` as of `seq`
+    ///
+    /// Replays the source WAL, applying base-table writes with sequence
+    /// numbers up to and including `seq`. The new database gets its own
+    /// sequence numbers and no index definitions. Fails with
+    /// `Error::SeqTruncated` if the WAL no longer reaches back to `seq`.
+    /// Bulk-loaded items never went through the WAL and cannot be replayed,
+    /// so a bulk-loaded source fails with `SeqTruncated` for every `seq`.
+    pub fn recover_to_seq(src: impl AsRef<Path>, dest: impl AsRef<Path>, seq: SeqNo) -> Result<Self> {
+        let bulk_loaded_through = bulk_loaded_through(src.as_ref())?;
+        if bulk_loaded_through > 0 {
+            return Err(Error::SeqTruncated { requested: seq, oldest: bulk_loaded_through + 1 });
+        }
+
+        let wal = Wal::open(src.as_ref().join("wal.log"))?;
+        let records = wal.read_all()?;
+
+        // Sequence numbers start at 1; a WAL starting later has lost history
+        let oldest = oldest_retained(&records, 0);
+        if oldest > 1 && seq < oldest {
+            return Err(Error::SeqTruncated { requested: seq, oldest });
+        }
+
+        let engine = Self::create(dest)?;
+        for (_lsn, record) in records {
+            if record.seq > seq || crate::index::is_index_key(&record.key.pk) {
+                continue;
+            }
+            match record.value {
+                Some(item) => engine.put(record.key, item)?,
+                None => engine.delete(record.key)?,
+            }
+        }
+
+        Ok(engine)
+    }
+
+    /// Follow committed writes starting at sequence number `from_seq`
+    ///
+    /// Delivers base-table puts and deletes (not index maintenance) in
+    /// sequence order, then blocks for new writes. If writes at or after
+    /// `from_seq` are no longer retained, the first event is a `Gap`.
+    pub fn tail_wal(&self, from_seq: SeqNo) -> WalTail {
+        self.inner.read().wal_tail.subscribe(from_seq)
+    }
+
+    /// Receive each committed base-table change to keys whose partition
+    /// key starts with `prefix`, buffering up to `buffer` of them
+    pub fn subscribe(&self, prefix: &[u8], buffer: usize) -> Subscription {
+        self.inner.write().subscribers.subscribe(Bytes::copy_from_slice(prefix), buffer)
+    }
+
     /// Emit a stream record if streams are enabled (Phase 3.4+)
     fn emit_stream_record(&self, inner: &mut LsmInner, record: crate::stream::StreamRecord) {
         if !inner.schema.stream_config.enabled {
@@ -1085,6 +1951,13 @@ impl LsmEngine {
             return Ok(());
         }
 
+        let records = inner.stripes[stripe_id].memtable.len();
+        inner.emit(EngineEvent::FlushStarted {
+            stripe: stripe_id,
+            records,
+            bytes: inner.stripes[stripe_id].memtable_size_bytes as u64,
+        });
+
         let sst_id = inner.next_sst_id;
         inner.next_sst_id += 1;
 
@@ -1095,7 +1968,8 @@ impl LsmEngine {
         let mut writer = SstWriter::with_compression(
             inner.config.compression_enabled,
             inner.config.compression_level,
-        );
+        )
+        .with_value_compression(inner.config.value_compression_threshold);
         for record in inner.stripes[stripe_id].memtable.values() {
             writer.add(record.clone());
         }
@@ -1103,6 +1977,18 @@ impl LsmEngine {
 
         // Load the new SST
         let reader = SstReader::open(&sst_path)?;
+        debug!(
+            stripe = stripe_id,
+            sst_id,
+            records,
+            "Flushed memtable to SST"
+        );
+        inner.emit(EngineEvent::FlushCompleted {
+            stripe: stripe_id,
+            sst_id,
+            records,
+            bytes: sst_file_size(&reader),
+        });
 
         // Add to front (newest SST) of this stripe
         inner.stripes[stripe_id].ssts.insert(0, reader);
@@ -1111,39 +1997,190 @@ impl LsmEngine {
         inner.stripes[stripe_id].memtable.clear();
         inner.stripes[stripe_id].memtable_size_bytes = 0;
 
-        // Check if compaction is needed for this stripe (Phase 1.7+)
-        if inner.compaction_config.enabled && inner.stripes[stripe_id].ssts.len() >= inner.compaction_config.sst_threshold {
-            // Start compaction statistics tracking
-            let _guard = inner.compaction_stats.start_compaction();
+        self.compact_if_needed(inner, stripe_id)
+    }
+
+    /// Compact a stripe as its compaction policy asks (Phase 1.7+)
+    fn compact_if_needed(&self, inner: &mut LsmInner, stripe_id: usize) -> Result<()> {
+        // A tiered merge may complete a run of the next tier, so repeat
+        loop {
+            let sizes: Vec<u64> = inner.stripes[stripe_id].ssts.iter().map(sst_file_size).collect();
+            let count = inner.compaction_config.ssts_to_compact(&sizes);
+            if count < MIN_SSTS_TO_COMPACT {
+                break;
+            }
+            self.compact_newest(inner, stripe_id, count)?;
+        }
+
+        Ok(())
+    }
+
+    /// Load items straight into SSTs, bypassing the WAL and memtables
+    ///
+    /// For seeding a new database: items are sorted into stripes and each
+    /// stripe is written out as SSTs of up to `max_memtable_records`
+    /// records, then every file is synced. Later duplicates of a key
+    /// replace earlier ones. Returns the number of items loaded.
+    ///
+    /// There is no crash safety while loading: if the process dies part way
+    /// through, delete the database and load it again. Items are checked
+    /// against the size limit and attribute schemas as usual, but are not
+    /// indexed, streamed or sent to WAL tails, so bulk loading fails with
+    /// `InvalidArgument` on tables with indexes or a stream, and on
+    /// databases that already hold regular writes (whose WAL replay would
+    /// otherwise shadow the loaded items). Other writers wait until the
+    /// load finishes.
+    ///
+    /// The loaded sequence numbers are recorded as missing from the WAL:
+    /// tails starting before them get a `Gap`, and `get_as_of`,
+    /// `changes_since` and `recover_to_seq` fail with `SeqTruncated` where
+    /// they would need them.
+    pub fn bulk_load(&self, items: impl IntoIterator<Item = (Key, Item)>) -> Result<u64> {
+        let mut inner = self.inner.write();
+        if !inner.schema.local_indexes.is_empty()
+            || !inner.schema.global_indexes.is_empty()
+            || inner.schema.stream_config.enabled
+        {
+            return Err(Error::InvalidArgument(
+                "Bulk load is not supported on tables with indexes or a stream".to_string(),
+            ));
+        }
+        if inner.wal.next_lsn() != 1 {
+            return Err(Error::InvalidArgument(
+                "Bulk load requires a database without regular writes".to_string(),
+            ));
+        }
+
+        let batch_records = inner.config.max_memtable_records.max(1);
+        let mut pending: Vec<BTreeMap<Vec<u8>, Record>> = (0..NUM_STRIPES).map(|_| BTreeMap::new()).collect();
+        let mut touched = vec![false; NUM_STRIPES];
+        let mut loaded = 0u64;
+
+        for (key, mut item) in items {
+            inner.stamp(&mut item);
+            inner.check_item_size(&item)?;
+            inner.schema.validate_item(&item)?;
+
+            let seq = inner.next_seq;
+            inner.next_seq += 1;
+            let stripe_id = key.stripe() as usize;
+            let key_enc = key.encode().to_vec();
+            pending[stripe_id].insert(key_enc, Record::put(key, item, seq));
+            touched[stripe_id] = true;
+            loaded += 1;
 
-            let compaction_mgr = CompactionManager::new(stripe_id, inner.dir.clone());
-            let ssts_to_compact = &inner.stripes[stripe_id].ssts;
-            let sst_count = ssts_to_compact.len();
+            if pending[stripe_id].len() >= batch_records {
+                let records = std::mem::take(&mut pending[stripe_id]);
+                self.write_bulk_sst(&mut inner, stripe_id, records)?;
+            }
+        }
 
-            // Allocate new SST ID for compacted file
-            let compacted_sst_id = inner.next_sst_id;
-            inner.next_sst_id += 1;
+        for stripe_id in 0..NUM_STRIPES {
+            let records = std::mem::take(&mut pending[stripe_id]);
+            if !records.is_empty() {
+                self.write_bulk_sst(&mut inner, stripe_id, records)?;
+            }
+            if touched[stripe_id] {
+                self.compact_if_needed(&mut inner, stripe_id)?;
+            }
+        }
 
-            // Perform compaction with compression settings
-            let (new_sst, old_paths) = compaction_mgr.compact(
-                ssts_to_compact,
-                compacted_sst_id,
-                inner.config.compression_enabled,
-                inner.config.compression_level,
-            )?;
+        // History up to here is only in the SSTs
+        let through = inner.next_seq - 1;
+        let marker = inner.dir.join(BULK_LOAD_FILE);
+        fs::write(&marker, through.to_string())?;
+        fs::File::open(&marker)?.sync_all()?;
+        inner.bulk_loaded_through = through;
+        inner.wal_tail.skip_through(through);
 
-            // Record statistics
-            inner.compaction_stats.record_ssts_merged(sst_count as u64);
-            inner.compaction_stats.record_ssts_created(1);
+        // Each SST was synced as it was written; make their names durable
+        fs::File::open(&inner.dir)?.sync_all()?;
+        info!(items = loaded, "Bulk loaded items");
+        Ok(loaded)
+    }
 
-            // Replace all SSTs with the compacted one
-            inner.stripes[stripe_id].ssts.clear();
-            inner.stripes[stripe_id].ssts.push(new_sst);
+    /// Write one stripe's bulk-loaded records as its newest SST
+    fn write_bulk_sst(&self, inner: &mut LsmInner, stripe_id: usize, records: BTreeMap<Vec<u8>, Record>) -> Result<()> {
+        let sst_id = inner.next_sst_id;
+        inner.next_sst_id += 1;
+        let sst_path = inner.dir.join(format!("{:03}-{}.sst", stripe_id, sst_id));
 
-            // Delete old SST files
-            compaction_mgr.cleanup_old_ssts(old_paths)?;
+        let mut writer = SstWriter::with_compression(
+            inner.config.compression_enabled,
+            inner.config.compression_level,
+        )
+        .with_value_compression(inner.config.value_compression_threshold);
+        for record in records.into_values() {
+            writer.add(record);
         }
+        writer.finish(&sst_path)?;
+
+        inner.stripes[stripe_id].ssts.insert(0, SstReader::open(&sst_path)?);
+        Ok(())
+    }
+
+    /// Merge all of a stripe's SSTs into one, dropping tombstones
+    fn compact_stripe(&self, inner: &mut LsmInner, stripe_id: usize) -> Result<()> {
+        let count = inner.stripes[stripe_id].ssts.len();
+        self.compact_newest(inner, stripe_id, count)
+    }
+
+    /// Merge a stripe's `count` newest SSTs into one
+    ///
+    /// Tombstones are dropped only when every SST of the stripe is merged;
+    /// otherwise they must go on shadowing older versions.
+    fn compact_newest(&self, inner: &mut LsmInner, stripe_id: usize, count: usize) -> Result<()> {
+        // Start compaction statistics tracking
+        let _guard = inner.compaction_stats.start_compaction();
+
+        let whole_stripe = count == inner.stripes[stripe_id].ssts.len();
+        let compaction_mgr = CompactionManager::new(stripe_id, inner.dir.clone())
+            .with_value_compression(inner.config.value_compression_threshold)
+            .with_tombstones_kept(!whole_stripe);
+        let ssts_to_compact = &inner.stripes[stripe_id].ssts[..count];
+        let sst_count = ssts_to_compact.len();
+        let bytes_read: u64 = ssts_to_compact.iter().map(sst_file_size).sum();
+        inner.emit(EngineEvent::CompactionStarted {
+            stripe: stripe_id,
+            ssts: sst_count,
+            bytes: bytes_read,
+        });
+
+        // Allocate new SST ID for compacted file
+        let compacted_sst_id = inner.next_sst_id;
+        inner.next_sst_id += 1;
 
+        // Perform compaction with compression settings
+        let (new_sst, old_paths) = compaction_mgr.compact(
+            ssts_to_compact,
+            compacted_sst_id,
+            inner.config.compression_enabled,
+            inner.config.compression_level,
+        )?;
+
+        // Record statistics
+        inner.compaction_stats.record_ssts_merged(sst_count as u64);
+        inner.compaction_stats.record_ssts_created(1);
+        inner.compaction_stats.record_bytes_read(bytes_read);
+        let bytes_written = sst_file_size(&new_sst);
+        inner.compaction_stats.record_bytes_written(bytes_written);
+
+        // Replace the merged SSTs with the compacted one, which is as new
+        // as the newest of them
+        let ssts = &mut inner.stripes[stripe_id].ssts;
+        ssts.drain(..count);
+        ssts.insert(0, new_sst);
+
+        // Delete old SST files
+        compaction_mgr.cleanup_old_ssts(old_paths)?;
+        info!(stripe = stripe_id, ssts_merged = sst_count, sst_id = compacted_sst_id, "Compacted stripe");
+        inner.emit(EngineEvent::CompactionCompleted {
+            stripe: stripe_id,
+            sst_id: compacted_sst_id,
+            ssts: sst_count,
+            bytes_read,
+            bytes_written,
+        });
         Ok(())
     }
 
@@ -1152,6 +2189,50 @@ impl LsmEngine {
         Some(&self.path)
     }
 
+    /// Read every SST file and the WAL back from disk and check them
+    ///
+    /// Damage is reported rather than returned as an error, so a periodic
+    /// check can alert on it; the database keeps serving from memory what
+    /// it loaded at open. A damaged WAL is checked only up to the first bad
+    /// record, as record boundaries past it can't be trusted. Compaction
+    /// waits while the check runs, so no file disappears under it.
+    pub fn verify(&self) -> Result<IntegrityReport> {
+        let inner = self.inner.read();
+        let mut report = IntegrityReport::default();
+
+        for sst in inner.stripes.iter().flat_map(|stripe| stripe.ssts.iter()) {
+            report.sst_files_checked += 1;
+            if let Err(e) = SstReader::open(sst.path()) {
+                report.problems.push(IntegrityProblem {
+                    path: sst.path().to_path_buf(),
+                    detail: e.to_string(),
+                });
+            }
+        }
+
+        match inner.wal.read_all() {
+            Ok(records) => report.wal_records_checked = records.len(),
+            Err(e) => report.problems.push(IntegrityProblem {
+                path: inner.dir.join("wal.log"),
+                detail: e.to_string(),
+            }),
+        }
+
+        Ok(report)
+    }
+
+    /// Total size in bytes of the files in the database directory
+    pub fn disk_size_bytes(&self) -> Result<u64> {
+        let mut total = 0;
+        for entry in fs::read_dir(&self.path)? {
+            let metadata = entry?.metadata()?;
+            if metadata.is_file() {
+                total += metadata.len();
+            }
+        }
+        Ok(total)
+    }
+
     /// Force flush all stripes (for testing/shutdown)
     pub fn flush(&self) -> Result<()> {
         let mut inner = self.inner.write();
@@ -1186,11 +2267,47 @@ impl LsmEngine {
     ///     .with_check_interval(30);
     /// db.set_compaction_config(config);
     /// ```
+    ///
+    /// Applies until the database is closed; to keep a policy across opens,
+    /// pass it in `DatabaseConfig::compaction`.
     pub fn set_compaction_config(&self, config: CompactionConfig) {
         let mut inner = self.inner.write();
         inner.compaction_config = config;
     }
 
+    /// Replace the attribute schemas used to validate writes
+    ///
+    /// Applies to subsequent writes only; existing items are not re-checked.
+    pub fn set_attribute_schemas(&self, schemas: Vec<crate::validation::AttributeSchema>) -> Result<()> {
+        crate::validation::check_schemas(&schemas)?;
+        self.inner.write().schema.attribute_schemas = schemas;
+        Ok(())
+    }
+
+    /// Check an item against the attribute schema, as a put would
+    pub fn validate_item(&self, item: &Item) -> Result<()> {
+        self.inner.read().schema.validate_item(item)
+    }
+
+    /// Configuration the engine is running with, including the compaction
+    /// policy in effect
+    pub fn config(&self) -> DatabaseConfig {
+        let inner = self.inner.read();
+        let mut config = inner.config.clone();
+        config.compaction = Some(inner.compaction_config.clone());
+        config
+    }
+
+    /// On-disk format version of the database's write-ahead log
+    pub fn format_version(&self) -> u32 {
+        self.inner.read().wal.format_version()
+    }
+
+    /// Maximum accounted item size in bytes
+    pub fn max_item_size_bytes(&self) -> usize {
+        self.inner.read().config.max_item_size_bytes
+    }
+
     /// Get current compaction configuration (Phase 1.7+)
     pub fn compaction_config(&self) -> CompactionConfig {
         let inner = self.inner.read();
@@ -1241,31 +2358,53 @@ impl LsmEngine {
 
         // Check if compaction is needed
         if inner.stripes[stripe_id].ssts.len() >= inner.compaction_config.sst_threshold {
-            let _guard = inner.compaction_stats.start_compaction();
-            let compaction_mgr = CompactionManager::new(stripe_id, inner.dir.clone());
-
-            let sst_count = inner.stripes[stripe_id].ssts.len();
-            let compacted_sst_id = inner.next_sst_id;
-            inner.next_sst_id += 1;
+            self.compact_stripe(&mut inner, stripe_id)?;
+        }
 
-            let ssts_to_compact = &inner.stripes[stripe_id].ssts;
-            let (new_sst, old_paths) = compaction_mgr.compact(
-                ssts_to_compact,
-                compacted_sst_id,
-                inner.config.compression_enabled,
-                inner.config.compression_level,
-            )?;
+        Ok(())
+    }
 
-            inner.compaction_stats.record_ssts_merged(sst_count as u64);
-            inner.compaction_stats.record_ssts_created(1);
+    /// Delete every item in partition `pk` whose sort key starts with
+    /// `sk_prefix`, then compact the partition's stripe so the deleted keys
+    /// are physically removed instead of lingering as tombstones
+    ///
+    /// An empty prefix purges the whole partition. Returns the number of
+    /// items deleted.
+    pub fn purge_prefix(&self, pk: &Bytes, sk_prefix: &[u8]) -> Result<u64> {
+        let mut inner = self.inner.write();
+        self.settle(&mut inner)?;
+        let stripe_id = Key::new(pk.clone()).stripe() as usize;
 
-            inner.stripes[stripe_id].ssts.clear();
-            inner.stripes[stripe_id].ssts.push(new_sst);
+        let matches = |key: &Key| {
+            key.pk == *pk && key.sk.as_deref().unwrap_or_default().starts_with(sk_prefix)
+        };
+        let mut candidates: std::collections::BTreeSet<Key> = inner.stripes[stripe_id]
+            .memtable
+            .values()
+            .filter(|r| matches(&r.key))
+            .map(|r| r.key.clone())
+            .collect();
+        for sst in &inner.stripes[stripe_id].ssts {
+            candidates.extend(sst.scan_prefix(pk).filter(|r| matches(&r.key)).map(|r| r.key.clone()));
+        }
 
-            compaction_mgr.cleanup_old_ssts(old_paths)?;
+        let operations: Vec<(Key, TransactWriteOperation)> = candidates
+            .into_iter()
+            .filter(|key| inner.newest_record(key).map_or(false, |r| r.value.is_some()))
+            .map(|key| (key, TransactWriteOperation::Delete { condition: None }))
+            .collect();
+        if operations.is_empty() {
+            return Ok(0);
         }
+        let deleted = self
+            .transact_write_locked(&mut inner, &operations, &ExpressionContext::new())?
+            .into_result()?;
 
-        Ok(())
+        self.flush_stripe(&mut inner, stripe_id)?;
+        if !inner.stripes[stripe_id].ssts.is_empty() {
+            self.compact_stripe(&mut inner, stripe_id)?;
+        }
+        Ok(deleted as u64)
     }
 }
 
@@ -2053,4 +3192,220 @@ mod tests {
             assert!(result.is_some(), "Item should be in memtable");
         }
     }
+
+    /// Records the level and message of every event
+    struct CaptureEvents(Arc<parking_lot::Mutex<Vec<(tracing::Level, String)>>>);
+
+    impl tracing::Subscriber for CaptureEvents {
+        fn enabled(&self, _: &tracing::Metadata<'_>) -> bool {
+            true
+        }
+
+        fn new_span(&self, _: &tracing::span::Attributes<'_>) -> tracing::span::Id {
+            tracing::span::Id::from_u64(1)
+        }
+
+        fn record(&self, _: &tracing::span::Id, _: &tracing::span::Record<'_>) {}
+
+        fn record_follows_from(&self, _: &tracing::span::Id, _: &tracing::span::Id) {}
+
+        fn event(&self, event: &tracing::Event<'_>) {
+            struct Message(String);
+            impl tracing::field::Visit for Message {
+                fn record_debug(&mut self, field: &tracing::field::Field, value: &dyn std::fmt::Debug) {
+                    if field.name() == "message" {
+                        self.0 = format!("{:?}", value);
+                    }
+                }
+            }
+
+            let mut message = Message(String::new());
+            event.record(&mut message);
+            self.0.lock().push((*event.metadata().level(), message.0));
+        }
+
+        fn enter(&self, _: &tracing::span::Id) {}
+
+        fn exit(&self, _: &tracing::span::Id) {}
+    }
+
+    #[test]
+    fn test_compaction_is_logged() {
+        let events = Arc::new(parking_lot::Mutex::new(Vec::new()));
+        let dir = TempDir::new().unwrap();
+
+        tracing::subscriber::with_default(CaptureEvents(Arc::clone(&events)), || {
+            let db = LsmEngine::create(dir.path()).unwrap();
+            db.set_compaction_config(CompactionConfig::new().with_sst_threshold(2));
+
+            // Same partition key, so every flush lands in one stripe
+            for i in 0..2 {
+                let key = Key::with_sk(Bytes::from("logged"), Bytes::from(format!("sk{}", i)));
+                db.put(key, HashMap::new()).unwrap();
+                db.flush().unwrap();
+            }
+        });
+
+        let events = events.lock();
+        assert!(events
+            .iter()
+            .any(|(level, message)| *level == tracing::Level::INFO && message == "Compacted stripe"));
+        assert!(events.iter().any(|(_, message)| message == "Flushed memtable to SST"));
+    }
+
+    #[test]
+    fn test_group_commit_applies_writes_only_once_durable() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_group_commit_window(std::time::Duration::from_millis(300));
+        let db = LsmEngine::create_with_config(dir.path(), config, TableSchema::new()).unwrap();
+        let key = Key::new(b"user#1".to_vec());
+        let mut item = HashMap::new();
+        item.insert("n".to_string(), Value::number(1));
+
+        // While the write waits for its sync, readers do not see it
+        let writer = {
+            let db = LsmEngine { inner: Arc::clone(&db.inner), path: db.path.clone() };
+            let (key, item) = (key.clone(), item.clone());
+            std::thread::spawn(move || db.put(key, item))
+        };
+        std::thread::sleep(std::time::Duration::from_millis(50));
+        assert!(db.get(&key).unwrap().is_none());
+        writer.join().unwrap().unwrap();
+        assert_eq!(db.get(&key).unwrap(), Some(item));
+    }
+
+    #[test]
+    fn test_group_commit_sync_failure_is_never_applied() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_group_commit_window(std::time::Duration::from_millis(5));
+        let db = LsmEngine::create_with_config(dir.path(), config, TableSchema::new()).unwrap();
+        let key = Key::new(b"user#1".to_vec());
+        let mut subscription = db.subscribe(b"user#", 4);
+        let mut tail = db.tail_wal(1);
+
+        db.inner.read().wal.fail_next_sync();
+        assert!(db.put(key.clone(), HashMap::new()).is_err());
+
+        // Neither readers, tails nor subscribers saw the failed write
+        assert!(db.get(&key).unwrap().is_none());
+        assert!(tail.try_next().is_none());
+        assert!(subscription.try_next().is_none());
+
+        // What reached the disk is unknown, so later writes fail too
+        assert!(db.put(key.clone(), HashMap::new()).is_err());
+        assert!(db.delete(key.clone()).is_err());
+        assert!(db.get(&key).unwrap().is_none());
+    }
+
+    #[test]
+    fn test_transact_get_checked_treats_expired_items_as_missing() {
+        let dir = TempDir::new().unwrap();
+        let db = LsmEngine::create_with_schema(dir.path(), TableSchema::new().with_ttl("expiresAt")).unwrap();
+        let key = Key::new(b"session#1".to_vec());
+        let mut item = HashMap::new();
+        item.insert("expiresAt".to_string(), Value::number(1));
+        db.put(key.clone(), item).unwrap();
+
+        let missing = crate::expression::ExpressionParser::parse("attribute_not_exists(expiresAt)").unwrap();
+        let checks = vec![(key.clone(), missing)];
+        let items = db.transact_get_checked(&[key.clone()], &checks, &ExpressionContext::new()).unwrap();
+        assert_eq!(items, vec![None]);
+    }
+
+    #[test]
+    fn test_transact_get_checked_with_concurrent_writer() {
+        use std::sync::atomic::{AtomicBool, Ordering};
+
+        let dir = TempDir::new().unwrap();
+        let db = LsmEngine::create(dir.path()).unwrap();
+        let key = Key::new(b"counter".to_vec());
+        let exists = crate::expression::ExpressionParser::parse("attribute_exists(n)").unwrap();
+        db.put(key.clone(), HashMap::from([("n".to_string(), Value::number(0))])).unwrap();
+        let done = AtomicBool::new(false);
+
+        std::thread::scope(|s| {
+            s.spawn(|| {
+                let mut n = 1;
+                while !done.load(Ordering::SeqCst) {
+                    db.put(key.clone(), HashMap::from([("n".to_string(), Value::number(n))])).unwrap();
+                    n += 1;
+                }
+            });
+            let checks = vec![(key.clone(), exists.clone())];
+            for _ in 0..2000 {
+                let items = db.transact_get_checked(&[key.clone()], &checks, &ExpressionContext::new()).unwrap();
+                assert!(items[0].is_some());
+            }
+            done.store(true, Ordering::SeqCst);
+        });
+    }
+
+    #[test]
+    fn test_transact_write_conditions_see_live_ttl_values() {
+        use crate::attribute_ttl::expiring;
+        use crate::expression::{ExpressionParser, UpdateExpressionParser, UpdateValue};
+        use std::time::{Duration, SystemTime};
+
+        let dir = TempDir::new().unwrap();
+        let db = LsmEngine::create_with_schema(dir.path(), TableSchema::new().with_ttl("expiresAt")).unwrap();
+        let hour = Duration::from_secs(3600);
+
+        // An expiring attribute is compared unwrapped; an expired one is absent
+        let lock = Key::new(b"lock#1".to_vec());
+        let mut item = HashMap::new();
+        item.insert("owner".to_string(), expiring(Value::string("a"), SystemTime::now() + hour));
+        item.insert("lease".to_string(), expiring(Value::number(1), SystemTime::now() - hour));
+        db.put(lock.clone(), item).unwrap();
+        let context = ExpressionContext::new().with_value(":a", Value::string("a"));
+        let check = ExpressionParser::parse("owner = :a AND attribute_not_exists(lease)").unwrap();
+        let outcome = db
+            .try_transact_write(&[(lock.clone(), TransactWriteOperation::ConditionCheck { condition: check })], &context)
+            .unwrap();
+        assert!(matches!(outcome, TransactWriteOutcome::Committed(_)));
+
+        // An update drops the expired attribute instead of rewriting it
+        let actions = UpdateExpressionParser::parse("SET owner = :a").unwrap();
+        db.transact_write(&[(lock.clone(), TransactWriteOperation::Update { actions, condition: None })], &context)
+            .unwrap();
+        let stored = db.get(&lock).unwrap().unwrap();
+        assert_eq!(stored.get("owner"), Some(&Value::string("a")));
+        assert!(!stored.contains_key("lease"));
+
+        // An item past its TTL does not exist for conditions
+        let session = Key::new(b"session#1".to_vec());
+        let mut item = HashMap::new();
+        item.insert("expiresAt".to_string(), Value::number(1));
+        db.put(session.clone(), item).unwrap();
+        let absent = ExpressionParser::parse("attribute_not_exists(expiresAt)").unwrap();
+        let put = TransactWriteOperation::Put { item: HashMap::new(), condition: Some(absent) };
+        db.transact_write(&[(session.clone(), put)], &ExpressionContext::new()).unwrap();
+
+        // The item an update produces is size-checked like a put
+        let big = vec![UpdateAction::Set(
+            "blob".to_string(),
+            UpdateValue::Value(Value::string("x".repeat(crate::config::DEFAULT_MAX_ITEM_SIZE_BYTES + 1))),
+        )];
+        let update = TransactWriteOperation::Update { actions: big, condition: None };
+        let result = db.transact_write(&[(session.clone(), update)], &ExpressionContext::new());
+        assert!(matches!(result, Err(Error::ItemTooLarge { .. })));
+    }
+
+    #[test]
+    fn test_applied_seq_excludes_staged_writes() {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new().with_group_commit_window(std::time::Duration::from_millis(300));
+        let db = LsmEngine::create_with_config(dir.path(), config, TableSchema::new()).unwrap();
+        db.put(Key::new(b"user#1".to_vec()), HashMap::new()).unwrap();
+        assert_eq!((db.last_seq(), db.applied_seq()), (1, 1));
+
+        // The second write has its sequence number but is not applied yet
+        let writer = {
+            let db = LsmEngine { inner: Arc::clone(&db.inner), path: db.path.clone() };
+            std::thread::spawn(move || db.put(Key::new(b"user#2".to_vec()), HashMap::new()))
+        };
+        std::thread::sleep(std::time::Duration::from_millis(50));
+        assert_eq!((db.last_seq(), db.applied_seq()), (2, 1));
+        writer.join().unwrap().unwrap();
+        assert_eq!(db.applied_seq(), 2);
+    }
 }
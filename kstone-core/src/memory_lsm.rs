@@ -2,6 +2,16 @@
 ///
 /// Provides the same API as the disk-based LSM engine but stores all data in memory.
 /// All data is lost when the MemoryLsmEngine is dropped.
+///
+/// Secondary indexes are not maintained: schemas that declare them and
+/// index queries are rejected rather than answered from the base table.
+///
+/// An engine created with a `MemoryLimit` bounds the bytes its items take
+/// (encoded key plus item size) and either rejects writes past the limit or
+/// evicts the least recently used items to make room. To keep that count
+/// honest, a limited engine stores only the newest version of each item:
+/// deletes remove items outright instead of writing tombstones, and no
+/// write log is kept.
 
 use crate::{
     Result, Key, Item, Record, Error,
@@ -10,10 +20,13 @@ use crate::{
     index::TableSchema,
     iterator::{QueryParams, QueryResult, ScanParams, ScanResult},
     expression::{UpdateAction, UpdateExecutor, ExpressionContext, ExpressionEvaluator, Expr},
-    lsm::TransactWriteOperation,
+    lsm::{CancellationReason, TransactWriteOperation, TransactWriteOutcome},
+    config::DEFAULT_MAX_ITEM_SIZE_BYTES,
+    attribute_ttl::{remove_expired, resolved},
 };
+use bytes::Bytes;
 use std::collections::{BTreeMap, HashMap, HashSet};
-use std::sync::{Arc, RwLock};
+use std::sync::{Arc, Mutex, RwLock};
 
 const NUM_STRIPES: usize = 256;
 const MEMTABLE_THRESHOLD: usize = 1000;
@@ -23,6 +36,144 @@ fn stripe_id(pk: &[u8]) -> usize {
     crc32fast::hash(pk) as usize % NUM_STRIPES
 }
 
+/// Reject items larger than the default maximum item size
+fn check_item_size(item: &Item) -> Result<()> {
+    let size = crate::types::item_size(item);
+    if size > DEFAULT_MAX_ITEM_SIZE_BYTES {
+        return Err(Error::ItemTooLarge {
+            size,
+            limit: DEFAULT_MAX_ITEM_SIZE_BYTES,
+        });
+    }
+    Ok(())
+}
+
+/// Bytes an item counts against a memory limit
+fn stored_size(key: &Key, item: &Item) -> u64 {
+    (key.encode().len() + crate::types::item_size(item)) as u64
+}
+
+/// What a memory-limited engine does with a write that would exceed the limit
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum EvictionPolicy {
+    /// Fail the write with `Error::MemoryLimit`
+    Reject,
+    /// Evict the least recently read or written items until the write fits
+    EvictLru,
+}
+
+/// Bound on the memory an in-memory engine's items may take
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct MemoryLimit {
+    /// Maximum bytes of items (encoded key plus item size)
+    pub max_bytes: u64,
+    /// What to do with writes past the limit
+    pub policy: EvictionPolicy,
+}
+
+/// Order in which items were last used
+#[derive(Default)]
+struct Recency {
+    clock: u64,
+    by_key: HashMap<Key, u64>,
+    by_tick: BTreeMap<u64, Key>,
+}
+
+impl Recency {
+    fn touch(&mut self, key: &Key) {
+        self.clock += 1;
+        if let Some(tick) = self.by_key.insert(key.clone(), self.clock) {
+            self.by_tick.remove(&tick);
+        }
+        self.by_tick.insert(self.clock, key.clone());
+    }
+
+    fn forget(&mut self, key: &Key) {
+        if let Some(tick) = self.by_key.remove(key) {
+            self.by_tick.remove(&tick);
+        }
+    }
+
+    /// Least recently used key other than `except`
+    fn oldest_except(&self, except: &Key) -> Option<Key> {
+        self.by_tick.values().find(|key| *key != except).cloned()
+    }
+}
+
+/// Memory accounting of a limited engine
+struct MemoryBudget {
+    limit: MemoryLimit,
+    /// Bytes of stored items
+    used: u64,
+    /// Only tracked under `EvictLru`. Reads hold just the read lock, hence
+    /// the mutex
+    recency: Mutex<Recency>,
+}
+
+impl MemoryBudget {
+    fn new(limit: MemoryLimit) -> Self {
+        Self {
+            limit,
+            used: 0,
+            recency: Mutex::new(Recency::default()),
+        }
+    }
+
+    fn touch(&self, key: &Key) {
+        if self.limit.policy == EvictionPolicy::EvictLru {
+            self.recency.lock().unwrap().touch(key);
+        }
+    }
+
+    fn forget(&self, key: &Key) {
+        if self.limit.policy == EvictionPolicy::EvictLru {
+            self.recency.lock().unwrap().forget(key);
+        }
+    }
+
+    /// Bytes `writes` would bring the engine to
+    fn needed(&self, stripes: &[MemoryStripe], writes: &[(&Key, Option<&Item>)]) -> u64 {
+        writes.iter().fold(self.used, |needed, (key, item)| {
+            let current = stripes[stripe_id(&key.pk)].stored_bytes(key);
+            let new = item.map_or(0, |item| stored_size(key, item));
+            needed.saturating_sub(current) + new
+        })
+    }
+
+    /// Fail if `writes` don't fit and can't be made to fit
+    ///
+    /// Under `EvictLru` anything fits unless a single item is larger than
+    /// the whole limit.
+    fn check_room(&self, stripes: &[MemoryStripe], writes: &[(&Key, Option<&Item>)]) -> Result<()> {
+        let limit = self.limit.max_bytes;
+        let needed = match self.limit.policy {
+            EvictionPolicy::Reject => self.needed(stripes, writes),
+            EvictionPolicy::EvictLru => writes
+                .iter()
+                .filter_map(|(key, item)| item.map(|item| stored_size(key, item)))
+                .max()
+                .unwrap_or(0),
+        };
+        if needed > limit {
+            return Err(Error::MemoryLimit { needed, limit });
+        }
+        Ok(())
+    }
+
+    /// Make room for a write of `key`, evicting other items under `EvictLru`
+    fn make_room(&mut self, stripes: &mut [MemoryStripe], key: &Key, item: Option<&Item>) -> Result<()> {
+        self.check_room(stripes, &[(key, item)])?;
+        while self.needed(stripes, &[(key, item)]) > self.limit.max_bytes {
+            let Some(victim) = self.recency.get_mut().unwrap().oldest_except(key) else {
+                break;
+            };
+            self.used -= stripes[stripe_id(&victim.pk)].purge(&victim);
+            self.forget(&victim);
+        }
+        Ok(())
+    }
+}
+
 /// In-memory stripe
 struct MemoryStripe {
     /// In-memory memtable
@@ -38,6 +189,30 @@ impl MemoryStripe {
             ssts: Vec::new(),
         }
     }
+
+    /// Bytes held by the stored version of `key`, in an engine that keeps
+    /// only one version per key
+    fn stored_bytes(&self, key: &Key) -> u64 {
+        let key_bytes = key.encode();
+        self.memtable
+            .get(key_bytes.as_ref())
+            .or_else(|| self.ssts.iter().find_map(|sst| sst.get(key)))
+            .and_then(|record| record.value.as_ref())
+            .map_or(0, |item| stored_size(key, item))
+    }
+
+    /// Remove every version of `key`, returning the bytes freed
+    fn purge(&mut self, key: &Key) -> u64 {
+        let mut removed: Vec<Record> = self.memtable.remove(key.encode().as_ref()).into_iter().collect();
+        for sst in &mut self.ssts {
+            removed.extend(sst.remove(key));
+        }
+        removed
+            .iter()
+            .filter_map(|record| record.value.as_ref())
+            .map(|item| stored_size(key, item))
+            .sum()
+    }
 }
 
 /// Inner mutable state
@@ -52,6 +227,8 @@ struct MemoryLsmInner {
     next_sst_id: u64,
     /// Table schema (for indexes, TTL, streams)
     schema: TableSchema,
+    /// Memory accounting, if the engine has a memory limit
+    budget: Option<MemoryBudget>,
 }
 
 /// In-memory LSM Engine
@@ -67,7 +244,27 @@ impl MemoryLsmEngine {
     }
 
     /// Create a new in-memory database with a table schema
+    ///
+    /// Fails if the schema declares secondary indexes.
     pub fn create_with_schema(schema: TableSchema) -> Result<Self> {
+        if !schema.local_indexes.is_empty() || !schema.global_indexes.is_empty() {
+            return Err(Error::InvalidArgument(
+                "In-memory databases do not support secondary indexes".to_string(),
+            ));
+        }
+        Self::create_with_budget(schema, None)
+    }
+
+    /// Create a new in-memory database whose items may take at most
+    /// `limit.max_bytes`
+    pub fn create_with_limit(limit: MemoryLimit) -> Result<Self> {
+        if limit.max_bytes == 0 {
+            return Err(Error::InvalidArgument("Memory limit must be positive".to_string()));
+        }
+        Self::create_with_budget(TableSchema::new(), Some(MemoryBudget::new(limit)))
+    }
+
+    fn create_with_budget(schema: TableSchema, budget: Option<MemoryBudget>) -> Result<Self> {
         let wal = MemoryWal::create()?;
         let stripes = (0..NUM_STRIPES).map(|_| MemoryStripe::new()).collect();
 
@@ -78,6 +275,7 @@ impl MemoryLsmEngine {
                 next_seq: 1,
                 next_sst_id: 1,
                 schema,
+                budget,
             })),
         })
     }
@@ -85,71 +283,206 @@ impl MemoryLsmEngine {
     /// Put an item
     pub fn put(&self, key: Key, item: Item) -> Result<()> {
         let mut inner = self.inner.write().unwrap();
+        Self::put_locked(&mut inner, key, item)
+    }
+
+    /// Put an item while already holding the write lock
+    fn put_locked(inner: &mut MemoryLsmInner, key: Key, item: Item) -> Result<()> {
+        check_item_size(&item)?;
+        inner.schema.validate_item(&item)?;
 
         let seq = inner.next_seq;
         inner.next_seq += 1;
 
-        let record = Record::put(key.clone(), item, seq);
+        Self::write_locked(inner, Record::put(key, item, seq))
+    }
 
-        // Append to WAL
-        inner.wal.append(record.clone())?;
+    /// Apply a put or delete record while holding the write lock
+    fn write_locked(inner: &mut MemoryLsmInner, record: Record) -> Result<()> {
+        let stripe_idx = stripe_id(&record.key.pk);
+        let MemoryLsmInner { wal, stripes, budget, .. } = &mut *inner;
+
+        match budget {
+            Some(budget) => {
+                budget.make_room(stripes, &record.key, record.value.as_ref())?;
+
+                // Keep only the newest version, which a delete leaves none of
+                budget.used -= stripes[stripe_idx].purge(&record.key);
+                match &record.value {
+                    Some(item) => {
+                        budget.used += stored_size(&record.key, item);
+                        budget.touch(&record.key);
+                    }
+                    None => {
+                        budget.forget(&record.key);
+                        return Ok(());
+                    }
+                }
+            }
+            None => {
+                wal.append(record.clone())?;
+            }
+        }
 
         // Add to memtable
-        let stripe_idx = stripe_id(&key.pk);
-        inner.stripes[stripe_idx].memtable.insert(key.encode().to_vec(), record);
+        stripes[stripe_idx].memtable.insert(record.key.encode().to_vec(), record);
 
         // Check if memtable needs flushing
-        if inner.stripes[stripe_idx].memtable.len() >= MEMTABLE_THRESHOLD {
-            Self::flush_stripe(&mut inner, stripe_idx)?;
+        if stripes[stripe_idx].memtable.len() >= MEMTABLE_THRESHOLD {
+            Self::flush_stripe(inner, stripe_idx)?;
         }
 
         Ok(())
     }
 
+    /// Replace the attribute schemas used to validate writes
+    pub fn set_attribute_schemas(&self, schemas: Vec<crate::validation::AttributeSchema>) -> Result<()> {
+        crate::validation::check_schemas(&schemas)?;
+        self.inner.write().unwrap().schema.attribute_schemas = schemas;
+        Ok(())
+    }
+
+    /// Check an item against the attribute schema, as a put would
+    pub fn validate_item(&self, item: &Item) -> Result<()> {
+        self.inner.read().unwrap().schema.validate_item(item)
+    }
+
     /// Get an item
     pub fn get(&self, key: &Key) -> Result<Option<Item>> {
         let inner = self.inner.read().unwrap();
+        let item = Self::get_locked(&inner, key);
+        if let (Some(budget), Some(_)) = (&inner.budget, &item) {
+            budget.touch(key);
+        }
+        Ok(item.map(resolved))
+    }
+
+    /// Newest live version of a key while already holding a lock
+    fn get_locked(inner: &MemoryLsmInner, key: &Key) -> Option<Item> {
         let stripe_idx = stripe_id(&key.pk);
         let stripe = &inner.stripes[stripe_idx];
         let key_bytes = key.encode();
 
         // Check memtable first
         if let Some(record) = stripe.memtable.get(key_bytes.as_ref()) {
-            return Ok(record.value.clone());
+            return record.value.clone();
         }
 
         // Check SSTs (newest to oldest)
         for sst in stripe.ssts.iter().rev() {
             if let Some(record) = sst.get(key) {
-                return Ok(record.value.clone());
+                return record.value.clone();
             }
         }
 
-        Ok(None)
+        None
     }
 
-    /// Delete an item
-    pub fn delete(&self, key: Key) -> Result<()> {
-        let mut inner = self.inner.write().unwrap();
+    /// All live items under partition key `pk`, in sort key order
+    pub fn partition_items(&self, pk: &Bytes) -> Result<Vec<(Key, Item)>> {
+        let inner = self.inner.read().unwrap();
+        let stripe = &inner.stripes[stripe_id(pk)];
 
-        let seq = inner.next_seq;
-        inner.next_seq += 1;
+        // The newest version of each key wins; tombstones shadow older ones
+        let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+        for record in stripe.memtable.values().filter(|r| r.key.pk == *pk) {
+            newest.insert(&record.key, record);
+        }
+        // SSTs newest to oldest
+        for sst in stripe.ssts.iter().rev() {
+            for record in sst.scan_prefix(pk) {
+                newest.entry(&record.key).or_insert(record);
+            }
+        }
 
-        let record = Record::delete(key.clone(), seq);
+        Ok(newest
+            .into_iter()
+            .filter_map(|(key, record)| record.value.as_ref().map(|item| (key.clone(), item.clone())))
+            .collect())
+    }
 
-        // Append to WAL
-        inner.wal.append(record.clone())?;
+    /// Item count and size of every partition, in partition key order
+    pub fn partition_sizes(&self) -> Result<Vec<crate::PartitionStat>> {
+        let inner = self.inner.read().unwrap();
+        let mut stats: BTreeMap<Bytes, crate::PartitionStat> = BTreeMap::new();
 
-        // Add tombstone to memtable
-        let stripe_idx = stripe_id(&key.pk);
-        inner.stripes[stripe_idx].memtable.insert(key.encode().to_vec(), record);
+        for stripe in &inner.stripes {
+            // The newest version of each key wins; tombstones shadow older ones
+            let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+            for record in stripe.memtable.values() {
+                newest.insert(&record.key, record);
+            }
+            // SSTs newest to oldest
+            for sst in stripe.ssts.iter().rev() {
+                for record in sst.iter() {
+                    newest.entry(&record.key).or_insert(record);
+                }
+            }
 
-        // Check if memtable needs flushing
-        if inner.stripes[stripe_idx].memtable.len() >= MEMTABLE_THRESHOLD {
-            Self::flush_stripe(&mut inner, stripe_idx)?;
+            for (key, record) in newest {
+                let Some(item) = &record.value else { continue };
+                if crate::index::is_index_key(&key.pk) {
+                    continue;
+                }
+                let stat = stats.entry(key.pk.clone()).or_insert_with(|| crate::PartitionStat {
+                    pk: key.pk.clone(),
+                    items: 0,
+                    bytes: 0,
+                });
+                stat.items += 1;
+                stat.bytes += crate::item_size(item) as u64;
+            }
         }
 
-        Ok(())
+        Ok(stats.into_values().collect())
+    }
+
+    /// Count the items under partition key `pk` without copying them
+    pub fn count_partition(&self, pk: &Bytes) -> Result<u64> {
+        let inner = self.inner.read().unwrap();
+        let stripe = &inner.stripes[stripe_id(pk)];
+
+        // The newest version of each key decides whether it counts
+        let mut live: BTreeMap<&Key, bool> = BTreeMap::new();
+        for record in stripe.memtable.values().filter(|r| r.key.pk == *pk) {
+            live.insert(&record.key, record.value.is_some());
+        }
+        // SSTs newest to oldest
+        for sst in stripe.ssts.iter().rev() {
+            for record in sst.scan_prefix(pk) {
+                live.entry(&record.key).or_insert(record.value.is_some());
+            }
+        }
+
+        Ok(live.values().filter(|l| **l).count() as u64)
+    }
+
+    /// Check whether an item exists without copying it
+    pub fn exists(&self, key: &Key) -> Result<bool> {
+        let inner = self.inner.read().unwrap();
+        let stripe = &inner.stripes[stripe_id(&key.pk)];
+
+        if let Some(record) = stripe.memtable.get(key.encode().as_ref()) {
+            return Ok(record.value.is_some());
+        }
+
+        // SSTs newest to oldest
+        Ok(stripe
+            .ssts
+            .iter()
+            .rev()
+            .find_map(|sst| sst.get(key))
+            .map_or(false, |record| record.value.is_some()))
+    }
+
+    /// Delete an item
+    pub fn delete(&self, key: Key) -> Result<()> {
+        let mut inner = self.inner.write().unwrap();
+
+        let seq = inner.next_seq;
+        inner.next_seq += 1;
+
+        Self::write_locked(&mut inner, Record::delete(key, seq))
     }
 
     /// Flush memtable to SST
@@ -207,6 +540,10 @@ impl MemoryLsmEngine {
         inner.wal.clear();
         inner.next_seq = 1;
         inner.next_sst_id = 1;
+        if let Some(budget) = &mut inner.budget {
+            budget.used = 0;
+            *budget.recency.get_mut().unwrap() = Recency::default();
+        }
 
         Ok(())
     }
@@ -233,6 +570,12 @@ impl MemoryLsmEngine {
 
     /// Query items within a partition
     pub fn query(&self, params: QueryParams) -> Result<QueryResult> {
+        if let Some(index_name) = &params.index_name {
+            return Err(Error::InvalidArgument(format!(
+                "Index {} not found: in-memory databases do not support secondary indexes",
+                index_name
+            )));
+        }
         let inner = self.inner.read().unwrap();
 
         // Route to correct stripe
@@ -310,7 +653,7 @@ impl MemoryLsmEngine {
             last_key = Some(record.key.clone());
 
             if let Some(item) = record.value {
-                items.push(item);
+                items.push(resolved(item));
 
                 // Check limit
                 if let Some(limit) = params.limit {
@@ -324,12 +667,101 @@ impl MemoryLsmEngine {
         Ok(QueryResult::new(items, last_key, scanned_count))
     }
 
+    /// Scan with keys - returns (Key, Item) pairs in key order
+    ///
+    /// Returns the newest live version of each item, skipping index entries
+    /// and sync metadata.
+    pub fn scan_with_keys(&self, limit: usize) -> Result<Vec<(Key, Item)>> {
+        let inner = self.inner.read().unwrap();
+        let mut all_records: BTreeMap<Key, Record> = BTreeMap::new();
+        let wanted = |record: &Record| {
+            !crate::index::is_index_key(&record.key.pk) && !record.key.pk.starts_with(b"_sync#")
+        };
+
+        for stripe in &inner.stripes {
+            for record in stripe.memtable.values() {
+                if wanted(record) {
+                    all_records.insert(record.key.clone(), record.clone());
+                }
+            }
+            for sst in &stripe.ssts {
+                for record in sst.iter() {
+                    if wanted(record) {
+                        all_records.entry(record.key.clone()).or_insert_with(|| record.clone());
+                    }
+                }
+            }
+        }
+
+        Ok(all_records
+            .into_values()
+            .filter_map(|record| record.value.map(|item| (record.key, item)))
+            .take(limit)
+            .collect())
+    }
+
+    /// Keys of the items `scan` would return, without copying their values
+    pub fn scan_keys(&self, params: &ScanParams) -> Result<Vec<Key>> {
+        let inner = self.inner.read().unwrap();
+        let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+
+        for stripe_id in (0..NUM_STRIPES).filter(|&id| params.should_scan_stripe(id)) {
+            let stripe = &inner.stripes[stripe_id];
+            let wanted = |record: &Record| {
+                !crate::index::is_index_key(&record.key.pk) && params.in_range(&record.key)
+            };
+
+            for record in stripe.memtable.values().filter(|r| wanted(r)) {
+                newest.insert(&record.key, record);
+            }
+            for sst in &stripe.ssts {
+                for record in sst.iter().filter(|r| wanted(r)) {
+                    newest.entry(&record.key).or_insert(record);
+                }
+            }
+        }
+
+        let live = newest
+            .into_iter()
+            .filter(|(key, record)| record.value.is_some() && !params.should_skip(key))
+            .map(|(key, _)| key.clone());
+        Ok(live.take(params.limit.unwrap_or(usize::MAX)).collect())
+    }
+
+    /// Distinct partition keys of all live items, in order
+    pub fn partition_keys(&self) -> Result<Vec<Bytes>> {
+        let inner = self.inner.read().unwrap();
+        let mut partitions = std::collections::BTreeSet::new();
+
+        for stripe in &inner.stripes {
+            // The newest version of each key decides whether it counts
+            let mut newest: BTreeMap<&Key, &Record> = BTreeMap::new();
+            for record in stripe.memtable.values() {
+                newest.insert(&record.key, record);
+            }
+            // SSTs newest to oldest
+            for sst in stripe.ssts.iter().rev() {
+                for record in sst.iter() {
+                    newest.entry(&record.key).or_insert(record);
+                }
+            }
+
+            for (key, record) in newest {
+                if record.value.is_some() && !crate::index::is_index_key(&key.pk) {
+                    partitions.insert(key.pk.clone());
+                }
+            }
+        }
+
+        Ok(partitions.into_iter().collect())
+    }
+
     /// Scan all items across all stripes
     pub fn scan(&self, params: ScanParams) -> Result<ScanResult> {
         let inner = self.inner.read().unwrap();
 
-        // Collect all records from all stripes
-        let mut all_records: BTreeMap<Vec<u8>, Record> = BTreeMap::new();
+        // Collect the newest version of each key from all stripes, sorted by key
+        let mut all_records: BTreeMap<Key, Record> = BTreeMap::new();
 
         for stripe_id in 0..NUM_STRIPES {
             // Skip stripes not assigned to this segment
@@ -338,28 +770,24 @@ impl MemoryLsmEngine {
             }
 
             let stripe = &inner.stripes[stripe_id];
+            let wanted = |record: &Record| {
+                !crate::index::is_index_key(&record.key.pk) && params.in_range(&record.key)
+            };
 
             // Collect from memtable
-            for (key_enc, record) in &stripe.memtable {
-                // Skip tombstones
-                if record.value.is_none() {
-                    continue;
+            for record in stripe.memtable.values() {
+                if wanted(record) {
+                    all_records.insert(record.key.clone(), record.clone());
                 }
-
-                all_records.insert(key_enc.clone(), record.clone());
             }
 
-            // Collect from SSTs
+            // Collect from SSTs; tombstones are kept so they shadow older versions
             for sst in &stripe.ssts {
                 for record in sst.iter() {
-                    // Skip tombstones
-                    if record.value.is_none() {
-                        continue;
+                    if wanted(record) {
+                        // Only add if we don't already have this key (memtable is newer)
+                        all_records.entry(record.key.clone()).or_insert(record.clone());
                     }
-
-                    let key_enc = record.key.encode().to_vec();
-                    // Only add if we don't already have this key (memtable is newer)
-                    all_records.entry(key_enc).or_insert(record.clone());
                 }
             }
         }
@@ -370,8 +798,8 @@ impl MemoryLsmEngine {
         let mut last_key = None;
 
         for (_, record) in all_records {
-            // Skip based on pagination
-            if params.should_skip(&record.key) {
+            // Skip deleted items and pagination
+            if record.value.is_none() || params.should_skip(&record.key) {
                 continue;
             }
 
@@ -380,7 +808,7 @@ impl MemoryLsmEngine {
             last_key = Some(record.key.clone());
 
             if let Some(item) = record.value {
-                items.push(item);
+                items.push(resolved(item));
 
                 // Check limit
                 if let Some(limit) = params.limit {
@@ -396,17 +824,7 @@ impl MemoryLsmEngine {
 
     /// Update an item using update expression
     pub fn update(&self, key: &Key, actions: &[UpdateAction], context: &ExpressionContext) -> Result<Item> {
-        // Get current item (or create empty if doesn't exist)
-        let current_item = self.get(key)?.unwrap_or_else(|| HashMap::new());
-
-        // Execute update actions
-        let executor = UpdateExecutor::new(context);
-        let updated_item = executor.execute(&current_item, actions)?;
-
-        // Put the updated item
-        self.put(key.clone(), updated_item.clone())?;
-
-        Ok(updated_item)
+        self.update_locked(key, actions, None, context)
     }
 
     /// Update an item with a condition expression
@@ -417,25 +835,37 @@ impl MemoryLsmEngine {
         condition: &Expr,
         context: &ExpressionContext,
     ) -> Result<Item> {
-        // Get current item (or create empty if doesn't exist)
-        let current_item = self.get(key)?.unwrap_or_else(|| HashMap::new());
+        self.update_locked(key, actions, Some(condition), context)
+    }
+
+    /// Read, check, and rewrite an item under a single write lock
+    fn update_locked(
+        &self,
+        key: &Key,
+        actions: &[UpdateAction],
+        condition: Option<&Expr>,
+        context: &ExpressionContext,
+    ) -> Result<Item> {
+        let mut inner = self.inner.write().unwrap();
 
-        // Evaluate condition
-        let evaluator = ExpressionEvaluator::new(&current_item, context);
-        let condition_passed = evaluator.evaluate(condition)?;
+        // Current item (or empty if it doesn't exist), without expired
+        // attributes so the rewrite drops them
+        let mut current_item = Self::get_locked(&inner, key).unwrap_or_default();
+        remove_expired(&mut current_item);
 
-        if !condition_passed {
-            return Err(Error::ConditionalCheckFailed("Update condition failed".into()));
+        if let Some(condition) = condition {
+            let visible = resolved(current_item.clone());
+            let evaluator = ExpressionEvaluator::new(&visible, context);
+            if !evaluator.evaluate(condition)? {
+                return Err(Error::ConditionalCheckFailed("Update condition failed".into()));
+            }
         }
 
-        // Condition passed, execute update
         let executor = UpdateExecutor::new(context);
         let updated_item = executor.execute(&current_item, actions)?;
+        Self::put_locked(&mut inner, key.clone(), updated_item.clone())?;
 
-        // Put the updated item
-        self.put(key.clone(), updated_item.clone())?;
-
-        Ok(updated_item)
+        Ok(resolved(updated_item))
     }
 
     /// Put an item with a condition expression
@@ -506,16 +936,40 @@ impl MemoryLsmEngine {
 
     /// Transaction get - read multiple items atomically
     pub fn transact_get(&self, keys: &[Key]) -> Result<Vec<Option<Item>>> {
-        // Hold read lock for consistent snapshot
-        let _inner = self.inner.read().unwrap();
+        self.transact_get_checked(keys, &[], &ExpressionContext::new())
+    }
 
-        let mut items = Vec::new();
-        for key in keys {
-            let item = self.get(key)?;
-            items.push(item);
+    /// Transaction get that also asserts conditions on other items
+    ///
+    /// Reads and checks happen under one read lock, so the items returned
+    /// are from the same state in which every condition held. Fails with
+    /// `ConditionalCheckFailed` if any condition is false.
+    pub fn transact_get_checked(
+        &self,
+        keys: &[Key],
+        checks: &[(Key, Expr)],
+        context: &ExpressionContext,
+    ) -> Result<Vec<Option<Item>>> {
+        // Every read goes through this one guard; calling `get` here would
+        // take the lock again while a writer may be queued on it
+        let inner = self.inner.read().unwrap();
+        let live = |key: &Key| {
+            Self::get_locked(&inner, key)
+                .filter(|item| !inner.schema.is_expired(item))
+                .map(resolved)
+        };
+
+        for (index, (key, condition)) in checks.iter().enumerate() {
+            let current_item = live(key).unwrap_or_default();
+            if !ExpressionEvaluator::new(&current_item, context).evaluate(condition)? {
+                return Err(Error::ConditionalCheckFailed(format!(
+                    "Condition check {} failed for key {:?}",
+                    index, key
+                )));
+            }
         }
 
-        Ok(items)
+        Ok(keys.iter().map(live).collect())
     }
 
     /// Transaction write - write multiple items atomically with conditions
@@ -524,117 +978,90 @@ impl MemoryLsmEngine {
         operations: &[(Key, TransactWriteOperation)],
         context: &ExpressionContext,
     ) -> Result<usize> {
+        self.try_transact_write(operations, context)?.into_result()
+    }
+
+    /// Transaction write reporting per-operation cancellation reasons
+    pub fn try_transact_write(
+        &self,
+        operations: &[(Key, TransactWriteOperation)],
+        context: &ExpressionContext,
+    ) -> Result<TransactWriteOutcome> {
         // Acquire write lock for atomicity
         let mut inner = self.inner.write().unwrap();
 
         // Phase 1: Read all items and check all conditions
         let mut current_items: Vec<Option<Item>> = Vec::new();
+        let mut reasons: Vec<CancellationReason> = Vec::new();
         for (key, op) in operations {
-            let item = {
-                let stripe_id = stripe_id(&key.pk);
-                let stripe = &inner.stripes[stripe_id];
-                let key_enc = key.encode().to_vec();
-
-                // Check memtable
-                if let Some(record) = stripe.memtable.get(&key_enc) {
-                    record.value.clone()
-                } else {
-                    // Check SSTs
-                    let mut found = None;
-                    for sst in &stripe.ssts {
-                        if let Some(record) = sst.get(key) {
-                            found = record.value.clone();
-                            break;
-                        }
-                    }
-                    found
-                }
-            };
-
-            current_items.push(item.clone());
+            // As in `update_locked`: expired attributes are dropped and
+            // conditions see live values unwrapped
+            let mut item = Self::get_locked(&inner, key).unwrap_or_default();
+            remove_expired(&mut item);
+            current_items.push(Some(item.clone()));
+
+            if let TransactWriteOperation::Put { item: new_item, .. } = op {
+                check_item_size(new_item)?;
+                inner.schema.validate_item(new_item)?;
+            }
 
             // Check condition if present
+            let mut reason = CancellationReason::none();
             if let Some(condition_expr) = op.condition() {
-                let current_item = item.unwrap_or_else(|| HashMap::new());
-                let evaluator = ExpressionEvaluator::new(&current_item, context);
+                let visible = resolved(item);
+                let evaluator = ExpressionEvaluator::new(&visible, context);
                 let condition_passed = evaluator.evaluate(condition_expr)?;
 
                 if !condition_passed {
-                    return Err(Error::TransactionCanceled(format!(
-                        "Condition failed for key {:?}",
-                        key
-                    )));
+                    reason = CancellationReason::condition_failed(key);
                 }
             }
+            reasons.push(reason);
         }
 
-        // Phase 2: All conditions passed, perform all writes
-        let mut committed = 0;
-        for (i, (key, op)) in operations.iter().enumerate() {
-            match op {
-                TransactWriteOperation::Put { item, .. } => {
-                    // Perform put
-                    let seq = inner.next_seq;
-                    inner.next_seq += 1;
-                    let record = Record::put(key.clone(), item.clone(), seq);
-                    inner.wal.append(record.clone())?;
-
-                    let stripe_id = stripe_id(&key.pk);
-                    let key_enc = key.encode().to_vec();
-                    inner.stripes[stripe_id].memtable.insert(key_enc, record);
-
-                    if inner.stripes[stripe_id].memtable.len() >= MEMTABLE_THRESHOLD {
-                        Self::flush_stripe(&mut inner, stripe_id)?;
-                    }
-
-                    committed += 1;
-                }
-                TransactWriteOperation::Delete { .. } => {
-                    // Perform delete
-                    let seq = inner.next_seq;
-                    inner.next_seq += 1;
-                    let record = Record::delete(key.clone(), seq);
-                    inner.wal.append(record.clone())?;
-
-                    let stripe_id = stripe_id(&key.pk);
-                    let key_enc = key.encode().to_vec();
-                    inner.stripes[stripe_id].memtable.insert(key_enc, record);
-
-                    if inner.stripes[stripe_id].memtable.len() >= MEMTABLE_THRESHOLD {
-                        Self::flush_stripe(&mut inner, stripe_id)?;
-                    }
+        // Cancel without writing anything if any condition failed
+        if reasons.iter().any(|r| !r.is_none()) {
+            return Ok(TransactWriteOutcome::Canceled(reasons));
+        }
 
-                    committed += 1;
-                }
+        // Phase 2: All conditions passed, work out the new items
+        let mut writes: Vec<(&Key, Option<Item>)> = Vec::new();
+        for (i, (key, op)) in operations.iter().enumerate() {
+            let item = match op {
+                TransactWriteOperation::Put { item, .. } => Some(item.clone()),
+                TransactWriteOperation::Delete { .. } => None,
                 TransactWriteOperation::Update { actions, .. } => {
-                    // Perform update
                     let current_item = current_items[i].clone().unwrap_or_else(|| HashMap::new());
                     let executor = UpdateExecutor::new(context);
                     let updated_item = executor.execute(&current_item, actions)?;
+                    check_item_size(&updated_item)?;
+                    Some(updated_item)
+                }
+                // Condition already checked in phase 1, no write needed
+                TransactWriteOperation::ConditionCheck { .. } => continue,
+            };
+            writes.push((key, item));
+        }
 
-                    let seq = inner.next_seq;
-                    inner.next_seq += 1;
-                    let record = Record::put(key.clone(), updated_item, seq);
-                    inner.wal.append(record.clone())?;
-
-                    let stripe_id = stripe_id(&key.pk);
-                    let key_enc = key.encode().to_vec();
-                    inner.stripes[stripe_id].memtable.insert(key_enc, record);
-
-                    if inner.stripes[stripe_id].memtable.len() >= MEMTABLE_THRESHOLD {
-                        Self::flush_stripe(&mut inner, stripe_id)?;
-                    }
+        // Under a memory limit, fail before writing anything
+        if let Some(budget) = &inner.budget {
+            let sizes: Vec<(&Key, Option<&Item>)> =
+                writes.iter().map(|(key, item)| (*key, item.as_ref())).collect();
+            budget.check_room(&inner.stripes, &sizes)?;
+        }
 
-                    committed += 1;
-                }
-                TransactWriteOperation::ConditionCheck { .. } => {
-                    // Condition already checked in phase 1, no write needed
-                    committed += 1;
-                }
-            }
+        // Phase 3: perform all writes
+        for (key, item) in writes {
+            let seq = inner.next_seq;
+            inner.next_seq += 1;
+            let record = match item {
+                Some(item) => Record::put(key.clone(), item, seq),
+                None => Record::delete(key.clone(), seq),
+            };
+            Self::write_locked(&mut inner, record)?;
         }
 
-        Ok(committed)
+        Ok(TransactWriteOutcome::Committed(operations.len()))
     }
 }
 
@@ -761,4 +1188,48 @@ mod tests {
         let key2 = Key::with_sk(b"pk1".to_vec(), b"sk2".to_vec());
         assert!(engine.get(&key2).unwrap().is_none());
     }
+
+    #[test]
+    fn test_memory_lsm_limit_counts_only_newest_versions() {
+        let limit = MemoryLimit { max_bytes: 512, policy: EvictionPolicy::Reject };
+        let engine = MemoryLsmEngine::create_with_limit(limit).unwrap();
+        let key = Key::new(b"counter".to_vec());
+
+        // Overwrites replace the stored version instead of piling up
+        for i in 0..2000 {
+            engine.put(key.clone(), create_test_item(&format!("value{:04}", i))).unwrap();
+        }
+        engine.flush().unwrap();
+        engine.put(key.clone(), create_test_item("last")).unwrap();
+        assert_eq!(engine.len(), 1);
+
+        // A transaction that doesn't fit writes nothing
+        let big = create_test_item(&"x".repeat(200));
+        let operations: Vec<_> = (0..3)
+            .map(|i| {
+                let op = TransactWriteOperation::Put { item: big.clone(), condition: None };
+                (Key::new(format!("big{}", i).into_bytes()), op)
+            })
+            .collect();
+        let err = engine.transact_write(&operations, &ExpressionContext::new()).unwrap_err();
+        assert!(matches!(err, Error::MemoryLimit { limit: 512, .. }), "{:?}", err);
+        assert!(engine.get(&Key::new(b"big0".to_vec())).unwrap().is_none());
+        assert_eq!(engine.get(&key).unwrap(), Some(create_test_item("last")));
+
+        assert!(MemoryLsmEngine::create_with_limit(MemoryLimit { max_bytes: 0, ..limit }).is_err());
+    }
+
+    #[test]
+    fn test_transact_get_checked_treats_expired_items_as_missing() {
+        let engine = MemoryLsmEngine::create_with_schema(TableSchema::new().with_ttl("expiresAt")).unwrap();
+        let key = Key::new(b"session#1".to_vec());
+        let mut item = HashMap::new();
+        item.insert("expiresAt".to_string(), Value::number(1));
+        engine.put(key.clone(), item).unwrap();
+
+        let missing = crate::expression::ExpressionParser::parse("attribute_not_exists(expiresAt)").unwrap();
+        let checks = vec![(key.clone(), missing)];
+        let items = engine.transact_get_checked(&[key.clone()], &checks, &ExpressionContext::new()).unwrap();
+        assert_eq!(items, vec![None]);
+    }
 }
@@ -88,6 +88,19 @@ impl MemorySstReader {
             .map(|idx| &self.records[idx])
     }
 
+    /// Remove the record for `key`, if any
+    ///
+    /// The bloom filter keeps the key's bits, so later lookups of it fall
+    /// through to the binary search.
+    pub(crate) fn remove(&mut self, key: &Key) -> Option<Record> {
+        let key_bytes = key.encode();
+        let idx = self
+            .records
+            .binary_search_by(|r| r.key.encode().as_ref().cmp(key_bytes.as_ref()))
+            .ok()?;
+        Some(self.records.remove(idx))
+    }
+
     /// Iterate over all records
     pub fn iter(&self) -> impl Iterator<Item = &Record> {
         self.records.iter()
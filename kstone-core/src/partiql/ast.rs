@@ -3,6 +3,7 @@
 /// This module provides a simplified Abstract Syntax Tree that wraps sqlparser's AST
 /// with types specific to DynamoDB PartiQL operations.
 
+use crate::{Error, Result};
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
 
@@ -15,6 +16,86 @@ pub enum PartiQLStatement {
     Delete(DeleteStatement),
 }
 
+impl PartiQLStatement {
+    /// Number of parameters the statement takes
+    ///
+    /// Parameters are written `?` and numbered in order of appearance.
+    pub fn parameter_count(&self) -> usize {
+        self.values()
+            .into_iter()
+            .filter_map(SqlValue::max_parameter)
+            .max()
+            .map_or(0, |index| index + 1)
+    }
+
+    /// Substitute `params` for the statement's parameters, in order
+    ///
+    /// Fails unless exactly `parameter_count()` values are given. A
+    /// statement without parameters binds to an empty list unchanged.
+    pub fn bind(mut self, params: &[SqlValue]) -> Result<Self> {
+        let expected = self.parameter_count();
+        if params.len() != expected {
+            return Err(Error::InvalidQuery(format!(
+                "Statement takes {} parameters, got {}",
+                expected,
+                params.len()
+            )));
+        }
+        if expected > 0 {
+            for value in self.values_mut() {
+                value.bind(params);
+            }
+        }
+        Ok(self)
+    }
+
+    /// Every literal value in the statement
+    fn values(&self) -> Vec<&SqlValue> {
+        match self {
+            PartiQLStatement::Select(stmt) => stmt
+                .where_clause
+                .iter()
+                .flat_map(|clause| clause.conditions.iter().map(|c| &c.value))
+                .collect(),
+            PartiQLStatement::Insert(stmt) => vec![&stmt.value],
+            PartiQLStatement::Update(stmt) => stmt
+                .where_clause
+                .conditions
+                .iter()
+                .map(|c| &c.value)
+                .chain(stmt.set_assignments.iter().map(|a| a.value.value()))
+                .collect(),
+            PartiQLStatement::Delete(stmt) => {
+                stmt.where_clause.conditions.iter().map(|c| &c.value).collect()
+            }
+        }
+    }
+
+    fn values_mut(&mut self) -> Vec<&mut SqlValue> {
+        match self {
+            PartiQLStatement::Select(stmt) => stmt
+                .where_clause
+                .iter_mut()
+                .flat_map(|clause| clause.conditions.iter_mut().map(|c| &mut c.value))
+                .collect(),
+            PartiQLStatement::Insert(stmt) => vec![&mut stmt.value],
+            PartiQLStatement::Update(stmt) => stmt
+                .where_clause
+                .conditions
+                .iter_mut()
+                .map(|c| &mut c.value)
+                .chain(stmt.set_assignments.iter_mut().map(|a| a.value.value_mut()))
+                .collect(),
+            PartiQLStatement::Delete(stmt) => stmt
+                .where_clause
+                .conditions
+                .iter_mut()
+                .map(|c| &mut c.value)
+                .collect(),
+        }
+    }
+}
+
 /// SELECT statement
 #[derive(Debug, Clone, PartialEq)]
 pub struct SelectStatement {
@@ -116,6 +197,8 @@ pub enum SqlValue {
     List(Vec<SqlValue>),
     /// Map/Object
     Map(HashMap<String, SqlValue>),
+    /// Statement parameter, by position from 0 (see `PartiQLStatement::bind`)
+    Parameter(usize),
 }
 
 impl SqlValue {
@@ -139,6 +222,29 @@ impl SqlValue {
                 }
                 crate::Value::M(kv_map)
             }
+            // Statements are bound before they are translated, so this is
+            // never reached for a statement that executes
+            SqlValue::Parameter(_) => crate::Value::Null,
+        }
+    }
+
+    /// Position of the last parameter in this value, if it has any
+    fn max_parameter(&self) -> Option<usize> {
+        match self {
+            SqlValue::Parameter(index) => Some(*index),
+            SqlValue::List(items) => items.iter().filter_map(SqlValue::max_parameter).max(),
+            SqlValue::Map(map) => map.values().filter_map(SqlValue::max_parameter).max(),
+            _ => None,
+        }
+    }
+
+    /// Replace parameters with their values; `params` covers every position
+    fn bind(&mut self, params: &[SqlValue]) {
+        match self {
+            SqlValue::Parameter(index) => *self = params[*index].clone(),
+            SqlValue::List(items) => items.iter_mut().for_each(|item| item.bind(params)),
+            SqlValue::Map(map) => map.values_mut().for_each(|value| value.bind(params)),
+            _ => {}
         }
     }
 
@@ -174,6 +280,16 @@ impl SqlValue {
                 SqlValue::List(numbers)
             }
             crate::Value::Ts(ts) => SqlValue::Number(ts.to_string()),
+            // Sets read back as lists
+            crate::Value::SS(set) => {
+                SqlValue::List(set.iter().map(|s| SqlValue::String(s.clone())).collect())
+            }
+            crate::Value::NS(set) => {
+                SqlValue::List(set.iter().map(|n| SqlValue::Number(n.clone())).collect())
+            }
+            crate::Value::BS(set) => {
+                SqlValue::List(set.iter().map(|b| SqlValue::String(base64_encode(b))).collect())
+            }
         }
     }
 }
@@ -246,6 +362,24 @@ pub enum SetValue {
     },
 }
 
+impl SetValue {
+    fn value(&self) -> &SqlValue {
+        match self {
+            SetValue::Literal(value)
+            | SetValue::Add { value, .. }
+            | SetValue::Subtract { value, .. } => value,
+        }
+    }
+
+    fn value_mut(&mut self) -> &mut SqlValue {
+        match self {
+            SetValue::Literal(value)
+            | SetValue::Add { value, .. }
+            | SetValue::Subtract { value, .. } => value,
+        }
+    }
+}
+
 /// DELETE statement
 #[derive(Debug, Clone, PartialEq)]
 pub struct DeleteStatement {
@@ -12,6 +12,10 @@ use sqlparser::parser::Parser as SqlParser;
 /// PartiQL statement parser
 pub struct PartiQLParser;
 
+/// Prefix marking a parameter inside the JSON map of an INSERT; strings in
+/// the map would have to spell a NUL as an escape to clash with it
+const JSON_PARAMETER_MARKER: char = '\0';
+
 impl PartiQLParser {
     /// Parse a PartiQL statement
     pub fn parse(sql: &str) -> Result<PartiQLStatement> {
@@ -26,6 +30,9 @@ impl PartiQLParser {
             )));
         }
 
+        // Number `?` parameters as `$1`, `$2`, ... so each keeps its position
+        let sql = &Self::number_parameters(sql);
+
         // Special handling for INSERT with JSON map
         if sql.trim().to_uppercase().starts_with("INSERT") && sql.contains('{') {
             return Self::parse_insert_with_json_map(sql);
@@ -58,6 +65,42 @@ impl PartiQLParser {
         Self::convert_statement(&statements[0])
     }
 
+    /// Replace each `?` outside quotes with `$N`, counting from 1
+    fn number_parameters(sql: &str) -> String {
+        let mut numbered = String::with_capacity(sql.len());
+        let mut quote = None;
+        let mut count = 0;
+        for c in sql.chars() {
+            match (quote, c) {
+                (None, '\'' | '"') => quote = Some(c),
+                (Some(q), _) if q == c => quote = None,
+                (None, '?') => {
+                    count += 1;
+                    numbered.push_str(&format!("${}", count));
+                    continue;
+                }
+                _ => {}
+            }
+            numbered.push(c);
+        }
+        numbered
+    }
+
+    /// Position (from 0) of a numbered `$N` parameter
+    fn parameter_index(placeholder: &str) -> Result<usize> {
+        placeholder
+            .strip_prefix('$')
+            .and_then(|n| n.parse::<usize>().ok())
+            .filter(|&n| n > 0)
+            .map(|n| n - 1)
+            .ok_or_else(|| {
+                Error::InvalidQuery(format!(
+                    "Unsupported parameter: {} (use ? for parameters)",
+                    placeholder
+                ))
+            })
+    }
+
     /// Special parser for INSERT statements with JSON map syntax
     /// Handles: INSERT INTO table VALUE {'pk': 'value', ...}
     fn parse_insert_with_json_map(sql: &str) -> Result<PartiQLStatement> {
@@ -451,6 +494,7 @@ impl PartiQLParser {
             }
             sql_ast::Value::Boolean(b) => Ok(SqlValue::Boolean(*b)),
             sql_ast::Value::Null => Ok(SqlValue::Null),
+            sql_ast::Value::Placeholder(p) => Ok(SqlValue::Parameter(Self::parameter_index(p)?)),
             _ => Err(Error::InvalidQuery(format!("Unsupported SQL value: {:?}", val))),
         }
     }
@@ -660,7 +704,7 @@ impl PartiQLParser {
     fn parse_json_string(s: &str) -> Result<SqlValue> {
         // DynamoDB uses single quotes, but JSON requires double quotes
         // Convert single quotes to double quotes (simple approach - may need refinement)
-        let json_normalized = s.replace('\'', "\"");
+        let json_normalized = Self::quote_json_parameters(&s.replace('\'', "\""));
 
         let json_value: serde_json::Value = serde_json::from_str(&json_normalized).map_err(|e| {
             Error::InvalidQuery(format!("Failed to parse JSON: {}", e))
@@ -669,13 +713,46 @@ impl PartiQLParser {
         Self::json_to_sql_value(&json_value)
     }
 
+    /// Turn each `$N` parameter outside strings into a marked JSON string
+    fn quote_json_parameters(json: &str) -> String {
+        let mut quoted = String::with_capacity(json.len());
+        let mut chars = json.chars().peekable();
+        let mut in_string = false;
+        while let Some(c) = chars.next() {
+            match c {
+                '"' => in_string = !in_string,
+                '\\' if in_string => {
+                    quoted.push(c);
+                    if let Some(escaped) = chars.next() {
+                        quoted.push(escaped);
+                    }
+                    continue;
+                }
+                '$' if !in_string => {
+                    quoted.push_str("\"\\u0000$");
+                    while let Some(digit) = chars.next_if(|d| d.is_ascii_digit()) {
+                        quoted.push(digit);
+                    }
+                    quoted.push('"');
+                    continue;
+                }
+                _ => {}
+            }
+            quoted.push(c);
+        }
+        quoted
+    }
+
     /// Convert serde_json::Value to SqlValue
     fn json_to_sql_value(value: &serde_json::Value) -> Result<SqlValue> {
         match value {
             serde_json::Value::Null => Ok(SqlValue::Null),
             serde_json::Value::Bool(b) => Ok(SqlValue::Boolean(*b)),
             serde_json::Value::Number(n) => Ok(SqlValue::Number(n.to_string())),
-            serde_json::Value::String(s) => Ok(SqlValue::String(s.clone())),
+            serde_json::Value::String(s) => match s.strip_prefix(JSON_PARAMETER_MARKER) {
+                Some(placeholder) => Ok(SqlValue::Parameter(Self::parameter_index(placeholder)?)),
+                None => Ok(SqlValue::String(s.clone())),
+            },
             serde_json::Value::Array(arr) => {
                 let items: Result<Vec<SqlValue>> = arr.iter().map(Self::json_to_sql_value).collect();
                 Ok(SqlValue::List(items?))
@@ -1345,4 +1422,65 @@ mod tests {
             _ => panic!("Expected SELECT statement"),
         }
     }
+
+    #[test]
+    fn test_parse_and_bind_parameters() {
+        let sql = "SELECT * FROM users WHERE pk = ? AND age > ? AND note = 'why?'";
+        let stmt = PartiQLParser::parse(sql).unwrap();
+        assert_eq!(stmt.parameter_count(), 2);
+
+        let bound = stmt
+            .clone()
+            .bind(&[SqlValue::String("user#1".to_string()), SqlValue::Number("30".to_string())])
+            .unwrap();
+        match bound {
+            PartiQLStatement::Select(select) => {
+                let conditions = select.where_clause.unwrap().conditions;
+                assert_eq!(conditions[0].value, SqlValue::String("user#1".to_string()));
+                assert_eq!(conditions[1].value, SqlValue::Number("30".to_string()));
+                // A ? inside a string literal is not a parameter
+                assert_eq!(conditions[2].value, SqlValue::String("why?".to_string()));
+            }
+            _ => panic!("Expected SELECT statement"),
+        }
+
+        // The number of values must match
+        assert!(stmt.clone().bind(&[]).is_err());
+        assert!(stmt.bind(&[SqlValue::Null, SqlValue::Null, SqlValue::Null]).is_err());
+    }
+
+    #[test]
+    fn test_parse_parameters_in_insert_and_update() {
+        let stmt = PartiQLParser::parse("INSERT INTO users VALUE {'pk': ?, 'tags': [?, 'x']}").unwrap();
+        assert_eq!(stmt.parameter_count(), 2);
+        match stmt.bind(&[SqlValue::String("user#1".to_string()), SqlValue::Boolean(true)]).unwrap() {
+            PartiQLStatement::Insert(insert) => match insert.value {
+                SqlValue::Map(map) => {
+                    assert_eq!(map["pk"], SqlValue::String("user#1".to_string()));
+                    assert_eq!(
+                        map["tags"],
+                        SqlValue::List(vec![SqlValue::Boolean(true), SqlValue::String("x".to_string())])
+                    );
+                }
+                other => panic!("Expected map, got {:?}", other),
+            },
+            _ => panic!("Expected INSERT statement"),
+        }
+
+        let stmt = PartiQLParser::parse("UPDATE users SET score = score + ? WHERE pk = ?").unwrap();
+        assert_eq!(stmt.parameter_count(), 2);
+        match stmt.bind(&[SqlValue::Number("5".to_string()), SqlValue::String("user#1".to_string())]).unwrap() {
+            PartiQLStatement::Update(update) => {
+                assert_eq!(
+                    update.set_assignments[0].value,
+                    SetValue::Add {
+                        attribute: "score".to_string(),
+                        value: SqlValue::Number("5".to_string()),
+                    }
+                );
+                assert_eq!(update.where_clause.conditions[0].value, SqlValue::String("user#1".to_string()));
+            }
+            _ => panic!("Expected UPDATE statement"),
+        }
+    }
 }
@@ -0,0 +1,197 @@
+/// Salvaging damaged databases
+///
+/// `repair` reads what it can from a database directory whose files fail
+/// their checksums (see `LsmEngine::verify`) and writes the surviving items
+/// into a new database, leaving the damaged one untouched. The newest
+/// version of each key is taken from the intact SST files and the readable
+/// part of the WAL. Since the WAL keeps every write, items of a damaged SST
+/// file usually survive there.
+
+use crate::lsm::LsmEngine;
+use crate::wal::Wal;
+use crate::{sst, Error, IntegrityProblem, Record, Result, SeqNo};
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::path::Path;
+
+/// Options for `repair`
+#[derive(Debug, Clone, Default)]
+pub struct RepairOptions {
+    /// Keep the records that can still be decoded from a damaged SST file
+    ///
+    /// Off by default: a failed checksum can't tell which records were
+    /// hit, so these may carry damaged values. When off, a damaged file's
+    /// items are recovered only if the WAL or another SST file holds them.
+    pub keep_unverified_records: bool,
+}
+
+/// Outcome of `repair`
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct RepairReport {
+    /// Items written to the new database
+    pub items_recovered: u64,
+    /// Writes known to be lost
+    ///
+    /// Counts records of damaged SST files that could not be read, and
+    /// those left out with no other copy of the same or a newer version.
+    /// An item whose newest version was lost but an older one survived is
+    /// counted here and in `items_recovered`. Writes past damage in the
+    /// WAL can't be counted and are not included.
+    pub items_lost: u64,
+    /// Files that failed their checks
+    pub damaged_files: Vec<IntegrityProblem>,
+}
+
+/// Copy what survives of the database at `src` into a new database at `dst`
+///
+/// Fails if `dst` already holds a database, or if `src` can't be read at
+/// all; damage to individual files is reported, not returned as an error.
+pub fn repair(src: impl AsRef<Path>, dst: impl AsRef<Path>, options: &RepairOptions) -> Result<RepairReport> {
+    let src = src.as_ref();
+    let mut report = RepairReport::default();
+    let mut newest: BTreeMap<Vec<u8>, Record> = BTreeMap::new();
+    // Newest version of each key seen only in damaged files
+    let mut at_risk: HashMap<Vec<u8>, SeqNo> = HashMap::new();
+
+    let mut sst_paths: Vec<_> = fs::read_dir(src)?
+        .map(|entry| entry.map(|e| e.path()))
+        .collect::<std::io::Result<_>>()?;
+    sst_paths.retain(|path| path.extension().map_or(false, |ext| ext == "sst"));
+    sst_paths.sort();
+
+    for path in sst_paths {
+        match sst::SstReader::open(&path) {
+            Ok(reader) => {
+                for record in reader.iter() {
+                    keep_newest(&mut newest, record.clone());
+                }
+            }
+            Err(e) => {
+                let (records, claimed) = sst::salvage(&path)?;
+                report.items_lost += claimed.saturating_sub(records.len()) as u64;
+                for record in records {
+                    let seq = at_risk.entry(record.key.encode().to_vec()).or_insert(0);
+                    *seq = (*seq).max(record.seq);
+                    if options.keep_unverified_records {
+                        keep_newest(&mut newest, record);
+                    }
+                }
+                report.damaged_files.push(IntegrityProblem { path, detail: e.to_string() });
+            }
+        }
+    }
+
+    let wal_path = src.join("wal.log");
+    let wal_damage = match Wal::open(&wal_path) {
+        Ok(wal) => {
+            let (records, damage) = wal.read_intact()?;
+            for (_lsn, record) in records {
+                keep_newest(&mut newest, record);
+            }
+            damage
+        }
+        Err(Error::Io(e)) if e.kind() == std::io::ErrorKind::NotFound => None,
+        Err(e) => Some(e),
+    };
+    if let Some(e) = wal_damage {
+        report.damaged_files.push(IntegrityProblem {
+            path: wal_path,
+            detail: e.to_string(),
+        });
+    }
+
+    report.items_lost += at_risk
+        .iter()
+        .filter(|(key, &seq)| newest.get(*key).map_or(true, |record| record.seq < seq))
+        .count() as u64;
+
+    let engine = LsmEngine::create(dst)?;
+    let items = newest
+        .into_values()
+        .filter_map(|record| record.value.map(|item| (record.key, item)));
+    report.items_recovered = engine.bulk_load(items)?;
+
+    Ok(report)
+}
+
+fn keep_newest(newest: &mut BTreeMap<Vec<u8>, Record>, record: Record) {
+    let key = record.key.encode().to_vec();
+    match newest.get(&key) {
+        Some(existing) if existing.seq >= record.seq => {}
+        _ => {
+            newest.insert(key, record);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{Key, Value};
+    use std::collections::HashMap;
+    use tempfile::TempDir;
+
+    fn item(n: i64) -> crate::Item {
+        let mut item = HashMap::new();
+        item.insert("n".to_string(), Value::number(n));
+        item
+    }
+
+    #[test]
+    fn test_repair_takes_newest_versions_and_drops_tombstones() {
+        let dir = TempDir::new().unwrap();
+        let src = dir.path().join("src");
+        {
+            let engine = LsmEngine::create(&src).unwrap();
+            engine.put(Key::new(b"a".to_vec()), item(1)).unwrap();
+            engine.put(Key::new(b"b".to_vec()), item(1)).unwrap();
+            engine.flush().unwrap();
+            engine.put(Key::new(b"a".to_vec()), item(2)).unwrap();
+            engine.delete(Key::new(b"b".to_vec())).unwrap();
+        }
+
+        let dst = dir.path().join("dst");
+        let report = repair(&src, &dst, &RepairOptions::default()).unwrap();
+        assert_eq!(report, RepairReport { items_recovered: 1, ..Default::default() });
+
+        let repaired = LsmEngine::open(&dst).unwrap();
+        assert_eq!(repaired.get(&Key::new(b"a".to_vec())).unwrap(), Some(item(2)));
+        assert_eq!(repaired.get(&Key::new(b"b".to_vec())).unwrap(), None);
+
+        // The target must be a new database
+        assert!(repair(&src, &dst, &RepairOptions::default()).is_err());
+    }
+
+    #[test]
+    fn test_repair_salvages_unverified_records_on_request() {
+        let dir = TempDir::new().unwrap();
+        let src = dir.path().join("src");
+        {
+            let engine = LsmEngine::create(&src).unwrap();
+            engine.put(Key::new(b"a".to_vec()), item(1)).unwrap();
+            engine.flush().unwrap();
+        }
+
+        // Lose the WAL and damage the SST's checksum, leaving records intact
+        fs::remove_file(src.join("wal.log")).unwrap();
+        let sst_path = fs::read_dir(&src)
+            .unwrap()
+            .map(|entry| entry.unwrap().path())
+            .find(|path| path.extension().map_or(false, |ext| ext == "sst"))
+            .unwrap();
+        let mut bytes = fs::read(&sst_path).unwrap();
+        let last = bytes.len() - 1;
+        bytes[last] ^= 0xff;
+        fs::write(&sst_path, &bytes).unwrap();
+
+        let report = repair(&src, dir.path().join("strict"), &RepairOptions::default()).unwrap();
+        assert_eq!(report.items_recovered, 0);
+        assert_eq!(report.items_lost, 1);
+        assert_eq!(report.damaged_files.len(), 1);
+
+        let options = RepairOptions { keep_unverified_records: true };
+        let report = repair(&src, dir.path().join("lenient"), &options).unwrap();
+        assert_eq!(report.items_recovered, 1);
+        assert_eq!(report.items_lost, 0);
+    }
+}
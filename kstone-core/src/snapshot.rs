@@ -0,0 +1,92 @@
+/// Point-in-time read views
+///
+/// The engine keeps a single version of each key, so a snapshot does not
+/// pin old versions in the LSM tree. Instead, while a snapshot is open,
+/// every committed base-table write first hands the version it replaces to
+/// the snapshot, which keeps the first one it sees per key. Reads through
+/// the snapshot use those preserved versions and fall through to the live
+/// tree for keys that have not changed since the snapshot was taken.
+///
+/// Index records are not preserved, so index queries are not available on
+/// snapshots.
+
+use crate::iterator::{QueryParams, QueryResult, ScanParams, ScanResult};
+use crate::lsm::LsmEngine;
+use crate::{Item, Key, Record, Result, SeqNo};
+use parking_lot::Mutex;
+use std::collections::HashMap;
+use std::sync::Arc;
+
+/// Versions a snapshot needs that the live tree no longer has
+pub(crate) struct SnapshotState {
+    seq: SeqNo,
+    /// Version as of `seq` of each key written since; None if it was absent
+    preserved: Mutex<HashMap<Key, Option<Record>>>,
+}
+
+impl SnapshotState {
+    pub(crate) fn new(seq: SeqNo) -> Arc<Self> {
+        Arc::new(Self {
+            seq,
+            preserved: Mutex::new(HashMap::new()),
+        })
+    }
+
+    /// Record the version a write is about to replace, unless one was already kept
+    pub(crate) fn preserve(&self, key: &Key, previous: Option<Record>) {
+        self.preserved.lock().entry(key.clone()).or_insert(previous);
+    }
+
+    /// The preserved version of `key`, if it has been written since the snapshot
+    pub(crate) fn lookup(&self, key: &Key) -> Option<Option<Record>> {
+        self.preserved.lock().get(key).cloned()
+    }
+
+    /// Visit every preserved key with its version as of the snapshot
+    pub(crate) fn for_each(&self, mut f: impl FnMut(&Key, Option<&Record>)) {
+        for (key, record) in self.preserved.lock().iter() {
+            f(key, record.as_ref());
+        }
+    }
+}
+
+/// A consistent read view of the database as of one sequence number
+///
+/// Writes made after the snapshot was taken are not visible through it.
+/// Keep snapshots short-lived: until released (or dropped), every write
+/// copies the version it replaces into the snapshot.
+pub struct Snapshot {
+    engine: LsmEngine,
+    state: Arc<SnapshotState>,
+}
+
+impl Snapshot {
+    pub(crate) fn new(engine: LsmEngine, state: Arc<SnapshotState>) -> Self {
+        Self { engine, state }
+    }
+
+    /// Sequence number of the last write visible in the snapshot
+    pub fn seq(&self) -> SeqNo {
+        self.state.seq
+    }
+
+    /// Get an item as of the snapshot
+    pub fn get(&self, key: &Key) -> Result<Option<Item>> {
+        self.engine.get_at(key, Some(&self.state))
+    }
+
+    /// Query a partition as of the snapshot (base table only)
+    pub fn query(&self, params: QueryParams) -> Result<QueryResult> {
+        self.engine.query_at(params, Some(&self.state))
+    }
+
+    /// Scan the table as of the snapshot
+    pub fn scan(&self, params: ScanParams) -> Result<ScanResult> {
+        self.engine.scan_at(params, Some(&self.state))
+    }
+
+    /// Release the snapshot; writes stop preserving versions for it
+    ///
+    /// Dropping the snapshot has the same effect.
+    pub fn release(self) {}
+}
@@ -0,0 +1,177 @@
+/// In-process change subscriptions
+///
+/// An embedded application can subscribe to the items under a partition
+/// key prefix and receive an event for each insert, update and delete as it
+/// is committed, e.g. to keep a reactive UI current. Unlike a WAL tail,
+/// events say whether a put created or replaced the item, and only
+/// matching keys are delivered.
+///
+/// Each subscription has a bounded buffer, and writers never wait for a
+/// subscriber: an event that finds the buffer full is dropped and counted
+/// in `Subscription::dropped`. A subscriber that sees the count rise has
+/// missed changes and should reload what it shows.
+
+use crate::{Item, Key, Record, SeqNo};
+use bytes::Bytes;
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::mpsc::{self, Receiver, SyncSender, TrySendError};
+use std::sync::Arc;
+use std::time::Duration;
+
+/// What a change did to the item
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ChangeKind {
+    /// The item was created
+    Insert,
+    /// An existing item was replaced or updated
+    Modify,
+    /// The item was deleted
+    Remove,
+}
+
+/// A committed change to an item
+#[derive(Debug, Clone, PartialEq)]
+pub struct ChangeEvent {
+    pub kind: ChangeKind,
+    pub key: Key,
+    /// The item as written (None for `Remove`)
+    pub item: Option<Item>,
+    pub seq: SeqNo,
+}
+
+#[derive(Default)]
+struct SubscriptionState {
+    closed: AtomicBool,
+    dropped: AtomicU64,
+}
+
+struct Subscriber {
+    prefix: Bytes,
+    sender: SyncSender<ChangeEvent>,
+    state: Arc<SubscriptionState>,
+}
+
+/// Open subscriptions of an engine
+#[derive(Default)]
+pub(crate) struct Subscribers {
+    subscribers: Vec<Subscriber>,
+}
+
+impl Subscribers {
+    pub(crate) fn is_empty(&self) -> bool {
+        self.subscribers.is_empty()
+    }
+
+    /// Subscribe to keys whose partition key starts with `prefix`
+    pub(crate) fn subscribe(&mut self, prefix: Bytes, buffer: usize) -> Subscription {
+        let (sender, receiver) = mpsc::sync_channel(buffer.max(1));
+        let state = Arc::new(SubscriptionState::default());
+        self.subscribers.push(Subscriber {
+            prefix,
+            sender,
+            state: Arc::clone(&state),
+        });
+        Subscription { receiver, state }
+    }
+
+    /// Deliver a committed write to the matching subscribers; `existed`
+    /// says whether the key held an item before it
+    pub(crate) fn publish(&mut self, record: &Record, existed: bool) {
+        self.subscribers.retain(|s| !s.state.closed.load(Ordering::SeqCst));
+
+        let kind = match (&record.value, existed) {
+            (Some(_), false) => ChangeKind::Insert,
+            (Some(_), true) => ChangeKind::Modify,
+            (None, true) => ChangeKind::Remove,
+            // Deleting a missing item changes nothing
+            (None, false) => return,
+        };
+        for subscriber in self.subscribers.iter().filter(|s| record.key.pk.starts_with(&s.prefix)) {
+            let event = ChangeEvent {
+                kind,
+                key: record.key.clone(),
+                item: record.value.clone(),
+                seq: record.seq,
+            };
+            if let Err(TrySendError::Full(_)) = subscriber.sender.try_send(event) {
+                subscriber.state.dropped.fetch_add(1, Ordering::SeqCst);
+            }
+        }
+    }
+}
+
+/// Changes to the items under a partition key prefix
+///
+/// Iterating blocks until the next change; use `try_next` or
+/// `next_timeout` to poll instead. Dropping the subscription closes it.
+pub struct Subscription {
+    receiver: Receiver<ChangeEvent>,
+    state: Arc<SubscriptionState>,
+}
+
+impl Subscription {
+    /// Next change if one is waiting, without blocking
+    pub fn try_next(&mut self) -> Option<ChangeEvent> {
+        self.receiver.try_recv().ok()
+    }
+
+    /// Next change, waiting up to `timeout` for one
+    pub fn next_timeout(&mut self, timeout: Duration) -> Option<ChangeEvent> {
+        self.receiver.recv_timeout(timeout).ok()
+    }
+
+    /// Number of changes dropped because the buffer was full
+    pub fn dropped(&self) -> u64 {
+        self.state.dropped.load(Ordering::SeqCst)
+    }
+
+    /// Stop receiving changes
+    pub fn close(self) {}
+}
+
+impl Iterator for Subscription {
+    type Item = ChangeEvent;
+
+    /// Block until the next change; None once the database is closed
+    fn next(&mut self) -> Option<ChangeEvent> {
+        self.receiver.recv().ok()
+    }
+}
+
+impl Drop for Subscription {
+    fn drop(&mut self) {
+        self.state.closed.store(true, Ordering::SeqCst);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn put(pk: &str, seq: SeqNo) -> Record {
+        Record::put(Key::new(Bytes::from(pk.to_string())), Item::new(), seq)
+    }
+
+    #[test]
+    fn test_full_buffer_drops_and_counts() {
+        let mut subscribers = Subscribers::default();
+        let mut subscription = subscribers.subscribe(Bytes::from("user#"), 2);
+        for seq in 1..=5 {
+            subscribers.publish(&put("user#1", seq), seq > 1);
+        }
+
+        assert_eq!(subscription.try_next().map(|e| (e.kind, e.seq)), Some((ChangeKind::Insert, 1)));
+        assert_eq!(subscription.try_next().map(|e| (e.kind, e.seq)), Some((ChangeKind::Modify, 2)));
+        assert!(subscription.try_next().is_none());
+        assert_eq!(subscription.dropped(), 3);
+    }
+
+    #[test]
+    fn test_closed_subscriptions_are_removed() {
+        let mut subscribers = Subscribers::default();
+        let subscription = subscribers.subscribe(Bytes::from("user#"), 4);
+        subscription.close();
+        subscribers.publish(&put("user#1", 1), false);
+        assert!(subscribers.is_empty());
+    }
+}
@@ -1,6 +1,7 @@
 use bytes::{Bytes, BytesMut, BufMut};
 use serde::{Deserialize, Serialize};
-use std::collections::HashMap;
+use std::collections::{BTreeSet, HashMap};
+use std::path::PathBuf;
 
 /// Logical Sequence Number - monotonic commit order
 pub type Lsn = u64;
@@ -29,6 +30,12 @@ pub enum Value {
     VecF32(Vec<f32>),
     /// Timestamp (i64 milliseconds since epoch)
     Ts(i64),
+    /// String set
+    SS(BTreeSet<String>),
+    /// Number set (numbers stored as strings, like N)
+    NS(BTreeSet<String>),
+    /// Binary set
+    BS(BTreeSet<Bytes>),
 }
 
 impl Value {
@@ -55,6 +62,21 @@ impl Value {
         }
     }
 
+    /// Numeric value, if this is a number
+    pub fn as_number(&self) -> Option<f64> {
+        match self {
+            Value::N(n) => n.parse().ok(),
+            _ => None,
+        }
+    }
+
+    pub fn as_bool(&self) -> Option<bool> {
+        match self {
+            Value::Bool(b) => Some(*b),
+            _ => None,
+        }
+    }
+
     pub fn as_map(&self) -> Option<&HashMap<String, Value>> {
         match self {
             Value::M(m) => Some(m),
@@ -83,11 +105,114 @@ impl Value {
             _ => None,
         }
     }
+
+    pub fn string_set<S: Into<String>>(members: impl IntoIterator<Item = S>) -> Self {
+        Value::SS(members.into_iter().map(Into::into).collect())
+    }
+
+    pub fn number_set<N: ToString>(members: impl IntoIterator<Item = N>) -> Self {
+        Value::NS(members.into_iter().map(|n| n.to_string()).collect())
+    }
+
+    pub fn binary_set<B: Into<Bytes>>(members: impl IntoIterator<Item = B>) -> Self {
+        Value::BS(members.into_iter().map(Into::into).collect())
+    }
+
+    pub fn as_string_set(&self) -> Option<&BTreeSet<String>> {
+        match self {
+            Value::SS(set) => Some(set),
+            _ => None,
+        }
+    }
+
+    pub fn as_number_set(&self) -> Option<&BTreeSet<String>> {
+        match self {
+            Value::NS(set) => Some(set),
+            _ => None,
+        }
+    }
+
+    pub fn as_binary_set(&self) -> Option<&BTreeSet<Bytes>> {
+        match self {
+            Value::BS(set) => Some(set),
+            _ => None,
+        }
+    }
+
+    /// Accounted size of this value in bytes
+    ///
+    /// - S, N: UTF-8 length of the string representation
+    /// - B: byte length
+    /// - Bool, Null: 1
+    /// - Ts: 8
+    /// - VecF32: 4 per element
+    /// - L: 3 + sum of (1 + element size)
+    /// - SS, NS, BS: 3 + sum of (1 + member length)
+    /// - M: 3 + sum of (1 + name length + value size)
+    pub fn size(&self) -> usize {
+        match self {
+            Value::S(s) | Value::N(s) => s.len(),
+            Value::B(b) => b.len(),
+            Value::Bool(_) | Value::Null => 1,
+            Value::Ts(_) => 8,
+            Value::VecF32(v) => v.len() * 4,
+            Value::L(list) => 3 + list.iter().map(|v| 1 + v.size()).sum::<usize>(),
+            Value::SS(set) | Value::NS(set) => 3 + set.iter().map(|s| 1 + s.len()).sum::<usize>(),
+            Value::BS(set) => 3 + set.iter().map(|b| 1 + b.len()).sum::<usize>(),
+            Value::M(map) => 3 + map.iter().map(|(k, v)| 1 + k.len() + v.size()).sum::<usize>(),
+        }
+    }
 }
 
 /// Item - a map of attribute names to values
 pub type Item = HashMap<String, Value>;
 
+/// Accounted size of an item in bytes: sum of attribute name length plus
+/// value size (see `Value::size`) over all attributes.
+///
+/// This is the size checked against `DatabaseConfig::max_item_size_bytes`.
+pub fn item_size(item: &Item) -> usize {
+    item.iter().map(|(name, value)| name.len() + value.size()).sum()
+}
+
+/// Item count and size of one partition
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct PartitionStat {
+    /// Partition key
+    pub pk: Bytes,
+    /// Number of live items
+    pub items: u64,
+    /// Total accounted size of the items in bytes (see `item_size`)
+    pub bytes: u64,
+}
+
+/// Result of checking a database's files against their checksums
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct IntegrityReport {
+    /// SST files read back and checked
+    pub sst_files_checked: usize,
+    /// WAL records read back and checked
+    pub wal_records_checked: usize,
+    /// Everything found wrong, one entry per damaged file
+    pub problems: Vec<IntegrityProblem>,
+}
+
+impl IntegrityReport {
+    /// Whether no damage was found
+    pub fn is_clean(&self) -> bool {
+        self.problems.is_empty()
+    }
+}
+
+/// A damaged file found by an integrity check
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct IntegrityProblem {
+    /// The damaged file
+    pub path: PathBuf,
+    /// What is wrong with it
+    pub detail: String,
+}
+
 /// Composite key: partition key + optional sort key
 #[derive(Debug, Clone, PartialEq, Eq, Hash, Serialize, Deserialize, PartialOrd, Ord)]
 pub struct Key {
@@ -178,6 +303,34 @@ pub mod checksum {
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_item_size_scalars() {
+        let mut item = Item::new();
+        item.insert("name".to_string(), Value::string("Alice")); // 4 + 5
+        item.insert("age".to_string(), Value::number(30)); // 3 + 2
+        item.insert("ok".to_string(), Value::Bool(true)); // 2 + 1
+        item.insert("raw".to_string(), Value::binary(vec![0u8; 10])); // 3 + 10
+        item.insert("ts".to_string(), Value::Ts(0)); // 2 + 8
+        item.insert("v".to_string(), Value::vector(vec![0.0; 3])); // 1 + 12
+
+        assert_eq!(item_size(&item), 9 + 5 + 3 + 13 + 10 + 13);
+    }
+
+    #[test]
+    fn test_item_size_nested() {
+        let mut inner = HashMap::new();
+        inner.insert("city".to_string(), Value::string("Paris")); // 1 + 4 + 5
+
+        let mut item = Item::new();
+        // 4 + (3 + (1 + 1) + (1 + 2))
+        item.insert("tags".to_string(), Value::L(vec![Value::string("a"), Value::string("bc")]));
+        // 4 + (3 + 10)
+        item.insert("addr".to_string(), Value::M(inner));
+
+        assert_eq!(item_size(&item), 4 + 8 + 4 + 13);
+        assert_eq!(item_size(&Item::new()), 0);
+    }
+
     #[test]
     fn test_key_encode() {
         let key = Key::new(b"user#123".to_vec());
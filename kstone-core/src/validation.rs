@@ -17,6 +17,9 @@ pub enum AttributeType {
     Map,
     Vector,      // VecF32
     Timestamp,   // Ts
+    StringSet,   // SS
+    NumberSet,   // NS
+    BinarySet,   // BS
 }
 
 impl AttributeType {
@@ -31,6 +34,9 @@ impl AttributeType {
             (AttributeType::Map, Value::M(_)) => true,
             (AttributeType::Vector, Value::VecF32(_)) => true,
             (AttributeType::Timestamp, Value::Ts(_)) => true,
+            (AttributeType::StringSet, Value::SS(_)) => true,
+            (AttributeType::NumberSet, Value::NS(_)) => true,
+            (AttributeType::BinarySet, Value::BS(_)) => true,
             _ => false,
         }
     }
@@ -43,9 +49,9 @@ pub enum ValueConstraint {
     MinValue(String),
     /// Maximum value (for numbers)
     MaxValue(String),
-    /// Minimum length (for strings, lists)
+    /// Minimum length (for strings, lists, sets)
     MinLength(usize),
-    /// Maximum length (for strings, lists)
+    /// Maximum length (for strings, lists, sets)
     MaxLength(usize),
     /// Must match regex pattern (for strings)
     Pattern(String),
@@ -93,6 +99,8 @@ impl ValueConstraint {
                 let len = match value {
                     Value::S(s) => s.len(),
                     Value::L(l) => l.len(),
+                    Value::SS(set) | Value::NS(set) => set.len(),
+                    Value::BS(set) => set.len(),
                     _ => return Ok(()),
                 };
                 if len < *min {
@@ -106,6 +114,8 @@ impl ValueConstraint {
                 let len = match value {
                     Value::S(s) => s.len(),
                     Value::L(l) => l.len(),
+                    Value::SS(set) | Value::NS(set) => set.len(),
+                    Value::BS(set) => set.len(),
                     _ => return Ok(()),
                 };
                 if len > *max {
@@ -185,28 +195,35 @@ impl AttributeSchema {
         self
     }
 
+    /// Build a schema validation error for this attribute
+    fn violation(&self, reason: String) -> Error {
+        Error::SchemaValidation {
+            attribute: self.name.clone(),
+            reason,
+        }
+    }
+
     /// Validate a value against this schema
     pub fn validate(&self, value: Option<&Value>) -> Result<()> {
         match value {
             None => {
                 if self.required {
-                    return Err(Error::InvalidArgument(
-                        format!("Required attribute '{}' is missing", self.name)
-                    ));
+                    return Err(self.violation("required attribute is missing".to_string()));
                 }
                 Ok(())
             }
             Some(val) => {
                 // Check type
                 if !self.attr_type.matches(val) {
-                    return Err(Error::InvalidArgument(
-                        format!("Attribute '{}' has wrong type (expected {:?})", self.name, self.attr_type)
-                    ));
+                    return Err(self.violation(format!("wrong type (expected {:?})", self.attr_type)));
                 }
 
                 // Check constraints
                 for constraint in &self.constraints {
-                    constraint.validate(val)?;
+                    constraint.validate(val).map_err(|e| match e {
+                        Error::InvalidArgument(reason) => self.violation(reason),
+                        other => other,
+                    })?;
                 }
 
                 Ok(())
@@ -215,6 +232,32 @@ impl AttributeSchema {
     }
 }
 
+/// Check that a set of attribute schemas is well-formed
+///
+/// Rejects empty or duplicate attribute names and invalid regex patterns.
+pub fn check_schemas(schemas: &[AttributeSchema]) -> Result<()> {
+    let mut seen = std::collections::HashSet::new();
+    for schema in schemas {
+        if schema.name.is_empty() {
+            return Err(Error::InvalidArgument("Attribute schema name cannot be empty".into()));
+        }
+        if !seen.insert(schema.name.as_str()) {
+            return Err(Error::InvalidArgument(format!(
+                "Duplicate attribute schema for '{}'",
+                schema.name
+            )));
+        }
+        for constraint in &schema.constraints {
+            if let ValueConstraint::Pattern(pattern) = constraint {
+                regex::Regex::new(pattern).map_err(|e| {
+                    Error::InvalidArgument(format!("Invalid regex pattern for '{}': {}", schema.name, e))
+                })?;
+            }
+        }
+    }
+    Ok(())
+}
+
 /// Validator for items based on a schema
 #[derive(Debug, Clone)]
 pub struct Validator {
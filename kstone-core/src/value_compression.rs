@@ -0,0 +1,163 @@
+/// Per-attribute compression of large string and binary values
+///
+/// When `DatabaseConfig::value_compression_threshold` is set, top-level
+/// string and binary attributes at least that many bytes long are zstd
+/// compressed as records are written to the WAL and SSTs. A compressed value
+/// is stored as a binary value that starts with `MARKER`, followed by a tag
+/// for the original type and the compressed bytes.
+///
+/// Records are decompressed as they are read back from disk, so the memtable
+/// and every read path only ever see the original values.
+
+use crate::{Error, Item, Record, Result, Value};
+use bytes::Bytes;
+use std::borrow::Cow;
+
+/// Prefix of a compressed value
+const MARKER: &[u8] = b"\xffKSZV\x01";
+
+/// Original type of a compressed value
+const TAG_STRING: u8 = 0;
+const TAG_BINARY: u8 = 1;
+
+/// zstd level used for attribute values
+const COMPRESSION_LEVEL: i32 = 3;
+
+/// Compress the large attributes of a record for writing to disk
+///
+/// Returns the record unchanged when compression is off, the record is a
+/// tombstone, or nothing in it is worth compressing. Binary values that
+/// happen to start with the marker are always wrapped, so they can't be
+/// mistaken for compressed values on the way back.
+pub fn compress_record(record: &Record, threshold: Option<usize>) -> Result<Cow<'_, Record>> {
+    let (Some(threshold), Some(item)) = (threshold, &record.value) else {
+        return Ok(Cow::Borrowed(record));
+    };
+    if !item.values().any(|value| needs_wrapping(value, threshold)) {
+        return Ok(Cow::Borrowed(record));
+    }
+
+    let mut compressed = Item::with_capacity(item.len());
+    for (name, value) in item {
+        let stored = match value {
+            Value::S(s) if s.len() >= threshold => compress_value(TAG_STRING, s.as_bytes(), false)?,
+            Value::B(b) if b.len() >= threshold || b.starts_with(MARKER) => {
+                compress_value(TAG_BINARY, b, b.starts_with(MARKER))?
+            }
+            _ => None,
+        };
+        compressed.insert(name.clone(), stored.unwrap_or_else(|| value.clone()));
+    }
+
+    Ok(Cow::Owned(Record {
+        key: record.key.clone(),
+        value: Some(compressed),
+        seq: record.seq,
+    }))
+}
+
+/// Restore any compressed attributes of a record read from disk
+pub fn decompress_record(mut record: Record) -> Result<Record> {
+    if let Some(item) = &mut record.value {
+        for value in item.values_mut() {
+            if let Value::B(bytes) = value {
+                if bytes.starts_with(MARKER) {
+                    *value = decompress_value(bytes)?;
+                }
+            }
+        }
+    }
+    Ok(record)
+}
+
+fn needs_wrapping(value: &Value, threshold: usize) -> bool {
+    match value {
+        Value::S(s) => s.len() >= threshold,
+        Value::B(b) => b.len() >= threshold || b.starts_with(MARKER),
+        _ => false,
+    }
+}
+
+/// Compressed form of `data`, or None if compressing doesn't save space
+/// and `force` is not set
+fn compress_value(tag: u8, data: &[u8], force: bool) -> Result<Option<Value>> {
+    let compressed = zstd::bulk::compress(data, COMPRESSION_LEVEL)
+        .map_err(|e| Error::CompressionError(format!("Failed to compress value: {}", e)))?;
+    if !force && MARKER.len() + 1 + compressed.len() >= data.len() {
+        return Ok(None);
+    }
+
+    let mut stored = Vec::with_capacity(MARKER.len() + 1 + compressed.len());
+    stored.extend_from_slice(MARKER);
+    stored.push(tag);
+    stored.extend_from_slice(&compressed);
+    Ok(Some(Value::B(Bytes::from(stored))))
+}
+
+fn decompress_value(stored: &[u8]) -> Result<Value> {
+    let (&tag, compressed) = stored[MARKER.len()..]
+        .split_first()
+        .ok_or_else(|| Error::Corruption("Truncated compressed value".to_string()))?;
+
+    let mut data = Vec::new();
+    zstd::stream::copy_decode(compressed, &mut data)
+        .map_err(|e| Error::CompressionError(format!("Failed to decompress value: {}", e)))?;
+
+    match tag {
+        TAG_STRING => String::from_utf8(data)
+            .map(Value::S)
+            .map_err(|e| Error::Corruption(format!("Compressed string is not UTF-8: {}", e))),
+        TAG_BINARY => Ok(Value::B(Bytes::from(data))),
+        other => Err(Error::Corruption(format!("Unknown compressed value tag {}", other))),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::Key;
+
+    fn record(item: Item) -> Record {
+        Record::put(Key::new(b"pk".to_vec()), item, 1)
+    }
+
+    #[test]
+    fn test_large_values_round_trip() {
+        let mut item = Item::new();
+        item.insert("text".to_string(), Value::S("a".repeat(10_000)));
+        item.insert("blob".to_string(), Value::B(Bytes::from(vec![7u8; 10_000])));
+        item.insert("small".to_string(), Value::S("tiny".to_string()));
+        let original = record(item);
+
+        let compressed = compress_record(&original, Some(1024)).unwrap().into_owned();
+        let stored = compressed.value.as_ref().unwrap();
+        assert!(matches!(&stored["text"], Value::B(b) if b.len() < 1_000));
+        assert!(matches!(&stored["blob"], Value::B(b) if b.len() < 1_000));
+        assert_eq!(stored["small"], Value::S("tiny".to_string()));
+
+        assert_eq!(decompress_record(compressed).unwrap().value, original.value);
+    }
+
+    #[test]
+    fn test_disabled_or_small_values_untouched() {
+        let mut item = Item::new();
+        item.insert("text".to_string(), Value::S("a".repeat(100)));
+        let original = record(item);
+
+        assert!(matches!(compress_record(&original, None).unwrap(), Cow::Borrowed(_)));
+        assert!(matches!(compress_record(&original, Some(1024)).unwrap(), Cow::Borrowed(_)));
+    }
+
+    #[test]
+    fn test_binary_starting_with_marker_is_escaped() {
+        let mut data = MARKER.to_vec();
+        data.extend_from_slice(b"not actually compressed");
+        let mut item = Item::new();
+        item.insert("blob".to_string(), Value::B(Bytes::from(data)));
+        let original = record(item);
+
+        let compressed = compress_record(&original, Some(1024)).unwrap().into_owned();
+        assert_ne!(compressed.value, original.value);
+        assert_eq!(decompress_record(compressed).unwrap().value, original.value);
+    }
+}
@@ -1,26 +1,48 @@
 use crate::{Error, Result, Record, Lsn};
+use crate::value_compression::{compress_record, decompress_record};
 use bytes::{BytesMut, BufMut};
-use parking_lot::Mutex;
+use parking_lot::{Condvar, Mutex, MutexGuard};
 use std::fs::{File, OpenOptions};
 use std::io::{Read, Write, Seek, SeekFrom};
 use std::path::Path;
 use std::sync::Arc;
+use std::time::Duration;
 
 const WAL_HEADER_SIZE: usize = 16;
 const WAL_MAGIC: u32 = 0x57414C00; // "WAL\0"
 const RECORD_HEADER_SIZE: usize = 12; // lsn(8) + len(4)
 
+/// Format version written to new WAL headers
+pub const WAL_FORMAT_VERSION: u32 = 1;
+
 /// Minimal WAL for walking skeleton
 /// Format: [magic(4) | version(4) | reserved(8)] [record...]
 /// Record: [lsn(8) | len(4) | data | crc(4)]
+///
+/// Clones share the same file.
+#[derive(Clone)]
 pub struct Wal {
     inner: Arc<Mutex<WalInner>>,
+    synced: Arc<Condvar>,
 }
 
 struct WalInner {
     file: File,
     next_lsn: Lsn,
     pending: Vec<Record>,
+    /// Highest LSN known to be on disk
+    durable_lsn: Lsn,
+    /// Whether a group commit leader is collecting writes
+    committing: bool,
+    /// Compress attribute values at least this long (see `value_compression`)
+    value_compression_threshold: Option<usize>,
+    /// Format version from the file header
+    format_version: u32,
+    /// Why a write or sync failed; nothing more is written after that,
+    /// since what reached the disk is unknown
+    failure: Option<String>,
+    /// Fail the next sync (fault injection for tests)
+    fail_next_sync: bool,
 }
 
 impl Wal {
@@ -35,7 +57,7 @@ impl Wal {
         // Write header (big-endian for magic, rest doesn't matter)
         let mut header = BytesMut::with_capacity(WAL_HEADER_SIZE);
         header.put_u32(WAL_MAGIC); // big-endian for magic
-        header.put_u32_le(1); // version
+        header.put_u32_le(WAL_FORMAT_VERSION);
         header.put_u64_le(0); // reserved
         file.write_all(&header)?;
         file.sync_all()?;
@@ -45,7 +67,14 @@ impl Wal {
                 file,
                 next_lsn: 1,
                 pending: Vec::new(),
+                durable_lsn: 0,
+                committing: false,
+                value_compression_threshold: None,
+                format_version: WAL_FORMAT_VERSION,
+                failure: None,
+                fail_next_sync: false,
             })),
+            synced: Arc::new(Condvar::new()),
         })
     }
 
@@ -63,6 +92,7 @@ impl Wal {
         if magic != WAL_MAGIC {
             return Err(Error::Corruption("Invalid WAL magic".to_string()));
         }
+        let format_version = u32::from_le_bytes([header[4], header[5], header[6], header[7]]);
 
         // Scan to find last LSN
         file.seek(SeekFrom::Start(WAL_HEADER_SIZE as u64))?;
@@ -95,10 +125,27 @@ impl Wal {
                 file,
                 next_lsn: max_lsn + 1,
                 pending: Vec::new(),
+                durable_lsn: max_lsn,
+                committing: false,
+                value_compression_threshold: None,
+                format_version,
+                failure: None,
+                fail_next_sync: false,
             })),
+            synced: Arc::new(Condvar::new()),
         })
     }
 
+    /// Format version recorded in the file header
+    pub fn format_version(&self) -> u32 {
+        self.inner.lock().format_version
+    }
+
+    /// Compress large attribute values of records appended from now on
+    pub fn set_value_compression_threshold(&self, threshold: Option<usize>) {
+        self.inner.lock().value_compression_threshold = threshold;
+    }
+
     /// Append a record (buffered, not yet durable)
     pub fn append(&self, record: Record) -> Result<Lsn> {
         let mut inner = self.inner.lock();
@@ -111,21 +158,74 @@ impl Wal {
     /// Flush pending records to disk (group commit)
     pub fn flush(&self) -> Result<()> {
         let mut inner = self.inner.lock();
+        Self::write_pending(&mut inner)?;
+        self.synced.notify_all();
+        Ok(())
+    }
+
+    /// Wait until the record at `lsn` is on disk, sharing one fsync with
+    /// other writers
+    ///
+    /// The first caller to find its record not yet durable becomes the
+    /// leader: it waits `window` for more records to be appended, then
+    /// writes and syncs everything pending. Callers whose records were
+    /// covered by that sync return without syncing themselves. A zero
+    /// window behaves like `flush`.
+    ///
+    /// If a write or sync fails, every record still pending fails with it
+    /// and the WAL accepts no further writes.
+    pub fn commit(&self, lsn: Lsn, window: Duration) -> Result<()> {
+        if window.is_zero() {
+            return self.flush();
+        }
+
+        let mut inner = self.inner.lock();
+        loop {
+            if inner.durable_lsn >= lsn {
+                return Ok(());
+            }
+            if !inner.committing {
+                inner.committing = true;
+                MutexGuard::unlocked(&mut inner, || std::thread::sleep(window));
+                let result = Self::write_pending(&mut inner);
+                inner.committing = false;
+                self.synced.notify_all();
+                return result;
+            }
+            self.synced.wait(&mut inner);
+        }
+    }
+
+    fn write_pending(inner: &mut WalInner) -> Result<()> {
+        if let Some(failure) = &inner.failure {
+            return Err(Error::Internal(format!("WAL failed earlier: {}", failure)));
+        }
         if inner.pending.is_empty() {
             return Ok(());
         }
 
+        let result = Self::write_records(inner);
+        if let Err(e) = &result {
+            inner.failure = Some(e.to_string());
+            inner.pending.clear();
+        }
+        result
+    }
+
+    fn write_records(inner: &mut WalInner) -> Result<()> {
         // Seek to end
         inner.file.seek(SeekFrom::End(0))?;
 
         // Prepare all records into a single buffer
         let mut full_buf = BytesMut::new();
         let base_lsn = inner.next_lsn - inner.pending.len() as u64;
+        let threshold = inner.value_compression_threshold;
 
         for (i, record) in inner.pending.iter().enumerate() {
             let lsn = base_lsn + i as u64;
 
-            let data = bincode::serialize(record)
+            let record = compress_record(record, threshold)?;
+            let data = bincode::serialize(record.as_ref())
                 .map_err(|e| Error::Internal(format!("Serialize error: {}", e)))?;
             let crc = crc32fast::hash(&data);
 
@@ -138,14 +238,30 @@ impl Wal {
         // Write all at once
         inner.file.write_all(&full_buf)?;
 
+        if std::mem::take(&mut inner.fail_next_sync) {
+            return Err(std::io::Error::new(std::io::ErrorKind::Other, "injected sync failure").into());
+        }
         inner.file.sync_all()?;
         inner.pending.clear();
+        inner.durable_lsn = inner.next_lsn - 1;
 
         Ok(())
     }
 
     /// Read all records from WAL
     pub fn read_all(&self) -> Result<Vec<(Lsn, Record)>> {
+        match self.read_intact()? {
+            (records, None) => Ok(records),
+            (_, Some(damage)) => Err(damage),
+        }
+    }
+
+    /// Read records up to the first damaged one
+    ///
+    /// Returns the records before the damage and the error the damaged
+    /// record raised, if any. Records past it are not read, since their
+    /// boundaries can't be trusted.
+    pub fn read_intact(&self) -> Result<(Vec<(Lsn, Record)>, Option<Error>)> {
         let inner = self.inner.lock();
         let mut file = inner.file.try_clone()?;
         drop(inner);
@@ -165,34 +281,59 @@ impl Wal {
                         rec_header[8], rec_header[9], rec_header[10], rec_header[11],
                     ]) as usize;
 
-                    let mut data = vec![0u8; len];
-                    file.read_exact(&mut data)?;
-
-                    let mut crc_bytes = [0u8; 4];
-                    file.read_exact(&mut crc_bytes)?;
-                    let expected_crc = u32::from_le_bytes(crc_bytes);
-                    let actual_crc = crc32fast::hash(&data);
-
-                    if expected_crc != actual_crc {
-                        return Err(Error::ChecksumMismatch);
+                    match Self::read_record(&mut file, len) {
+                        Ok(record) => records.push((lsn, record)),
+                        Err(damage) => return Ok((records, Some(damage))),
                     }
-
-                    let record: Record = bincode::deserialize(&data)
-                        .map_err(|e| Error::Corruption(format!("Deserialize error: {}", e)))?;
-
-                    records.push((lsn, record));
                 }
                 Err(e) if e.kind() == std::io::ErrorKind::UnexpectedEof => break,
                 Err(e) => return Err(e.into()),
             }
         }
 
-        Ok(records)
+        Ok((records, None))
+    }
+
+    /// Read the data and checksum of a record whose header has been read
+    fn read_record(file: &mut File, len: usize) -> Result<Record> {
+        // A damaged length must not turn into a huge allocation
+        let end = file.stream_position()? + len as u64 + 4;
+        if end > file.metadata()?.len() {
+            return Err(Error::Corruption("WAL record runs past the end of the file".to_string()));
+        }
+
+        let mut data = vec![0u8; len];
+        file.read_exact(&mut data)?;
+
+        let mut crc_bytes = [0u8; 4];
+        file.read_exact(&mut crc_bytes)?;
+        let expected_crc = u32::from_le_bytes(crc_bytes);
+        let actual_crc = crc32fast::hash(&data);
+
+        if expected_crc != actual_crc {
+            return Err(Error::ChecksumMismatch);
+        }
+
+        let record: Record = bincode::deserialize(&data)
+            .map_err(|e| Error::Corruption(format!("Deserialize error: {}", e)))?;
+
+        decompress_record(record)
     }
 
     pub fn next_lsn(&self) -> Lsn {
         self.inner.lock().next_lsn
     }
+
+    /// Highest LSN known to be on disk
+    pub fn durable_lsn(&self) -> Lsn {
+        self.inner.lock().durable_lsn
+    }
+
+    /// Make the next sync fail, as a failing disk would
+    #[cfg(test)]
+    pub(crate) fn fail_next_sync(&self) {
+        self.inner.lock().fail_next_sync = true;
+    }
 }
 
 #[cfg(test)]
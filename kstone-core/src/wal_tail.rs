@@ -0,0 +1,207 @@
+/// Tailing committed writes (embedded change feed)
+///
+/// Every committed base-table write is published to a bounded in-memory
+/// history after it is durable in the WAL. A `WalTail` reads that history
+/// from a starting sequence number and then follows new writes as they are
+/// applied. Writers never wait for tails: a tail that falls behind by more
+/// than the history capacity receives a `Gap` event and resumes at the
+/// oldest record still retained.
+
+use crate::{Record, SeqNo};
+use parking_lot::{Condvar, Mutex};
+use std::collections::VecDeque;
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+/// Number of recent writes retained for tails
+pub const DEFAULT_WAL_TAIL_CAPACITY: usize = 10_000;
+
+/// An event delivered to a WAL tail
+#[derive(Debug, Clone)]
+pub enum WalTailEvent {
+    /// A committed write (tombstone if `value` is None)
+    Record(Record),
+    /// Writes with sequence numbers in `from_seq..resume_seq` are no longer
+    /// retained and were skipped
+    Gap { from_seq: SeqNo, resume_seq: SeqNo },
+}
+
+struct History {
+    records: VecDeque<Record>,
+    capacity: usize,
+    /// Highest sequence number evicted from the history (0 if none)
+    evicted_through: SeqNo,
+}
+
+/// Shared history of recent writes
+pub(crate) struct WalTailHub {
+    history: Mutex<History>,
+    appended: Condvar,
+}
+
+impl WalTailHub {
+    pub(crate) fn new(capacity: usize) -> Arc<Self> {
+        Arc::new(Self {
+            history: Mutex::new(History {
+                records: VecDeque::new(),
+                capacity: capacity.max(1),
+                evicted_through: 0,
+            }),
+            appended: Condvar::new(),
+        })
+    }
+
+    /// Publish a committed write; records must arrive in sequence order
+    pub(crate) fn publish(&self, record: &Record) {
+        let mut history = self.history.lock();
+        history.records.push_back(record.clone());
+        while history.records.len() > history.capacity {
+            if let Some(evicted) = history.records.pop_front() {
+                history.evicted_through = evicted.seq;
+            }
+        }
+        drop(history);
+        self.appended.notify_all();
+    }
+
+    /// Treat writes up to `seq` as not retained, e.g. ones that never went
+    /// through the WAL
+    pub(crate) fn skip_through(&self, seq: SeqNo) {
+        let mut history = self.history.lock();
+        history.evicted_through = history.evicted_through.max(seq);
+    }
+
+    /// Start tailing at the first write with sequence number >= `from_seq`
+    pub(crate) fn subscribe(self: &Arc<Self>, from_seq: SeqNo) -> WalTail {
+        WalTail {
+            hub: Arc::clone(self),
+            next_seq: from_seq,
+        }
+    }
+}
+
+/// A reader following committed writes in sequence order
+///
+/// Iterating blocks until the next write arrives; use `try_next` or
+/// `next_timeout` to poll instead.
+pub struct WalTail {
+    hub: Arc<WalTailHub>,
+    next_seq: SeqNo,
+}
+
+impl WalTail {
+    /// Sequence number the tail will deliver next (or the first after it)
+    pub fn position(&self) -> SeqNo {
+        self.next_seq
+    }
+
+    /// Next event if one is available, without blocking
+    pub fn try_next(&mut self) -> Option<WalTailEvent> {
+        let history = self.hub.history.lock();
+        self.take(&history)
+    }
+
+    /// Next event, waiting up to `timeout` for one to arrive
+    pub fn next_timeout(&mut self, timeout: Duration) -> Option<WalTailEvent> {
+        let deadline = Instant::now() + timeout;
+        let mut history = self.hub.history.lock();
+        loop {
+            if let Some(event) = self.take(&history) {
+                return Some(event);
+            }
+            if self.hub.appended.wait_until(&mut history, deadline).timed_out() {
+                return self.take(&history);
+            }
+        }
+    }
+
+    fn take(&mut self, history: &History) -> Option<WalTailEvent> {
+        if self.next_seq <= history.evicted_through {
+            let from_seq = self.next_seq;
+            let resume_seq = history
+                .records
+                .front()
+                .map(|r| r.seq)
+                .unwrap_or(history.evicted_through + 1);
+            self.next_seq = resume_seq;
+            return Some(WalTailEvent::Gap { from_seq, resume_seq });
+        }
+
+        // Sequence numbers are increasing, so binary search for the cursor
+        let start = history.records.partition_point(|r| r.seq < self.next_seq);
+        let record = history.records.get(start)?.clone();
+        self.next_seq = record.seq + 1;
+        Some(WalTailEvent::Record(record))
+    }
+}
+
+impl Iterator for WalTail {
+    type Item = WalTailEvent;
+
+    /// Block until the next event; never returns None
+    fn next(&mut self) -> Option<WalTailEvent> {
+        let mut history = self.hub.history.lock();
+        loop {
+            if let Some(event) = self.take(&history) {
+                return Some(event);
+            }
+            self.hub.appended.wait(&mut history);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::{Item, Key};
+    use bytes::Bytes;
+
+    fn record(seq: SeqNo) -> Record {
+        Record::put(Key::new(Bytes::from(format!("k{}", seq))), Item::new(), seq)
+    }
+
+    fn seq_of(event: Option<WalTailEvent>) -> SeqNo {
+        match event {
+            Some(WalTailEvent::Record(r)) => r.seq,
+            other => panic!("expected record, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_tail_follows_new_records() {
+        let hub = WalTailHub::new(10);
+        hub.publish(&record(1));
+
+        let mut tail = hub.subscribe(0);
+        assert_eq!(seq_of(tail.try_next()), 1);
+        assert!(tail.try_next().is_none());
+
+        let publisher = Arc::clone(&hub);
+        let handle = std::thread::spawn(move || {
+            std::thread::sleep(Duration::from_millis(20));
+            publisher.publish(&record(2));
+        });
+        assert_eq!(seq_of(tail.next()), 2);
+        handle.join().unwrap();
+    }
+
+    #[test]
+    fn test_gap_reported_when_history_truncated() {
+        let hub = WalTailHub::new(2);
+        for seq in 1..=4 {
+            hub.publish(&record(seq));
+        }
+
+        let mut tail = hub.subscribe(1);
+        match tail.try_next() {
+            Some(WalTailEvent::Gap { from_seq, resume_seq }) => {
+                assert_eq!(from_seq, 1);
+                assert_eq!(resume_seq, 3);
+            }
+            other => panic!("expected gap, got {:?}", other),
+        }
+        assert_eq!(seq_of(tail.try_next()), 3);
+        assert_eq!(seq_of(tail.next_timeout(Duration::from_millis(1))), 4);
+        assert!(tail.next_timeout(Duration::from_millis(1)).is_none());
+    }
+}
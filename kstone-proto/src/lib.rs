@@ -9,3 +9,9 @@ pub mod keystone {
 
 // Re-export commonly used types
 pub use keystone::*;
+
+/// Status metadata carrying the size of an item rejected as too large
+pub const ITEM_SIZE_METADATA: &str = "x-kstone-item-size";
+
+/// Status metadata carrying the item size limit that was exceeded
+pub const ITEM_SIZE_LIMIT_METADATA: &str = "x-kstone-item-size-limit";
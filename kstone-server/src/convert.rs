@@ -44,6 +44,11 @@ pub fn proto_value_to_ks(value: proto::Value) -> Result<KsValue, Status> {
         }
         ProtoValueEnum::VectorValue(vec) => Ok(KsValue::VecF32(vec.values)),
         ProtoValueEnum::TimestampValue(ts) => Ok(KsValue::Ts(ts as i64)),
+        ProtoValueEnum::StringSetValue(set) => Ok(KsValue::SS(set.values.into_iter().collect())),
+        ProtoValueEnum::NumberSetValue(set) => Ok(KsValue::NS(set.values.into_iter().collect())),
+        ProtoValueEnum::BinarySetValue(set) => {
+            Ok(KsValue::BS(set.values.into_iter().map(Bytes::from).collect()))
+        }
     }
 }
 
@@ -70,6 +75,15 @@ pub fn ks_value_to_proto(value: &KsValue) -> proto::Value {
             values: vec.clone(),
         }),
         KsValue::Ts(ts) => ProtoValueEnum::TimestampValue(*ts as u64),
+        KsValue::SS(set) => ProtoValueEnum::StringSetValue(proto::StringSetValue {
+            values: set.iter().cloned().collect(),
+        }),
+        KsValue::NS(set) => ProtoValueEnum::NumberSetValue(proto::StringSetValue {
+            values: set.iter().cloned().collect(),
+        }),
+        KsValue::BS(set) => ProtoValueEnum::BinarySetValue(proto::BinarySetValue {
+            values: set.iter().map(|b| b.to_vec()).collect(),
+        }),
     };
 
     proto::Value {
@@ -146,6 +160,15 @@ pub fn proto_last_key_to_ks(key: proto::LastKey) -> (Bytes, Option<Bytes>) {
     (pk, sk)
 }
 
+/// Convert protobuf LastKey to kstone_core Key
+pub fn proto_last_key_to_core_key(key: proto::LastKey) -> Key {
+    let (pk, sk) = proto_last_key_to_ks(key);
+    match sk {
+        Some(sk) => Key::with_sk(pk, sk),
+        None => Key::new(pk),
+    }
+}
+
 /// Convert (partition_key, optional sort_key) to protobuf LastKey
 pub fn ks_last_key_to_proto(
     pk: impl Into<Vec<u8>>,
@@ -166,6 +189,74 @@ pub fn ks_last_key_opt_to_proto(last_key: Option<(Bytes, Option<Bytes>)>) -> Opt
     last_key.map(|(pk, sk)| ks_last_key_to_proto(pk, sk))
 }
 
+// ============================================================================
+// Export Conversions
+// ============================================================================
+
+/// Convert an exported item and its key to protobuf
+pub fn export_entry_to_proto(key: &Key, item: &Item) -> proto::ExportEntry {
+    proto::ExportEntry {
+        partition_key: key.pk.to_vec(),
+        sort_key: key.sk.as_ref().map(|sk| sk.to_vec()),
+        item: Some(ks_item_to_proto(item)),
+    }
+}
+
+/// Checksum of an export chunk's entries (see `ExportChunk.checksum`)
+pub fn export_checksum(entries: &[proto::ExportEntry]) -> u32 {
+    let mut encoded = Vec::new();
+    for entry in entries {
+        prost::Message::encode(entry, &mut encoded).expect("Vec has unbounded capacity");
+    }
+    kstone_core::types::checksum::compute(&encoded)
+}
+
+// ============================================================================
+// Dry Run Conversions
+// ============================================================================
+
+/// Convert a dry-run outcome to protobuf
+pub fn dry_run_to_proto(result: &kstone_api::DryRunResponse) -> proto::DryRunResult {
+    proto::DryRunResult {
+        condition_passed: result.condition_passed,
+        item: result.item.as_ref().map(ks_item_to_proto),
+    }
+}
+
+/// Convert a protobuf Select (as carried in requests) to the API enum
+pub fn proto_select_to_ks(select: i32) -> kstone_api::Select {
+    match proto::Select::try_from(select) {
+        Ok(proto::Select::Count) => kstone_api::Select::Count,
+        _ => kstone_api::Select::AllAttributes,
+    }
+}
+
+/// Convert a query plan's access path to protobuf
+pub fn plan_operation_to_proto(operation: kstone_api::PlanOperation) -> proto::PlanOperation {
+    match operation {
+        kstone_api::PlanOperation::Query => proto::PlanOperation::Query,
+        kstone_api::PlanOperation::MultiQuery => proto::PlanOperation::MultiQuery,
+        kstone_api::PlanOperation::Scan => proto::PlanOperation::Scan,
+        kstone_api::PlanOperation::Write => proto::PlanOperation::Write,
+    }
+}
+
+/// Convert a transaction cancellation reason to protobuf
+pub fn cancellation_reason_to_proto(reason: &kstone_api::CancellationReason) -> proto::CancellationReason {
+    proto::CancellationReason {
+        code: reason.code.clone(),
+        message: reason.message.clone(),
+    }
+}
+
+/// Convert consumed capacity to protobuf
+pub fn consumed_capacity_to_proto(capacity: kstone_core::ConsumedCapacity) -> proto::ConsumedCapacity {
+    proto::ConsumedCapacity {
+        read_units: capacity.read_units,
+        write_units: capacity.write_units,
+    }
+}
+
 // ============================================================================
 // Tests
 // ============================================================================
@@ -254,6 +345,19 @@ mod tests {
         assert_eq!(ks_value, converted);
     }
 
+    #[test]
+    fn test_value_set_roundtrip() {
+        for ks_value in [
+            KsValue::string_set(["a", "b"]),
+            KsValue::number_set([1, 2]),
+            KsValue::binary_set([b"x".to_vec(), b"y".to_vec()]),
+        ] {
+            let proto_value = ks_value_to_proto(&ks_value);
+            let converted = proto_value_to_ks(proto_value).unwrap();
+            assert_eq!(ks_value, converted);
+        }
+    }
+
     #[test]
     fn test_item_roundtrip() {
         let mut item = HashMap::new();
@@ -0,0 +1,273 @@
+/// Idempotency tokens for transactional writes
+///
+/// A TransactWrite carrying a client request token is applied at most once
+/// per token within a time window. A retry with the same token and the same
+/// operations returns the original response without re-applying the writes.
+
+use kstone_proto as proto;
+use std::collections::HashMap;
+use std::time::{Duration, Instant};
+use prost::encoding::encode_varint;
+use prost::Message;
+use tokio::sync::Mutex;
+use tonic::Status;
+
+/// How long a token is remembered (matches DynamoDB's 10 minute window)
+pub const DEFAULT_IDEMPOTENCY_WINDOW: Duration = Duration::from_secs(10 * 60);
+
+/// Maximum client request token length
+pub const MAX_TOKEN_LEN: usize = 36;
+
+/// A transaction remembered by token
+struct Entry {
+    recorded_at: Instant,
+    /// Canonical encoding of the request items (see `fingerprint`), to
+    /// detect a token reused for different writes
+    request_fingerprint: Vec<u8>,
+    /// Response of the committed transaction, None while it is running
+    response: Option<proto::TransactWriteResponse>,
+}
+
+/// Cache of recently committed transaction tokens
+///
+/// The lock is only held to look a token up and to record its outcome, not
+/// while the transaction runs; a token is reserved in between, so a retry
+/// arriving while the original is still running is turned away instead of
+/// being applied a second time.
+pub struct IdempotencyCache {
+    window: Duration,
+    entries: Mutex<HashMap<String, Entry>>,
+}
+
+impl IdempotencyCache {
+    /// Create a cache that remembers tokens for `window`
+    pub fn new(window: Duration) -> Self {
+        Self {
+            window,
+            entries: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Look up `token` and reserve it if it is new
+    ///
+    /// Returns the previous response of a committed transaction with this
+    /// token, or None after reserving the token for the caller, who must
+    /// then call `finish`. Fails if the token was used for a different
+    /// request, or if a transaction with it is still running.
+    pub async fn begin(
+        &self,
+        token: &str,
+        request_fingerprint: &[u8],
+    ) -> Result<Option<proto::TransactWriteResponse>, Status> {
+        let mut entries = self.entries.lock().await;
+        let window = self.window;
+        entries.retain(|_, entry| entry.recorded_at.elapsed() < window);
+
+        match entries.get(token) {
+            Some(entry) if entry.request_fingerprint != request_fingerprint => {
+                Err(Status::invalid_argument(
+                    "Client request token was already used with different parameters",
+                ))
+            }
+            Some(Entry { response: Some(response), .. }) => Ok(Some(response.clone())),
+            Some(_) => Err(Status::aborted(
+                "A transaction with this client request token is in progress",
+            )),
+            None => {
+                entries.insert(
+                    token.to_string(),
+                    Entry {
+                        recorded_at: Instant::now(),
+                        request_fingerprint: request_fingerprint.to_vec(),
+                        response: None,
+                    },
+                );
+                Ok(None)
+            }
+        }
+    }
+
+    /// Record the outcome of the transaction reserved by `begin`
+    ///
+    /// A committed response is remembered for the window; otherwise the
+    /// reservation is dropped, since nothing was written and the
+    /// transaction may be retried with the same token.
+    pub async fn finish(&self, token: &str, committed: Option<proto::TransactWriteResponse>) {
+        let mut entries = self.entries.lock().await;
+        match committed {
+            Some(response) => {
+                if let Some(entry) = entries.get_mut(token) {
+                    entry.recorded_at = Instant::now();
+                    entry.response = Some(response);
+                }
+            }
+            None => {
+                entries.remove(token);
+            }
+        }
+    }
+}
+
+impl Default for IdempotencyCache {
+    fn default() -> Self {
+        Self::new(DEFAULT_IDEMPOTENCY_WINDOW)
+    }
+}
+
+/// Canonical encoding of a transaction's items
+///
+/// Item attributes and map values decode into `HashMap`s, whose encoding
+/// order differs from one request to the next, so they are encoded here
+/// sorted by name. A retry of the same operations then always gets the
+/// same fingerprint.
+pub fn fingerprint(items: &[proto::TransactWriteItem]) -> Vec<u8> {
+    use proto::transact_write_item::Item as TxItem;
+
+    let mut out = Vec::new();
+    for item in items {
+        let mut item = item.clone();
+        let attributes = match &mut item.item {
+            Some(TxItem::Put(put)) => put.item.take().map(|item| item.attributes),
+            _ => None,
+        };
+        out.extend(item.encode_length_delimited_to_vec());
+        match attributes {
+            Some(attributes) => {
+                out.push(1);
+                encode_map(&attributes, &mut out);
+            }
+            None => out.push(0),
+        }
+    }
+    out
+}
+
+fn encode_map(map: &HashMap<String, proto::Value>, out: &mut Vec<u8>) {
+    let mut entries: Vec<_> = map.iter().collect();
+    entries.sort_by(|a, b| a.0.cmp(b.0));
+    encode_varint(entries.len() as u64, out);
+    for (name, value) in entries {
+        encode_varint(name.len() as u64, out);
+        out.extend_from_slice(name.as_bytes());
+        encode_value(value, out);
+    }
+}
+
+fn encode_value(value: &proto::Value, out: &mut Vec<u8>) {
+    use proto::value::Value as V;
+
+    match &value.value {
+        Some(V::MapValue(map)) => {
+            out.push(1);
+            encode_map(&map.fields, out);
+        }
+        Some(V::ListValue(list)) => {
+            out.push(2);
+            encode_varint(list.items.len() as u64, out);
+            for item in &list.items {
+                encode_value(item, out);
+            }
+        }
+        _ => {
+            out.push(0);
+            out.extend(value.encode_length_delimited_to_vec());
+        }
+    }
+}
+
+/// Validate a client request token
+pub fn validate_token(token: &str) -> Result<(), Status> {
+    if token.is_empty() || token.len() > MAX_TOKEN_LEN {
+        return Err(Status::invalid_argument(format!(
+            "Client request token must be 1 to {} characters",
+            MAX_TOKEN_LEN
+        )));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn committed() -> proto::TransactWriteResponse {
+        proto::TransactWriteResponse {
+            success: true,
+            error: None,
+            dry_run_results: Vec::new(),
+            cancellation_reasons: Vec::new(),
+        }
+    }
+
+    #[tokio::test]
+    async fn test_begin_after_finish() {
+        let cache = IdempotencyCache::default();
+
+        assert!(cache.begin("t1", b"req").await.unwrap().is_none());
+        cache.finish("t1", Some(committed())).await;
+
+        assert!(cache.begin("t1", b"req").await.unwrap().unwrap().success);
+        assert!(cache.begin("t1", b"other").await.is_err());
+    }
+
+    #[tokio::test]
+    async fn test_running_token_is_turned_away_and_failures_are_forgotten() {
+        let cache = IdempotencyCache::default();
+
+        assert!(cache.begin("t1", b"req").await.unwrap().is_none());
+        let err = cache.begin("t1", b"req").await.unwrap_err();
+        assert_eq!(err.code(), tonic::Code::Aborted);
+
+        cache.finish("t1", None).await;
+        assert!(cache.begin("t1", b"req").await.unwrap().is_none());
+    }
+
+    #[tokio::test]
+    async fn test_tokens_expire_after_window() {
+        let cache = IdempotencyCache::new(Duration::from_millis(10));
+        cache.begin("t1", b"req").await.unwrap();
+        cache.finish("t1", Some(committed())).await;
+
+        tokio::time::sleep(Duration::from_millis(20)).await;
+        assert!(cache.begin("t1", b"req").await.unwrap().is_none());
+    }
+
+    #[test]
+    fn test_fingerprint_ignores_attribute_order() {
+        let value = |n: &str| proto::Value {
+            value: Some(proto::value::Value::NumberValue(n.to_string())),
+        };
+        let put = |names: &[&str]| {
+            let mut attributes = HashMap::new();
+            for (i, name) in names.iter().enumerate() {
+                attributes.insert(name.to_string(), value(&i.to_string()));
+            }
+            proto::TransactWriteItem {
+                item: Some(proto::transact_write_item::Item::Put(proto::TransactPut {
+                    partition_key: b"doc#1".to_vec(),
+                    sort_key: None,
+                    item: Some(proto::Item { attributes }),
+                    condition_expression: None,
+                })),
+            }
+        };
+
+        // Build the same attributes in many insertion orders and capacities
+        let names: Vec<String> = (0..16).map(|i| format!("attr{}", i)).collect();
+        let names: Vec<&str> = names.iter().map(String::as_str).collect();
+        let expected = fingerprint(&[put(&names)]);
+        for _ in 0..8 {
+            assert_eq!(fingerprint(&[put(&names)]), expected);
+        }
+        let mut other = names.clone();
+        other.swap(0, 1);
+        assert_ne!(fingerprint(&[put(&other)]), expected);
+    }
+
+    #[test]
+    fn test_validate_token() {
+        assert!(validate_token("abc").is_ok());
+        assert!(validate_token("").is_err());
+        assert!(validate_token(&"x".repeat(MAX_TOKEN_LEN + 1)).is_err());
+    }
+}
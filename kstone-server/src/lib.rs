@@ -5,9 +5,12 @@
 
 pub mod connection;
 pub mod convert;
+pub mod idempotency;
+pub mod live;
 pub mod metrics;
 pub mod rate_limit;
 pub mod service;
+pub mod statements;
 
 // Re-export key types
 pub use connection::ConnectionManager;
@@ -0,0 +1,250 @@
+/// Live query dispatch
+///
+/// Open live queries share one dispatch thread instead of holding a
+/// blocking thread each. The thread sleeps until a write is committed (or
+/// the poll interval passes), then drains every query's WAL tail without
+/// blocking and pushes the matching items to that query's stream. A query
+/// whose client reads slowly keeps its place in the tail until its stream
+/// has room; one whose client went away is dropped. The thread exits when
+/// no live queries are left, and the number open at once is capped.
+
+use kstone_api::{Database, LiveQuery};
+use kstone_proto as proto;
+use futures::channel::mpsc::Sender;
+use std::sync::{Arc, Mutex};
+use std::time::Duration;
+use tonic::Status;
+
+use crate::convert::ks_item_to_proto;
+use crate::service::map_error;
+
+/// Live queries open at once by default
+pub const DEFAULT_MAX_LIVE_QUERIES: usize = 1024;
+
+/// How often the dispatch thread wakes without writes, to notice clients
+/// that went away
+const POLL_INTERVAL: Duration = Duration::from_millis(500);
+
+type LiveSender = Sender<Result<proto::QueryLiveResponse, Status>>;
+
+struct Registered {
+    live: LiveQuery,
+    sender: LiveSender,
+    /// A message the stream had no room for yet
+    held: Option<Result<proto::QueryLiveResponse, Status>>,
+}
+
+#[derive(Default)]
+struct State {
+    queries: Vec<Registered>,
+    /// Whether the dispatch thread is running
+    running: bool,
+}
+
+/// The open live queries of a server
+pub struct LiveQueries {
+    max: usize,
+    state: Arc<Mutex<State>>,
+}
+
+impl Default for LiveQueries {
+    fn default() -> Self {
+        Self::new(DEFAULT_MAX_LIVE_QUERIES)
+    }
+}
+
+impl LiveQueries {
+    /// Allow up to `max` live queries open at once
+    pub fn new(max: usize) -> Self {
+        Self {
+            max,
+            state: Arc::new(Mutex::new(State::default())),
+        }
+    }
+
+    /// Number of open live queries
+    pub fn len(&self) -> usize {
+        self.state.lock().unwrap().queries.len()
+    }
+
+    /// Whether no live queries are open
+    pub fn is_empty(&self) -> bool {
+        self.len() == 0
+    }
+
+    /// Fail with `resource_exhausted` if no more live queries may be opened
+    pub fn check_capacity(&self) -> Result<(), Status> {
+        if self.len() >= self.max {
+            return Err(self.exhausted());
+        }
+        Ok(())
+    }
+
+    /// Push the new items of `live` to `sender` until the client goes away
+    ///
+    /// The caller sends the initial items first. Fails with
+    /// `resource_exhausted` if the cap was reached in the meantime.
+    pub fn register(&self, db: &Arc<Database>, live: LiveQuery, sender: LiveSender) -> Result<(), Status> {
+        let mut state = self.state.lock().unwrap();
+        if state.queries.len() >= self.max {
+            return Err(self.exhausted());
+        }
+        state.queries.push(Registered { live, sender, held: None });
+
+        if !state.running {
+            let db = Arc::clone(db);
+            let shared = Arc::clone(&self.state);
+            let spawned = std::thread::Builder::new()
+                .name("live-queries".to_string())
+                .spawn(move || dispatch(db, shared));
+            if let Err(e) = spawned {
+                state.queries.pop();
+                return Err(Status::internal(format!("Failed to start live query thread: {}", e)));
+            }
+            state.running = true;
+        }
+        Ok(())
+    }
+
+    fn exhausted(&self) -> Status {
+        Status::resource_exhausted(format!("Too many live queries (limit {})", self.max))
+    }
+}
+
+/// Deliver new items to every open live query until none are left
+fn dispatch(db: Arc<Database>, state: Arc<Mutex<State>>) {
+    // Any committed write wakes the thread
+    let mut wake = match db.last_seq().and_then(|seq| db.tail_wal(seq + 1)) {
+        Ok(wake) => wake,
+        Err(e) => {
+            let status = map_error(e);
+            let mut state = state.lock().unwrap();
+            for mut query in state.queries.drain(..) {
+                let _ = query.sender.try_send(Err(status.clone()));
+            }
+            state.running = false;
+            return;
+        }
+    };
+
+    loop {
+        {
+            let mut state = state.lock().unwrap();
+            state.queries.retain_mut(deliver);
+            if state.queries.is_empty() {
+                state.running = false;
+                return;
+            }
+        }
+
+        // One pass covers every write so far, so skip past the rest
+        if wake.next_timeout(POLL_INTERVAL).is_some() {
+            while wake.try_next().is_some() {}
+        }
+    }
+}
+
+/// Push a query's new matching items until its stream is full; false once
+/// the query is over
+fn deliver(query: &mut Registered) -> bool {
+    loop {
+        let message = match query.held.take() {
+            Some(message) => message,
+            None => match query.live.next_timeout(Duration::ZERO) {
+                Ok(Some(item)) => Ok(proto::QueryLiveResponse {
+                    items: vec![ks_item_to_proto(&item)],
+                    initial: false,
+                }),
+                Ok(None) => return !query.sender.is_closed(),
+                Err(e) => Err(map_error(e)),
+            },
+        };
+
+        let last = message.is_err();
+        match query.sender.try_send(message) {
+            Ok(()) if last => return false,
+            Ok(()) => {}
+            Err(e) if e.is_full() => {
+                query.held = Some(e.into_inner());
+                return true;
+            }
+            Err(_) => return false,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use kstone_api::{ItemBuilder, Query};
+    use std::time::Instant;
+    use tempfile::TempDir;
+
+    type Receiver = futures::channel::mpsc::Receiver<Result<proto::QueryLiveResponse, Status>>;
+
+    fn open(db: &Arc<Database>) -> (LiveQuery, LiveSender, Receiver) {
+        let live = db.query_live(Query::new(b"log")).unwrap();
+        let (sender, receiver) = futures::channel::mpsc::channel(4);
+        (live, sender, receiver)
+    }
+
+    /// Wait up to five seconds for `condition`
+    fn eventually(mut condition: impl FnMut() -> bool) -> bool {
+        let deadline = Instant::now() + Duration::from_secs(5);
+        while Instant::now() < deadline {
+            if condition() {
+                return true;
+            }
+            std::thread::sleep(Duration::from_millis(10));
+        }
+        false
+    }
+
+    #[test]
+    fn test_items_are_pushed_by_the_shared_thread() {
+        let dir = TempDir::new().unwrap();
+        let db = Arc::new(Database::create(dir.path()).unwrap());
+        let queries = LiveQueries::new(4);
+
+        let mut receivers: Vec<Receiver> = (0..3)
+            .map(|_| {
+                let (live, sender, receiver) = open(&db);
+                queries.register(&db, live, sender).unwrap();
+                receiver
+            })
+            .collect();
+        db.put_with_sk(b"log", b"event#1", ItemBuilder::new().number("n", 1).build()).unwrap();
+
+        for receiver in &mut receivers {
+            let mut received = None;
+            assert!(eventually(|| {
+                received = receiver.try_next().ok().flatten();
+                received.is_some()
+            }));
+            let response = received.unwrap().unwrap();
+            assert!(!response.initial);
+            assert_eq!(response.items.len(), 1);
+        }
+    }
+
+    #[test]
+    fn test_live_queries_are_capped_and_freed_on_disconnect() {
+        let dir = TempDir::new().unwrap();
+        let db = Arc::new(Database::create(dir.path()).unwrap());
+        let queries = LiveQueries::new(1);
+
+        let (live, sender, receiver) = open(&db);
+        queries.register(&db, live, sender).unwrap();
+        let (live, sender, _receiver) = open(&db);
+        let refused = queries.register(&db, live, sender).unwrap_err();
+        assert_eq!(refused.code(), tonic::Code::ResourceExhausted);
+        assert_eq!(queries.check_capacity().unwrap_err().code(), tonic::Code::ResourceExhausted);
+
+        // The client going away frees its slot and stops the thread
+        drop(receiver);
+        assert!(eventually(|| queries.is_empty()));
+        assert!(!queries.state.lock().unwrap().running);
+        let (live, sender, _receiver) = open(&db);
+        queries.register(&db, live, sender).unwrap();
+    }
+}
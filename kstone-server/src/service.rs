@@ -13,17 +13,106 @@ use tracing::{error, info, instrument};
 use uuid::Uuid;
 
 use crate::convert::*;
+use crate::idempotency::{fingerprint, validate_token, IdempotencyCache};
+use crate::live::LiveQueries;
+use crate::statements::StatementCache;
 use crate::metrics::{RPC_REQUESTS_TOTAL, RPC_DURATION_SECONDS};
 
+/// Acks buffered per write stream before pushing back
+const WRITE_STREAM_BUFFER: usize = 256;
+
+/// Items per export chunk when the request doesn't say
+const DEFAULT_EXPORT_CHUNK_SIZE: usize = 500;
+
+/// Export chunks read ahead of a slow client
+const EXPORT_BUFFER: usize = 4;
+
 /// KeystoneDB gRPC service implementation
+///
+/// Clones share the database and caches.
+#[derive(Clone)]
 pub struct KeystoneService {
     db: Arc<Database>,
+    idempotency: Arc<IdempotencyCache>,
+    statements: Arc<StatementCache>,
+    live_queries: Arc<LiveQueries>,
 }
 
 impl KeystoneService {
     /// Create a new KeystoneService wrapping a Database
     pub fn new(db: Database) -> Self {
-        Self { db: Arc::new(db) }
+        Self {
+            db: Arc::new(db),
+            idempotency: Arc::new(IdempotencyCache::default()),
+            statements: Arc::new(StatementCache::default()),
+            live_queries: Arc::new(LiveQueries::default()),
+        }
+    }
+
+    /// Set how long transaction client request tokens are remembered
+    pub fn with_idempotency_window(mut self, window: std::time::Duration) -> Self {
+        self.idempotency = Arc::new(IdempotencyCache::new(window));
+        self
+    }
+
+    /// Set how many parsed PartiQL statements are kept for reuse
+    pub fn with_statement_cache_capacity(mut self, capacity: usize) -> Self {
+        self.statements = Arc::new(StatementCache::new(capacity));
+        self
+    }
+
+    /// Set how many live queries may be open at once
+    pub fn with_max_live_queries(mut self, max: usize) -> Self {
+        self.live_queries = Arc::new(LiveQueries::new(max));
+        self
+    }
+
+    /// Apply one write received over a write stream through the put or
+    /// delete handler, and ack it
+    async fn apply_stream_write(&self, write: proto::WriteStreamRequest) -> proto::WriteStreamAck {
+        use proto::write_request::Request as WriteRequestEnum;
+
+        let result = match write.write.and_then(|w| w.request) {
+            Some(WriteRequestEnum::Put(put)) => self
+                .put(Request::new(proto::PutRequest {
+                    partition_key: put.partition_key,
+                    sort_key: put.sort_key,
+                    item: put.item,
+                    condition_expression: put.condition_expression,
+                    expression_values: put.expression_values,
+                    expression_names: put.expression_names,
+                    dry_run: false,
+                    return_consumed_capacity: false,
+                }))
+                .await
+                .map(|_| ()),
+            Some(WriteRequestEnum::Delete(delete)) => self
+                .delete(Request::new(proto::DeleteRequest {
+                    partition_key: delete.partition_key,
+                    sort_key: delete.sort_key,
+                    condition_expression: None,
+                    expression_values: Default::default(),
+                    expression_names: Default::default(),
+                }))
+                .await
+                .map(|_| ()),
+            None => Err(Status::invalid_argument("Write request is required")),
+        };
+
+        match result {
+            Ok(()) => proto::WriteStreamAck {
+                id: write.id,
+                success: true,
+                error: None,
+                code: 0,
+            },
+            Err(status) => proto::WriteStreamAck {
+                id: write.id,
+                success: false,
+                error: Some(status.message().to_string()),
+                code: status.code() as i32,
+            },
+        }
     }
 }
 
@@ -32,7 +121,7 @@ impl KeystoneService {
 // ============================================================================
 
 /// Map KeystoneDB errors to gRPC Status
-fn map_error(err: KsError) -> Status {
+pub(crate) fn map_error(err: KsError) -> Status {
     match err {
         KsError::NotFound(msg) => Status::not_found(msg),
         KsError::InvalidQuery(msg) => Status::invalid_argument(msg),
@@ -52,6 +141,18 @@ fn map_error(err: KsError) -> Status {
         KsError::CompactionError(msg) => Status::internal(format!("Compaction error: {}", msg)),
         KsError::StripeError(msg) => Status::internal(format!("Stripe error: {}", msg)),
         KsError::ResourceExhausted(msg) => Status::resource_exhausted(format!("Resource exhausted: {}", msg)),
+        err @ KsError::SchemaValidation { .. } => Status::invalid_argument(err.to_string()),
+        err @ KsError::SeqTruncated { .. } => Status::out_of_range(err.to_string()),
+        KsError::TransactionConflict(msg) => Status::aborted(format!("Transaction conflict: {}", msg)),
+        err @ KsError::MemoryLimit { .. } => Status::resource_exhausted(err.to_string()),
+        KsError::Canceled(msg) => Status::cancelled(msg),
+        err @ KsError::ItemTooLarge { size, limit } => {
+            let mut status = Status::invalid_argument(err.to_string());
+            let metadata = status.metadata_mut();
+            metadata.insert(proto::ITEM_SIZE_METADATA, size.into());
+            metadata.insert(proto::ITEM_SIZE_LIMIT_METADATA, limit.into());
+            status
+        }
     }
 }
 
@@ -73,6 +174,60 @@ fn value_to_key_bytes(value: proto::Value) -> Result<Bytes, Status> {
     }
 }
 
+/// Build an API query from a protobuf query request
+fn build_query(req: proto::QueryRequest) -> Result<kstone_api::Query, Status> {
+    // Build query starting with partition key
+    let mut query = kstone_api::Query::new(&req.partition_key);
+
+    // Apply sort key condition if present
+    if let Some(sk_cond) = req.sort_key_condition {
+        query = apply_sort_key_condition(query, sk_cond)?;
+    }
+
+    // Apply limit
+    if let Some(limit) = req.limit {
+        query = query.limit(limit as usize);
+    }
+
+    // Apply exclusive start key for pagination
+    if let Some(start_key) = req.exclusive_start_key {
+        let (pk, sk) = proto_last_key_to_ks(start_key);
+        query = query.start_after(&pk, sk.as_deref());
+    }
+
+    // Apply scan direction
+    if let Some(forward) = req.scan_forward {
+        query = query.forward(forward);
+    }
+
+    // Apply index name
+    if let Some(index_name) = req.index_name {
+        query = query.index(index_name);
+    }
+    query = query.fetch_full_items(req.fetch_full_items);
+
+    // Apply filter expression and its bound names/values
+    if let Some(filter) = req.filter_expression {
+        query = query.filter(filter);
+    }
+    for (placeholder, proto_value) in req.expression_values {
+        let value = proto_value_to_ks(proto_value).map_err(|_| {
+            Status::invalid_argument(format!("Invalid expression value for {}", placeholder))
+        })?;
+        query = query.value(placeholder, value);
+    }
+    for (placeholder, name) in req.expression_names {
+        query = query.name(placeholder, name);
+    }
+    query = query.select(proto_select_to_ks(req.select));
+    if let Some(attribute) = req.sum_attribute {
+        query = query.sum(attribute);
+    }
+    query = query.return_consumed_capacity(req.return_consumed_capacity);
+
+    Ok(query)
+}
+
 /// Apply sort key condition to query builder
 fn apply_sort_key_condition(
     query: kstone_api::Query,
@@ -161,10 +316,34 @@ impl KeystoneDb for KeystoneService {
             req.item
                 .ok_or_else(|| Status::invalid_argument("Item required"))?,
         )?;
+        let consumed_capacity = (req.return_consumed_capacity && !req.dry_run)
+            .then(|| kstone_core::ConsumedCapacity::write(&item));
 
         // Execute put operation (blocking DB call in spawn_blocking)
         let db = Arc::clone(&self.db);
         let result = tokio::task::spawn_blocking(move || {
+            // Dry run: validate and report without persisting
+            if req.dry_run {
+                let mut context = kstone_core::expression::ExpressionContext::new();
+                for (placeholder, proto_value) in req.expression_values {
+                    let value = proto_value_to_ks(proto_value)
+                        .map_err(|_| KsError::InvalidExpression(format!("Invalid expression value for {}", placeholder)))?;
+                    context = context.with_value(placeholder, value);
+                }
+                for (placeholder, name) in req.expression_names {
+                    context = context.with_name(placeholder, name);
+                }
+
+                let outcome = db.put_dry_run(
+                    &pk,
+                    sk.as_deref(),
+                    item,
+                    req.condition_expression.as_deref(),
+                    context,
+                )?;
+                return Ok::<_, KsError>(Some(outcome));
+            }
+
             // Check if this is a conditional put
             if let Some(condition_expr) = req.condition_expression {
                 // Build expression context from expression_values
@@ -174,6 +353,9 @@ impl KeystoneDb for KeystoneService {
                         .map_err(|_| KsError::InvalidExpression(format!("Invalid expression value for {}", placeholder)))?;
                     context = context.with_value(placeholder, value);
                 }
+                for (placeholder, name) in req.expression_names {
+                    context = context.with_name(placeholder, name);
+                }
 
                 if let Some(sk_bytes) = sk {
                     db.put_conditional_with_sk(&pk, &sk_bytes, item, &condition_expr, context)?;
@@ -188,19 +370,21 @@ impl KeystoneDb for KeystoneService {
                     db.put(&pk, item)?;
                 }
             }
-            Ok::<_, KsError>(())
+            Ok(None)
         })
         .await
         .map_err(|e| Status::internal(format!("Task join error: {}", e)))?;
 
         match result {
-            Ok(_) => {
+            Ok(dry_run) => {
                 timer.observe_duration();
                 RPC_REQUESTS_TOTAL.with_label_values(&["put", "success"]).inc();
                 info!("Put operation completed successfully");
                 Ok(Response::new(proto::PutResponse {
                     success: true,
                     error: None,
+                    dry_run_result: dry_run.as_ref().map(dry_run_to_proto),
+                    consumed_capacity: consumed_capacity.map(consumed_capacity_to_proto),
                 }))
             }
             Err(e) => {
@@ -232,6 +416,7 @@ impl KeystoneDb for KeystoneService {
         });
 
         tracing::Span::current().record("has_sk", sk.is_some());
+        let return_consumed_capacity = req.return_consumed_capacity;
 
         // Execute get operation
         let db = Arc::clone(&self.db);
@@ -249,9 +434,12 @@ impl KeystoneDb for KeystoneService {
             Ok(item_opt) => {
                 tracing::Span::current().record("found", item_opt.is_some());
                 info!("Get operation completed");
+                let consumed_capacity = return_consumed_capacity
+                    .then(|| kstone_core::ConsumedCapacity::read(item_opt.as_ref()));
                 Ok(Response::new(proto::GetResponse {
                     item: item_opt.map(|item| ks_item_to_proto(&item)),
                     error: None,
+                    consumed_capacity: consumed_capacity.map(consumed_capacity_to_proto),
                 }))
             }
             Err(e) => {
@@ -291,6 +479,9 @@ impl KeystoneDb for KeystoneService {
                         .map_err(|_| KsError::InvalidExpression(format!("Invalid expression value for {}", placeholder)))?;
                     context = context.with_value(placeholder, value);
                 }
+                for (placeholder, name) in req.expression_names {
+                    context = context.with_name(placeholder, name);
+                }
 
                 if let Some(sk_bytes) = sk {
                     db.delete_conditional_with_sk(&pk, &sk_bytes, &condition_expr, context)?;
@@ -339,41 +530,7 @@ impl KeystoneDb for KeystoneService {
 
         let req = request.into_inner();
 
-        // Build query starting with partition key
-        let mut query = kstone_api::Query::new(&req.partition_key);
-
-        // Apply sort key condition if present
-        if let Some(sk_cond) = req.sort_key_condition {
-            query = apply_sort_key_condition(query, sk_cond)?;
-        }
-
-        // Apply limit
-        if let Some(limit) = req.limit {
-            query = query.limit(limit as usize);
-        }
-
-        // Apply exclusive start key for pagination
-        if let Some(start_key) = req.exclusive_start_key {
-            let (pk, sk) = proto_last_key_to_ks(start_key);
-            query = query.start_after(&pk, sk.as_deref());
-        }
-
-        // Apply scan direction
-        if let Some(forward) = req.scan_forward {
-            query = query.forward(forward);
-        }
-
-        // Apply index name
-        if let Some(index_name) = req.index_name {
-            query = query.index(index_name);
-        }
-
-        // TODO: Support filter_expression (needs expression context)
-        if req.filter_expression.is_some() {
-            return Err(Status::unimplemented(
-                "Filter expressions not yet supported in server",
-            ));
-        }
+        let query = build_query(req)?;
 
         // Execute query
         let db = Arc::clone(&self.db);
@@ -389,6 +546,8 @@ impl KeystoneDb for KeystoneService {
             scanned_count: response.scanned_count as u32,
             last_evaluated_key: ks_last_key_opt_to_proto(response.last_key),
             error: None,
+            consumed_capacity: response.consumed_capacity.map(consumed_capacity_to_proto),
+            sum: response.sum.as_ref().map(ks_value_to_proto),
         }))
     }
 
@@ -427,12 +586,24 @@ impl KeystoneDb for KeystoneService {
             scan = scan.segment(segment as usize, total_segments as usize);
         }
 
-        // TODO: Support filter_expression (needs expression context)
-        if req.filter_expression.is_some() {
-            return Err(Status::unimplemented(
-                "Filter expressions not yet supported in server",
-            ));
+        // Apply filter expression and its bound names/values
+        if let Some(filter) = req.filter_expression {
+            scan = scan.filter(filter);
+        }
+        for (placeholder, proto_value) in req.expression_values {
+            let value = proto_value_to_ks(proto_value).map_err(|_| {
+                Status::invalid_argument(format!("Invalid expression value for {}", placeholder))
+            })?;
+            scan = scan.value(placeholder, value);
         }
+        for (placeholder, name) in req.expression_names {
+            scan = scan.name(placeholder, name);
+        }
+        scan = scan.select(proto_select_to_ks(req.select));
+        if let Some(attribute) = req.sum_attribute {
+            scan = scan.sum(attribute);
+        }
+        scan = scan.return_consumed_capacity(req.return_consumed_capacity);
 
         // TODO: Support index_name for GSI/LSI
         if req.index_name.is_some() {
@@ -455,6 +626,8 @@ impl KeystoneDb for KeystoneService {
             scanned_count: response.scanned_count as u32,
             last_evaluated_key: ks_last_key_opt_to_proto(response.last_key),
             error: None,
+            consumed_capacity: response.consumed_capacity.map(consumed_capacity_to_proto),
+            sum: response.sum.as_ref().map(ks_value_to_proto),
         };
 
         // Return as a single-item stream
@@ -462,6 +635,146 @@ impl KeystoneDb for KeystoneService {
         Ok(Response::new(stream))
     }
 
+    /// Live query (streaming response)
+    type QueryLiveStream = futures::channel::mpsc::Receiver<Result<proto::QueryLiveResponse, Status>>;
+
+    /// Send the query's current results, then push new matching items
+    ///
+    /// New items are pushed by the thread shared by all live queries (see
+    /// `live`) until the client cancels or disconnects. Fails with
+    /// `resource_exhausted` once the server's live query limit is reached.
+    #[instrument(skip(self, request), fields(trace_id))]
+    async fn query_live(
+        &self,
+        request: Request<proto::QueryRequest>,
+    ) -> Result<Response<Self::QueryLiveStream>, Status> {
+        // Generate trace ID for request correlation
+        let trace_id = Uuid::new_v4().to_string();
+        tracing::Span::current().record("trace_id", &trace_id);
+
+        let query = build_query(request.into_inner())?;
+        self.live_queries.check_capacity()?;
+
+        let db = Arc::clone(&self.db);
+        let mut live = tokio::task::spawn_blocking(move || db.query_live(query))
+            .await
+            .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
+            .map_err(map_error)?;
+
+        // A fresh channel always has room for the initial results
+        let (mut tx, rx) = futures::channel::mpsc::channel(16);
+        let initial = proto::QueryLiveResponse {
+            items: live.initial_items().iter().map(ks_item_to_proto).collect(),
+            initial: true,
+        };
+        tx.try_send(Ok(initial))
+            .map_err(|e| Status::internal(format!("Failed to queue initial results: {}", e)))?;
+        self.live_queries.register(&self.db, live, tx)?;
+
+        Ok(Response::new(rx))
+    }
+
+    /// Export (server streaming)
+    type ExportStream = futures::channel::mpsc::Receiver<Result<proto::ExportChunk, Status>>;
+
+    /// Stream items with their keys, a chunk at a time, in key order
+    ///
+    /// Each chunk is read as it is sent, so an export is not a point-in-time
+    /// view of the table. A client that loses the stream resumes by sending
+    /// the last key it received as `exclusive_start_key`.
+    #[instrument(skip(self, request), fields(trace_id))]
+    async fn export(
+        &self,
+        request: Request<proto::ExportRequest>,
+    ) -> Result<Response<Self::ExportStream>, Status> {
+        // Generate trace ID for request correlation
+        let trace_id = Uuid::new_v4().to_string();
+        tracing::Span::current().record("trace_id", &trace_id);
+
+        let req = request.into_inner();
+        let chunk_size = match req.chunk_size {
+            Some(0) => return Err(Status::invalid_argument("chunk_size must be greater than zero")),
+            Some(size) => size as usize,
+            None => DEFAULT_EXPORT_CHUNK_SIZE,
+        };
+        let start = req.range_start.map(proto_last_key_to_core_key);
+        let end = req.range_end.map(proto_last_key_to_core_key);
+        let mut after = req.exclusive_start_key.map(proto_last_key_to_core_key);
+
+        let db = Arc::clone(&self.db);
+        let (mut tx, rx) = futures::channel::mpsc::channel(EXPORT_BUFFER);
+        tokio::task::spawn_blocking(move || {
+            use futures::SinkExt;
+
+            loop {
+                let page = match db.export_page(start.clone(), end.clone(), after.clone(), chunk_size) {
+                    Ok(page) => page,
+                    Err(e) => {
+                        let _ = futures::executor::block_on(tx.send(Err(map_error(e))));
+                        return;
+                    }
+                };
+                let Some((last, _)) = page.last() else {
+                    return;
+                };
+                after = Some(last.clone());
+
+                let entries: Vec<proto::ExportEntry> =
+                    page.iter().map(|(key, item)| export_entry_to_proto(key, item)).collect();
+                let chunk = proto::ExportChunk {
+                    checksum: export_checksum(&entries),
+                    entries,
+                };
+                if futures::executor::block_on(tx.send(Ok(chunk))).is_err() {
+                    return;
+                }
+            }
+        });
+
+        Ok(Response::new(rx))
+    }
+
+    /// Write stream (bidirectional streaming)
+    type WriteStreamStream = futures::channel::mpsc::Receiver<Result<proto::WriteStreamAck, Status>>;
+
+    /// Apply writes as they arrive on the stream and ack each one
+    ///
+    /// Writes are applied in order by one async task per stream, each
+    /// through the put or delete handler; the next write is not read until
+    /// the current one is acked. A failed write is reported in its ack and
+    /// the stream carries on; the stream ends once the client closes its
+    /// side and every write has been acked.
+    #[instrument(skip(self, request), fields(trace_id))]
+    async fn write_stream(
+        &self,
+        request: Request<tonic::Streaming<proto::WriteStreamRequest>>,
+    ) -> Result<Response<Self::WriteStreamStream>, Status> {
+        // Generate trace ID for request correlation
+        let trace_id = Uuid::new_v4().to_string();
+        tracing::Span::current().record("trace_id", &trace_id);
+
+        let mut inbound = request.into_inner();
+        let (mut tx, rx) = futures::channel::mpsc::channel(WRITE_STREAM_BUFFER);
+        let service = self.clone();
+        tokio::spawn(async move {
+            use futures::SinkExt;
+
+            loop {
+                let message = match inbound.message().await {
+                    Ok(Some(write)) => Ok(service.apply_stream_write(write).await),
+                    Ok(None) => return,
+                    Err(status) => Err(status),
+                };
+                let last = message.is_err();
+                if tx.send(message).await.is_err() || last {
+                    return;
+                }
+            }
+        });
+
+        Ok(Response::new(rx))
+    }
+
     /// Batch get multiple items
     #[instrument(skip(self, request), fields(trace_id))]
     async fn batch_get(
@@ -540,7 +853,23 @@ impl KeystoneDb for KeystoneService {
                             .ok_or_else(|| Status::invalid_argument("Item required for put"))?,
                     )?;
 
-                    if let Some(sk_bytes) = sk {
+                    if let Some(condition_expr) = put_item.condition_expression {
+                        let mut context = kstone_core::expression::ExpressionContext::new();
+                        for (placeholder, proto_value) in put_item.expression_values {
+                            let value = proto_value_to_ks(proto_value).map_err(|_| {
+                                Status::invalid_argument(format!("Invalid expression value for {}", placeholder))
+                            })?;
+                            context = context.with_value(placeholder, value);
+                        }
+                        for (placeholder, name) in put_item.expression_names {
+                            context = context.with_name(placeholder, name);
+                        }
+
+                        batch_request = match sk {
+                            Some(sk_bytes) => batch_request.put_conditional_with_sk(&pk, &sk_bytes, item, &condition_expr, context),
+                            None => batch_request.put_conditional(&pk, item, &condition_expr, context),
+                        };
+                    } else if let Some(sk_bytes) = sk {
                         batch_request = batch_request.put_with_sk(&pk, &sk_bytes, item);
                     } else {
                         batch_request = batch_request.put(&pk, item);
@@ -563,7 +892,7 @@ impl KeystoneDb for KeystoneService {
 
         // Execute batch write
         let db = Arc::clone(&self.db);
-        tokio::task::spawn_blocking(move || db.batch_write(batch_request))
+        let response = tokio::task::spawn_blocking(move || db.batch_write(batch_request))
             .await
             .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
             .map_err(map_error)?;
@@ -571,9 +900,32 @@ impl KeystoneDb for KeystoneService {
         Ok(Response::new(proto::BatchWriteResponse {
             success: true,
             error: None,
+            rejected: response.rejected.into_iter().map(|index| index as u32).collect(),
         }))
     }
 
+    /// Delete every item a query matches
+    #[instrument(skip(self, request), fields(trace_id))]
+    async fn delete_by_query(
+        &self,
+        request: Request<proto::QueryRequest>,
+    ) -> Result<Response<proto::DeleteByQueryResponse>, Status> {
+        // Generate trace ID for request correlation
+        let trace_id = Uuid::new_v4().to_string();
+        tracing::Span::current().record("trace_id", &trace_id);
+
+        let query = build_query(request.into_inner())?;
+
+        let db = Arc::clone(&self.db);
+        let deleted_count = tokio::task::spawn_blocking(move || db.delete_by_query(query))
+            .await
+            .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
+            .map_err(map_error)?;
+
+        info!("Delete by query deleted {} items", deleted_count);
+        Ok(Response::new(proto::DeleteByQueryResponse { deleted_count }))
+    }
+
     /// Transactional get
     #[instrument(skip(self, request), fields(trace_id))]
     async fn transact_get(
@@ -596,6 +948,21 @@ impl KeystoneDb for KeystoneService {
                 transact_request = transact_request.get(&core_key.pk);
             }
         }
+        for check in req.condition_checks {
+            transact_request = match check.sort_key {
+                Some(sk) => transact_request.condition_check_with_sk(&check.partition_key, &sk, check.condition_expression),
+                None => transact_request.condition_check(&check.partition_key, check.condition_expression),
+            };
+        }
+        for (placeholder, proto_value) in req.expression_values {
+            let value = proto_value_to_ks(proto_value).map_err(|_| {
+                Status::invalid_argument(format!("Invalid expression value for {}", placeholder))
+            })?;
+            transact_request = transact_request.value(placeholder, value);
+        }
+        for (placeholder, name) in req.expression_names {
+            transact_request = transact_request.name(placeholder, name);
+        }
 
         // Execute transactional get
         let db = Arc::clone(&self.db);
@@ -633,6 +1000,13 @@ impl KeystoneDb for KeystoneService {
 
         let req = request.into_inner();
 
+        // Fingerprint the operations so a reused token can be checked
+        let token = req.client_request_token.clone();
+        if let Some(token) = &token {
+            validate_token(token)?;
+        }
+        let fingerprint = fingerprint(&req.items);
+
         // Build transact write request with all operations
         let mut transact_request = kstone_api::TransactWriteRequest::new();
 
@@ -718,17 +1092,63 @@ impl KeystoneDb for KeystoneService {
             }
         }
 
+        // Dry run: evaluate every operation without persisting
+        if req.dry_run {
+            let db = Arc::clone(&self.db);
+            let outcome = tokio::task::spawn_blocking(move || db.transact_write_dry_run(transact_request))
+                .await
+                .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
+                .map_err(map_error)?;
+
+            return Ok(Response::new(proto::TransactWriteResponse {
+                success: outcome.would_commit,
+                error: None,
+                dry_run_results: outcome.results.iter().map(dry_run_to_proto).collect(),
+                cancellation_reasons: Vec::new(),
+            }));
+        }
+
+        // A token-bearing transaction is applied at most once: the token is
+        // reserved while it runs, so a concurrent retry is turned away
+        if let Some(token) = &token {
+            if let Some(previous) = self.idempotency.begin(token, &fingerprint).await? {
+                info!("Duplicate transaction token {}, returning previous result", token);
+                return Ok(Response::new(previous));
+            }
+        }
+
         // Execute transactional write
         let db = Arc::clone(&self.db);
-        tokio::task::spawn_blocking(move || db.transact_write(transact_request))
+        let outcome = tokio::task::spawn_blocking(move || db.try_transact_write(transact_request))
             .await
-            .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
-            .map_err(map_error)?;
+            .map_err(|e| Status::internal(format!("Task join error: {}", e)))
+            .and_then(|result| result.map_err(map_error));
+
+        // A canceled transaction is reported in the response body so the
+        // client gets the per-item reasons
+        let response = outcome.map(|outcome| match outcome {
+            kstone_api::TransactWriteOutcome::Committed(_) => proto::TransactWriteResponse {
+                success: true,
+                error: None,
+                dry_run_results: Vec::new(),
+                cancellation_reasons: Vec::new(),
+            },
+            kstone_api::TransactWriteOutcome::Canceled(reasons) => proto::TransactWriteResponse {
+                success: false,
+                error: Some("Transaction canceled".to_string()),
+                dry_run_results: Vec::new(),
+                cancellation_reasons: reasons.iter().map(cancellation_reason_to_proto).collect(),
+            },
+        });
 
-        Ok(Response::new(proto::TransactWriteResponse {
-            success: true,
-            error: None,
-        }))
+        // Only commits are remembered; a canceled or failed transaction
+        // wrote nothing and may be retried with the same token
+        if let Some(token) = &token {
+            let committed = response.as_ref().ok().filter(|response| response.success).cloned();
+            self.idempotency.finish(token, committed).await;
+        }
+
+        Ok(Response::new(response?))
     }
 
     /// Update an item
@@ -764,6 +1184,26 @@ impl KeystoneDb for KeystoneService {
             update = update.value(placeholder, value);
         }
 
+        // Add expression names
+        for (placeholder, name) in req.expression_names {
+            update = update.name(placeholder, name);
+        }
+
+        // Dry run: compute the post-image without persisting
+        if req.dry_run {
+            let db = Arc::clone(&self.db);
+            let outcome = tokio::task::spawn_blocking(move || db.update_dry_run(update))
+                .await
+                .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
+                .map_err(map_error)?;
+
+            return Ok(Response::new(proto::UpdateResponse {
+                item: outcome.item.as_ref().map(ks_item_to_proto),
+                error: None,
+                dry_run_result: Some(dry_run_to_proto(&outcome)),
+            }));
+        }
+
         // Execute update
         let db = Arc::clone(&self.db);
         let response = tokio::task::spawn_blocking(move || db.update(update))
@@ -774,6 +1214,7 @@ impl KeystoneDb for KeystoneService {
         Ok(Response::new(proto::UpdateResponse {
             item: Some(ks_item_to_proto(&response.item)),
             error: None,
+            dry_run_result: None,
         }))
     }
 
@@ -791,13 +1232,23 @@ impl KeystoneDb for KeystoneService {
 
         let req = request.into_inner();
 
-        // Execute the statement
+        let params = req
+            .parameters
+            .into_iter()
+            .map(proto_value_to_ks)
+            .collect::<Result<Vec<_>, Status>>()?;
+
+        // Execute the statement, parsing it only if it isn't cached
         let db = Arc::clone(&self.db);
+        let statements = Arc::clone(&self.statements);
         let statement = req.statement;
-        let response = tokio::task::spawn_blocking(move || db.execute_statement(&statement))
-            .await
-            .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
-            .map_err(map_error)?;
+        let response = tokio::task::spawn_blocking(move || {
+            let prepared = statements.prepare(&db, &statement)?;
+            db.execute_prepared(&prepared, &params)
+        })
+        .await
+        .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
+        .map_err(map_error)?;
 
         // Convert response based on statement type
         let proto_response = match response {
@@ -830,4 +1281,55 @@ impl KeystoneDb for KeystoneService {
             error: None,
         }))
     }
+
+    /// Explain how a PartiQL statement would be executed
+    #[instrument(skip(self, request), fields(trace_id))]
+    async fn explain(
+        &self,
+        request: Request<proto::ExplainRequest>,
+    ) -> Result<Response<proto::ExplainResponse>, Status> {
+        // Generate trace ID for request correlation
+        let trace_id = Uuid::new_v4().to_string();
+        tracing::Span::current().record("trace_id", &trace_id);
+
+        let req = request.into_inner();
+
+        let db = Arc::clone(&self.db);
+        let statement = req.statement;
+        let plan = tokio::task::spawn_blocking(move || db.explain_statement(&statement))
+            .await
+            .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
+            .map_err(map_error)?;
+
+        Ok(Response::new(proto::ExplainResponse {
+            operation: plan_operation_to_proto(plan.operation) as i32,
+            index_name: plan.index_name,
+            estimated_items: plan.estimated_items,
+        }))
+    }
+
+    /// Parse a PartiQL statement and keep it for later executions
+    #[instrument(skip(self, request), fields(trace_id))]
+    async fn prepare(
+        &self,
+        request: Request<proto::PrepareRequest>,
+    ) -> Result<Response<proto::PrepareResponse>, Status> {
+        // Generate trace ID for request correlation
+        let trace_id = Uuid::new_v4().to_string();
+        tracing::Span::current().record("trace_id", &trace_id);
+
+        let req = request.into_inner();
+
+        let db = Arc::clone(&self.db);
+        let statements = Arc::clone(&self.statements);
+        let statement = req.statement;
+        let prepared = tokio::task::spawn_blocking(move || statements.prepare(&db, &statement))
+            .await
+            .map_err(|e| Status::internal(format!("Task join error: {}", e)))?
+            .map_err(map_error)?;
+
+        Ok(Response::new(proto::PrepareResponse {
+            parameter_count: prepared.parameter_count() as u32,
+        }))
+    }
 }
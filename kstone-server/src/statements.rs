@@ -0,0 +1,140 @@
+/// Parsed PartiQL statements, cached by their SQL text
+///
+/// ExecuteStatement and Prepare look statements up here, so a client that
+/// runs the same text over and over (typically a prepared statement with
+/// `?` parameters) has it parsed only once. Once the cache is full, the
+/// least recently used statement makes room for a new one.
+
+use kstone_api::{Database, PreparedStatement};
+use kstone_core::Result;
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+
+/// Statements kept by default
+pub const DEFAULT_STATEMENT_CACHE_CAPACITY: usize = 1024;
+
+struct Entry {
+    statement: Arc<PreparedStatement>,
+    /// Tick of the latest lookup, for least-recently-used eviction
+    last_used: u64,
+}
+
+struct Entries {
+    by_sql: HashMap<String, Entry>,
+    clock: u64,
+}
+
+/// Cache of parsed statements shared by all requests
+pub struct StatementCache {
+    capacity: usize,
+    entries: Mutex<Entries>,
+}
+
+impl StatementCache {
+    /// Create a cache holding up to `capacity` statements (0 disables it)
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            capacity,
+            entries: Mutex::new(Entries {
+                by_sql: HashMap::new(),
+                clock: 0,
+            }),
+        }
+    }
+
+    /// The parsed form of `sql`, parsing it on a miss
+    ///
+    /// Statements that fail to parse are not cached.
+    pub fn prepare(&self, db: &Database, sql: &str) -> Result<Arc<PreparedStatement>> {
+        {
+            let mut entries = self.entries.lock().unwrap();
+            let entries = &mut *entries;
+            entries.clock += 1;
+            if let Some(entry) = entries.by_sql.get_mut(sql) {
+                entry.last_used = entries.clock;
+                return Ok(Arc::clone(&entry.statement));
+            }
+        }
+
+        // Parse without holding the lock
+        let statement = Arc::new(db.prepare(sql)?);
+        if self.capacity == 0 {
+            return Ok(statement);
+        }
+
+        let mut entries = self.entries.lock().unwrap();
+        let entries = &mut *entries;
+        if entries.by_sql.len() >= self.capacity && !entries.by_sql.contains_key(sql) {
+            let oldest = entries
+                .by_sql
+                .iter()
+                .min_by_key(|(_, entry)| entry.last_used)
+                .map(|(sql, _)| sql.clone());
+            if let Some(oldest) = oldest {
+                entries.by_sql.remove(&oldest);
+            }
+        }
+        entries.clock += 1;
+        entries.by_sql.insert(
+            sql.to_string(),
+            Entry {
+                statement: Arc::clone(&statement),
+                last_used: entries.clock,
+            },
+        );
+        Ok(statement)
+    }
+
+    /// Number of cached statements
+    pub fn len(&self) -> usize {
+        self.entries.lock().unwrap().by_sql.len()
+    }
+
+    /// Whether no statement is cached
+    pub fn is_empty(&self) -> bool {
+        self.len() == 0
+    }
+}
+
+impl Default for StatementCache {
+    fn default() -> Self {
+        Self::new(DEFAULT_STATEMENT_CACHE_CAPACITY)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_statements_are_parsed_once() {
+        let db = Database::create_in_memory().unwrap();
+        let cache = StatementCache::default();
+
+        let first = cache.prepare(&db, "SELECT * FROM users WHERE pk = ?").unwrap();
+        let second = cache.prepare(&db, "SELECT * FROM users WHERE pk = ?").unwrap();
+        assert!(Arc::ptr_eq(&first, &second));
+        assert_eq!(first.parameter_count(), 1);
+
+        assert!(cache.prepare(&db, "NOT SQL").is_err());
+        assert_eq!(cache.len(), 1);
+    }
+
+    #[test]
+    fn test_least_recently_used_is_evicted() {
+        let db = Database::create_in_memory().unwrap();
+        let cache = StatementCache::new(2);
+        let a = "SELECT * FROM t WHERE pk = 'a'";
+        let b = "SELECT * FROM t WHERE pk = 'b'";
+        let c = "SELECT * FROM t WHERE pk = 'c'";
+
+        let first_a = cache.prepare(&db, a).unwrap();
+        cache.prepare(&db, b).unwrap();
+        cache.prepare(&db, a).unwrap();
+        // b is now the least recently used
+        cache.prepare(&db, c).unwrap();
+        assert_eq!(cache.len(), 2);
+
+        assert!(Arc::ptr_eq(&first_a, &cache.prepare(&db, a).unwrap()));
+    }
+}
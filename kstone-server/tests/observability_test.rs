@@ -72,6 +72,9 @@ async fn test_metrics_collection_on_operations() {
         item: Some(Item { attributes }),
         condition_expression: None,
         expression_values: HashMap::new(),
+        expression_names: HashMap::new(),
+        dry_run: false,
+        return_consumed_capacity: false,
     });
 
     // Call the put method directly (simulating gRPC call)
@@ -99,6 +102,7 @@ async fn test_rpc_operations_complete() {
     let get_request = tonic::Request::new(GetRequest {
         partition_key: b"nonexistent".to_vec(),
         sort_key: None,
+        return_consumed_capacity: false,
     });
 
     // Call the get method
@@ -137,6 +141,9 @@ async fn test_trace_id_in_spans() {
         item: Some(Item { attributes }),
         condition_expression: None,
         expression_values: HashMap::new(),
+        expression_names: HashMap::new(),
+        dry_run: false,
+        return_consumed_capacity: false,
     });
 
     use kstone_proto::keystone_db_server::KeystoneDb;
@@ -164,6 +171,9 @@ async fn test_validation_errors() {
         item: None,  // Missing item should cause error
         condition_expression: None,
         expression_values: HashMap::new(),
+        expression_names: HashMap::new(),
+        dry_run: false,
+        return_consumed_capacity: false,
     });
 
     use kstone_proto::keystone_db_server::KeystoneDb;
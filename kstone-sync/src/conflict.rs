@@ -273,6 +273,39 @@ pub trait ConflictResolver: Send + Sync {
     fn name(&self) -> &str;
 }
 
+/// Built-in resolver keeping the version with the later timestamp
+///
+/// Ties go to the local version.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct LastWriterWins;
+
+impl ConflictResolver for LastWriterWins {
+    fn resolve(&self, conflict: &Conflict) -> Result<ConflictResolution> {
+        Ok(conflict.resolve_last_writer_wins())
+    }
+
+    fn name(&self) -> &str {
+        "last-writer-wins"
+    }
+}
+
+/// Built-in resolver merging both versions attribute by attribute
+///
+/// Attributes present on one side only are kept; for attributes present on
+/// both, the version with the later timestamp wins.
+#[derive(Debug, Clone, Copy, Default)]
+pub struct MergeAttributes;
+
+impl ConflictResolver for MergeAttributes {
+    fn resolve(&self, conflict: &Conflict) -> Result<ConflictResolution> {
+        Ok(conflict.resolve_attribute_merge())
+    }
+
+    fn name(&self) -> &str {
+        "merge-attributes"
+    }
+}
+
 /// Manager for tracking and resolving conflicts
 pub struct ConflictManager {
     /// Pending conflicts
@@ -280,7 +313,7 @@ pub struct ConflictManager {
     /// Resolved conflicts (kept for history)
     resolved: parking_lot::RwLock<Vec<Conflict>>,
     /// Custom resolvers
-    resolvers: HashMap<String, Box<dyn ConflictResolver>>,
+    resolvers: parking_lot::RwLock<HashMap<String, Box<dyn ConflictResolver>>>,
     /// Default strategy
     default_strategy: ConflictStrategy,
     /// Maximum resolved conflicts to keep
@@ -293,15 +326,18 @@ impl ConflictManager {
         Self {
             pending: parking_lot::RwLock::new(HashMap::new()),
             resolved: parking_lot::RwLock::new(Vec::new()),
-            resolvers: HashMap::new(),
+            resolvers: parking_lot::RwLock::new(HashMap::new()),
             default_strategy,
             max_resolved: 1000,
         }
     }
 
     /// Register a custom resolver
-    pub fn register_resolver(&mut self, resolver: Box<dyn ConflictResolver>) {
-        self.resolvers.insert(resolver.name().to_string(), resolver);
+    ///
+    /// Conflicts with strategy `ConflictStrategy::Custom(name)` are resolved
+    /// by the resolver of that name.
+    pub fn register_resolver(&self, resolver: Box<dyn ConflictResolver>) {
+        self.resolvers.write().insert(resolver.name().to_string(), resolver);
     }
 
     /// Add a new conflict
@@ -327,7 +363,7 @@ impl ConflictManager {
         if let Some(mut conflict) = pending.remove(conflict_id) {
             // Try custom resolver if specified
             if let ConflictStrategy::Custom(ref name) = conflict.strategy {
-                if let Some(resolver) = self.resolvers.get(name) {
+                if let Some(resolver) = self.resolvers.read().get(name) {
                     let resolution = resolver.resolve(&conflict)?;
                     conflict.resolution = Some(resolution.clone());
                     conflict.resolved = true;
@@ -502,4 +538,67 @@ mod tests {
             panic!("Expected merged resolution");
         }
     }
-}
\ No newline at end of file
+
+    fn concurrent_conflict(local: Item, remote: Item, strategy: ConflictStrategy) -> Conflict {
+        Conflict::new(
+            Key::new(b"key1".to_vec()),
+            Some(local),
+            Some(remote),
+            VectorClock::with_local(EndpointId::from_str("local"), 1),
+            VectorClock::with_local(EndpointId::from_str("remote"), 1),
+            100,
+            200, // Remote is newer
+            strategy,
+        )
+    }
+
+    #[test]
+    fn test_builtin_resolvers() {
+        let mut local = create_test_item("local");
+        local.insert("only_local".to_string(), Value::number(1));
+        let mut remote = create_test_item("remote");
+        remote.insert("only_remote".to_string(), Value::number(2));
+        let conflict = concurrent_conflict(local.clone(), remote.clone(), ConflictStrategy::Manual);
+        assert!(conflict.is_concurrent());
+
+        let resolution = LastWriterWins.resolve(&conflict).unwrap();
+        assert_eq!(resolution, ConflictResolution::UseRemote(Some(remote)));
+
+        let resolution = MergeAttributes.resolve(&conflict).unwrap();
+        let merged = resolution.get_item().unwrap();
+        assert_eq!(merged.len(), 3);
+        assert_eq!(merged.get("value").unwrap().as_string(), Some("remote"));
+        assert!(merged.contains_key("only_local"));
+        assert!(merged.contains_key("only_remote"));
+    }
+
+    #[test]
+    fn test_custom_resolver_registered_by_name() {
+        struct KeepLocal;
+
+        impl ConflictResolver for KeepLocal {
+            fn resolve(&self, conflict: &Conflict) -> Result<ConflictResolution> {
+                Ok(ConflictResolution::UseLocal(conflict.local_item.clone()))
+            }
+
+            fn name(&self) -> &str {
+                "keep-local"
+            }
+        }
+
+        let manager = ConflictManager::new(ConflictStrategy::LastWriterWins);
+        manager.register_resolver(Box::new(KeepLocal));
+
+        let conflict = concurrent_conflict(
+            create_test_item("local"),
+            create_test_item("remote"),
+            ConflictStrategy::Custom("keep-local".to_string()),
+        );
+        let id = manager.add_conflict(conflict).unwrap();
+        assert_eq!(manager.get_stats().pending_count, 1);
+
+        let resolution = manager.resolve_conflict(&id).unwrap();
+        assert_eq!(resolution, ConflictResolution::UseLocal(Some(create_test_item("local"))));
+        assert_eq!(manager.get_stats().pending_count, 0);
+    }
+}
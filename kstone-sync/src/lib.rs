@@ -19,8 +19,8 @@ pub mod protocol;
 pub use vector_clock::VectorClock;
 pub use merkle::{MerkleTree, MerkleNode};
 pub use change_tracker::{ChangeTracker, SyncRecord};
-pub use conflict::{ConflictStrategy, ConflictResolver, ConflictResolution, Conflict};
-pub use sync_engine::{SyncEngine, SyncConfig, SyncState, SyncEvent};
+pub use conflict::{ConflictStrategy, ConflictResolver, ConflictResolution, Conflict, LastWriterWins, MergeAttributes};
+pub use sync_engine::{SyncEngine, SyncConfig, SyncState, SyncEvent, SyncProgress, SyncStatus};
 pub use offline_queue::{OfflineQueue, PendingOperation};
 pub use metadata::{SyncMetadata, SyncMetadataStore, EndpointInfo};
 pub use protocol::{SyncProtocol, SyncEndpoint};
@@ -87,6 +87,8 @@ pub struct CloudSyncBuilder {
     db: Option<Arc<Database>>,
     endpoint: Option<SyncEndpoint>,
     conflict_strategy: ConflictStrategy,
+    conflict_resolver: Option<Box<dyn ConflictResolver>>,
+    sync_scope: Vec<Vec<u8>>,
     sync_interval: Option<std::time::Duration>,
     batch_size: usize,
     max_retries: u32,
@@ -99,6 +101,8 @@ impl CloudSyncBuilder {
             db: None,
             endpoint: None,
             conflict_strategy: ConflictStrategy::LastWriterWins,
+            conflict_resolver: None,
+            sync_scope: Vec::new(),
             sync_interval: Some(std::time::Duration::from_secs(30)),
             batch_size: 100,
             max_retries: 3,
@@ -121,6 +125,18 @@ impl CloudSyncBuilder {
         self
     }
 
+    /// Resolve conflicts with a custom resolver instead of a strategy
+    pub fn with_conflict_resolver(mut self, resolver: Box<dyn ConflictResolver>) -> Self {
+        self.conflict_resolver = Some(resolver);
+        self
+    }
+
+    /// Only sync partitions whose key starts with one of `prefixes`
+    pub fn with_sync_scope<P: AsRef<[u8]>>(mut self, prefixes: impl IntoIterator<Item = P>) -> Self {
+        self.sync_scope = prefixes.into_iter().map(|p| p.as_ref().to_vec()).collect();
+        self
+    }
+
     pub fn with_sync_interval(mut self, interval: std::time::Duration) -> Self {
         self.sync_interval = Some(interval);
         self
@@ -159,7 +175,11 @@ impl CloudSyncBuilder {
             enable_compression: self.enable_compression,
         };
 
-        SyncEngine::new(db, config)
+        let engine = SyncEngine::new(db, config)?.with_sync_scope(self.sync_scope);
+        Ok(match self.conflict_resolver {
+            Some(resolver) => engine.with_conflict_resolver(resolver),
+            None => engine,
+        })
     }
 }
 
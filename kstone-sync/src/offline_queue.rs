@@ -353,6 +353,45 @@ impl OfflineQueue {
         self.failed.write().clear();
     }
 
+    /// Every operation not yet delivered: queued, in flight and failed
+    pub fn operations(&self) -> Vec<PendingOperation> {
+        let mut operations: Vec<_> = self.queue.read().iter().cloned().collect();
+        operations.extend(self.processing.read().iter().cloned());
+        operations.extend(self.failed.read().iter().cloned());
+        operations
+    }
+
+    /// Move every queued and failed operation to processing, ignoring
+    /// backoff and pause, for a forced delivery attempt
+    pub fn take_all(&self) -> Vec<PendingOperation> {
+        let mut queue = self.queue.write();
+        let mut processing = self.processing.write();
+        let mut failed = self.failed.write();
+
+        let batch: Vec<_> = queue.drain(..).chain(failed.drain(..)).collect();
+        processing.extend(batch.iter().cloned());
+        batch
+    }
+
+    /// Drop operations by ID, wherever they are; returns how many were found
+    pub fn discard(&self, operation_ids: &[&str]) -> usize {
+        let matches = |op: &PendingOperation| operation_ids.contains(&op.id.as_str());
+        let mut discarded = 0;
+
+        let mut queue = self.queue.write();
+        let before = queue.len();
+        queue.retain(|op| !matches(op));
+        discarded += before - queue.len();
+
+        for list in [&self.processing, &self.failed] {
+            let mut list = list.write();
+            let before = list.len();
+            list.retain(|op| !matches(op));
+            discarded += before - list.len();
+        }
+        discarded
+    }
+
     /// Get failed operations
     pub fn get_failed(&self) -> Vec<PendingOperation> {
         self.failed.read().clone()
@@ -15,12 +15,12 @@ use tokio::sync::mpsc;
 use tokio::time;
 
 use kstone_api::Database;
-use kstone_core::{Item, Key, stream::StreamRecord};
+use kstone_core::{item_size, Item, Key, stream::StreamRecord};
 
 use crate::{
     EndpointId, VectorClock, SyncOrigin, SyncStats,
     change_tracker::{ChangeTracker, SyncRecord},
-    conflict::{ConflictManager, ConflictStrategy, Conflict},
+    conflict::{ConflictManager, ConflictResolution, ConflictResolver, ConflictStrategy, Conflict},
     merkle::MerkleTree,
     metadata::{SyncMetadata, SyncMetadataStore, SyncCheckpoint},
     offline_queue::{OfflineQueue, PendingOperation, RetryPolicy},
@@ -93,6 +93,62 @@ pub enum SyncEvent {
     },
 }
 
+/// Progress of the running sync
+#[derive(Debug, Clone, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SyncProgress {
+    /// Keys that differ between the endpoints
+    pub total: usize,
+    /// Keys transferred so far
+    pub done: usize,
+    /// Items pushed so far
+    pub sent: usize,
+    /// Items pulled so far
+    pub received: usize,
+    /// Keys still to pull
+    pub pending_pull: usize,
+    /// Approximate item bytes pushed so far (see `item_size`)
+    pub bytes_sent: u64,
+    /// Approximate item bytes pulled so far
+    pub bytes_received: u64,
+}
+
+impl SyncProgress {
+    /// Share of the keys transferred, 0-100
+    pub fn percent(&self) -> u8 {
+        if self.total == 0 {
+            return 100;
+        }
+        (self.done.min(self.total) * 100 / self.total) as u8
+    }
+}
+
+/// Snapshot of the engine's sync status
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct SyncStatus {
+    /// Current phase
+    pub state: SyncState,
+    /// Local changes not yet pushed
+    pub pending_push: usize,
+    /// Remote changes not yet pulled by the running sync
+    pub pending_pull: usize,
+    /// When the last successful sync finished (ms since epoch)
+    pub last_sync_time: Option<i64>,
+    /// Item bytes pushed over all syncs
+    pub bytes_sent: u64,
+    /// Item bytes pulled over all syncs
+    pub bytes_received: u64,
+}
+
+impl SyncStatus {
+    /// Whether no sync is running
+    pub fn is_idle(&self) -> bool {
+        matches!(
+            self.state,
+            SyncState::Idle | SyncState::Completed | SyncState::Error(_)
+        )
+    }
+}
+
 /// Sync configuration
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct SyncConfig {
@@ -134,6 +190,12 @@ pub struct SyncEngine {
     event_rx: Option<mpsc::UnboundedReceiver<SyncEvent>>,
     /// Shutdown signal
     shutdown_tx: Option<mpsc::Sender<()>>,
+    /// Progress of the running sync
+    progress: Arc<RwLock<SyncProgress>>,
+    /// Receivers of progress updates for the running sync
+    progress_subscribers: Arc<parking_lot::Mutex<Vec<mpsc::UnboundedSender<SyncProgress>>>>,
+    /// Partition key prefixes to sync; empty syncs everything
+    scope: Arc<Vec<Bytes>>,
 }
 
 impl SyncEngine {
@@ -173,9 +235,44 @@ impl SyncEngine {
             event_tx,
             event_rx: Some(event_rx),
             shutdown_tx: None,
+            progress: Arc::new(RwLock::new(SyncProgress::default())),
+            progress_subscribers: Arc::new(parking_lot::Mutex::new(Vec::new())),
+            scope: Arc::new(Vec::new()),
         })
     }
 
+    /// Resolve conflicting writes with a custom resolver
+    ///
+    /// Replaces the configured conflict strategy: concurrent local and
+    /// remote writes to the same key are passed to `resolver`, and its
+    /// resolution is written to the local database. `LastWriterWins` and
+    /// `MergeAttributes` are provided.
+    pub fn with_conflict_resolver(mut self, resolver: Box<dyn ConflictResolver>) -> Self {
+        self.config.conflict_strategy = ConflictStrategy::Custom(resolver.name().to_string());
+        self.conflict_manager.register_resolver(resolver);
+        self
+    }
+
+    /// Only sync partitions whose key starts with one of `prefixes`
+    ///
+    /// Items in other partitions are neither pushed nor pulled, and are left
+    /// out of the Merkle diff, so local-only data such as caches never
+    /// leaves the device. An empty list syncs everything.
+    pub fn with_sync_scope<P: AsRef<[u8]>>(mut self, prefixes: impl IntoIterator<Item = P>) -> Self {
+        self.scope = Arc::new(
+            prefixes
+                .into_iter()
+                .map(|p| Bytes::copy_from_slice(p.as_ref()))
+                .collect(),
+        );
+        self
+    }
+
+    /// Whether partition `pk` is within the sync scope
+    fn in_scope(&self, pk: &[u8]) -> bool {
+        self.scope.is_empty() || self.scope.iter().any(|prefix| pk.starts_with(prefix))
+    }
+
     /// Start the sync engine with automatic syncing
     pub async fn start(&mut self) -> Result<()> {
         if let Some(interval) = self.config.sync_interval {
@@ -207,10 +304,13 @@ impl SyncEngine {
     }
 
     /// Stop the sync engine
+    ///
+    /// Closes any open `progress` channels.
     pub async fn stop(&mut self) -> Result<()> {
         if let Some(tx) = self.shutdown_tx.take() {
             let _ = tx.send(()).await;
         }
+        self.progress_subscribers.lock().clear();
         Ok(())
     }
 
@@ -261,6 +361,12 @@ impl SyncEngine {
 
     /// Perform a single sync operation with a specific endpoint
     pub async fn sync(&self, endpoint: SyncEndpoint) -> Result<SyncSessionStats> {
+        let result = self.sync_endpoint(endpoint).await;
+        self.finish_progress();
+        result
+    }
+
+    async fn sync_endpoint(&self, endpoint: SyncEndpoint) -> Result<SyncSessionStats> {
         // Temporarily use the provided endpoint for this sync
         let mut protocol = self.create_protocol_for_endpoint(&endpoint).await?;
 
@@ -347,6 +453,12 @@ impl SyncEngine {
 
     /// Perform a single sync operation
     pub async fn sync_once(&self) -> Result<()> {
+        let result = self.sync_configured().await;
+        self.finish_progress();
+        result
+    }
+
+    async fn sync_configured(&self) -> Result<()> {
         self.set_state(SyncState::Connecting);
         self.emit_event(SyncEvent::Started {
             endpoint_id: self.config.endpoint.endpoint_id(),
@@ -438,7 +550,7 @@ impl SyncEngine {
         // Scan the database to get items with their keys
         let records = self.db.scan_with_keys(10000)?;
 
-        for (key, item) in records {
+        for (key, item) in records.into_iter().filter(|(key, _)| self.in_scope(&key.pk)) {
             let key_bytes = key.encode();
             let value_bytes = serde_json::to_vec(&item)?;
             local_items.push((key_bytes, Bytes::from(value_bytes)));
@@ -447,7 +559,8 @@ impl SyncEngine {
         let local_tree = MerkleTree::build(local_items, 16)?;
 
         if let Some(root) = local_tree.root.as_ref() {
-            let diffs = protocol.exchange_merkle(root).await?;
+            let mut diffs = protocol.exchange_merkle(root).await?;
+            diffs.retain(|(key, _)| self.in_scope(&key.pk));
             Ok(diffs)
         } else {
             Ok(Vec::new())
@@ -464,6 +577,15 @@ impl SyncEngine {
         let mut sent = 0;
         let mut received = 0;
 
+        *self.progress.write() = SyncProgress {
+            total: changes.len(),
+            pending_pull: changes
+                .iter()
+                .filter(|(_, diff_type)| !matches!(diff_type, DiffType::LocalOnly))
+                .count(),
+            ..Default::default()
+        };
+
         for chunk in changes.chunks(self.config.batch_size) {
             let mut to_pull = Vec::new();
             let mut to_push = Vec::new();
@@ -513,10 +635,18 @@ impl SyncEngine {
 
             // Pull items from remote
             if !to_pull.is_empty() {
+                let requested = to_pull.len();
                 let pulled = protocol.pull_items(to_pull).await?;
                 received += pulled.len();
                 stats.items_received += pulled.len();
 
+                let bytes: usize = pulled.iter().filter_map(|(_, item, _)| item.as_ref()).map(item_size).sum();
+                stats.bytes_received += bytes;
+                let mut progress = self.progress.write();
+                progress.pending_pull = progress.pending_pull.saturating_sub(requested);
+                progress.bytes_received += bytes as u64;
+                drop(progress);
+
                 // Process pulled items
                 for (key, item, remote_clock) in pulled {
                     self.process_remote_item(key, item, remote_clock).await?;
@@ -525,9 +655,12 @@ impl SyncEngine {
 
             // Push items to remote
             if !to_push.is_empty() {
+                let bytes: usize = to_push.iter().filter_map(|(_, item, _)| item.as_ref()).map(item_size).sum();
                 let pushed = protocol.push_items(to_push.clone()).await?;
                 sent += pushed.len();
                 stats.items_sent += pushed.len();
+                stats.bytes_sent += bytes;
+                self.progress.write().bytes_sent += bytes as u64;
             }
 
             // Update progress
@@ -542,8 +675,24 @@ impl SyncEngine {
                 received,
                 total: changes.len(),
             });
+
+            let progress = {
+                let mut progress = self.progress.write();
+                progress.done += chunk.len();
+                progress.sent = sent;
+                progress.received = received;
+                progress.clone()
+            };
+            self.progress_subscribers
+                .lock()
+                .retain(|subscriber| subscriber.send(progress.clone()).is_ok());
         }
 
+        let mut metadata = self.metadata.write();
+        metadata.stats.bytes_sent += stats.bytes_sent as u64;
+        metadata.stats.bytes_received += stats.bytes_received as u64;
+        drop(metadata);
+
         Ok(stats)
     }
 
@@ -584,40 +733,53 @@ impl SyncEngine {
             });
         } else {
             // No conflict, apply remote change
-            match remote_item {
-                Some(item) => {
-                    if let Some(ref sk) = key.sk {
-                        self.db.put_with_sk(&key.pk, sk, item)?
-                    } else {
-                        self.db.put(&key.pk, item)?
-                    }
+            self.write_local(&key, remote_item)?;
+        }
+
+        Ok(())
+    }
+
+    /// Write an item to the local database, or delete it if None
+    fn write_local(&self, key: &Key, item: Option<Item>) -> Result<()> {
+        match item {
+            Some(item) => {
+                if let Some(ref sk) = key.sk {
+                    self.db.put_with_sk(&key.pk, sk, item)?
+                } else {
+                    self.db.put(&key.pk, item)?
                 }
-                None => {
-                    if let Some(ref sk) = key.sk {
-                        self.db.delete_with_sk(&key.pk, sk)?
-                    } else {
-                        self.db.delete(&key.pk)?
-                    }
+            }
+            None => {
+                if let Some(ref sk) = key.sk {
+                    self.db.delete_with_sk(&key.pk, sk)?
+                } else {
+                    self.db.delete(&key.pk)?
                 }
             }
         }
-
         Ok(())
     }
 
     /// Resolve pending conflicts
     async fn resolve_conflicts(&self) -> Result<()> {
-        let results = self.conflict_manager.resolve_all();
-
-        for (conflict_id, result) in results {
-            if let Ok(resolution) = result {
-                self.emit_event(SyncEvent::ConflictResolved {
-                    conflict_id: conflict_id.clone(),
-                });
-
-                // Apply resolution
-                // This would write to the database based on the resolution
+        for conflict in self.conflict_manager.get_pending() {
+            let resolution = match self.conflict_manager.resolve_conflict(&conflict.id) {
+                Ok(resolution) => resolution,
+                Err(_) => continue,
+            };
+
+            // The local version is already in place
+            match resolution {
+                ConflictResolution::UseRemote(item) | ConflictResolution::Merged(item) => {
+                    self.write_local(&conflict.key, item)?;
+                }
+                ConflictResolution::UseLocal(_) => {}
+                ConflictResolution::Deferred => continue,
             }
+
+            self.emit_event(SyncEvent::ConflictResolved {
+                conflict_id: conflict.id,
+            });
         }
 
         Ok(())
@@ -759,6 +921,110 @@ impl SyncEngine {
         self.state.read().clone()
     }
 
+    /// Queue an operation for delivery to the configured endpoint
+    ///
+    /// Queued operations are delivered by `flush_queue`.
+    pub fn enqueue(&self, operation: PendingOperation) -> Result<()> {
+        self.offline_queue.enqueue(operation)
+    }
+
+    /// Operations awaiting delivery, including ones that exhausted their retries
+    pub fn queued_operations(&self) -> Vec<PendingOperation> {
+        self.offline_queue.operations()
+    }
+
+    /// Attempt to deliver every queued operation now
+    ///
+    /// Backoff and pause are ignored. Delivered operations leave the queue;
+    /// ones that fail are requeued with their retry count increased and the
+    /// first error is returned. Returns the number delivered.
+    pub async fn flush_queue(&self) -> Result<usize> {
+        let operations = self.offline_queue.take_all();
+        if operations.is_empty() {
+            return Ok(0);
+        }
+
+        let mut protocol = self.create_protocol().await?;
+        if let Err(e) = protocol.connect().await {
+            for op in &operations {
+                self.offline_queue.mark_failed(&op.id, e.to_string())?;
+            }
+            return Err(e);
+        }
+
+        let mut delivered = 0;
+        let mut first_error = None;
+        for op in operations {
+            let items = op
+                .keys
+                .iter()
+                .cloned()
+                .zip(op.items.iter().cloned())
+                .map(|(key, item)| (key, item, op.vector_clock.clone()))
+                .collect();
+            match protocol.push_items(items).await {
+                Ok(_) => {
+                    self.offline_queue.mark_completed(&op.id)?;
+                    delivered += 1;
+                }
+                Err(e) => {
+                    self.offline_queue.mark_failed(&op.id, e.to_string())?;
+                    first_error.get_or_insert(e);
+                }
+            }
+        }
+
+        protocol.disconnect().await?;
+        match first_error {
+            Some(e) => Err(e),
+            None => Ok(delivered),
+        }
+    }
+
+    /// Drop queued operations by ID; returns how many were found
+    ///
+    /// For operations that can never be delivered.
+    pub fn discard_queued(&self, operation_ids: &[&str]) -> usize {
+        self.offline_queue.discard(operation_ids)
+    }
+
+    /// Current sync status: phase, pending work and totals
+    pub fn status(&self) -> SyncStatus {
+        let metadata = self.metadata.read();
+        let last_sync_time = metadata
+            .last_sync_times
+            .values()
+            .copied()
+            .chain(metadata.stats.last_sync_time)
+            .max();
+
+        SyncStatus {
+            state: self.get_state(),
+            pending_push: self.change_tracker.get_stats().needs_sync + self.offline_queue.get_stats().pending,
+            pending_pull: self.progress.read().pending_pull,
+            last_sync_time,
+            bytes_sent: metadata.stats.bytes_sent,
+            bytes_received: metadata.stats.bytes_received,
+        }
+    }
+
+    /// Receive progress updates for the running (or next) sync
+    ///
+    /// An update is sent after each transferred batch. The channel closes
+    /// when that sync finishes, successfully or not, or when the engine is
+    /// stopped; call again for the following sync.
+    pub fn progress(&self) -> mpsc::UnboundedReceiver<SyncProgress> {
+        let (tx, rx) = mpsc::unbounded_channel();
+        self.progress_subscribers.lock().push(tx);
+        rx
+    }
+
+    /// Close the progress channels of the sync that just ended
+    fn finish_progress(&self) {
+        self.progress_subscribers.lock().clear();
+        *self.progress.write() = SyncProgress::default();
+    }
+
     /// Get sync statistics
     pub fn get_stats(&self) -> SyncStats {
         self.metadata.read().stats.clone()
@@ -785,6 +1051,9 @@ impl SyncEngine {
             event_tx,
             event_rx: None,
             shutdown_tx: None,
+            progress: self.progress.clone(),
+            progress_subscribers: self.progress_subscribers.clone(),
+            scope: self.scope.clone(),
         }
     }
 }
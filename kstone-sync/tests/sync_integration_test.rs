@@ -322,4 +322,124 @@ fn test_sync_configuration() {
 
     // Verify it builds without error
     let _sync_engine = builder.build().unwrap();
-}
\ No newline at end of file
+}
+#[tokio::test]
+async fn test_sync_progress_and_status() -> Result<()> {
+    let (db1, _dir1) = create_test_database("db1")?;
+    let (_db2, dir2) = create_test_database("db2")?;
+
+    let sync_engine = CloudSyncBuilder::new()
+        .with_database(db1.clone())
+        .with_endpoint(SyncEndpoint::FileSystem {
+            path: dir2.path().to_string_lossy().to_string(),
+        })
+        .with_batch_size(1)
+        .build()?;
+
+    let mut progress = sync_engine.progress();
+    sync_engine.sync_once().await?;
+
+    // The channel closes once the sync has finished
+    let mut updates = Vec::new();
+    while let Some(update) = progress.recv().await {
+        updates.push(update);
+    }
+    assert!(!updates.is_empty());
+    assert!(updates.windows(2).all(|w| w[0].done < w[1].done));
+
+    let last = updates.last().unwrap();
+    assert_eq!(last.percent(), 100);
+    assert_eq!(last.pending_pull, 0);
+    assert!(last.bytes_sent + last.bytes_received > 0);
+
+    let status = sync_engine.status();
+    assert!(status.is_idle());
+    assert_eq!(status.pending_pull, 0);
+    assert!(status.last_sync_time.is_some());
+    assert_eq!(status.bytes_sent, last.bytes_sent);
+    assert_eq!(status.bytes_received, last.bytes_received);
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_offline_queue_flush_and_discard() -> Result<()> {
+    use kstone_core::Key;
+    use kstone_sync::{EndpointId, PendingOperation, VectorClock};
+
+    let (db1, _dir1) = create_test_database("db1")?;
+    let remote_dir = TempDir::new()?;
+    let remote_path = remote_dir.path().join("remote");
+
+    let sync_engine = CloudSyncBuilder::new()
+        .with_database(db1.clone())
+        .with_endpoint(SyncEndpoint::FileSystem {
+            path: remote_path.to_string_lossy().to_string(),
+        })
+        .build()?;
+    let endpoint = EndpointId::from_str("remote");
+
+    // Queue while the remote does not exist yet ("offline")
+    for i in 1..=2 {
+        let item = ItemBuilder::new().number("value", i).build();
+        let key = Key::new(format!("queued#{}", i).into_bytes());
+        sync_engine.enqueue(PendingOperation::put(endpoint.clone(), key, item, VectorClock::new()))?;
+    }
+    let poison = PendingOperation::delete(endpoint.clone(), Key::new(b"queued#3".to_vec()), VectorClock::new());
+    let poison_id = poison.id.clone();
+    sync_engine.enqueue(poison)?;
+    assert_eq!(sync_engine.queued_operations().len(), 3);
+
+    assert!(sync_engine.flush_queue().await.is_err());
+    let queued = sync_engine.queued_operations();
+    assert_eq!(queued.len(), 3);
+    assert!(queued.iter().all(|op| op.retry_count == 1 && op.last_error.is_some()));
+
+    assert_eq!(sync_engine.discard_queued(&[poison_id.as_str()]), 1);
+    assert_eq!(sync_engine.queued_operations().len(), 2);
+
+    // Back online
+    drop(Database::create(&remote_path)?);
+    assert_eq!(sync_engine.flush_queue().await?, 2);
+    assert!(sync_engine.queued_operations().is_empty());
+
+    let remote = Database::open(&remote_path)?;
+    assert!(remote.get(b"queued#1")?.is_some());
+    assert!(remote.get(b"queued#2")?.is_some());
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_sync_scope_keeps_other_partitions_local() -> Result<()> {
+    let local_dir = TempDir::new()?;
+    let local = Arc::new(Database::create(local_dir.path())?);
+    local.put(b"user#1", ItemBuilder::new().string("name", "Alice").build())?;
+    local.put(b"cache#1", ItemBuilder::new().string("blob", "local").build())?;
+
+    let remote_dir = TempDir::new()?;
+    {
+        let remote = Database::create(remote_dir.path())?;
+        remote.put(b"user#2", ItemBuilder::new().string("name", "Bob").build())?;
+        remote.put(b"cache#2", ItemBuilder::new().string("blob", "remote").build())?;
+        remote.flush()?;
+    }
+
+    let sync_engine = CloudSyncBuilder::new()
+        .with_database(local.clone())
+        .with_endpoint(SyncEndpoint::FileSystem {
+            path: remote_dir.path().to_string_lossy().to_string(),
+        })
+        .with_sync_scope(["user#"])
+        .build()?;
+    sync_engine.sync_once().await?;
+
+    assert!(local.get(b"user#2")?.is_some());
+    assert!(local.get(b"cache#2")?.is_none());
+
+    let remote = Database::open(remote_dir.path())?;
+    assert!(remote.get(b"user#1")?.is_some());
+    assert!(remote.get(b"cache#1")?.is_none());
+
+    Ok(())
+}
@@ -307,6 +307,172 @@ fn bench_in_memory(c: &mut Criterion) {
     group.finish();
 }
 
+fn bench_exists_vs_get(c: &mut Criterion) {
+    let mut group = c.benchmark_group("exists_vs_get");
+
+    // Setup: one item with a large value
+    let dir = TempDir::new().unwrap();
+    let db = Database::create(dir.path()).unwrap();
+    let item = ItemBuilder::new()
+        .string("data", "x".repeat(256 * 1024))
+        .build();
+    db.put(b"large", item).unwrap();
+
+    group.throughput(Throughput::Elements(1));
+    group.bench_function("get", |b| {
+        b.iter(|| {
+            let _result = db.get(black_box(b"large")).unwrap();
+        });
+    });
+    group.bench_function("exists", |b| {
+        b.iter(|| {
+            let _result = db.exists(black_box(b"large")).unwrap();
+        });
+    });
+    group.finish();
+}
+
+fn bench_group_commit(c: &mut Criterion) {
+    use kstone_api::DatabaseConfig;
+    use std::sync::atomic::{AtomicU64, Ordering};
+    use std::time::Duration;
+
+    const WRITERS: u64 = 16;
+    const PUTS_PER_WRITER: u64 = 50;
+
+    let mut group = c.benchmark_group("group_commit");
+    group.sample_size(10);
+
+    for window_ms in [0u64, 2] {
+        group.throughput(Throughput::Elements(WRITERS * PUTS_PER_WRITER));
+        group.bench_with_input(BenchmarkId::new("window_ms", window_ms), &window_ms, |b, &window_ms| {
+            let dir = TempDir::new().unwrap();
+            let config = DatabaseConfig::new().with_group_commit_window(Duration::from_millis(window_ms));
+            let db = Database::create_with_config(dir.path(), config).unwrap();
+            let item = ItemBuilder::new().string("data", "x".repeat(100)).build();
+            let counter = AtomicU64::new(0);
+
+            b.iter(|| {
+                std::thread::scope(|s| {
+                    for _ in 0..WRITERS {
+                        s.spawn(|| {
+                            for _ in 0..PUTS_PER_WRITER {
+                                let key = format!("key{}", counter.fetch_add(1, Ordering::Relaxed));
+                                db.put(black_box(key.as_bytes()), item.clone()).unwrap();
+                            }
+                        });
+                    }
+                });
+            });
+        });
+    }
+    group.finish();
+}
+
+fn bench_keys_only_vs_scan(c: &mut Criterion) {
+    use kstone_api::Scan;
+
+    let mut group = c.benchmark_group("keys_only_vs_scan");
+
+    // Setup: 2000 items with 1 KB values
+    let dir = TempDir::new().unwrap();
+    let db = Database::create(dir.path()).unwrap();
+    let item = ItemBuilder::new().string("data", "x".repeat(1024)).build();
+    for i in 0..2000 {
+        db.put(format!("key{:05}", i).as_bytes(), item.clone()).unwrap();
+    }
+
+    group.throughput(Throughput::Elements(2000));
+    group.bench_function("scan", |b| {
+        b.iter(|| {
+            let response = db.scan(black_box(Scan::new())).unwrap();
+            assert_eq!(response.count, 2000);
+        });
+    });
+    group.bench_function("keys_only", |b| {
+        b.iter(|| {
+            let keys = db.keys_only(black_box(Scan::new())).unwrap();
+            assert_eq!(keys.count(), 2000);
+        });
+    });
+    group.finish();
+}
+
+fn bench_compaction_styles(c: &mut Criterion) {
+    use kstone_api::{CompactionConfig, CompactionStyle, DatabaseConfig};
+
+    const WRITES: u64 = 20_000;
+
+    // Write-heavy load into one partition, so every flush lands in the same
+    // stripe and compaction runs often
+    let load = |style: CompactionStyle| {
+        let dir = TempDir::new().unwrap();
+        let config = DatabaseConfig::new()
+            .with_max_memtable_records(100)
+            .with_compaction(CompactionConfig::new().with_style(style));
+        let db = Database::create_with_config(dir.path(), config).unwrap();
+        let item = ItemBuilder::new().string("data", "x".repeat(100)).build();
+        for i in 0..WRITES {
+            db.put_with_sk(b"events", format!("{:08}", i).as_bytes(), item.clone())
+                .unwrap();
+        }
+        db.stats().unwrap().compaction
+    };
+
+    let mut group = c.benchmark_group("compaction_style");
+    group.sample_size(10);
+    group.throughput(Throughput::Elements(WRITES));
+
+    for (name, style) in [("leveled", CompactionStyle::Leveled), ("tiered", CompactionStyle::Tiered)] {
+        // Write amplification shows in how much compaction rewrote
+        let stats = load(style);
+        println!(
+            "compaction_style/{}: {} compactions rewrote {} bytes",
+            name, stats.total_compactions, stats.total_bytes_written
+        );
+        group.bench_function(name, |b| b.iter(|| load(style)));
+    }
+    group.finish();
+}
+
+fn bench_bulk_load(c: &mut Criterion) {
+    use kstone_api::BatchWriteRequest;
+    use kstone_core::Key;
+
+    const ITEMS: u64 = 1_000_000;
+    const BATCH: u64 = 1_000;
+
+    let item = |i: u64| ItemBuilder::new().number("index", i as i64).string("data", "x".repeat(64)).build();
+
+    let mut group = c.benchmark_group("bulk_load");
+    group.sample_size(10);
+    group.throughput(Throughput::Elements(ITEMS));
+
+    group.bench_function("batched_puts", |b| {
+        b.iter(|| {
+            let dir = TempDir::new().unwrap();
+            let db = Database::create(dir.path()).unwrap();
+            for start in (0..ITEMS).step_by(BATCH as usize) {
+                let mut batch = BatchWriteRequest::new();
+                for i in start..start + BATCH {
+                    batch = batch.put(format!("key{:08}", i).as_bytes(), item(i));
+                }
+                db.batch_write(batch).unwrap();
+            }
+        });
+    });
+
+    group.bench_function("bulk_load", |b| {
+        b.iter(|| {
+            let dir = TempDir::new().unwrap();
+            let db = Database::create(dir.path()).unwrap();
+            let items = (0..ITEMS).map(|i| (Key::new(format!("key{:08}", i).into_bytes().into()), item(i)));
+            assert_eq!(db.bulk_load(items).unwrap(), ITEMS);
+        });
+    });
+    group.finish();
+}
+
 criterion_group!(
     benches,
     bench_put_single,
@@ -318,6 +484,11 @@ criterion_group!(
     bench_recovery,
     bench_mixed_workload,
     bench_composite_keys,
-    bench_in_memory
+    bench_in_memory,
+    bench_exists_vs_get,
+    bench_group_commit,
+    bench_keys_only_vs_scan,
+    bench_compaction_styles,
+    bench_bulk_load
 );
 criterion_main!(benches);
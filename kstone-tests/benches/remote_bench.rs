@@ -0,0 +1,86 @@
+/// Performance benchmarks for remote access through kstone-server
+///
+/// Run with: cargo bench -p kstone-tests --bench remote_bench
+
+use criterion::{criterion_group, criterion_main, Criterion, Throughput};
+use kstone_api::{Database, ItemBuilder};
+use kstone_client::{Client, WriteOp};
+use kstone_server::{KeystoneDbServer, KeystoneService};
+use std::time::Duration;
+use tempfile::TempDir;
+use tokio::runtime::Runtime;
+use tonic::transport::Server;
+
+const WRITES_PER_ITERATION: u64 = 1000;
+
+/// Start a server on a free port and connect a client to it
+fn start_server(rt: &Runtime, dir: &TempDir) -> Client {
+    let db = Database::create(dir.path()).unwrap();
+    let service = KeystoneService::new(db);
+
+    let listener = std::net::TcpListener::bind("127.0.0.1:0").unwrap();
+    let addr = listener.local_addr().unwrap();
+    drop(listener);
+
+    rt.spawn(async move {
+        Server::builder()
+            .add_service(KeystoneDbServer::new(service))
+            .serve(addr)
+            .await
+            .unwrap();
+    });
+
+    rt.block_on(async {
+        tokio::time::sleep(Duration::from_millis(200)).await;
+        Client::connect(format!("http://{}", addr)).await.unwrap()
+    })
+}
+
+fn bench_write_stream_vs_unary(c: &mut Criterion) {
+    let rt = Runtime::new().unwrap();
+    let dir = TempDir::new().unwrap();
+    let mut client = start_server(&rt, &dir);
+
+    let item = ItemBuilder::new().string("data", "x".repeat(100)).build();
+
+    let mut group = c.benchmark_group("remote_writes");
+    group.throughput(Throughput::Elements(WRITES_PER_ITERATION));
+    group.sample_size(10);
+
+    group.bench_function("unary_put", |b| {
+        b.iter(|| {
+            rt.block_on(async {
+                for i in 0..WRITES_PER_ITERATION {
+                    let key = format!("unary#{}", i);
+                    client.put(key.as_bytes(), item.clone()).await.unwrap();
+                }
+            })
+        });
+    });
+
+    group.bench_function("write_stream", |b| {
+        b.iter(|| {
+            rt.block_on(async {
+                let (mut sender, mut acks) = client.write_stream().await.unwrap().into_split();
+                let item = item.clone();
+                let producer = tokio::spawn(async move {
+                    for i in 0..WRITES_PER_ITERATION {
+                        let key = format!("stream#{}", i);
+                        sender.send(WriteOp::put(key.as_bytes(), item.clone())).await.unwrap();
+                    }
+                });
+                let mut acked = 0;
+                while acked < WRITES_PER_ITERATION {
+                    acks.recv().await.unwrap().unwrap().result.unwrap();
+                    acked += 1;
+                }
+                producer.await.unwrap();
+            })
+        });
+    });
+
+    group.finish();
+}
+
+criterion_group!(benches, bench_write_stream_vs_unary);
+criterion_main!(benches);